@@ -23,8 +23,10 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/skydive-project/skydive/analyzer"
@@ -40,6 +42,8 @@ import (
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/packet_injector"
 	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/probe/replay"
+	"github.com/skydive-project/skydive/profiling"
 	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/topology/graph"
 	"github.com/skydive-project/skydive/topology/graph/traversal"
@@ -54,6 +58,7 @@ type Agent struct {
 	topologyEndpoint    *topology.TopologySubscriberEndpoint
 	rootNode            *graph.Node
 	topologyProbeBundle *probe.ProbeBundle
+	shadowProbeBundle   *probe.ProbeBundle
 	flowProbeBundle     *probe.ProbeBundle
 	flowPipeline        *flow.EnhancerPipeline
 	flowTableAllocator  *flow.TableAllocator
@@ -62,6 +67,8 @@ type Agent struct {
 	httpServer          *shttp.Server
 	tidMapper           *topology.TIDMapper
 	topologyForwarder   *TopologyForwarder
+	recorder            *replay.Recorder
+	state               int64
 }
 
 // NewAnalyzerWSStructClientPool creates a new http WebSocket client Pool
@@ -82,6 +89,7 @@ func NewAnalyzerWSStructClientPool(authOptions *shttp.AuthenticationOpts) (*shtt
 	for _, sa := range addresses {
 		authClient := shttp.NewAuthenticationClient(config.GetURL("http", sa.Addr, sa.Port, ""), authOptions)
 		c := shttp.NewWSClientFromConfig(common.AgentService, config.GetURL("ws", sa.Addr, sa.Port, "/ws/agent"), authClient, nil)
+		c.RegistrationKey = config.GetString("agent.registration.key")
 		pool.AddClient(c)
 	}
 
@@ -133,21 +141,55 @@ func (a *Agent) Start() {
 	a.flowPipeline.Start()
 	a.wsServer.Start()
 	a.topologyProbeBundle.Start()
+	a.shadowProbeBundle.Start()
 	a.flowProbeBundle.Start()
 	a.onDemandProbeServer.Start()
 
 	// everything is ready, then initiate the websocket connection
 	go a.analyzerClientPool.ConnectAll()
+
+	atomic.StoreInt64(&a.state, common.RunningState)
+}
+
+// IsStarted returns whether the agent services are started
+func (a *Agent) IsStarted() bool {
+	return atomic.LoadInt64(&a.state) == common.RunningState
+}
+
+// GetHealthChecks returns the checks of the agent subsystems
+func (a *Agent) GetHealthChecks() map[string]api.HealthCheck {
+	return map[string]api.HealthCheck{
+		"topology_probes": func() error {
+			if !a.topologyProbeBundle.IsStarted() {
+				return errors.New("Topology probes not started")
+			}
+			return nil
+		},
+		"flow_probes": func() error {
+			if !a.flowProbeBundle.IsStarted() {
+				return errors.New("Flow probes not started")
+			}
+			return nil
+		},
+		"byte_order": fprobes.CheckByteOrder,
+	}
 }
 
 // Stop agent services
 func (a *Agent) Stop() {
+	atomic.StoreInt64(&a.state, common.StoppingState)
+
+	// send the flows to the analyzers while still connected
+	a.flowTableAllocator.Flush()
 	a.flowProbeBundle.Stop()
+	a.flowClientPool.Close()
+
+	a.topologyForwarder.Shutdown()
 	a.analyzerClientPool.Stop()
+	a.shadowProbeBundle.Stop()
 	a.topologyProbeBundle.Stop()
 	a.httpServer.Stop()
 	a.wsServer.Stop()
-	a.flowClientPool.Close()
 	a.onDemandProbeServer.Stop()
 	a.flowPipeline.Stop()
 
@@ -158,6 +200,11 @@ func (a *Agent) Stop() {
 	}
 
 	a.tidMapper.Stop()
+
+	if a.recorder != nil {
+		replay.SetRecorder(nil)
+		a.recorder.Close()
+	}
 }
 
 // NewAgent instanciates a new Agent aiming to launch probes (topology and flow)
@@ -195,7 +242,32 @@ func NewAgent() (*Agent, error) {
 		return nil, err
 	}
 
-	api.RegisterTopologyAPI(hserver, g, tr)
+	// recording before the probes creation so that the trace starts with
+	// the initial state
+	var recorder *replay.Recorder
+	if path := config.GetString("agent.topology.record_trace"); path != "" {
+		if recorder, err = replay.NewFileRecorder(path); err != nil {
+			return nil, fmt.Errorf("Unable to record the probes events: %s", err)
+		}
+		replay.SetRecorder(recorder)
+	}
+
+	// the probes running in shadow mode feed a staging graph mirroring the
+	// production one, queried with G.Staging()
+	var staging *graph.Graph
+	shadowProbeBundle := probe.NewProbeBundle(make(map[string]probe.Probe))
+	if len(config.GetStringSlice("agent.topology.shadow_probes")) > 0 {
+		if staging, err = graph.NewStagingGraph(g); err != nil {
+			return nil, err
+		}
+		shadowProbeBundle = NewShadowProbeBundleFromConfig(staging, rootNode)
+	}
+	tr.AddTraversalExtension(ge.NewStagingTraversalExtension(staging))
+
+	// failures of the probes are reported on the host node
+	probe.AddDegradationHandler(probe.NewGraphDegradationHandler(g, func() *graph.Node { return rootNode }, ""))
+
+	api.RegisterTopologyAPI(hserver, g, tr, nil)
 
 	authOptions := analyzer.NewAnalyzerAuthenticationOpts()
 
@@ -230,15 +302,27 @@ func NewAgent() (*Agent, error) {
 
 	packet_injector.NewServer(g, analyzerClientPool)
 
+	profiling.NewServer(analyzerClientPool)
+
 	flowClientPool := analyzer.NewFlowClientPool(analyzerClientPool)
 
 	flowProbeBundle := fprobes.NewFlowProbeBundle(topologyProbeBundle, g, flowTableAllocator, flowClientPool)
 
+	// the capture paths share structures with the kernel, report early a
+	// build not matching the byte order of the host
+	if err := fprobes.CheckByteOrder(); err != nil {
+		logging.GetLogger().Errorf("Byte order self-test failed: %s", err)
+	}
+
 	onDemandProbeServer, err := ondemand.NewOnDemandProbeServer(flowProbeBundle, g, analyzerClientPool)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to initialize on-demand flow probe %s", err.Error())
 	}
 
+	g.Lock()
+	g.AddMetadata(rootNode, "Probes", newProbesInfo(topologyProbeBundle, flowProbeBundle))
+	g.Unlock()
+
 	agent := &Agent{
 		graph:               g,
 		wsServer:            wsServer,
@@ -246,6 +330,7 @@ func NewAgent() (*Agent, error) {
 		topologyEndpoint:    topologyEndpoint,
 		rootNode:            rootNode,
 		topologyProbeBundle: topologyProbeBundle,
+		shadowProbeBundle:   shadowProbeBundle,
 		flowProbeBundle:     flowProbeBundle,
 		flowPipeline:        pipeline,
 		flowTableAllocator:  flowTableAllocator,
@@ -254,9 +339,13 @@ func NewAgent() (*Agent, error) {
 		httpServer:          hserver,
 		tidMapper:           tm,
 		topologyForwarder:   tforwarder,
+		recorder:            recorder,
+		state:               common.StoppedState,
 	}
 
 	api.RegisterStatusAPI(hserver, agent)
+	api.RegisterHealthAPI(hserver, agent)
+	api.RegisterProfileAPI(hserver, nil)
 
 	return agent, nil
 }
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package agent
+
+import (
+	"strings"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+)
+
+// Privileges describes the privileges the agent is running with
+type Privileges struct {
+	Hardened     bool
+	Capabilities []string
+	Seccomp      string
+	NoNewPrivs   bool
+}
+
+// capabilityNames indexed by capability number
+var capabilityNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill",
+	"setgid", "setuid", "setpcap", "linux_immutable", "net_bind_service",
+	"net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+	"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct",
+	"sys_admin", "sys_boot", "sys_nice", "sys_resource", "sys_time",
+	"sys_tty_config", "mknod", "lease", "audit_write", "audit_control",
+	"setfcap", "mac_override", "mac_admin", "syslog", "wake_alarm",
+	"block_suspend", "audit_read", "perfmon", "bpf", "checkpoint_restore",
+}
+
+// capabilitySet returns the names of the capabilities of a mask
+func capabilitySet(mask uint64) []string {
+	var caps []string
+	for i, name := range capabilityNames {
+		if mask&(1<<uint(i)) != 0 {
+			caps = append(caps, name)
+		}
+	}
+	return caps
+}
+
+// requiredCapabilities returns the capabilities the agent needs according
+// to the probes enabled in the configuration. Capture sockets and network
+// namespaces are opened on demand, so the related capabilities are kept.
+func requiredCapabilities() map[string]bool {
+	caps := map[string]bool{
+		"net_raw":   true, // packet capture sockets
+		"net_admin": true, // netlink, promiscuous mode
+		"sys_admin": true, // entering network namespaces
+	}
+
+	for _, probe := range config.GetStringSlice("agent.topology.probes") {
+		switch probe {
+		case "docker", "lxd", "socketinfo":
+			// namespaces and sockets of other processes through /proc
+			caps["sys_ptrace"] = true
+			caps["dac_read_search"] = true
+		}
+	}
+
+	for _, probe := range config.GetStringSlice("agent.flow.probes") {
+		if probe == "ebpf" {
+			caps["sys_resource"] = true
+		}
+	}
+
+	if sa, err := common.ServiceAddressFromString(config.GetString("agent.listen")); err == nil && sa.Port < 1024 {
+		caps["net_bind_service"] = true
+	}
+
+	for _, c := range config.GetStringSlice("agent.hardening.capabilities") {
+		caps[strings.TrimPrefix(strings.ToLower(c), "cap_")] = true
+	}
+
+	return caps
+}
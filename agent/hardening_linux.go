@@ -0,0 +1,270 @@
+// +build linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+)
+
+const (
+	hardenedEnv = "SKYDIVE_AGENT_HARDENED"
+
+	linuxCapabilityVersion3 = 0x20080522
+
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+	seccompRetAllow        = 0x7fff0000
+	seccompRetErrno        = 0x00050000
+)
+
+// audit architectures of the seccomp data, see linux/audit.h
+var auditArchs = map[string]uint32{
+	"386":     0x40000003,
+	"amd64":   0xc000003e,
+	"arm":     0x40000028,
+	"arm64":   0xc00000b7,
+	"ppc64le": 0xc0000015,
+	"s390x":   0x80000016,
+}
+
+// syscalls denied by the seccomp profile, none of them is used by the agent
+var deniedSyscalls = []uintptr{
+	unix.SYS_ACCT,
+	unix.SYS_ADD_KEY,
+	unix.SYS_ADJTIMEX,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEYCTL,
+	unix.SYS_MOUNT,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_PTRACE,
+	unix.SYS_QUOTACTL,
+	unix.SYS_REBOOT,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_SWAPOFF,
+	unix.SYS_SWAPON,
+	unix.SYS_SYSLOG,
+	unix.SYS_UMOUNT2,
+}
+
+type capUserHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capUserData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+var hardened bool
+
+func lastCapability() int {
+	if content, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		if last, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+			return last
+		}
+	}
+	return len(capabilityNames) - 1
+}
+
+func capabilityMask(caps map[string]bool) (mask uint64) {
+	for i, name := range capabilityNames {
+		if caps[name] {
+			mask |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+// restrictCapabilities limits the capabilities of the calling thread and of
+// the programs it executes to the given mask
+func restrictCapabilities(mask uint64) error {
+	hdr := capUserHeader{version: linuxCapabilityVersion3}
+	var data [2]capUserData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("Unable to get capabilities: %s", errno)
+	}
+
+	// the bounding set limits the permitted capabilities after execve
+	for i := 0; i <= lastCapability(); i++ {
+		if mask&(1<<uint(i)) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(i), 0, 0, 0); err != nil && err != unix.EINVAL {
+			return fmt.Errorf("Unable to drop capability %d from the bounding set: %s", i, err)
+		}
+	}
+
+	// ambient capabilities are not supported by old kernels
+	unix.Prctl(prCapAmbient, prCapAmbientClearAll, 0, 0, 0)
+
+	for i := range data {
+		data[i].inheritable &= uint32(mask >> (32 * uint(i)))
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("Unable to set capabilities: %s", errno)
+	}
+
+	return nil
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// applySeccomp installs on all the threads a filter denying the syscalls
+// listed in deniedSyscalls with EPERM
+func applySeccomp() error {
+	arch, ok := auditArchs[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("Seccomp profile not available on %s", runtime.GOARCH)
+	}
+
+	n := len(deniedSyscalls)
+	filter := []unix.SockFilter{
+		// allow the syscalls of other architectures, numbers would not match
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 4),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow),
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 0),
+	}
+	for i, nr := range deniedSyscalls {
+		filter = append(filter, bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(nr), uint8(n-i), 0))
+	}
+	filter = append(filter,
+		bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetErrno|uint32(unix.EPERM)),
+	)
+
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("Unable to set no_new_privs: %s", err)
+	}
+
+	if _, _, errno := syscall.RawSyscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("Unable to apply the seccomp profile: %s", errno)
+	}
+
+	return nil
+}
+
+// Harden restricts the privileges of the agent. As capabilities are per
+// thread, the agent first restricts the capabilities of its current thread
+// then executes itself again, the new process inheriting only the required
+// capabilities on all its threads. The seccomp profile is then applied.
+func Harden() error {
+	if os.Getenv(hardenedEnv) == "" {
+		caps := requiredCapabilities()
+
+		runtime.LockOSThread()
+		if err := restrictCapabilities(capabilityMask(caps)); err != nil {
+			runtime.UnlockOSThread()
+			return err
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		logging.GetLogger().Infof("Restarting the agent with the capabilities %v", capabilitySet(capabilityMask(caps)))
+		return syscall.Exec(exe, os.Args, append(os.Environ(), hardenedEnv+"=1"))
+	}
+
+	if config.GetBool("agent.hardening.seccomp") {
+		if err := applySeccomp(); err != nil {
+			return err
+		}
+	}
+
+	hardened = true
+	return nil
+}
+
+// getPrivileges returns the privileges of the agent process
+func getPrivileges() *Privileges {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	privileges := &Privileges{Hardened: hardened, Seccomp: "disabled"}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+
+		switch fields[0] {
+		case "CapEff":
+			if mask, err := strconv.ParseUint(value, 16, 64); err == nil {
+				privileges.Capabilities = capabilitySet(mask)
+			}
+		case "NoNewPrivs":
+			privileges.NoNewPrivs = value == "1"
+		case "Seccomp":
+			switch value {
+			case "1":
+				privileges.Seccomp = "strict"
+			case "2":
+				privileges.Seccomp = "filter"
+			}
+		}
+	}
+
+	return privileges
+}
@@ -76,6 +76,10 @@ func createRootNode(g *graph.Graph) (*graph.Node, error) {
 		m.SetField("IsolatedCPU", isolated)
 	}
 
+	if privileges := getPrivileges(); privileges != nil {
+		m.SetField("Privileges", privileges)
+	}
+
 	cpuInfo, err := cpu.Info()
 	if err != nil {
 		return nil, err
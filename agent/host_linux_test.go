@@ -53,3 +53,17 @@ func TestIsolatedCPU(t *testing.T) {
 		t.Fatal("Parsing of isolated cpu should return an error")
 	}
 }
+
+func TestCapabilitySet(t *testing.T) {
+	// CapEff of a process with net_admin, net_raw and sys_admin
+	caps := capabilitySet(0x0000000000203000)
+
+	expected := []string{"net_admin", "net_raw", "sys_admin"}
+	if !reflect.DeepEqual(caps, expected) {
+		t.Fatalf("Expected %v, got %v", expected, caps)
+	}
+
+	if mask := capabilityMask(map[string]bool{"net_admin": true, "net_raw": true, "sys_admin": true}); mask != 0x203000 {
+		t.Fatalf("Expected mask 0x203000, got %x", mask)
+	}
+}
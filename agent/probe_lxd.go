@@ -0,0 +1,44 @@
+// +build !nolxd
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package agent
+
+import (
+	"errors"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/probes/lxd"
+	"github.com/skydive-project/skydive/topology/probes/netns"
+)
+
+func init() {
+	registerTopologyProbe("lxd", func(g *graph.Graph, n *graph.Node, nsProbe *netns.NetNSProbe) (probe.Probe, error) {
+		if nsProbe == nil {
+			return nil, errors.New("the lxd probe requires the netns probe")
+		}
+		return lxd.NewLxdProbe(nsProbe, config.GetString("lxd.url"))
+	})
+}
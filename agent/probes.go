@@ -24,21 +24,59 @@ package agent
 
 import (
 	"runtime"
+	"sort"
 
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/probe"
 	"github.com/skydive-project/skydive/topology/graph"
-	"github.com/skydive-project/skydive/topology/probes/docker"
-	"github.com/skydive-project/skydive/topology/probes/lxd"
 	"github.com/skydive-project/skydive/topology/probes/netlink"
 	"github.com/skydive-project/skydive/topology/probes/netns"
-	"github.com/skydive-project/skydive/topology/probes/neutron"
-	"github.com/skydive-project/skydive/topology/probes/opencontrail"
-	"github.com/skydive-project/skydive/topology/probes/ovsdb"
-	"github.com/skydive-project/skydive/topology/probes/socketinfo"
 )
 
+// topologyProbeConstructor creates a topology probe of the agent, nsProbe being
+// nil on other platforms than Linux and for the probes running in shadow mode
+type topologyProbeConstructor func(g *graph.Graph, n *graph.Node, nsProbe *netns.NetNSProbe) (probe.Probe, error)
+
+var topologyProbes = make(map[string]topologyProbeConstructor)
+
+// registerTopologyProbe makes a topology probe available to the agent. Each
+// probe registers itself from a file excluded by the no<name> build tag, so
+// that agents without the probe dependencies can be built.
+func registerTopologyProbe(name string, constructor topologyProbeConstructor) {
+	topologyProbes[name] = constructor
+}
+
+// ProbesInfo describes the probes of the agent, reported in the Probes
+// metadata of the host node
+type ProbesInfo struct {
+	Compiled []string
+	Topology []string
+	Flow     []string
+}
+
+// newProbesInfo returns the probes compiled in and the active ones
+func newProbesInfo(topologyBundle, flowBundle *probe.ProbeBundle) *ProbesInfo {
+	info := &ProbesInfo{
+		Compiled: CompiledTopologyProbes(),
+		Topology: topologyBundle.ActiveProbes(),
+		Flow:     flowBundle.ActiveProbes(),
+	}
+	sort.Strings(info.Topology)
+	sort.Strings(info.Flow)
+	return info
+}
+
+// CompiledTopologyProbes returns the names of the topology probes compiled in
+func CompiledTopologyProbes() []string {
+	names := make([]string, 0, len(topologyProbes))
+	for name := range topologyProbes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // NewTopologyProbeBundleFromConfig creates a new topology probe.ProbeBundle based on the configuration
 func NewTopologyProbeBundleFromConfig(g *graph.Graph, n *graph.Node) (*probe.ProbeBundle, error) {
 	list := config.GetStringSlice("agent.topology.probes")
@@ -67,43 +105,52 @@ func NewTopologyProbeBundleFromConfig(g *graph.Graph, n *graph.Node) (*probe.Pro
 			continue
 		}
 
-		switch t {
-		case "ovsdb":
-			probes[t] = ovsdb.NewOvsdbProbeFromConfig(g, n)
-		case "lxd":
-			lxdURL := config.GetConfig().GetString("lxd.url")
-			lxdProbe, err := lxd.NewLxdProbe(nsProbe, lxdURL)
-			if err != nil {
-				return nil, err
-			}
-			probes[t] = lxdProbe
-		case "docker":
-			dockerURL := config.GetString("docker.url")
-			dockerProbe, err := docker.NewDockerProbe(nsProbe, dockerURL)
-			if err != nil {
-				return nil, err
-			}
-			probes[t] = dockerProbe
-		case "neutron":
-			neutron, err := neutron.NewNeutronProbeFromConfig(g)
-			if err != nil {
-				logging.GetLogger().Errorf("Failed to initialize Neutron probe: %s", err.Error())
-				return nil, err
-			}
-			probes["neutron"] = neutron
-		case "opencontrail":
-			opencontrail, err := opencontrail.NewOpenContrailProbeFromConfig(g, n)
-			if err != nil {
-				logging.GetLogger().Errorf("Failed to initialize OpenContrail probe: %s", err.Error())
-				return nil, err
-			}
-			probes[t] = opencontrail
-		case "socketinfo":
-			probes[t] = socketinfo.NewSocketInfoProbe(g, n)
-		default:
-			logging.GetLogger().Errorf("unknown probe type %s", t)
+		constructor, ok := topologyProbes[t]
+		if !ok {
+			logging.GetLogger().Errorf("unknown probe type %s or not compiled in, available probes: %v", t, CompiledTopologyProbes())
+			continue
+		}
+
+		p, err := constructor(g, n, nsProbe)
+		if err != nil {
+			logging.GetLogger().Errorf("Failed to initialize %s probe: %s", t, err.Error())
+			return nil, err
 		}
+		probes[t] = p
 	}
 
 	return bundle, nil
 }
+
+// NewShadowProbeBundleFromConfig creates the bundle of the probes running in
+// shadow mode, their output going to the staging graph instead of the
+// production one. A probe failing to initialize is only reported, to not
+// prevent the agent from starting.
+func NewShadowProbeBundleFromConfig(staging *graph.Graph, n *graph.Node) *probe.ProbeBundle {
+	list := config.GetStringSlice("agent.topology.shadow_probes")
+	logging.GetLogger().Infof("Shadow topology probes: %v", list)
+
+	probes := make(map[string]probe.Probe)
+	bundle := probe.NewProbeBundle(probes)
+
+	staging.RLock()
+	root := staging.GetNode(n.ID)
+	staging.RUnlock()
+
+	for _, t := range list {
+		constructor, ok := topologyProbes[t]
+		if !ok {
+			logging.GetLogger().Errorf("unknown shadow probe type %s or not compiled in, available probes: %v", t, CompiledTopologyProbes())
+			continue
+		}
+
+		p, err := constructor(staging, root, nil)
+		if err != nil {
+			logging.GetLogger().Errorf("Failed to initialize %s shadow probe: %s", t, err.Error())
+			continue
+		}
+		probes[t] = p
+	}
+
+	return bundle
+}
@@ -23,6 +23,9 @@
 package agent
 
 import (
+	"time"
+
+	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
@@ -31,24 +34,121 @@ import (
 
 // TopologyForwarder forwards the topology to only one master server.
 // When switching from one analyzer to another one the agent does a full
-// re-sync since some messages could have been lost.
+// re-sync since some messages could have been lost. If acknowledgements are
+// enabled, the messages not acknowledged by the analyzer are retransmitted
+// instead when the session with the analyzer is resumed.
 type TopologyForwarder struct {
+	common.RWMutex
 	masterElection *shttp.WSMasterElection
+	pool           shttp.WSStructSpeakerPool
 	graph          *graph.Graph
 	host           string
+	acks           bool
+	ackWindow      int
+	sequence       int64
+	unacked        []pendingMessage
+	overflow       bool
+}
+
+// pendingMessage is a message waiting for an acknowledgement
+type pendingMessage struct {
+	sequence int64
+	data     shttp.WSRawMessage
+}
+
+// send forwards a graph message to the master, keeping it until acknowledged
+// when acknowledgements are enabled
+func (t *TopologyForwarder) send(msg *shttp.WSStructMessage) {
+	if !t.acks {
+		t.masterElection.SendMessageToMaster(msg)
+		return
+	}
+
+	t.Lock()
+	t.sequence++
+	msg.Sequence = t.sequence
+	data := shttp.WSRawMessage(msg.Bytes(shttp.ProtobufProtocol))
+
+	if len(t.unacked) >= t.ackWindow {
+		// too many messages not acknowledged, a full re-sync will be required
+		t.unacked = nil
+		t.overflow = true
+	}
+	if !t.overflow {
+		t.unacked = append(t.unacked, pendingMessage{sequence: t.sequence, data: data})
+	}
+	t.Unlock()
+
+	t.masterElection.SendMessageToMaster(data)
+}
+
+// retransmit sends the messages not acknowledged to the given speaker. It
+// returns false if messages were dropped and a re-sync is needed.
+func (t *TopologyForwarder) retransmit(c shttp.WSSpeaker) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.overflow {
+		return false
+	}
+
+	logging.GetLogger().Infof("Retransmitting %d messages not acknowledged", len(t.unacked))
+	for _, m := range t.unacked {
+		if err := c.SendMessage(m.data); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *TopologyForwarder) acknowledge(sequence int64) {
+	t.Lock()
+	defer t.Unlock()
+
+	i := 0
+	for i < len(t.unacked) && t.unacked[i].sequence <= sequence {
+		i++
+	}
+	t.unacked = t.unacked[i:]
 }
 
-func (t *TopologyForwarder) triggerResync() {
+// OnWSStructMessage handles the acknowledgements sent by the analyzer
+func (t *TopologyForwarder) OnWSStructMessage(c shttp.WSSpeaker, msg *shttp.WSStructMessage) {
+	msgType, obj, err := graph.UnmarshalWSMessage(msg)
+	if err != nil {
+		logging.GetLogger().Errorf("Graph: Unable to parse the event %v: %s", msg, err.Error())
+		return
+	}
+
+	switch msgType {
+	case graph.AckMsgType:
+		t.acknowledge(obj.(*graph.AckMsg).Sequence)
+	case graph.NackMsgType:
+		logging.GetLogger().Warningf("Analyzer lost messages after %d, re-syncing", obj.(*graph.AckMsg).Sequence)
+		t.triggerResync(true)
+	}
+}
+
+func (t *TopologyForwarder) triggerResync(resumed bool) {
 	logging.GetLogger().Infof("Start a re-sync for %s", t.host)
 
 	t.graph.RLock()
 	defer t.graph.RUnlock()
 
-	// request for deletion of everything belonging this host
-	t.masterElection.SendMessageToMaster(shttp.NewWSStructMessage(graph.Namespace, graph.HostGraphDeletedMsgType, t.host))
+	// the re-sync supersedes the messages not acknowledged
+	t.Lock()
+	t.unacked = nil
+	t.overflow = false
+	t.Unlock()
+
+	// request for deletion of everything belonging this host, when the session
+	// was resumed the analyzer still has our graph and only applies the differences
+	if !resumed {
+		t.send(shttp.NewWSStructMessage(graph.Namespace, graph.HostGraphDeletedMsgType, t.host))
+	}
 
 	// re-add all the nodes and edges
-	t.masterElection.SendMessageToMaster(shttp.NewWSStructMessage(graph.Namespace, graph.SyncMsgType, t.graph))
+	t.send(shttp.NewWSStructMessage(graph.Namespace, graph.SyncMsgType, t.graph))
 }
 
 // OnNewMaster is called by the master election mechanism when a new master is elected. In
@@ -59,38 +159,57 @@ func (t *TopologyForwarder) OnNewMaster(c shttp.WSSpeaker) {
 	} else {
 		addr, port := c.GetAddrPort()
 		logging.GetLogger().Infof("Using %s:%d as master of topology forwarder", addr, port)
-		t.triggerResync()
+		resumed := c.GetStatus().Resumed
+		if t.acks && resumed && t.retransmit(c) {
+			return
+		}
+		t.triggerResync(resumed)
 	}
 }
 
 // OnNodeUpdated graph node updated event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnNodeUpdated(n *graph.Node) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSStructMessage(graph.Namespace, graph.NodeUpdatedMsgType, n))
+	t.send(shttp.NewWSStructMessage(graph.Namespace, graph.NodeUpdatedMsgType, n))
 }
 
 // OnNodeAdded graph node added event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnNodeAdded(n *graph.Node) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSStructMessage(graph.Namespace, graph.NodeAddedMsgType, n))
+	t.send(shttp.NewWSStructMessage(graph.Namespace, graph.NodeAddedMsgType, n))
 }
 
 // OnNodeDeleted graph node deleted event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnNodeDeleted(n *graph.Node) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSStructMessage(graph.Namespace, graph.NodeDeletedMsgType, n))
+	t.send(shttp.NewWSStructMessage(graph.Namespace, graph.NodeDeletedMsgType, n))
 }
 
 // OnEdgeUpdated graph edge updated event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnEdgeUpdated(e *graph.Edge) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSStructMessage(graph.Namespace, graph.EdgeUpdatedMsgType, e))
+	t.send(shttp.NewWSStructMessage(graph.Namespace, graph.EdgeUpdatedMsgType, e))
 }
 
 // OnEdgeAdded graph edge added event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnEdgeAdded(e *graph.Edge) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSStructMessage(graph.Namespace, graph.EdgeAddedMsgType, e))
+	t.send(shttp.NewWSStructMessage(graph.Namespace, graph.EdgeAddedMsgType, e))
 }
 
 // OnEdgeDeleted graph edge deleted event. Implements the GraphEventListener interface.
 func (t *TopologyForwarder) OnEdgeDeleted(e *graph.Edge) {
-	t.masterElection.SendMessageToMaster(shttp.NewWSStructMessage(graph.Namespace, graph.EdgeDeletedMsgType, e))
+	t.send(shttp.NewWSStructMessage(graph.Namespace, graph.EdgeDeletedMsgType, e))
+}
+
+// Shutdown notifies the analyzers that the agent is being stopped cleanly so
+// that they don't consider the upcoming disconnection as a crash
+func (t *TopologyForwarder) Shutdown() {
+	msg := &graph.HostGraphShutdownMsg{
+		Host: t.host,
+		Time: common.UnixMillis(time.Now()),
+	}
+
+	// send directly to the speakers, not using the broadcast queue of the pool
+	// as it is about to be stopped
+	for _, speaker := range t.pool.GetSpeakers() {
+		speaker.SendMessage(shttp.NewWSStructMessage(graph.Namespace, graph.HostGraphShutdownMsgType, msg))
+	}
 }
 
 // GetMaster returns the current analyzer the agent is sending its events to
@@ -103,13 +222,25 @@ func (t *TopologyForwarder) GetMaster() shttp.WSSpeaker {
 func NewTopologyForwarder(host string, g *graph.Graph, pool shttp.WSStructSpeakerPool) *TopologyForwarder {
 	masterElection := shttp.NewWSMasterElection(pool)
 
+	acks, ackWindow := config.GetBool("agent.topology.acks.enabled"), config.GetInt("agent.topology.acks.window")
+
+	// an edge agent keeps the messages sent during the outages of its WAN link
+	// so that they are replayed, with their timestamps, once the session is resumed
+	if config.GetBool("agent.edge.enabled") {
+		acks, ackWindow = true, config.GetInt("agent.edge.topology_buffer")
+	}
+
 	t := &TopologyForwarder{
 		masterElection: masterElection,
+		pool:           pool,
 		graph:          g,
 		host:           host,
+		acks:           acks,
+		ackWindow:      ackWindow,
 	}
 
 	masterElection.AddEventHandler(t)
+	pool.AddStructMessageHandler(t, []string{graph.Namespace})
 	g.AddEventListener(t)
 
 	return t
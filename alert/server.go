@@ -27,21 +27,28 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"net/http"
 	"os/exec"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
+	etcdclient "github.com/coreos/etcd/client"
 	"github.com/robertkrimen/otto"
 	api "github.com/skydive-project/skydive/api/server"
 	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/flow/storage"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/topology/graph"
 	"github.com/skydive-project/skydive/topology/graph/traversal"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -49,26 +56,130 @@ const (
 	Namespace = "Alert"
 )
 
+const (
+	// ProbeDegradationAlertID is the ID of the built-in probe degradation alert
+	ProbeDegradationAlertID = "probe-degradation"
+	// LoopAlertID is the ID of the built-in layer2 loop alert
+	LoopAlertID = "l2-loop"
+	// ThreatIntelAlertID is the ID of the built-in threat intelligence alert
+	ThreatIntelAlertID = "threat-intel"
+)
+
+// notificationsPath is the etcd directory holding the digest of the data
+// of the last notification of each alert, shared by the analyzers
+const notificationsPath = "/alert-notifications/"
+
 const (
 	actionWebHook = 1 + iota
 	actionScript
 )
 
+// alertClause is one of the expressions of an alert, the alert expression
+// itself being the first one
+type alertClause struct {
+	expression        string
+	hold              time.Duration
+	pendingSince      time.Time
+	traversalSequence *traversal.GremlinTraversalSequence
+}
+
 type GremlinAlert struct {
 	*types.Alert
-	graph             *graph.Graph
-	lastEval          interface{}
-	kind              int
-	data              string
-	traversalSequence *traversal.GremlinTraversalSequence
-	gremlinParser     *traversal.GremlinTraversalParser
+	graph         *graph.Graph
+	lastEval      interface{}
+	notified      bool
+	pendingSince  time.Time
+	hold          time.Duration
+	kind          int
+	data          string
+	clauses       []*alertClause
+	gremlinParser *traversal.GremlinTraversalParser
+	holdLock      sync.Mutex
+	holdTimer     *time.Timer
+	holdStopped   bool
 }
 
+// Evaluate returns the data matched by the alert, nil if it does not match.
+// The clauses of a composite alert are evaluated against the same state of
+// the graph, the alert matching if all of them, or one of them for the OR
+// operator, are satisfied. The data is then the list of the clause results.
 func (ga *GremlinAlert) Evaluate(lockGraph bool) (interface{}, error) {
+	if len(ga.clauses) == 1 {
+		return ga.evaluateClause(ga.clauses[0], lockGraph)
+	}
+
+	if lockGraph {
+		ga.graph.RLock()
+		defer ga.graph.RUnlock()
+	}
+
+	now := time.Now()
+	results := make([]interface{}, len(ga.clauses))
+	satisfied := 0
+	for i, c := range ga.clauses {
+		data, err := ga.evaluateClause(c, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if data == nil {
+			c.pendingSince = time.Time{}
+			continue
+		}
+
+		// a clause is satisfied once it matched during its whole For duration
+		if c.pendingSince.IsZero() {
+			c.pendingSince = now
+		}
+		if now.Sub(c.pendingSince) >= c.hold {
+			results[i] = data
+			satisfied++
+		}
+	}
+
+	if satisfied == len(ga.clauses) || (ga.Operator == "OR" && satisfied > 0) {
+		return results, nil
+	}
+
+	return nil, nil
+}
+
+// holdDeadline returns when the For duration of the pending condition of the
+// alert, or of one of its clauses, elapses, zero if none is pending
+func (ga *GremlinAlert) holdDeadline(now time.Time) (deadline time.Time) {
+	pending := func(since time.Time, hold time.Duration) {
+		if since.IsZero() || hold <= 0 {
+			return
+		}
+		if d := since.Add(hold); d.After(now) && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+	}
+
+	pending(ga.pendingSince, ga.hold)
+	for _, c := range ga.clauses {
+		pending(c.pendingSince, c.hold)
+	}
+	return
+}
+
+// stopHold cancels the pending re-evaluation of an alert being unregistered
+func (ga *GremlinAlert) stopHold() {
+	ga.holdLock.Lock()
+	defer ga.holdLock.Unlock()
+
+	ga.holdStopped = true
+	if ga.holdTimer != nil {
+		ga.holdTimer.Stop()
+		ga.holdTimer = nil
+	}
+}
+
+func (ga *GremlinAlert) evaluateClause(c *alertClause, lockGraph bool) (interface{}, error) {
 	// If the alert is a simple Gremlin query, avoid
 	// converting to JavaScript
-	if ga.traversalSequence != nil {
-		result, err := ga.traversalSequence.Exec(ga.graph, lockGraph)
+	if c.traversalSequence != nil {
+		result, err := c.traversalSequence.Exec(ga.graph, lockGraph)
 		if err != nil {
 			return nil, err
 		}
@@ -120,9 +231,9 @@ func (ga *GremlinAlert) Evaluate(lockGraph bool) (interface{}, error) {
 	gremlin, _ := vm.Get("Gremlin")
 	vm.Set("$", gremlin)
 
-	result, err := vm.Run(ga.Expression)
+	result, err := vm.Run(c.expression)
 	if err != nil {
-		return nil, fmt.Errorf("Error while executing Javascript '%s': %s", ga.Expression, err.Error())
+		return nil, fmt.Errorf("Error while executing Javascript '%s': %s", c.expression, err.Error())
 	}
 
 	if result.Class() == "Error" {
@@ -131,7 +242,7 @@ func (ga *GremlinAlert) Evaluate(lockGraph bool) (interface{}, error) {
 	}
 
 	success, _ := result.ToBoolean()
-	logging.GetLogger().Debugf("Evaluation of '%s' returned %+v => %+v (%+v)", ga.Expression, result, success, result.Class())
+	logging.GetLogger().Debugf("Evaluation of '%s' returned %+v => %+v (%+v)", c.expression, result, success, result.Class())
 
 	if success {
 		v, err := result.Export()
@@ -160,6 +271,47 @@ func (ga *GremlinAlert) Evaluate(lockGraph bool) (interface{}, error) {
 	return nil, nil
 }
 
+// underMaintenance returns whether one of the nodes involved in the data
+// matched by the alert, or one of their owners, is under maintenance
+func (ga *GremlinAlert) underMaintenance(data interface{}, lockGraph bool) bool {
+	if lockGraph {
+		ga.graph.RLock()
+		defer ga.graph.RUnlock()
+	}
+
+	return ga.involvesMaintenance(data)
+}
+
+func (ga *GremlinAlert) involvesMaintenance(data interface{}) bool {
+	switch data := data.(type) {
+	case traversal.GraphTraversalStep:
+		return ga.involvesMaintenance(data.Values())
+	case []interface{}:
+		for _, v := range data {
+			if ga.involvesMaintenance(v) {
+				return true
+			}
+		}
+	case []map[string]interface{}:
+		for _, v := range data {
+			if ga.involvesMaintenance(v) {
+				return true
+			}
+		}
+	case *graph.Node:
+		return topology.UnderMaintenance(ga.graph, data)
+	case map[string]interface{}:
+		// nodes returned by a Javascript expression
+		if id, ok := data["ID"].(string); ok {
+			if node := ga.graph.GetNode(graph.Identifier(id)); node != nil {
+				return topology.UnderMaintenance(ga.graph, node)
+			}
+		}
+	}
+
+	return false
+}
+
 func (ga *GremlinAlert) Trigger(payload []byte) error {
 	switch ga.kind {
 	case actionWebHook:
@@ -201,14 +353,61 @@ func (ga *GremlinAlert) Trigger(payload []byte) error {
 	return nil
 }
 
-func NewGremlinAlert(alert *types.Alert, g *graph.Graph, p *traversal.GremlinTraversalParser) (*GremlinAlert, error) {
-	ts, _ := p.Parse(strings.NewReader(alert.Expression))
+func newAlertClause(expression string, hold string, p *traversal.GremlinTraversalParser) (*alertClause, error) {
+	ts, _ := p.Parse(strings.NewReader(expression))
 
-	ga := &GremlinAlert{
-		Alert:             alert,
+	c := &alertClause{
+		expression:        expression,
 		traversalSequence: ts,
-		gremlinParser:     p,
-		graph:             g,
+	}
+
+	if hold != "" {
+		d, err := time.ParseDuration(hold)
+		if err != nil {
+			return nil, err
+		}
+		c.hold = d
+	}
+
+	return c, nil
+}
+
+func NewGremlinAlert(alert *types.Alert, g *graph.Graph, p *traversal.GremlinTraversalParser) (*GremlinAlert, error) {
+	ga := &GremlinAlert{
+		Alert:         alert,
+		notified:      true,
+		gremlinParser: p,
+		graph:         g,
+	}
+
+	switch alert.Operator {
+	case "", "AND", "OR":
+	default:
+		return nil, fmt.Errorf("Invalid operator %s for alert %s, has to be AND or OR", alert.Operator, alert.UUID)
+	}
+
+	// the For duration of the alert applies to its combined result
+	c, _ := newAlertClause(alert.Expression, "", p)
+	ga.clauses = append(ga.clauses, c)
+
+	for i, clause := range alert.Clauses {
+		if clause.Expression == "" {
+			return nil, fmt.Errorf("Empty expression for clause %d of alert %s", i+1, alert.UUID)
+		}
+
+		c, err := newAlertClause(clause.Expression, clause.For, p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid duration for clause %d of alert %s: %s", i+1, alert.UUID, err.Error())
+		}
+		ga.clauses = append(ga.clauses, c)
+	}
+
+	if alert.For != "" {
+		hold, err := time.ParseDuration(alert.For)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid duration for alert %s: %s", alert.UUID, err.Error())
+		}
+		ga.hold = hold
 	}
 
 	if strings.HasPrefix(alert.Action, "http://") || strings.HasPrefix(alert.Action, "https://") {
@@ -224,10 +423,13 @@ func NewGremlinAlert(alert *types.Alert, g *graph.Graph, p *traversal.GremlinTra
 
 type AlertServer struct {
 	common.RWMutex
-	*etcd.MasterElector
 	Graph         *graph.Graph
 	Pool          shttp.WSStructSpeakerPool
 	AlertHandler  api.Handler
+	elector       *etcd.ShardedElector
+	etcdKeyAPI    etcdclient.KeysAPI
+	store         storage.AlertStorage
+	host          string
 	watcher       api.StoppableWatcher
 	graphAlerts   map[string]*GremlinAlert
 	alertTimers   map[string]chan bool
@@ -260,6 +462,12 @@ func (a *AlertServer) TriggerAlert(al *GremlinAlert, data interface{}) error {
 		}
 	}()
 
+	reason, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal alert data to JSON: %s", err.Error())
+	}
+	a.storeEvent(types.NewAlertEvent(al.UUID, types.AlertFired, a.host, string(reason)))
+
 	wsMsg := shttp.NewWSStructMessage(Namespace, "Alert", msg)
 	a.Pool.BroadcastMessage(wsMsg)
 
@@ -267,8 +475,127 @@ func (a *AlertServer) TriggerAlert(al *GremlinAlert, data interface{}) error {
 	return nil
 }
 
+// IsMaster returns whether the analyzer evaluates at least one shard of the alerts
+func (a *AlertServer) IsMaster() bool {
+	return a.elector.IsAnyMaster()
+}
+
+// digest returns a digest of the data triggering an alert
+func digest(data interface{}) (string, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	h := fnv.New64a()
+	h.Write(b)
+	return fmt.Sprintf("%x", h.Sum64()), nil
+}
+
+// claimNotification records the data of the notification of an alert,
+// returning false if an analyzer already notified the same data, for
+// instance before the shard of the alert moved to this analyzer
+func (a *AlertServer) claimNotification(al *GremlinAlert, data interface{}) (bool, error) {
+	d, err := digest(data)
+	if err != nil {
+		return false, err
+	}
+
+	key := notificationsPath + al.UUID
+	opts := &etcdclient.SetOptions{PrevExist: etcdclient.PrevNoExist}
+
+	resp, err := a.etcdKeyAPI.Get(context.Background(), key, nil)
+	if err == nil {
+		if resp.Node.Value == d {
+			return false, nil
+		}
+		opts = &etcdclient.SetOptions{PrevValue: resp.Node.Value}
+	} else if !etcdclient.IsKeyNotFound(err) {
+		return false, err
+	}
+
+	// the compare and swap fails if another analyzer notified in between
+	if _, err := a.etcdKeyAPI.Set(context.Background(), key, d, opts); err != nil {
+		if cerr, ok := err.(etcdclient.Error); ok && (cerr.Code == etcdclient.ErrorCodeTestFailed || cerr.Code == etcdclient.ErrorCodeNodeExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// releaseNotification forgets the last notification of an alert so that
+// the same data triggers the alert again. It returns false if the alert was
+// not notified or if another analyzer already released it.
+func (a *AlertServer) releaseNotification(id string) bool {
+	_, err := a.etcdKeyAPI.Delete(context.Background(), notificationsPath+id, nil)
+	return err == nil
+}
+
+// storeEvent persists an alert event if an alert storage is configured
+func (a *AlertServer) storeEvent(event *types.AlertEvent) {
+	if a.store == nil {
+		return
+	}
+
+	go func() {
+		if err := a.store.StoreAlertEvent(event); err != nil {
+			logging.GetLogger().Errorf("Failed to store event of alert %s: %s", event.AlertUUID, err.Error())
+		}
+	}()
+}
+
+// scheduleReevaluation re-evaluates an alert once the For duration of its
+// pending condition elapses, no graph event or tick may come by then
+func (a *AlertServer) scheduleReevaluation(al *GremlinAlert) {
+	now := time.Now()
+	deadline := al.holdDeadline(now)
+
+	al.holdLock.Lock()
+	defer al.holdLock.Unlock()
+
+	if al.holdTimer != nil {
+		al.holdTimer.Stop()
+		al.holdTimer = nil
+	}
+	if deadline.IsZero() || al.holdStopped {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(deadline.Sub(now), func() {
+		// the graph lock serializes the re-evaluation with the ones of
+		// the graph events and of the ticks
+		a.Graph.Lock()
+		defer a.Graph.Unlock()
+
+		al.holdLock.Lock()
+		if al.holdStopped || al.holdTimer != timer {
+			al.holdLock.Unlock()
+			return
+		}
+		al.holdTimer = nil
+		al.holdLock.Unlock()
+
+		if err := a.evaluateAlert(al, false); err != nil {
+			logging.GetLogger().Warning(err.Error())
+		}
+	})
+	al.holdTimer = timer
+}
+
 func (a *AlertServer) evaluateAlert(al *GremlinAlert, lockGraph bool) error {
-	if !a.IsMaster() {
+	defer a.scheduleReevaluation(al)
+
+	// the alert is evaluated by the analyzer handling its shard only
+	if !a.elector.IsKeyMaster(al.UUID) {
+		al.lastEval = nil
+		al.pendingSince = time.Time{}
+		for _, c := range al.clauses {
+			c.pendingSince = time.Time{}
+		}
+		al.notified = true
 		return nil
 	}
 
@@ -278,18 +605,54 @@ func (a *AlertServer) evaluateAlert(al *GremlinAlert, lockGraph bool) error {
 	}
 
 	if data != nil {
+		// The condition has to be met during the whole For duration
+		// before the alert gets triggered
+		if al.hold > 0 {
+			now := time.Now()
+			if al.pendingSince.IsZero() {
+				al.pendingSince = now
+			}
+			if now.Sub(al.pendingSince) < al.hold {
+				return nil
+			}
+		}
+
+		// Alerts involving nodes under maintenance are suppressed, they
+		// get notified at the end of the maintenance if still matching
+		if al.underMaintenance(data, lockGraph) {
+			logging.GetLogger().Debugf("Alert %s suppressed, nodes under maintenance", al.UUID)
+			return nil
+		}
+
 		// Gremlin query/Javascript expression returned datas.
 		// Alert must but sent if those datas differ from the one that trigger
 		// the previous alert.
 		equal := reflect.DeepEqual(reflect.ValueOf(data).Interface(), al.lastEval)
 		if !equal {
 			al.lastEval = data
+			al.notified = true
+
+			claimed, err := a.claimNotification(al, data)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to deduplicate the notification of alert %s: %s", al.UUID, err.Error())
+			} else if !claimed {
+				logging.GetLogger().Debugf("Alert %s already notified with the same data", al.UUID)
+				return nil
+			}
+
 			return a.TriggerAlert(al, data)
 		}
 	} else {
 		// Gremlin query returned no datas, or Javascript expression was unsuccessful
 		// Reset the lastEval to be able to trigger the alert next time
+		if al.notified {
+			if a.releaseNotification(al.UUID) {
+				a.storeEvent(types.NewAlertEvent(al.UUID, types.AlertResolved, a.host, ""))
+			}
+			al.notified = false
+		}
 		al.lastEval = nil
+		al.pendingSince = time.Time{}
 	}
 
 	return nil
@@ -368,6 +731,7 @@ func (a *AlertServer) RegisterAlert(apiAlert *types.Alert) error {
 						logging.GetLogger().Warning(err.Error())
 					}
 				case <-done:
+					alert.stopHold()
 					return
 				}
 			}
@@ -379,6 +743,9 @@ func (a *AlertServer) RegisterAlert(apiAlert *types.Alert) error {
 		fallthrough
 	default:
 		a.Lock()
+		if previous, found := a.graphAlerts[apiAlert.UUID]; found {
+			previous.stopHold()
+		}
 		a.graphAlerts[apiAlert.UUID] = alert
 		a.Unlock()
 	}
@@ -389,13 +756,16 @@ func (a *AlertServer) RegisterAlert(apiAlert *types.Alert) error {
 func (a *AlertServer) UnregisterAlert(id string) {
 	logging.GetLogger().Debugf("Alert deleted: %s", id)
 
+	a.releaseNotification(id)
+
 	a.Lock()
 	defer a.Unlock()
 
 	if ch, found := a.alertTimers[id]; found {
 		close(ch)
 		delete(a.alertTimers, id)
-	} else {
+	} else if alert, found := a.graphAlerts[id]; found {
+		alert.stopHold()
 		delete(a.graphAlerts, id)
 	}
 }
@@ -412,21 +782,96 @@ func (a *AlertServer) onAPIWatcherEvent(action string, id string, resource types
 }
 
 func (a *AlertServer) Start() {
-	a.StartAndWait()
+	a.elector.Start()
 
 	a.watcher = a.AlertHandler.AsyncWatch(a.onAPIWatcherEvent)
 	a.Graph.AddEventListener(a)
+
+	if config.GetBool("analyzer.probe_degradation_alert.enabled") {
+		a.registerProbeDegradationAlert()
+	}
+
+	if config.GetBool("analyzer.loop.enabled") {
+		a.registerLoopAlert()
+	}
+
+	if config.GetBool("analyzer.threat_intel.enabled") {
+		a.registerThreatIntelAlert()
+	}
+}
+
+// registerProbeDegradationAlert registers an alert triggered whenever a
+// probe reports a degradation on a node
+func (a *AlertServer) registerProbeDegradationAlert() {
+	alert := &types.Alert{
+		UUID:        ProbeDegradationAlertID,
+		Name:        "Probe degradation",
+		Description: "A probe failed to report its part of the topology",
+		Expression:  "G.V().HasKey('Degradations')",
+		Action:      config.GetString("analyzer.probe_degradation_alert.action"),
+		Trigger:     "graph",
+		CreateTime:  time.Now().UTC(),
+	}
+
+	if err := a.RegisterAlert(alert); err != nil {
+		logging.GetLogger().Errorf("Failed to register probe degradation alert: %s", err.Error())
+	}
+}
+
+// registerLoopAlert registers an alert triggered whenever the loop detector
+// reports a suspected layer2 loop
+func (a *AlertServer) registerLoopAlert() {
+	alert := &types.Alert{
+		UUID:        LoopAlertID,
+		Name:        "Layer2 loop",
+		Description: "A broadcast or BPDU storm reveals a suspected layer2 loop",
+		Expression:  "G.V().Has('Type', 'annotation', 'Annotation.Kind', 'l2-loop')",
+		Action:      config.GetString("analyzer.loop.action"),
+		Trigger:     "graph",
+		CreateTime:  time.Now().UTC(),
+	}
+
+	if err := a.RegisterAlert(alert); err != nil {
+		logging.GetLogger().Errorf("Failed to register layer2 loop alert: %s", err.Error())
+	}
+}
+
+// registerThreatIntelAlert registers an alert triggered whenever flows
+// match an indicator of a threat intelligence feed
+func (a *AlertServer) registerThreatIntelAlert() {
+	alert := &types.Alert{
+		UUID:        ThreatIntelAlertID,
+		Name:        "Threat intelligence",
+		Description: "Flows match an indicator of a threat intelligence feed",
+		Expression:  "G.V().Has('Type', 'annotation', 'Annotation.Kind', 'threat-intel')",
+		Action:      config.GetString("analyzer.threat_intel.action"),
+		Trigger:     "graph",
+		CreateTime:  time.Now().UTC(),
+	}
+
+	if err := a.RegisterAlert(alert); err != nil {
+		logging.GetLogger().Errorf("Failed to register threat intelligence alert: %s", err.Error())
+	}
 }
 
 func (a *AlertServer) Stop() {
-	a.MasterElector.Stop()
+	a.RLock()
+	for _, alert := range a.graphAlerts {
+		alert.stopHold()
+	}
+	a.RUnlock()
+
+	a.elector.Stop()
 }
 
-func NewAlertServer(ah api.Handler, pool shttp.WSStructSpeakerPool, graph *graph.Graph, parser *traversal.GremlinTraversalParser, etcdClient *etcd.Client) *AlertServer {
-	elector := etcd.NewMasterElectorFromConfig(common.AnalyzerService, "alert-server", etcdClient)
+func NewAlertServer(ah api.Handler, pool shttp.WSStructSpeakerPool, graph *graph.Graph, parser *traversal.GremlinTraversalParser, etcdClient *etcd.Client, store storage.Storage) *AlertServer {
+	shards := config.GetInt("analyzer.alert.shards")
+	elector := etcd.NewShardedElectorFromConfig(common.AnalyzerService, "alert-server", shards, etcdClient)
 
 	as := &AlertServer{
-		MasterElector: elector,
+		elector:       elector,
+		etcdKeyAPI:    etcdClient.KeysAPI,
+		host:          config.GetString("host_id"),
 		Pool:          pool,
 		AlertHandler:  ah,
 		Graph:         graph,
@@ -435,5 +880,10 @@ func NewAlertServer(ah api.Handler, pool shttp.WSStructSpeakerPool, graph *graph
 		gremlinParser: parser,
 	}
 
+	// the alert events are persisted only if the storage supports them
+	if store, ok := store.(storage.AlertStorage); ok {
+		as.store = store
+	}
+
 	return as
 }
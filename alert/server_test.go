@@ -0,0 +1,407 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package alert
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/etcd/etcdtest"
+	"github.com/skydive-project/skydive/filters"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// racingKeysAPI notifies the alert from another analyzer right after the
+// last notification was read
+type racingKeysAPI struct {
+	etcdclient.KeysAPI
+	value string
+}
+
+func (r *racingKeysAPI) Get(ctx context.Context, key string, opts *etcdclient.GetOptions) (*etcdclient.Response, error) {
+	resp, err := r.KeysAPI.Get(ctx, key, opts)
+	if _, err := r.KeysAPI.Set(ctx, key, r.value, nil); err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+func TestClaimNotification(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	as := &AlertServer{etcdKeyAPI: server.Client.KeysAPI}
+	al := &GremlinAlert{Alert: &types.Alert{UUID: "claim"}}
+
+	claim := func(as *AlertServer, data interface{}, expected bool) {
+		claimed, err := as.claimNotification(al, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed != expected {
+			t.Fatalf("Expected the claim of %v to return %t", data, expected)
+		}
+	}
+
+	claim(as, "data1", true)
+	claim(as, "data1", false)
+	claim(as, "data2", true)
+
+	if !as.releaseNotification(al.UUID) {
+		t.Fatal("Expected the notification to be released")
+	}
+	if as.releaseNotification(al.UUID) {
+		t.Fatal("Expected a notification to be released only once")
+	}
+	claim(as, "data2", true)
+
+	// another analyzer notifies between the read and the write of the last notification
+	other, _ := digest("data4")
+	racing := &AlertServer{etcdKeyAPI: &racingKeysAPI{KeysAPI: server.Client.KeysAPI, value: other}}
+	claim(racing, "data3", false)
+
+	resp, err := server.Client.KeysAPI.Get(context.Background(), notificationsPath+al.UUID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Node.Value != other {
+		t.Fatal("Expected the notification of the other analyzer to be kept")
+	}
+
+	// same race on an alert never notified
+	as.releaseNotification(al.UUID)
+	claim(racing, "data3", false)
+}
+
+func TestClaimNotificationConcurrent(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	al := &GremlinAlert{Alert: &types.Alert{UUID: "concurrent"}}
+
+	var wg sync.WaitGroup
+	claims := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			as := &AlertServer{etcdKeyAPI: server.Client.KeysAPI}
+			claimed, err := as.claimNotification(al, "data")
+			if err != nil {
+				t.Error(err)
+			}
+			claims <- claimed
+		}()
+	}
+	wg.Wait()
+	close(claims)
+
+	count := 0
+	for claimed := range claims {
+		if claimed {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected a single analyzer to notify the alert, got: %d", count)
+	}
+}
+
+// TestAlertForHold checks that an alert pending for its For duration is
+// triggered once the duration elapsed, even without any graph event by then
+func TestAlertForHold(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	elector := etcd.NewShardedElector("host1", common.AnalyzerService, "alert-test", 1, server.Client)
+	elector.Start()
+	defer elector.Stop()
+
+	triggered := make(chan time.Time, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		triggered <- time.Now()
+	}))
+	defer webhook.Close()
+
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host1", backend)
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host1"})
+	g.Unlock()
+
+	as := &AlertServer{
+		Graph:         g,
+		Pool:          shttp.NewWSStructClientPool("alert-test"),
+		elector:       elector,
+		etcdKeyAPI:    server.Client.KeysAPI,
+		graphAlerts:   make(map[string]*GremlinAlert),
+		alertTimers:   make(map[string]chan bool),
+		gremlinParser: traversal.NewGremlinTraversalParser(),
+	}
+	defer as.Stop()
+
+	tests := []*types.Alert{
+		{UUID: "alert-for", Expression: "G.V().Has('Type', 'host')", Action: webhook.URL, For: "300ms"},
+		{UUID: "clause-for", Expression: "G.V().Has('Type', 'host')", Action: webhook.URL, Clauses: []types.AlertClause{
+			{Expression: "G.V().Has('Name', 'host1')", For: "300ms"},
+		}},
+	}
+
+	for _, alert := range tests {
+		registered := time.Now()
+		if err := as.RegisterAlert(alert); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case at := <-triggered:
+			if at.Sub(registered) < 300*time.Millisecond {
+				t.Errorf("%s: expected the alert to be triggered after its For duration, got %s", alert.UUID, at.Sub(registered))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: expected the alert to be triggered once its For duration elapsed", alert.UUID)
+		}
+
+		as.UnregisterAlert(alert.UUID)
+	}
+
+	// an alert unregistered while pending is not triggered
+	if err := as.RegisterAlert(&types.Alert{UUID: "unregistered", Expression: "G.V().Has('Type', 'host')", Action: webhook.URL, For: "300ms"}); err != nil {
+		t.Fatal(err)
+	}
+	as.UnregisterAlert("unregistered")
+
+	select {
+	case <-triggered:
+		t.Error("Expected an unregistered alert not to be triggered")
+	case <-time.After(600 * time.Millisecond):
+	}
+}
+
+// eventStorage collects the stored alert events
+type eventStorage struct {
+	events chan *types.AlertEvent
+}
+
+func (s *eventStorage) StoreAlertEvent(event *types.AlertEvent) error {
+	s.events <- event
+	return nil
+}
+
+func (s *eventStorage) SearchAlertEvents(fsq filters.SearchQuery) ([]*types.AlertEvent, error) {
+	return nil, nil
+}
+
+func TestAlertHistoryTransitions(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	elector := etcd.NewShardedElector("host1", common.AnalyzerService, "alert-history", 1, server.Client)
+	elector.Start()
+	defer elector.Stop()
+
+	for i := 0; !elector.IsKeyMaster("history"); i++ {
+		if i == 50 {
+			t.Fatal("Expected the analyzer to handle the shard of the alert")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host1", backend)
+
+	store := &eventStorage{events: make(chan *types.AlertEvent, 10)}
+	as := &AlertServer{
+		Graph:       g,
+		Pool:        shttp.NewWSStructClientPool("alert-history"),
+		elector:     elector,
+		etcdKeyAPI:  server.Client.KeysAPI,
+		store:       store,
+		host:        "host1",
+		graphAlerts: make(map[string]*GremlinAlert),
+		alertTimers: make(map[string]chan bool),
+	}
+
+	al, err := NewGremlinAlert(&types.Alert{UUID: "history", Expression: "G.V().Has('Type', 'host')"}, g, traversal.NewGremlinTraversalParser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evaluate := func() {
+		if err := as.evaluateAlert(al, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addHost := func() *graph.Node {
+		g.Lock()
+		defer g.Unlock()
+		return g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host1"})
+	}
+
+	// not matching at start, the alert was never fired
+	evaluate()
+
+	n := addHost()
+	evaluate()
+	evaluate()
+
+	// the events are timestamped in milliseconds
+	time.Sleep(5 * time.Millisecond)
+	g.Lock()
+	g.DelNode(n)
+	g.Unlock()
+	evaluate()
+	evaluate()
+
+	time.Sleep(5 * time.Millisecond)
+	addHost()
+	evaluate()
+
+	var events []*types.AlertEvent
+	timeout := time.After(5 * time.Second)
+	for len(events) < 3 {
+		select {
+		case event := <-store.events:
+			events = append(events, event)
+		case <-timeout:
+			t.Fatalf("Expected 3 transitions, got: %d", len(events))
+		}
+	}
+
+	select {
+	case event := <-store.events:
+		t.Fatalf("Expected a single event per transition, got: %+v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// the events are stored concurrently, in the order of their timestamps
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp < events[j].Timestamp })
+
+	var states []string
+	for _, event := range events {
+		states = append(states, event.State)
+		if event.AlertUUID != "history" || event.Host != "host1" {
+			t.Errorf("Expected the events of the alert on host1, got: %+v", event)
+		}
+	}
+	if expected := []string{types.AlertFired, types.AlertResolved, types.AlertFired}; !reflect.DeepEqual(states, expected) {
+		t.Errorf("Expected the transitions %v, got: %v", expected, states)
+	}
+}
+
+func TestAlertMaintenance(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	elector := etcd.NewShardedElector("host1", common.AnalyzerService, "alert-maintenance", 1, server.Client)
+	elector.Start()
+	defer elector.Stop()
+
+	for i := 0; !elector.IsKeyMaster("maintenance"); i++ {
+		if i == 50 {
+			t.Fatal("Expected the analyzer to handle the shard of the alert")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host1", backend)
+
+	// the host is under maintenance while its interface is alerting
+	expire := time.Now().Add(500 * time.Millisecond)
+	g.Lock()
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host1"})
+	g.AddMetadata(host, topology.MaintenanceMetadataKey, map[string]interface{}{
+		"UUID":       "maintenance",
+		"ExpireTime": common.UnixMillis(expire),
+	})
+	intf := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth0", "State": "DOWN"})
+	topology.AddOwnershipLink(g, host, intf, nil)
+	g.Unlock()
+
+	store := &eventStorage{events: make(chan *types.AlertEvent, 10)}
+	as := &AlertServer{
+		Graph:       g,
+		Pool:        shttp.NewWSStructClientPool("alert-maintenance"),
+		elector:     elector,
+		etcdKeyAPI:  server.Client.KeysAPI,
+		store:       store,
+		graphAlerts: make(map[string]*GremlinAlert),
+		alertTimers: make(map[string]chan bool),
+	}
+
+	al, err := NewGremlinAlert(&types.Alert{UUID: "maintenance", Expression: "G.V().Has('State', 'DOWN')"}, g, traversal.NewGremlinTraversalParser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := as.evaluateAlert(al, true); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-store.events:
+		t.Fatalf("Expected the alert to be suppressed during the maintenance, got: %+v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// the alert is notified once the maintenance window expired
+	time.Sleep(time.Until(expire) + 50*time.Millisecond)
+	if err := as.evaluateAlert(al, true); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-store.events:
+		if event.State != types.AlertFired {
+			t.Errorf("Expected the alert to be fired, got: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the alert to be fired at the end of the maintenance")
+	}
+}
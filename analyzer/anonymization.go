@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// newAnonymizerFromConfig returns the anonymizer of the IPs, the MACs and the
+// hostnames of the graph and the flows, nil if the anonymization is disabled
+func newAnonymizerFromConfig() (*common.Anonymizer, error) {
+	if !config.GetBool("analyzer.anonymization.enabled") {
+		return nil, nil
+	}
+
+	mode, salt := config.GetString("analyzer.anonymization.mode"), config.GetString("analyzer.anonymization.salt")
+	if mode == common.AnonymizeHash && salt == "" {
+		logging.GetLogger().Warning("No anonymization salt, the hashes will change with each restart of the analyzer")
+	}
+
+	return common.NewAnonymizer(mode, salt)
+}
+
+// setupAnonymization anonymizes the metadata of the graph elements and the
+// flows before they get stored or sent to the clients. The flows queried
+// live from the agents are not anonymized.
+func setupAnonymization(g *graph.Graph, fs *FlowServer) error {
+	a, err := newAnonymizerFromConfig()
+	if err != nil || a == nil {
+		return err
+	}
+
+	g.Lock()
+	g.Use(graph.AnonymizeMetadataMiddleware(a, config.GetStringSlice("analyzer.anonymization.metadata")...))
+	g.Unlock()
+
+	if config.GetBool("analyzer.anonymization.flows") {
+		fs.SetAnonymizer(a)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"time"
+
+	"github.com/skydive-project/skydive/config"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// clockSkew compensates the clock skew of the agents, estimated from the
+// WebSocket heartbeats, by shifting the timestamps of the graph elements and
+// of the flows they send
+type clockSkew struct {
+	enabled   bool
+	threshold time.Duration
+}
+
+// correction returns the shift to apply to the timestamps sent by a peer,
+// zero if its clock offset is below the threshold
+func (s clockSkew) correction(c shttp.WSSpeaker) time.Duration {
+	if !s.enabled {
+		return 0
+	}
+
+	offset := c.GetClockOffset()
+	if offset < s.threshold && offset > -s.threshold {
+		return 0
+	}
+	return -offset
+}
+
+// correctGraphMessage shifts the timestamps of a graph message sent by a peer
+func (s clockSkew) correctGraphMessage(c shttp.WSSpeaker, obj interface{}) {
+	d := s.correction(c)
+	if d == 0 {
+		return
+	}
+
+	switch obj := obj.(type) {
+	case *graph.Node:
+		obj.ShiftTime(d)
+	case *graph.Edge:
+		obj.ShiftTime(d)
+	case *graph.SyncMsg:
+		for _, n := range obj.Nodes {
+			n.ShiftTime(d)
+		}
+		for _, e := range obj.Edges {
+			e.ShiftTime(d)
+		}
+	case *graph.HostGraphShutdownMsg:
+		obj.Time += int64(d / time.Millisecond)
+	}
+}
+
+// newClockSkewFromConfig returns the clock skew compensation as configured
+func newClockSkewFromConfig() clockSkew {
+	return clockSkew{
+		enabled:   config.GetBool("analyzer.clock_skew.enabled"),
+		threshold: time.Duration(config.GetInt("analyzer.clock_skew.threshold")) * time.Millisecond,
+	}
+}
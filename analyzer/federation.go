@@ -0,0 +1,416 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	federationNamespace = "0c3b5b9e-6f57-4d8e-a3a2-8d1e4f6b2c71"
+	federationManager   = "federation"
+)
+
+// FederatedSiteConfig describes a site analyzer in the configuration
+type FederatedSiteConfig struct {
+	Name     string `mapstructure:"name"`
+	Address  string `mapstructure:"address"`
+	Filter   string `mapstructure:"filter"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// FederatedSite imports the graph of a site analyzer it subscribes to. The
+// identifiers of the imported nodes and edges are prefixed by the site name
+// and the imported nodes without owner are owned by the site node.
+type FederatedSite struct {
+	shttp.DefaultWSSpeakerEventHandler
+	Name        string
+	URL         *url.URL
+	Filter      string
+	AuthOptions *shttp.AuthenticationOpts
+	graph       *graph.Graph
+	node        *graph.Node
+	wsspeaker   *shttp.WSStructSpeaker
+	restClient  *shttp.RestClient
+	nodes       map[graph.Identifier]bool
+	edges       map[graph.Identifier]bool
+	connected   bool
+}
+
+// Federator subscribes to the site analyzers of the configuration, giving
+// a single view of their topologies, and fans out the Gremlin queries.
+type Federator struct {
+	sync.RWMutex
+	graph *graph.Graph
+	sites []*FederatedSite
+}
+
+func (s *FederatedSite) prefixID(id graph.Identifier) graph.Identifier {
+	return graph.Identifier(s.Name + "/" + string(id))
+}
+
+func (s *FederatedSite) siteMetadata(state string) graph.Metadata {
+	return graph.Metadata{
+		"Type":    "site",
+		"Name":    s.Name,
+		"Manager": federationManager,
+		"Address": s.URL.Host,
+		"State":   state,
+	}
+}
+
+// hasOwner returns whether an imported node is owned by another imported node
+func (s *FederatedSite) hasOwner(n *graph.Node) bool {
+	for _, e := range s.graph.GetNodeEdges(n, topology.OwnershipMetadata) {
+		if e.GetChild() == n.ID && e.GetParent() != s.node.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *FederatedSite) importNode(n *graph.Node) {
+	id := s.prefixID(n.ID)
+
+	m := n.Metadata()
+	m["Site"] = s.Name
+
+	if node := s.graph.GetNode(id); node != nil {
+		s.graph.SetMetadata(node, m)
+		return
+	}
+
+	node := s.graph.NewNode(id, m, s.Name+"/"+n.Host())
+	if node == nil {
+		return
+	}
+	s.nodes[id] = true
+
+	topology.AddOwnershipLink(s.graph, s.node, node, nil)
+}
+
+func (s *FederatedSite) deleteNode(id graph.Identifier) {
+	delete(s.nodes, id)
+
+	node := s.graph.GetNode(id)
+	if node == nil {
+		return
+	}
+
+	// the edges of the node are deleted with it
+	for _, e := range s.graph.GetNodeEdges(node, nil) {
+		if s.edges[e.ID] {
+			s.deleteEdge(e.ID)
+		}
+	}
+	s.graph.DelNode(node)
+}
+
+func (s *FederatedSite) importEdge(e *graph.Edge) {
+	id := s.prefixID(e.ID)
+
+	m := e.Metadata()
+	m["Site"] = s.Name
+
+	if edge := s.graph.GetEdge(id); edge != nil {
+		s.graph.SetMetadata(edge, m)
+		return
+	}
+
+	parent, child := s.graph.GetNode(s.prefixID(e.GetParent())), s.graph.GetNode(s.prefixID(e.GetChild()))
+	if parent == nil || child == nil {
+		logging.GetLogger().Debugf("Edge %s of site %s ignored, unknown node", e.ID, s.Name)
+		return
+	}
+
+	// the site node only owns the roots of the imported graph
+	if relationType, _ := e.GetFieldString("RelationType"); relationType == topology.OwnershipLink {
+		s.graph.Unlink(s.node, child)
+	}
+
+	if s.graph.NewEdge(id, parent, child, m, s.Name+"/"+e.Host()) != nil {
+		s.edges[id] = true
+	}
+}
+
+func (s *FederatedSite) deleteEdge(id graph.Identifier) {
+	edge := s.graph.GetEdge(id)
+	delete(s.edges, id)
+	if edge == nil {
+		return
+	}
+	s.graph.DelEdge(edge)
+
+	if relationType, _ := edge.GetFieldString("RelationType"); relationType == topology.OwnershipLink {
+		if child := s.graph.GetNode(edge.GetChild()); child != nil && !s.hasOwner(child) {
+			topology.AddOwnershipLink(s.graph, s.node, child, nil)
+		}
+	}
+}
+
+// resync replaces the imported graph by the graph of a sync reply
+func (s *FederatedSite) resync(r *graph.SyncMsg) {
+	nodes, edges := make(map[graph.Identifier]bool), make(map[graph.Identifier]bool)
+	for _, n := range r.Nodes {
+		nodes[s.prefixID(n.ID)] = true
+	}
+	for _, e := range r.Edges {
+		edges[s.prefixID(e.ID)] = true
+	}
+
+	for id := range s.edges {
+		if !edges[id] {
+			s.deleteEdge(id)
+		}
+	}
+	for id := range s.nodes {
+		if !nodes[id] {
+			s.deleteNode(id)
+		}
+	}
+
+	for _, n := range r.Nodes {
+		s.importNode(n)
+	}
+	for _, e := range r.Edges {
+		s.importEdge(e)
+	}
+}
+
+// OnConnected requests the graph of the site analyzer
+func (s *FederatedSite) OnConnected(c shttp.WSSpeaker) {
+	logging.GetLogger().Infof("Connected to the analyzer of site %s", s.Name)
+
+	s.graph.Lock()
+	s.connected = true
+	s.graph.SetMetadata(s.node, s.siteMetadata("UP"))
+	s.graph.Unlock()
+
+	c.SendMessage(shttp.NewWSStructMessage(graph.Namespace, graph.SyncRequestMsgType, graph.SyncRequestMsg{GremlinFilter: s.Filter}))
+}
+
+// OnDisconnected marks the site as down, keeping the imported graph until
+// the next synchronization
+func (s *FederatedSite) OnDisconnected(c shttp.WSSpeaker) {
+	logging.GetLogger().Warningf("Disconnected from the analyzer of site %s", s.Name)
+
+	s.graph.Lock()
+	s.connected = false
+	s.graph.SetMetadata(s.node, s.siteMetadata("DOWN"))
+	s.graph.Unlock()
+}
+
+// OnWSStructMessage imports the graph messages of the site analyzer
+func (s *FederatedSite) OnWSStructMessage(c shttp.WSSpeaker, msg *shttp.WSStructMessage) {
+	msgType, obj, err := graph.UnmarshalWSMessage(msg)
+	if err != nil {
+		logging.GetLogger().Errorf("Graph: Unable to parse the event %v: %s", msg, err.Error())
+		return
+	}
+
+	s.graph.Lock()
+	defer s.graph.Unlock()
+
+	switch msgType {
+	case graph.SyncMsgType, graph.SyncReplyMsgType:
+		if msg.Status != http.StatusOK {
+			logging.GetLogger().Errorf("Unable to get the graph of site %s: status %d", s.Name, msg.Status)
+			return
+		}
+		s.resync(obj.(*graph.SyncMsg))
+	case graph.NodeAddedMsgType, graph.NodeUpdatedMsgType:
+		s.importNode(obj.(*graph.Node))
+	case graph.NodeDeletedMsgType:
+		s.deleteNode(s.prefixID(obj.(*graph.Node).ID))
+	case graph.EdgeAddedMsgType, graph.EdgeUpdatedMsgType:
+		s.importEdge(obj.(*graph.Edge))
+	case graph.EdgeDeletedMsgType:
+		s.deleteEdge(s.prefixID(obj.(*graph.Edge).ID))
+	}
+}
+
+// query runs a Gremlin query against the site analyzer
+func (s *FederatedSite) query(query string) *types.FederationResult {
+	body, err := json.Marshal(types.TopologyParam{GremlinQuery: query})
+	if err != nil {
+		return &types.FederationResult{Error: err.Error()}
+	}
+
+	resp, err := s.restClient.Request("POST", "api/topology", bytes.NewReader(body), http.Header{"Content-Type": []string{"application/json"}})
+	if err != nil {
+		return &types.FederationResult{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &types.FederationResult{Error: err.Error()}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return &types.FederationResult{Result: json.RawMessage(data)}
+	case http.StatusNoContent:
+		return &types.FederationResult{}
+	default:
+		return &types.FederationResult{Error: fmt.Sprintf("%s: %s", resp.Status, string(data))}
+	}
+}
+
+// GetSites returns the status of the site analyzers
+func (f *Federator) GetSites() map[string]types.FederationSite {
+	f.graph.RLock()
+	defer f.graph.RUnlock()
+
+	sites := make(map[string]types.FederationSite, len(f.sites))
+	for _, s := range f.sites {
+		sites[s.Name] = types.FederationSite{
+			Address:   s.URL.Host,
+			Connected: s.connected,
+			Nodes:     len(s.nodes),
+			Edges:     len(s.edges),
+		}
+	}
+	return sites
+}
+
+// Query fans out a Gremlin query to the site analyzers, returning the
+// results by site
+func (f *Federator) Query(query string) map[string]*types.FederationResult {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+
+	results := make(map[string]*types.FederationResult, len(f.sites))
+	for _, s := range f.sites {
+		wg.Add(1)
+		go func(s *FederatedSite) {
+			defer wg.Done()
+
+			result := s.query(query)
+
+			lock.Lock()
+			results[s.Name] = result
+			lock.Unlock()
+		}(s)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Start connects to the site analyzers
+func (f *Federator) Start() {
+	for _, s := range f.sites {
+		s.wsspeaker.Connect()
+	}
+}
+
+// Stop disconnects from the site analyzers, the imported graphs being kept
+func (f *Federator) Stop() {
+	for _, s := range f.sites {
+		s.wsspeaker.Disconnect()
+	}
+}
+
+// NewFederatorFromConfig returns a federator of the site analyzers of the
+// configuration, nil if none is defined
+func NewFederatorFromConfig(g *graph.Graph) (*Federator, error) {
+	var sites []*FederatedSiteConfig
+	if err := config.GetConfig().UnmarshalKey("analyzer.federation.sites", &sites); err != nil {
+		return nil, fmt.Errorf("Invalid analyzer.federation.sites: %s", err.Error())
+	}
+
+	if len(sites) == 0 {
+		return nil, nil
+	}
+
+	f := &Federator{graph: g}
+
+	names := make(map[string]bool)
+	for i, sc := range sites {
+		if sc.Name == "" || sc.Address == "" {
+			return nil, fmt.Errorf("Federated site %d: name and address are mandatory", i)
+		}
+		if names[sc.Name] {
+			return nil, fmt.Errorf("Federated site %s defined twice", sc.Name)
+		}
+		names[sc.Name] = true
+
+		sa, err := common.ServiceAddressFromString(sc.Address)
+		if err != nil {
+			return nil, fmt.Errorf("Federated site %s: %s", sc.Name, err.Error())
+		}
+
+		authOptions := NewAnalyzerAuthenticationOpts()
+		if sc.Username != "" {
+			authOptions = &shttp.AuthenticationOpts{Username: sc.Username, Password: sc.Password}
+		}
+
+		restClient, err := shttp.NewRestClient(config.GetURL("http", sa.Addr, sa.Port, ""), authOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &FederatedSite{
+			Name:        sc.Name,
+			URL:         config.GetURL("ws", sa.Addr, sa.Port, "/ws/subscriber"),
+			Filter:      sc.Filter,
+			AuthOptions: authOptions,
+			graph:       g,
+			restClient:  restClient,
+			nodes:       make(map[graph.Identifier]bool),
+			edges:       make(map[graph.Identifier]bool),
+		}
+
+		g.Lock()
+		id := graph.GenIDNameBased(federationNamespace, sc.Name)
+		if s.node = g.GetNode(id); s.node == nil {
+			s.node = g.NewNode(id, s.siteMetadata("DOWN"))
+		}
+		g.Unlock()
+
+		authClient := shttp.NewAuthenticationClient(config.GetURL("http", sa.Addr, sa.Port, ""), authOptions)
+		s.wsspeaker = shttp.NewWSClientFromConfig(common.AnalyzerService, s.URL, authClient, http.Header{}).UpgradeToWSStructSpeaker()
+		s.wsspeaker.AddEventHandler(s)
+		s.wsspeaker.AddStructMessageHandler(s, []string{graph.Namespace})
+
+		f.sites = append(f.sites, s)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func newTestGraph(t *testing.T, host string) *graph.Graph {
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph.NewGraph(host, backend)
+}
+
+func newTestFederatedSite(t *testing.T, name string) *FederatedSite {
+	g := newTestGraph(t, "federation")
+
+	s := &FederatedSite{
+		Name:  name,
+		URL:   &url.URL{Scheme: "ws", Host: "127.0.0.1:8082", Path: "/ws/subscriber"},
+		graph: g,
+		nodes: make(map[graph.Identifier]bool),
+		edges: make(map[graph.Identifier]bool),
+	}
+	s.node = g.NewNode(graph.GenIDNameBased(federationNamespace, name), s.siteMetadata("UP"))
+
+	return s
+}
+
+// sendSiteMessage feeds a message of the site analyzer as received on the wire
+func sendSiteMessage(t *testing.T, s *FederatedSite, msgType string, obj interface{}) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := json.RawMessage(data)
+
+	s.OnWSStructMessage(nil, &shttp.WSStructMessage{
+		Protocol:  shttp.JsonProtocol,
+		Namespace: graph.Namespace,
+		Type:      msgType,
+		UUID:      "uuid",
+		Status:    http.StatusOK,
+		JsonObj:   &raw,
+	})
+}
+
+func siteNode(t *testing.T, s *FederatedSite, id graph.Identifier) *graph.Node {
+	node := s.graph.GetNode(graph.Identifier(s.Name + "/" + string(id)))
+	if node == nil {
+		t.Fatalf("Expected node %s to be imported with the prefix of site %s", id, s.Name)
+	}
+	if site, _ := node.GetFieldString("Site"); site != s.Name {
+		t.Errorf("Expected node %s to be tagged with site %s, got: %s", node.ID, s.Name, site)
+	}
+	return node
+}
+
+func assertSiteOwned(t *testing.T, s *FederatedSite, n *graph.Node, owned bool) {
+	if s.graph.AreLinked(s.node, n, topology.OwnershipMetadata) != owned {
+		t.Errorf("Expected node %s to be owned by the site node: %t", n.ID, owned)
+	}
+}
+
+func TestFederatedSiteImport(t *testing.T) {
+	s := newTestFederatedSite(t, "site1")
+
+	sg := newTestGraph(t, "host1")
+	host := sg.NewNode(graph.Identifier("host"), graph.Metadata{"Type": "host", "Name": "host1"}, "host1")
+	netns := sg.NewNode(graph.Identifier("netns"), graph.Metadata{"Type": "netns", "Name": "ns1"}, "host1")
+	ownership := topology.AddOwnershipLink(sg, host, netns, nil, "host1")
+
+	sendSiteMessage(t, s, graph.SyncMsgType, &graph.SyncMsg{Nodes: []*graph.Node{host, netns}, Edges: []*graph.Edge{ownership}})
+
+	importedHost, importedNetns := siteNode(t, s, host.ID), siteNode(t, s, netns.ID)
+	if importedHost.Host() != "site1/host1" {
+		t.Errorf("Expected the host of the node to be prefixed by the site, got: %s", importedHost.Host())
+	}
+
+	edge := s.graph.GetEdge(graph.Identifier("site1/" + string(ownership.ID)))
+	if edge == nil {
+		t.Fatal("Expected the edge to be imported with the prefix of the site")
+	}
+	if edge.GetParent() != importedHost.ID || edge.GetChild() != importedNetns.ID {
+		t.Errorf("Expected the edge to link the imported nodes, got: %s -> %s", edge.GetParent(), edge.GetChild())
+	}
+
+	// the site node only owns the roots of the site graph
+	assertSiteOwned(t, s, importedHost, true)
+	assertSiteOwned(t, s, importedNetns, false)
+
+	intf := sg.NewNode(graph.Identifier("intf"), graph.Metadata{"Type": "veth", "Name": "eth0"}, "host1")
+	sendSiteMessage(t, s, graph.NodeAddedMsgType, intf)
+
+	importedIntf := siteNode(t, s, intf.ID)
+	assertSiteOwned(t, s, importedIntf, true)
+
+	intfOwnership := topology.AddOwnershipLink(sg, netns, intf, nil, "host1")
+	sendSiteMessage(t, s, graph.EdgeAddedMsgType, intfOwnership)
+	assertSiteOwned(t, s, importedIntf, false)
+
+	layer2 := topology.AddLayer2Link(sg, host, intf, nil)
+	sendSiteMessage(t, s, graph.EdgeAddedMsgType, layer2)
+	if !s.graph.AreLinked(importedHost, importedIntf, topology.Layer2Metadata) {
+		t.Error("Expected the layer2 edge to be imported")
+	}
+
+	// the orphaned roots are owned by the site node again
+	sendSiteMessage(t, s, graph.EdgeDeletedMsgType, ownership)
+	if s.graph.GetEdge(edge.ID) != nil {
+		t.Error("Expected the edge to be deleted")
+	}
+	assertSiteOwned(t, s, importedNetns, true)
+
+	sendSiteMessage(t, s, graph.EdgeDeletedMsgType, layer2)
+	assertSiteOwned(t, s, importedIntf, false)
+
+	sendSiteMessage(t, s, graph.NodeDeletedMsgType, netns)
+	if s.graph.GetNode(importedNetns.ID) != nil {
+		t.Error("Expected the node to be deleted")
+	}
+	if s.graph.GetEdge(graph.Identifier("site1/"+string(intfOwnership.ID))) != nil {
+		t.Error("Expected the edges of the deleted node to be deleted")
+	}
+	assertSiteOwned(t, s, importedIntf, true)
+
+	// a resync removes the nodes the site analyzer doesn't have anymore
+	sendSiteMessage(t, s, graph.SyncMsgType, &graph.SyncMsg{Nodes: []*graph.Node{host}})
+	if s.graph.GetNode(importedIntf.ID) != nil {
+		t.Error("Expected the node missing from the sync to be deleted")
+	}
+	assertSiteOwned(t, s, siteNode(t, s, host.ID), true)
+
+	if len(s.nodes) != 1 || len(s.edges) != 0 {
+		t.Errorf("Expected a single node to be tracked, got nodes %v and edges %v", s.nodes, s.edges)
+	}
+}
+
+func TestFederatedSitesPrefix(t *testing.T) {
+	s1 := newTestFederatedSite(t, "site1")
+	s2 := &FederatedSite{Name: "site2", URL: s1.URL, graph: s1.graph, nodes: make(map[graph.Identifier]bool), edges: make(map[graph.Identifier]bool)}
+	s2.node = s1.graph.NewNode(graph.GenIDNameBased(federationNamespace, "site2"), s2.siteMetadata("UP"))
+
+	// the same identifier on two sites gives two nodes
+	sg := newTestGraph(t, "host1")
+	host := sg.NewNode(graph.Identifier("host"), graph.Metadata{"Type": "host", "Name": "host1"}, "host1")
+
+	sendSiteMessage(t, s1, graph.NodeAddedMsgType, host)
+	sendSiteMessage(t, s2, graph.NodeAddedMsgType, host)
+
+	n1, n2 := siteNode(t, s1, host.ID), siteNode(t, s2, host.ID)
+	if n1.ID == n2.ID {
+		t.Fatal("Expected the nodes of the sites to have distinct identifiers")
+	}
+	assertSiteOwned(t, s1, n1, true)
+	assertSiteOwned(t, s2, n2, true)
+
+	sendSiteMessage(t, s1, graph.NodeDeletedMsgType, host)
+	if s1.graph.GetNode(n1.ID) != nil || s2.graph.GetNode(n2.ID) == nil {
+		t.Error("Expected only the node of the first site to be deleted")
+	}
+}
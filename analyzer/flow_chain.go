@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/spaolacci/murmur3"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+)
+
+// flowChain holds the flows sharing a payload tracking ID, started around the
+// same time
+type flowChain struct {
+	id      string
+	start   int64
+	members map[string]bool
+	seen    time.Time
+}
+
+// FlowChainer links the flows carrying the same session while being seen with
+// different addresses, ports or address families, like the IPv4 and IPv6
+// sides of a NAT64 translation or the two sides of a transparent proxy. The
+// flows of a chain share their ChainID. Implements the FlowListener interface.
+type FlowChainer struct {
+	sync.Mutex
+	window    int64
+	ttl       time.Duration
+	chains    map[string][]*flowChain
+	lastPurge time.Time
+}
+
+// chain returns the chain of the flow, creating it if needed
+func (fc *FlowChainer) chain(f *flow.Flow) *flowChain {
+	for _, c := range fc.chains[f.PayloadTrackingID] {
+		if d := f.Start - c.start; d <= fc.window && d >= -fc.window {
+			return c
+		}
+	}
+
+	hasher := murmur3.New64()
+	hasher.Write([]byte(f.PayloadTrackingID))
+	value64 := make([]byte, 8)
+	binary.BigEndian.PutUint64(value64, uint64(f.Start))
+	hasher.Write(value64)
+
+	c := &flowChain{
+		id:      hex.EncodeToString(hasher.Sum(nil)),
+		start:   f.Start,
+		members: make(map[string]bool),
+	}
+	fc.chains[f.PayloadTrackingID] = append(fc.chains[f.PayloadTrackingID], c)
+	return c
+}
+
+// purge removes the chains without any update for the ttl
+func (fc *FlowChainer) purge(now time.Time) {
+	for id, chains := range fc.chains {
+		var alive []*flowChain
+		for _, c := range chains {
+			if now.Sub(c.seen) < fc.ttl {
+				alive = append(alive, c)
+			}
+		}
+
+		if len(alive) == 0 {
+			delete(fc.chains, id)
+		} else {
+			fc.chains[id] = alive
+		}
+	}
+	fc.lastPurge = now
+}
+
+// OnFlows sets the ChainID of the flows once their chain holds flows seen
+// with different addresses. The same flow captured at several points of the
+// path, with the same L3TrackingID, is not enough to make a chain.
+func (fc *FlowChainer) OnFlows(flows []*flow.Flow) {
+	fc.Lock()
+	defer fc.Unlock()
+
+	now := time.Now()
+	for _, f := range flows {
+		if f.PayloadTrackingID == "" {
+			continue
+		}
+
+		c := fc.chain(f)
+		c.members[f.L3TrackingID] = true
+		c.seen = now
+
+		if len(c.members) > 1 {
+			f.ChainID = c.id
+		}
+	}
+
+	if now.Sub(fc.lastPurge) > fc.ttl {
+		fc.purge(now)
+	}
+}
+
+// NewFlowChainer returns a new flow chainer linking the flows started within
+// window, the chains being forgotten after ttl without any update
+func NewFlowChainer(window, ttl time.Duration) *FlowChainer {
+	return &FlowChainer{
+		window:    int64(window / time.Millisecond),
+		ttl:       ttl,
+		chains:    make(map[string][]*flowChain),
+		lastPurge: time.Now(),
+	}
+}
+
+// NewFlowChainerFromConfig returns a new flow chainer, nil if disabled
+func NewFlowChainerFromConfig() *FlowChainer {
+	if !config.GetBool("analyzer.flow_chain.enabled") {
+		return nil
+	}
+
+	window := time.Duration(config.GetInt("analyzer.flow_chain.window")) * time.Second
+	ttl := time.Duration(config.GetInt("flow.expire")) * time.Second
+	return NewFlowChainer(window, ttl)
+}
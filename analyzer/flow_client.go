@@ -29,6 +29,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/skydive-project/skydive/common"
@@ -43,6 +44,18 @@ type FlowClientPool struct {
 	common.RWMutex
 	shttp.DefaultWSSpeakerEventHandler
 	flowClients []*FlowClient
+	edge        *edgeFlowBuffer
+}
+
+// edgeFlowBuffer keeps the flows of an edge agent while its WAN link is down.
+// The flows are uploaded to the analyzers by compressed batches, the oldest
+// ones being dropped when the buffer is full.
+type edgeFlowBuffer struct {
+	sync.Mutex
+	flows    [][]byte
+	size     int
+	batch    int
+	flushing bool
 }
 
 // FlowClient describes a flow client connection
@@ -70,6 +83,79 @@ type FlowClientWebSocketConn struct {
 	shttp.DefaultWSSpeakerEventHandler
 	url      *url.URL
 	wsClient *shttp.WSClient
+	edge     *edgeFlowBuffer
+}
+
+func (b *edgeFlowBuffer) push(flows []*flow.Flow) {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, f := range flows {
+		data, err := f.GetData()
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to encode flow: %s", err.Error())
+			continue
+		}
+		b.flows = append(b.flows, data)
+	}
+
+	if dropped := len(b.flows) - b.size; dropped > 0 {
+		logging.GetLogger().Warningf("Edge flow buffer full, dropping the %d oldest flows", dropped)
+		b.flows = append([][]byte{}, b.flows[dropped:]...)
+	}
+}
+
+// flush uploads the buffered flows through the given connection, the flows
+// of a batch that can't be sent being kept for the next connection
+func (b *edgeFlowBuffer) flush(c *FlowClientWebSocketConn) {
+	b.Lock()
+	if b.flushing {
+		b.Unlock()
+		return
+	}
+	b.flushing = true
+	b.Unlock()
+
+	defer func() {
+		b.Lock()
+		b.flushing = false
+		b.Unlock()
+	}()
+
+	for {
+		b.Lock()
+		n := len(b.flows)
+		if b.batch > 0 && n > b.batch {
+			n = b.batch
+		}
+		batch := b.flows[:n]
+		b.flows = b.flows[n:]
+		b.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		data, err := flow.EncodeBatch(batch)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to encode a batch of flows: %s", err.Error())
+			continue
+		}
+
+		if err := c.wsClient.SendRaw(data); err != nil {
+			b.Lock()
+			b.flows = append(append([][]byte{}, batch...), b.flows...)
+			b.Unlock()
+			return
+		}
+	}
+}
+
+// OnConnected uploads the flows buffered by an edge agent
+func (c *FlowClientWebSocketConn) OnConnected(ws shttp.WSSpeaker) {
+	if c.edge != nil {
+		go c.edge.flush(c)
+	}
 }
 
 // Close the connection
@@ -92,7 +178,7 @@ func (c *FlowClientUDPConn) Send(data []byte) error {
 
 // NewFlowClientUDPConn returns a new UDP flow client
 func NewFlowClientUDPConn(addr string, port int) (*FlowClientUDPConn, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", addr, port))
+	udpAddr, err := net.ResolveUDPAddr(common.IPNetwork("udp"), common.JoinHostPort(addr, port))
 	if err != nil {
 		return nil, err
 	}
@@ -113,8 +199,8 @@ func (c *FlowClientWebSocketConn) Connect() error {
 	authPort, _ := strconv.Atoi(c.url.Port())
 	authClient := shttp.NewAuthenticationClient(config.GetURL("http", authAddr, authPort, ""), authOptions)
 	c.wsClient = shttp.NewWSClientFromConfig(common.AgentService, c.url, authClient, nil)
-	c.wsClient.Connect()
 	c.wsClient.AddEventHandler(c)
+	c.wsClient.Connect()
 
 	return nil
 }
@@ -174,16 +260,30 @@ func (c *FlowClient) SendFlows(flows []*flow.Flow) {
 
 // NewFlowClient creates a flow client and creates a new connection to the server
 func NewFlowClient(addr string, port int) (*FlowClient, error) {
+	return newFlowClient(addr, port, nil)
+}
+
+// newFlowClient creates a flow client, the flows of an edge agent being
+// uploaded from its buffer through a WebSocket connection
+func newFlowClient(addr string, port int, edge *edgeFlowBuffer) (*FlowClient, error) {
 	var (
 		connection FlowClientConn
 		err        error
 	)
 	protocol := strings.ToLower(config.GetString("flow.protocol"))
+	if edge != nil {
+		protocol = "websocket"
+	}
+
 	switch protocol {
 	case "udp":
 		connection, err = NewFlowClientUDPConn(common.NormalizeAddrForURL(addr), port)
 	case "websocket":
-		connection, err = NewFlowClientWebSocketConn(config.GetURL("ws", common.NormalizeAddrForURL(addr), port, "/ws/flow"))
+		var wsConn *FlowClientWebSocketConn
+		if wsConn, err = NewFlowClientWebSocketConn(config.GetURL("ws", common.NormalizeAddrForURL(addr), port, "/ws/flow")); err == nil {
+			wsConn.edge = edge
+			connection = wsConn
+		}
 	default:
 		return nil, fmt.Errorf("Invalid protocol %s", protocol)
 	}
@@ -213,7 +313,7 @@ func (p *FlowClientPool) OnConnected(c shttp.WSSpeaker) {
 		}
 	}
 
-	flowClient, err := NewFlowClient(addr, port)
+	flowClient, err := newFlowClient(addr, port, p.edge)
 	if err != nil {
 		logging.GetLogger().Error(err)
 		return
@@ -237,8 +337,13 @@ func (p *FlowClientPool) OnDisconnected(c shttp.WSSpeaker) {
 	}
 }
 
-// SendFlows sends flows using a random connection
+// SendFlows sends flows using a random connection. The flows of an edge
+// agent are buffered and uploaded by a connected client.
 func (p *FlowClientPool) SendFlows(flows []*flow.Flow) {
+	if p.edge != nil {
+		p.edge.push(flows)
+	}
+
 	p.RLock()
 	defer p.RUnlock()
 
@@ -246,6 +351,16 @@ func (p *FlowClientPool) SendFlows(flows []*flow.Flow) {
 		return
 	}
 
+	if p.edge != nil {
+		for _, i := range rand.Perm(len(p.flowClients)) {
+			if c, ok := p.flowClients[i].flowClientConn.(*FlowClientWebSocketConn); ok && c.wsClient.IsConnected() {
+				go p.edge.flush(c)
+				return
+			}
+		}
+		return
+	}
+
 	fc := p.flowClients[rand.Intn(len(p.flowClients))]
 	fc.SendFlows(flows)
 }
@@ -264,6 +379,13 @@ func NewFlowClientPool(pool shttp.WSSpeakerPool) *FlowClientPool {
 	p := &FlowClientPool{
 		flowClients: make([]*FlowClient, 0),
 	}
+
+	if config.GetBool("agent.edge.enabled") {
+		p.edge = &edgeFlowBuffer{
+			size:  config.GetInt("agent.edge.flow_buffer"),
+			batch: config.GetInt("agent.edge.flow_batch"),
+		}
+	}
 	pool.AddEventHandler(p)
 	return p
 }
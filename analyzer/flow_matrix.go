@@ -0,0 +1,365 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Namespace and message types of the flow matrix websocket endpoint
+const (
+	FlowMatrixNamespace       = "FlowMatrix"
+	FlowMatrixSnapshotMsgType = "FlowMatrixSnapshot"
+	FlowMatrixUpdateMsgType   = "FlowMatrixUpdate"
+)
+
+// Groupings of the flow matrix endpoints
+const (
+	FlowMatrixGroupByHost      = "host"
+	FlowMatrixGroupByNamespace = "namespace"
+)
+
+const unknownMatrixGroup = "unknown"
+
+// FlowMatrixCell holds the traffic sent by a group to another one
+type FlowMatrixCell struct {
+	Source      string
+	Destination string
+	Bytes       int64
+	Packets     int64
+}
+
+// FlowMatrixSnapshot describes the traffic exchanged between the groups
+// over the whole window
+type FlowMatrixSnapshot struct {
+	GroupBy  string
+	Start    int64
+	Last     int64
+	Interval int64
+	Cells    []*FlowMatrixCell
+}
+
+// FlowMatrixUpdate describes the traffic exchanged between the groups during
+// the last interval. Expired holds the traffic of the interval leaving the
+// window, that has to be subtracted from the snapshot by the clients.
+type FlowMatrixUpdate struct {
+	Start   int64
+	Last    int64
+	Cells   []*FlowMatrixCell
+	Expired []*FlowMatrixCell
+}
+
+type matrixKey struct {
+	src, dst string
+}
+
+type matrixTraffic struct {
+	bytes, packets int64
+}
+
+type matrixSlot map[matrixKey]*matrixTraffic
+
+// matrixFlow holds the endpoints and the traffic of a flow during an interval
+type matrixFlow struct {
+	linkA, linkB string
+	netA, netB   string
+	ab, ba       matrixTraffic
+}
+
+// FlowMatrix maintains the matrix of the traffic exchanged between the hosts
+// or the network namespaces from the flows received by the analyzer and
+// streams its variations to the clients of the /ws/flowmatrix endpoint.
+// Clients receive a snapshot of the matrix over the window when connecting,
+// then an update every interval.
+type FlowMatrix struct {
+	sync.RWMutex
+	shttp.DefaultWSSpeakerEventHandler
+	graph    *graph.Graph
+	server   *shttp.WSStructServer
+	groupBy  string
+	interval time.Duration
+	pending  map[string]*matrixFlow
+	slots    []matrixSlot
+	current  int
+	total    matrixSlot
+	start    time.Time
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func (s matrixSlot) add(src, dst string, t matrixTraffic) {
+	if t.bytes == 0 && t.packets == 0 {
+		return
+	}
+
+	key := matrixKey{src: src, dst: dst}
+	traffic, ok := s[key]
+	if !ok {
+		traffic = &matrixTraffic{}
+		s[key] = traffic
+	}
+	traffic.bytes += t.bytes
+	traffic.packets += t.packets
+}
+
+func (s matrixSlot) cells() []*FlowMatrixCell {
+	cells := make([]*FlowMatrixCell, 0, len(s))
+	for key, t := range s {
+		cells = append(cells, &FlowMatrixCell{Source: key.src, Destination: key.dst, Bytes: t.bytes, Packets: t.packets})
+	}
+
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Source == cells[j].Source {
+			return cells[i].Destination < cells[j].Destination
+		}
+		return cells[i].Source < cells[j].Source
+	})
+	return cells
+}
+
+// OnFlows accumulates the traffic of the flows until the next update. A flow
+// captured at several points of the path is only accounted once, from the
+// capture that saw the most traffic. Implements the FlowListener interface.
+func (fm *FlowMatrix) OnFlows(flows []*flow.Flow) {
+	fm.Lock()
+	defer fm.Unlock()
+
+	for _, f := range flows {
+		m := f.GetLastUpdateMetric()
+		if m == nil {
+			continue
+		}
+
+		key := f.TrackingID
+		if key == "" {
+			key = f.UUID
+		}
+
+		mf := &matrixFlow{
+			linkA: f.GetLink().GetA(),
+			linkB: f.GetLink().GetB(),
+			netA:  f.GetNetwork().GetA(),
+			netB:  f.GetNetwork().GetB(),
+			ab:    matrixTraffic{bytes: m.ABBytes, packets: m.ABPackets},
+			ba:    matrixTraffic{bytes: m.BABytes, packets: m.BAPackets},
+		}
+
+		if prev, ok := fm.pending[key]; ok && prev.ab.bytes+prev.ba.bytes >= mf.ab.bytes+mf.ba.bytes {
+			continue
+		}
+		fm.pending[key] = mf
+	}
+}
+
+// group returns the matrix group of a flow endpoint. The caller has to hold
+// the lock of the graph.
+func (fm *FlowMatrix) group(n *graph.Node) string {
+	if n == nil || n.Host() == "" {
+		return unknownMatrixGroup
+	}
+
+	if fm.groupBy == FlowMatrixGroupByNamespace {
+		for _, parent := range fm.graph.LookupParents(n, graph.Metadata{"Type": "netns"}, topology.OwnershipMetadata) {
+			if name, _ := parent.GetFieldString("Name"); name != "" {
+				return n.Host() + "/" + name
+			}
+		}
+	}
+
+	return n.Host()
+}
+
+// resolve aggregates the pending flows by groups
+func (fm *FlowMatrix) resolve(pending map[string]*matrixFlow) matrixSlot {
+	slot := make(matrixSlot)
+	if len(pending) == 0 {
+		return slot
+	}
+
+	fm.graph.RLock()
+	defer fm.graph.RUnlock()
+
+	index := topology.NewAddressIndex(fm.graph)
+	for _, mf := range pending {
+		a := fm.group(index.Lookup(mf.linkA, mf.netA))
+		b := fm.group(index.Lookup(mf.linkB, mf.netB))
+
+		slot.add(a, b, mf.ab)
+		slot.add(b, a, mf.ba)
+	}
+	return slot
+}
+
+func (fm *FlowMatrix) update(now time.Time) {
+	fm.Lock()
+	pending := fm.pending
+	fm.pending = make(map[string]*matrixFlow)
+	fm.Unlock()
+
+	slot := fm.resolve(pending)
+
+	fm.Lock()
+	expired := fm.slots[fm.current]
+	fm.slots[fm.current] = slot
+	fm.current = (fm.current + 1) % len(fm.slots)
+
+	for key, t := range slot {
+		fm.total.add(key.src, key.dst, *t)
+	}
+	for key, t := range expired {
+		fm.total.add(key.src, key.dst, matrixTraffic{bytes: -t.bytes, packets: -t.packets})
+		if total, ok := fm.total[key]; ok && total.bytes == 0 && total.packets == 0 {
+			delete(fm.total, key)
+		}
+	}
+	fm.Unlock()
+
+	if len(slot) == 0 && len(expired) == 0 {
+		return
+	}
+
+	update := &FlowMatrixUpdate{
+		Start:   common.UnixMillis(now.Add(-fm.interval)),
+		Last:    common.UnixMillis(now),
+		Cells:   slot.cells(),
+		Expired: expired.cells(),
+	}
+	fm.server.BroadcastMessage(shttp.NewWSStructMessage(FlowMatrixNamespace, FlowMatrixUpdateMsgType, update))
+
+	logging.GetLogger().Debugf("Flow matrix updated with %d flows", len(pending))
+}
+
+// Snapshot returns the traffic exchanged between the groups over the window
+func (fm *FlowMatrix) Snapshot() *FlowMatrixSnapshot {
+	fm.RLock()
+	defer fm.RUnlock()
+
+	now := time.Now()
+	start := now.Add(-fm.interval * time.Duration(len(fm.slots)))
+	if start.Before(fm.start) {
+		start = fm.start
+	}
+
+	return &FlowMatrixSnapshot{
+		GroupBy:  fm.groupBy,
+		Start:    common.UnixMillis(start),
+		Last:     common.UnixMillis(now),
+		Interval: int64(fm.interval / time.Millisecond),
+		Cells:    fm.total.cells(),
+	}
+}
+
+// OnConnected sends the current matrix to the new client
+func (fm *FlowMatrix) OnConnected(c shttp.WSSpeaker) {
+	c.SendMessage(shttp.NewWSStructMessage(FlowMatrixNamespace, FlowMatrixSnapshotMsgType, fm.Snapshot()))
+}
+
+func (fm *FlowMatrix) run() {
+	defer fm.wg.Done()
+
+	ticker := time.NewTicker(fm.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			fm.update(now)
+		case <-fm.quit:
+			return
+		}
+	}
+}
+
+// Start the flow matrix
+func (fm *FlowMatrix) Start() {
+	fm.start = time.Now()
+	fm.server.Start()
+
+	fm.wg.Add(1)
+	go fm.run()
+}
+
+// Stop the flow matrix
+func (fm *FlowMatrix) Stop() {
+	close(fm.quit)
+	fm.wg.Wait()
+	fm.server.Stop()
+}
+
+// NewFlowMatrix returns a new flow matrix grouping the flow endpoints by host
+// or by network namespace, updated every interval and kept over window
+func NewFlowMatrix(server *shttp.WSStructServer, g *graph.Graph, groupBy string, interval, window time.Duration) *FlowMatrix {
+	count := int(window / interval)
+	if count < 1 {
+		count = 1
+	}
+
+	fm := &FlowMatrix{
+		graph:    g,
+		server:   server,
+		groupBy:  groupBy,
+		interval: interval,
+		pending:  make(map[string]*matrixFlow),
+		slots:    make([]matrixSlot, count),
+		total:    make(matrixSlot),
+		quit:     make(chan struct{}),
+	}
+
+	server.AddEventHandler(fm)
+	return fm
+}
+
+// NewFlowMatrixFromConfig returns a new flow matrix served on /ws/flowmatrix,
+// nil if disabled
+func NewFlowMatrixFromConfig(hserver *shttp.Server, g *graph.Graph, limit shttp.WSRateLimit) (*FlowMatrix, error) {
+	if !config.GetBool("analyzer.flow_matrix.enabled") {
+		return nil, nil
+	}
+
+	groupBy := config.GetString("analyzer.flow_matrix.group_by")
+	if groupBy != FlowMatrixGroupByHost && groupBy != FlowMatrixGroupByNamespace {
+		return nil, fmt.Errorf("Invalid flow matrix grouping %s, has to be host or namespace", groupBy)
+	}
+
+	interval := time.Duration(config.GetInt("analyzer.flow_matrix.interval")) * time.Second
+	window := time.Duration(config.GetInt("analyzer.flow_matrix.window")) * time.Second
+	if interval <= 0 || window < interval {
+		return nil, errors.New("Flow matrix interval has to be positive and lower than the window")
+	}
+
+	server := shttp.NewWSStructServer(shttp.NewWSServer(hserver, "/ws/flowmatrix"))
+	server.SetRateLimit(limit)
+
+	return NewFlowMatrix(server, g, groupBy, interval, window), nil
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func newTestFlowMatrix(t *testing.T, groupBy string) *FlowMatrix {
+	g := newTestGraph(t, "matrix")
+
+	g.Lock()
+	netns := g.NewNode(graph.GenID(), graph.Metadata{"Type": "netns", "Name": "ns1"}, "host1")
+	intf1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "intf1", "IPV4": []string{"10.0.0.1/24"}}, "host1")
+	topology.AddOwnershipLink(g, netns, intf1, nil)
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "intf2", "IPV4": []string{"10.0.0.2/24"}}, "host1")
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "intf3", "IPV4": []string{"10.0.0.3/24"}}, "host2")
+	g.Unlock()
+
+	hserver := shttp.NewServer("matrix", common.AnalyzerService, "127.0.0.1", 0, shttp.NewNoAuthenticationBackend(), "")
+	server := shttp.NewWSStructServer(shttp.NewWSServer(hserver, "/ws/flowmatrix"))
+
+	return NewFlowMatrix(server, g, groupBy, time.Second, 2*time.Second)
+}
+
+func jsonString(obj interface{}) string {
+	data, _ := json.Marshal(obj)
+	return string(data)
+}
+
+func newTestMatrixFlow(uuid, trackingID, a, b string, metric *flow.FlowMetric) *flow.Flow {
+	return &flow.Flow{
+		UUID:             uuid,
+		TrackingID:       trackingID,
+		Network:          &flow.FlowLayer{A: a, B: b},
+		LastUpdateMetric: metric,
+	}
+}
+
+// testMatrixFlows are the flows of a capture at several points of the path,
+// of which the most complete one is accounted, along with flows to unknown
+// endpoints and without traffic
+var testMatrixFlows = []*flow.Flow{
+	newTestMatrixFlow("aaa", "t1", "10.0.0.1", "10.0.0.3", &flow.FlowMetric{ABBytes: 100, ABPackets: 1, BABytes: 50, BAPackets: 1}),
+	newTestMatrixFlow("bbb", "t1", "10.0.0.1", "10.0.0.3", &flow.FlowMetric{ABBytes: 200, ABPackets: 2, BABytes: 100, BAPackets: 2}),
+	newTestMatrixFlow("ccc", "t1", "10.0.0.1", "10.0.0.3", &flow.FlowMetric{ABBytes: 10, ABPackets: 1}),
+	newTestMatrixFlow("ddd", "", "10.0.0.2", "10.0.0.3", &flow.FlowMetric{ABBytes: 30, ABPackets: 3}),
+	newTestMatrixFlow("eee", "", "10.0.0.1", "192.168.0.1", &flow.FlowMetric{ABBytes: 5, ABPackets: 1}),
+	newTestMatrixFlow("fff", "", "10.0.0.2", "10.0.0.3", nil),
+}
+
+func TestFlowMatrixGroupByHost(t *testing.T) {
+	fm := newTestFlowMatrix(t, FlowMatrixGroupByHost)
+	fm.OnFlows(testMatrixFlows)
+
+	if len(fm.pending) != 3 {
+		t.Fatalf("Expected a pending flow per tracking ID, got: %d", len(fm.pending))
+	}
+
+	expected := []*FlowMatrixCell{
+		{Source: "host1", Destination: "host2", Bytes: 230, Packets: 5},
+		{Source: "host1", Destination: unknownMatrixGroup, Bytes: 5, Packets: 1},
+		{Source: "host2", Destination: "host1", Bytes: 100, Packets: 2},
+	}
+	if cells := fm.resolve(fm.pending).cells(); !reflect.DeepEqual(cells, expected) {
+		t.Errorf("Expected the cells %s, got: %s", jsonString(expected), jsonString(cells))
+	}
+}
+
+func TestFlowMatrixGroupByNamespace(t *testing.T) {
+	fm := newTestFlowMatrix(t, FlowMatrixGroupByNamespace)
+	fm.OnFlows(testMatrixFlows)
+
+	expected := []*FlowMatrixCell{
+		{Source: "host1", Destination: "host2", Bytes: 30, Packets: 3},
+		{Source: "host1/ns1", Destination: "host2", Bytes: 200, Packets: 2},
+		{Source: "host1/ns1", Destination: unknownMatrixGroup, Bytes: 5, Packets: 1},
+		{Source: "host2", Destination: "host1/ns1", Bytes: 100, Packets: 2},
+	}
+	if cells := fm.resolve(fm.pending).cells(); !reflect.DeepEqual(cells, expected) {
+		t.Errorf("Expected the cells %s, got: %s", jsonString(expected), jsonString(cells))
+	}
+}
+
+func TestFlowMatrixWindow(t *testing.T) {
+	fm := newTestFlowMatrix(t, FlowMatrixGroupByHost)
+	now := time.Now()
+
+	fm.OnFlows(testMatrixFlows[:2])
+	fm.update(now)
+
+	fm.OnFlows(testMatrixFlows[3:4])
+	fm.update(now.Add(time.Second))
+
+	expected := []*FlowMatrixCell{
+		{Source: "host1", Destination: "host2", Bytes: 230, Packets: 5},
+		{Source: "host2", Destination: "host1", Bytes: 100, Packets: 2},
+	}
+	if cells := fm.Snapshot().Cells; !reflect.DeepEqual(cells, expected) {
+		t.Errorf("Expected the traffic of both intervals, got: %s", jsonString(cells))
+	}
+
+	// the first interval leaves the window of 2 intervals
+	fm.update(now.Add(2 * time.Second))
+
+	expected = []*FlowMatrixCell{
+		{Source: "host1", Destination: "host2", Bytes: 30, Packets: 3},
+	}
+	if cells := fm.Snapshot().Cells; !reflect.DeepEqual(cells, expected) {
+		t.Errorf("Expected the traffic of the last interval, got: %s", jsonString(cells))
+	}
+
+	fm.update(now.Add(3 * time.Second))
+	if cells := fm.Snapshot().Cells; len(cells) != 0 {
+		t.Errorf("Expected no traffic once all the intervals expired, got: %s", jsonString(cells))
+	}
+}
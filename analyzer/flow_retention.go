@@ -0,0 +1,287 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// FlowRetentionClassConfig describes a retention class in the configuration
+type FlowRetentionClassConfig struct {
+	Name  string                   `mapstructure:"name"`
+	TTL   int                      `mapstructure:"ttl"`
+	Match []map[string]interface{} `mapstructure:"match"`
+}
+
+// retentionClass is a retention class with the filter of its flows, nil
+// for the default class without rules
+type retentionClass struct {
+	name   string
+	ttl    time.Duration
+	filter *filters.Filter
+}
+
+// FlowRetainer assigns a retention class to the flows, the first class of
+// which a rule matches the flow or else the default class, and sets until
+// when the flows are kept from the TTL of their class. The flows retained
+// until before now are periodically deleted from the flow storage by the
+// master analyzer, instead of being kept as long as their index. Implements
+// the FlowListener interface.
+type FlowRetainer struct {
+	*etcd.MasterElector
+	classes      []*retentionClass
+	defaultClass *retentionClass
+	storage      storage.ExpirationStorage
+	interval     time.Duration
+	quit         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// matchValueToFilter returns the filter of a field of a rule, * matching
+// any value and the CIDRs matching the addresses they contain
+func matchValueToFilter(key string, value interface{}) (*filters.Filter, error) {
+	if s, ok := value.(string); ok {
+		if s == "*" {
+			return filters.NewNotFilter(filters.NewTermStringFilter(key, "")), nil
+		}
+
+		if _, _, err := net.ParseCIDR(s); err == nil {
+			rf, err := filters.NewIPV4RangeFilter(key, s)
+			if err != nil {
+				return nil, err
+			}
+			return &filters.Filter{IPV4RangeFilter: rf}, nil
+		}
+	}
+	return traversal.KeyValueToFilter(key, value)
+}
+
+// ruleToFilter returns the filter of a rule, made of the fields the flows
+// must match, Link, Network and Transport matching either of their sides
+func ruleToFilter(rule map[string]interface{}) (*filters.Filter, error) {
+	keys := make([]string, 0, len(rule))
+	for key := range rule {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var andFilters []*filters.Filter
+	for _, key := range keys {
+		switch key {
+		case "Link", "Network", "Transport":
+			// the addresses are strings and the ports numbers, either
+			// of them possibly written as the other type
+			value := rule[key]
+			if value != "*" {
+				if key == "Transport" {
+					port, err := common.ToInt64(value)
+					if err != nil {
+						return nil, fmt.Errorf("Invalid port %v", value)
+					}
+					value = port
+				} else {
+					value = fmt.Sprintf("%v", value)
+				}
+			}
+			fa, err := matchValueToFilter(key+".A", value)
+			if err != nil {
+				return nil, err
+			}
+			fb, err := matchValueToFilter(key+".B", value)
+			if err != nil {
+				return nil, err
+			}
+			andFilters = append(andFilters, filters.NewOrFilter(fa, fb))
+		default:
+			filter, err := matchValueToFilter(key, rule[key])
+			if err != nil {
+				return nil, err
+			}
+			andFilters = append(andFilters, filter)
+		}
+	}
+	return filters.NewAndFilter(andFilters...), nil
+}
+
+// newRetentionClasses returns the retention classes of the configuration,
+// in order, and the default class if named
+func newRetentionClasses(configs []*FlowRetentionClassConfig, defaultName string) ([]*retentionClass, *retentionClass, error) {
+	var classes []*retentionClass
+	names := make(map[string]*retentionClass)
+	for i, cc := range configs {
+		if cc.Name == "" || cc.TTL <= 0 {
+			return nil, nil, fmt.Errorf("Retention class %d: name and a positive ttl are mandatory", i)
+		}
+		if _, found := names[cc.Name]; found {
+			return nil, nil, fmt.Errorf("Retention class %s defined twice", cc.Name)
+		}
+
+		var ruleFilters []*filters.Filter
+		for _, rule := range cc.Match {
+			filter, err := ruleToFilter(rule)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Retention class %s: invalid rule %v: %s", cc.Name, rule, err.Error())
+			}
+			ruleFilters = append(ruleFilters, filter)
+		}
+
+		c := &retentionClass{name: cc.Name, ttl: time.Duration(cc.TTL) * time.Second}
+		if len(ruleFilters) > 0 {
+			c.filter = filters.NewOrFilter(ruleFilters...)
+		}
+		classes = append(classes, c)
+		names[cc.Name] = c
+	}
+
+	var defaultClass *retentionClass
+	if defaultName != "" {
+		if defaultClass = names[defaultName]; defaultClass == nil {
+			return nil, nil, fmt.Errorf("Unknown default retention class %s", defaultName)
+		}
+	}
+
+	return classes, defaultClass, nil
+}
+
+// classify returns the retention class of the flow, nil if none
+func (r *FlowRetainer) classify(f *flow.Flow) *retentionClass {
+	for _, c := range r.classes {
+		if c.filter != nil && c.filter.Eval(f) {
+			return c
+		}
+	}
+	return r.defaultClass
+}
+
+// OnFlows sets the retention class of the flows and until when they are
+// kept after their last update
+func (r *FlowRetainer) OnFlows(flows []*flow.Flow) {
+	for _, f := range flows {
+		if c := r.classify(f); c != nil {
+			f.RetentionClass = c.name
+			f.RetainUntil = f.Last + int64(c.ttl/time.Millisecond)
+		}
+	}
+}
+
+func (r *FlowRetainer) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !r.IsMaster() {
+				continue
+			}
+
+			if err := r.storage.DeleteExpiredFlows(common.UnixMillis(time.Now())); err != nil {
+				logging.GetLogger().Errorf("Failed to delete the expired flows: %s", err)
+			}
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// Start the deletion of the expired flows
+func (r *FlowRetainer) Start() {
+	if r.storage == nil {
+		return
+	}
+
+	r.StartAndWait()
+
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Stop the deletion of the expired flows
+func (r *FlowRetainer) Stop() {
+	if r.storage == nil {
+		return
+	}
+
+	close(r.quit)
+	r.wg.Wait()
+	r.MasterElector.Stop()
+}
+
+// NewFlowRetainerFromConfig returns a new flow retainer of the retention
+// classes of the configuration, nil if disabled
+func NewFlowRetainerFromConfig(store storage.Storage, etcdClient *etcd.Client) (*FlowRetainer, error) {
+	if !config.GetBool("analyzer.flow_retention.enabled") {
+		return nil, nil
+	}
+
+	var configs []*FlowRetentionClassConfig
+	if err := config.GetConfig().UnmarshalKey("analyzer.flow_retention.classes", &configs); err != nil {
+		return nil, fmt.Errorf("Invalid analyzer.flow_retention.classes: %s", err.Error())
+	}
+
+	if len(configs) == 0 {
+		return nil, errors.New("analyzer.flow_retention.classes has to define at least one class")
+	}
+
+	interval := time.Duration(config.GetInt("analyzer.flow_retention.interval")) * time.Second
+	if interval <= 0 {
+		return nil, errors.New("analyzer.flow_retention.interval must be a positive number of seconds")
+	}
+
+	classes, defaultClass, err := newRetentionClasses(configs, config.GetString("analyzer.flow_retention.default"))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &FlowRetainer{
+		MasterElector: etcd.NewMasterElectorFromConfig(common.AnalyzerService, "flow-retainer", etcdClient),
+		classes:       classes,
+		defaultClass:  defaultClass,
+		interval:      interval,
+		quit:          make(chan struct{}),
+	}
+
+	if expiration, ok := store.(storage.ExpirationStorage); ok {
+		r.storage = expiration
+	} else {
+		logging.GetLogger().Warning("The flow storage doesn't delete the expired flows, the retention classes are only set")
+	}
+
+	return r, nil
+}
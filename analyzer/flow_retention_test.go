@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+var testRetentionClasses = []*FlowRetentionClassConfig{
+	{
+		Name: "security-relevant",
+		TTL:  365 * 24 * 3600,
+		Match: []map[string]interface{}{
+			{"ThreatFeed": "*"},
+			{"Transport": 22},
+		},
+	},
+	{
+		Name: "internal-dns",
+		TTL:  24 * 3600,
+		Match: []map[string]interface{}{
+			{"Network": "10.0.0.0/8", "Application": "DNS"},
+			{"Network": "192.168.0.53", "Transport": "53"},
+		},
+	},
+	{
+		Name: "bulk",
+		TTL:  7 * 24 * 3600,
+	},
+}
+
+func newTestRetentionFlow(application, a, b string, portA, portB int64) *flow.Flow {
+	return &flow.Flow{
+		Application: application,
+		Network:     &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: a, B: b},
+		Transport:   &flow.TransportLayer{Protocol: flow.FlowProtocol_TCP, A: portA, B: portB},
+		Start:       1000,
+		Last:        5000,
+	}
+}
+
+func TestFlowRetentionClasses(t *testing.T) {
+	classes, defaultClass, err := newRetentionClasses(testRetentionClasses, "bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &FlowRetainer{classes: classes, defaultClass: defaultClass}
+
+	threat := newTestRetentionFlow("TCP", "10.0.0.1", "198.51.100.1", 43210, 443)
+	threat.ThreatFeed = "feed"
+
+	tests := []struct {
+		name  string
+		flow  *flow.Flow
+		class string
+		ttl   time.Duration
+	}{
+		{"threat", threat, "security-relevant", 365 * 24 * time.Hour},
+		{"ssh server side", newTestRetentionFlow("TCP", "10.0.0.1", "10.0.0.2", 43210, 22), "security-relevant", 365 * 24 * time.Hour},
+		{"ssh client side", newTestRetentionFlow("TCP", "10.0.0.2", "10.0.0.1", 22, 43210), "security-relevant", 365 * 24 * time.Hour},
+		{"internal dns", newTestRetentionFlow("DNS", "172.16.0.1", "10.1.2.3", 43210, 53), "internal-dns", 24 * time.Hour},
+		{"both rule fields", newTestRetentionFlow("TCP", "192.168.0.53", "192.168.0.1", 53, 43210), "internal-dns", 24 * time.Hour},
+		{"external dns", newTestRetentionFlow("DNS", "172.16.0.1", "8.8.8.8", 43210, 53), "bulk", 7 * 24 * time.Hour},
+		{"one rule field", newTestRetentionFlow("TCP", "10.0.0.1", "10.0.0.2", 43210, 80), "bulk", 7 * 24 * time.Hour},
+	}
+
+	for _, test := range tests {
+		r.OnFlows([]*flow.Flow{test.flow})
+
+		if test.flow.RetentionClass != test.class {
+			t.Errorf("Expected the %s flow to be in the class %s, got: %s", test.name, test.class, test.flow.RetentionClass)
+		}
+		if until := test.flow.Last + int64(test.ttl/time.Millisecond); test.flow.RetainUntil != until {
+			t.Errorf("Expected the %s flow to be retained until %d, got: %d", test.name, until, test.flow.RetainUntil)
+		}
+	}
+
+	// the retention is extended by the updates of the flow
+	f := newTestRetentionFlow("TCP", "10.0.0.1", "10.0.0.2", 43210, 80)
+	r.OnFlows([]*flow.Flow{f})
+	f.Last = 65000
+	r.OnFlows([]*flow.Flow{f})
+	if until := int64(65000 + 7*24*3600*1000); f.RetainUntil != until {
+		t.Errorf("Expected the flow to be retained from its last update until %d, got: %d", until, f.RetainUntil)
+	}
+}
+
+func TestFlowRetentionWithoutDefault(t *testing.T) {
+	classes, defaultClass, err := newRetentionClasses(testRetentionClasses, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &FlowRetainer{classes: classes, defaultClass: defaultClass}
+
+	// the class without rules doesn't match any flow
+	f := newTestRetentionFlow("TCP", "10.0.0.1", "10.0.0.2", 43210, 80)
+	r.OnFlows([]*flow.Flow{f})
+	if f.RetentionClass != "" || f.RetainUntil != 0 {
+		t.Errorf("Expected the flow not to be classified, got: %s until %d", f.RetentionClass, f.RetainUntil)
+	}
+
+	f = newTestRetentionFlow("TCP", "10.0.0.1", "10.0.0.2", 43210, 22)
+	r.OnFlows([]*flow.Flow{f})
+	if f.RetentionClass != "security-relevant" {
+		t.Errorf("Expected the ssh flow to be classified, got: %s", f.RetentionClass)
+	}
+}
+
+func TestFlowRetentionClassesErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		classes     []*FlowRetentionClassConfig
+		defaultName string
+	}{
+		{"no name", []*FlowRetentionClassConfig{{TTL: 10}}, ""},
+		{"no ttl", []*FlowRetentionClassConfig{{Name: "bulk"}}, ""},
+		{"negative ttl", []*FlowRetentionClassConfig{{Name: "bulk", TTL: -1}}, ""},
+		{"defined twice", []*FlowRetentionClassConfig{{Name: "bulk", TTL: 10}, {Name: "bulk", TTL: 20}}, ""},
+		{"unknown default", []*FlowRetentionClassConfig{{Name: "bulk", TTL: 10}}, "other"},
+		{"invalid port", []*FlowRetentionClassConfig{{Name: "ssh", TTL: 10, Match: []map[string]interface{}{{"Transport": "ssh"}}}}, ""},
+	}
+
+	for _, test := range tests {
+		if _, _, err := newRetentionClasses(test.classes, test.defaultName); err == nil {
+			t.Errorf("Expected an error for the %s class", test.name)
+		}
+	}
+}
@@ -25,7 +25,6 @@ package analyzer
 import (
 	"fmt"
 	"net"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -76,11 +75,22 @@ type FlowServerWebSocketConn struct {
 	timeOfLastLostFlowsLog time.Time
 	numOfLostFlows         int
 	maxFlowBufferSize      int
+	clockSkew              clockSkew
+}
+
+// FlowListener describes the interface of the modules consuming the flows
+// received by the flow server. The flows slice is reused once OnFlows returns.
+// The listeners are notified before the flows get stored and may enrich them.
+type FlowListener interface {
+	OnFlows(flows []*flow.Flow)
 }
 
 // FlowServer describes a flow server with pipeline enhancers mechanism
 type FlowServer struct {
+	sync.RWMutex
 	storage                storage.Storage
+	listeners              []FlowListener
+	anonymizer             *common.Anonymizer
 	enhancerPipeline       *flow.EnhancerPipeline
 	enhancerPipelineConfig *flow.EnhancerPipelineConfig
 	conn                   FlowServerConn
@@ -90,15 +100,45 @@ type FlowServer struct {
 	bulkInsertDeadline     time.Duration
 	ch                     chan *flow.Flow
 	quit                   chan struct{}
+	received               int64
+}
+
+// onBatch queues the flows of a batch uploaded by an edge agent. The flows
+// being possibly hours late, the connection is slowed down when the buffer
+// is full instead of dropping them.
+func (c *FlowServerWebSocketConn) onBatch(client shttp.WSSpeaker, data []byte) {
+	flows, err := flow.DecodeBatch(data)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while parsing batch of flows: %s", err.Error())
+		return
+	}
+
+	d := c.clockSkew.correction(client)
+	logging.GetLogger().Debugf("New batch of %d flows from Websocket connection %s", len(flows), client.GetHost())
+	for _, f := range flows {
+		if d != 0 {
+			f.ShiftTime(int64(d / time.Millisecond))
+		}
+		c.ch <- f
+	}
 }
 
 // OnMessage event
 func (c *FlowServerWebSocketConn) OnMessage(client shttp.WSSpeaker, m shttp.WSMessage) {
-	f, err := flow.FromData(m.Bytes(client.GetClientProtocol()))
+	data := m.Bytes(client.GetClientProtocol())
+	if flow.IsBatch(data) {
+		c.onBatch(client, data)
+		return
+	}
+
+	f, err := flow.FromData(data)
 	if err != nil {
 		logging.GetLogger().Errorf("Error while parsing flow: %s", err.Error())
 		return
 	}
+	if d := c.clockSkew.correction(client); d != 0 {
+		f.ShiftTime(int64(d / time.Millisecond))
+	}
 	logging.GetLogger().Debugf("New flow from Websocket connection: %+v", f)
 	if len(c.ch) >= c.maxFlowBufferSize {
 		c.numOfLostFlows++
@@ -128,7 +168,7 @@ func (c *FlowServerWebSocketConn) Serve(ch chan *flow.Flow, quit chan struct{},
 // NewFlowServerWebSocketConn returns a new WebSocket flow server
 func NewFlowServerWebSocketConn(server *shttp.Server) (*FlowServerWebSocketConn, error) {
 	flowsMax := config.GetConfig().GetInt("analyzer.flow.max_buffer_size")
-	return &FlowServerWebSocketConn{server: server, maxFlowBufferSize: flowsMax}, nil
+	return &FlowServerWebSocketConn{server: server, maxFlowBufferSize: flowsMax, clockSkew: newClockSkewFromConfig()}, nil
 }
 
 // Serve UDP connections
@@ -178,13 +218,13 @@ func (c *FlowServerUDPConn) Serve(ch chan *flow.Flow, quit chan struct{}, wg *sy
 
 // NewFlowServerUDPConn return a new UDP flow server
 func NewFlowServerUDPConn(addr string, port int) (*FlowServerUDPConn, error) {
-	host := addr + ":" + strconv.FormatInt(int64(port), 10)
-	udpAddr, err := net.ResolveUDPAddr("udp", host)
+	host := common.JoinHostPort(addr, port)
+	udpAddr, err := net.ResolveUDPAddr(common.IPNetwork("udp"), host)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := net.ListenUDP("udp", udpAddr)
+	conn, err := net.ListenUDP(common.IPNetwork("udp"), udpAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -194,8 +234,39 @@ func NewFlowServerUDPConn(addr string, port int) (*FlowServerUDPConn, error) {
 	return &FlowServerUDPConn{conn: conn, maxFlowBufferSize: flowsMax}, err
 }
 
+// AddFlowListener registers a listener notified of every batch of received flows
+func (s *FlowServer) AddFlowListener(l FlowListener) {
+	s.Lock()
+	s.listeners = append(s.listeners, l)
+	s.Unlock()
+}
+
+// SetAnonymizer sets the anonymizer of the flows, applied before the
+// listeners and the storage
+func (s *FlowServer) SetAnonymizer(a *common.Anonymizer) {
+	s.Lock()
+	s.anonymizer = a
+	s.Unlock()
+}
+
 func (s *FlowServer) storeFlows(flows []*flow.Flow) {
-	if s.storage != nil && len(flows) > 0 {
+	if len(flows) == 0 {
+		return
+	}
+	atomic.AddInt64(&s.received, int64(len(flows)))
+
+	s.RLock()
+	if s.anonymizer != nil {
+		for _, f := range flows {
+			f.Anonymize(s.anonymizer)
+		}
+	}
+	for _, l := range s.listeners {
+		l.OnFlows(flows)
+	}
+	s.RUnlock()
+
+	if s.storage != nil {
 		s.storage.StoreFlows(flows)
 
 		logging.GetLogger().Debugf("%d flows stored", len(flows))
@@ -235,6 +306,12 @@ func (s *FlowServer) Start() {
 	}()
 }
 
+// Received returns the number of flows received, the number of flows
+// waiting to be stored and the size of the buffer
+func (s *FlowServer) Received() (received int64, queue int, size int) {
+	return atomic.LoadInt64(&s.received), len(s.ch), cap(s.ch)
+}
+
 // Stop the server
 func (s *FlowServer) Stop() {
 	if atomic.CompareAndSwapInt64(&s.state, common.RunningState, common.StoppingState) {
@@ -293,8 +370,8 @@ func NewFlowServer(s *shttp.Server, g *graph.Graph, store storage.Storage, probe
 		storage:                store,
 		enhancerPipeline:       pipeline,
 		enhancerPipelineConfig: flow.NewEnhancerPipelineConfig(),
-		conn: conn,
-		quit: make(chan struct{}, 2),
+		conn:                   conn,
+		quit:                   make(chan struct{}, 2),
 	}
 	err = fs.setupBulkConfigFromBackend()
 	if err != nil {
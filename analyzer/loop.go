@@ -0,0 +1,369 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	// LoopAnnotationKind is the kind of the annotations of the suspected
+	// layer2 loops
+	LoopAnnotationKind = "l2-loop"
+
+	loopManager     = "loop-detector"
+	broadcastMAC    = "ff:ff:ff:ff:ff:ff"
+	stpMulticastMAC = "01:80:c2:00:00:00"
+)
+
+// storm is the rate, in packets per second, of the broadcast and BPDU
+// frames captured on a node
+type storm struct {
+	node      *graph.Node
+	broadcast int64
+	bpdu      int64
+}
+
+// suspectedLoop is a layer2 cycle of the topology crossed by storms, or
+// only the storming node when the loop is outside of the known topology
+type suspectedLoop struct {
+	path   []*graph.Node
+	storms []*storm
+}
+
+// LoopDetector periodically looks, in the stored flows, for the broadcast
+// and spanning tree BPDU storms, the usual symptoms of a layer2 loop, and
+// reports each storm with a high priority annotation node linked to the
+// shortest layer2 cycle of the topology crossing the storming node, the
+// suspected loop path. Only the master analyzer updates the graph.
+type LoopDetector struct {
+	*etcd.MasterElector
+	graph              *graph.Graph
+	storage            storage.Storage
+	window             time.Duration
+	interval           time.Duration
+	maxHops            int
+	broadcastThreshold int64
+	bpduThreshold      int64
+	annotations        map[graph.Identifier]*graph.Node
+	quit               chan struct{}
+	wg                 sync.WaitGroup
+}
+
+// packetRate returns the average number of packets per second of a flow
+func packetRate(f *flow.Flow) int64 {
+	metric := f.GetMetric()
+	packets := metric.GetABPackets() + metric.GetBAPackets()
+
+	// the bursts shorter than a second are not storms
+	seconds := (f.Last - f.Start) / 1000
+	if seconds < 1 {
+		seconds = 1
+	}
+	return packets / seconds
+}
+
+// storms returns the nodes on which broadcast or BPDU storms have been
+// captured
+func (l *LoopDetector) storms(flows []*flow.Flow) []*storm {
+	byTID := make(map[string]*storm)
+	for _, f := range flows {
+		s, ok := byTID[f.NodeTID]
+		if !ok {
+			s = &storm{}
+			byTID[f.NodeTID] = s
+		}
+
+		switch f.GetLink().GetB() {
+		case broadcastMAC:
+			s.broadcast += packetRate(f)
+		case stpMulticastMAC:
+			s.bpdu += packetRate(f)
+		}
+	}
+
+	var storms []*storm
+	for tid, s := range byTID {
+		if s.broadcast < l.broadcastThreshold && s.bpdu < l.bpduThreshold {
+			continue
+		}
+
+		if s.node = l.graph.LookupFirstNode(graph.Metadata{"TID": tid}); s.node != nil {
+			storms = append(storms, s)
+		}
+	}
+	return storms
+}
+
+// lookupCycle returns the nodes of the shortest cycle of layer2 edges
+// crossing the given node, nil if none in the maximum number of hops
+func (l *LoopDetector) lookupCycle(start *graph.Node) []*graph.Node {
+	var path []*graph.Node
+	usedEdges := make(map[graph.Identifier]bool)
+	onPath := make(map[graph.Identifier]bool)
+
+	var visit func(n *graph.Node, depth int) bool
+	visit = func(n *graph.Node, depth int) bool {
+		path = append(path, n)
+		onPath[n.ID] = true
+
+		if len(path) <= depth {
+			for _, e := range l.graph.GetNodeEdges(n, topology.Layer2Metadata) {
+				if usedEdges[e.ID] {
+					continue
+				}
+
+				peer := e.GetChild()
+				if peer == n.ID {
+					peer = e.GetParent()
+				}
+
+				// back to the start by another edge than the one used
+				// to leave it
+				if peer == start.ID && len(path) == depth {
+					return true
+				}
+
+				if onPath[peer] || len(path) == depth {
+					continue
+				}
+
+				if node := l.graph.GetNode(peer); node != nil {
+					usedEdges[e.ID] = true
+					if visit(node, depth) {
+						return true
+					}
+					delete(usedEdges, e.ID)
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		delete(onPath, n.ID)
+		return false
+	}
+
+	// iterative deepening so that the shortest cycle is reported
+	for depth := 2; depth <= l.maxHops; depth++ {
+		if visit(start, depth) {
+			return path
+		}
+	}
+	return nil
+}
+
+// loops groups the storms by suspected loop
+func (l *LoopDetector) loops(storms []*storm) map[graph.Identifier]*suspectedLoop {
+	loops := make(map[graph.Identifier]*suspectedLoop)
+	for _, s := range storms {
+		path := l.lookupCycle(s.node)
+		if path == nil {
+			path = []*graph.Node{s.node}
+		}
+
+		ids := make([]string, len(path))
+		for i, n := range path {
+			ids[i] = string(n.ID)
+		}
+		sort.Strings(ids)
+
+		id := graph.GenIDNameBased(loopManager, strings.Join(ids, ","))
+		if loop, ok := loops[id]; ok {
+			loop.storms = append(loop.storms, s)
+		} else {
+			loops[id] = &suspectedLoop{path: path, storms: []*storm{s}}
+		}
+	}
+	return loops
+}
+
+func nodeNames(nodes []*graph.Node) []interface{} {
+	names := make([]interface{}, len(nodes))
+	for i, n := range nodes {
+		if name, _ := n.GetFieldString("Name"); name != "" {
+			names[i] = name
+		} else {
+			names[i] = string(n.ID)
+		}
+	}
+	return names
+}
+
+func (l *LoopDetector) annotate(id graph.Identifier, loop *suspectedLoop) {
+	var broadcast, bpdu int64
+	var captures []*graph.Node
+	for _, s := range loop.storms {
+		broadcast += s.broadcast
+		bpdu += s.bpdu
+		captures = append(captures, s.node)
+	}
+
+	path := nodeNames(loop.path)
+	var description string
+	if len(loop.path) > 1 {
+		description = fmt.Sprintf("Suspected layer2 loop %v, %d broadcast and %d BPDU packets per second", path, broadcast, bpdu)
+	} else {
+		description = fmt.Sprintf("Suspected layer2 loop outside of the known topology of %v, %d broadcast and %d BPDU packets per second", path, broadcast, bpdu)
+	}
+
+	metadata := map[string]interface{}{
+		"Path":      path,
+		"Captures":  nodeNames(captures),
+		"Broadcast": broadcast,
+		"BPDU":      bpdu,
+	}
+
+	if n, ok := l.annotations[id]; ok {
+		if l.graph.GetNode(id) != nil {
+			l.graph.AddMetadata(n, "L2Loop", metadata)
+			return
+		}
+	}
+
+	logging.GetLogger().Errorf("%s", description)
+
+	n := l.graph.NewNode(id, graph.Metadata{
+		"Type":    "annotation",
+		"Manager": loopManager,
+		"Name":    "L2 loop",
+		"Annotation": map[string]interface{}{
+			"Source":      loopManager,
+			"Kind":        LoopAnnotationKind,
+			"Priority":    "high",
+			"Timestamp":   common.UnixMillis(time.Now().UTC()),
+			"Description": description,
+		},
+		"L2Loop": metadata,
+	})
+
+	linked := make(map[graph.Identifier]bool)
+	for _, node := range append(loop.path, captures...) {
+		if !linked[node.ID] {
+			linked[node.ID] = true
+			l.graph.Link(n, node, graph.Metadata{"RelationType": "annotation"})
+		}
+	}
+	l.annotations[id] = n
+}
+
+func (l *LoopDetector) update() {
+	now := common.UnixMillis(time.Now())
+	fr := filters.Range{From: now - int64(l.window/time.Millisecond), To: now}
+	fsq := filters.SearchQuery{
+		Filter: filters.NewAndFilter(
+			filters.NewFilterActiveIn(fr, ""),
+			filters.NewOrTermStringFilter([]string{broadcastMAC, stpMulticastMAC}, "Link.B"),
+		),
+	}
+
+	flowset, err := l.storage.SearchFlows(fsq)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to retrieve the flows for loop detection: %s", err.Error())
+		return
+	}
+
+	l.graph.Lock()
+	defer l.graph.Unlock()
+
+	loops := l.loops(l.storms(flowset.Flows))
+	for id, loop := range loops {
+		l.annotate(id, loop)
+	}
+
+	for id, n := range l.annotations {
+		if _, ok := loops[id]; !ok {
+			logging.GetLogger().Infof("Layer2 loop %v resolved", n.Metadata()["L2Loop"])
+			if l.graph.GetNode(id) != nil {
+				l.graph.DelNode(n)
+			}
+			delete(l.annotations, id)
+		}
+	}
+}
+
+func (l *LoopDetector) run() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if l.IsMaster() {
+				l.update()
+			}
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// Start the loop detector
+func (l *LoopDetector) Start() {
+	l.StartAndWait()
+
+	l.wg.Add(1)
+	go l.run()
+}
+
+// Stop the loop detector
+func (l *LoopDetector) Stop() {
+	close(l.quit)
+	l.wg.Wait()
+	l.MasterElector.Stop()
+}
+
+// NewLoopDetectorFromConfig returns a new loop detector, nil if disabled or
+// if no flow storage is configured
+func NewLoopDetectorFromConfig(g *graph.Graph, store storage.Storage, etcdClient *etcd.Client) *LoopDetector {
+	if store == nil || !config.GetBool("analyzer.loop.enabled") {
+		return nil
+	}
+
+	return &LoopDetector{
+		MasterElector:      etcd.NewMasterElectorFromConfig(common.AnalyzerService, "loop-detector", etcdClient),
+		graph:              g,
+		storage:            store,
+		window:             time.Duration(config.GetInt("analyzer.loop.window")) * time.Second,
+		interval:           time.Duration(config.GetInt("analyzer.loop.interval")) * time.Second,
+		maxHops:            config.GetInt("analyzer.loop.max_hops"),
+		broadcastThreshold: int64(config.GetInt("analyzer.loop.broadcast_threshold")),
+		bpduThreshold:      int64(config.GetInt("analyzer.loop.bpdu_threshold")),
+		annotations:        make(map[graph.Identifier]*graph.Node),
+		quit:               make(chan struct{}),
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func newTestLoopFlow(tid, mac string, packets, seconds int64) *flow.Flow {
+	return &flow.Flow{
+		UUID:    graph.GenID().String(),
+		NodeTID: tid,
+		Link:    &flow.FlowLayer{Protocol: flow.FlowProtocol_ETHERNET, A: "aa:bb:cc:dd:ee:ff", B: mac},
+		Metric:  &flow.FlowMetric{ABPackets: packets},
+		Start:   1000,
+		Last:    1000 + seconds*1000,
+	}
+}
+
+func TestLoopDetector(t *testing.T) {
+	g := newTestGraph(t, "loop")
+
+	// sw1, sw2 and sw3 form a layer2 loop while sw4 hangs on sw1
+	g.Lock()
+	sw1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "sw1", "TID": "tid1"})
+	sw2 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "sw2", "TID": "tid2"})
+	sw3 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "sw3", "TID": "tid3"})
+	sw4 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "sw4", "TID": "tid4"})
+	topology.AddLayer2Link(g, sw1, sw2, nil)
+	topology.AddLayer2Link(g, sw2, sw3, nil)
+	topology.AddLayer2Link(g, sw3, sw1, nil)
+	topology.AddLayer2Link(g, sw1, sw4, nil)
+	g.Unlock()
+
+	store := &fakeFlowStorage{
+		flows: []*flow.Flow{
+			// 1000 broadcast packets per second captured on sw2
+			newTestLoopFlow("tid2", broadcastMAC, 10000, 10),
+			// 200 BPDUs per second on sw1, in the same loop
+			newTestLoopFlow("tid1", stpMulticastMAC, 2000, 10),
+			// 300 BPDUs per second on sw4, out of the known loops
+			newTestLoopFlow("tid4", stpMulticastMAC, 3000, 10),
+			// below the thresholds on sw3
+			newTestLoopFlow("tid3", broadcastMAC, 1000, 10),
+			newTestLoopFlow("tid3", stpMulticastMAC, 50, 1),
+			// a short burst is not a storm
+			newTestLoopFlow("tid3", broadcastMAC, 300, 0),
+		},
+	}
+
+	l := &LoopDetector{
+		graph:              g,
+		storage:            store,
+		window:             time.Minute,
+		maxHops:            5,
+		broadcastThreshold: 500,
+		bpduThreshold:      100,
+		annotations:        make(map[graph.Identifier]*graph.Node),
+	}
+
+	l.update()
+
+	if len(l.annotations) != 2 {
+		t.Fatalf("Expected 2 suspected loops, got: %d", len(l.annotations))
+	}
+
+	g.RLock()
+	var loops []map[string]interface{}
+	for _, n := range l.annotations {
+		field, err := n.GetField("L2Loop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		loops = append(loops, field.(map[string]interface{}))
+
+		if annotated := g.LookupChildren(n, nil, graph.Metadata{"RelationType": "annotation"}); len(annotated) != len(field.(map[string]interface{})["Path"].([]interface{})) {
+			t.Errorf("Expected the annotation to be linked to the nodes of the loop, got: %d nodes", len(annotated))
+		}
+	}
+	g.RUnlock()
+
+	sort.Slice(loops, func(i, j int) bool {
+		return len(loops[i]["Path"].([]interface{})) > len(loops[j]["Path"].([]interface{}))
+	})
+
+	names := func(values interface{}) []string {
+		var names []string
+		for _, name := range values.([]interface{}) {
+			names = append(names, name.(string))
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if path := names(loops[0]["Path"]); !reflect.DeepEqual(path, []string{"sw1", "sw2", "sw3"}) {
+		t.Errorf("Expected the loop of sw1, sw2 and sw3, got: %v", path)
+	}
+	if captures := names(loops[0]["Captures"]); !reflect.DeepEqual(captures, []string{"sw1", "sw2"}) {
+		t.Errorf("Expected the storms of sw1 and sw2 on the same loop, got: %v", captures)
+	}
+	if loops[0]["Broadcast"] != int64(1000) || loops[0]["BPDU"] != int64(200) {
+		t.Errorf("Expected the rates of the storms of the loop, got: %v", loops[0])
+	}
+
+	if path := names(loops[1]["Path"]); !reflect.DeepEqual(path, []string{"sw4"}) {
+		t.Errorf("Expected the storm of sw4 outside of the known topology, got: %v", path)
+	}
+	if loops[1]["BPDU"] != int64(300) {
+		t.Errorf("Expected the BPDU rate of sw4, got: %v", loops[1])
+	}
+
+	// the annotations are updated in place
+	l.update()
+	if len(l.annotations) != 2 {
+		t.Errorf("Expected the suspected loops to be kept, got: %d", len(l.annotations))
+	}
+
+	// the storms calmed down
+	store.flows = store.flows[3:]
+	l.update()
+
+	g.RLock()
+	defer g.RUnlock()
+	if len(l.annotations) != 0 || len(g.GetNodes(graph.Metadata{"Manager": loopManager})) != 0 {
+		t.Errorf("Expected the annotations to be removed once the storms stopped, got: %d", len(l.annotations))
+	}
+}
+
+func TestLoopLookupCycle(t *testing.T) {
+	g := newTestGraph(t, "loop")
+
+	// a square of which sw1 and sw3 are also linked, and a tree branch
+	g.Lock()
+	var sw []*graph.Node
+	for i := 0; i < 5; i++ {
+		sw = append(sw, g.NewNode(graph.GenID(), graph.Metadata{"Name": fmt.Sprintf("sw%d", i)}))
+	}
+	topology.AddLayer2Link(g, sw[0], sw[1], nil)
+	topology.AddLayer2Link(g, sw[1], sw[2], nil)
+	topology.AddLayer2Link(g, sw[2], sw[3], nil)
+	topology.AddLayer2Link(g, sw[3], sw[0], nil)
+	topology.AddLayer2Link(g, sw[0], sw[2], nil)
+	topology.AddLayer2Link(g, sw[3], sw[4], nil)
+	g.Unlock()
+
+	l := &LoopDetector{graph: g, maxHops: 5}
+
+	g.RLock()
+	defer g.RUnlock()
+
+	if cycle := l.lookupCycle(sw[1]); len(cycle) != 3 || cycle[0].ID != sw[1].ID {
+		t.Errorf("Expected the shortest cycle from sw1, got: %v", nodeNames(cycle))
+	}
+	if cycle := l.lookupCycle(sw[4]); cycle != nil {
+		t.Errorf("Expected no cycle through the tree branch, got: %v", nodeNames(cycle))
+	}
+
+	l.maxHops = 2
+	if cycle := l.lookupCycle(sw[1]); cycle != nil {
+		t.Errorf("Expected no cycle within 2 hops, got: %v", nodeNames(cycle))
+	}
+}
@@ -0,0 +1,363 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package analyzer
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Kinds of the MTU issues reported on the edges
+const (
+	MTUMismatch      = "Mismatch"
+	MTUEncapsulation = "Encapsulation"
+	MTUBlackhole     = "Blackhole"
+)
+
+// ethernetHeaderLength is accounted in the bytes of the flows with a link
+// layer but not in the MTUs
+const ethernetHeaderLength = 14
+
+// encapsulationOverheads are the bytes added by the encapsulations to the
+// inner packets, outer IPv4 header included
+var encapsulationOverheads = map[string]int64{
+	"VXLAN":  50,
+	"Geneve": 50,
+	"GRE":    24,
+	"MPLS":   4,
+}
+
+type mtuIssue struct {
+	description string
+	flows       int64
+}
+
+// MTUChecker periodically checks the MTUs of the interfaces along the paths of
+// the stored flows and reports on the offending layer2 edges, in the
+// MTUIssues metadata, the MTU mismatches between the two ends of an edge,
+// the encapsulations whose overhead does not fit in the MTU of the outer path
+// and the TCP flows sending packets larger than their path MTU without any
+// ICMP fragmentation needed, or packet too big, error, a sign of a path MTU
+// discovery black-hole. Only the master analyzer updates the edges.
+type MTUChecker struct {
+	*etcd.MasterElector
+	graph    *graph.Graph
+	storage  storage.Storage
+	window   time.Duration
+	interval time.Duration
+	maxHops  int
+	flagged  map[graph.Identifier]bool
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type mtuPath struct {
+	edges []*graph.Edge
+	// mtu is the smallest MTU of the path
+	mtu int64
+}
+
+func nodeMTU(n *graph.Node) int64 {
+	mtu, _ := n.GetFieldInt64("MTU")
+	return mtu
+}
+
+// edgeMTU returns the smallest MTU of the two ends of an edge
+func (m *MTUChecker) edgeMTU(e *graph.Edge) (parent, child int64) {
+	if n := m.graph.GetNode(e.GetParent()); n != nil {
+		parent = nodeMTU(n)
+	}
+	if n := m.graph.GetNode(e.GetChild()); n != nil {
+		child = nodeMTU(n)
+	}
+	return
+}
+
+func minMTU(mtus ...int64) (min int64) {
+	for _, mtu := range mtus {
+		if mtu > 0 && (min == 0 || mtu < min) {
+			min = mtu
+		}
+	}
+	return
+}
+
+func (m *MTUChecker) lookupPath(a, b *graph.Node) *mtuPath {
+	path := &mtuPath{
+		edges: m.graph.LookupEdgePath(a, b, topology.Layer2Metadata, m.maxHops, nil),
+		mtu:   minMTU(nodeMTU(a), nodeMTU(b)),
+	}
+	for _, e := range path.edges {
+		parent, child := m.edgeMTU(e)
+		path.mtu = minMTU(path.mtu, parent, child)
+	}
+	return path
+}
+
+// narrowEdges returns the edges of the path with an end of MTU below size
+func (m *MTUChecker) narrowEdges(path *mtuPath, size int64) (edges []*graph.Edge) {
+	for _, e := range path.edges {
+		parent, child := m.edgeMTU(e)
+		if mtu := minMTU(parent, child); mtu > 0 && mtu < size {
+			edges = append(edges, e)
+		}
+	}
+	return
+}
+
+// averagePacketSize returns the average size of the IP packets sent by one
+// side of a flow
+func averagePacketSize(f *flow.Flow, bytes, packets int64) int64 {
+	if packets == 0 {
+		return 0
+	}
+
+	size := bytes / packets
+	if f.Link != nil && f.Link.Protocol == flow.FlowProtocol_ETHERNET {
+		size -= ethernetHeaderLength
+	}
+	return size
+}
+
+// icmpTooBig returns whether the flow is made of ICMP errors telling that
+// a packet needs to be fragmented
+func icmpTooBig(f *flow.Flow) bool {
+	if f.ICMP == nil || f.ICMP.Original == nil || f.ICMP.Original.Network == nil {
+		return false
+	}
+	switch f.ICMP.Type {
+	case flow.ICMPType_PACKET_TOO_BIG:
+		return true
+	case flow.ICMPType_DESTINATION_UNREACHABLE:
+		return f.Network != nil && f.Network.Protocol == flow.FlowProtocol_IPV4 && f.ICMP.Code == 4
+	}
+	return false
+}
+
+func (m *MTUChecker) update() {
+	now := common.UnixMillis(time.Now())
+	fr := filters.Range{From: now - int64(m.window/time.Millisecond), To: now}
+	fsq := filters.SearchQuery{Filter: filters.NewFilterActiveIn(fr, "")}
+
+	flowset, err := m.storage.SearchFlows(fsq)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to retrieve the flows for MTU checks: %s", err.Error())
+		return
+	}
+
+	// the senders to which a fragmentation needed error has been returned
+	tooBig := make(map[[2]string]bool)
+	byUUID := make(map[string]*flow.Flow)
+	for _, f := range flowset.Flows {
+		byUUID[f.UUID] = f
+		if icmpTooBig(f) {
+			tooBig[[2]string{f.ICMP.Original.Network.A, f.ICMP.Original.Network.B}] = true
+		}
+	}
+
+	m.graph.Lock()
+	defer m.graph.Unlock()
+
+	index := topology.NewAddressIndex(m.graph)
+	paths := make(map[[2]graph.Identifier]*mtuPath)
+	issues := make(map[graph.Identifier]map[string]*mtuIssue)
+
+	flowPath := func(f *flow.Flow) *mtuPath {
+		a := index.Lookup(f.GetLink().GetA(), f.GetNetwork().GetA())
+		b := index.Lookup(f.GetLink().GetB(), f.GetNetwork().GetB())
+		if a == nil || b == nil || a.ID == b.ID {
+			return nil
+		}
+
+		key := [2]graph.Identifier{a.ID, b.ID}
+		if b.ID < a.ID {
+			key = [2]graph.Identifier{b.ID, a.ID}
+		}
+
+		path, ok := paths[key]
+		if !ok {
+			path = m.lookupPath(a, b)
+			paths[key] = path
+		}
+		return path
+	}
+
+	report := func(e *graph.Edge, kind, description string) {
+		if _, ok := issues[e.ID]; !ok {
+			issues[e.ID] = make(map[string]*mtuIssue)
+		}
+		if issue, ok := issues[e.ID][kind]; ok {
+			issue.flows++
+		} else {
+			issues[e.ID][kind] = &mtuIssue{description: description, flows: 1}
+		}
+	}
+
+	for _, f := range flowset.Flows {
+		path := flowPath(f)
+		if path == nil {
+			continue
+		}
+
+		for _, e := range path.edges {
+			if parent, child := m.edgeMTU(e); parent > 0 && child > 0 && parent != child {
+				report(e, MTUMismatch, fmt.Sprintf("MTU %d on one end, %d on the other", parent, child))
+			}
+		}
+
+		if parent, ok := byUUID[f.ParentUUID]; ok {
+			if overhead, ok := encapsulationOverheads[parent.Application]; ok && path.mtu > 0 {
+				required := path.mtu + overhead
+				if outer := flowPath(parent); outer != nil && outer.mtu > 0 && outer.mtu < required {
+					description := fmt.Sprintf("%s encapsulated packets of MTU %d require an MTU of %d", parent.Application, path.mtu, required)
+					for _, e := range m.narrowEdges(outer, required) {
+						report(e, MTUEncapsulation, description)
+					}
+				}
+			}
+		}
+
+		if f.GetTransport().GetProtocol() != flow.FlowProtocol_TCP || f.Network == nil || path.mtu == 0 {
+			continue
+		}
+
+		metric := f.GetMetric()
+		sides := []struct {
+			src, dst       string
+			bytes, packets int64
+		}{
+			{f.Network.A, f.Network.B, metric.GetABBytes(), metric.GetABPackets()},
+			{f.Network.B, f.Network.A, metric.GetBABytes(), metric.GetBAPackets()},
+		}
+		for _, side := range sides {
+			size := averagePacketSize(f, side.bytes, side.packets)
+			if size <= path.mtu || tooBig[[2]string{side.src, side.dst}] {
+				continue
+			}
+
+			description := fmt.Sprintf("Packets of %d bytes from %s to %s exceed the path MTU %d without ICMP error", size, side.src, side.dst, path.mtu)
+			for _, e := range m.narrowEdges(path, size) {
+				report(e, MTUBlackhole, description)
+			}
+		}
+	}
+
+	// reset the edges whose issues have been resolved
+	for id := range m.flagged {
+		if _, ok := issues[id]; !ok {
+			if e := m.graph.GetEdge(id); e != nil {
+				m.graph.DelMetadata(e, "MTUIssues")
+			}
+		}
+	}
+
+	flagged := make(map[graph.Identifier]bool)
+	for id, kinds := range issues {
+		e := m.graph.GetEdge(id)
+		if e == nil {
+			continue
+		}
+		flagged[id] = true
+
+		metadata := make(map[string]interface{})
+		for kind, issue := range kinds {
+			metadata[kind] = map[string]interface{}{
+				"Description": issue.description,
+				"Flows":       issue.flows,
+			}
+		}
+
+		if current, _ := e.GetField("MTUIssues"); !reflect.DeepEqual(current, metadata) {
+			if !m.flagged[id] {
+				logging.GetLogger().Warningf("MTU issues on edge %s: %v", id, metadata)
+			}
+			m.graph.AddMetadata(e, "MTUIssues", metadata)
+		}
+	}
+	m.flagged = flagged
+
+	logging.GetLogger().Debugf("MTU issues reported on %d edges", len(flagged))
+}
+
+func (m *MTUChecker) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if m.IsMaster() {
+				m.update()
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// Start the MTU checker
+func (m *MTUChecker) Start() {
+	m.StartAndWait()
+
+	m.wg.Add(1)
+	go m.run()
+}
+
+// Stop the MTU checker
+func (m *MTUChecker) Stop() {
+	close(m.quit)
+	m.wg.Wait()
+	m.MasterElector.Stop()
+}
+
+// NewMTUCheckerFromConfig returns a new MTU checker, nil if disabled or if no
+// flow storage is configured
+func NewMTUCheckerFromConfig(g *graph.Graph, store storage.Storage, etcdClient *etcd.Client) *MTUChecker {
+	if store == nil || !config.GetBool("analyzer.mtu.enabled") {
+		return nil
+	}
+
+	return &MTUChecker{
+		MasterElector: etcd.NewMasterElectorFromConfig(common.AnalyzerService, "mtu-checker", etcdClient),
+		graph:         g,
+		storage:       store,
+		window:        time.Duration(config.GetInt("analyzer.mtu.window")) * time.Second,
+		interval:      time.Duration(config.GetInt("analyzer.mtu.interval")) * time.Second,
+		maxHops:       config.GetInt("analyzer.mtu.max_hops"),
+		flagged:       make(map[graph.Identifier]bool),
+		quit:          make(chan struct{}),
+	}
+}
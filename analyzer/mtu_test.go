@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+type mtuTestGraph struct {
+	g        *graph.Graph
+	vmToBr   *graph.Edge
+	brToVM   *graph.Edge
+	hostLink *graph.Edge
+	vxlan    *graph.Edge
+}
+
+// newMTUTestGraph returns two VMs linked by a bridge, the MTU of the second
+// VM being lower, and two hosts whose link carries a VXLAN tunnel between
+// two other VMs
+func newMTUTestGraph(t *testing.T) *mtuTestGraph {
+	g := newTestGraph(t, "mtu")
+
+	g.Lock()
+	defer g.Unlock()
+
+	vm1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "vm1", "IPV4": []string{"10.0.0.1/24"}, "MTU": 1500})
+	br := g.NewNode(graph.GenID(), graph.Metadata{"Name": "br", "MTU": 1500})
+	vm2 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "vm2", "IPV4": []string{"10.0.0.2/24"}, "MTU": 1400})
+
+	host1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "host1", "IPV4": []string{"192.168.0.1/24"}, "MTU": 1500})
+	host2 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "host2", "IPV4": []string{"192.168.0.2/24"}, "MTU": 1500})
+	vm3 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "vm3", "IPV4": []string{"10.0.1.1/24"}, "MTU": 1500})
+	vm4 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "vm4", "IPV4": []string{"10.0.1.2/24"}, "MTU": 1500})
+
+	return &mtuTestGraph{
+		g:        g,
+		vmToBr:   topology.AddLayer2Link(g, vm1, br, nil),
+		brToVM:   topology.AddLayer2Link(g, br, vm2, nil),
+		hostLink: topology.AddLayer2Link(g, host1, host2, nil),
+		vxlan:    topology.AddLayer2Link(g, vm3, vm4, nil),
+	}
+}
+
+func newTestMTUChecker(g *graph.Graph, flows ...*flow.Flow) *MTUChecker {
+	return &MTUChecker{
+		graph:   g,
+		storage: &fakeFlowStorage{flows: flows},
+		window:  time.Minute,
+		maxHops: 10,
+		flagged: make(map[graph.Identifier]bool),
+	}
+}
+
+func newTestTCPFlow(uuid, a, b string, bytes, packets int64) *flow.Flow {
+	return &flow.Flow{
+		UUID:      uuid,
+		Network:   &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: a, B: b},
+		Transport: &flow.TransportLayer{Protocol: flow.FlowProtocol_TCP, A: 43210, B: 80},
+		Metric:    &flow.FlowMetric{ABBytes: bytes, ABPackets: packets},
+	}
+}
+
+// mtuIssues returns the kinds of the issues reported on an edge
+func mtuIssues(t *testing.T, g *graph.Graph, e *graph.Edge) map[string]bool {
+	g.RLock()
+	defer g.RUnlock()
+
+	field, err := e.GetField("MTUIssues")
+	if err != nil {
+		return nil
+	}
+
+	kinds := make(map[string]bool)
+	for kind := range field.(map[string]interface{}) {
+		kinds[kind] = true
+	}
+	return kinds
+}
+
+func TestMTUMismatchAndBlackhole(t *testing.T) {
+	tg := newMTUTestGraph(t)
+
+	// packets of 1500 bytes towards vm2 of MTU 1400
+	m := newTestMTUChecker(tg.g, newTestTCPFlow("aaa", "10.0.0.1", "10.0.0.2", 15000, 10))
+	m.update()
+
+	if issues := mtuIssues(t, tg.g, tg.vmToBr); len(issues) != 0 {
+		t.Errorf("Expected no issue between vm1 and the bridge, got: %v", issues)
+	}
+	if issues := mtuIssues(t, tg.g, tg.brToVM); !issues[MTUMismatch] || !issues[MTUBlackhole] {
+		t.Errorf("Expected a mismatch and a black-hole between the bridge and vm2, got: %v", issues)
+	}
+	if !m.flagged[tg.brToVM.ID] || len(m.flagged) != 1 {
+		t.Errorf("Expected only the edge to vm2 to be flagged, got: %v", m.flagged)
+	}
+
+	// the ICMP errors returned to the sender make it lower its packet
+	// size, there is no black-hole
+	icmp := &flow.Flow{
+		UUID:    "bbb",
+		Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "10.0.0.2", B: "10.0.0.1"},
+		ICMP: &flow.ICMPLayer{
+			Type: flow.ICMPType_DESTINATION_UNREACHABLE,
+			Code: 4,
+			Original: &flow.ICMPOriginal{
+				Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "10.0.0.1", B: "10.0.0.2"},
+			},
+		},
+	}
+	m.storage = &fakeFlowStorage{flows: []*flow.Flow{newTestTCPFlow("aaa", "10.0.0.1", "10.0.0.2", 15000, 10), icmp}}
+	m.update()
+
+	if issues := mtuIssues(t, tg.g, tg.brToVM); !issues[MTUMismatch] || issues[MTUBlackhole] {
+		t.Errorf("Expected the mismatch only with ICMP errors, got: %v", issues)
+	}
+
+	// no flow anymore on the path, the issues are cleared
+	m.storage = &fakeFlowStorage{}
+	m.update()
+
+	if issues := mtuIssues(t, tg.g, tg.brToVM); issues != nil {
+		t.Errorf("Expected the issues to be cleared, got: %v", issues)
+	}
+	if len(m.flagged) != 0 {
+		t.Errorf("Expected no flagged edge, got: %v", m.flagged)
+	}
+}
+
+func TestMTUEncapsulation(t *testing.T) {
+	tg := newMTUTestGraph(t)
+
+	outer := newTestTCPFlow("outer", "192.168.0.1", "192.168.0.2", 1000, 10)
+	outer.Transport.Protocol = flow.FlowProtocol_UDP
+	outer.Application = "VXLAN"
+
+	inner := newTestTCPFlow("inner", "10.0.1.1", "10.0.1.2", 1000, 10)
+	inner.ParentUUID = outer.UUID
+
+	m := newTestMTUChecker(tg.g, outer, inner)
+	m.update()
+
+	// the 50 bytes of VXLAN overhead do not fit in the MTU of the hosts
+	if issues := mtuIssues(t, tg.g, tg.hostLink); !issues[MTUEncapsulation] || len(issues) != 1 {
+		t.Errorf("Expected an encapsulation issue on the link of the hosts, got: %v", issues)
+	}
+	if issues := mtuIssues(t, tg.g, tg.vxlan); issues != nil {
+		t.Errorf("Expected no issue on the inner path, got: %v", issues)
+	}
+}
+
+func TestMTUNoIssue(t *testing.T) {
+	tg := newMTUTestGraph(t)
+
+	m := newTestMTUChecker(tg.g,
+		// packets fitting in the path MTU
+		newTestTCPFlow("aaa", "10.0.0.1", "10.0.0.2", 13000, 10),
+		// of which the ethernet header is not part of the MTU
+		&flow.Flow{
+			UUID:      "bbb",
+			Link:      &flow.FlowLayer{Protocol: flow.FlowProtocol_ETHERNET},
+			Network:   &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "10.0.1.1", B: "10.0.1.2"},
+			Transport: &flow.TransportLayer{Protocol: flow.FlowProtocol_TCP},
+			Metric:    &flow.FlowMetric{ABBytes: 15140, ABPackets: 10},
+		},
+		// unknown endpoints
+		newTestTCPFlow("ccc", "172.16.0.1", "10.0.0.2", 90000, 10),
+	)
+	m.update()
+
+	for _, e := range []*graph.Edge{tg.vmToBr, tg.hostLink, tg.vxlan} {
+		if issues := mtuIssues(t, tg.g, e); issues != nil {
+			t.Errorf("Expected no issue on the edge %s, got: %v", e.ID, issues)
+		}
+	}
+
+	// the mismatch is reported whatever the size of the packets
+	if issues := mtuIssues(t, tg.g, tg.brToVM); !issues[MTUMismatch] || issues[MTUBlackhole] {
+		t.Errorf("Expected only the mismatch on the edge to vm2, got: %v", issues)
+	}
+}
@@ -27,9 +27,15 @@ import (
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/probe"
 	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/probes/dns"
 	"github.com/skydive-project/skydive/topology/probes/fabric"
-	"github.com/skydive-project/skydive/topology/probes/k8s"
+	"github.com/skydive-project/skydive/topology/probes/fingerprint"
+	"github.com/skydive-project/skydive/topology/probes/ipconflict"
+	"github.com/skydive-project/skydive/topology/probes/macflap"
+	"github.com/skydive-project/skydive/topology/probes/multicast"
+	"github.com/skydive-project/skydive/topology/probes/nova"
 	"github.com/skydive-project/skydive/topology/probes/peering"
+	"github.com/skydive-project/skydive/topology/probes/stack"
 )
 
 // NewTopologyProbeBundleFromConfig creates a new topology server probes from configuration
@@ -48,12 +54,63 @@ func NewTopologyProbeBundleFromConfig(g *graph.Graph) (*probe.ProbeBundle, error
 		switch t {
 		case "k8s":
 			var err error
-			probes[t], err = k8s.NewProbe(g)
+			probes[t], err = newK8sProbe(g)
 			if err != nil {
 				logging.GetLogger().Errorf("Failed to initialize K8S probe: %s", err.Error())
 				return nil, err
 			}
 
+		case "dns":
+			var err error
+			probes[t], err = dns.NewDNSProbeFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize DNS probe: %s", err.Error())
+				return nil, err
+			}
+
+		case "fingerprint":
+			var err error
+			probes[t], err = fingerprint.NewFingerprintProbeFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize fingerprint probe: %s", err.Error())
+				return nil, err
+			}
+
+		case "ipconflict":
+			var err error
+			probes[t], err = ipconflict.NewConflictProbeFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize IP conflict probe: %s", err.Error())
+				return nil, err
+			}
+
+		case "macflap":
+			var err error
+			probes[t], err = macflap.NewFlapProbeFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize MAC flap probe: %s", err.Error())
+				return nil, err
+			}
+
+		case "multicast":
+			probes[t] = multicast.NewDistributionProbe(g)
+
+		case "nova":
+			var err error
+			probes[t], err = nova.NewNovaProbeFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize Nova probe: %s", err.Error())
+				return nil, err
+			}
+
+		case "stack":
+			var err error
+			probes[t], err = stack.NewStackProbeFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize stack probe: %s", err.Error())
+				return nil, err
+			}
+
 		default:
 			logging.GetLogger().Errorf("unknown probe type: %s", t)
 		}
@@ -0,0 +1,483 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	api "github.com/skydive-project/skydive/api/server"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/enhancers"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const remoteCaptureNamespace = "7f0a4cf4-8b6d-4a5e-9e5f-2d3c1b8e6a90"
+
+// interfaceNameRegexp matches the interface names a remote capture can be
+// started on, the name being part of the remote shell command
+var interfaceNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.:@-]+$`)
+
+// remoteCapture holds the SSH session of a running remote capture and the
+// flow table its packets are fed to
+type remoteCapture struct {
+	capture *types.RemoteCapture
+	client  *ssh.Client
+	session *ssh.Session
+	table   *flow.Table
+	node    *graph.Node
+}
+
+// RemoteCaptureManager runs tcpdump over SSH on hosts without a Skydive agent
+// and feeds the captured packets to the flow pipeline. Only the master analyzer
+// runs the captures.
+type RemoteCaptureManager struct {
+	common.RWMutex
+	*etcd.MasterElector
+	graph       *graph.Graph
+	handler     *api.RemoteCaptureAPIHandler
+	storage     storage.Storage
+	pipeline    *flow.EnhancerPipeline
+	watcher     api.StoppableWatcher
+	captures    map[string]*remoteCapture
+	keyFile     string
+	keyDir      string
+	knownHosts  string
+	updateEvery time.Duration
+	expireEvery time.Duration
+	dialTimeout time.Duration
+	tcpdumpPath string
+}
+
+func (m *RemoteCaptureManager) storeFlows(flows []*flow.Flow) {
+	if m.storage != nil && len(flows) > 0 {
+		m.storage.StoreFlows(flows)
+		logging.GetLogger().Debugf("%d remote capture flows stored", len(flows))
+	}
+}
+
+// shellQuote quotes a word of a command run by the remote shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// knownHostMatch tests whether a host of a known_hosts line, either plain or
+// hashed, is the given one
+func knownHostMatch(entry, host string) bool {
+	if !strings.HasPrefix(entry, "|1|") {
+		return entry == host
+	}
+
+	parts := strings.Split(entry[3:], "|")
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// knownHostKeys returns the keys of a known_hosts file registered for the
+// address, a host:port pair. Markers and wildcard patterns are not supported.
+func knownHostKeys(path string, address string) ([]ssh.PublicKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if port != "22" {
+		host = "[" + host + "]:" + port
+	}
+
+	var keys []ssh.PublicKey
+	for len(data) > 0 {
+		marker, hosts, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse %s: %s", path, err.Error())
+		}
+		data = rest
+
+		if marker != "" {
+			continue
+		}
+		for _, h := range hosts {
+			if knownHostMatch(h, host) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// sshConfig returns the configuration of the connection to the address of
+// the capture. The key file of a capture has to be in the key directory and
+// the host key has to be either given or in the known_hosts file.
+func (m *RemoteCaptureManager) sshConfig(rc *types.RemoteCapture, address string) (*ssh.ClientConfig, error) {
+	keyFile := m.keyFile
+	if rc.KeyFile != "" {
+		if m.keyDir == "" {
+			return nil, fmt.Errorf("No SSH key directory configured, remote capture %s can't set its key file", rc.UUID)
+		}
+		if rc.KeyFile != filepath.Base(rc.KeyFile) || rc.KeyFile == "." || rc.KeyFile == ".." {
+			return nil, fmt.Errorf("SSH key %s is not a file name of the key directory %s", rc.KeyFile, m.keyDir)
+		}
+		keyFile = filepath.Join(m.keyDir, rc.KeyFile)
+	}
+
+	pem, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read SSH key %s: %s", keyFile, err.Error())
+	}
+
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse SSH key %s: %s", keyFile, err.Error())
+	}
+
+	var expected []ssh.PublicKey
+	if rc.HostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(rc.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse host key: %s", err.Error())
+		}
+		expected = append(expected, key)
+	} else if expected, err = knownHostKeys(m.knownHosts, address); err != nil {
+		return nil, err
+	} else if len(expected) == 0 {
+		return nil, fmt.Errorf("No host key given for remote capture %s and no known host key for %s", rc.UUID, address)
+	}
+
+	return &ssh.ClientConfig{
+		User:    rc.Username,
+		Auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		Timeout: m.dialTimeout,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			for _, e := range expected {
+				if bytes.Equal(key.Marshal(), e.Marshal()) {
+					return nil
+				}
+			}
+			return fmt.Errorf("Host key mismatch for %s", hostname)
+		},
+	}, nil
+}
+
+func (m *RemoteCaptureManager) tcpdumpCommand(rc *types.RemoteCapture) (string, error) {
+	iface := rc.Interface
+	if iface == "" {
+		iface = "any"
+	}
+	if !interfaceNameRegexp.MatchString(iface) {
+		return "", fmt.Errorf("Invalid interface name %s", shellQuote(iface))
+	}
+
+	cmd := fmt.Sprintf("%s -U -n -s %d -w - -i %s", m.tcpdumpPath, flow.MaxCaptureLength, shellQuote(iface))
+	if rc.BPFFilter != "" {
+		cmd += " " + shellQuote(rc.BPFFilter)
+	}
+	if rc.Sudo {
+		cmd = "sudo -n " + cmd
+	}
+	return cmd, nil
+}
+
+// createNode adds the synthetic node the flows of the capture are attributed to
+func (m *RemoteCaptureManager) createNode(rc *types.RemoteCapture) *graph.Node {
+	host := rc.Host
+	if h, _, err := net.SplitHostPort(rc.Host); err == nil {
+		host = h
+	}
+
+	id := graph.GenIDNameBased(remoteCaptureNamespace, rc.UUID)
+	metadata := graph.Metadata{
+		"Name":  host,
+		"Type":  "host",
+		"TID":   string(id),
+		"Probe": "remotecapture",
+		"Capture": map[string]interface{}{
+			"ID":        rc.UUID,
+			"Name":      rc.Name,
+			"Interface": rc.Interface,
+			"BPFFilter": rc.BPFFilter,
+			"State":     "active",
+		},
+	}
+
+	m.graph.Lock()
+	defer m.graph.Unlock()
+
+	if node := m.graph.GetNode(id); node != nil {
+		return node
+	}
+	return m.graph.NewNode(id, metadata)
+}
+
+func (m *RemoteCaptureManager) startCapture(rc *types.RemoteCapture) error {
+	m.Lock()
+	_, found := m.captures[rc.UUID]
+	m.Unlock()
+	if found {
+		return nil
+	}
+
+	cmd, err := m.tcpdumpCommand(rc)
+	if err != nil {
+		return err
+	}
+
+	address := rc.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	cfg, err := m.sshConfig(rc, address)
+	if err != nil {
+		return err
+	}
+
+	client, err := ssh.Dial("tcp", address, cfg)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to %s: %s", address, err.Error())
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return err
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return err
+	}
+
+	if err := session.Start(cmd); err != nil {
+		session.Close()
+		client.Close()
+		return fmt.Errorf("Unable to start '%s' on %s: %s", cmd, rc.Host, err.Error())
+	}
+
+	node := m.createNode(rc)
+	tid, _ := node.GetFieldString("TID")
+
+	updateHandler := flow.NewFlowHandler(m.storeFlows, m.updateEvery)
+	expireHandler := flow.NewFlowHandler(m.storeFlows, m.expireEvery)
+	table := flow.NewTable(updateHandler, expireHandler, m.pipeline, tid, flow.TableOpts{})
+	packetSeqChan, _ := table.Start()
+
+	capture := &remoteCapture{
+		capture: rc,
+		client:  client,
+		session: session,
+		table:   table,
+		node:    node,
+	}
+
+	m.Lock()
+	m.captures[rc.UUID] = capture
+	m.Unlock()
+
+	// reading the pcap header blocks until tcpdump outputs something
+	go func() {
+		feeder, err := flow.NewPcapTableFeeder(ioutil.NopCloser(stdout), packetSeqChan, false, "")
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to read pcap stream of remote capture %s: %s", rc.UUID, err.Error())
+			m.stopCapture(rc.UUID)
+			return
+		}
+
+		feeder.Start()
+		feeder.Wait()
+
+		if err := session.Wait(); err != nil {
+			logging.GetLogger().Errorf("Remote capture %s on %s terminated: %s", rc.UUID, rc.Host, err.Error())
+		}
+		m.stopCapture(rc.UUID)
+	}()
+
+	logging.GetLogger().Infof("Remote capture %s started on %s", rc.UUID, rc.Host)
+
+	return nil
+}
+
+func (m *RemoteCaptureManager) stopCapture(id string) {
+	m.Lock()
+	capture, found := m.captures[id]
+	if !found {
+		m.Unlock()
+		return
+	}
+	delete(m.captures, id)
+	m.Unlock()
+
+	capture.session.Signal(ssh.SIGTERM)
+	capture.session.Close()
+	capture.client.Close()
+	capture.table.Stop()
+
+	m.graph.Lock()
+	m.graph.DelNode(capture.node)
+	m.graph.Unlock()
+
+	logging.GetLogger().Infof("Remote capture %s stopped on %s", id, capture.capture.Host)
+}
+
+func (m *RemoteCaptureManager) stopAll() {
+	m.RLock()
+	ids := make([]string, 0, len(m.captures))
+	for id := range m.captures {
+		ids = append(ids, id)
+	}
+	m.RUnlock()
+
+	for _, id := range ids {
+		m.stopCapture(id)
+	}
+}
+
+func (m *RemoteCaptureManager) onAPIWatcherEvent(action string, id string, resource types.Resource) {
+	if !m.IsMaster() {
+		return
+	}
+
+	switch action {
+	case "init", "create", "set", "update":
+		rc := resource.(*types.RemoteCapture)
+		if action == "set" || action == "update" {
+			m.stopCapture(id)
+		}
+		if err := m.startCapture(rc); err != nil {
+			logging.GetLogger().Errorf("Failed to start remote capture %s: %s", id, err.Error())
+		}
+	case "expire", "delete":
+		m.stopCapture(id)
+	}
+}
+
+// OnStartAsMaster event
+func (m *RemoteCaptureManager) OnStartAsMaster() {
+}
+
+// OnStartAsSlave event
+func (m *RemoteCaptureManager) OnStartAsSlave() {
+}
+
+// OnSwitchToMaster starts the already defined remote captures
+func (m *RemoteCaptureManager) OnSwitchToMaster() {
+	for id, resource := range m.handler.Index() {
+		if err := m.startCapture(resource.(*types.RemoteCapture)); err != nil {
+			logging.GetLogger().Errorf("Failed to start remote capture %s: %s", id, err.Error())
+		}
+	}
+}
+
+// OnSwitchToSlave stops the running remote captures
+func (m *RemoteCaptureManager) OnSwitchToSlave() {
+	m.stopAll()
+}
+
+// Start the remote capture manager
+func (m *RemoteCaptureManager) Start() {
+	m.pipeline.Start()
+	m.MasterElector.AddEventListener(m)
+	m.StartAndWait()
+
+	m.watcher = m.handler.AsyncWatch(m.onAPIWatcherEvent)
+}
+
+// Stop the remote capture manager
+func (m *RemoteCaptureManager) Stop() {
+	if m.watcher != nil {
+		m.watcher.Stop()
+	}
+	m.stopAll()
+	m.MasterElector.Stop()
+	m.pipeline.Stop()
+}
+
+// NewRemoteCaptureManager returns a new remote capture manager
+func NewRemoteCaptureManager(g *graph.Graph, handler *api.RemoteCaptureAPIHandler, store storage.Storage, etcdClient *etcd.Client) *RemoteCaptureManager {
+	elector := etcd.NewMasterElectorFromConfig(common.AnalyzerService, "remote-capture", etcdClient)
+
+	return &RemoteCaptureManager{
+		MasterElector: elector,
+		graph:         g,
+		handler:       handler,
+		storage:       store,
+		pipeline:      flow.NewEnhancerPipeline(enhancers.NewGraphFlowEnhancer(g)),
+		captures:      make(map[string]*remoteCapture),
+		keyFile:       config.GetString("analyzer.remote_capture.ssh_key"),
+		keyDir:        config.GetString("analyzer.remote_capture.ssh_key_dir"),
+		knownHosts:    config.GetString("analyzer.remote_capture.known_hosts"),
+		tcpdumpPath:   config.GetString("analyzer.remote_capture.tcpdump"),
+		dialTimeout:   time.Duration(config.GetInt("analyzer.remote_capture.timeout")) * time.Second,
+		updateEvery:   time.Duration(config.GetInt("flow.update")) * time.Second,
+		expireEvery:   time.Duration(config.GetInt("flow.expire")) * time.Second,
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/flow"
+)
+
+func TestRemoteCaptureTcpdumpCommand(t *testing.T) {
+	m := &RemoteCaptureManager{tcpdumpPath: "tcpdump"}
+
+	cmd, err := m.tcpdumpCommand(&types.RemoteCapture{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := fmt.Sprintf("tcpdump -U -n -s %d -w - -i 'any'", flow.MaxCaptureLength); cmd != expected {
+		t.Errorf("Expected the capture on any interface %s, got: %s", expected, cmd)
+	}
+
+	cmd, err = m.tcpdumpCommand(&types.RemoteCapture{Interface: "eth0.100", BPFFilter: "host 'a'", Sudo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(cmd, "sudo -n tcpdump ") || !strings.HasSuffix(cmd, `-i 'eth0.100' 'host '\''a'\'''`) {
+		t.Errorf("Expected the interface and the filter to be quoted, got: %s", cmd)
+	}
+
+	for _, iface := range []string{"x'; rm -rf / #", "eth0 eth1", "$(reboot)", "eth0`id`"} {
+		if cmd, err := m.tcpdumpCommand(&types.RemoteCapture{Interface: iface}); err == nil {
+			t.Errorf("Expected the interface %s to be rejected, got: %s", iface, cmd)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for s, expected := range map[string]string{
+		"":        "''",
+		"eth0":    "'eth0'",
+		"a'b":     `'a'\''b'`,
+		"'; id #": `''\''; id #'`,
+	} {
+		if quoted := shellQuote(s); quoted != expected {
+			t.Errorf("Expected %s to be quoted as %s, got: %s", s, expected, quoted)
+		}
+	}
+}
+
+func newTestSSHKey(t *testing.T, path string) ssh.PublicKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func TestRemoteCaptureSSHConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-remote-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyDir := filepath.Join(dir, "keys")
+	if err := os.Mkdir(keyDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	newTestSSHKey(t, filepath.Join(keyDir, "id_rsa"))
+	hostKey := newTestSSHKey(t, filepath.Join(dir, "host"))
+	otherKey := newTestSSHKey(t, filepath.Join(dir, "other"))
+
+	m := &RemoteCaptureManager{
+		keyFile:    filepath.Join(keyDir, "id_rsa"),
+		keyDir:     keyDir,
+		knownHosts: filepath.Join(dir, "known_hosts"),
+	}
+
+	for _, keyFile := range []string{"../host", filepath.Join(dir, "host"), "..", "missing"} {
+		rc := &types.RemoteCapture{KeyFile: keyFile, HostKey: string(ssh.MarshalAuthorizedKey(hostKey))}
+		if _, err := m.sshConfig(rc, "10.0.0.1:22"); err == nil {
+			t.Errorf("Expected the key file %s to be rejected", keyFile)
+		}
+	}
+
+	if _, err := m.sshConfig(&types.RemoteCapture{}, "10.0.0.1:22"); err == nil {
+		t.Error("Expected the connection to be refused without host key")
+	}
+
+	rc := &types.RemoteCapture{KeyFile: "id_rsa", HostKey: string(ssh.MarshalAuthorizedKey(hostKey))}
+	cfg, err := m.sshConfig(rc, "10.0.0.1:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.HostKeyCallback("10.0.0.1:22", nil, hostKey); err != nil {
+		t.Errorf("Expected the given host key to be accepted, got: %s", err)
+	}
+	if err := cfg.HostKeyCallback("10.0.0.1:22", nil, otherKey); err == nil {
+		t.Error("Expected another host key to be rejected")
+	}
+
+	// hashed entry for 10.0.0.2 and plain entry for 10.0.0.3 on port 2222
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte("10.0.0.2"))
+	hashed := "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	authorized := string(ssh.MarshalAuthorizedKey(hostKey))
+	knownHosts := "# comment\n" + hashed + " " + authorized + "[10.0.0.3]:2222 " + authorized
+	if err := ioutil.WriteFile(m.knownHosts, []byte(knownHosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, address := range []string{"10.0.0.2:22", "10.0.0.3:2222"} {
+		cfg, err := m.sshConfig(&types.RemoteCapture{}, address)
+		if err != nil {
+			t.Fatalf("Expected the known host key of %s to be used, got: %s", address, err)
+		}
+		if err := cfg.HostKeyCallback(address, nil, hostKey); err != nil {
+			t.Errorf("Expected the known host key of %s to be accepted, got: %s", address, err)
+		}
+		if err := cfg.HostKeyCallback(address, nil, otherKey); err == nil {
+			t.Errorf("Expected another host key of %s to be rejected", address)
+		}
+	}
+
+	for _, address := range []string{"10.0.0.3:22", "10.0.0.4:22"} {
+		if _, err := m.sshConfig(&types.RemoteCapture{}, address); err == nil {
+			t.Errorf("Expected the connection to %s to be refused without known host key", address)
+		}
+	}
+}
@@ -23,9 +23,11 @@
 package analyzer
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/skydive-project/dede/dede"
@@ -37,6 +39,7 @@ import (
 	"github.com/skydive-project/skydive/etcd"
 	"github.com/skydive-project/skydive/flow"
 	ondemand "github.com/skydive-project/skydive/flow/ondemand/client"
+	"github.com/skydive-project/skydive/flow/report"
 	"github.com/skydive-project/skydive/flow/storage"
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	shttp "github.com/skydive-project/skydive/http"
@@ -62,12 +65,30 @@ type Server struct {
 	onDemandClient      *ondemand.OnDemandProbeClient
 	piClient            *packet_injector.PacketInjectorClient
 	metadataManager     *metadata.UserMetadataManager
+	maintenanceManager  *metadata.MaintenanceManager
+	tagManager          *metadata.TagManager
+	remoteCaptures      *RemoteCaptureManager
+	serviceAccounts     *api.ServiceAccountAPIHandler
+	trafficWeigher      *TrafficWeigher
+	mtuChecker          *MTUChecker
+	loopDetector        *LoopDetector
+	slaEvaluator        *SLAEvaluator
+	reportScheduler     *report.Scheduler
+	queryScheduler      *report.QueryScheduler
+	federator           *Federator
 	flowServer          *FlowServer
+	flowMatrix          *FlowMatrix
+	threatMatcher       *ThreatMatcher
+	flowRetainer        *FlowRetainer
 	probeBundle         *probe.ProbeBundle
 	storage             storage.Storage
+	journal             *graph.Journal
 	embeddedEtcd        *etcd.EmbeddedEtcd
 	etcdClient          *etcd.Client
+	graph               *graph.Graph
+	rates               *rateMeters
 	wgServers           sync.WaitGroup
+	state               int64
 }
 
 // GetStatus returns the status of an analyzer
@@ -84,7 +105,7 @@ func (s *Server) GetStatus() interface{} {
 		}
 	}
 
-	return &types.AnalyzerStatus{
+	status := &types.AnalyzerStatus{
 		Agents:      s.agentWSServer.GetStatus(),
 		Peers:       peersStatus,
 		Publishers:  s.publisherWSServer.GetStatus(),
@@ -93,6 +114,32 @@ func (s *Server) GetStatus() interface{} {
 		Captures:    types.ElectionStatus{IsMaster: s.onDemandClient.IsMaster()},
 		Probes:      s.probeBundle.ActiveProbes(),
 	}
+
+	if s.federator != nil {
+		status.Sites = s.federator.GetSites()
+	}
+
+	status.Health = make(map[string]string)
+	for name, check := range s.GetHealthChecks() {
+		if err := check(); err != nil {
+			status.Health[name] = err.Error()
+		} else {
+			status.Health[name] = "ok"
+		}
+	}
+
+	now := time.Now()
+	received, queue, size := s.flowServer.Received()
+	status.Flows = types.FlowServerStatus{
+		Received:  received,
+		Rate:      s.rates.rate("flows", received, now),
+		Queue:     queue,
+		QueueSize: size,
+	}
+
+	status.AgentsInfo = agentsInfo(s.graph, status.Agents, s.rates, now)
+
+	return status
 }
 
 // createStartupCapture creates capture based on preconfigured selected SubGraph
@@ -115,6 +162,9 @@ func (s *Server) Start() error {
 		s.storage.Start()
 	}
 
+	// the service accounts are watched before serving their tokens
+	s.serviceAccounts.Start()
+
 	if err := s.httpServer.Listen(); err != nil {
 		return err
 	}
@@ -126,6 +176,39 @@ func (s *Server) Start() error {
 	s.piClient.Start()
 	s.alertServer.Start()
 	s.metadataManager.Start()
+	s.maintenanceManager.Start()
+	s.tagManager.Start()
+	s.remoteCaptures.Start()
+	if s.trafficWeigher != nil {
+		s.trafficWeigher.Start()
+	}
+	if s.mtuChecker != nil {
+		s.mtuChecker.Start()
+	}
+	if s.loopDetector != nil {
+		s.loopDetector.Start()
+	}
+	if s.slaEvaluator != nil {
+		s.slaEvaluator.Start()
+	}
+	if s.reportScheduler != nil {
+		s.reportScheduler.Start()
+	}
+	if s.queryScheduler != nil {
+		s.queryScheduler.Start()
+	}
+	if s.federator != nil {
+		s.federator.Start()
+	}
+	if s.flowMatrix != nil {
+		s.flowMatrix.Start()
+	}
+	if s.threatMatcher != nil {
+		s.threatMatcher.Start()
+	}
+	if s.flowRetainer != nil {
+		s.flowRetainer.Start()
+	}
 	s.flowServer.Start()
 	s.agentWSServer.Start()
 	s.publisherWSServer.Start()
@@ -138,12 +221,49 @@ func (s *Server) Start() error {
 		s.httpServer.Serve()
 	}()
 
+	atomic.StoreInt64(&s.state, common.RunningState)
+
 	return nil
 }
 
+// IsStarted returns whether the analyzer services are started
+func (s *Server) IsStarted() bool {
+	return atomic.LoadInt64(&s.state) == common.RunningState
+}
+
+// GetHealthChecks returns the checks of the analyzer subsystems
+func (s *Server) GetHealthChecks() map[string]api.HealthCheck {
+	checks := map[string]api.HealthCheck{
+		"probes": func() error {
+			if !s.probeBundle.IsStarted() {
+				return errors.New("Topology probes not started")
+			}
+			return nil
+		},
+		"etcd": s.etcdClient.Ping,
+	}
+
+	if s.storage != nil {
+		checks["storage"] = s.storage.Ping
+	}
+
+	return checks
+}
+
 // Stop the analyzer server
 func (s *Server) Stop() {
+	atomic.StoreInt64(&s.state, common.StoppingState)
+
 	s.flowServer.Stop()
+	if s.flowMatrix != nil {
+		s.flowMatrix.Stop()
+	}
+	if s.threatMatcher != nil {
+		s.threatMatcher.Stop()
+	}
+	if s.flowRetainer != nil {
+		s.flowRetainer.Stop()
+	}
 	s.agentWSServer.Stop()
 	s.publisherWSServer.Stop()
 	s.replicationWSServer.Stop()
@@ -160,8 +280,36 @@ func (s *Server) Stop() {
 	s.piClient.Stop()
 	s.alertServer.Stop()
 	s.metadataManager.Stop()
+	s.maintenanceManager.Stop()
+	s.tagManager.Stop()
+	s.remoteCaptures.Stop()
+	s.serviceAccounts.Stop()
+	if s.trafficWeigher != nil {
+		s.trafficWeigher.Stop()
+	}
+	if s.mtuChecker != nil {
+		s.mtuChecker.Stop()
+	}
+	if s.loopDetector != nil {
+		s.loopDetector.Stop()
+	}
+	if s.slaEvaluator != nil {
+		s.slaEvaluator.Stop()
+	}
+	if s.reportScheduler != nil {
+		s.reportScheduler.Stop()
+	}
+	if s.queryScheduler != nil {
+		s.queryScheduler.Stop()
+	}
+	if s.federator != nil {
+		s.federator.Stop()
+	}
 	s.etcdClient.Stop()
 	s.wgServers.Wait()
+	if s.journal != nil {
+		s.journal.Close()
+	}
 	if tr, ok := http.DefaultTransport.(interface {
 		CloseIdleConnections()
 	}); ok {
@@ -223,9 +371,22 @@ func NewServerFromConfig() (*Server, error) {
 
 	g := graph.NewGraphFromConfig(cached)
 
+	if keys := config.GetStringSlice("analyzer.topology.scrub_metadata"); len(keys) > 0 {
+		g.Use(graph.ScrubMetadataMiddleware(keys...))
+	}
+
 	authOptions := NewAnalyzerAuthenticationOpts()
 
 	agentWSServer := shttp.NewWSStructServer(shttp.NewWSServer(hserver, "/ws/agent"))
+	agentWSServer.SetResumeDelay(agentResumeDelay())
+
+	registrationVerifier, err := shttp.NewRegistrationVerifierFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	if registrationVerifier != nil {
+		agentWSServer.SetRegistrationVerifier(registrationVerifier)
+	}
 	_, err = NewTopologyAgentEndpoint(agentWSServer, authOptions, cached, g)
 	if err != nil {
 		return nil, err
@@ -246,6 +407,15 @@ func NewServerFromConfig() (*Server, error) {
 	subscriberWSServer := shttp.NewWSStructServer(shttp.NewWSServer(hserver, "/ws/subscriber"))
 	topology.NewTopologySubscriberEndpoint(subscriberWSServer, authOptions, g)
 
+	// limits only apply to the endpoints used by the UI and the scripts
+	wsRateLimit := shttp.WSRateLimit{
+		Rate:           int64(config.GetInt("analyzer.ws.rate_limit")),
+		Burst:          int64(config.GetInt("analyzer.ws.rate_limit_burst")),
+		MaxConnections: config.GetInt("analyzer.ws.max_subscriptions"),
+	}
+	publisherWSServer.SetRateLimit(wsRateLimit)
+	subscriberWSServer.SetRateLimit(wsRateLimit)
+
 	probeBundle, err := NewTopologyProbeBundleFromConfig(g)
 	if err != nil {
 		return nil, err
@@ -266,13 +436,57 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
+	maintenanceAPIHandler, err := api.RegisterMaintenanceAPI(apiServer)
+	if err != nil {
+		return nil, err
+	}
+
+	tagAPIHandler, err := api.RegisterTagAPI(apiServer)
+	if err != nil {
+		return nil, err
+	}
+
+	groupAPIHandler, err := api.RegisterGroupAPI(apiServer)
+	if err != nil {
+		return nil, err
+	}
+
+	savedSearchAPIHandler, err := api.RegisterSavedSearchAPI(apiServer)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := api.RegisterDashboardAPI(apiServer, savedSearchAPIHandler); err != nil {
+		return nil, err
+	}
+
 	piAPIHandler, err := api.RegisterPacketInjectorAPI(g, apiServer)
 	if err != nil {
 		return nil, err
 	}
 	piClient := packet_injector.NewPacketInjectorClient(agentWSServer, etcdClient, piAPIHandler, g)
 
-	alertAPIHandler, err := api.RegisterAlertAPI(apiServer)
+	storage, err := storage.NewStorageFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	alertAPIHandler, err := api.RegisterAlertAPI(apiServer, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteCaptureAPIHandler, err := api.RegisterRemoteCaptureAPI(apiServer)
+	if err != nil {
+		return nil, err
+	}
+
+	slaMonitorAPIHandler, err := api.RegisterSLAMonitorAPI(apiServer, g)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceAccountAPIHandler, err := api.RegisterServiceAccountAPI(apiServer)
 	if err != nil {
 		return nil, err
 	}
@@ -280,25 +494,95 @@ func NewServerFromConfig() (*Server, error) {
 	onDemandClient := ondemand.NewOnDemandProbeClient(g, captureAPIHandler, agentWSServer, subscriberWSServer, etcdClient)
 
 	metadataManager := metadata.NewUserMetadataManager(g, metadataAPIHandler)
+	maintenanceManager := metadata.NewMaintenanceManager(g, maintenanceAPIHandler)
+	tagManager := metadata.NewTagManager(g, tagAPIHandler, groupAPIHandler)
 
 	tableClient := flow.NewTableClient(agentWSServer)
 
-	storage, err := storage.NewStorageFromConfig()
+	flowServer, err := NewFlowServer(hserver, g, storage, probeBundle)
 	if err != nil {
 		return nil, err
 	}
 
-	flowServer, err := NewFlowServer(hserver, g, storage, probeBundle)
+	if err := setupAnonymization(g, flowServer); err != nil {
+		return nil, err
+	}
+
+	// journaled last, once scrubbed and anonymized, as stored by the backend
+	var journal *graph.Journal
+	if path := config.GetString("analyzer.topology.journal.path"); path != "" {
+		if journal, err = graph.OpenJournal(path); err != nil {
+			return nil, fmt.Errorf("Unable to open the graph journal: %s", err)
+		}
+
+		g.Lock()
+		g.Use(graph.JournalMiddleware(journal))
+		g.Unlock()
+	}
+
+	if flowChainer := NewFlowChainerFromConfig(); flowChainer != nil {
+		flowServer.AddFlowListener(flowChainer)
+	}
+
+	flowMatrix, err := NewFlowMatrixFromConfig(hserver, g, wsRateLimit)
 	if err != nil {
 		return nil, err
 	}
+	if flowMatrix != nil {
+		flowServer.AddFlowListener(flowMatrix)
+	}
+
+	if fingerprinter, ok := probeBundle.GetProbe("fingerprint").(FlowListener); ok {
+		flowServer.AddFlowListener(fingerprinter)
+	}
+
+	threatMatcher, err := NewThreatMatcherFromConfig(g)
+	if err != nil {
+		return nil, err
+	}
+	if threatMatcher != nil {
+		flowServer.AddFlowListener(threatMatcher)
+	}
+
+	// registered last so that the rules see the fields set by the others
+	flowRetainer, err := NewFlowRetainerFromConfig(storage, etcdClient)
+	if err != nil {
+		return nil, err
+	}
+	if flowRetainer != nil {
+		flowServer.AddFlowListener(flowRetainer)
+	}
 
 	tr := traversal.NewGremlinTraversalParser()
 	tr.AddTraversalExtension(ge.NewMetricsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewFlowTraversalExtension(tableClient, storage))
 	tr.AddTraversalExtension(ge.NewSocketsTraversalExtension())
+	tr.AddTraversalExtension(ge.NewSummarizeTraversalExtension())
+	tr.AddTraversalExtension(ge.NewUtilizationTraversalExtension())
+	tr.AddTraversalExtension(ge.NewTopNTraversalExtension())
+
+	alertServer := alert.NewAlertServer(alertAPIHandler, subscriberWSServer, g, tr, etcdClient, storage)
 
-	alertServer := alert.NewAlertServer(alertAPIHandler, subscriberWSServer, g, tr, etcdClient)
+	remoteCaptures := NewRemoteCaptureManager(g, remoteCaptureAPIHandler, storage, etcdClient)
+	trafficWeigher := NewTrafficWeigherFromConfig(g, storage, etcdClient)
+	mtuChecker := NewMTUCheckerFromConfig(g, storage, etcdClient)
+	loopDetector := NewLoopDetectorFromConfig(g, storage, etcdClient)
+	slaEvaluator := NewSLAEvaluatorFromConfig(g, slaMonitorAPIHandler, storage, etcdClient)
+
+	reportScheduler, err := report.NewSchedulerFromConfig(g, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	queryScheduler, err := report.NewQuerySchedulerFromConfig(g, tr, etcdClient)
+	if err != nil {
+		return nil, err
+	}
+
+	federator, err := NewFederatorFromConfig(g)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &Server{
 		httpServer:          hserver,
@@ -310,20 +594,54 @@ func NewServerFromConfig() (*Server, error) {
 		probeBundle:         probeBundle,
 		embeddedEtcd:        embeddedEtcd,
 		etcdClient:          etcdClient,
+		graph:               g,
+		rates:               newRateMeters(),
 		onDemandClient:      onDemandClient,
 		piClient:            piClient,
 		metadataManager:     metadataManager,
+		maintenanceManager:  maintenanceManager,
+		tagManager:          tagManager,
+		remoteCaptures:      remoteCaptures,
+		serviceAccounts:     serviceAccountAPIHandler,
+		trafficWeigher:      trafficWeigher,
+		mtuChecker:          mtuChecker,
+		loopDetector:        loopDetector,
+		slaEvaluator:        slaEvaluator,
+		reportScheduler:     reportScheduler,
+		queryScheduler:      queryScheduler,
+		federator:           federator,
 		storage:             storage,
+		journal:             journal,
 		flowServer:          flowServer,
+		flowMatrix:          flowMatrix,
+		threatMatcher:       threatMatcher,
+		flowRetainer:        flowRetainer,
 		alertServer:         alertServer,
+		state:               common.StoppedState,
 	}
 
 	s.createStartupCapture(captureAPIHandler)
 
-	api.RegisterTopologyAPI(hserver, g, tr)
-	api.RegisterPcapAPI(hserver, storage)
+	queryCache, err := api.NewQueryCacheFromConfig(g)
+	if err != nil {
+		return nil, err
+	}
+
+	api.RegisterTopologyAPI(hserver, g, tr, queryCache)
+	api.RegisterEventsAPI(hserver, g, tr)
+	api.RegisterPcapAPI(hserver, storage, g)
+	api.RegisterReportAPI(hserver, storage, g)
+	api.RegisterExportAPI(hserver, storage)
+	api.RegisterFlowAPI(hserver, storage)
+	api.RegisterFlowTableAPI(hserver, captureAPIHandler, g, tableClient)
 	api.RegisterConfigAPI(hserver)
 	api.RegisterStatusAPI(hserver, s)
+	api.RegisterHealthAPI(hserver, s)
+	api.RegisterProfileAPI(hserver, agentWSServer)
+
+	if federator != nil {
+		api.RegisterFederationAPI(hserver, federator)
+	}
 
 	dede.RegisterHandler("terminal", "/dede", hserver.Router)
 
@@ -0,0 +1,325 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package analyzer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/skydive-project/skydive/api/server"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+	ge "github.com/skydive-project/skydive/gremlin/traversal"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const slaMonitorType = "slamonitor"
+
+// SLAEvaluator periodically evaluates the SLA monitors against the flows
+// stored during the window, the flows being attributed to the nodes of the
+// source and destination queries through their addresses. The evaluations
+// are kept, up to the history size, in the SLAMonitor metadata of a node
+// per monitor, from which the API serves them. Only the master analyzer
+// evaluates the monitors.
+type SLAEvaluator struct {
+	*etcd.MasterElector
+	graph    *graph.Graph
+	handler  *api.SLAMonitorAPIHandler
+	storage  storage.Storage
+	window   time.Duration
+	interval time.Duration
+	history  int
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// endpoints returns the nodes returned by a Gremlin query and the nodes
+// they own
+func (s *SLAEvaluator) endpoints(query string) (map[graph.Identifier]bool, error) {
+	res, err := ge.TopologyGremlinQuery(s.graph, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var queue []*graph.Node
+	for _, value := range res.Values() {
+		switch v := value.(type) {
+		case *graph.Node:
+			queue = append(queue, v)
+		case []*graph.Node:
+			queue = append(queue, v...)
+		}
+	}
+
+	nodes := make(map[graph.Identifier]bool)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		if nodes[n.ID] {
+			continue
+		}
+		nodes[n.ID] = true
+
+		queue = append(queue, s.graph.LookupChildren(n, nil, topology.OwnershipMetadata)...)
+	}
+	return nodes, nil
+}
+
+// evaluate computes the latency, the throughput and the loss of the flows
+// and compares them to the thresholds of the monitor
+func (s *SLAEvaluator) evaluate(monitor *types.SLAMonitor, flows []*flow.Flow, metrics map[string][]common.Metric, now time.Time) *types.SLAStatus {
+	status := &types.SLAStatus{
+		Timestamp: common.UnixMillis(now),
+		Flows:     len(flows),
+		State:     types.SLANoData,
+	}
+	if len(flows) == 0 {
+		return status
+	}
+
+	var rtt, rttFlows, bytes, segments, skipped int64
+	for _, f := range flows {
+		if f.RTT > 0 {
+			rtt += f.RTT
+			rttFlows++
+		}
+
+		for _, m := range metrics[f.UUID] {
+			for _, field := range []string{"ABBytes", "BABytes"} {
+				v, _ := m.GetFieldInt64(field)
+				bytes += v
+			}
+		}
+
+		if tcp := f.TCPMetric; tcp != nil {
+			segments += tcp.ABPackets + tcp.BAPackets
+			skipped += tcp.ABSegmentSkipped + tcp.BASegmentSkipped
+		}
+	}
+
+	if rttFlows > 0 {
+		status.Latency = int64(time.Duration(rtt/rttFlows) / time.Millisecond)
+	}
+	if seconds := int64(s.window / time.Second); seconds > 0 {
+		status.Throughput = bytes / seconds
+	}
+	if segments > 0 {
+		status.Loss = float64(skipped) * 100 / float64(segments)
+	}
+
+	if monitor.MaxLatency > 0 && status.Latency > monitor.MaxLatency {
+		status.Violations = append(status.Violations, fmt.Sprintf("Latency %dms above %dms", status.Latency, monitor.MaxLatency))
+	}
+	if monitor.MinThroughput > 0 && status.Throughput < monitor.MinThroughput {
+		status.Violations = append(status.Violations, fmt.Sprintf("Throughput %dB/s below %dB/s", status.Throughput, monitor.MinThroughput))
+	}
+	if monitor.MaxLoss > 0 && status.Loss > monitor.MaxLoss {
+		status.Violations = append(status.Violations, fmt.Sprintf("Loss %.2f%% above %.2f%%", status.Loss, monitor.MaxLoss))
+	}
+
+	if len(status.Violations) > 0 {
+		status.State = types.SLAViolated
+	} else {
+		status.State = types.SLACompliant
+	}
+	return status
+}
+
+// record adds an evaluation to the node of the monitor, the oldest ones
+// being dropped beyond the history size
+func (s *SLAEvaluator) record(monitor *types.SLAMonitor, status *types.SLAStatus) {
+	name := monitor.Name
+	if name == "" {
+		name = monitor.UUID
+	}
+
+	id := api.SLAMonitorNodeID(monitor.UUID)
+	n := s.graph.GetNode(id)
+	if n == nil {
+		n = s.graph.NewNode(id, graph.Metadata{
+			"Type":    slaMonitorType,
+			"Manager": "sla",
+			"Name":    name,
+		})
+	}
+
+	previous, history, err := api.SLAMonitorEvaluations(n)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to decode the evaluations of SLA monitor %s: %s", monitor.UUID, err.Error())
+	}
+
+	history = append(history, *status)
+	if len(history) > s.history {
+		history = history[len(history)-s.history:]
+	}
+
+	if status.State == types.SLAViolated && (previous == nil || previous.State != types.SLAViolated) {
+		logging.GetLogger().Warningf("SLA monitor %s violated: %v", name, status.Violations)
+	}
+
+	s.graph.AddMetadata(n, "SLAMonitor", map[string]interface{}{
+		"Status":  status,
+		"History": history,
+	})
+}
+
+func (s *SLAEvaluator) update() {
+	monitors := s.handler.Index()
+
+	now := time.Now()
+	fr := filters.Range{From: common.UnixMillis(now) - int64(s.window/time.Millisecond), To: common.UnixMillis(now)}
+	fsq := filters.SearchQuery{Filter: filters.NewFilterActiveIn(fr, "")}
+
+	var flows []*flow.Flow
+	var metrics map[string][]common.Metric
+	if len(monitors) > 0 {
+		flowset, err := s.storage.SearchFlows(fsq)
+		if err != nil {
+			logging.GetLogger().Errorf("Failed to retrieve the flows for SLA monitors: %s", err.Error())
+			return
+		}
+		flows = flowset.Flows
+
+		if metrics, err = s.storage.SearchMetrics(fsq, filters.NewFilterIncludedIn(fr, "")); err != nil {
+			logging.GetLogger().Errorf("Failed to retrieve the flow metrics for SLA monitors: %s", err.Error())
+			return
+		}
+	}
+
+	s.graph.Lock()
+	defer s.graph.Unlock()
+
+	index := topology.NewAddressIndex(s.graph)
+	type flowEnds struct{ a, b *graph.Node }
+	ends := make([]flowEnds, len(flows))
+	for i, f := range flows {
+		ends[i] = flowEnds{
+			a: index.Lookup(f.GetLink().GetA(), f.GetNetwork().GetA()),
+			b: index.Lookup(f.GetLink().GetB(), f.GetNetwork().GetB()),
+		}
+	}
+
+	for id, resource := range monitors {
+		monitor := resource.(*types.SLAMonitor)
+
+		src, err := s.endpoints(monitor.Source)
+		if err != nil {
+			logging.GetLogger().Errorf("Gremlin error in the source of SLA monitor %s: %s", id, err.Error())
+			continue
+		}
+
+		dst, err := s.endpoints(monitor.Destination)
+		if err != nil {
+			logging.GetLogger().Errorf("Gremlin error in the destination of SLA monitor %s: %s", id, err.Error())
+			continue
+		}
+
+		var matched []*flow.Flow
+		for i, f := range flows {
+			a, b := ends[i].a, ends[i].b
+			if a == nil || b == nil {
+				continue
+			}
+
+			if (src[a.ID] && dst[b.ID]) || (src[b.ID] && dst[a.ID]) {
+				matched = append(matched, f)
+			}
+		}
+
+		s.record(monitor, s.evaluate(monitor, matched, metrics, now))
+	}
+
+	// remove the nodes of the deleted monitors
+	for _, n := range s.graph.GetNodes(graph.Metadata{"Type": slaMonitorType}) {
+		found := false
+		for id := range monitors {
+			if api.SLAMonitorNodeID(id) == n.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.graph.DelNode(n)
+		}
+	}
+}
+
+func (s *SLAEvaluator) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.IsMaster() {
+				s.update()
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Start the SLA evaluator
+func (s *SLAEvaluator) Start() {
+	s.StartAndWait()
+
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop the SLA evaluator
+func (s *SLAEvaluator) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+	s.MasterElector.Stop()
+}
+
+// NewSLAEvaluatorFromConfig returns a new SLA evaluator, nil if no flow
+// storage is configured
+func NewSLAEvaluatorFromConfig(g *graph.Graph, handler *api.SLAMonitorAPIHandler, store storage.Storage, etcdClient *etcd.Client) *SLAEvaluator {
+	if store == nil {
+		return nil
+	}
+
+	return &SLAEvaluator{
+		MasterElector: etcd.NewMasterElectorFromConfig(common.AnalyzerService, "sla-evaluator", etcdClient),
+		graph:         g,
+		handler:       handler,
+		storage:       store,
+		window:        time.Duration(config.GetInt("analyzer.sla.window")) * time.Second,
+		interval:      time.Duration(config.GetInt("analyzer.sla.interval")) * time.Second,
+		history:       config.GetInt("analyzer.sla.history"),
+		quit:          make(chan struct{}),
+	}
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	api "github.com/skydive-project/skydive/api/server"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/etcd/etcdtest"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// fakeFlowStorage serves a fixed set of flows and metrics, recording the
+// last search
+type fakeFlowStorage struct {
+	flows   []*flow.Flow
+	metrics map[string][]common.Metric
+	query   filters.SearchQuery
+}
+
+func (s *fakeFlowStorage) Start() {}
+func (s *fakeFlowStorage) Stop()  {}
+
+func (s *fakeFlowStorage) Ping() error {
+	return nil
+}
+
+func (s *fakeFlowStorage) StoreFlows(flows []*flow.Flow) error {
+	return nil
+}
+
+func (s *fakeFlowStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+	s.query = fsq
+	return &flow.FlowSet{Flows: s.flows}, nil
+}
+
+func (s *fakeFlowStorage) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
+	return s.metrics, nil
+}
+
+func (s *fakeFlowStorage) SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error) {
+	return nil, nil
+}
+
+func newTestSLAFlow(uuid, a, b string, rtt time.Duration) *flow.Flow {
+	return &flow.Flow{
+		UUID:    uuid,
+		Network: &flow.FlowLayer{A: a, B: b},
+		RTT:     int64(rtt),
+	}
+}
+
+func TestSLAEvaluate(t *testing.T) {
+	s := &SLAEvaluator{window: 10 * time.Second}
+
+	flows := []*flow.Flow{
+		newTestSLAFlow("aaa", "10.0.0.1", "10.0.0.2", 10*time.Millisecond),
+		newTestSLAFlow("bbb", "10.0.0.1", "10.0.0.2", 30*time.Millisecond),
+		// the flows without RTT are not part of the latency
+		newTestSLAFlow("ccc", "10.0.0.1", "10.0.0.2", 0),
+	}
+	flows[0].TCPMetric = &flow.TCPMetric{ABPackets: 150, BAPackets: 50, ABSegmentSkipped: 4, BASegmentSkipped: 1}
+	flows[1].TCPMetric = &flow.TCPMetric{ABPackets: 200, BAPackets: 100}
+
+	metrics := map[string][]common.Metric{
+		"aaa": {&flow.FlowMetric{ABBytes: 5000, BABytes: 1000}, &flow.FlowMetric{ABBytes: 3000}},
+		"ccc": {&flow.FlowMetric{BABytes: 1000}},
+	}
+
+	now := time.Now()
+	for _, test := range []struct {
+		name       string
+		monitor    *types.SLAMonitor
+		flows      []*flow.Flow
+		state      string
+		violations int
+	}{
+		{
+			name:    "no flow",
+			monitor: &types.SLAMonitor{MaxLatency: 10},
+			state:   types.SLANoData,
+		},
+		{
+			name:    "compliant",
+			monitor: &types.SLAMonitor{MaxLatency: 20, MinThroughput: 1000, MaxLoss: 1},
+			flows:   flows,
+			state:   types.SLACompliant,
+		},
+		{
+			name:       "latency",
+			monitor:    &types.SLAMonitor{MaxLatency: 19},
+			flows:      flows,
+			state:      types.SLAViolated,
+			violations: 1,
+		},
+		{
+			name:       "throughput",
+			monitor:    &types.SLAMonitor{MinThroughput: 1001},
+			flows:      flows,
+			state:      types.SLAViolated,
+			violations: 1,
+		},
+		{
+			name:       "loss",
+			monitor:    &types.SLAMonitor{MaxLoss: 0.5},
+			flows:      flows,
+			state:      types.SLAViolated,
+			violations: 1,
+		},
+		{
+			name:       "all",
+			monitor:    &types.SLAMonitor{MaxLatency: 1, MinThroughput: 1000000, MaxLoss: 0.1},
+			flows:      flows,
+			state:      types.SLAViolated,
+			violations: 3,
+		},
+	} {
+		status := s.evaluate(test.monitor, test.flows, metrics, now)
+		if status.State != test.state || len(status.Violations) != test.violations {
+			t.Errorf("%s: expected %s with %d violations, got: %+v", test.name, test.state, test.violations, status)
+		}
+		if status.Timestamp != common.UnixMillis(now) || status.Flows != len(test.flows) {
+			t.Errorf("%s: expected the evaluation of %d flows at %s, got: %+v", test.name, len(test.flows), now, status)
+		}
+		if test.flows == nil {
+			continue
+		}
+
+		// 20ms of average RTT, 10000 bytes during 10s and 5 segments
+		// skipped out of 500
+		if status.Latency != 20 || status.Throughput != 1000 || status.Loss != 1 {
+			t.Errorf("%s: unexpected measures: %+v", test.name, status)
+		}
+	}
+}
+
+func TestSLAEvaluatorUpdate(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	g := newTestGraph(t, "sla")
+	handler := &api.SLAMonitorAPIHandler{
+		BasicAPIHandler: api.BasicAPIHandler{
+			ResourceHandler: &api.SLAMonitorResourceHandler{},
+			EtcdKeyAPI:      server.Client.KeysAPI,
+		},
+		Graph: g,
+	}
+
+	g.Lock()
+	client := g.NewNode(graph.GenID(), graph.Metadata{"Name": "client"})
+	intf := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "IPV4": []string{"10.0.0.1/24"}})
+	topology.AddOwnershipLink(g, client, intf, nil)
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "server", "IPV4": []string{"10.0.0.2"}})
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "other", "IPV4": []string{"10.0.0.3"}})
+	g.Unlock()
+
+	monitor := handler.New().(*types.SLAMonitor)
+	monitor.Source = "G.V().Has('Name', 'client')"
+	monitor.Destination = "G.V().Has('Name', 'server')"
+	monitor.MaxLatency = 20
+	if err := handler.Create(monitor); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &fakeFlowStorage{
+		flows: []*flow.Flow{
+			// owned by the source, answered by the destination
+			newTestSLAFlow("aaa", "10.0.0.2", "10.0.0.1", 10*time.Millisecond),
+			// not between the source and the destination
+			newTestSLAFlow("bbb", "10.0.0.3", "10.0.0.2", time.Second),
+			// unknown endpoints
+			newTestSLAFlow("ccc", "192.168.0.1", "10.0.0.2", time.Second),
+		},
+	}
+
+	s := &SLAEvaluator{
+		graph:   g,
+		handler: handler,
+		storage: store,
+		window:  time.Minute,
+		history: 2,
+	}
+
+	evaluations := func() (*types.SLAStatus, []types.SLAStatus) {
+		g.RLock()
+		defer g.RUnlock()
+
+		n := g.GetNode(api.SLAMonitorNodeID(monitor.UUID))
+		if n == nil {
+			t.Fatal("Expected the node of the monitor to be created")
+		}
+
+		status, history, err := api.SLAMonitorEvaluations(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status == nil {
+			t.Fatal("Expected the monitor to be evaluated")
+		}
+		return status, history
+	}
+
+	s.update()
+
+	// the flows are the ones active within the window
+	var from, to int64
+	for _, f := range store.query.Filter.BoolFilter.Filters {
+		if f.GteInt64Filter != nil {
+			from = f.GteInt64Filter.Value
+		}
+		if f.LteInt64Filter != nil {
+			to = f.LteInt64Filter.Value
+		}
+	}
+	if to-from != int64(time.Minute/time.Millisecond) {
+		t.Errorf("Expected the flows of the last minute to be searched, got: %+v", store.query.Filter)
+	}
+
+	status, history := evaluations()
+	if status.State != types.SLACompliant || status.Flows != 1 || status.Latency != 10 {
+		t.Errorf("Expected the flow between the source and the destination to be compliant, got: %+v", status)
+	}
+	if len(history) != 1 {
+		t.Errorf("Expected a single evaluation, got: %+v", history)
+	}
+
+	// the latency raises above the threshold
+	store.flows[0].RTT = int64(30 * time.Millisecond)
+	s.update()
+
+	status, history = evaluations()
+	if status.State != types.SLAViolated || len(status.Violations) != 1 {
+		t.Errorf("Expected the monitor to be violated, got: %+v", status)
+	}
+	if states := []string{history[0].State, history[1].State}; !reflect.DeepEqual(states, []string{types.SLACompliant, types.SLAViolated}) {
+		t.Errorf("Expected the history of the transition, got: %v", states)
+	}
+
+	// no flow anymore, the history being bounded
+	store.flows = nil
+	s.update()
+
+	status, history = evaluations()
+	if status.State != types.SLANoData {
+		t.Errorf("Expected no data without flow, got: %+v", status)
+	}
+	if len(history) != 2 || history[0].State != types.SLAViolated || history[1].State != types.SLANoData {
+		t.Errorf("Expected the history to keep the 2 last evaluations, got: %+v", history)
+	}
+
+	handler.Decorate(monitor)
+	if monitor.Compliance != 0 || len(monitor.History) != 2 {
+		t.Errorf("Expected the compliance of the evaluations with data, got: %+v", monitor)
+	}
+
+	// the node of a deleted monitor is removed
+	if err := handler.Delete(monitor.UUID); err != nil {
+		t.Fatal(err)
+	}
+	s.update()
+
+	g.RLock()
+	defer g.RUnlock()
+	if g.GetNode(api.SLAMonitorNodeID(monitor.UUID)) != nil {
+		t.Error("Expected the node of the deleted monitor to be removed")
+	}
+}
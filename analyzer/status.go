@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// rateMeters computes the rates of counters between two status requests,
+// the rate being kept when requested again within a second
+type rateMeters struct {
+	sync.Mutex
+	samples map[string]*rateSample
+}
+
+type rateSample struct {
+	time  time.Time
+	count int64
+	rate  float64
+}
+
+// rate returns the rate per second of the named counter since its previous
+// sample, 0 for the first one or when the counter was reset
+func (m *rateMeters) rate(name string, count int64, now time.Time) float64 {
+	m.Lock()
+	defer m.Unlock()
+
+	sample, found := m.samples[name]
+	if !found {
+		m.samples[name] = &rateSample{time: now, count: count}
+		return 0
+	}
+
+	elapsed := now.Sub(sample.time)
+	if elapsed < time.Second {
+		return sample.rate
+	}
+
+	sample.rate = 0
+	if count >= sample.count {
+		sample.rate = float64(count-sample.count) / elapsed.Seconds()
+	}
+	sample.time, sample.count = now, count
+	return sample.rate
+}
+
+func newRateMeters() *rateMeters {
+	return &rateMeters{samples: make(map[string]*rateSample)}
+}
+
+// stringList returns the strings of a metadata list, set either locally as
+// a slice of strings or decoded from a replica as a slice of interfaces
+func stringList(value interface{}, _ error) []string {
+	var list []string
+	switch value := value.(type) {
+	case []string:
+		list = append(list, value...)
+	case []interface{}:
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// agentsInfo returns the agents of the topology, known by their host node
+// or their connection, with the counters of their capture nodes
+func agentsInfo(g *graph.Graph, agents map[string]shttp.WSConnStatus, meters *rateMeters, now time.Time) map[string]types.AgentInfo {
+	infos := make(map[string]*types.AgentInfo)
+	info := func(host string) *types.AgentInfo {
+		i, found := infos[host]
+		if !found {
+			i = &types.AgentInfo{}
+			infos[host] = i
+		}
+		return i
+	}
+
+	for host, status := range agents {
+		if status.ServiceType == common.AgentService {
+			info(host).Connected = status.State != nil && atomic.LoadInt32((*int32)(status.State)) == common.RunningState
+		}
+	}
+
+	g.RLock()
+	for _, n := range g.GetNodes(graph.Metadata{"Type": "host"}) {
+		if _, err := n.GetField("Probes"); err != nil {
+			continue
+		}
+		i := info(n.Host())
+		i.TopologyProbes = stringList(n.GetField("Probes.Topology"))
+		i.FlowProbes = stringList(n.GetField("Probes.Flow"))
+	}
+
+	for _, n := range g.GetNodes(nil) {
+		if id, _ := n.GetFieldString("Capture.ID"); id == "" {
+			continue
+		}
+
+		counter := func(field string) int64 {
+			value, _ := n.GetFieldInt64("Capture." + field)
+			return value
+		}
+
+		i := info(n.Host())
+		i.Captures++
+		i.Packets += counter("Packets")
+		i.Bytes += counter("Bytes")
+		i.PacketsDropped += counter("PacketsDropped")
+		i.ParseErrors += counter("PacketsDecodingErrors") + counter("PacketsMalformed")
+		i.FlowsCreated += counter("FlowsCreated")
+	}
+	g.RUnlock()
+
+	result := make(map[string]types.AgentInfo, len(infos))
+	for host, i := range infos {
+		i.FlowRate = meters.rate("agent/"+host, i.FlowsCreated, now)
+		result[host] = *i
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestRateMeters(t *testing.T) {
+	meters := newRateMeters()
+	now := time.Now()
+
+	if rate := meters.rate("flows", 100, now); rate != 0 {
+		t.Errorf("Expected no rate for the first sample, got: %f", rate)
+	}
+
+	if rate := meters.rate("flows", 300, now.Add(2*time.Second)); rate != 100 {
+		t.Errorf("Expected 100 flows per second, got: %f", rate)
+	}
+
+	// requested again within a second
+	if rate := meters.rate("flows", 1000, now.Add(2500*time.Millisecond)); rate != 100 {
+		t.Errorf("Expected the previous rate to be kept, got: %f", rate)
+	}
+
+	if rate := meters.rate("flows", 500, now.Add(4*time.Second)); rate != 100 {
+		t.Errorf("Expected 100 flows per second since the previous sample, got: %f", rate)
+	}
+
+	// the counter was reset by a restart
+	if rate := meters.rate("flows", 10, now.Add(5*time.Second)); rate != 0 {
+		t.Errorf("Expected no rate after a reset of the counter, got: %f", rate)
+	}
+
+	// the counters are independent
+	if rate := meters.rate("other", 10, now.Add(5*time.Second)); rate != 0 {
+		t.Errorf("Expected no rate for the first sample of another counter, got: %f", rate)
+	}
+}
+
+func TestStringList(t *testing.T) {
+	if list := stringList([]string{"netlink", "ovsdb"}, nil); !reflect.DeepEqual(list, []string{"netlink", "ovsdb"}) {
+		t.Errorf("Expected the local list, got: %v", list)
+	}
+	if list := stringList([]interface{}{"pcap", 1, "afpacket"}, nil); !reflect.DeepEqual(list, []string{"pcap", "afpacket"}) {
+		t.Errorf("Expected the strings of the replicated list, got: %v", list)
+	}
+	if list := stringList(nil, common.ErrFieldNotFound); list != nil {
+		t.Errorf("Expected no list for a missing field, got: %v", list)
+	}
+}
+
+func TestAgentsInfo(t *testing.T) {
+	g := newTestGraph(t, "analyzer")
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{
+		"Type": "host",
+		"Name": "host1",
+		"Probes": map[string]interface{}{
+			"Topology": []string{"netlink", "ovsdb"},
+			"Flow":     []interface{}{"pcap"},
+		},
+	}, "host1")
+	g.NewNode(graph.GenID(), graph.Metadata{
+		"Type": "host",
+		"Name": "host2",
+	}, "host2")
+	g.NewNode(graph.GenID(), graph.Metadata{
+		"Type": "device",
+		"Name": "eth0",
+		"Capture": map[string]interface{}{
+			"ID":                    "capture1",
+			"Packets":               int64(1000),
+			"Bytes":                 int64(64000),
+			"PacketsDropped":        int64(10),
+			"PacketsDecodingErrors": int64(2),
+			"PacketsMalformed":      int64(1),
+			"FlowsCreated":          int64(100),
+		},
+	}, "host1")
+	g.NewNode(graph.GenID(), graph.Metadata{
+		"Type": "device",
+		"Name": "eth1",
+		"Capture": map[string]interface{}{
+			"ID":           "capture2",
+			"Packets":      int64(500),
+			"FlowsCreated": int64(50),
+		},
+	}, "host1")
+	g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth2"}, "host1")
+	g.Unlock()
+
+	running := shttp.WSConnState(common.RunningState)
+	stopped := shttp.WSConnState(common.StoppedState)
+	agents := map[string]shttp.WSConnStatus{
+		"host1":     {ServiceType: common.AgentService, State: &running},
+		"host3":     {ServiceType: common.AgentService, State: &stopped},
+		"analyzer2": {ServiceType: common.AnalyzerService, State: &running},
+	}
+
+	meters := newRateMeters()
+	now := time.Now()
+	infos := agentsInfo(g, agents, meters, now)
+
+	// neither the analyzers nor the host nodes without probes
+	if len(infos) != 2 {
+		t.Fatalf("Expected the agents of host1 and host3, got: %+v", infos)
+	}
+
+	expected := types.AgentInfo{
+		Connected:      true,
+		TopologyProbes: []string{"netlink", "ovsdb"},
+		FlowProbes:     []string{"pcap"},
+		Captures:       2,
+		Packets:        1500,
+		Bytes:          64000,
+		PacketsDropped: 10,
+		ParseErrors:    3,
+		FlowsCreated:   150,
+	}
+	if info := infos["host1"]; !reflect.DeepEqual(info, expected) {
+		t.Errorf("Expected the info of host1 %+v, got: %+v", expected, info)
+	}
+
+	if info := infos["host3"]; info.Connected || info.Captures != 0 {
+		t.Errorf("Expected host3 to be a disconnected agent without capture, got: %+v", info)
+	}
+
+	// the flow rate is computed from the previous status
+	g.Lock()
+	for _, n := range g.GetNodes(graph.Metadata{"Name": "eth1"}) {
+		g.AddMetadata(n, "Capture.FlowsCreated", int64(250))
+	}
+	g.Unlock()
+
+	infos = agentsInfo(g, agents, meters, now.Add(2*time.Second))
+	if info := infos["host1"]; info.FlowsCreated != 350 || info.FlowRate != 100 {
+		t.Errorf("Expected 100 flows created per second, got: %+v", info)
+	}
+}
+
+func TestFlowServerReceived(t *testing.T) {
+	s := &FlowServer{ch: make(chan *flow.Flow, 10)}
+
+	s.storeFlows([]*flow.Flow{{}, {}, {}})
+	s.storeFlows(nil)
+	s.ch <- &flow.Flow{}
+
+	received, queue, size := s.Received()
+	if received != 3 || queue != 1 || size != 10 {
+		t.Errorf("Expected 3 flows received, 1 out of 10 queued, got: %d, %d out of %d", received, queue, size)
+	}
+}
@@ -0,0 +1,623 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	// ThreatAnnotationKind is the kind of the annotations of the indicators
+	// matched by the flows
+	ThreatAnnotationKind = "threat-intel"
+
+	threatManager = "threat-intel"
+
+	// maxTAXIIPages bounds the pages of a TAXII collection fetched at once
+	maxTAXIIPages = 100
+)
+
+// Formats of the threat intelligence feeds
+const (
+	ThreatFeedList = "list"
+	ThreatFeedSTIX = "stix"
+)
+
+// stixPattern matches the comparisons of the STIX patterns on addresses and
+// domains, like [ipv4-addr:value = '198.51.100.1']
+var stixPattern = regexp.MustCompile(`(ipv4-addr|ipv6-addr|domain-name):value\s*=\s*'([^']+)'`)
+
+// ThreatFeedConfig describes a threat intelligence feed in the configuration
+type ThreatFeedConfig struct {
+	Name     string `mapstructure:"name"`
+	URL      string `mapstructure:"url"`
+	Format   string `mapstructure:"format"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// threatIndicator is an address, a network or a domain listed by a feed
+type threatIndicator struct {
+	feed  string
+	value string
+}
+
+// threatNetwork is a network indicator
+type threatNetwork struct {
+	network   *net.IPNet
+	indicator *threatIndicator
+}
+
+// threatMatch gathers the flows matching an indicator
+type threatMatch struct {
+	indicator *threatIndicator
+	flows     map[string]bool
+	peers     map[string]bool
+	captures  map[string]bool
+	last      time.Time
+}
+
+// ThreatMatcher tags the flows of which an endpoint is listed by a threat
+// intelligence feed, either a plain list of addresses, networks and domains
+// or a STIX 2 bundle possibly served by a TAXII 2.1 collection, with the
+// ThreatFeed and ThreatIndicator fields. The feeds are periodically
+// refreshed, the domains being resolved at each refresh. Each matched
+// indicator is reported by a high priority annotation node linked to the
+// nodes capturing the flows, removed once no flow matched it for the TTL.
+// Implements the FlowListener interface.
+type ThreatMatcher struct {
+	sync.Mutex
+	graph    *graph.Graph
+	feeds    []*ThreatFeedConfig
+	client   *http.Client
+	refresh  time.Duration
+	interval time.Duration
+	ttl      time.Duration
+	// the domains are resolved by resolveWorkers concurrent lookups
+	resolveWorkers int
+	resolveTimeout time.Duration
+	lookupIP       func(ctx context.Context, host string) ([]net.IP, error)
+	indicators     map[string][]string
+	addresses      map[string]*threatIndicator
+	networks       []*threatNetwork
+	matches        map[string]*threatMatch
+	annotations    map[graph.Identifier]*graph.Node
+	quit           chan struct{}
+	wg             sync.WaitGroup
+}
+
+// parseThreatList returns the indicators of a plain list, one per line, the
+// lines starting with # being comments
+func parseThreatList(data []byte) []string {
+	var values []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// the lists often follow the indicators with comments
+		if fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ';' || r == ' ' || r == '\t' }); len(fields) > 0 {
+			values = append(values, fields[0])
+		}
+	}
+	return values
+}
+
+// parseSTIX returns the address and domain indicators of a STIX 2 bundle or
+// of a TAXII 2.1 envelope, along with the next page of the envelope
+func parseSTIX(data []byte) ([]string, string, error) {
+	var bundle struct {
+		Objects []struct {
+			Type    string `json:"type"`
+			Pattern string `json:"pattern"`
+			Value   string `json:"value"`
+		} `json:"objects"`
+		More bool   `json:"more"`
+		Next string `json:"next"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, "", err
+	}
+
+	var values []string
+	for _, object := range bundle.Objects {
+		switch object.Type {
+		case "indicator":
+			for _, match := range stixPattern.FindAllStringSubmatch(object.Pattern, -1) {
+				values = append(values, match[2])
+			}
+		case "ipv4-addr", "ipv6-addr", "domain-name":
+			if object.Value != "" {
+				values = append(values, object.Value)
+			}
+		}
+	}
+
+	if !bundle.More {
+		return values, "", nil
+	}
+	return values, bundle.Next, nil
+}
+
+// fetch returns the content of a feed, either an http(s) URL or a file
+func (m *ThreatMatcher) fetch(feed *ThreatFeedConfig, location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return ioutil.ReadFile(strings.TrimPrefix(location, "file://"))
+	}
+
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	if feed.Format == ThreatFeedSTIX {
+		req.Header.Set("Accept", "application/taxii+json;version=2.1, application/stix+json;version=2.1, application/json")
+	}
+	if feed.Username != "" {
+		req.SetBasicAuth(feed.Username, feed.Password)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", location, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// load returns the indicators of a feed, following the pages of the TAXII
+// collections
+func (m *ThreatMatcher) load(feed *ThreatFeedConfig) ([]string, error) {
+	if feed.Format == ThreatFeedList {
+		data, err := m.fetch(feed, feed.URL)
+		if err != nil {
+			return nil, err
+		}
+		return parseThreatList(data), nil
+	}
+
+	var values []string
+	location := feed.URL
+	for page := 0; page < maxTAXIIPages; page++ {
+		data, err := m.fetch(feed, location)
+		if err != nil {
+			return nil, err
+		}
+
+		pageValues, next, err := parseSTIX(data)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, pageValues...)
+
+		if next == "" {
+			break
+		}
+
+		u, err := url.Parse(feed.URL)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		query.Set("next", next)
+		u.RawQuery = query.Encode()
+		location = u.String()
+	}
+	return values, nil
+}
+
+// lookupIP returns the addresses of a host, as net.LookupIP, within the
+// deadline of the context
+func lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// resolve returns the addresses of the domain indicators, resolved by a
+// bounded pool of workers, each resolution being bounded by the resolve
+// timeout
+func (m *ThreatMatcher) resolve(domains []*threatIndicator) [][]net.IP {
+	results := make([][]net.IP, len(domains))
+
+	workers := m.resolveWorkers
+	if workers > len(domains) {
+		workers = len(domains)
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				ctx, cancel := context.WithTimeout(context.Background(), m.resolveTimeout)
+				ips, err := m.lookupIP(ctx, domains[j].value)
+				cancel()
+
+				if err != nil {
+					logging.GetLogger().Debugf("Failed to resolve the threat indicator %s of %s: %s", domains[j].value, domains[j].feed, err)
+					continue
+				}
+				results[j] = ips
+			}
+		}()
+	}
+
+	for j := range domains {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// index resolves the indicators of the feeds into the addresses and the
+// networks matched by the flows
+func (m *ThreatMatcher) index(indicators map[string][]string) (map[string]*threatIndicator, []*threatNetwork) {
+	addresses := make(map[string]*threatIndicator)
+	var networks []*threatNetwork
+	var domains []*threatIndicator
+
+	for _, feed := range m.feeds {
+		for _, value := range indicators[feed.Name] {
+			indicator := &threatIndicator{feed: feed.Name, value: value}
+
+			if ip := net.ParseIP(value); ip != nil {
+				addresses[ip.String()] = indicator
+				continue
+			}
+
+			if _, network, err := net.ParseCIDR(value); err == nil {
+				networks = append(networks, &threatNetwork{network: network, indicator: indicator})
+				continue
+			}
+
+			domains = append(domains, indicator)
+		}
+	}
+
+	for i, ips := range m.resolve(domains) {
+		for _, ip := range ips {
+			if _, found := addresses[ip.String()]; !found {
+				addresses[ip.String()] = domains[i]
+			}
+		}
+	}
+
+	// the most specific networks are matched first
+	sort.Slice(networks, func(i, j int) bool {
+		a, _ := networks[i].network.Mask.Size()
+		b, _ := networks[j].network.Mask.Size()
+		return a > b
+	})
+
+	return addresses, networks
+}
+
+// refreshFeeds reloads the feeds, the feeds failing to load keeping their
+// previous indicators
+func (m *ThreatMatcher) refreshFeeds() {
+	indicators := make(map[string][]string)
+	for _, feed := range m.feeds {
+		values, err := m.load(feed)
+		if err != nil {
+			logging.GetLogger().Errorf("Failed to load the threat feed %s: %s", feed.Name, err)
+
+			m.Lock()
+			indicators[feed.Name] = m.indicators[feed.Name]
+			m.Unlock()
+			continue
+		}
+
+		logging.GetLogger().Debugf("Threat feed %s loaded with %d indicators", feed.Name, len(values))
+		indicators[feed.Name] = values
+	}
+
+	addresses, networks := m.index(indicators)
+
+	m.Lock()
+	m.indicators, m.addresses, m.networks = indicators, addresses, networks
+	m.Unlock()
+}
+
+// lookup returns the indicator matching an address. The caller has to hold
+// the lock of the matcher.
+func (m *ThreatMatcher) lookup(address string) *threatIndicator {
+	if address == "" {
+		return nil
+	}
+
+	if indicator, found := m.addresses[address]; found {
+		return indicator
+	}
+
+	if ip := net.ParseIP(address); ip != nil {
+		for _, n := range m.networks {
+			if n.network.Contains(ip) {
+				return n.indicator
+			}
+		}
+	}
+	return nil
+}
+
+// OnFlows tags the flows of which an endpoint matches an indicator
+func (m *ThreatMatcher) OnFlows(flows []*flow.Flow) {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.addresses) == 0 && len(m.networks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, f := range flows {
+		if f.Network == nil {
+			continue
+		}
+
+		address, peer := f.Network.A, f.Network.B
+		indicator := m.lookup(address)
+		if indicator == nil {
+			address, peer = peer, address
+			if indicator = m.lookup(address); indicator == nil {
+				continue
+			}
+		}
+
+		f.ThreatFeed, f.ThreatIndicator = indicator.feed, indicator.value
+
+		key := indicator.feed + "/" + indicator.value
+		match, found := m.matches[key]
+		if !found {
+			match = &threatMatch{
+				indicator: indicator,
+				flows:     make(map[string]bool),
+				peers:     make(map[string]bool),
+				captures:  make(map[string]bool),
+			}
+			m.matches[key] = match
+		}
+
+		match.flows[f.UUID] = true
+		match.peers[peer] = true
+		if f.NodeTID != "" {
+			match.captures[f.NodeTID] = true
+		}
+		match.last = now
+	}
+}
+
+func sortedKeys(set map[string]bool) []interface{} {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = key
+	}
+	return values
+}
+
+// annotate creates or updates the annotation of a matched indicator. The
+// caller has to hold the locks of the graph and of the matcher.
+func (m *ThreatMatcher) annotate(id graph.Identifier, match *threatMatch) {
+	metadata := map[string]interface{}{
+		"Feed":      match.indicator.feed,
+		"Indicator": match.indicator.value,
+		"Peers":     sortedKeys(match.peers),
+		"Flows":     int64(len(match.flows)),
+		"Last":      common.UnixMillis(match.last),
+	}
+
+	n, found := m.annotations[id]
+	if !found || m.graph.GetNode(id) == nil {
+		description := fmt.Sprintf("Flows of %v matching the indicator %s of the threat feed %s", metadata["Peers"], match.indicator.value, match.indicator.feed)
+		logging.GetLogger().Warningf("%s", description)
+
+		n = m.graph.NewNode(id, graph.Metadata{
+			"Type":    "annotation",
+			"Manager": threatManager,
+			"Name":    "Threat " + match.indicator.value,
+			"Annotation": map[string]interface{}{
+				"Source":      threatManager,
+				"Kind":        ThreatAnnotationKind,
+				"Priority":    "high",
+				"Timestamp":   common.UnixMillis(time.Now().UTC()),
+				"Description": description,
+			},
+			"Threat": metadata,
+		})
+		m.annotations[id] = n
+	} else {
+		m.graph.AddMetadata(n, "Threat", metadata)
+	}
+
+	relation := graph.Metadata{"RelationType": "annotation"}
+	for tid := range match.captures {
+		if node := m.graph.LookupFirstNode(graph.Metadata{"TID": tid}); node != nil && !m.graph.AreLinked(n, node, relation) {
+			m.graph.Link(n, node, relation)
+		}
+	}
+}
+
+// update reports the matched indicators, and removes the annotations of the
+// indicators no flow matched within the TTL
+func (m *ThreatMatcher) update() {
+	m.graph.Lock()
+	defer m.graph.Unlock()
+
+	m.Lock()
+	defer m.Unlock()
+
+	expire := time.Now().Add(-m.ttl)
+	for key, match := range m.matches {
+		id := graph.GenIDNameBased(threatManager, key)
+		if match.last.After(expire) {
+			m.annotate(id, match)
+			continue
+		}
+
+		logging.GetLogger().Infof("Threat indicator %s of %s not matched anymore", match.indicator.value, match.indicator.feed)
+		if n, found := m.annotations[id]; found && m.graph.GetNode(id) != nil {
+			m.graph.DelNode(n)
+		}
+		delete(m.annotations, id)
+		delete(m.matches, key)
+	}
+}
+
+func (m *ThreatMatcher) run() {
+	defer m.wg.Done()
+
+	m.refreshFeeds()
+
+	refreshTicker := time.NewTicker(m.refresh)
+	defer refreshTicker.Stop()
+
+	updateTicker := time.NewTicker(m.interval)
+	defer updateTicker.Stop()
+
+	for {
+		select {
+		case <-refreshTicker.C:
+			m.refreshFeeds()
+		case <-updateTicker.C:
+			m.update()
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// Start loads the feeds and starts reporting the matched indicators
+func (m *ThreatMatcher) Start() {
+	m.wg.Add(1)
+	go m.run()
+}
+
+// Stop the threat matcher
+func (m *ThreatMatcher) Stop() {
+	close(m.quit)
+	m.wg.Wait()
+}
+
+// NewThreatMatcherFromConfig returns a new threat matcher of the feeds of
+// the configuration, nil if disabled
+func NewThreatMatcherFromConfig(g *graph.Graph) (*ThreatMatcher, error) {
+	if !config.GetBool("analyzer.threat_intel.enabled") {
+		return nil, nil
+	}
+
+	var feeds []*ThreatFeedConfig
+	if err := config.GetConfig().UnmarshalKey("analyzer.threat_intel.feeds", &feeds); err != nil {
+		return nil, fmt.Errorf("Invalid analyzer.threat_intel.feeds: %s", err.Error())
+	}
+
+	if len(feeds) == 0 {
+		return nil, errors.New("analyzer.threat_intel.feeds has to define at least one feed")
+	}
+
+	names := make(map[string]bool)
+	for i, feed := range feeds {
+		if feed.Name == "" || feed.URL == "" {
+			return nil, fmt.Errorf("Threat feed %d: name and url are mandatory", i)
+		}
+		if names[feed.Name] {
+			return nil, fmt.Errorf("Threat feed %s defined twice", feed.Name)
+		}
+		names[feed.Name] = true
+
+		switch feed.Format {
+		case "":
+			feed.Format = ThreatFeedList
+		case ThreatFeedList, ThreatFeedSTIX:
+		default:
+			return nil, fmt.Errorf("Threat feed %s: unknown format %s", feed.Name, feed.Format)
+		}
+	}
+
+	refresh := time.Duration(config.GetInt("analyzer.threat_intel.refresh")) * time.Second
+	interval := time.Duration(config.GetInt("analyzer.threat_intel.interval")) * time.Second
+	if refresh <= 0 || interval <= 0 {
+		return nil, errors.New("analyzer.threat_intel.refresh and interval must be positive numbers of seconds")
+	}
+
+	resolveWorkers := config.GetInt("analyzer.threat_intel.resolve_workers")
+	resolveTimeout := time.Duration(config.GetInt("analyzer.threat_intel.resolve_timeout")) * time.Second
+	if resolveWorkers <= 0 || resolveTimeout <= 0 {
+		return nil, errors.New("analyzer.threat_intel.resolve_workers and resolve_timeout must be positive numbers")
+	}
+
+	return &ThreatMatcher{
+		graph:          g,
+		feeds:          feeds,
+		client:         &http.Client{Timeout: time.Duration(config.GetInt("analyzer.threat_intel.timeout")) * time.Second},
+		refresh:        refresh,
+		interval:       interval,
+		ttl:            time.Duration(config.GetInt("analyzer.threat_intel.ttl")) * time.Second,
+		resolveWorkers: resolveWorkers,
+		resolveTimeout: resolveTimeout,
+		lookupIP:       lookupIP,
+		indicators:     make(map[string][]string),
+		matches:        make(map[string]*threatMatch),
+		annotations:    make(map[graph.Identifier]*graph.Node),
+		quit:           make(chan struct{}),
+	}, nil
+}
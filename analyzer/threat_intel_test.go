@@ -0,0 +1,334 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestParseThreatList(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		data     string
+		expected []string
+	}{
+		{
+			name:     "plain",
+			data:     "198.51.100.1\n203.0.113.0/24\nevil.example.com\n",
+			expected: []string{"198.51.100.1", "203.0.113.0/24", "evil.example.com"},
+		},
+		{
+			name:     "comments",
+			data:     "# blocklist\n\n  198.51.100.1  \n#198.51.100.2\n",
+			expected: []string{"198.51.100.1"},
+		},
+		{
+			name:     "trailing comments",
+			data:     "198.51.100.1,botnet\n198.51.100.2;scanner\n198.51.100.3 c2\n198.51.100.4\tspam\n",
+			expected: []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4"},
+		},
+		{
+			name: "empty",
+			data: "# nothing\n",
+		},
+	} {
+		if values := parseThreatList([]byte(test.data)); !reflect.DeepEqual(values, test.expected) {
+			t.Errorf("%s: expected %v, got: %v", test.name, test.expected, values)
+		}
+	}
+}
+
+func TestParseSTIX(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		data     string
+		expected []string
+		next     string
+		err      bool
+	}{
+		{
+			name: "bundle",
+			data: `{"type": "bundle", "objects": [
+				{"type": "indicator", "pattern": "[ipv4-addr:value = '198.51.100.1'] OR [domain-name:value='evil.example.com']"},
+				{"type": "indicator", "pattern": "[file:hashes.MD5 = 'd41d8cd98f00b204e9800998ecf8427e']"},
+				{"type": "ipv6-addr", "value": "2001:db8::1"},
+				{"type": "domain-name", "value": ""},
+				{"type": "malware", "value": "198.51.100.2"}
+			]}`,
+			expected: []string{"198.51.100.1", "evil.example.com", "2001:db8::1"},
+		},
+		{
+			name:     "envelope with more pages",
+			data:     `{"more": true, "next": "page2", "objects": [{"type": "ipv4-addr", "value": "198.51.100.1"}]}`,
+			expected: []string{"198.51.100.1"},
+			next:     "page2",
+		},
+		{
+			name:     "last page",
+			data:     `{"more": false, "next": "page3", "objects": [{"type": "ipv4-addr", "value": "198.51.100.1"}]}`,
+			expected: []string{"198.51.100.1"},
+		},
+		{
+			name: "invalid",
+			data: `{"objects": `,
+			err:  true,
+		},
+	} {
+		values, next, err := parseSTIX([]byte(test.data))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		if !reflect.DeepEqual(values, test.expected) || next != test.next {
+			t.Errorf("%s: expected %v and next %q, got: %v and %q", test.name, test.expected, test.next, values, next)
+		}
+	}
+}
+
+// fakeResolver resolves the domains of a static table, recording the
+// maximal number of concurrent resolutions
+type fakeResolver struct {
+	sync.Mutex
+	hosts   map[string][]net.IP
+	delay   time.Duration
+	running int
+	max     int
+}
+
+func (r *fakeResolver) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	r.Lock()
+	if r.running++; r.running > r.max {
+		r.max = r.running
+	}
+	r.Unlock()
+
+	defer func() {
+		r.Lock()
+		r.running--
+		r.Unlock()
+	}()
+
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	ips, found := r.hosts[host]
+	if !found {
+		return nil, errors.New("no such host")
+	}
+	return ips, nil
+}
+
+func newTestThreatMatcher(t *testing.T, resolver *fakeResolver) *ThreatMatcher {
+	return &ThreatMatcher{
+		graph:          newTestGraph(t, "threat"),
+		feeds:          []*ThreatFeedConfig{{Name: "first"}, {Name: "second"}},
+		resolveWorkers: 2,
+		resolveTimeout: time.Second,
+		lookupIP:       resolver.lookupIP,
+		indicators:     make(map[string][]string),
+		matches:        make(map[string]*threatMatch),
+		annotations:    make(map[graph.Identifier]*graph.Node),
+	}
+}
+
+func TestThreatLookup(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]net.IP{
+			"evil.example.com":  {net.ParseIP("192.0.2.10"), net.ParseIP("2001:db8::10")},
+			"other.example.com": {net.ParseIP("198.51.100.1")},
+		},
+	}
+	m := newTestThreatMatcher(t, resolver)
+
+	m.addresses, m.networks = m.index(map[string][]string{
+		"first":  {"198.51.100.1", "203.0.113.0/24", "evil.example.com", "unknown.example.com"},
+		"second": {"203.0.113.128/25", "other.example.com", "2001:0db8:0000::0001"},
+	})
+
+	for _, test := range []struct {
+		address string
+		feed    string
+		value   string
+	}{
+		{address: "198.51.100.1", feed: "first", value: "198.51.100.1"},
+		{address: "192.0.2.10", feed: "first", value: "evil.example.com"},
+		{address: "2001:db8::10", feed: "first", value: "evil.example.com"},
+		{address: "2001:db8::1", feed: "second", value: "2001:0db8:0000::0001"},
+		{address: "203.0.113.1", feed: "first", value: "203.0.113.0/24"},
+		// the most specific network wins
+		{address: "203.0.113.200", feed: "second", value: "203.0.113.128/25"},
+		{address: "192.0.2.11"},
+		{address: ""},
+		{address: "not an address"},
+	} {
+		indicator := m.lookup(test.address)
+		if test.feed == "" {
+			if indicator != nil {
+				t.Errorf("Expected %s not to match, got: %+v", test.address, indicator)
+			}
+			continue
+		}
+
+		if indicator == nil || indicator.feed != test.feed || indicator.value != test.value {
+			t.Errorf("Expected %s to match %s of %s, got: %+v", test.address, test.value, test.feed, indicator)
+		}
+	}
+}
+
+func TestThreatResolve(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]net.IP{
+			"a.example.com": {net.ParseIP("192.0.2.1")},
+			"b.example.com": {net.ParseIP("192.0.2.2")},
+			"c.example.com": {net.ParseIP("192.0.2.3")},
+			"d.example.com": {net.ParseIP("192.0.2.4")},
+			"e.example.com": {net.ParseIP("192.0.2.5")},
+		},
+		delay: 10 * time.Millisecond,
+	}
+	m := newTestThreatMatcher(t, resolver)
+
+	var domains []*threatIndicator
+	for _, domain := range []string{"a.example.com", "b.example.com", "unknown.example.com", "c.example.com", "d.example.com", "e.example.com"} {
+		domains = append(domains, &threatIndicator{feed: "first", value: domain})
+	}
+
+	results := m.resolve(domains)
+	if len(results) != len(domains) {
+		t.Fatalf("Expected a result per domain, got: %v", results)
+	}
+	for i, domain := range domains {
+		if ips := resolver.hosts[domain.value]; !reflect.DeepEqual(results[i], ips) {
+			t.Errorf("Expected %s to be resolved to %v, got: %v", domain.value, ips, results[i])
+		}
+	}
+
+	if resolver.max > m.resolveWorkers {
+		t.Errorf("Expected at most %d concurrent resolutions, got: %d", m.resolveWorkers, resolver.max)
+	}
+
+	// the resolutions are bounded by the timeout
+	resolver.delay = time.Minute
+	m.resolveTimeout = 10 * time.Millisecond
+
+	start := time.Now()
+	results = m.resolve(domains)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the resolutions to time out, took: %s", elapsed)
+	}
+	for i, ips := range results {
+		if ips != nil {
+			t.Errorf("Expected %s not to be resolved, got: %v", domains[i].value, ips)
+		}
+	}
+
+	if results := m.resolve(nil); len(results) != 0 {
+		t.Errorf("Expected no result without domain, got: %v", results)
+	}
+}
+
+func TestThreatOnFlows(t *testing.T) {
+	m := newTestThreatMatcher(t, &fakeResolver{})
+	m.addresses, m.networks = m.index(map[string][]string{
+		"first": {"198.51.100.1", "203.0.113.0/24"},
+	})
+
+	flows := []*flow.Flow{
+		{UUID: "aaa", NodeTID: "tid1", Network: &flow.FlowLayer{A: "10.0.0.1", B: "198.51.100.1"}},
+		{UUID: "bbb", NodeTID: "tid2", Network: &flow.FlowLayer{A: "203.0.113.5", B: "10.0.0.2"}},
+		{UUID: "ccc", NodeTID: "tid1", Network: &flow.FlowLayer{A: "10.0.0.3", B: "198.51.100.1"}},
+		{UUID: "ddd", Network: &flow.FlowLayer{A: "10.0.0.1", B: "10.0.0.2"}},
+		{UUID: "eee"},
+	}
+	m.OnFlows(flows)
+
+	for _, test := range []struct {
+		flow      *flow.Flow
+		indicator string
+	}{
+		{flow: flows[0], indicator: "198.51.100.1"},
+		{flow: flows[1], indicator: "203.0.113.0/24"},
+		{flow: flows[2], indicator: "198.51.100.1"},
+		{flow: flows[3]},
+		{flow: flows[4]},
+	} {
+		feed := ""
+		if test.indicator != "" {
+			feed = "first"
+		}
+		if test.flow.ThreatFeed != feed || test.flow.ThreatIndicator != test.indicator {
+			t.Errorf("Expected flow %s to be tagged with %q of %q, got: %q of %q", test.flow.UUID, test.indicator, feed, test.flow.ThreatIndicator, test.flow.ThreatFeed)
+		}
+	}
+
+	if len(m.matches) != 2 {
+		t.Fatalf("Expected 2 matched indicators, got: %+v", m.matches)
+	}
+
+	match := m.matches["first/198.51.100.1"]
+	if match == nil {
+		t.Fatalf("Expected 198.51.100.1 to be matched, got: %+v", m.matches)
+	}
+	if len(match.flows) != 2 || !match.flows["aaa"] || !match.flows["ccc"] {
+		t.Errorf("Expected the flows aaa and ccc, got: %v", match.flows)
+	}
+	if !reflect.DeepEqual(sortedKeys(match.peers), []interface{}{"10.0.0.1", "10.0.0.3"}) {
+		t.Errorf("Expected the peers of the indicator, got: %v", match.peers)
+	}
+	if !reflect.DeepEqual(sortedKeys(match.captures), []interface{}{"tid1"}) {
+		t.Errorf("Expected the capture tid1, got: %v", match.captures)
+	}
+
+	if match := m.matches["first/203.0.113.0/24"]; match == nil || !match.peers["10.0.0.2"] || !match.captures["tid2"] {
+		t.Errorf("Expected 203.0.113.0/24 to be matched by 10.0.0.2 on tid2, got: %+v", match)
+	}
+}
+
+func TestThreatOnFlowsWithoutIndicator(t *testing.T) {
+	m := newTestThreatMatcher(t, &fakeResolver{})
+
+	f := &flow.Flow{UUID: "aaa", Network: &flow.FlowLayer{A: "10.0.0.1", B: "198.51.100.1"}}
+	m.OnFlows([]*flow.Flow{f})
+
+	if f.ThreatFeed != "" || len(m.matches) != 0 {
+		t.Errorf("Expected no match without indicator, got: %+v", m.matches)
+	}
+}
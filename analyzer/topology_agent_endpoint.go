@@ -24,8 +24,10 @@ package analyzer
 
 import (
 	"sync"
+	"time"
 
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/topology/graph"
@@ -39,15 +41,214 @@ type TopologyAgentEndpoint struct {
 	Graph  *graph.Graph
 	cached *graph.CachedBackend
 	wg     sync.WaitGroup
+	// hosts that notified a clean shutdown
+	shutdowns map[string]bool
+	// deletions of host graphs postponed while their session can be resumed
+	pendingDeletions map[string]*time.Timer
+	resumeDelay      time.Duration
+	// sequence numbers of the messages received from the agents
+	sequences map[string]*hostSequence
+	ackEvery  int64
+	clockSkew clockSkew
 }
 
-// OnDisconnected called when an agent disconnected.
+// hostSequence tracks the sequence numbers of the messages of an agent
+type hostSequence struct {
+	last   int64 // last message applied
+	acked  int64 // last message acknowledged
+	nacked bool  // a loss was reported, waiting for a re-sync
+}
+
+// agentResumeDelay returns the delay during which the agents can resume their
+// sessions, their graphs being kept. It can be extended for the edge agents
+// whose WAN links are down for hours.
+func agentResumeDelay() time.Duration {
+	if delay := config.GetInt("analyzer.edge.resume_delay"); delay > 0 {
+		return time.Duration(delay) * time.Second
+	}
+	return time.Duration(config.GetInt("http.ws.session_resume_delay")) * time.Second
+}
+
+// checkSequence returns whether a message should be applied according to its
+// sequence number. Lost messages are reported to the agent with a Nack.
+func (t *TopologyAgentEndpoint) checkSequence(c shttp.WSSpeaker, msgType string, seq int64) bool {
+	if seq == 0 {
+		return true
+	}
+
+	host := c.GetHost()
+
+	t.Lock()
+	defer t.Unlock()
+
+	hs, ok := t.sequences[host]
+	if !ok {
+		hs = &hostSequence{}
+		t.sequences[host] = hs
+	}
+
+	resync := msgType == graph.SyncMsgType || msgType == graph.HostGraphDeletedMsgType
+	switch {
+	case hs.last == 0 || resync:
+		hs.nacked = false
+	case seq <= hs.last:
+		// duplicate caused by a retransmission, acknowledge it again
+		c.SendMessage(shttp.NewWSStructMessage(graph.Namespace, graph.AckMsgType, &graph.AckMsg{Sequence: hs.last}))
+		return false
+	case seq > hs.last+1:
+		if !hs.nacked {
+			logging.GetLogger().Warningf("Lost messages from %s between %d and %d", host, hs.last, seq)
+			c.SendMessage(shttp.NewWSStructMessage(graph.Namespace, graph.NackMsgType, &graph.AckMsg{Sequence: hs.last}))
+			hs.nacked = true
+		}
+		return false
+	}
+
+	hs.last = seq
+	if resync || hs.last-hs.acked >= t.ackEvery {
+		c.SendMessage(shttp.NewWSStructMessage(graph.Namespace, graph.AckMsgType, &graph.AckMsg{Sequence: hs.last}))
+		hs.acked = hs.last
+	}
+
+	return true
+}
+
+// markHostGraphShutdown reports in the metadata of the nodes of the given host
+// how and when the agent was stopped.
+func (t *TopologyAgentEndpoint) markHostGraphShutdown(host string, clean bool, at int64) {
+	shutdown := map[string]interface{}{
+		"Clean": clean,
+		"Time":  at,
+	}
+
+	for _, node := range t.Graph.GetNodes(nil) {
+		if node.Host() == host {
+			t.Graph.AddMetadata(node, "Shutdown", shutdown)
+		}
+	}
+}
+
+// OnConnected called when an agent connected. A pending deletion of the
+// host graph is cancelled, the graph being kept if the session was resumed.
+func (t *TopologyAgentEndpoint) OnConnected(c shttp.WSSpeaker) {
+	host := c.GetHost()
+
+	t.Lock()
+	timer, pending := t.pendingDeletions[host]
+	delete(t.pendingDeletions, host)
+	if !c.GetStatus().Resumed {
+		delete(t.sequences, host)
+	}
+	t.Unlock()
+
+	if !pending || !timer.Stop() {
+		return
+	}
+
+	if c.GetStatus().Resumed {
+		logging.GetLogger().Infof("Agent %s resumed its session, keeping its resources", host)
+		return
+	}
+
+	t.Graph.Lock()
+	t.Graph.DelHostGraph(host)
+	t.Graph.Unlock()
+}
+
+// scheduleHostGraphDeletion postpones the deletion of the graph of a host
+// for the resume delay, during which its session can be resumed and its
+// shutdown marker looked at. It returns false if the graph has to be deleted
+// at once.
+func (t *TopologyAgentEndpoint) scheduleHostGraphDeletion(host string) bool {
+	if t.resumeDelay <= 0 {
+		return false
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	var timer *time.Timer
+	timer = time.AfterFunc(t.resumeDelay, func() {
+		t.Lock()
+		if t.pendingDeletions[host] != timer {
+			t.Unlock()
+			return
+		}
+		delete(t.pendingDeletions, host)
+		t.Unlock()
+
+		logging.GetLogger().Debugf("Agent %s not back, delete resources", host)
+		t.Graph.Lock()
+		t.Graph.DelHostGraph(host)
+		t.Graph.Unlock()
+	})
+	t.pendingDeletions[host] = timer
+
+	return true
+}
+
+// OnDisconnected called when an agent disconnected. The graph of the host
+// is kept for the resume delay, with its shutdown marker.
 func (t *TopologyAgentEndpoint) OnDisconnected(c shttp.WSSpeaker) {
 	host := c.GetHost()
+
+	t.Lock()
+	clean := t.shutdowns[host]
+	delete(t.shutdowns, host)
+	if clean {
+		delete(t.sequences, host)
+	}
+	t.Unlock()
+
 	t.Graph.Lock()
+	defer t.Graph.Unlock()
+
+	if !clean {
+		logging.GetLogger().Warningf("Agent %s disconnected without notifying a shutdown", host)
+		t.markHostGraphShutdown(host, false, common.UnixMillis(time.Now()))
+	}
+
+	if t.scheduleHostGraphDeletion(host) {
+		return
+	}
+
 	logging.GetLogger().Debugf("Authoritative client unregistered, delete resources %s", host)
 	t.Graph.DelHostGraph(host)
-	t.Graph.Unlock()
+}
+
+// syncHostGraph makes the graph of the host match the content of a sync
+// message, removing the nodes and edges not part of it anymore.
+func (t *TopologyAgentEndpoint) syncHostGraph(host string, r *graph.SyncMsg) {
+	nodes := make(map[graph.Identifier]bool)
+	for _, n := range r.Nodes {
+		nodes[n.ID] = true
+		if t.Graph.GetNode(n.ID) == nil {
+			t.Graph.NodeAdded(n)
+		} else {
+			t.Graph.NodeUpdated(n)
+		}
+	}
+
+	edges := make(map[graph.Identifier]bool)
+	for _, e := range r.Edges {
+		edges[e.ID] = true
+		if t.Graph.GetEdge(e.ID) == nil {
+			t.Graph.EdgeAdded(e)
+		} else {
+			t.Graph.EdgeUpdated(e)
+		}
+	}
+
+	for _, e := range t.Graph.GetEdges(nil) {
+		if e.Host() == host && !edges[e.ID] {
+			t.Graph.EdgeDeleted(e)
+		}
+	}
+	for _, n := range t.Graph.GetNodes(nil) {
+		if n.Host() == host && !nodes[n.ID] {
+			t.Graph.NodeDeleted(n)
+		}
+	}
 }
 
 // OnWSStructMessage is triggered when a message from the agent is received.
@@ -58,6 +259,12 @@ func (t *TopologyAgentEndpoint) OnWSStructMessage(c shttp.WSSpeaker, msg *shttp.
 		return
 	}
 
+	if !t.checkSequence(c, msgType, msg.Sequence) {
+		return
+	}
+
+	t.clockSkew.correctGraphMessage(c, obj)
+
 	t.Graph.Lock()
 	defer t.Graph.Unlock()
 
@@ -68,18 +275,17 @@ func (t *TopologyAgentEndpoint) OnWSStructMessage(c shttp.WSSpeaker, msg *shttp.
 		// the graph.
 		logging.GetLogger().Debugf("Got %s message for host %s", graph.HostGraphDeletedMsgType, obj.(string))
 		t.Graph.DelHostGraph(obj.(string))
+	case graph.HostGraphShutdownMsgType:
+		shutdown := obj.(*graph.HostGraphShutdownMsg)
+		logging.GetLogger().Infof("Agent %s is shutting down", shutdown.Host)
+
+		t.Lock()
+		t.shutdowns[shutdown.Host] = true
+		t.Unlock()
+
+		t.markHostGraphShutdown(shutdown.Host, true, shutdown.Time)
 	case graph.SyncMsgType, graph.SyncReplyMsgType:
-		r := obj.(*graph.SyncMsg)
-		for _, n := range r.Nodes {
-			if t.Graph.GetNode(n.ID) == nil {
-				t.Graph.NodeAdded(n)
-			}
-		}
-		for _, e := range r.Edges {
-			if t.Graph.GetEdge(e.ID) == nil {
-				t.Graph.EdgeAdded(e)
-			}
-		}
+		t.syncHostGraph(c.GetHost(), obj.(*graph.SyncMsg))
 	case graph.NodeUpdatedMsgType:
 		t.Graph.NodeUpdated(obj.(*graph.Node))
 	case graph.NodeDeletedMsgType:
@@ -98,9 +304,15 @@ func (t *TopologyAgentEndpoint) OnWSStructMessage(c shttp.WSSpeaker, msg *shttp.
 // NewTopologyAgentEndpoint returns a new server that handles messages from the agents
 func NewTopologyAgentEndpoint(pool shttp.WSStructSpeakerPool, auth *shttp.AuthenticationOpts, cached *graph.CachedBackend, g *graph.Graph) (*TopologyAgentEndpoint, error) {
 	t := &TopologyAgentEndpoint{
-		Graph:  g,
-		pool:   pool,
-		cached: cached,
+		Graph:            g,
+		pool:             pool,
+		cached:           cached,
+		shutdowns:        make(map[string]bool),
+		pendingDeletions: make(map[string]*time.Timer),
+		resumeDelay:      agentResumeDelay(),
+		sequences:        make(map[string]*hostSequence),
+		ackEvery:         int64(config.GetInt("analyzer.topology.ack_every")),
+		clockSkew:        newClockSkewFromConfig(),
 	}
 
 	pool.AddEventHandler(t)
@@ -0,0 +1,227 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+type edgeTraffic struct {
+	bytes   int64
+	packets int64
+}
+
+// TrafficWeigher periodically aggregates the stored flows exchanged between
+// two topology nodes and reports the traffic on the layer2 edges of the path
+// between them in the Traffic metadata. Only the master analyzer updates the
+// edges.
+type TrafficWeigher struct {
+	*etcd.MasterElector
+	graph    *graph.Graph
+	storage  storage.Storage
+	window   time.Duration
+	interval time.Duration
+	maxHops  int
+	suffix   string
+	weighted map[graph.Identifier]bool
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// durationSuffix returns a compact representation of a duration, 1h, 30m or 90s
+func durationSuffix(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+func (t *TrafficWeigher) flowTraffic(uuid string, metrics map[string][]common.Metric) (traffic edgeTraffic) {
+	for _, m := range metrics[uuid] {
+		for _, field := range []string{"ABBytes", "BABytes"} {
+			v, _ := m.GetFieldInt64(field)
+			traffic.bytes += v
+		}
+		for _, field := range []string{"ABPackets", "BAPackets"} {
+			v, _ := m.GetFieldInt64(field)
+			traffic.packets += v
+		}
+	}
+	return
+}
+
+func (t *TrafficWeigher) update() {
+	now := common.UnixMillis(time.Now())
+	fr := filters.Range{From: now - int64(t.window/time.Millisecond), To: now}
+	fsq := filters.SearchQuery{Filter: filters.NewFilterActiveIn(fr, "")}
+
+	flowset, err := t.storage.SearchFlows(fsq)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to retrieve the flows for traffic weights: %s", err.Error())
+		return
+	}
+
+	metrics, err := t.storage.SearchMetrics(fsq, filters.NewFilterIncludedIn(fr, ""))
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to retrieve the flow metrics for traffic weights: %s", err.Error())
+		return
+	}
+
+	t.graph.Lock()
+	defer t.graph.Unlock()
+
+	index := topology.NewAddressIndex(t.graph)
+	paths := make(map[[2]graph.Identifier][]*graph.Edge)
+	weights := make(map[graph.Identifier]*edgeTraffic)
+
+	for _, f := range flowset.Flows {
+		a := index.Lookup(f.GetLink().GetA(), f.GetNetwork().GetA())
+		b := index.Lookup(f.GetLink().GetB(), f.GetNetwork().GetB())
+		if a == nil || b == nil || a.ID == b.ID {
+			continue
+		}
+
+		traffic := t.flowTraffic(f.UUID, metrics)
+		if traffic.bytes == 0 && traffic.packets == 0 {
+			continue
+		}
+
+		key := [2]graph.Identifier{a.ID, b.ID}
+		if b.ID < a.ID {
+			key = [2]graph.Identifier{b.ID, a.ID}
+		}
+
+		path, ok := paths[key]
+		if !ok {
+			path = t.graph.LookupEdgePath(a, b, topology.Layer2Metadata, t.maxHops, nil)
+			paths[key] = path
+		}
+
+		for _, e := range path {
+			w, ok := weights[e.ID]
+			if !ok {
+				w = &edgeTraffic{}
+				weights[e.ID] = w
+			}
+			w.bytes += traffic.bytes
+			w.packets += traffic.packets
+		}
+	}
+
+	// reset the edges that do not carry traffic anymore
+	for id := range t.weighted {
+		if _, ok := weights[id]; !ok {
+			weights[id] = &edgeTraffic{}
+		}
+	}
+
+	weighted := make(map[graph.Identifier]bool)
+	for id, w := range weights {
+		e := t.graph.GetEdge(id)
+		if e == nil {
+			continue
+		}
+
+		t.graph.AddMetadata(e, "Traffic", map[string]interface{}{
+			"Bytes" + t.suffix:   w.bytes,
+			"Packets" + t.suffix: w.packets,
+		})
+
+		if w.bytes != 0 || w.packets != 0 {
+			weighted[id] = true
+		}
+	}
+	t.weighted = weighted
+
+	logging.GetLogger().Debugf("Traffic weights updated on %d edges", len(weighted))
+}
+
+func (t *TrafficWeigher) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if t.IsMaster() {
+				t.update()
+			}
+		case <-t.quit:
+			return
+		}
+	}
+}
+
+// Start the traffic weigher
+func (t *TrafficWeigher) Start() {
+	t.StartAndWait()
+
+	t.wg.Add(1)
+	go t.run()
+}
+
+// Stop the traffic weigher
+func (t *TrafficWeigher) Stop() {
+	close(t.quit)
+	t.wg.Wait()
+	t.MasterElector.Stop()
+}
+
+// NewTrafficWeigherFromConfig returns a new traffic weigher, nil if disabled
+// or if no flow storage is configured
+func NewTrafficWeigherFromConfig(g *graph.Graph, store storage.Storage, etcdClient *etcd.Client) *TrafficWeigher {
+	if store == nil || !config.GetBool("analyzer.traffic.enabled") {
+		return nil
+	}
+
+	window := time.Duration(config.GetInt("analyzer.traffic.window")) * time.Second
+
+	return &TrafficWeigher{
+		MasterElector: etcd.NewMasterElectorFromConfig(common.AnalyzerService, "traffic-weigher", etcdClient),
+		graph:         g,
+		storage:       store,
+		window:        window,
+		interval:      time.Duration(config.GetInt("analyzer.traffic.interval")) * time.Second,
+		maxHops:       config.GetInt("analyzer.traffic.max_hops"),
+		suffix:        durationSuffix(window),
+		weighted:      make(map[graph.Identifier]bool),
+		quit:          make(chan struct{}),
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestDurationSuffix(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		time.Hour:        "1h",
+		2 * time.Hour:    "2h",
+		30 * time.Minute: "30m",
+		90 * time.Second: "90s",
+	} {
+		if suffix := durationSuffix(d); suffix != expected {
+			t.Errorf("Expected the suffix %s for %s, got: %s", expected, d, suffix)
+		}
+	}
+}
+
+func TestTrafficWeigherUpdate(t *testing.T) {
+	g := newTestGraph(t, "traffic")
+
+	g.Lock()
+	intf1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "intf1", "IPV4": []string{"10.0.0.1/24"}})
+	intf2 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "intf2", "MAC": "AA:BB:CC:DD:EE:02"})
+	intf3 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "intf3", "IPV4": []string{"10.0.0.3"}})
+	bridge := g.NewNode(graph.GenID(), graph.Metadata{"Name": "bridge"})
+	edge1 := topology.AddLayer2Link(g, intf1, bridge, nil)
+	edge2 := topology.AddLayer2Link(g, bridge, intf2, nil)
+	edge3 := topology.AddLayer2Link(g, bridge, intf3, nil)
+	g.Unlock()
+
+	newFlow := func(uuid, linkB, a, b string) *flow.Flow {
+		return &flow.Flow{
+			UUID:    uuid,
+			Link:    &flow.FlowLayer{B: linkB},
+			Network: &flow.FlowLayer{A: a, B: b},
+		}
+	}
+
+	store := &fakeFlowStorage{
+		flows: []*flow.Flow{
+			// the destination is known by its MAC address
+			newFlow("aaa", "aa:bb:cc:dd:ee:02", "10.0.0.1", "10.0.0.2"),
+			newFlow("bbb", "", "10.0.0.3", "10.0.0.1"),
+			// unknown endpoint
+			newFlow("ccc", "", "192.168.0.1", "10.0.0.1"),
+			// both ends on the same node
+			newFlow("ddd", "", "10.0.0.1", "10.0.0.1"),
+			// no traffic within the window
+			newFlow("eee", "", "10.0.0.3", "10.0.0.1"),
+		},
+		metrics: map[string][]common.Metric{
+			"aaa": {&flow.FlowMetric{ABBytes: 600, BABytes: 400, ABPackets: 6, BAPackets: 4}},
+			"bbb": {&flow.FlowMetric{ABBytes: 300, ABPackets: 3}, &flow.FlowMetric{BABytes: 200, BAPackets: 2}},
+			"ccc": {&flow.FlowMetric{ABBytes: 10000, ABPackets: 100}},
+			"ddd": {&flow.FlowMetric{ABBytes: 10000, ABPackets: 100}},
+		},
+	}
+
+	tw := &TrafficWeigher{
+		graph:    g,
+		storage:  store,
+		window:   time.Minute,
+		maxHops:  10,
+		suffix:   durationSuffix(time.Minute),
+		weighted: make(map[graph.Identifier]bool),
+	}
+
+	assertTraffic := func(e *graph.Edge, bytes, packets int64) {
+		g.RLock()
+		defer g.RUnlock()
+
+		b, err := e.GetFieldInt64("Traffic.Bytes1m")
+		if err != nil {
+			t.Fatalf("Expected the traffic of the edge %s: %s", e.ID, err)
+		}
+		p, _ := e.GetFieldInt64("Traffic.Packets1m")
+
+		if b != bytes || p != packets {
+			t.Errorf("Expected %d bytes and %d packets on the edge %s, got: %d and %d", bytes, packets, e.ID, b, p)
+		}
+	}
+
+	tw.update()
+
+	assertTraffic(edge1, 1500, 15)
+	assertTraffic(edge2, 1000, 10)
+	assertTraffic(edge3, 500, 5)
+	if len(tw.weighted) != 3 {
+		t.Errorf("Expected 3 weighted edges, got: %v", tw.weighted)
+	}
+
+	// the edges not carrying traffic anymore are reset
+	store.flows = store.flows[:1]
+	tw.update()
+
+	assertTraffic(edge1, 1000, 10)
+	assertTraffic(edge2, 1000, 10)
+	assertTraffic(edge3, 0, 0)
+	if len(tw.weighted) != 2 || tw.weighted[edge3.ID] {
+		t.Errorf("Expected the edge %s not to be weighted anymore, got: %v", edge3.ID, tw.weighted)
+	}
+}
@@ -23,12 +23,27 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
 	"github.com/nu7hatch/gouuid"
 	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow/storage"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
 )
 
+// maximum number of events returned by the alert history API by default
+const defaultAlertHistoryLimit = 100
+
 // AlertResourceHandler aims to creates and manage a new Alert.
 type AlertResourceHandler struct {
 	ResourceHandler
@@ -37,6 +52,13 @@ type AlertResourceHandler struct {
 // AlertAPIHandler aims to exposes the Alert API.
 type AlertAPIHandler struct {
 	BasicAPIHandler
+	store storage.AlertStorage
+}
+
+// alertHistoryEvent describes an alert event with its reason data decoded
+type alertHistoryEvent struct {
+	*types.AlertEvent
+	ReasonData json.RawMessage `json:",omitempty"`
 }
 
 // New creates a new alert
@@ -54,14 +76,106 @@ func (a *AlertResourceHandler) Name() string {
 	return "alert"
 }
 
-// RegisterAlertAPI registers an Alert's API to a designated API Server
-func RegisterAlertAPI(apiServer *Server) (*AlertAPIHandler, error) {
+// historyFilter returns the filter of the events of an alert, optionally
+// restricted to the from and to timestamps in milliseconds
+func historyFilter(id string, r *auth.AuthenticatedRequest) (*filters.Filter, error) {
+	query := r.URL.Query()
+	terms := []*filters.Filter{filters.NewTermStringFilter("AlertUUID", id)}
+
+	if value := query.Get("from"); value != "" {
+		from, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, filters.NewGteInt64Filter("Timestamp", from))
+	}
+
+	if value := query.Get("to"); value != "" {
+		to, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, filters.NewLteInt64Filter("Timestamp", to))
+	}
+
+	return filters.NewAndFilter(terms...), nil
+}
+
+func (a *AlertAPIHandler) alertHistory(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "alert", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if a.store == nil {
+		writeError(w, http.StatusBadRequest, storage.ErrNoStorageConfigured)
+		return
+	}
+
+	filter, err := historyFilter(mux.Vars(&r.Request)["id"], r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit := defaultAlertHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("Limit has to be a positive integer"))
+			return
+		}
+	}
+
+	events, err := a.store.SearchAlertEvents(filters.SearchQuery{
+		Filter:          filter,
+		PaginationRange: &filters.Range{From: 0, To: int64(limit)},
+		Sort:            true,
+		SortBy:          "Timestamp",
+		SortOrder:       string(common.SortDescending),
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	history := make([]*alertHistoryEvent, len(events))
+	for i, event := range events {
+		history[i] = &alertHistoryEvent{AlertEvent: event}
+		if event.ReasonData != "" {
+			history[i].ReasonData = json.RawMessage(event.ReasonData)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+// RegisterAlertAPI registers an Alert's API to a designated API Server, the
+// history of the alerts being served from the storage when it supports it
+func RegisterAlertAPI(apiServer *Server, store storage.Storage) (*AlertAPIHandler, error) {
 	alertAPIHandler := &AlertAPIHandler{
 		BasicAPIHandler: BasicAPIHandler{
 			ResourceHandler: &AlertResourceHandler{},
 			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
 		},
 	}
+	if store, ok := store.(storage.AlertStorage); ok {
+		alertAPIHandler.store = store
+	}
+
+	// registered before the alert API as its routes match any path under /api/alert/
+	apiServer.HTTPServer.RegisterRoutes([]shttp.Route{
+		{
+			Name:        "AlertHistory",
+			Method:      "GET",
+			Path:        "/api/alert/{id}/history",
+			HandlerFunc: alertAPIHandler.alertHistory,
+		},
+	})
+
 	if err := apiServer.RegisterAPIHandler(alertAPIHandler); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+)
+
+// fakeAlertStorage returns its events as sorted by the storages, recording
+// the last search
+type fakeAlertStorage struct {
+	events []*types.AlertEvent
+	query  filters.SearchQuery
+}
+
+func (s *fakeAlertStorage) StoreAlertEvent(event *types.AlertEvent) error {
+	s.events = append([]*types.AlertEvent{event}, s.events...)
+	return nil
+}
+
+func (s *fakeAlertStorage) SearchAlertEvents(fsq filters.SearchQuery) ([]*types.AlertEvent, error) {
+	s.query = fsq
+	return s.events, nil
+}
+
+func serveAlertHistory(handler *AlertAPIHandler, path string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/alert/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		handler.alertHistory(w, &auth.AuthenticatedRequest{Request: *r})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestAlertHistory(t *testing.T) {
+	store := &fakeAlertStorage{}
+	for i, state := range []string{types.AlertFired, types.AlertResolved, types.AlertFired} {
+		event := types.NewAlertEvent("alert", state, "host1", "")
+		event.Timestamp = int64(1000 * (i + 1))
+		if state == types.AlertFired {
+			event.ReasonData = `{"ID": "node"}`
+		}
+		store.StoreAlertEvent(event)
+	}
+	handler := &AlertAPIHandler{store: store}
+
+	w := serveAlertHistory(handler, "/api/alert/alert/history?from=1000&to=5000&limit=10")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the history to be returned, got: %d %s", w.Code, w.Body.String())
+	}
+
+	var history []struct {
+		State      string
+		Timestamp  int64
+		ReasonData map[string]interface{}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+
+	// the most recent events first
+	if len(history) != 3 || history[0].Timestamp != 3000 || history[1].Timestamp != 2000 || history[2].Timestamp != 1000 {
+		t.Fatalf("Expected the events from the most recent one, got: %+v", history)
+	}
+	if history[0].State != types.AlertFired || history[1].State != types.AlertResolved || history[2].State != types.AlertFired {
+		t.Errorf("Expected the transitions of the alert, got: %+v", history)
+	}
+	if history[0].ReasonData["ID"] != "node" || history[1].ReasonData != nil {
+		t.Errorf("Expected the reason data of the fired events to be decoded, got: %+v", history)
+	}
+
+	query := store.query
+	if !query.Sort || query.SortBy != "Timestamp" || query.SortOrder != string(common.SortDescending) {
+		t.Errorf("Expected the events to be sorted by descending timestamps, got: %+v", query)
+	}
+	if query.PaginationRange == nil || query.PaginationRange.To != 10 {
+		t.Errorf("Expected the events to be limited, got: %+v", query.PaginationRange)
+	}
+
+	terms := query.Filter.BoolFilter.Filters
+	if len(terms) != 3 || terms[0].TermStringFilter.Value != "alert" || terms[1].GteInt64Filter.Value != 1000 || terms[2].LteInt64Filter.Value != 5000 {
+		t.Errorf("Expected the events of the alert between from and to, got: %+v", query.Filter)
+	}
+
+	serveAlertHistory(handler, "/api/alert/alert/history")
+	if query := store.query; query.PaginationRange.To != defaultAlertHistoryLimit || len(query.Filter.BoolFilter.Filters) != 1 {
+		t.Errorf("Expected all the events up to the default limit, got: %+v", query)
+	}
+
+	for _, path := range []string{
+		"/api/alert/alert/history?limit=0",
+		"/api/alert/alert/history?limit=abc",
+		"/api/alert/alert/history?from=abc",
+	} {
+		if w := serveAlertHistory(handler, path); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be refused, got: %d", path, w.Code)
+		}
+	}
+
+	if w := serveAlertHistory(&AlertAPIHandler{}, "/api/alert/alert/history"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected the history to require a storage, got: %d", w.Code)
+	}
+}
@@ -24,6 +24,7 @@ package server
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/nu7hatch/gouuid"
 
@@ -56,8 +57,9 @@ func (c *CaptureResourceHandler) New() types.Resource {
 	id, _ := uuid.NewV4()
 
 	return &types.Capture{
-		UUID:         id.String(),
-		LayerKeyMode: flow.DefaultLayerKeyModeName(),
+		UUID:          id.String(),
+		LayerKeyMode:  flow.DefaultLayerKeyModeName(),
+		FlowDirection: flow.DefaultDirectionModeName(),
 	}
 }
 
@@ -77,12 +79,15 @@ func (c *CaptureAPIHandler) Decorate(resource types.Resource) {
 		return
 	}
 
+	stats := &types.CaptureStats{}
+	talkers := make(map[string]*types.CaptureTalker)
 	for _, value := range res.Values() {
 		switch value.(type) {
 		case *graph.Node:
 			n := value.(*graph.Node)
 			if cuuid, _ := n.GetFieldString("Capture.ID"); cuuid != "" {
 				count++
+				addCaptureNodeStats(stats, talkers, n)
 			}
 			if p, _ := n.GetFieldString("Capture.PCAPSocket"); p != "" {
 				pcapSocket = p
@@ -91,6 +96,7 @@ func (c *CaptureAPIHandler) Decorate(resource types.Resource) {
 			for _, n := range value.([]*graph.Node) {
 				if cuuid, _ := n.GetFieldString("Capture.ID"); cuuid != "" {
 					count++
+					addCaptureNodeStats(stats, talkers, n)
 				}
 				if p, _ := n.GetFieldString("Capture.PCAPSocket"); p != "" {
 					pcapSocket = p
@@ -103,6 +109,56 @@ func (c *CaptureAPIHandler) Decorate(resource types.Resource) {
 
 	capture.Count = count
 	capture.PCAPSocket = pcapSocket
+
+	if count > 0 {
+		for _, talker := range talkers {
+			stats.TopTalkers = append(stats.TopTalkers, *talker)
+		}
+		sort.Slice(stats.TopTalkers, func(i, j int) bool {
+			return stats.TopTalkers[i].Bytes > stats.TopTalkers[j].Bytes
+		})
+		if len(stats.TopTalkers) > flow.TopTalkersCount {
+			stats.TopTalkers = stats.TopTalkers[:flow.TopTalkersCount]
+		}
+		capture.Stats = stats
+	}
+}
+
+// addCaptureNodeStats adds the capture counters of a node to the stats of
+// the capture, the talkers seen by several nodes being merged
+func addCaptureNodeStats(stats *types.CaptureStats, talkers map[string]*types.CaptureTalker, n *graph.Node) {
+	counter := func(field string) int64 {
+		value, _ := n.GetFieldInt64("Capture." + field)
+		return value
+	}
+
+	stats.PacketsReceived += counter("PacketsReceived")
+	stats.PacketsDropped += counter("PacketsDropped")
+	stats.Packets += counter("Packets")
+	stats.Bytes += counter("Bytes")
+	stats.FlowsCreated += counter("FlowsCreated")
+	stats.ParseErrors += counter("PacketsDecodingErrors") + counter("PacketsMalformed")
+
+	top, _ := n.GetField("Capture.TopTalkers")
+	list, _ := top.([]interface{})
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		address, _ := m["Address"].(string)
+		packets, _ := common.ToInt64(m["Packets"])
+		bytes, _ := common.ToInt64(m["Bytes"])
+
+		talker, found := talkers[address]
+		if !found {
+			talker = &types.CaptureTalker{Address: address}
+			talkers[address] = talker
+		}
+		talker.Packets += packets
+		talker.Bytes += bytes
+	}
 }
 
 // Create tests that resource GremlinQuery does not exists already
@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/validator"
+)
+
+// DashboardResourceHandler describes a dashboard resource handler
+type DashboardResourceHandler struct {
+}
+
+// DashboardAPIHandler based on BasicAPIHandler
+type DashboardAPIHandler struct {
+	BasicAPIHandler
+	savedSearches *SavedSearchAPIHandler
+}
+
+// New creates a new dashboard resource
+func (d *DashboardResourceHandler) New() types.Resource {
+	id, _ := uuid.NewV4()
+
+	return &types.Dashboard{
+		UUID: id.String(),
+	}
+}
+
+// Name returns "dashboard"
+func (d *DashboardResourceHandler) Name() string {
+	return "dashboard"
+}
+
+// Create checks the sources of the widgets, the saved searches having to be
+// visible to the owner of the dashboard, and that its name is unique among
+// the ones of its owner
+func (d *DashboardAPIHandler) Create(r types.Resource) error {
+	dashboard := r.(*types.Dashboard)
+
+	for i, widget := range dashboard.Widgets {
+		if widget.GremlinQuery != "" {
+			if err := validator.ValidateGremlinQuery(widget.GremlinQuery); err != nil {
+				return fmt.Errorf("Invalid Gremlin query of widget %d: %s", i, err)
+			}
+			continue
+		}
+
+		search, ok := d.savedSearches.Get(widget.SavedSearch)
+		if !ok || !search.(types.OwnedResource).IsVisibleTo(dashboard.Owner) {
+			return fmt.Errorf("Unknown saved search %s of widget %d", widget.SavedSearch, i)
+		}
+	}
+
+	for _, resource := range d.BasicAPIHandler.Index() {
+		if other := resource.(*types.Dashboard); other.Owner == dashboard.Owner && other.Name == dashboard.Name && other.UUID != dashboard.UUID {
+			return fmt.Errorf("Duplicate dashboard, name=%s", dashboard.Name)
+		}
+	}
+
+	return d.BasicAPIHandler.Create(r)
+}
+
+// RegisterDashboardAPI registers a new dashboard api handler, the widgets
+// referring to the saved searches of savedSearches
+func RegisterDashboardAPI(apiServer *Server, savedSearches *SavedSearchAPIHandler) (*DashboardAPIHandler, error) {
+	dashboardAPIHandler := &DashboardAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &DashboardResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+		savedSearches: savedSearches,
+	}
+	if err := apiServer.RegisterAPIHandler(dashboardAPIHandler); err != nil {
+		return nil, err
+	}
+	return dashboardAPIHandler, nil
+}
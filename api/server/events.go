@@ -0,0 +1,236 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/nu7hatch/gouuid"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+	"github.com/skydive-project/skydive/validator"
+)
+
+// AnnotationType is the type of the nodes created from the posted events
+const AnnotationType = "annotation"
+
+var annotationFilter = graph.Metadata{"Type": AnnotationType, "Manager": "events"}
+
+// EventsAPI exposes the API used by external systems to annotate the topology
+type EventsAPI struct {
+	graph         *graph.Graph
+	gremlinParser *traversal.GremlinTraversalParser
+	ttl           time.Duration
+}
+
+// affectedNodes returns the nodes returned by the Gremlin query of the event
+// and the nodes it references. The caller has to hold the lock of the graph.
+func (e *EventsAPI) affectedNodes(event *types.Event, ts *traversal.GremlinTraversalSequence) ([]*graph.Node, error) {
+	var nodes []*graph.Node
+	seen := make(map[graph.Identifier]bool)
+
+	if ts != nil {
+		res, err := ts.Exec(e.graph, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range res.Values() {
+			if n, ok := value.(*graph.Node); ok && !seen[n.ID] {
+				seen[n.ID] = true
+				nodes = append(nodes, n)
+			}
+		}
+	}
+
+	for _, id := range event.Nodes {
+		n := e.graph.GetNode(graph.Identifier(id))
+		if n == nil {
+			return nil, fmt.Errorf("Node %s not found", id)
+		}
+		if !seen[n.ID] {
+			seen[n.ID] = true
+			nodes = append(nodes, n)
+		}
+	}
+
+	return nodes, nil
+}
+
+// pruneAnnotations removes from the graph the annotations older than the
+// TTL. The caller has to hold the lock of the graph.
+func (e *EventsAPI) pruneAnnotations(now time.Time) {
+	if e.ttl <= 0 {
+		return
+	}
+
+	expire := common.UnixMillis(now.Add(-e.ttl))
+	for _, n := range e.graph.GetNodes(annotationFilter) {
+		if timestamp, err := n.GetFieldInt64("Annotation.Timestamp"); err == nil && timestamp < expire {
+			e.graph.DelNode(n)
+		}
+	}
+}
+
+func (e *EventsAPI) eventsCreate(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "event", "write") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var event types.Event
+	if err := common.JSONDecode(r.Body, &event); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := validator.Validate(&event); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var ts *traversal.GremlinTraversalSequence
+	if event.GremlinQuery != "" {
+		var err error
+		if ts, err = e.gremlinParser.Parse(strings.NewReader(event.GremlinQuery)); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	if event.UUID == "" {
+		id, _ := uuid.NewV4()
+		event.UUID = id.String()
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = common.UnixMillis(now)
+	}
+
+	e.graph.Lock()
+	defer e.graph.Unlock()
+
+	e.pruneAnnotations(now)
+
+	// events are posted at most once, retries of the sender are rejected
+	if e.graph.GetNode(graph.Identifier(event.UUID)) != nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("Event %s already exists", event.UUID))
+		return
+	}
+
+	nodes, err := e.affectedNodes(&event, ts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	annotation := map[string]interface{}{
+		"Source":    event.Source,
+		"Timestamp": event.Timestamp,
+	}
+	for key, value := range map[string]string{"Kind": event.Kind, "Description": event.Description, "URL": event.URL} {
+		if value != "" {
+			annotation[key] = value
+		}
+	}
+	if len(event.Metadata) > 0 {
+		annotation["Metadata"] = event.Metadata
+	}
+
+	m := graph.Metadata{
+		"Type":       AnnotationType,
+		"Manager":    "events",
+		"Name":       event.Title,
+		"Annotation": annotation,
+	}
+
+	n := e.graph.NewNode(graph.Identifier(event.UUID), m)
+	for _, node := range nodes {
+		e.graph.Link(n, node, graph.Metadata{"RelationType": AnnotationType})
+	}
+
+	logging.GetLogger().Debugf("Event %s from %s annotating %d nodes", event.UUID, event.Source, len(nodes))
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&event); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (e *EventsAPI) eventsIndex(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "event", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	e.graph.RLock()
+	defer e.graph.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(e.graph.GetNodes(annotationFilter)); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (e *EventsAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "EventsIndex",
+			Method:      "GET",
+			Path:        "/api/events",
+			HandlerFunc: e.eventsIndex,
+		},
+		{
+			Name:        "EventsCreate",
+			Method:      "POST",
+			Path:        "/api/events",
+			HandlerFunc: e.eventsCreate,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+// RegisterEventsAPI registers a new API creating annotation nodes from the
+// events posted by external systems
+func RegisterEventsAPI(r *shttp.Server, g *graph.Graph, parser *traversal.GremlinTraversalParser) {
+	e := &EventsAPI{
+		graph:         g,
+		gremlinParser: parser,
+		ttl:           time.Duration(config.GetInt("analyzer.events.ttl")) * time.Second,
+	}
+
+	e.registerEndpoints(r)
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow/export"
+	"github.com/skydive-project/skydive/flow/storage"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+// ExportAPI exposes the bulk export of the stored flows
+type ExportAPI struct {
+	storage storage.Storage
+}
+
+// exportFlows writes the flows of the time range to a temporary file first
+// so that a storage error is reported instead of a truncated file
+func (ea *ExportAPI) exportFlows(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "export", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if ea.storage == nil {
+		writeError(w, http.StatusBadRequest, storage.ErrNoStorageConfigured)
+		return
+	}
+
+	from, to, err := usageRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.Parquet
+	}
+
+	file, err := ioutil.TempFile("", "skydive-export-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer, err := export.NewWriter(format, file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	count, err := export.Flows(writer, ea.storage, common.UnixMillis(from), common.UnixMillis(to), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	logging.GetLogger().Infof("Exporting %d flows as %s to %s", count, format, r.Username)
+
+	filename := fmt.Sprintf("flows-%d-%d.%s", common.UnixMillis(from), common.UnixMillis(to), format)
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("X-Skydive-Flows", fmt.Sprintf("%d", count))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, file); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (ea *ExportAPI) exportSchema(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "export", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"Version": export.SchemaVersion,
+		"Columns": export.Schema,
+	}); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (ea *ExportAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "ExportFlows",
+			Method:      "GET",
+			Path:        "/api/export/flows",
+			HandlerFunc: ea.exportFlows,
+		},
+		{
+			Name:        "ExportSchema",
+			Method:      "GET",
+			Path:        "/api/export/schema",
+			HandlerFunc: ea.exportSchema,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+// RegisterExportAPI registers the bulk export API of the stored flows
+func RegisterExportAPI(r *shttp.Server, store storage.Storage) {
+	ea := &ExportAPI{storage: store}
+	ea.registerEndpoints(r)
+}
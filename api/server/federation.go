@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/skydive-project/skydive/api/types"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/validator"
+)
+
+// Federation is the interface of the federation of site analyzers
+type Federation interface {
+	GetSites() map[string]types.FederationSite
+	Query(query string) map[string]*types.FederationResult
+}
+
+type federationAPI struct {
+	federation Federation
+}
+
+func (f *federationAPI) sitesGet(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "topology", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(f.federation.GetSites()); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (f *federationAPI) topologySearch(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "topology", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	resource := types.TopologyParam{}
+	data, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(data, &resource); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validator.Validate(resource); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if resource.GremlinQuery == "" {
+		writeError(w, http.StatusBadRequest, errors.New("GremlinQuery is mandatory"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(f.federation.Query(resource.GremlinQuery)); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (f *federationAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "FederationSites",
+			Method:      "GET",
+			Path:        "/api/federation",
+			HandlerFunc: f.sitesGet,
+		},
+		{
+			Name:        "FederationTopologySearch",
+			Method:      "POST",
+			Path:        "/api/federation/topology",
+			HandlerFunc: f.topologySearch,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+// RegisterFederationAPI registers the endpoints listing the federated sites
+// and fanning out the Gremlin queries to them
+func RegisterFederationAPI(r *shttp.Server, f Federation) {
+	a := &federationAPI{
+		federation: f,
+	}
+
+	a.registerEndpoints(r)
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow/storage"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+// FlowAPI exposes the aggregations of the stored flows
+type FlowAPI struct {
+	storage storage.AggregationStorage
+}
+
+// aggregateFlows returns the aggregations of the search query posted, the
+// buckets being computed by the storage instead of returning the flows
+func (fa *FlowAPI) aggregateFlows(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "flow", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if fa.storage == nil {
+		writeError(w, http.StatusBadRequest, errors.New("No storage backend supporting aggregations has been configured"))
+		return
+	}
+
+	var fsq filters.SearchQuery
+	if err := json.NewDecoder(r.Body).Decode(&fsq); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(fsq.Aggregations) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("No aggregation requested"))
+		return
+	}
+
+	if err := filters.ValidateAggregations(fsq.Aggregations); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	results, err := fa.storage.AggregateFlows(fsq)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (fa *FlowAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "AggregateFlows",
+			Method:      "POST",
+			Path:        "/api/flow/aggregate",
+			HandlerFunc: fa.aggregateFlows,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+// RegisterFlowAPI registers the flow aggregation API, available when the
+// storage supports it
+func RegisterFlowAPI(r *shttp.Server, store storage.Storage) {
+	fa := &FlowAPI{}
+	if store, ok := store.(storage.AggregationStorage); ok {
+		fa.storage = store
+	}
+	fa.registerEndpoints(r)
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/filters"
+)
+
+// fakeAggregationStorage returns its results, recording the last query
+type fakeAggregationStorage struct {
+	results map[string]*filters.AggregationResult
+	err     error
+	query   filters.SearchQuery
+}
+
+func (s *fakeAggregationStorage) AggregateFlows(fsq filters.SearchQuery) (map[string]*filters.AggregationResult, error) {
+	s.query = fsq
+	return s.results, s.err
+}
+
+func serveAggregateFlows(fa *FlowAPI, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/flow/aggregate", strings.NewReader(body))
+	fa.aggregateFlows(w, &auth.AuthenticatedRequest{Request: *r})
+	return w
+}
+
+func TestAggregateFlows(t *testing.T) {
+	hosts, bytes := int64(2), int64(1500)
+	store := &fakeAggregationStorage{
+		results: map[string]*filters.AggregationResult{
+			"talkers": {
+				Buckets: []*filters.AggregationBucket{
+					{Key: "10.0.0.1", Count: 5, Aggregations: map[string]*filters.AggregationResult{"bytes": {Value: &bytes}}},
+				},
+			},
+			"hosts": {Value: &hosts},
+		},
+	}
+	fa := &FlowAPI{storage: store}
+
+	w := serveAggregateFlows(fa, `{
+		"Filter": {"TermStringFilter": {"Key": "Network.Protocol", "Value": "IPV4"}},
+		"Aggregations": [
+			{"Name": "talkers", "Type": "terms", "Field": "Network.A", "Size": 5, "Order": "bytes",
+			 "Aggregations": [{"Name": "bytes", "Type": "sum", "Field": "Metric.ABBytes"}]},
+			{"Name": "hosts", "Type": "cardinality", "Field": "NodeTID"}
+		]
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the aggregations to be returned, got: %d %s", w.Code, w.Body.String())
+	}
+
+	query := store.query
+	if query.Filter == nil || query.Filter.TermStringFilter == nil || query.Filter.TermStringFilter.Value != "IPV4" {
+		t.Errorf("Expected the filter to be passed to the storage, got: %+v", query.Filter)
+	}
+	if len(query.Aggregations) != 2 {
+		t.Fatalf("Expected 2 aggregations to be passed to the storage, got: %+v", query.Aggregations)
+	}
+	talkers := query.Aggregations[0]
+	if talkers.Name != "talkers" || talkers.Type != filters.TermsAggregation || talkers.Field != "Network.A" || talkers.Size != 5 || talkers.Order != "bytes" {
+		t.Errorf("Expected the terms aggregation to be decoded, got: %+v", talkers)
+	}
+	if len(talkers.Aggregations) != 1 || talkers.Aggregations[0].Type != filters.SumAggregation {
+		t.Errorf("Expected the sum sub aggregation to be decoded, got: %+v", talkers.Aggregations)
+	}
+
+	var results map[string]struct {
+		Value   *int64
+		Buckets []struct {
+			Key          interface{}
+			Count        int64
+			Aggregations map[string]struct{ Value *int64 }
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	if value := results["hosts"].Value; value == nil || *value != 2 || results["hosts"].Buckets != nil {
+		t.Errorf("Expected the cardinality of the hosts, got: %s", w.Body.String())
+	}
+	buckets := results["talkers"].Buckets
+	if len(buckets) != 1 || buckets[0].Key != "10.0.0.1" || buckets[0].Count != 5 {
+		t.Fatalf("Expected the buckets of the talkers, got: %s", w.Body.String())
+	}
+	if value := buckets[0].Aggregations["bytes"].Value; value == nil || *value != 1500 {
+		t.Errorf("Expected the bytes of the talker, got: %s", w.Body.String())
+	}
+	if results["talkers"].Value != nil {
+		t.Errorf("Expected no value for a bucket aggregation, got: %s", w.Body.String())
+	}
+}
+
+func TestAggregateFlowsErrors(t *testing.T) {
+	store := &fakeAggregationStorage{}
+
+	tests := []struct {
+		name string
+		fa   *FlowAPI
+		body string
+		code int
+	}{
+		{"no storage", &FlowAPI{}, `{"Aggregations": [{"Name": "hosts", "Type": "cardinality", "Field": "NodeTID"}]}`, http.StatusBadRequest},
+		{"bad body", &FlowAPI{storage: store}, `{"Aggregations": `, http.StatusBadRequest},
+		{"no aggregation", &FlowAPI{storage: store}, `{}`, http.StatusBadRequest},
+		{"unsupported type", &FlowAPI{storage: store}, `{"Aggregations": [{"Name": "rtt", "Type": "avg", "Field": "RTT"}]}`, http.StatusBadRequest},
+		{"no interval", &FlowAPI{storage: store}, `{"Aggregations": [{"Name": "timeline", "Type": "histogram", "Field": "Start"}]}`, http.StatusBadRequest},
+		{"bad order", &FlowAPI{storage: store}, `{"Aggregations": [{"Name": "talkers", "Type": "terms", "Field": "Network.A", "Order": "bytes"}]}`, http.StatusBadRequest},
+		{"storage error", &FlowAPI{storage: &fakeAggregationStorage{err: errors.New("unavailable")}}, `{"Aggregations": [{"Name": "hosts", "Type": "cardinality", "Field": "NodeTID"}]}`, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		if w := serveAggregateFlows(test.fa, test.body); w.Code != test.code {
+			t.Errorf("Expected %d for %s, got: %d %s", test.code, test.name, w.Code, w.Body.String())
+		}
+	}
+
+	if store.query.Aggregations != nil {
+		t.Errorf("Expected the invalid requests not to reach the storage, got: %+v", store.query)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/flow"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// FlowTableAPI exposes the live dump of the flow tables of the nodes of a
+// capture, requested from their agents
+type FlowTableAPI struct {
+	captureHandler *CaptureAPIHandler
+	graph          *graph.Graph
+	tableClient    *flow.TableClient
+}
+
+// captureNodes returns the nodes capturing for the capture
+func (fa *FlowTableAPI) captureNodes(id string) []*graph.Node {
+	fa.graph.RLock()
+	defer fa.graph.RUnlock()
+
+	var nodes []*graph.Node
+	for _, n := range fa.graph.GetNodes(nil) {
+		if cid, _ := n.GetFieldString("Capture.ID"); cid == id {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (fa *FlowTableAPI) flowTableGet(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "capture", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	resource, ok := fa.captureHandler.Get(mux.Vars(&r.Request)["capture"])
+	if !ok || !isVisibleTo(resource, r.Username) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	capture := resource.(*types.Capture)
+
+	hnmap := topology.BuildHostNodeTIDMap(fa.captureNodes(capture.UUID))
+	if len(hnmap) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	dumps, err := fa.tableClient.DumpTables(hnmap)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(dumps); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (fa *FlowTableAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "FlowTableGet",
+			Method:      "GET",
+			Path:        "/api/flowtable/{capture}",
+			HandlerFunc: fa.flowTableGet,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+// RegisterFlowTableAPI registers the API dumping the flow tables of the
+// agents for a capture, before their flows are updated or expired
+func RegisterFlowTableAPI(r *shttp.Server, captureHandler *CaptureAPIHandler, g *graph.Graph, tableClient *flow.TableClient) {
+	fa := &FlowTableAPI{
+		captureHandler: captureHandler,
+		graph:          g,
+		tableClient:    tableClient,
+	}
+	fa.registerEndpoints(r)
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// GroupResourceHandler describes a group resource handler
+type GroupResourceHandler struct {
+}
+
+// GroupAPIHandler based on BasicAPIHandler
+type GroupAPIHandler struct {
+	BasicAPIHandler
+}
+
+// New creates a new group resource
+func (g *GroupResourceHandler) New() types.Resource {
+	id, _ := uuid.NewV4()
+
+	return &types.Group{
+		UUID: id.String(),
+	}
+}
+
+// Name returns "group"
+func (g *GroupResourceHandler) Name() string {
+	return "group"
+}
+
+// Create checks that the group is defined by either a tag or a Gremlin
+// query and that its name is unique
+func (g *GroupAPIHandler) Create(r types.Resource) error {
+	group := r.(*types.Group)
+
+	if (group.Tag == "") == (group.GremlinQuery == "") {
+		return errors.New("A group has to be defined by either a tag or a Gremlin query")
+	}
+
+	if group.GremlinQuery != "" {
+		if _, err := traversal.NewGremlinTraversalParser().Parse(strings.NewReader(group.GremlinQuery)); err != nil {
+			return fmt.Errorf("Invalid Gremlin query: %s", err.Error())
+		}
+	}
+
+	for _, resource := range g.BasicAPIHandler.Index() {
+		if other := resource.(*types.Group); other.Name == group.Name && other.UUID != group.UUID {
+			return fmt.Errorf("Duplicate group, name=%s", group.Name)
+		}
+	}
+
+	return g.BasicAPIHandler.Create(r)
+}
+
+// RegisterGroupAPI registers a new group api handler
+func RegisterGroupAPI(apiServer *Server) (*GroupAPIHandler, error) {
+	groupAPIHandler := &GroupAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &GroupResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+	}
+	if err := apiServer.RegisterAPIHandler(groupAPIHandler); err != nil {
+		return nil, err
+	}
+	return groupAPIHandler, nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// HealthCheck returns an error if a subsystem is not able to serve requests
+type HealthCheck func() error
+
+// HealthReporter is the interface to report the health of a service
+type HealthReporter interface {
+	// IsStarted returns whether the service completed its startup
+	IsStarted() bool
+	// GetHealthChecks returns the checks of the subsystems of the service
+	GetHealthChecks() map[string]HealthCheck
+}
+
+type healthAPI struct {
+	reporter HealthReporter
+}
+
+func writeHealth(w http.ResponseWriter, ok bool, result interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+// healthz is the liveness endpoint, it reports that the service is able to
+// answer requests, regardless of the state of its subsystems
+func (h *healthAPI) healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, true, map[string]string{"Status": "ok"})
+}
+
+// startupz reports whether the service completed its startup
+func (h *healthAPI) startupz(w http.ResponseWriter, r *http.Request) {
+	if !h.reporter.IsStarted() {
+		writeHealth(w, false, map[string]string{"Status": "starting"})
+		return
+	}
+	writeHealth(w, true, map[string]string{"Status": "ok"})
+}
+
+// readyz reports whether the service is started and all its subsystems are
+// able to serve requests
+func (h *healthAPI) readyz(w http.ResponseWriter, r *http.Request) {
+	ready := h.reporter.IsStarted()
+
+	checks := make(map[string]string)
+	for name, check := range h.reporter.GetHealthChecks() {
+		if err := check(); err != nil {
+			checks[name] = err.Error()
+			ready = false
+		} else {
+			checks[name] = "ok"
+		}
+	}
+
+	status := "ok"
+	if !ready {
+		status = "not ready"
+	}
+
+	writeHealth(w, ready, map[string]interface{}{
+		"Status": status,
+		"Checks": checks,
+	})
+}
+
+// RegisterHealthAPI registers the liveness, readiness and startup endpoints.
+// These endpoints are not authenticated so that they can be used by orchestrators.
+func RegisterHealthAPI(s *shttp.Server, r HealthReporter) {
+	h := &healthAPI{
+		reporter: r,
+	}
+
+	s.Router.HandleFunc("/healthz", h.healthz).Methods("GET")
+	s.Router.HandleFunc("/readyz", h.readyz).Methods("GET")
+	s.Router.HandleFunc("/startupz", h.startupz).Methods("GET")
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/api/types"
+)
+
+// MaintenanceResourceHandler describes a maintenance resource handler
+type MaintenanceResourceHandler struct {
+}
+
+// MaintenanceAPIHandler based on BasicAPIHandler, the maintenances are
+// stored with a TTL so that Etcd expires them at the end of their period
+type MaintenanceAPIHandler struct {
+	BasicAPIHandler
+}
+
+// New creates a new maintenance resource
+func (m *MaintenanceResourceHandler) New() types.Resource {
+	id, _ := uuid.NewV4()
+
+	return &types.Maintenance{
+		UUID:       id.String(),
+		CreateTime: time.Now().UTC(),
+	}
+}
+
+// Name returns "maintenance"
+func (m *MaintenanceResourceHandler) Name() string {
+	return "maintenance"
+}
+
+// Create computes the expiration time of the maintenance and stores it
+func (m *MaintenanceAPIHandler) Create(r types.Resource) error {
+	mt := r.(*types.Maintenance)
+
+	duration, err := time.ParseDuration(mt.Duration)
+	if err != nil {
+		return fmt.Errorf("Invalid maintenance duration '%s': %s", mt.Duration, err.Error())
+	}
+	if duration <= 0 {
+		return fmt.Errorf("Maintenance duration has to be positive, got %s", mt.Duration)
+	}
+
+	if mt.CreateTime.IsZero() {
+		mt.CreateTime = time.Now().UTC()
+	}
+	mt.ExpireTime = mt.CreateTime.Add(duration)
+
+	data, err := json.Marshal(mt)
+	if err != nil {
+		return err
+	}
+
+	etcdPath := fmt.Sprintf("/%s/%s", m.ResourceHandler.Name(), mt.ID())
+	_, err = m.EtcdKeyAPI.Set(context.Background(), etcdPath, string(data), &etcd.SetOptions{TTL: duration})
+	return err
+}
+
+// RegisterMaintenanceAPI registers a new maintenance api handler
+func RegisterMaintenanceAPI(apiServer *Server) (*MaintenanceAPIHandler, error) {
+	maintenanceAPIHandler := &MaintenanceAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &MaintenanceResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+	}
+	if err := apiServer.RegisterAPIHandler(maintenanceAPIHandler); err != nil {
+		return nil, err
+	}
+	return maintenanceAPIHandler, nil
+}
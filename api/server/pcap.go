@@ -23,49 +23,133 @@
 package server
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/abbot/go-http-auth"
+	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/flow/storage"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
 )
 
 // PcapAPI exposes the pcap injector API
 type PcapAPI struct {
 	Storage storage.Storage
+	Graph   *graph.Graph
 }
 
-func (p *PcapAPI) flowExpireUpdate(flows []*flow.Flow) {
+// pcapStitcher attributes the flows of an imported pcap to the graph nodes
+// owning one of their MAC or IP addresses
+type pcapStitcher struct {
+	sync.Mutex
+	index  topology.AddressIndex
+	tids   map[graph.Identifier]string
+	flows  map[string]bool
+	result types.PcapImportResult
+}
+
+func newPcapStitcher(g *graph.Graph) *pcapStitcher {
+	s := &pcapStitcher{
+		index: make(topology.AddressIndex),
+		tids:  make(map[graph.Identifier]string),
+		flows: make(map[string]bool),
+	}
+
+	if g == nil {
+		return s
+	}
+
+	g.RLock()
+	defer g.RUnlock()
+
+	// the TIDs are read now as the nodes are looked up without the graph lock
+	s.index = topology.NewAddressIndex(g)
+	for _, n := range s.index {
+		if tid, _ := n.GetFieldString("TID"); tid != "" {
+			s.tids[n.ID] = tid
+		}
+	}
+
+	return s
+}
+
+// nodeTID returns the TID of the node matching the flow, MAC addresses first
+func (s *pcapStitcher) nodeTID(f *flow.Flow) string {
+	if f.Link != nil {
+		if n := s.index.Lookup(f.Link.A, f.Link.B); n != nil {
+			return s.tids[n.ID]
+		}
+	}
+
+	if f.Network != nil {
+		if n := s.index.Lookup(f.Network.A, f.Network.B); n != nil {
+			return s.tids[n.ID]
+		}
+	}
+
+	return ""
+}
+
+// stitch sets the node TID of the flows, the flows being counted once
+// however many times the flow table reports them
+func (s *pcapStitcher) stitch(flows []*flow.Flow) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, f := range flows {
+		tid := s.nodeTID(f)
+		if tid != "" {
+			f.NodeTID = tid
+		}
+
+		if !s.flows[f.UUID] {
+			s.flows[f.UUID] = true
+			s.result.Flows++
+			if tid != "" {
+				s.result.Stitched++
+			}
+		}
+	}
+}
+
+func (p *PcapAPI) storeFlows(flows []*flow.Flow) {
 	if p.Storage != nil && len(flows) > 0 {
 		p.Storage.StoreFlows(flows)
 		logging.GetLogger().Debugf("%d flows stored", len(flows))
 	}
 }
 
-func (p *PcapAPI) injectPcap(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+// importPcap feeds the packets of the pcap to a flow table, the flows being
+// stitched and stored as they get updated and expired
+func (p *PcapAPI) importPcap(r io.ReadCloser, stitcher *pcapStitcher) error {
 	update := config.GetInt("flow.update")
 	expire := config.GetInt("flow.expire")
 
-	if !rbac.Enforce(r.Username, "pcap", "write") {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+	flowExpireUpdate := func(flows []*flow.Flow) {
+		stitcher.stitch(flows)
+		p.storeFlows(flows)
 	}
 
-	updateHandler := flow.NewFlowHandler(p.flowExpireUpdate, time.Second*time.Duration(update))
-	expireHandler := flow.NewFlowHandler(p.flowExpireUpdate, time.Second*time.Duration(expire))
+	updateHandler := flow.NewFlowHandler(flowExpireUpdate, time.Second*time.Duration(update))
+	expireHandler := flow.NewFlowHandler(flowExpireUpdate, time.Second*time.Duration(expire))
 
 	flowtable := flow.NewTable(updateHandler, expireHandler, flow.NewEnhancerPipeline(), "", flow.TableOpts{})
 	packetSeqChan, _ := flowtable.Start()
 
-	feeder, err := flow.NewPcapTableFeeder(r.Body, packetSeqChan, false, "")
+	feeder, err := flow.NewPcapTableFeeder(r, packetSeqChan, false, "")
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err)
-		return
+		flowtable.Stop()
+		return err
 	}
 
 	feeder.Start()
@@ -74,8 +158,36 @@ func (p *PcapAPI) injectPcap(w http.ResponseWriter, r *auth.AuthenticatedRequest
 	// stop/flush flowtable
 	flowtable.Stop()
 
+	return nil
+}
+
+func (p *PcapAPI) injectPcap(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "pcap", "write") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var stitcher *pcapStitcher
+	if stitch, _ := strconv.ParseBool(r.URL.Query().Get("stitch")); stitch {
+		stitcher = newPcapStitcher(p.Graph)
+	} else {
+		stitcher = newPcapStitcher(nil)
+	}
+
+	if err := p.importPcap(r.Body, stitcher); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stitcher.Lock()
+	result := stitcher.result
+	stitcher.Unlock()
+
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logging.GetLogger().Errorf("Error while writing response: %s", err)
+	}
 }
 
 func (p *PcapAPI) registerEndpoints(r *shttp.Server) {
@@ -91,10 +203,12 @@ func (p *PcapAPI) registerEndpoints(r *shttp.Server) {
 	r.RegisterRoutes(routes)
 }
 
-// RegisterPcapAPI registers a new pcap injector API
-func RegisterPcapAPI(r *shttp.Server, store storage.Storage) {
+// RegisterPcapAPI registers a new pcap injector API, flows can optionally be
+// stitched to the nodes of the given graph
+func RegisterPcapAPI(r *shttp.Server, store storage.Storage, g *graph.Graph) {
 	p := &PcapAPI{
 		Storage: store,
+		Graph:   g,
 	}
 
 	p.registerEndpoints(r)
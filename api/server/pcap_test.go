@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestPcapStitch(t *testing.T) {
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host", backend)
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "tid1", "MAC": "1E:1A:51:F4:45:46"})
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "tid2", "IPV4": []string{"192.168.0.2/24"}})
+	g.NewNode(graph.GenID(), graph.Metadata{"IPV4": []string{"172.16.0.2/24"}})
+	g.Unlock()
+
+	file, err := os.Open("../../flow/pcaptraces/gre-mpls-icmpv4.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stitcher := newPcapStitcher(g)
+	if err := (&PcapAPI{}).importPcap(file, stitcher); err != nil {
+		t.Fatal(err)
+	}
+
+	// the GRE flow is stitched through its MAC address, the inner ICMP one
+	// through its IP address
+	if expected := (types.PcapImportResult{Flows: 2, Stitched: 2}); stitcher.result != expected {
+		t.Errorf("Expected %+v, got: %+v", expected, stitcher.result)
+	}
+}
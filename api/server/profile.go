@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/profiling"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+const defaultProfileDuration = 30
+
+// ProfileAPI exposes the profiling API, capturing the profiles either locally
+// or on the agents connected to the given pool
+type ProfileAPI struct {
+	hostID string
+	client *profiling.ProfilingClient
+}
+
+func (pa *ProfileAPI) profileGet(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "profile", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	req := &profiling.ProfileRequest{
+		Type:     query.Get("type"),
+		Duration: defaultProfileDuration,
+	}
+	if req.Type == "" {
+		req.Type = profiling.CPUProfile
+	}
+	if value := query.Get("duration"); value != "" {
+		var err error
+		if req.Duration, err = strconv.ParseInt(value, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := req.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var data []byte
+	var err error
+
+	host := mux.Vars(&r.Request)["host"]
+	if host == pa.hostID {
+		data, err = profiling.Capture(req)
+	} else if pa.client != nil {
+		data, err = pa.client.Capture(host, req)
+	} else {
+		err = common.ErrNotFound
+	}
+
+	if err != nil {
+		status := http.StatusBadRequest
+		switch err {
+		case common.ErrNotFound:
+			status = http.StatusNotFound
+		case profiling.ErrProfilingInProgress:
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.pprof", host, req.Type))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+// pprofHandler restricts the net/http/pprof handlers to the users allowed to
+// read the profiles
+func pprofHandler(h http.HandlerFunc) auth.AuthenticatedHandlerFunc {
+	return func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+		if !rbac.Enforce(r.Username, "profile", "read") {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, &r.Request)
+	}
+}
+
+func (pa *ProfileAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "ProfileGet",
+			Method:      "GET",
+			Path:        "/api/profile/{host}",
+			HandlerFunc: pa.profileGet,
+		},
+	}
+
+	if config.GetBool("http.pprof.enabled") {
+		routes = append(routes, []shttp.Route{
+			{
+				Name:        "PprofCmdline",
+				Method:      "GET",
+				Path:        "/debug/pprof/cmdline",
+				HandlerFunc: pprofHandler(pprof.Cmdline),
+			},
+			{
+				Name:        "PprofProfile",
+				Method:      "GET",
+				Path:        "/debug/pprof/profile",
+				HandlerFunc: pprofHandler(pprof.Profile),
+			},
+			{
+				Name:        "PprofSymbol",
+				Method:      "GET",
+				Path:        "/debug/pprof/symbol",
+				HandlerFunc: pprofHandler(pprof.Symbol),
+			},
+			{
+				Name:        "PprofTrace",
+				Method:      "GET",
+				Path:        "/debug/pprof/trace",
+				HandlerFunc: pprofHandler(pprof.Trace),
+			},
+			{
+				Name:        "PprofIndex",
+				Method:      "GET",
+				Path:        shttp.PathPrefix("/debug/pprof/"),
+				HandlerFunc: pprofHandler(pprof.Index),
+			},
+		}...)
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+// RegisterProfileAPI registers the profiling API, the profiles of the other
+// hosts are requested through the given pool when not nil
+func RegisterProfileAPI(r *shttp.Server, pool shttp.WSStructSpeakerPool) {
+	pa := &ProfileAPI{
+		hostID: config.GetString("host_id"),
+	}
+	if pool != nil {
+		pa.client = profiling.NewClient(pool)
+	}
+
+	pa.registerEndpoints(r)
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+
+	"github.com/skydive-project/skydive/config"
+	ge "github.com/skydive-project/skydive/gremlin/traversal"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// QueryCache caches the JSON results of the Gremlin queries against the
+// live graph. The entries are invalidated by any graph event or once they
+// are older than the TTL.
+type QueryCache struct {
+	graph.DefaultGraphListener
+	entries    *lru.Cache
+	ttl        time.Duration
+	generation int64
+}
+
+type queryCacheEntry struct {
+	data       []byte
+	generation int64
+	time       time.Time
+}
+
+// Cacheable returns whether the result of a query only depends on the live
+// graph, the queries with a time context or retrieving flows are not cached
+func (c *QueryCache) Cacheable(ts *traversal.GremlinTraversalSequence) bool {
+	for _, step := range ts.Steps() {
+		switch step.(type) {
+		case *traversal.GremlinTraversalStepContext, *ge.FlowGremlinTraversalStep:
+			return false
+		}
+	}
+	return true
+}
+
+// Generation returns the current generation of the graph, to record with
+// the result of a query executed afterwards
+func (c *QueryCache) Generation() int64 {
+	return atomic.LoadInt64(&c.generation)
+}
+
+// Get returns the cached result of a query
+func (c *QueryCache) Get(query string) ([]byte, bool) {
+	value, ok := c.entries.Get(query)
+	if !ok {
+		return nil, false
+	}
+
+	entry := value.(*queryCacheEntry)
+	if entry.generation != c.Generation() || time.Now().Sub(entry.time) > c.ttl {
+		c.entries.Remove(query)
+		return nil, false
+	}
+
+	return entry.data, true
+}
+
+// Set caches the result of a query executed at the given generation
+func (c *QueryCache) Set(query string, generation int64, data []byte) {
+	c.entries.Add(query, &queryCacheEntry{data: data, generation: generation, time: time.Now()})
+}
+
+func (c *QueryCache) invalidate() {
+	atomic.AddInt64(&c.generation, 1)
+}
+
+// OnNodeUpdated event
+func (c *QueryCache) OnNodeUpdated(n *graph.Node) {
+	c.invalidate()
+}
+
+// OnNodeAdded event
+func (c *QueryCache) OnNodeAdded(n *graph.Node) {
+	c.invalidate()
+}
+
+// OnNodeDeleted event
+func (c *QueryCache) OnNodeDeleted(n *graph.Node) {
+	c.invalidate()
+}
+
+// OnEdgeUpdated event
+func (c *QueryCache) OnEdgeUpdated(e *graph.Edge) {
+	c.invalidate()
+}
+
+// OnEdgeAdded event
+func (c *QueryCache) OnEdgeAdded(e *graph.Edge) {
+	c.invalidate()
+}
+
+// OnEdgeDeleted event
+func (c *QueryCache) OnEdgeDeleted(e *graph.Edge) {
+	c.invalidate()
+}
+
+// NewQueryCache returns a new query cache of the given size
+func NewQueryCache(g *graph.Graph, size int, ttl time.Duration) (*QueryCache, error) {
+	entries, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &QueryCache{
+		entries: entries,
+		ttl:     ttl,
+	}
+	g.AddEventListener(c)
+
+	return c, nil
+}
+
+// NewQueryCacheFromConfig returns the query cache of the analyzer, nil if
+// it is disabled
+func NewQueryCacheFromConfig(g *graph.Graph) (*QueryCache, error) {
+	if !config.GetBool("analyzer.topology.query_cache.enabled") {
+		return nil, nil
+	}
+
+	size := config.GetInt("analyzer.topology.query_cache.size")
+	ttl := time.Duration(config.GetInt("analyzer.topology.query_cache.ttl")) * time.Second
+
+	logging.GetLogger().Infof("Caching the results of %d Gremlin queries for %s", size, ttl)
+	return NewQueryCache(g, size, ttl)
+}
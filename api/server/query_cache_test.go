@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ge "github.com/skydive-project/skydive/gremlin/traversal"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+func newTestQueryCache(t *testing.T, ttl time.Duration) (*QueryCache, *graph.Graph) {
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host", backend)
+
+	cache, err := NewQueryCache(g, 10, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache, g
+}
+
+func TestQueryCacheInvalidation(t *testing.T) {
+	cache, g := newTestQueryCache(t, time.Hour)
+
+	cache.Set("G.V()", cache.Generation(), []byte("[]"))
+	if data, ok := cache.Get("G.V()"); !ok || string(data) != "[]" {
+		t.Fatal("Expected the result to be cached")
+	}
+
+	generation := cache.Generation()
+
+	g.Lock()
+	n := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host"})
+	g.Unlock()
+
+	if cache.Generation() == generation {
+		t.Fatal("Expected a graph event to bump the generation")
+	}
+	if _, ok := cache.Get("G.V()"); ok {
+		t.Fatal("Expected a graph event to invalidate the cached results")
+	}
+
+	// a result computed before an event isn't cached for the next queries
+	generation = cache.Generation()
+	g.Lock()
+	g.AddMetadata(n, "Name", "host1")
+	g.Unlock()
+
+	cache.Set("G.V()", generation, []byte("[{}]"))
+	if _, ok := cache.Get("G.V()"); ok {
+		t.Fatal("Expected a result of a previous generation to be ignored")
+	}
+
+	cache.Set("G.V()", cache.Generation(), []byte("[{}]"))
+	if _, ok := cache.Get("G.V()"); !ok {
+		t.Fatal("Expected the result of the current generation to be cached")
+	}
+}
+
+func TestQueryCacheTTL(t *testing.T) {
+	cache, _ := newTestQueryCache(t, 100*time.Millisecond)
+
+	cache.Set("G.V()", cache.Generation(), []byte("[]"))
+	if _, ok := cache.Get("G.V()"); !ok {
+		t.Fatal("Expected the result to be cached")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := cache.Get("G.V()"); ok {
+		t.Fatal("Expected the result to expire after the TTL")
+	}
+}
+
+func TestQueryCacheCacheable(t *testing.T) {
+	cache, _ := newTestQueryCache(t, time.Hour)
+
+	parser := traversal.NewGremlinTraversalParser()
+	parser.AddTraversalExtension(ge.NewFlowTraversalExtension(nil, nil))
+
+	tests := []struct {
+		query     string
+		cacheable bool
+	}{
+		{query: `G.V().Has("Type", "host")`, cacheable: true},
+		{query: `G.V().Out().Count()`, cacheable: true},
+		{query: `G.At("-1m").V()`},
+		{query: `G.Context("-1m", 300).V().Has("Type", "host")`},
+		{query: `G.V().Flows()`},
+		{query: `G.Flows().Has("Application", "TCP")`},
+	}
+
+	for _, test := range tests {
+		ts, err := parser.Parse(strings.NewReader(test.query))
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", test.query, err)
+		}
+
+		if cacheable := cache.Cacheable(ts); cacheable != test.cacheable {
+			t.Errorf("Expected %s to be cacheable: %t", test.query, test.cacheable)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/api/types"
+)
+
+// RemoteCaptureResourceHandler describes a remote capture resource handler
+type RemoteCaptureResourceHandler struct {
+}
+
+// RemoteCaptureAPIHandler based on BasicAPIHandler
+type RemoteCaptureAPIHandler struct {
+	BasicAPIHandler
+}
+
+// New creates a new remote capture resource
+func (r *RemoteCaptureResourceHandler) New() types.Resource {
+	id, _ := uuid.NewV4()
+
+	return &types.RemoteCapture{
+		UUID: id.String(),
+	}
+}
+
+// Name returns "remotecapture"
+func (r *RemoteCaptureResourceHandler) Name() string {
+	return "remotecapture"
+}
+
+// Create tests whether a capture is already running on the same host and interface
+func (r *RemoteCaptureAPIHandler) Create(resource types.Resource) error {
+	rc := resource.(*types.RemoteCapture)
+	if rc.Interface == "" {
+		rc.Interface = "any"
+	}
+
+	for _, res := range r.BasicAPIHandler.Index() {
+		c := res.(*types.RemoteCapture)
+		if c.Host == rc.Host && c.Interface == rc.Interface {
+			return fmt.Errorf("Duplicate remote capture, uuid=%s", c.UUID)
+		}
+	}
+
+	return r.BasicAPIHandler.Create(resource)
+}
+
+// RegisterRemoteCaptureAPI registers a new remote capture api handler
+func RegisterRemoteCaptureAPI(apiServer *Server) (*RemoteCaptureAPIHandler, error) {
+	remoteCaptureAPIHandler := &RemoteCaptureAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &RemoteCaptureResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+	}
+	if err := apiServer.RegisterAPIHandler(remoteCaptureAPIHandler); err != nil {
+		return nil, err
+	}
+	return remoteCaptureAPIHandler, nil
+}
@@ -0,0 +1,287 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/skydive-project/skydive/flow/report"
+	"github.com/skydive-project/skydive/flow/storage"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	defaultReportPeriod  = report.Week
+	defaultReportGroupBy = "Application"
+	defaultReportCount   = 4
+	defaultUsagePeriod   = 24 * time.Hour
+	defaultCompareBy     = "Application,Network.A,Network.B"
+	defaultThreshold     = 50
+)
+
+// ReportAPI exposes the flow trend reports API
+type ReportAPI struct {
+	reporter *report.Reporter
+	tenants  *report.TenantMapping
+}
+
+func (ra *ReportAPI) reportTrends(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "report", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if ra.reporter == nil {
+		writeError(w, http.StatusBadRequest, storage.ErrNoStorageConfigured)
+		return
+	}
+
+	query := r.URL.Query()
+
+	period := query.Get("period")
+	if period == "" {
+		period = defaultReportPeriod
+	}
+
+	groupBy := query.Get("groupby")
+	if groupBy == "" {
+		groupBy = defaultReportGroupBy
+	}
+
+	count := defaultReportCount
+	if value := query.Get("count"); value != "" {
+		var err error
+		if count, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	rp, err := ra.reporter.Trends(period, groupBy, count, time.Now().UTC())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if query.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		if err := rp.WriteCSV(w); err != nil {
+			logging.GetLogger().Warningf("Error while writing response: %s", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(rp); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+// usageRange returns the time range of the usage request, either given by the
+// from and to timestamps in milliseconds, or by a duration until now
+func usageRange(r *auth.AuthenticatedRequest) (from time.Time, to time.Time, err error) {
+	query := r.URL.Query()
+
+	to = time.Now().UTC()
+	if value := query.Get("to"); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return from, to, err
+		}
+		to = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	}
+
+	from = to.Add(-defaultUsagePeriod)
+	if value := query.Get("from"); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return from, to, err
+		}
+		from = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	} else if value := query.Get("since"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return from, to, err
+		}
+		from = to.Add(-d)
+	}
+
+	if !from.Before(to) {
+		return from, to, errors.New("Start of the range has to be before its end")
+	}
+	return from, to, nil
+}
+
+func (ra *ReportAPI) reportAccounting(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "report", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if ra.reporter == nil {
+		writeError(w, http.StatusBadRequest, storage.ErrNoStorageConfigured)
+		return
+	}
+
+	from, to, err := usageRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	accounting, err := ra.reporter.Usage(ra.tenants, from, to)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(accounting); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+// timestampParameter returns the timestamp in milliseconds of a required
+// query parameter
+func timestampParameter(r *auth.AuthenticatedRequest, name string) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, fmt.Errorf("Parameter %s is required", name)
+	}
+
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Parameter %s has to be a timestamp in milliseconds", name)
+	}
+	return ms, nil
+}
+
+// compareWindow returns the window given by the prefixed from and to
+// timestamps
+func compareWindow(r *auth.AuthenticatedRequest, prefix string) (w report.Window, err error) {
+	if w.Start, err = timestampParameter(r, prefix+"_from"); err != nil {
+		return
+	}
+	w.Last, err = timestampParameter(r, prefix+"_to")
+	return
+}
+
+func (ra *ReportAPI) reportCompare(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "report", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if ra.reporter == nil {
+		writeError(w, http.StatusBadRequest, storage.ErrNoStorageConfigured)
+		return
+	}
+
+	before, err := compareWindow(r, "before")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	after, err := compareWindow(r, "after")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	query := r.URL.Query()
+
+	by := query.Get("by")
+	if by == "" {
+		by = defaultCompareBy
+	}
+
+	threshold := float64(defaultThreshold)
+	if value := query.Get("threshold"); value != "" {
+		if threshold, err = strconv.ParseFloat(value, 64); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	comparison, err := ra.reporter.Compare(before, after, strings.Split(by, ","), threshold)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(comparison); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (ra *ReportAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "ReportTrends",
+			Method:      "GET",
+			Path:        "/api/report/trends",
+			HandlerFunc: ra.reportTrends,
+		},
+		{
+			Name:        "ReportAccounting",
+			Method:      "GET",
+			Path:        "/api/report/accounting",
+			HandlerFunc: ra.reportAccounting,
+		},
+		{
+			Name:        "ReportCompare",
+			Method:      "GET",
+			Path:        "/api/report/compare",
+			HandlerFunc: ra.reportCompare,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+// RegisterReportAPI registers a new flow trend reports, accounting and
+// comparison API
+func RegisterReportAPI(r *shttp.Server, store storage.Storage, g *graph.Graph) {
+	ra := &ReportAPI{tenants: report.NewTenantMappingFromConfig()}
+	if store != nil {
+		ra.reporter = report.NewReporter(g, store)
+	}
+
+	ra.registerEndpoints(r)
+}
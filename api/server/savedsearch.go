@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/api/types"
+)
+
+// SavedSearchResourceHandler describes a saved search resource handler
+type SavedSearchResourceHandler struct {
+}
+
+// SavedSearchAPIHandler based on BasicAPIHandler
+type SavedSearchAPIHandler struct {
+	BasicAPIHandler
+}
+
+// New creates a new saved search resource
+func (s *SavedSearchResourceHandler) New() types.Resource {
+	id, _ := uuid.NewV4()
+
+	return &types.SavedSearch{
+		UUID: id.String(),
+	}
+}
+
+// Name returns "savedsearch"
+func (s *SavedSearchResourceHandler) Name() string {
+	return "savedsearch"
+}
+
+// Create checks that the name of the saved search is unique among the ones
+// of its owner
+func (s *SavedSearchAPIHandler) Create(r types.Resource) error {
+	search := r.(*types.SavedSearch)
+
+	for _, resource := range s.BasicAPIHandler.Index() {
+		if other := resource.(*types.SavedSearch); other.Owner == search.Owner && other.Name == search.Name && other.UUID != search.UUID {
+			return fmt.Errorf("Duplicate saved search, name=%s", search.Name)
+		}
+	}
+
+	return s.BasicAPIHandler.Create(r)
+}
+
+// RegisterSavedSearchAPI registers a new saved search api handler
+func RegisterSavedSearchAPI(apiServer *Server) (*SavedSearchAPIHandler, error) {
+	savedSearchAPIHandler := &SavedSearchAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &SavedSearchResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+	}
+	if err := apiServer.RegisterAPIHandler(savedSearchAPIHandler); err != nil {
+		return nil, err
+	}
+	return savedSearchAPIHandler, nil
+}
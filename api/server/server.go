@@ -32,6 +32,7 @@ import (
 	"github.com/abbot/go-http-auth"
 	etcd "github.com/coreos/etcd/client"
 
+	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	shttp "github.com/skydive-project/skydive/http"
@@ -65,6 +66,15 @@ func writeError(w http.ResponseWriter, status int, err error) {
 	w.Write([]byte(err.Error()))
 }
 
+// isVisibleTo returns whether a resource is visible to a user, the resources
+// not owned by a user being visible to all the users allowed by the RBAC
+func isVisibleTo(resource types.Resource, user string) bool {
+	if owned, ok := resource.(types.OwnedResource); ok {
+		return owned.IsVisibleTo(user)
+	}
+	return true
+}
+
 // RegisterAPIHandler registers a new handler for an API
 func (a *Server) RegisterAPIHandler(handler Handler) error {
 	name := handler.Name()
@@ -85,7 +95,11 @@ func (a *Server) RegisterAPIHandler(handler Handler) error {
 				w.WriteHeader(http.StatusOK)
 
 				resources := handler.Index()
-				for _, resource := range resources {
+				for id, resource := range resources {
+					if !isVisibleTo(resource, r.Username) {
+						delete(resources, id)
+						continue
+					}
 					handler.Decorate(resource)
 				}
 
@@ -111,7 +125,7 @@ func (a *Server) RegisterAPIHandler(handler Handler) error {
 				}
 
 				resource, ok := handler.Get(id)
-				if !ok {
+				if !ok || !isVisibleTo(resource, r.Username) {
 					w.WriteHeader(http.StatusNotFound)
 					return
 				}
@@ -144,6 +158,9 @@ func (a *Server) RegisterAPIHandler(handler Handler) error {
 				}
 
 				resource.SetID(id)
+				if owned, ok := resource.(types.OwnedResource); ok {
+					owned.SetOwner(r.Username)
+				}
 
 				if err := validator.Validate(resource); err != nil {
 					writeError(w, http.StatusBadRequest, err)
@@ -184,6 +201,13 @@ func (a *Server) RegisterAPIHandler(handler Handler) error {
 					return
 				}
 
+				if resource, ok := handler.Get(id); ok {
+					if owned, ok := resource.(types.OwnedResource); ok && owned.GetOwner() != r.Username {
+						writeError(w, http.StatusForbidden, fmt.Errorf("%s %s is owned by %s", name, id, owned.GetOwner()))
+						return
+					}
+				}
+
 				if err := handler.Delete(id); err != nil {
 					writeError(w, http.StatusBadRequest, err)
 					return
@@ -0,0 +1,305 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/api/types"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+const serviceAccountPrefix = "serviceaccount:"
+
+var (
+	// ErrInvalidToken is returned when a token doesn't match any service account
+	ErrInvalidToken = errors.New("Invalid token")
+	// ErrRevokedToken is returned when the service account of a token was revoked
+	ErrRevokedToken = errors.New("Token revoked")
+	// ErrExpiredToken is returned when a token expired
+	ErrExpiredToken = errors.New("Token expired")
+)
+
+// ServiceAccountResourceHandler describes a service account resource handler
+type ServiceAccountResourceHandler struct {
+}
+
+// ServiceAccountAPIHandler based on BasicAPIHandler, it also validates the
+// API tokens of the service accounts, looked up by their hash in the
+// accounts kept up to date by watching the service account resources
+type ServiceAccountAPIHandler struct {
+	BasicAPIHandler
+	sync.RWMutex
+	accounts map[string]*types.ServiceAccount
+	hashes   map[string]string
+	watcher  StoppableWatcher
+}
+
+// New creates a new service account resource
+func (s *ServiceAccountResourceHandler) New() types.Resource {
+	id, _ := uuid.NewV4()
+
+	return &types.ServiceAccount{
+		UUID:       id.String(),
+		CreateTime: time.Now().UTC(),
+	}
+}
+
+// Name returns "serviceaccount"
+func (s *ServiceAccountResourceHandler) Name() string {
+	return "serviceaccount"
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validatePermission(perm string) error {
+	if perm == "*" {
+		return nil
+	}
+
+	if fields := strings.Split(perm, ":"); len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+		return fmt.Errorf("Invalid permission '%s', should be 'object:action' or '*'", perm)
+	}
+	return nil
+}
+
+// setAccount indexes a service account by the hash of its token
+func (s *ServiceAccountAPIHandler) setAccount(sa *types.ServiceAccount) {
+	s.Lock()
+	defer s.Unlock()
+
+	if hash, ok := s.hashes[sa.UUID]; ok {
+		delete(s.accounts, hash)
+	}
+	if sa.TokenHash != "" {
+		s.accounts[sa.TokenHash] = sa
+		s.hashes[sa.UUID] = sa.TokenHash
+	}
+}
+
+// removeAccount removes a service account from the index
+func (s *ServiceAccountAPIHandler) removeAccount(id string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if hash, ok := s.hashes[id]; ok {
+		delete(s.accounts, hash)
+		delete(s.hashes, id)
+	}
+}
+
+func (s *ServiceAccountAPIHandler) onAPIWatcherEvent(action string, id string, resource types.Resource) {
+	switch action {
+	case "init", "create", "set", "update":
+		s.setAccount(resource.(*types.ServiceAccount))
+	case "expire", "delete":
+		s.removeAccount(id)
+	}
+}
+
+// Decorate removes the token hash from the service account
+func (s *ServiceAccountAPIHandler) Decorate(resource types.Resource) {
+	sa := resource.(*types.ServiceAccount)
+	sa.Token = ""
+	sa.TokenHash = ""
+}
+
+// Create generates the token of the service account, only the hash of the
+// token is stored. The token itself is only returned once, at creation.
+func (s *ServiceAccountAPIHandler) Create(resource types.Resource) error {
+	sa := resource.(*types.ServiceAccount)
+
+	for _, perm := range sa.Permissions {
+		if err := validatePermission(perm); err != nil {
+			return err
+		}
+	}
+
+	for _, res := range s.BasicAPIHandler.Index() {
+		if res.(*types.ServiceAccount).Name == sa.Name {
+			return fmt.Errorf("Duplicate service account, name=%s", sa.Name)
+		}
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	sa.Token, sa.TokenHash, sa.Revoked = "", hashToken(token), false
+	if err := s.BasicAPIHandler.Create(sa); err != nil {
+		return err
+	}
+
+	// the token is valid at once, before the watcher gets the account
+	account := *sa
+	s.setAccount(&account)
+
+	sa.Token, sa.TokenHash = token, ""
+	return nil
+}
+
+// Revoke revokes the token of a service account, the account is kept
+func (s *ServiceAccountAPIHandler) Revoke(id string) error {
+	resource, ok := s.Get(id)
+	if !ok {
+		return fmt.Errorf("Service account %s not found", id)
+	}
+
+	sa := resource.(*types.ServiceAccount)
+	sa.Revoked = true
+
+	if err := s.BasicAPIHandler.Update(id, sa); err != nil {
+		return err
+	}
+	s.setAccount(sa)
+
+	rbac.RemoveScopes(serviceAccountPrefix + sa.Name)
+	return nil
+}
+
+// Delete a service account
+func (s *ServiceAccountAPIHandler) Delete(id string) error {
+	if resource, ok := s.Get(id); ok {
+		rbac.RemoveScopes(serviceAccountPrefix + resource.(*types.ServiceAccount).Name)
+	}
+	if err := s.BasicAPIHandler.Delete(id); err != nil {
+		return err
+	}
+	s.removeAccount(id)
+	return nil
+}
+
+// ValidateToken returns the identity of the service account owning the token.
+// The permissions of the identity are restricted to the scopes of the account.
+func (s *ServiceAccountAPIHandler) ValidateToken(token string) (string, error) {
+	s.RLock()
+	sa, ok := s.accounts[hashToken(token)]
+	s.RUnlock()
+
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	if sa.Revoked {
+		return "", ErrRevokedToken
+	}
+
+	if sa.Expired(time.Now().UTC()) {
+		return "", ErrExpiredToken
+	}
+
+	username := serviceAccountPrefix + sa.Name
+	rbac.SetScopes(username, sa.Permissions)
+
+	return username, nil
+}
+
+// Start watching the service accounts, their tokens being accepted from then
+func (s *ServiceAccountAPIHandler) Start() {
+	s.watcher = s.AsyncWatch(s.onAPIWatcherEvent)
+}
+
+// Stop watching the service accounts
+func (s *ServiceAccountAPIHandler) Stop() {
+	if s.watcher != nil {
+		s.watcher.Stop()
+	}
+}
+
+func (s *ServiceAccountAPIHandler) serviceAccountRevoke(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "serviceaccount", "write") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := mux.Vars(&r.Request)["id"]
+	if err := s.Revoke(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	resource, _ := s.Get(id)
+	s.Decorate(resource)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resource); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+// RegisterServiceAccountAPI registers a new service account api handler and
+// makes the http server accept the tokens of the service accounts
+func RegisterServiceAccountAPI(apiServer *Server) (*ServiceAccountAPIHandler, error) {
+	serviceAccountAPIHandler := &ServiceAccountAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &ServiceAccountResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+		accounts: make(map[string]*types.ServiceAccount),
+		hashes:   make(map[string]string),
+	}
+	if err := apiServer.RegisterAPIHandler(serviceAccountAPIHandler); err != nil {
+		return nil, err
+	}
+
+	apiServer.HTTPServer.RegisterRoutes([]shttp.Route{
+		{
+			Name:        "ServiceAccountRevoke",
+			Method:      "POST",
+			Path:        "/api/serviceaccount/{id}/revoke",
+			HandlerFunc: serviceAccountAPIHandler.serviceAccountRevoke,
+		},
+	})
+
+	apiServer.HTTPServer.SetTokenValidator(serviceAccountAPIHandler)
+
+	return serviceAccountAPIHandler, nil
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/etcd/etcdtest"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+func newTestServiceAccountAPI(t *testing.T, server *etcdtest.Server) (*shttp.Server, *ServiceAccountAPIHandler) {
+	httpServer := shttp.NewServer("host", common.AnalyzerService, "127.0.0.1", 0, shttp.NewNoAuthenticationBackend(), "")
+
+	apiServer, err := NewAPI(httpServer, server.Client.KeysAPI, common.AnalyzerService)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := RegisterServiceAccountAPI(apiServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Start()
+
+	return httpServer, handler
+}
+
+func createTestServiceAccount(t *testing.T, handler *ServiceAccountAPIHandler, name string, permissions ...string) *types.ServiceAccount {
+	sa := handler.New().(*types.ServiceAccount)
+	sa.Name = name
+	sa.Permissions = permissions
+	if err := handler.Create(sa); err != nil {
+		t.Fatal(err)
+	}
+	if sa.Token == "" {
+		t.Fatal("Expected the token to be returned at creation")
+	}
+	return sa
+}
+
+func serveBearer(httpServer *shttp.Server, method, path, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	httpServer.Router.ServeHTTP(w, r)
+	return w
+}
+
+func TestServiceAccountToken(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	httpServer, handler := newTestServiceAccountAPI(t, server)
+	defer handler.Stop()
+
+	reader := createTestServiceAccount(t, handler, "reader", "serviceaccount:read", "capture:*")
+	username, err := handler.ValidateToken(reader.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "serviceaccount:reader" {
+		t.Errorf("Expected the identity of the service account, got: %s", username)
+	}
+	if !rbac.Enforce(username, "capture", "write") || !rbac.Enforce(username, "serviceaccount", "read") || rbac.Enforce(username, "serviceaccount", "write") {
+		t.Error("Expected the permissions to be restricted to the scopes of the account")
+	}
+
+	if w := serveBearer(httpServer, "GET", "/api/serviceaccount", reader.Token); w.Code != http.StatusOK {
+		t.Errorf("Expected the token to grant the read access, got: %d", w.Code)
+	}
+	if w := serveBearer(httpServer, "DELETE", "/api/serviceaccount/"+reader.UUID, reader.Token); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected the token to be denied the write access, got: %d", w.Code)
+	}
+
+	if _, err := handler.ValidateToken("unknown"); err != ErrInvalidToken {
+		t.Errorf("Expected an unknown token to be rejected, got: %v", err)
+	}
+	if w := serveBearer(httpServer, "GET", "/api/serviceaccount", "unknown"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown token to be unauthorized, got: %d", w.Code)
+	}
+
+	if err := handler.Revoke(reader.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.ValidateToken(reader.Token); err != ErrRevokedToken {
+		t.Errorf("Expected a revoked token to be rejected, got: %v", err)
+	}
+	if w := serveBearer(httpServer, "GET", "/api/serviceaccount", reader.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token to be unauthorized, got: %d", w.Code)
+	}
+
+	expired := handler.New().(*types.ServiceAccount)
+	expired.Name = "expired"
+	expired.ExpireTime = time.Now().UTC().Add(-time.Minute)
+	if err := handler.Create(expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.ValidateToken(expired.Token); err != ErrExpiredToken {
+		t.Errorf("Expected an expired token to be rejected, got: %v", err)
+	}
+
+	deleted := createTestServiceAccount(t, handler, "deleted", "*")
+	if err := handler.Delete(deleted.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.ValidateToken(deleted.Token); err != ErrInvalidToken {
+		t.Errorf("Expected the token of a deleted account to be rejected, got: %v", err)
+	}
+}
+
+// TestServiceAccountWatch checks that the tokens of the accounts created and
+// revoked by another analyzer, sharing the same etcd, are tracked
+func TestServiceAccountWatch(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	_, handler := newTestServiceAccountAPI(t, server)
+	defer handler.Stop()
+	_, other := newTestServiceAccountAPI(t, server)
+	defer other.Stop()
+
+	sa := createTestServiceAccount(t, other, "remote", "*")
+
+	waitFor := func(expected error) {
+		var err error
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if _, err = handler.ValidateToken(sa.Token); err == expected {
+				return
+			}
+		}
+		t.Fatalf("Expected the token validation to return %v, got: %v", expected, err)
+	}
+
+	waitFor(nil)
+
+	if err := other.Revoke(sa.UUID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(ErrRevokedToken)
+
+	if err := other.Delete(sa.UUID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(ErrInvalidToken)
+}
+
+func TestServiceAccountHiddenToken(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	httpServer, handler := newTestServiceAccountAPI(t, server)
+	defer handler.Stop()
+
+	sa := createTestServiceAccount(t, handler, "hidden", "*")
+
+	// the hash is stored, never returned
+	resp, err := server.Client.KeysAPI.Get(context.Background(), "/serviceaccount/"+sa.UUID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(resp.Node.Value, sa.Token) || !strings.Contains(resp.Node.Value, hashToken(sa.Token)) {
+		t.Errorf("Expected only the hash of the token to be stored, got: %s", resp.Node.Value)
+	}
+
+	for _, path := range []string{"/api/serviceaccount", "/api/serviceaccount/" + sa.UUID} {
+		w := serveBearer(httpServer, "GET", path, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected %s to be served, got: %d", path, w.Code)
+		}
+
+		body := w.Body.String()
+		if strings.Contains(body, sa.Token) || strings.Contains(body, hashToken(sa.Token)) {
+			t.Errorf("Expected %s to hide the token, got: %s", path, body)
+		}
+
+		var fields map[string]json.RawMessage
+		if path == "/api/serviceaccount" {
+			var accounts map[string]map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &accounts); err != nil {
+				t.Fatal(err)
+			}
+			fields = accounts[sa.UUID]
+		} else if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := fields["Name"]; !ok {
+			t.Errorf("Expected %s to return the account, got: %s", path, body)
+		}
+		for _, field := range []string{"Token", "TokenHash"} {
+			if _, ok := fields[field]; ok {
+				t.Errorf("Expected %s not to return the %s field, got: %s", path, field, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	defaultSimulationFlowsQuery = "G.Flows()"
+	defaultSimulationMaxHops    = 10
+)
+
+func edgeIDs(edges []*graph.Edge) (ids []string) {
+	for _, e := range edges {
+		ids = append(ids, string(e.ID))
+	}
+	return
+}
+
+// crossesRemoved returns whether the path goes through a removed node or edge
+func crossesRemoved(path []*graph.Edge, removed map[graph.Identifier]bool) bool {
+	for _, e := range path {
+		if removed[e.ID] || removed[e.GetParent()] || removed[e.GetChild()] {
+			return true
+		}
+	}
+	return false
+}
+
+// execSimulationQuery returns the nodes and edges, or the flows, returned by a Gremlin query
+func (t *TopologyAPI) execSimulationQuery(query string) ([]interface{}, error) {
+	ts, err := t.gremlinParser.Parse(strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ts.Exec(t.graph, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Values(), nil
+}
+
+func (t *TopologyAPI) simulateFailure(simulation *types.FailureSimulation) (*types.FailureSimulationResult, error) {
+	result := &types.FailureSimulationResult{
+		Nodes: simulation.Nodes,
+		Edges: simulation.Edges,
+		Flows: []*types.SimulatedFlow{},
+	}
+
+	removed := make(map[graph.Identifier]bool)
+	for _, id := range simulation.Nodes {
+		removed[graph.Identifier(id)] = true
+	}
+	for _, id := range simulation.Edges {
+		removed[graph.Identifier(id)] = true
+	}
+
+	if simulation.GremlinQuery != "" {
+		values, err := t.execSimulationQuery(simulation.GremlinQuery)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range values {
+			switch value := value.(type) {
+			case *graph.Node:
+				removed[value.ID] = true
+				result.Nodes = append(result.Nodes, string(value.ID))
+			case *graph.Edge:
+				removed[value.ID] = true
+				result.Edges = append(result.Edges, string(value.ID))
+			default:
+				return nil, errors.New("Gremlin query has to return nodes or edges")
+			}
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil, errors.New("No node nor edge to remove")
+	}
+
+	query := simulation.FlowsQuery
+	if query == "" {
+		query = defaultSimulationFlowsQuery
+	}
+
+	values, err := t.execSimulationQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	maxHops := simulation.MaxHops
+	if maxHops <= 0 {
+		maxHops = defaultSimulationMaxHops
+	}
+
+	t.graph.RLock()
+	defer t.graph.RUnlock()
+
+	index := topology.NewAddressIndex(t.graph)
+	for _, value := range values {
+		f, ok := value.(*flow.Flow)
+		if !ok {
+			return nil, errors.New("Flows query has to return flows")
+		}
+
+		a := index.Lookup(f.GetLink().GetA(), f.GetNetwork().GetA())
+		b := index.Lookup(f.GetLink().GetB(), f.GetNetwork().GetB())
+		if a == nil || b == nil {
+			result.Unresolved++
+			continue
+		}
+		result.Checked++
+
+		sf := &types.SimulatedFlow{
+			UUID:       f.UUID,
+			LayersPath: f.LayersPath,
+			A:          string(a.ID),
+			B:          string(b.ID),
+		}
+
+		// one of the endpoints disappears, nothing can be rerouted
+		if removed[a.ID] || removed[b.ID] {
+			sf.Lost = true
+			result.Flows = append(result.Flows, sf)
+			continue
+		}
+
+		if a.ID == b.ID {
+			continue
+		}
+
+		path := t.graph.LookupEdgePath(a, b, topology.Layer2Metadata, maxHops, nil)
+		if path == nil || !crossesRemoved(path, removed) {
+			continue
+		}
+		sf.Path = edgeIDs(path)
+
+		if newPath := t.graph.LookupEdgePath(a, b, topology.Layer2Metadata, maxHops, removed); newPath != nil {
+			sf.NewPath = edgeIDs(newPath)
+		} else {
+			sf.Lost = true
+		}
+
+		result.Flows = append(result.Flows, sf)
+	}
+
+	return result, nil
+}
+
+func (t *TopologyAPI) topologySimulate(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "topology", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var simulation types.FailureSimulation
+	if err := json.NewDecoder(r.Body).Decode(&simulation); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := t.simulateFailure(&simulation)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
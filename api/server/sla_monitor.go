@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// slaMonitorNamespace is the namespace of the identifiers of the nodes
+// holding the evaluations of the SLA monitors
+const slaMonitorNamespace = "0f3e6a52-3c2d-4b9e-8f41-6d7a2c9b5e18"
+
+// SLAMonitorNodeID returns the identifier of the node holding the
+// evaluations of a SLA monitor
+func SLAMonitorNodeID(id string) graph.Identifier {
+	return graph.GenIDNameBased(slaMonitorNamespace, id)
+}
+
+// SLAMonitorEvaluations returns the last evaluation and the history of
+// the evaluations of a SLA monitor node. The metadata of the nodes
+// replicated from another analyzer being decoded as maps, they are read
+// through their JSON representation.
+func SLAMonitorEvaluations(n *graph.Node) (*types.SLAStatus, []types.SLAStatus, error) {
+	field, err := n.GetField("SLAMonitor")
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	data, err := json.Marshal(field)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var evaluations struct {
+		Status  *types.SLAStatus
+		History []types.SLAStatus
+	}
+	if err := json.Unmarshal(data, &evaluations); err != nil {
+		return nil, nil, err
+	}
+
+	return evaluations.Status, evaluations.History, nil
+}
+
+// SLAMonitorResourceHandler describes a SLA monitor resource handler
+type SLAMonitorResourceHandler struct {
+}
+
+// SLAMonitorAPIHandler based on BasicAPIHandler, the status and the history
+// of the monitors are read from the nodes updated by the master analyzer
+type SLAMonitorAPIHandler struct {
+	BasicAPIHandler
+	Graph *graph.Graph
+}
+
+// New creates a new SLA monitor resource
+func (s *SLAMonitorResourceHandler) New() types.Resource {
+	id, _ := uuid.NewV4()
+
+	return &types.SLAMonitor{
+		UUID:       id.String(),
+		CreateTime: time.Now().UTC(),
+	}
+}
+
+// Name returns "slamonitor"
+func (s *SLAMonitorResourceHandler) Name() string {
+	return "slamonitor"
+}
+
+// Create tests that the monitor defines at least one threshold
+func (s *SLAMonitorAPIHandler) Create(r types.Resource) error {
+	monitor := r.(*types.SLAMonitor)
+
+	if monitor.MaxLatency < 0 || monitor.MinThroughput < 0 || monitor.MaxLoss < 0 {
+		return errors.New("SLA thresholds have to be positive")
+	}
+	if monitor.MaxLatency == 0 && monitor.MinThroughput == 0 && monitor.MaxLoss == 0 {
+		return errors.New("At least one of the latency, throughput or loss thresholds is required")
+	}
+
+	// the evaluations are not part of the definition
+	monitor.Status, monitor.History, monitor.Compliance = nil, nil, 0
+	if monitor.CreateTime.IsZero() {
+		monitor.CreateTime = time.Now().UTC()
+	}
+
+	return s.BasicAPIHandler.Create(r)
+}
+
+// Decorate populates the status, the history and the compliance, the
+// percentage of the evaluations with flows meeting the thresholds
+func (s *SLAMonitorAPIHandler) Decorate(resource types.Resource) {
+	monitor := resource.(*types.SLAMonitor)
+
+	s.Graph.RLock()
+	n := s.Graph.GetNode(SLAMonitorNodeID(monitor.UUID))
+	if n == nil {
+		s.Graph.RUnlock()
+		return
+	}
+	status, history, err := SLAMonitorEvaluations(n)
+	s.Graph.RUnlock()
+
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to decode the evaluations of SLA monitor %s: %s", monitor.UUID, err.Error())
+		return
+	}
+
+	monitor.Status = status
+	monitor.History = history
+
+	var evaluated, compliant int
+	for _, status := range monitor.History {
+		switch status.State {
+		case types.SLACompliant:
+			compliant++
+			evaluated++
+		case types.SLAViolated:
+			evaluated++
+		}
+	}
+	if evaluated > 0 {
+		monitor.Compliance = float64(compliant) * 100 / float64(evaluated)
+	}
+}
+
+// RegisterSLAMonitorAPI registers a new SLA monitor api handler
+func RegisterSLAMonitorAPI(apiServer *Server, g *graph.Graph) (*SLAMonitorAPIHandler, error) {
+	slaMonitorAPIHandler := &SLAMonitorAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &SLAMonitorResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+		Graph: g,
+	}
+	if err := apiServer.RegisterAPIHandler(slaMonitorAPIHandler); err != nil {
+		return nil, err
+	}
+	return slaMonitorAPIHandler, nil
+}
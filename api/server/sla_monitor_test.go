@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestSLAMonitorThresholds(t *testing.T) {
+	handler := &SLAMonitorAPIHandler{}
+
+	for _, monitor := range []*types.SLAMonitor{
+		{},
+		{MaxLatency: -1},
+		{MinThroughput: -1, MaxLatency: 10},
+		{MaxLoss: -0.5},
+	} {
+		if err := handler.Create(monitor); err == nil {
+			t.Errorf("Expected the thresholds of %+v to be refused", monitor)
+		}
+	}
+}
+
+func TestSLAMonitorDecorate(t *testing.T) {
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host", backend)
+	handler := &SLAMonitorAPIHandler{Graph: g}
+
+	monitor := &types.SLAMonitor{UUID: "monitor"}
+	handler.Decorate(monitor)
+	if monitor.Status != nil || monitor.Compliance != 0 {
+		t.Errorf("Expected no evaluation before the first one, got: %+v", monitor)
+	}
+
+	history := []types.SLAStatus{
+		{Timestamp: 1, State: types.SLACompliant},
+		{Timestamp: 2, State: types.SLANoData},
+		{Timestamp: 3, State: types.SLAViolated, Violations: []string{"Latency 30ms above 20ms"}},
+		{Timestamp: 4, State: types.SLACompliant},
+		{Timestamp: 5, State: types.SLACompliant},
+	}
+
+	g.Lock()
+	g.NewNode(SLAMonitorNodeID(monitor.UUID), graph.Metadata{
+		"SLAMonitor": map[string]interface{}{
+			"Status":  &history[len(history)-1],
+			"History": history,
+		},
+	})
+	g.Unlock()
+
+	handler.Decorate(monitor)
+	if monitor.Status == nil || monitor.Status.Timestamp != 5 || len(monitor.History) != len(history) {
+		t.Fatalf("Expected the evaluations of the node, got: %+v", monitor)
+	}
+	for i, status := range monitor.History {
+		if status.Timestamp != int64(i+1) {
+			t.Errorf("Expected the history to be kept in order, got: %+v", monitor.History)
+		}
+	}
+
+	// the evaluations without data are not part of the compliance
+	if monitor.Compliance != 75 {
+		t.Errorf("Expected a compliance of 75%%, got: %f", monitor.Compliance)
+	}
+}
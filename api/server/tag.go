@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/api/types"
+)
+
+// TagResourceHandler describes a tag resource handler
+type TagResourceHandler struct {
+}
+
+// TagAPIHandler based on BasicAPIHandler
+type TagAPIHandler struct {
+	BasicAPIHandler
+}
+
+// New creates a new tag resource
+func (t *TagResourceHandler) New() types.Resource {
+	id, _ := uuid.NewV4()
+
+	return &types.Tag{
+		UUID: id.String(),
+	}
+}
+
+// Name returns "tag"
+func (t *TagResourceHandler) Name() string {
+	return "tag"
+}
+
+// Create tests whether the same tag is already attached by the same query
+func (t *TagAPIHandler) Create(r types.Resource) error {
+	tag := r.(*types.Tag)
+	for _, resource := range t.BasicAPIHandler.Index() {
+		if other := resource.(*types.Tag); other.Name == tag.Name && other.GremlinQuery == tag.GremlinQuery {
+			return fmt.Errorf("Duplicate tag, uuid=%s", other.UUID)
+		}
+	}
+
+	return t.BasicAPIHandler.Create(r)
+}
+
+// RegisterTagAPI registers a new tag api handler
+func RegisterTagAPI(apiServer *Server) (*TagAPIHandler, error) {
+	tagAPIHandler := &TagAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &TagResourceHandler{},
+			EtcdKeyAPI:      apiServer.EtcdKeyAPI,
+		},
+	}
+	if err := apiServer.RegisterAPIHandler(tagAPIHandler); err != nil {
+		return nil, err
+	}
+	return tagAPIHandler, nil
+}
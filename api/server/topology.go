@@ -23,14 +23,17 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
 	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/flow"
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
@@ -42,10 +45,14 @@ import (
 	"github.com/skydive-project/skydive/validator"
 )
 
+// maximum number of hops of a topology fragment
+const maxFragmentHops = 10
+
 // TopologyAPI exposes the topology query API
 type TopologyAPI struct {
 	graph         *graph.Graph
 	gremlinParser *traversal.GremlinTraversalParser
+	cache         *QueryCache
 }
 
 func shortID(s graph.Identifier) graph.Identifier {
@@ -111,13 +118,23 @@ func (t *TopologyAPI) topologyIndex(w http.ResponseWriter, r *auth.Authenticated
 	t.graph.RLock()
 	defer t.graph.RUnlock()
 
+	// nodes sharing the same value for the given key are collapsed together
+	g := t.graph
+	if key := r.URL.Query().Get("summarize"); key != "" {
+		var err error
+		if g, err = graph.NewSummaryGraph(t.graph, t.graph.GetNodes(nil), key); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
 	w.WriteHeader(http.StatusOK)
 	if strings.Contains(r.Header.Get("Accept"), "vnd.graphviz") {
 		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=UTF-8")
-		t.graphToDot(w, t.graph)
+		t.graphToDot(w, g)
 	} else {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		if err := json.NewEncoder(w).Encode(t.graph); err != nil {
+		if err := json.NewEncoder(w).Encode(g); err != nil {
 			logging.GetLogger().Warningf("Error while writing response: %s", err)
 		}
 	}
@@ -153,13 +170,40 @@ func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.Authenticate
 		return
 	}
 
+	// only the JSON results of the queries against the live graph are cached
+	accept := r.Header.Get("Accept")
+	cacheable := t.cache != nil && t.cache.Cacheable(ts) &&
+		!strings.Contains(accept, "vnd.graphviz") && !strings.Contains(accept, "vnd.tcpdump.pcap") && !strings.Contains(accept, "x-pcapng")
+
+	var generation int64
+	if cacheable {
+		if data, ok := t.cache.Get(resource.GremlinQuery); ok {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+			return
+		}
+		generation = t.cache.Generation()
+	}
+
 	res, err := ts.Exec(t.graph, true)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	if strings.Contains(r.Header.Get("Accept"), "vnd.graphviz") {
+	if cacheable {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(res); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		t.cache.Set(resource.GremlinQuery, generation, buf.Bytes())
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	} else if strings.Contains(accept, "vnd.graphviz") {
 		if graphTraversal, ok := res.(*traversal.GraphTraversal); ok {
 			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=UTF-8")
 			w.WriteHeader(http.StatusOK)
@@ -167,7 +211,7 @@ func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.Authenticate
 		} else {
 			writeError(w, http.StatusNotAcceptable, errors.New("Only graph can be outputted as dot"))
 		}
-	} else if strings.Contains(r.Header.Get("Accept"), "vnd.tcpdump.pcap") {
+	} else if strings.Contains(accept, "vnd.tcpdump.pcap") {
 		if rawPacketsTraversal, ok := res.(*ge.RawPacketsTraversalStep); ok {
 			values := rawPacketsTraversal.Values()
 			if len(values) == 0 {
@@ -189,6 +233,35 @@ func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.Authenticate
 		} else {
 			writeError(w, http.StatusNotAcceptable, errors.New("Only RawPackets step result can be outputted as pcap"))
 		}
+	} else if strings.Contains(accept, "x-pcapng") {
+		if rawPacketsTraversal, ok := res.(*ge.RawPacketsTraversalStep); ok {
+			values := rawPacketsTraversal.Values()
+			if len(values) == 0 {
+				writeError(w, http.StatusNotFound, errors.New("No raw packet found, please check your Gremlin request and the time context"))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/x-pcapng")
+			w.WriteHeader(http.StatusOK)
+
+			pw, err := flow.NewPcapNgWriter(w)
+			if err != nil {
+				logging.GetLogger().Errorf("Error while writing pcapng header: %s", err)
+				return
+			}
+
+			for _, pf := range values {
+				m := pf.(map[string]*flow.RawPackets)
+				for uuid, fr := range m {
+					if err = pw.WriteRawPackets(uuid, fr, rawPacketsTraversal.Flow(uuid)); err != nil {
+						logging.GetLogger().Errorf("Error while writing pcapng: %s", err)
+						return
+					}
+				}
+			}
+		} else {
+			writeError(w, http.StatusNotAcceptable, errors.New("Only RawPackets step result can be outputted as pcapng"))
+		}
 	} else {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		w.WriteHeader(http.StatusOK)
@@ -198,6 +271,38 @@ func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.Authenticate
 	}
 }
 
+func (t *TopologyAPI) topologyFragment(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "topology", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	hops := 1
+	if value := r.URL.Query().Get("hops"); value != "" {
+		var err error
+		if hops, err = strconv.Atoi(value); err != nil || hops < 0 || hops > maxFragmentHops {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Hops has to be an integer between 0 and %d", maxFragmentHops))
+			return
+		}
+	}
+
+	t.graph.RLock()
+	defer t.graph.RUnlock()
+
+	vars := mux.Vars(&r.Request)
+	node := t.graph.GetNode(graph.Identifier(vars["id"]))
+	if node == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Node %s not found", vars["id"]))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(t.graph.GetFragment(node, hops, nil)); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
 func (t *TopologyAPI) registerEndpoints(r *shttp.Server) {
 	routes := []shttp.Route{
 		{
@@ -212,16 +317,30 @@ func (t *TopologyAPI) registerEndpoints(r *shttp.Server) {
 			Path:        "/api/topology",
 			HandlerFunc: t.topologySearch,
 		},
+		{
+			Name:        "TopologyFragment",
+			Method:      "GET",
+			Path:        "/api/topology/{id}/fragment",
+			HandlerFunc: t.topologyFragment,
+		},
+		{
+			Name:        "TopologySimulate",
+			Method:      "POST",
+			Path:        "/api/topology/simulate",
+			HandlerFunc: t.topologySimulate,
+		},
 	}
 
 	r.RegisterRoutes(routes)
 }
 
-// RegisterTopologyAPI registers a new topology query API
-func RegisterTopologyAPI(r *shttp.Server, g *graph.Graph, parser *traversal.GremlinTraversalParser) {
+// RegisterTopologyAPI registers a new topology query API, the results of
+// the queries being cached if a cache is given
+func RegisterTopologyAPI(r *shttp.Server, g *graph.Graph, parser *traversal.GremlinTraversalParser, cache *QueryCache) {
 	t := &TopologyAPI{
 		gremlinParser: parser,
 		graph:         g,
+		cache:         cache,
 	}
 
 	t.registerEndpoints(r)
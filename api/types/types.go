@@ -23,7 +23,10 @@
 package types
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/nu7hatch/gouuid"
@@ -36,18 +39,69 @@ type Resource interface {
 	SetID(string)
 }
 
+// OwnedResource is a resource belonging to the user who created it, only
+// visible to its owner and to the users it is shared with
+type OwnedResource interface {
+	Resource
+	GetOwner() string
+	SetOwner(owner string)
+	IsVisibleTo(user string) bool
+}
+
+// Ownership implements OwnedResource, SharedWith being the list of the users
+// the resource is shared with, "*" sharing it with everyone
+type Ownership struct {
+	Owner      string
+	SharedWith []string `json:",omitempty"`
+}
+
+// GetOwner returns the owner of the resource
+func (o *Ownership) GetOwner() string {
+	return o.Owner
+}
+
+// SetOwner sets the owner of the resource
+func (o *Ownership) SetOwner(owner string) {
+	o.Owner = owner
+}
+
+// IsVisibleTo returns whether the user owns the resource or whether it is
+// shared with them
+func (o *Ownership) IsVisibleTo(user string) bool {
+	if o.Owner == user {
+		return true
+	}
+	for _, shared := range o.SharedWith {
+		if shared == user || shared == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 // Alert is a set of parameters, the Alert Action will Trigger according to its Expression.
 type Alert struct {
 	Resource
 	UUID        string
-	Name        string `json:",omitempty"`
-	Description string `json:",omitempty"`
-	Expression  string `json:",omitempty" valid:"nonzero"`
-	Action      string `json:",omitempty" valid:"regexp=^(|http://|https://|file://).*$"`
-	Trigger     string `json:",omitempty" valid:"regexp=^(graph|duration:.+|)$"`
+	Name        string        `json:",omitempty"`
+	Description string        `json:",omitempty"`
+	Expression  string        `json:",omitempty" valid:"nonzero"`
+	Action      string        `json:",omitempty" valid:"regexp=^(|http://|https://|file://).*$"`
+	Trigger     string        `json:",omitempty" valid:"regexp=^(graph|duration:.+|)$"`
+	For         string        `json:",omitempty" valid:"regexp=^([0-9]+(ms|s|m|h))*$"`
+	Operator    string        `json:",omitempty" valid:"regexp=^(|AND|OR)$"`
+	Clauses     []AlertClause `json:",omitempty"`
 	CreateTime  time.Time
 }
 
+// AlertClause is an additional expression of a composite alert, combined with
+// the alert expression by the alert operator. The clause is satisfied once its
+// expression matched during the whole For duration.
+type AlertClause struct {
+	Expression string `valid:"nonzero"`
+	For        string `json:",omitempty" valid:"regexp=^([0-9]+(ms|s|m|h))*$"`
+}
+
 // ID returns the alert ID
 func (a *Alert) ID() string {
 	return a.UUID
@@ -68,6 +122,101 @@ func NewAlert() *Alert {
 	}
 }
 
+// Alert event states
+const (
+	AlertFired    = "fired"
+	AlertResolved = "resolved"
+)
+
+// AlertEvent describes a transition of an alert. Fired events hold the JSON
+// encoded data that triggered the alert in ReasonData.
+type AlertEvent struct {
+	UUID       string
+	AlertUUID  string
+	State      string
+	Timestamp  int64
+	Host       string
+	ReasonData string `json:",omitempty"`
+}
+
+// NewAlertEvent creates a new event of an alert
+func NewAlertEvent(alertUUID string, state string, host string, reasonData string) *AlertEvent {
+	id, _ := uuid.NewV4()
+
+	return &AlertEvent{
+		UUID:       id.String(),
+		AlertUUID:  alertUUID,
+		State:      state,
+		Timestamp:  time.Now().UTC().UnixNano() / int64(time.Millisecond),
+		Host:       host,
+		ReasonData: reasonData,
+	}
+}
+
+// RemoteCapture describes a capture started over SSH on a host that is not
+// running a Skydive agent
+type RemoteCapture struct {
+	UUID        string
+	Name        string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	Host        string `valid:"nonzero"`
+	Username    string `valid:"nonzero"`
+	KeyFile     string `json:",omitempty"`
+	HostKey     string `json:",omitempty"`
+	Interface   string `json:",omitempty"`
+	BPFFilter   string `json:",omitempty" valid:"isBPFFilter"`
+	Sudo        bool   `json:",omitempty"`
+}
+
+// ID returns the remote capture identifier
+func (r *RemoteCapture) ID() string {
+	return r.UUID
+}
+
+// SetID set a new identifier for this remote capture
+func (r *RemoteCapture) SetID(id string) {
+	r.UUID = id
+}
+
+// PcapImportResult describes the result of a pcap import, Stitched being
+// the number of flows attributed to a node of the topology
+type PcapImportResult struct {
+	Flows    int
+	Stitched int
+}
+
+// FailureSimulation describes the nodes and edges removed by a what-if
+// simulation, either by their identifiers or through a Gremlin query.
+// FlowsQuery selects the flows whose connectivity is checked.
+type FailureSimulation struct {
+	Nodes        []string `json:",omitempty"`
+	Edges        []string `json:",omitempty"`
+	GremlinQuery string   `json:",omitempty"`
+	FlowsQuery   string   `json:",omitempty"`
+	MaxHops      int      `json:",omitempty"`
+}
+
+// SimulatedFlow describes a flow whose layer2 path crosses a removed element.
+// Lost is set when no other path connects its endpoints.
+type SimulatedFlow struct {
+	UUID       string
+	LayersPath string
+	A          string
+	B          string
+	Path       []string
+	NewPath    []string `json:",omitempty"`
+	Lost       bool
+}
+
+// FailureSimulationResult describes the flows affected by a what-if simulation
+type FailureSimulationResult struct {
+	Nodes      []string
+	Edges      []string
+	Checked    int
+	Unresolved int
+	Flows      []*SimulatedFlow
+}
+
 // AnalyzerStatus describes the status of an analyzer
 type AnalyzerStatus struct {
 	Agents      map[string]shttp.WSConnStatus
@@ -77,27 +226,104 @@ type AnalyzerStatus struct {
 	Alerts      ElectionStatus
 	Captures    ElectionStatus
 	Probes      []string
+	Sites       map[string]FederationSite `json:",omitempty"`
+	// Health holds the result of the health checks of the subsystems, ok
+	// or the error of the check
+	Health map[string]string `json:",omitempty"`
+	Flows  FlowServerStatus
+	// AgentsInfo describes the agents of the topology, connected or not,
+	// from their host and capture nodes
+	AgentsInfo map[string]AgentInfo `json:",omitempty"`
+}
+
+// FlowServerStatus describes the flows received by an analyzer, Queue being
+// the number of flows waiting to be stored out of QueueSize and Rate the
+// flows received per second since the previous status
+type FlowServerStatus struct {
+	Received  int64
+	Rate      float64
+	Queue     int
+	QueueSize int
+}
+
+// AgentInfo describes an agent with its probes and the counters of its
+// captures, FlowRate being the flows created per second since the previous
+// status
+type AgentInfo struct {
+	Connected      bool
+	TopologyProbes []string `json:",omitempty"`
+	FlowProbes     []string `json:",omitempty"`
+	Captures       int
+	Packets        int64
+	Bytes          int64
+	PacketsDropped int64
+	ParseErrors    int64
+	FlowsCreated   int64
+	FlowRate       float64
+}
+
+// FederationSite describes the status of a site analyzer whose graph is
+// imported by a federation analyzer
+type FederationSite struct {
+	Address   string
+	Connected bool
+	Nodes     int
+	Edges     int
+}
+
+// FederationResult holds the result of a Gremlin query fanned out to a site
+// analyzer or the error it returned
+type FederationResult struct {
+	Result json.RawMessage `json:",omitempty"`
+	Error  string          `json:",omitempty"`
 }
 
 // Capture describes a capture API
 type Capture struct {
 	UUID           string
-	GremlinQuery   string `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
-	BPFFilter      string `json:"BPFFilter,omitempty" valid:"isBPFFilter"`
-	Name           string `json:"Name,omitempty"`
-	Description    string `json:"Description,omitempty"`
-	Type           string `json:"Type,omitempty"`
-	Count          int    `json:"Count"`
-	PCAPSocket     string `json:"PCAPSocket,omitempty"`
-	Port           int    `json:"Port,omitempty"`
-	RawPacketLimit int    `json:"RawPacketLimit,omitempty" valid:"isValidRawPacketLimit"`
-	HeaderSize     int    `json:"HeaderSize,omitempty" valid:"isValidCaptureHeaderSize"`
-	ExtraTCPMetric bool   `json:"ExtraTCPMetric"`
-	IPDefrag       bool   `json:"IPDefrag"`
-	ReassembleTCP  bool   `json:"ReassembleTCP"`
-	LayerKeyMode   string `json:"LayerKeyMode,omitempty" valid:"isValidLayerKeyMode"`
+	GremlinQuery   string        `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
+	BPFFilter      string        `json:"BPFFilter,omitempty" valid:"isBPFFilter"`
+	Name           string        `json:"Name,omitempty"`
+	Description    string        `json:"Description,omitempty"`
+	Type           string        `json:"Type,omitempty"`
+	Count          int           `json:"Count"`
+	PCAPSocket     string        `json:"PCAPSocket,omitempty"`
+	Port           int           `json:"Port,omitempty"`
+	RawPacketLimit int           `json:"RawPacketLimit,omitempty" valid:"isValidRawPacketLimit"`
+	HeaderSize     int           `json:"HeaderSize,omitempty" valid:"isValidCaptureHeaderSize"`
+	ExtraTCPMetric bool          `json:"ExtraTCPMetric"`
+	IPDefrag       bool          `json:"IPDefrag"`
+	ReassembleTCP  bool          `json:"ReassembleTCP"`
+	LayerKeyMode   string        `json:"LayerKeyMode,omitempty" valid:"isValidLayerKeyMode"`
+	FlowKey        string        `json:"FlowKey,omitempty" valid:"isValidFlowKey"`
+	FlowDirection  string        `json:"FlowDirection,omitempty" valid:"isValidFlowDirection"`
+	NetNS          string        `json:"NetNS,omitempty"`
+	Hosts          []string      `json:"Hosts,omitempty"`
+	Stats          *CaptureStats `json:"Stats,omitempty"`
 }
 
+// CaptureStats describes the traffic seen by the nodes of a capture. The top
+// talkers are the ones of the last stats update of the agents.
+type CaptureStats struct {
+	PacketsReceived int64
+	PacketsDropped  int64
+	Packets         int64
+	Bytes           int64
+	FlowsCreated    int64
+	ParseErrors     int64
+	TopTalkers      []CaptureTalker
+}
+
+// CaptureTalker describes the traffic sent by an address seen by a capture
+type CaptureTalker struct {
+	Address string
+	Packets int64
+	Bytes   int64
+}
+
+// AllHosts selects all the hosts in a host capture
+const AllHosts = "*"
+
 // ID returns the capture Identifier
 func (c *Capture) ID() string {
 	return c.UUID
@@ -119,6 +345,54 @@ func NewCapture(query string, bpfFilter string) *Capture {
 	}
 }
 
+// NewNetNSCapture creates a new capture of all the current and future
+// interfaces of the network namespaces with the given name
+func NewNetNSCapture(netns string, bpfFilter string) *Capture {
+	capture := NewCapture(NetNSGremlinQuery(netns), bpfFilter)
+	capture.NetNS = netns
+	return capture
+}
+
+// NetNSGremlinQuery returns the Gremlin expression of the interfaces of the
+// network namespaces with the given name
+func NetNSGremlinQuery(netns string) string {
+	return fmt.Sprintf("G.V().Has('Type', 'netns', 'Name', '%s').Out()", netns)
+}
+
+// NewHostsCapture creates a new capture of all the physical interfaces of
+// the given hosts, but the ones excluded by the analyzers
+func NewHostsCapture(hosts []string, bpfFilter string) *Capture {
+	capture := NewCapture(HostsGremlinQuery(hosts), bpfFilter)
+	capture.Hosts = hosts
+	return capture
+}
+
+// HostsGremlinQuery returns the Gremlin expression of the physical
+// interfaces of the given hosts
+func HostsGremlinQuery(hosts []string) string {
+	for _, host := range hosts {
+		if host == AllHosts {
+			return "G.V().Has('Type', 'host').Out().Has('Type', 'device')"
+		}
+	}
+	return fmt.Sprintf("G.V().Has('Type', 'host', 'Name', Within('%s')).Out().Has('Type', 'device')", strings.Join(hosts, "', '"))
+}
+
+// Validate verifies that the Gremlin expression of a namespace or host
+// capture matches its targets
+func (c *Capture) Validate() error {
+	if c.NetNS != "" && len(c.Hosts) > 0 {
+		return errors.New("a capture can't target both a namespace and hosts")
+	}
+	if c.NetNS != "" && c.GremlinQuery != NetNSGremlinQuery(c.NetNS) {
+		return fmt.Errorf("namespace capture has to use the Gremlin expression %s", NetNSGremlinQuery(c.NetNS))
+	}
+	if len(c.Hosts) > 0 && c.GremlinQuery != HostsGremlinQuery(c.Hosts) {
+		return fmt.Errorf("host capture has to use the Gremlin expression %s", HostsGremlinQuery(c.Hosts))
+	}
+	return nil
+}
+
 // ElectionStatus describes the status of an election
 type ElectionStatus struct {
 	IsMaster bool
@@ -175,6 +449,23 @@ type TopologyParam struct {
 	GremlinQuery string `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
 }
 
+// Event describes an event posted by an external system, a deployment or
+// a change for instance, attached to the topology as an annotation node
+// linked to the nodes returned by GremlinQuery and to the Nodes identifiers.
+// Timestamp is in milliseconds, the reception time being used if not set.
+type Event struct {
+	UUID         string
+	Source       string                 `valid:"nonzero"`
+	Kind         string                 `json:",omitempty"`
+	Title        string                 `valid:"nonzero"`
+	Description  string                 `json:",omitempty"`
+	URL          string                 `json:",omitempty"`
+	Timestamp    int64                  `json:",omitempty"`
+	GremlinQuery string                 `json:",omitempty"`
+	Nodes        []string               `json:",omitempty"`
+	Metadata     map[string]interface{} `json:",omitempty"`
+}
+
 // UserMetadata describes a user metadata
 type UserMetadata struct {
 	UUID         string
@@ -204,3 +495,306 @@ func NewUserMetadata(query string, key string, value string) *UserMetadata {
 		Value:        value,
 	}
 }
+
+// Tag attaches a tag to the nodes and edges matching a Gremlin query. The
+// tags of an element are listed in its Tags metadata.
+type Tag struct {
+	UUID         string
+	GremlinQuery string `valid:"isGremlinExpr"`
+	Name         string `valid:"nonzero"`
+}
+
+// ID returns the tag identifier
+func (t *Tag) ID() string {
+	return t.UUID
+}
+
+// SetID set a new identifier for this tag
+func (t *Tag) SetID(id string) {
+	t.UUID = id
+}
+
+// NewTag creates a new tag
+func NewTag(query string, name string) *Tag {
+	id, _ := uuid.NewV4()
+
+	return &Tag{
+		UUID:         id.String(),
+		GremlinQuery: query,
+		Name:         name,
+	}
+}
+
+// Group is a saved set of graph elements, defined either by a tag or by a
+// Gremlin query. The groups of an element are listed in its Groups metadata
+// so that captures, alerts and packet injections can target the members of
+// a group with G.V().Has('Groups', '<name>').
+type Group struct {
+	UUID         string
+	Name         string `valid:"nonzero"`
+	Description  string `json:",omitempty"`
+	Tag          string `json:",omitempty"`
+	GremlinQuery string `json:",omitempty"`
+}
+
+// ID returns the group identifier
+func (g *Group) ID() string {
+	return g.UUID
+}
+
+// SetID set a new identifier for this group
+func (g *Group) SetID(id string) {
+	g.UUID = id
+}
+
+// NewGroup creates a new group
+func NewGroup(name string, description string, tag string, query string) *Group {
+	id, _ := uuid.NewV4()
+
+	return &Group{
+		UUID:         id.String(),
+		Name:         name,
+		Description:  description,
+		Tag:          tag,
+		GremlinQuery: query,
+	}
+}
+
+// SavedSearch is a named Gremlin query, either on the topology or on the
+// flows with the Flows step
+type SavedSearch struct {
+	Ownership
+	UUID         string
+	Name         string `valid:"nonzero"`
+	Description  string `json:",omitempty"`
+	GremlinQuery string `valid:"isGremlinExpr"`
+}
+
+// ID returns the saved search identifier
+func (s *SavedSearch) ID() string {
+	return s.UUID
+}
+
+// SetID set a new identifier for this saved search
+func (s *SavedSearch) SetID(id string) {
+	s.UUID = id
+}
+
+// NewSavedSearch creates a new saved search
+func NewSavedSearch(name string, description string, query string) *SavedSearch {
+	id, _ := uuid.NewV4()
+
+	return &SavedSearch{
+		UUID:         id.String(),
+		Name:         name,
+		Description:  description,
+		GremlinQuery: query,
+	}
+}
+
+// DashboardWidget is a panel of a dashboard displaying the result of either
+// a saved search or a Gremlin query. Its position and size are expressed in
+// grid cells, Options holding the settings specific to its type.
+type DashboardWidget struct {
+	Title        string `json:",omitempty"`
+	Type         string
+	SavedSearch  string `json:",omitempty"`
+	GremlinQuery string `json:",omitempty"`
+	X            int
+	Y            int
+	Width        int
+	Height       int
+	Options      map[string]interface{} `json:",omitempty"`
+}
+
+// Dashboard is a named layout of widgets
+type Dashboard struct {
+	Ownership
+	UUID        string
+	Name        string `valid:"nonzero"`
+	Description string `json:",omitempty"`
+	Widgets     []DashboardWidget
+}
+
+// ID returns the dashboard identifier
+func (d *Dashboard) ID() string {
+	return d.UUID
+}
+
+// SetID set a new identifier for this dashboard
+func (d *Dashboard) SetID(id string) {
+	d.UUID = id
+}
+
+// DashboardWidgetTypes are the types of widgets a dashboard can hold
+var DashboardWidgetTypes = map[string]bool{"topology": true, "table": true, "chart": true, "counter": true}
+
+// Validate verifies the type, the source and the layout of the widgets
+func (d *Dashboard) Validate() error {
+	for i, widget := range d.Widgets {
+		if !DashboardWidgetTypes[widget.Type] {
+			return fmt.Errorf("widget %d has an unsupported type '%s'", i, widget.Type)
+		}
+		if (widget.SavedSearch == "") == (widget.GremlinQuery == "") {
+			return fmt.Errorf("widget %d has to use either a saved search or a Gremlin query", i)
+		}
+		if widget.X < 0 || widget.Y < 0 || widget.Width < 1 || widget.Height < 1 {
+			return fmt.Errorf("widget %d has an invalid position or size", i)
+		}
+	}
+	return nil
+}
+
+// NewDashboard creates a new dashboard
+func NewDashboard(name string, description string, widgets ...DashboardWidget) *Dashboard {
+	id, _ := uuid.NewV4()
+
+	return &Dashboard{
+		UUID:        id.String(),
+		Name:        name,
+		Description: description,
+		Widgets:     widgets,
+	}
+}
+
+// Maintenance marks the nodes matching a Gremlin query, and the nodes they
+// own, as under maintenance for a period. The alerts involving those nodes
+// are suppressed until the maintenance expires or is deleted.
+type Maintenance struct {
+	UUID         string
+	GremlinQuery string `valid:"isGremlinExpr"`
+	Reason       string `json:",omitempty"`
+	Duration     string `valid:"nonzero"`
+	CreateTime   time.Time
+	ExpireTime   time.Time
+}
+
+// ID returns the maintenance identifier
+func (m *Maintenance) ID() string {
+	return m.UUID
+}
+
+// SetID set a new identifier for this maintenance
+func (m *Maintenance) SetID(id string) {
+	m.UUID = id
+}
+
+// Expired returns whether the maintenance period is over
+func (m *Maintenance) Expired(now time.Time) bool {
+	return !m.ExpireTime.IsZero() && now.After(m.ExpireTime)
+}
+
+// NewMaintenance creates a new maintenance
+func NewMaintenance(query string, reason string, duration string) *Maintenance {
+	id, _ := uuid.NewV4()
+
+	return &Maintenance{
+		UUID:         id.String(),
+		GremlinQuery: query,
+		Reason:       reason,
+		Duration:     duration,
+		CreateTime:   time.Now().UTC(),
+	}
+}
+
+// SLAMonitor defines the thresholds of the traffic between the nodes of two
+// Gremlin queries, the nodes they own included. The latency is the average
+// round trip time of the flows in milliseconds, the throughput is in bytes
+// per second and the loss is the percentage of TCP segments skipped. Status
+// and History are populated by the analyzers.
+type SLAMonitor struct {
+	UUID          string
+	Name          string      `json:",omitempty"`
+	Description   string      `json:",omitempty"`
+	Source        string      `valid:"isGremlinExpr"`
+	Destination   string      `valid:"isGremlinExpr"`
+	MaxLatency    int64       `json:",omitempty"`
+	MinThroughput int64       `json:",omitempty"`
+	MaxLoss       float64     `json:",omitempty"`
+	Status        *SLAStatus  `json:",omitempty"`
+	History       []SLAStatus `json:",omitempty"`
+	Compliance    float64     `json:",omitempty"`
+	CreateTime    time.Time
+}
+
+// SLA states of an evaluation
+const (
+	SLACompliant = "compliant"
+	SLAViolated  = "violated"
+	SLANoData    = "nodata"
+)
+
+// SLAStatus describes an evaluation of a SLA monitor, Violations listing the
+// thresholds exceeded
+type SLAStatus struct {
+	Timestamp  int64
+	State      string
+	Flows      int
+	Latency    int64    `json:",omitempty"`
+	Throughput int64    `json:",omitempty"`
+	Loss       float64  `json:",omitempty"`
+	Violations []string `json:",omitempty"`
+}
+
+// ID returns the SLA monitor identifier
+func (s *SLAMonitor) ID() string {
+	return s.UUID
+}
+
+// SetID set a new identifier for this SLA monitor
+func (s *SLAMonitor) SetID(id string) {
+	s.UUID = id
+}
+
+// NewSLAMonitor creates a new SLA monitor
+func NewSLAMonitor(source string, destination string) *SLAMonitor {
+	id, _ := uuid.NewV4()
+
+	return &SLAMonitor{
+		UUID:        id.String(),
+		Source:      source,
+		Destination: destination,
+		CreateTime:  time.Now().UTC(),
+	}
+}
+
+// ServiceAccount describes a non-interactive identity authenticating with a
+// long-lived API token. Its permissions are given as "object:action" scopes.
+type ServiceAccount struct {
+	UUID        string
+	Name        string   `valid:"nonzero"`
+	Description string   `json:",omitempty"`
+	Permissions []string `json:",omitempty"`
+	Token       string   `json:",omitempty"`
+	TokenHash   string   `json:",omitempty"`
+	Revoked     bool     `json:",omitempty"`
+	CreateTime  time.Time
+	ExpireTime  time.Time
+}
+
+// ID returns the service account identifier
+func (s *ServiceAccount) ID() string {
+	return s.UUID
+}
+
+// SetID set a new identifier for this service account
+func (s *ServiceAccount) SetID(id string) {
+	s.UUID = id
+}
+
+// Expired returns whether the token of the service account expired
+func (s *ServiceAccount) Expired(now time.Time) bool {
+	return !s.ExpireTime.IsZero() && now.After(s.ExpireTime)
+}
+
+// NewServiceAccount creates a new service account
+func NewServiceAccount(name string, permissions []string) *ServiceAccount {
+	id, _ := uuid.NewV4()
+
+	return &ServiceAccount{
+		UUID:        id.String(),
+		Name:        name,
+		Permissions: permissions,
+		CreateTime:  time.Now().UTC(),
+	}
+}
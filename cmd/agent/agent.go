@@ -45,6 +45,13 @@ var AgentCmd = &cobra.Command{
 		config.Set("logging.id", "agent")
 		logging.GetLogger().Noticef("Skydive Agent %s starting...", version.Version)
 
+		if config.GetBool("agent.hardening.enabled") {
+			if err := agent.Harden(); err != nil {
+				logging.GetLogger().Errorf("Can't harden Skydive agent: %v", err)
+				os.Exit(1)
+			}
+		}
+
 		agent, err := agent.NewAgent()
 		if err != nil {
 			logging.GetLogger().Errorf("Can't start Skydive agent: %v", err)
@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	journalPath  string
+	forceRebuild bool
+)
+
+// RebuildCmd rebuilds the history of the graph from the journal
+var RebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Rebuild the graph history from the journal",
+	Long: "Replay the graph events of the journal into the Elasticsearch backend of the analyzer, " +
+		"to regenerate its indices from scratch after a mapping change for instance",
+	SilenceUsage: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		config.Set("logging.id", "analyzer")
+
+		if journalPath == "" {
+			journalPath = config.GetString("analyzer.topology.journal.path")
+		}
+		if journalPath == "" {
+			fmt.Fprintln(os.Stderr, "No graph journal specified")
+			os.Exit(1)
+		}
+
+		name := config.GetString("analyzer.topology.backend")
+		if driver := config.GetString("storage." + name + ".driver"); driver != "elasticsearch" {
+			fmt.Fprintf(os.Stderr, "The %s graph backend is not an Elasticsearch one\n", name)
+			os.Exit(1)
+		}
+
+		client, err := graph.NewElasticSearchClientFromConfig(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create the Elasticsearch client: %s\n", err)
+			os.Exit(1)
+		}
+
+		backend, err := graph.NewElasticSearchBackendFromClient(client)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create the Elasticsearch backend: %s\n", err)
+			os.Exit(1)
+		}
+		defer client.Stop()
+
+		// replaying over existing elements would duplicate their revisions
+		if !forceRebuild && len(backend.GetNodes(graph.GraphContext{}, nil)) > 0 {
+			fmt.Fprintf(os.Stderr, "The %s index is not empty, delete the topology indices first or use --force\n", client.GetIndexAlias())
+			os.Exit(1)
+		}
+
+		count, err := graph.ReplayJournalFile(journalPath, backend)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to replay the graph journal: %s\n", err)
+			os.Exit(1)
+		}
+
+		if err := client.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to flush the Elasticsearch indices: %s\n", err)
+			os.Exit(1)
+		}
+
+		logging.GetLogger().Noticef("%d graph events replayed from %s", count, journalPath)
+	},
+}
+
+func init() {
+	RebuildCmd.Flags().StringVarP(&journalPath, "journal", "", "", "graph journal to replay, defaults to analyzer.topology.journal.path")
+	RebuildCmd.Flags().BoolVarP(&forceRebuild, "force", "", false, "replay even if the topology index is not empty")
+
+	AnalyzerCmd.AddCommand(RebuildCmd)
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package chart
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// hostSpecificKeys are the configuration keys whose default values are
+// computed on the host running the command, they can't be part of a chart
+var hostSpecificKeys = []string{"host_id", "etcd.name", "logging.id"}
+
+// ChartCmd skydive chart root command
+var ChartCmd = &cobra.Command{
+	Use:          "chart",
+	Short:        "Skydive Helm chart tooling",
+	Long:         "Skydive Helm chart tooling",
+	SilenceUsage: false,
+}
+
+// ChartValuesCmd generates the chart values from the configuration schema
+var ChartValuesCmd = &cobra.Command{
+	Use:   "values",
+	Short: "Generate Helm chart values from the configuration",
+	Long:  "Generate Helm chart values where each key of the config section maps to a Skydive configuration key",
+	Run: func(cmd *cobra.Command, args []string) {
+		values := map[string]interface{}{
+			"config": config.GetSettings(hostSpecificKeys...),
+		}
+
+		data, err := yaml.Marshal(values)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to generate chart values: %s", err)
+			os.Exit(1)
+		}
+
+		fmt.Print(string(data))
+	},
+}
+
+func init() {
+	ChartCmd.AddCommand(ChartValuesCmd)
+}
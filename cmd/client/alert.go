@@ -39,6 +39,10 @@ var (
 	alertExpression  string
 	alertAction      string
 	alertTrigger     string
+	alertFor         string
+	alertOperator    string
+	alertClauses     []string
+	alertClausesFor  []string
 )
 
 // AlertCmd skydive alert root command
@@ -66,7 +70,21 @@ var AlertCreate = &cobra.Command{
 		alert.Description = alertDescription
 		alert.Expression = alertExpression
 		alert.Trigger = alertTrigger
+		alert.For = alertFor
 		alert.Action = alertAction
+		alert.Operator = alertOperator
+
+		if len(alertClausesFor) > len(alertClauses) {
+			logging.GetLogger().Error("More clause durations than clauses")
+			os.Exit(1)
+		}
+		for i, expression := range alertClauses {
+			clause := types.AlertClause{Expression: expression}
+			if i < len(alertClausesFor) {
+				clause.For = alertClausesFor[i]
+			}
+			alert.Clauses = append(alert.Clauses, clause)
+		}
 
 		if err := validator.Validate(alert); err != nil {
 			logging.GetLogger().Error(err)
@@ -158,8 +176,12 @@ func addAlertFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVarP(&alertName, "name", "", "", "alert name")
 	cmd.Flags().StringVarP(&alertDescription, "description", "", "", "description of the alert")
 	cmd.Flags().StringVarP(&alertTrigger, "trigger", "", "graph", "event that triggers the alert evaluation")
+	cmd.Flags().StringVarP(&alertFor, "for", "", "", "duration during which the expression has to match before triggering the alarm (e.g. 10m)")
 	cmd.Flags().StringVarP(&alertExpression, "expression", "", "", "Gremlin of JavaScript expression evaluated to trigger the alarm")
 	cmd.Flags().StringVarP(&alertAction, "action", "", "", "can be either an empty string, or a URL (use 'file://' for local scripts)")
+	cmd.Flags().StringVarP(&alertOperator, "operator", "", "", "combination of the expression and the clauses, AND or OR (default AND)")
+	cmd.Flags().StringArrayVarP(&alertClauses, "clause", "", []string{}, "additional expression of a composite alert, can be repeated")
+	cmd.Flags().StringArrayVarP(&alertClausesFor, "clause-for", "", []string{}, "duration during which the clause of the same position has to match (e.g. 5m)")
 }
 
 func init() {
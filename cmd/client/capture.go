@@ -24,6 +24,8 @@ package client
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 
 	"github.com/skydive-project/skydive/api/client"
@@ -42,6 +44,8 @@ var (
 	captureDescription string
 	captureType        string
 	nodeTID            string
+	netns              string
+	hosts              []string
 	port               int
 	headerSize         int
 	rawPacketLimit     int
@@ -49,6 +53,8 @@ var (
 	ipDefrag           bool
 	reassembleTCP      bool
 	layerKeyMode       string
+	flowKey            string
+	flowDirection      string
 )
 
 // CaptureCmd skdyive capture root command
@@ -72,6 +78,20 @@ var CaptureCreate = &cobra.Command{
 			}
 			gremlinQuery = fmt.Sprintf("g.V().Has('TID', '%s')", nodeTID)
 		}
+		if netns != "" {
+			if gremlinQuery != "" {
+				logging.GetLogger().Error("Options --netns, --node and --gremlin are exclusive")
+				os.Exit(1)
+			}
+			gremlinQuery = api.NetNSGremlinQuery(netns)
+		}
+		if len(hosts) > 0 {
+			if gremlinQuery != "" {
+				logging.GetLogger().Error("Options --hosts, --netns, --node and --gremlin are exclusive")
+				os.Exit(1)
+			}
+			gremlinQuery = api.HostsGremlinQuery(hosts)
+		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
@@ -90,6 +110,10 @@ var CaptureCreate = &cobra.Command{
 		capture.IPDefrag = ipDefrag
 		capture.ReassembleTCP = reassembleTCP
 		capture.LayerKeyMode = layerKeyMode
+		capture.FlowKey = flowKey
+		capture.FlowDirection = flowDirection
+		capture.NetNS = netns
+		capture.Hosts = hosts
 
 		if !config.GetConfig().GetBool("analyzer.packet_capture_enabled") {
 			capture.RawPacketLimit = 0
@@ -158,6 +182,84 @@ var CaptureGet = &cobra.Command{
 	},
 }
 
+// CaptureStats skydive capture stats command
+var CaptureStats = &cobra.Command{
+	Use:   "stats [capture]",
+	Short: "Display the traffic seen by a capture",
+	Long:  "Display the packets, bytes, flows, parsing errors and top talkers seen by the nodes of a capture",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var capture api.Capture
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err.Error())
+			os.Exit(1)
+		}
+
+		if err := client.Get("capture", args[0], &capture); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+
+		if capture.Stats == nil {
+			logging.GetLogger().Errorf("No node is capturing for %s yet", args[0])
+			os.Exit(1)
+		}
+		printJSON(capture.Stats)
+	},
+}
+
+// CaptureFlowTable skydive capture flowtable command
+var CaptureFlowTable = &cobra.Command{
+	Use:   "flowtable [capture]",
+	Short: "Dump the flow tables of a capture",
+	Long:  "Dump the live flow tables of the nodes of a capture, with the flows not expired yet and the update and expiration timers of the tables",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err.Error())
+			os.Exit(1)
+		}
+
+		resp, err := client.Request("GET", "flowtable/"+args[0], nil, nil)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNoContent:
+			logging.GetLogger().Errorf("No node is capturing for %s yet", args[0])
+			os.Exit(1)
+		default:
+			data, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("Failed to dump the flow tables, %s: %s", resp.Status, data)
+			os.Exit(1)
+		}
+
+		var dumps []interface{}
+		if err := common.JSONDecode(resp.Body, &dumps); err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+
+		printJSON(dumps)
+	},
+}
+
 // CaptureDelete skydive capture delete command
 var CaptureDelete = &cobra.Command{
 	Use:   "delete [capture]",
@@ -198,6 +300,8 @@ func addCaptureFlags(cmd *cobra.Command) {
 	helpText := fmt.Sprintf("Allowed capture types: %v", types)
 	cmd.Flags().StringVarP(&gremlinQuery, "gremlin", "", "", "Gremlin Query")
 	cmd.Flags().StringVarP(&nodeTID, "node", "", "", "node TID")
+	cmd.Flags().StringSliceVarP(&hosts, "hosts", "", nil, "capture all the physical interfaces of the hosts, * for all of them, except the interfaces excluded by the analyzers")
+	cmd.Flags().StringVarP(&netns, "netns", "", "", "capture all the current and future interfaces of the network namespace")
 	cmd.Flags().StringVarP(&bpfFilter, "bpf", "", "", "BPF filter")
 	cmd.Flags().StringVarP(&captureName, "name", "", "", "capture name")
 	cmd.Flags().StringVarP(&captureDescription, "description", "", "", "capture description")
@@ -209,12 +313,16 @@ func addCaptureFlags(cmd *cobra.Command) {
 	cmd.Flags().BoolVarP(&ipDefrag, "ip-defrag", "", false, "Defragment IPv4 packets, default: false")
 	cmd.Flags().BoolVarP(&reassembleTCP, "reassamble-tcp", "", false, "Reassemble TCP packets, default: false")
 	cmd.Flags().StringVarP(&layerKeyMode, "layer-key-mode", "", "L2", "Defines the first layer used by flow key calculation, L2 or L3")
+	cmd.Flags().StringVarP(&flowKey, "flow-key", "", "", "Defines the flow aggregation key, 5-tuple, 3-tuple, ip-pair, l2-pair or a comma separated list of Link, Network, Protocol, Ports and Application, default: 5-tuple with the layer key mode")
+	cmd.Flags().StringVarP(&flowDirection, "flow-direction", "", "", "Defines how the initiator of a flow is chosen, first-packet or auto to rely on the TCP handshake, ICMP echo and well-known ports, default: flow.direction of the configuration")
 }
 
 func init() {
 	CaptureCmd.AddCommand(CaptureList)
 	CaptureCmd.AddCommand(CaptureCreate)
 	CaptureCmd.AddCommand(CaptureGet)
+	CaptureCmd.AddCommand(CaptureStats)
+	CaptureCmd.AddCommand(CaptureFlowTable)
 	CaptureCmd.AddCommand(CaptureDelete)
 
 	addCaptureFlags(CaptureCreate)
@@ -55,11 +55,18 @@ var ClientCmd = &cobra.Command{
 func RegisterClientCommands(cmd *cobra.Command) {
 	cmd.AddCommand(AlertCmd)
 	cmd.AddCommand(CaptureCmd)
+	cmd.AddCommand(ExportCmd)
+	cmd.AddCommand(GroupCmd)
+	cmd.AddCommand(MaintenanceCmd)
 	cmd.AddCommand(PacketInjectorCmd)
 	cmd.AddCommand(PcapCmd)
+	cmd.AddCommand(ProfileCmd)
 	cmd.AddCommand(QueryCmd)
+	cmd.AddCommand(ServiceAccountCmd)
 	cmd.AddCommand(ShellCmd)
+	cmd.AddCommand(SLAMonitorCmd)
 	cmd.AddCommand(StatusCmd)
+	cmd.AddCommand(TagCmd)
 	cmd.AddCommand(TopologyCmd)
 	cmd.AddCommand(UserMetadataCmd)
 }
@@ -67,6 +74,7 @@ func RegisterClientCommands(cmd *cobra.Command) {
 func init() {
 	ClientCmd.PersistentFlags().StringVarP(&AuthenticationOpts.Username, "username", "", os.Getenv("SKYDIVE_USERNAME"), "username auth parameter")
 	ClientCmd.PersistentFlags().StringVarP(&AuthenticationOpts.Password, "password", "", os.Getenv("SKYDIVE_PASSWORD"), "password auth parameter")
+	ClientCmd.PersistentFlags().StringVarP(&AuthenticationOpts.Token, "token", "", os.Getenv("SKYDIVE_TOKEN"), "API token of a service account")
 	ClientCmd.PersistentFlags().StringVarP(&analyzerAddr, "analyzer", "", os.Getenv("SKYDIVE_ANALYZER"), "analyzer address")
 
 	RegisterClientCommands(ClientCmd)
@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/skydive-project/skydive/api/client"
+	"github.com/skydive-project/skydive/flow/export"
+	"github.com/skydive-project/skydive/logging"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportFormat string
+	exportFrom   string
+	exportTo     string
+	exportSince  string
+	exportOutput string
+)
+
+// ExportCmd skydive export root command
+var ExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the stored flows",
+	Long:  "Export the stored flows of a time range as a Parquet or an IPFIX file",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if exportOutput == "" {
+			logging.GetLogger().Error("You need to specify an output file")
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		query := url.Values{"format": {exportFormat}}
+		for key, value := range map[string]string{"from": exportFrom, "to": exportTo, "since": exportSince} {
+			if value != "" {
+				query.Set(key, value)
+			}
+		}
+
+		resp, err := client.Request("GET", "export/flows?"+query.Encode(), nil, nil)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			content, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("Failed to export the flows, %s: %s", resp.Status, content)
+			os.Exit(1)
+		}
+
+		file, err := os.Create(exportOutput)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(file, resp.Body); err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s flows exported to %s\n", resp.Header.Get("X-Skydive-Flows"), exportOutput)
+	},
+}
+
+func init() {
+	ExportCmd.Flags().StringVarP(&exportFormat, "format", "", export.Parquet, "export format, parquet or ipfix")
+	ExportCmd.Flags().StringVarP(&exportFrom, "from", "", "", "start of the time range, in milliseconds")
+	ExportCmd.Flags().StringVarP(&exportTo, "to", "", "", "end of the time range, in milliseconds, now by default")
+	ExportCmd.Flags().StringVarP(&exportSince, "since", "", "", "duration of the time range until its end, 24h by default")
+	ExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write")
+}
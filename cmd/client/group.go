@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"os"
+
+	"github.com/skydive-project/skydive/api/client"
+	api "github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/validator"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	groupName        string
+	groupDescription string
+	groupTag         string
+)
+
+// GroupCmd skydive group root command
+var GroupCmd = &cobra.Command{
+	Use:          "group",
+	Short:        "Manage groups",
+	Long:         "Manage groups",
+	SilenceUsage: false,
+}
+
+// GroupCreate skydive group create command
+var GroupCreate = &cobra.Command{
+	Use:          "create",
+	Short:        "Save a group of graph elements defined by a tag or a Gremlin query",
+	Long:         "Save a group of graph elements defined by a tag or a Gremlin query",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if groupName == "" || (groupTag == "") == (gremlinQuery == "") {
+			logging.GetLogger().Error("--name and either --tag or --gremlin are mandatory")
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		group := api.NewGroup(groupName, groupDescription, groupTag, gremlinQuery)
+
+		if err := validator.Validate(group); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+
+		if err := client.Create("group", &group); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(group)
+	},
+}
+
+// GroupDelete skydive group delete command
+var GroupDelete = &cobra.Command{
+	Use:          "delete",
+	Short:        "Delete groups",
+	Long:         "Delete groups",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		for _, id := range args {
+			if err := client.Delete("group", id); err != nil {
+				logging.GetLogger().Error(err)
+			}
+		}
+	},
+}
+
+// GroupList skydive group list command
+var GroupList = &cobra.Command{
+	Use:          "list",
+	Short:        "List groups",
+	Long:         "List groups",
+	SilenceUsage: false,
+	Run: func(cmd *cobra.Command, args []string) {
+		var groups map[string]api.Group
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		if err := client.List("group", &groups); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(groups)
+	},
+}
+
+func init() {
+	GroupCmd.AddCommand(GroupCreate)
+	GroupCmd.AddCommand(GroupDelete)
+	GroupCmd.AddCommand(GroupList)
+
+	GroupCreate.Flags().StringVarP(&groupName, "name", "", "", "Group name")
+	GroupCreate.Flags().StringVarP(&groupDescription, "description", "", "", "Group description")
+	GroupCreate.Flags().StringVarP(&groupTag, "tag", "", "", "Tag of the members of the group")
+	GroupCreate.Flags().StringVarP(&gremlinQuery, "gremlin", "", "", "Gremlin expression of the members of the group")
+}
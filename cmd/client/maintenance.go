@@ -0,0 +1,139 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"os"
+
+	"github.com/skydive-project/skydive/api/client"
+	api "github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/validator"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	maintenanceReason   string
+	maintenanceDuration string
+)
+
+// MaintenanceCmd skydive maintenance root command
+var MaintenanceCmd = &cobra.Command{
+	Use:          "maintenance",
+	Short:        "Manage maintenances",
+	Long:         "Manage maintenances",
+	SilenceUsage: false,
+}
+
+// MaintenanceCreate skydive maintenance create command
+var MaintenanceCreate = &cobra.Command{
+	Use:          "create",
+	Short:        "Mark nodes as under maintenance",
+	Long:         "Mark nodes, and the nodes they own, as under maintenance",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if gremlinQuery == "" || maintenanceDuration == "" {
+			logging.GetLogger().Error("--gremlin and --duration are mandatory")
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		maintenance := api.NewMaintenance(gremlinQuery, maintenanceReason, maintenanceDuration)
+
+		if err := validator.Validate(maintenance); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+
+		if err := client.Create("maintenance", &maintenance); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(maintenance)
+	},
+}
+
+// MaintenanceDelete skydive maintenance delete command
+var MaintenanceDelete = &cobra.Command{
+	Use:          "delete",
+	Short:        "End maintenances",
+	Long:         "End maintenances",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		for _, id := range args {
+			if err := client.Delete("maintenance", id); err != nil {
+				logging.GetLogger().Error(err)
+			}
+		}
+	},
+}
+
+// MaintenanceList skydive maintenance list command
+var MaintenanceList = &cobra.Command{
+	Use:          "list",
+	Short:        "List maintenances",
+	Long:         "List maintenances",
+	SilenceUsage: false,
+	Run: func(cmd *cobra.Command, args []string) {
+		var maintenances map[string]api.Maintenance
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		if err := client.List("maintenance", &maintenances); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(maintenances)
+	},
+}
+
+func init() {
+	MaintenanceCmd.AddCommand(MaintenanceCreate)
+	MaintenanceCmd.AddCommand(MaintenanceDelete)
+	MaintenanceCmd.AddCommand(MaintenanceList)
+
+	MaintenanceCreate.Flags().StringVarP(&gremlinQuery, "gremlin", "", "", "Gremlin expression of the nodes under maintenance")
+	MaintenanceCreate.Flags().StringVarP(&maintenanceReason, "reason", "", "", "Reason of the maintenance")
+	MaintenanceCreate.Flags().StringVarP(&maintenanceDuration, "duration", "", "", "Duration of the maintenance, 2h for instance")
+}
@@ -23,19 +23,22 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 
 	"github.com/skydive-project/skydive/api/client"
+	api "github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/logging"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	pcapTrace string
+	pcapTrace  string
+	pcapStitch bool
 )
 
 // PcapCmd skydive pcap root command
@@ -64,14 +67,28 @@ var PcapCmd = &cobra.Command{
 		}
 		defer file.Close()
 
-		resp, err := client.Request("POST", "pcap", file, nil)
+		path := "pcap"
+		if pcapStitch {
+			path += "?stitch=true"
+		}
+
+		resp, err := client.Request("POST", path, file, nil)
 		if err != nil {
 			logging.GetLogger().Critical(err)
 			os.Exit(1)
 		}
 
 		if resp.StatusCode == http.StatusOK {
-			fmt.Printf("%s was successfully imported\n", pcapTrace)
+			var result api.PcapImportResult
+			if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+				fmt.Printf("%s was successfully imported, %d flows", pcapTrace, result.Flows)
+				if pcapStitch {
+					fmt.Printf(", %d stitched to the topology", result.Stitched)
+				}
+				fmt.Println()
+			} else {
+				fmt.Printf("%s was successfully imported\n", pcapTrace)
+			}
 		} else {
 			content, _ := ioutil.ReadAll(resp.Body)
 			logging.GetLogger().Errorf("Failed to import %s: %s", pcapTrace, string(content))
@@ -82,4 +99,5 @@ var PcapCmd = &cobra.Command{
 
 func init() {
 	PcapCmd.Flags().StringVarP(&pcapTrace, "trace", "t", "", "PCAP trace file to read")
+	PcapCmd.Flags().BoolVarP(&pcapStitch, "stitch", "", false, "attribute flows to the topology nodes matching their MAC or IP addresses")
 }
@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/skydive-project/skydive/api/client"
+	"github.com/skydive-project/skydive/logging"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	profileHost     string
+	profileType     string
+	profileDuration int
+	profileOutput   string
+)
+
+// ProfileCmd skydive profile root command
+var ProfileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Capture a CPU or memory profile of an agent or an analyzer",
+	Long:  "Capture a CPU or memory profile of an agent or an analyzer, in the pprof format",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if profileHost == "" {
+			logging.GetLogger().Error("You need to specify the host to profile")
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		path := fmt.Sprintf("profile/%s?type=%s&duration=%d", profileHost, profileType, profileDuration)
+		resp, err := client.Request("GET", path, nil, nil)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			content, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("Failed to capture the profile of %s: %s", profileHost, string(content))
+			os.Exit(1)
+		}
+
+		output := profileOutput
+		if output == "" {
+			output = fmt.Sprintf("%s-%s.pprof", profileHost, profileType)
+		}
+
+		file, err := os.Create(output)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(file, resp.Body); err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Profile of %s written to %s\n", profileHost, output)
+	},
+}
+
+func init() {
+	ProfileCmd.Flags().StringVarP(&profileHost, "host", "", "", "host ID of the agent or the analyzer to profile")
+	ProfileCmd.Flags().StringVarP(&profileType, "type", "", "cpu", "profile type: cpu, heap, goroutine, block, mutex, threadcreate")
+	ProfileCmd.Flags().IntVarP(&profileDuration, "duration", "", 30, "duration of the CPU profile in seconds")
+	ProfileCmd.Flags().StringVarP(&profileOutput, "output", "o", "", "file to write the profile to, <host>-<type>.pprof by default")
+}
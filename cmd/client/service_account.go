@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/skydive-project/skydive/api/client"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/validator"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serviceAccountName        string
+	serviceAccountDescription string
+	serviceAccountPermissions []string
+	serviceAccountTTL         string
+)
+
+// ServiceAccountCmd skydive service account root command
+var ServiceAccountCmd = &cobra.Command{
+	Use:          "serviceaccount",
+	Short:        "Manage service accounts",
+	Long:         "Manage service accounts and their API tokens",
+	SilenceUsage: false,
+}
+
+// ServiceAccountCreate skydive service account create command
+var ServiceAccountCreate = &cobra.Command{
+	Use:   "create",
+	Short: "Create service account",
+	Long:  "Create a service account, its API token is only displayed once",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+
+		sa := types.NewServiceAccount(serviceAccountName, serviceAccountPermissions)
+		sa.Description = serviceAccountDescription
+
+		if serviceAccountTTL != "" {
+			ttl, err := time.ParseDuration(serviceAccountTTL)
+			if err != nil {
+				logging.GetLogger().Error(err)
+				os.Exit(1)
+			}
+			sa.ExpireTime = sa.CreateTime.Add(ttl)
+		}
+
+		if err := validator.Validate(sa); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+
+		if err := client.Create("serviceaccount", &sa); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(&sa)
+	},
+}
+
+// ServiceAccountList skydive service account list command
+var ServiceAccountList = &cobra.Command{
+	Use:   "list",
+	Short: "List service accounts",
+	Long:  "List service accounts",
+	Run: func(cmd *cobra.Command, args []string) {
+		var serviceAccounts map[string]types.ServiceAccount
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		if err := client.List("serviceaccount", &serviceAccounts); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(serviceAccounts)
+	},
+}
+
+// ServiceAccountGet skydive service account get command
+var ServiceAccountGet = &cobra.Command{
+	Use:   "get [serviceaccount]",
+	Short: "Display service account",
+	Long:  "Display service account",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var sa types.ServiceAccount
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err.Error())
+			os.Exit(1)
+		}
+
+		if err := client.Get("serviceaccount", args[0], &sa); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(&sa)
+	},
+}
+
+// ServiceAccountRevoke skydive service account revoke command
+var ServiceAccountRevoke = &cobra.Command{
+	Use:   "revoke [serviceaccount]",
+	Short: "Revoke the token of service accounts",
+	Long:  "Revoke the token of service accounts",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+
+		for _, id := range args {
+			resp, err := client.Request("POST", fmt.Sprintf("serviceaccount/%s/revoke", id), nil, nil)
+			if err != nil {
+				logging.GetLogger().Error(err)
+				continue
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				content, _ := ioutil.ReadAll(resp.Body)
+				logging.GetLogger().Errorf("Failed to revoke %s: %s", id, string(content))
+				resp.Body.Close()
+				continue
+			}
+
+			var sa types.ServiceAccount
+			if err := json.NewDecoder(resp.Body).Decode(&sa); err != nil {
+				logging.GetLogger().Error(err)
+			} else {
+				printJSON(&sa)
+			}
+			resp.Body.Close()
+		}
+	},
+}
+
+// ServiceAccountDelete skydive service account delete command
+var ServiceAccountDelete = &cobra.Command{
+	Use:   "delete [serviceaccount]",
+	Short: "Delete service account",
+	Long:  "Delete service account",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+
+		for _, id := range args {
+			if err := client.Delete("serviceaccount", id); err != nil {
+				logging.GetLogger().Error(err)
+			}
+		}
+	},
+}
+
+func init() {
+	ServiceAccountCmd.AddCommand(ServiceAccountList)
+	ServiceAccountCmd.AddCommand(ServiceAccountGet)
+	ServiceAccountCmd.AddCommand(ServiceAccountCreate)
+	ServiceAccountCmd.AddCommand(ServiceAccountRevoke)
+	ServiceAccountCmd.AddCommand(ServiceAccountDelete)
+
+	ServiceAccountCreate.Flags().StringVarP(&serviceAccountName, "name", "", "", "service account name")
+	ServiceAccountCreate.Flags().StringVarP(&serviceAccountDescription, "description", "", "", "description of the service account")
+	ServiceAccountCreate.Flags().StringArrayVarP(&serviceAccountPermissions, "permission", "", nil, "permission granted to the service account as 'object:action' (e.g. topology:read), can be repeated")
+	ServiceAccountCreate.Flags().StringVarP(&serviceAccountTTL, "ttl", "", "", "lifetime of the token (e.g. 720h), never expires if empty")
+}
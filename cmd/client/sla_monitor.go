@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package client
+
+import (
+	"os"
+
+	"github.com/skydive-project/skydive/api/client"
+	api "github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/validator"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	slaName          string
+	slaDescription   string
+	slaSource        string
+	slaDestination   string
+	slaMaxLatency    int64
+	slaMinThroughput int64
+	slaMaxLoss       float64
+)
+
+// SLAMonitorCmd skydive sla-monitor root command
+var SLAMonitorCmd = &cobra.Command{
+	Use:          "sla-monitor",
+	Short:        "Manage SLA monitors",
+	Long:         "Manage SLA monitors",
+	SilenceUsage: false,
+}
+
+// SLAMonitorCreate skydive sla-monitor create command
+var SLAMonitorCreate = &cobra.Command{
+	Use:          "create",
+	Short:        "Create a SLA monitor",
+	Long:         "Create a SLA monitor of the traffic between the nodes of two Gremlin queries",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if slaSource == "" || slaDestination == "" {
+			logging.GetLogger().Error("--source and --destination are mandatory")
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		monitor := api.NewSLAMonitor(slaSource, slaDestination)
+		monitor.Name = slaName
+		monitor.Description = slaDescription
+		monitor.MaxLatency = slaMaxLatency
+		monitor.MinThroughput = slaMinThroughput
+		monitor.MaxLoss = slaMaxLoss
+
+		if err := validator.Validate(monitor); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+
+		if err := client.Create("slamonitor", &monitor); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(monitor)
+	},
+}
+
+// SLAMonitorGet skydive sla-monitor get command
+var SLAMonitorGet = &cobra.Command{
+	Use:          "get [sla-monitor]",
+	Short:        "Display a SLA monitor and its compliance history",
+	Long:         "Display a SLA monitor and its compliance history",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var monitor api.SLAMonitor
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		if err := client.Get("slamonitor", args[0], &monitor); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(&monitor)
+	},
+}
+
+// SLAMonitorDelete skydive sla-monitor delete command
+var SLAMonitorDelete = &cobra.Command{
+	Use:          "delete [sla-monitor]",
+	Short:        "Delete SLA monitors",
+	Long:         "Delete SLA monitors",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		for _, id := range args {
+			if err := client.Delete("slamonitor", id); err != nil {
+				logging.GetLogger().Error(err)
+			}
+		}
+	},
+}
+
+// SLAMonitorList skydive sla-monitor list command
+var SLAMonitorList = &cobra.Command{
+	Use:          "list",
+	Short:        "List SLA monitors",
+	Long:         "List SLA monitors",
+	SilenceUsage: false,
+	Run: func(cmd *cobra.Command, args []string) {
+		var monitors map[string]api.SLAMonitor
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		if err := client.List("slamonitor", &monitors); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(monitors)
+	},
+}
+
+func init() {
+	SLAMonitorCmd.AddCommand(SLAMonitorCreate)
+	SLAMonitorCmd.AddCommand(SLAMonitorDelete)
+	SLAMonitorCmd.AddCommand(SLAMonitorGet)
+	SLAMonitorCmd.AddCommand(SLAMonitorList)
+
+	SLAMonitorCreate.Flags().StringVarP(&slaName, "name", "", "", "SLA monitor name")
+	SLAMonitorCreate.Flags().StringVarP(&slaDescription, "description", "", "", "SLA monitor description")
+	SLAMonitorCreate.Flags().StringVarP(&slaSource, "source", "", "", "Gremlin expression of the source nodes")
+	SLAMonitorCreate.Flags().StringVarP(&slaDestination, "destination", "", "", "Gremlin expression of the destination nodes")
+	SLAMonitorCreate.Flags().Int64VarP(&slaMaxLatency, "max-latency", "", 0, "Maximum latency in milliseconds")
+	SLAMonitorCreate.Flags().Int64VarP(&slaMinThroughput, "min-throughput", "", 0, "Minimum throughput in bytes per second")
+	SLAMonitorCreate.Flags().Float64VarP(&slaMaxLoss, "max-loss", "", 0, "Maximum percentage of lost TCP segments")
+}
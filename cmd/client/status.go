@@ -23,9 +23,14 @@
 package client
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/skydive-project/skydive/api/client"
 	"github.com/skydive-project/skydive/api/types"
@@ -35,6 +40,51 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var statusFormat string
+
+func sortedKeys(m interface{}) (keys []string) {
+	switch m := m.(type) {
+	case map[string]string:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]types.AgentInfo:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// printStatus writes the analyzer status as tables of its health, of its
+// flow pipeline and of its agents
+func printStatus(out io.Writer, status *types.AnalyzerStatus) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "HEALTH\tSTATUS")
+	for _, name := range sortedKeys(status.Health) {
+		fmt.Fprintf(w, "%s\t%s\n", name, status.Health[name])
+	}
+	fmt.Fprintf(w, "alerts master\t%t\n", status.Alerts.IsMaster)
+	fmt.Fprintf(w, "captures master\t%t\n", status.Captures.IsMaster)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "FLOWS RECEIVED\tRATE/S\tQUEUE")
+	fmt.Fprintf(w, "%d\t%.1f\t%d/%d\n", status.Flows.Received, status.Flows.Rate, status.Flows.Queue, status.Flows.QueueSize)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "AGENT\tCONNECTED\tCAPTURES\tPACKETS\tBYTES\tDROPPED\tERRORS\tFLOWS\tFLOWS/S\tPROBES")
+	for _, host := range sortedKeys(status.AgentsInfo) {
+		a := status.AgentsInfo[host]
+		probes := append(append([]string{}, a.TopologyProbes...), a.FlowProbes...)
+		fmt.Fprintf(w, "%s\t%t\t%d\t%d\t%d\t%d\t%d\t%d\t%.1f\t%s\n", host, a.Connected, a.Captures,
+			a.Packets, a.Bytes, a.PacketsDropped, a.ParseErrors, a.FlowsCreated, a.FlowRate, strings.Join(probes, ","))
+	}
+
+	w.Flush()
+}
+
 // StatusShow shows an analyzer status
 var StatusCmd = &cobra.Command{
 	Use:   "status",
@@ -66,6 +116,18 @@ var StatusCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		printJSON(&status)
+		switch statusFormat {
+		case "json":
+			printJSON(&status)
+		case "text":
+			printStatus(os.Stdout, &status)
+		default:
+			logging.GetLogger().Errorf("Invalid output format %s", statusFormat)
+			os.Exit(1)
+		}
 	},
 }
+
+func init() {
+	StatusCmd.Flags().StringVarP(&statusFormat, "format", "", "json", "Output format (json or text), text showing the health, the flows and the agents")
+}
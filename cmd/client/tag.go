@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"os"
+
+	"github.com/skydive-project/skydive/api/client"
+	api "github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/validator"
+
+	"github.com/spf13/cobra"
+)
+
+var tagName string
+
+// TagCmd skydive tag root command
+var TagCmd = &cobra.Command{
+	Use:          "tag",
+	Short:        "Manage tags",
+	Long:         "Manage tags",
+	SilenceUsage: false,
+}
+
+// TagCreate skydive tag create command
+var TagCreate = &cobra.Command{
+	Use:          "create",
+	Short:        "Tag the nodes and edges matching a Gremlin query",
+	Long:         "Tag the nodes and edges matching a Gremlin query",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if gremlinQuery == "" || tagName == "" {
+			logging.GetLogger().Error("--gremlin and --name are mandatory")
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		tag := api.NewTag(gremlinQuery, tagName)
+
+		if err := validator.Validate(tag); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+
+		if err := client.Create("tag", &tag); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(tag)
+	},
+}
+
+// TagDelete skydive tag delete command
+var TagDelete = &cobra.Command{
+	Use:          "delete",
+	Short:        "Delete tags",
+	Long:         "Delete tags",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		for _, id := range args {
+			if err := client.Delete("tag", id); err != nil {
+				logging.GetLogger().Error(err)
+			}
+		}
+	},
+}
+
+// TagList skydive tag list command
+var TagList = &cobra.Command{
+	Use:          "list",
+	Short:        "List tags",
+	Long:         "List tags",
+	SilenceUsage: false,
+	Run: func(cmd *cobra.Command, args []string) {
+		var tags map[string]api.Tag
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		if err := client.List("tag", &tags); err != nil {
+			logging.GetLogger().Error(err)
+			os.Exit(1)
+		}
+		printJSON(tags)
+	},
+}
+
+func init() {
+	TagCmd.AddCommand(TagCreate)
+	TagCmd.AddCommand(TagDelete)
+	TagCmd.AddCommand(TagList)
+
+	TagCreate.Flags().StringVarP(&gremlinQuery, "gremlin", "", "", "Gremlin expression of the tagged nodes or edges")
+	TagCreate.Flags().StringVarP(&tagName, "name", "", "", "Tag name")
+}
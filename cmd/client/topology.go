@@ -23,15 +23,25 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 
+	"github.com/skydive-project/skydive/api/client"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/logging"
+
 	"github.com/spf13/cobra"
 )
 
 var (
 	gremlinQuery string
 	outputFormat string
+	fragmentHops int
+	simulation   types.FailureSimulation
 )
 
 // TopologyCmd skydive topology root command
@@ -53,8 +63,175 @@ var TopologyRequest = &cobra.Command{
 	},
 }
 
+// TopologyFragment skydive topology fragment command
+var TopologyFragment = &cobra.Command{
+	Use:   "fragment [node]",
+	Short: "Show the neighborhood of a node",
+	Long:  "Show the neighborhood of a node with a summary of the collapsed regions",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		resp, err := client.Request("GET", fmt.Sprintf("topology/%s/fragment?hops=%d", args[0], fragmentHops), nil, nil)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			content, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("Failed to get fragment of %s: %s", args[0], string(content))
+			os.Exit(1)
+		}
+
+		var fragment interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&fragment); err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		printJSON(&fragment)
+	},
+}
+
+// TopologySimulate skydive topology simulate command
+var TopologySimulate = &cobra.Command{
+	Use:   "simulate",
+	Short: "Simulate the failure of nodes and edges",
+	Long:  "Report the flows that would be rerouted or lost if the given nodes and edges were removed",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		s, err := json.Marshal(&simulation)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		resp, err := client.Request("POST", "topology/simulate", bytes.NewReader(s), nil)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			content, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("Failed to simulate failure: %s", string(content))
+			os.Exit(1)
+		}
+
+		var result types.FailureSimulationResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		printJSON(&result)
+	},
+}
+
+// TopologySites skydive topology sites command
+var TopologySites = &cobra.Command{
+	Use:   "sites",
+	Short: "List the federated sites",
+	Long:  "List the site analyzers whose topologies are imported by a federation analyzer",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		resp, err := client.Request("GET", "federation", nil, nil)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			content, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("Failed to list the federated sites: %s", string(content))
+			os.Exit(1)
+		}
+
+		var sites map[string]types.FederationSite
+		if err := json.NewDecoder(resp.Body).Decode(&sites); err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		printJSON(&sites)
+	},
+}
+
+// TopologyFederated skydive topology federated command
+var TopologyFederated = &cobra.Command{
+	Use:   "federated",
+	Short: "Query the federated sites",
+	Long:  "Fan out a Gremlin query to the site analyzers of a federation analyzer",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		s, err := json.Marshal(&types.TopologyParam{GremlinQuery: gremlinQuery})
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+
+		resp, err := client.Request("POST", "federation/topology", bytes.NewReader(s), nil)
+		if err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			content, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("Failed to query the federated sites: %s", string(content))
+			os.Exit(1)
+		}
+
+		var results map[string]*types.FederationResult
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			logging.GetLogger().Critical(err)
+			os.Exit(1)
+		}
+		printJSON(&results)
+	},
+}
+
 func init() {
 	TopologyCmd.AddCommand(TopologyRequest)
+	TopologyCmd.AddCommand(TopologyFragment)
+	TopologyFragment.Flags().IntVarP(&fragmentHops, "hops", "", 1, "Number of hops from the node")
+
+	TopologyCmd.AddCommand(TopologySimulate)
+	TopologySimulate.Flags().StringSliceVarP(&simulation.Nodes, "node", "", nil, "Identifier of a removed node")
+	TopologySimulate.Flags().StringSliceVarP(&simulation.Edges, "edge", "", nil, "Identifier of a removed edge")
+	TopologySimulate.Flags().StringVarP(&simulation.GremlinQuery, "gremlin", "", "", "Gremlin query returning the removed nodes or edges")
+	TopologySimulate.Flags().StringVarP(&simulation.FlowsQuery, "flows", "", "", "Gremlin query returning the flows to check, all the flows by default")
+	TopologySimulate.Flags().IntVarP(&simulation.MaxHops, "max-hops", "", 0, "Maximum number of edges of a path")
+	TopologyCmd.AddCommand(TopologySites)
+	TopologyCmd.AddCommand(TopologyFederated)
+	TopologyFederated.Flags().StringVarP(&gremlinQuery, "gremlin", "", "G", "Gremlin Query")
+
 	TopologyRequest.Flags().StringVarP(&gremlinQuery, "gremlin", "", "G", "Gremlin Query")
 	TopologyRequest.Flags().StringVarP(&outputFormat, "format", "", "json", "Output format (json, dot or pcap)")
 }
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package seed
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/skydive-project/skydive/analyzer"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/synthetic"
+
+	"github.com/spf13/cobra"
+)
+
+// SeedCmd skydive seed command
+var SeedCmd = &cobra.Command{
+	Use:          "seed",
+	Short:        "Seed the analyzers with a synthetic topology and flows",
+	Long:         "Seed the analyzers with a synthetic topology and flows, for load testing and UI development",
+	SilenceUsage: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		config.Set("logging.id", "seed")
+
+		seeder, err := synthetic.NewSeederFromConfig(analyzer.NewAnalyzerAuthenticationOpts())
+		if err != nil {
+			logging.GetLogger().Errorf("Can't start the seeder: %v", err)
+			os.Exit(1)
+		}
+
+		seeder.Start()
+
+		logging.GetLogger().Notice("Skydive seeder started")
+		ch := make(chan os.Signal)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		<-ch
+
+		seeder.Stop()
+
+		logging.GetLogger().Notice("Skydive seeder stopped.")
+	},
+}
+
+func init() {
+	SeedCmd.Flags().Int("hosts", 10, "number of synthetic hosts")
+	config.BindPFlag("seed.topology.hosts", SeedCmd.Flags().Lookup("hosts"))
+
+	SeedCmd.Flags().Int("interfaces", 2, "number of physical interfaces per host")
+	config.BindPFlag("seed.topology.interfaces", SeedCmd.Flags().Lookup("interfaces"))
+
+	SeedCmd.Flags().Int("pods", 10, "number of pods per host")
+	config.BindPFlag("seed.topology.pods", SeedCmd.Flags().Lookup("pods"))
+
+	SeedCmd.Flags().Bool("k8s", true, "add the k8s layer, cluster, namespaces and pods")
+	config.BindPFlag("seed.topology.k8s", SeedCmd.Flags().Lookup("k8s"))
+
+	SeedCmd.Flags().Int("flows", 100, "number of concurrent synthetic flows")
+	config.BindPFlag("seed.flows.count", SeedCmd.Flags().Lookup("flows"))
+}
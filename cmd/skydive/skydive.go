@@ -31,9 +31,11 @@ import (
 	"github.com/skydive-project/skydive/cmd/agent"
 	"github.com/skydive-project/skydive/cmd/allinone"
 	"github.com/skydive-project/skydive/cmd/analyzer"
+	"github.com/skydive-project/skydive/cmd/chart"
 	"github.com/skydive-project/skydive/cmd/client"
 	"github.com/skydive-project/skydive/cmd/completion"
 	"github.com/skydive-project/skydive/cmd/config"
+	"github.com/skydive-project/skydive/cmd/seed"
 	"github.com/skydive-project/skydive/cmd/version"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/spf13/cobra"
@@ -65,8 +67,10 @@ func init() {
 	} else {
 		RootCmd.AddCommand(agent.AgentCmd)
 		RootCmd.AddCommand(analyzer.AnalyzerCmd)
+		RootCmd.AddCommand(chart.ChartCmd)
 		RootCmd.AddCommand(completion.BashCompletion)
 		RootCmd.AddCommand(client.ClientCmd)
+		RootCmd.AddCommand(seed.SeedCmd)
 		RootCmd.AddCommand(version.VersionCmd)
 
 		if allinone.AllInOneCmd != nil {
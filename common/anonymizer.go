@@ -0,0 +1,181 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Anonymization modes
+const (
+	// AnonymizeHash replaces the values by salted hashes of the same kind,
+	// a given value always giving the same hash so that the anonymized
+	// addresses can still be correlated
+	AnonymizeHash = "hash"
+	// AnonymizeTruncate keeps the network part of the IPs, the OUI of the
+	// MACs and the domain of the hostnames
+	AnonymizeTruncate = "truncate"
+)
+
+// Anonymizer hashes or truncates the IPs, the MACs and the hostnames. The
+// produced values are kept so that anonymizing a value twice, as the graph
+// does when an already anonymized element is updated, is a no-op.
+type Anonymizer struct {
+	sync.RWMutex
+	mode     string
+	salt     []byte
+	produced map[string]bool
+}
+
+func (a *Anonymizer) sum(kind, s string) []byte {
+	h := hmac.New(sha256.New, a.salt)
+	h.Write([]byte(kind + ":" + s))
+	return h.Sum(nil)
+}
+
+func (a *Anonymizer) isProduced(s string) bool {
+	a.RLock()
+	defer a.RUnlock()
+	return a.produced[s]
+}
+
+func (a *Anonymizer) produce(s string) string {
+	a.Lock()
+	a.produced[s] = true
+	a.Unlock()
+	return s
+}
+
+func (a *Anonymizer) ip(ip net.IP) net.IP {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.Equal(net.IPv4bcast) {
+		return ip
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		if a.mode == AnonymizeTruncate {
+			return ip4.Mask(net.CIDRMask(24, 32))
+		}
+		return net.IP(a.sum("ip", ip4.String())[:net.IPv4len])
+	}
+
+	if a.mode == AnonymizeTruncate {
+		return ip.Mask(net.CIDRMask(48, 128))
+	}
+	return net.IP(a.sum("ip", ip.String())[:net.IPv6len])
+}
+
+// IP anonymizes an IP address or a CIDR, the prefix length of which is
+// kept. Anything else is returned as is.
+func (a *Anonymizer) IP(s string) string {
+	if s == "" || a.isProduced(s) {
+		return s
+	}
+
+	if ip, ipnet, err := net.ParseCIDR(s); err == nil {
+		ones, _ := ipnet.Mask.Size()
+		return a.produce(fmt.Sprintf("%s/%d", a.ip(ip), ones))
+	}
+
+	if ip := net.ParseIP(s); ip != nil {
+		return a.produce(a.ip(ip).String())
+	}
+
+	return s
+}
+
+// MAC anonymizes a MAC address, the hashed ones being locally administered
+// unicast addresses. The broadcast and the multicast addresses are kept.
+func (a *Anonymizer) MAC(s string) string {
+	if s == "" || a.isProduced(s) {
+		return s
+	}
+
+	mac, err := net.ParseMAC(s)
+	if err != nil || len(mac) != 6 || mac[0]&0x01 != 0 {
+		return s
+	}
+
+	if a.mode == AnonymizeTruncate {
+		return a.produce(net.HardwareAddr{mac[0], mac[1], mac[2], 0, 0, 0}.String())
+	}
+
+	hash := a.sum("mac", mac.String())[:6]
+	hash[0] = hash[0]&0xfc | 0x02
+	return a.produce(net.HardwareAddr(hash).String())
+}
+
+// Hostname anonymizes a hostname. Truncating a hostname keeps its domain,
+// or replaces it by "*" if it is not qualified.
+func (a *Anonymizer) Hostname(s string) string {
+	if s == "" || a.isProduced(s) {
+		return s
+	}
+
+	if a.mode == AnonymizeTruncate {
+		if i := strings.Index(s, "."); i != -1 && i != len(s)-1 {
+			return a.produce(s[i+1:])
+		}
+		return a.produce("*")
+	}
+
+	return a.produce("host-" + hex.EncodeToString(a.sum("host", strings.ToLower(s))[:8]))
+}
+
+// Anonymize anonymizes a value according to its kind, the values which are
+// neither an IP, a CIDR nor a MAC being considered as hostnames
+func (a *Anonymizer) Anonymize(s string) string {
+	if _, _, err := net.ParseCIDR(s); err == nil || net.ParseIP(s) != nil {
+		return a.IP(s)
+	}
+	if _, err := net.ParseMAC(s); err == nil {
+		return a.MAC(s)
+	}
+	return a.Hostname(s)
+}
+
+// NewAnonymizer returns an anonymizer using the given mode. Without salt, a
+// random one is generated and the hashes differ from one run to another.
+func NewAnonymizer(mode string, salt string) (*Anonymizer, error) {
+	switch mode {
+	case AnonymizeHash, AnonymizeTruncate:
+	default:
+		return nil, fmt.Errorf("Invalid anonymization mode %s", mode)
+	}
+
+	a := &Anonymizer{mode: mode, salt: []byte(salt), produced: make(map[string]bool)}
+	if salt == "" {
+		a.salt = make([]byte, 32)
+		if _, err := rand.Read(a.salt); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestAnonymizerHash(t *testing.T) {
+	a, err := NewAnonymizer(AnonymizeHash, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := a.IP("192.168.1.10")
+	if ip == "192.168.1.10" || net.ParseIP(ip).To4() == nil {
+		t.Errorf("Expected an hashed IPv4, got %s", ip)
+	}
+	if a.IP(ip) != ip {
+		t.Errorf("Expected an anonymized IP to be kept, got %s", a.IP(ip))
+	}
+
+	b, _ := NewAnonymizer(AnonymizeHash, "salt")
+	if other := b.IP("192.168.1.10"); other != ip {
+		t.Errorf("Expected the same salt to give the same hash, got %s and %s", ip, other)
+	}
+
+	if cidr := a.IP("192.168.1.10/24"); cidr != ip+"/24" {
+		t.Errorf("Expected %s/24, got %s", ip, cidr)
+	}
+
+	mac := a.MAC("52:54:00:12:34:56")
+	hw, err := net.ParseMAC(mac)
+	if err != nil || mac == "52:54:00:12:34:56" || hw[0]&0x03 != 0x02 {
+		t.Errorf("Expected a locally administered unicast MAC, got %s", mac)
+	}
+
+	if bcast := a.MAC("ff:ff:ff:ff:ff:ff"); bcast != "ff:ff:ff:ff:ff:ff" {
+		t.Errorf("Expected the broadcast MAC to be kept, got %s", bcast)
+	}
+
+	if host := a.Anonymize("node1.example.com"); !strings.HasPrefix(host, "host-") {
+		t.Errorf("Expected an hashed hostname, got %s", host)
+	}
+
+	if lo := a.Anonymize("127.0.0.1"); lo != "127.0.0.1" {
+		t.Errorf("Expected the loopback to be kept, got %s", lo)
+	}
+}
+
+func TestAnonymizerTruncate(t *testing.T) {
+	a, err := NewAnonymizer(AnonymizeTruncate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for value, expected := range map[string]string{
+		"192.168.1.10":      "192.168.1.0",
+		"10.0.0.1/8":        "10.0.0.0/8",
+		"2001:db8:1:2::1":   "2001:db8:1::",
+		"52:54:00:12:34:56": "52:54:00:00:00:00",
+		"node1.example.com": "example.com",
+		"node1":             "*",
+	} {
+		if anonymized := a.Anonymize(value); anonymized != expected {
+			t.Errorf("Expected %s to be truncated to %s, got %s", value, expected, anonymized)
+		}
+	}
+
+	if host := a.Hostname("example.com"); host != "example.com" {
+		t.Errorf("Expected a truncated hostname to be kept, got %s", host)
+	}
+
+	if _, err := NewAnonymizer("xor", ""); err == nil {
+		t.Error("Expected an error for an invalid mode")
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+// NativeEndian is the byte order of the host, the one of the structures
+// shared with the kernel like the eBPF maps or the AF_PACKET ring headers
+var NativeEndian binary.ByteOrder
+
+func init() {
+	v := uint16(0x0102)
+	if *(*byte)(unsafe.Pointer(&v)) == 0x01 {
+		NativeEndian = binary.BigEndian
+	} else {
+		NativeEndian = binary.LittleEndian
+	}
+}
+
+// CheckByteOrder verifies that the detected host byte order matches the
+// memory layout of the integers and that the network byte order
+// conversions are correct on this host
+func CheckByteOrder() error {
+	var v uint32
+	NativeEndian.PutUint32((*[4]byte)(unsafe.Pointer(&v))[:], 0x01020304)
+	if v != 0x01020304 {
+		return fmt.Errorf("Host byte order is not %s, read 0x%08x instead of 0x01020304", NativeEndian, v)
+	}
+
+	ethPIP := []byte{0x08, 0x00}
+	if p := binary.BigEndian.Uint16(ethPIP); p != 0x0800 {
+		return fmt.Errorf("Wrong network byte order conversion, read 0x%04x instead of 0x0800", p)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"encoding/binary"
+	"runtime"
+	"testing"
+)
+
+func TestByteOrder(t *testing.T) {
+	if err := CheckByteOrder(); err != nil {
+		t.Fatal(err)
+	}
+
+	switch runtime.GOARCH {
+	case "386", "amd64", "arm", "arm64", "ppc64le":
+		if NativeEndian != binary.LittleEndian {
+			t.Errorf("%s expected to be little endian, got %s", runtime.GOARCH, NativeEndian)
+		}
+	case "s390x", "ppc64":
+		if NativeEndian != binary.BigEndian {
+			t.Errorf("%s expected to be big endian, got %s", runtime.GOARCH, NativeEndian)
+		}
+	}
+}
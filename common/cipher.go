@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// encryptedMagic prefixes the data encrypted by a DataCipher
+var encryptedMagic = []byte("SKE1")
+
+var (
+	// ErrUnknownKey is returned when decrypting data encrypted with a key
+	// not known by the cipher
+	ErrUnknownKey = errors.New("Data encrypted with an unknown key")
+	// ErrNoKey is returned when decrypting data without any key
+	ErrNoKey = errors.New("Data encrypted while no key is configured")
+)
+
+// DataCipher encrypts data at rest with AES-GCM. The encrypted data holds
+// the ID of its key so that the keys can be rotated, the new data being
+// encrypted with the current key while the previous keys decrypt the data
+// they encrypted. A nil cipher leaves the data in the clear.
+type DataCipher struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// IsEncrypted returns whether the data has been encrypted by a DataCipher
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// Encrypt returns the data encrypted with the current key, made of the
+// magic, the length and the ID of the key, the nonce and the sealed data
+func (c *DataCipher) Encrypt(data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+
+	aead := c.aeads[c.current]
+
+	header := make([]byte, 0, len(encryptedMagic)+1+len(c.current)+aead.NonceSize())
+	header = append(header, encryptedMagic...)
+	header = append(header, byte(len(c.current)))
+	header = append(header, c.current...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	// the header is authenticated so that the key ID can't be altered
+	return aead.Seal(append(header, nonce...), nonce, data, header), nil
+}
+
+// Decrypt returns the plain data, the data not encrypted being returned as
+// they are, like the ones stored before enabling the encryption
+func (c *DataCipher) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, ErrNoKey
+	}
+
+	offset := len(encryptedMagic)
+	if len(data) <= offset {
+		return nil, errors.New("Truncated encrypted data")
+	}
+
+	idLen := int(data[offset])
+	if len(data) < offset+1+idLen {
+		return nil, errors.New("Truncated encrypted data")
+	}
+	id := string(data[offset+1 : offset+1+idLen])
+
+	aead, ok := c.aeads[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	header := data[:offset+1+idLen]
+	rest := data[len(header):]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("Truncated encrypted data")
+	}
+
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+}
+
+// NewDataCipher returns a cipher encrypting with the current key, the keys
+// being AES-128, AES-192 or AES-256 keys indexed by their ID
+func NewDataCipher(current string, keys map[string][]byte) (*DataCipher, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("Current key '%s' not defined", current)
+	}
+
+	c := &DataCipher{current: current, aeads: make(map[string]cipher.AEAD)}
+	for id, key := range keys {
+		if len(id) > 255 {
+			return nil, fmt.Errorf("Key ID '%s' longer than 255 bytes", id)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key '%s': %s", id, err)
+		}
+
+		if c.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
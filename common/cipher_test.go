@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDataCipher(t *testing.T) {
+	previous, err := NewDataCipher("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("first packet payload")
+	encrypted, err := previous.Encrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || bytes.Contains(encrypted, data) {
+		t.Fatalf("Expected encrypted data, got %v", encrypted)
+	}
+
+	// rotated key, the previous one still decrypting its data
+	rotated, err := NewDataCipher("k2", map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 16),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted, err := rotated.Decrypt(encrypted); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the data decrypted with the previous key, got %s, %v", decrypted, err)
+	}
+
+	reencrypted, err := rotated.Encrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := previous.Decrypt(reencrypted); err != ErrUnknownKey {
+		t.Errorf("Expected an unknown key error, got %v", err)
+	}
+
+	// tampered data
+	encrypted[len(encrypted)-1] ^= 0xff
+	if _, err := rotated.Decrypt(encrypted); err == nil {
+		t.Error("Expected an authentication error on tampered data")
+	}
+
+	// plain data stored before the encryption
+	if decrypted, err := rotated.Decrypt(data); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the plain data as is, got %s, %v", decrypted, err)
+	}
+
+	var none *DataCipher
+	if plain, err := none.Encrypt(data); err != nil || !bytes.Equal(plain, data) {
+		t.Errorf("Expected the data left in the clear without cipher, got %s, %v", plain, err)
+	}
+	if _, err := none.Decrypt(reencrypted); err != ErrNoKey {
+		t.Errorf("Expected a no key error, got %v", err)
+	}
+
+	if _, err := NewDataCipher("k3", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}); err == nil {
+		t.Error("Expected an error for an undefined current key")
+	}
+	if _, err := NewDataCipher("k1", map[string][]byte{"k1": []byte("short")}); err == nil {
+		t.Error("Expected an error for an invalid key size")
+	}
+}
@@ -25,6 +25,7 @@ package common
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 )
 
@@ -49,6 +50,53 @@ const (
 	StoppingState
 )
 
+// IP families the service addresses can be restricted to
+const (
+	// AnyIPFamily resolves to the first address found, wildcard IPv6
+	// listening addresses accept both IPv4 and IPv6 connections
+	AnyIPFamily = "any"
+	// IPv4Family only uses IPv4 addresses
+	IPv4Family = "ipv4"
+	// IPv6Family only uses IPv6 addresses, listeners are IPv6 only
+	IPv6Family = "ipv6"
+)
+
+// ServiceIPFamily is the IP family used to resolve the service addresses and
+// to listen, set from the configuration
+var ServiceIPFamily = AnyIPFamily
+
+// IPNetwork returns the network to use for "tcp" or "udp" according to the
+// service IP family
+func IPNetwork(network string) string {
+	switch ServiceIPFamily {
+	case IPv4Family:
+		return network + "4"
+	case IPv6Family:
+		return network + "6"
+	}
+	return network
+}
+
+// JoinHostPort combines a host, possibly an IPv6 address already enclosed in
+// brackets, and a port into an address usable by the net package
+func JoinHostPort(host string, port int) string {
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+}
+
+func filterIPFamily(ips []net.IP, family string) []net.IP {
+	if family != IPv4Family && family != IPv6Family {
+		return ips
+	}
+
+	var filtered []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (family == IPv4Family) {
+			filtered = append(filtered, ip)
+		}
+	}
+	return filtered
+}
+
 // ServiceAddress describes the service listening address and port
 type ServiceAddress struct {
 	Addr string
@@ -74,6 +122,9 @@ func ServiceAddressFromString(addressPort string) (ServiceAddress, error) {
 
 	host, port, err := net.SplitHostPort(addressPort)
 	if err != nil {
+		if strings.Count(addressPort, ":") > 1 && !strings.HasPrefix(addressPort, "[") {
+			return ServiceAddress{}, fmt.Errorf("Invalid address %s, IPv6 addresses must be enclosed in brackets, e.g. [::1]:8082", addressPort)
+		}
 		return ServiceAddress{}, err
 	}
 
@@ -86,7 +137,7 @@ func ServiceAddressFromString(addressPort string) (ServiceAddress, error) {
 	if err != nil {
 		return ServiceAddress{}, err
 	}
-	if len(ips) == 0 {
+	if ips = filterIPFamily(ips, ServiceIPFamily); len(ips) == 0 {
 		return ServiceAddress{}, fmt.Errorf("no address found for %s", host)
 	}
 
@@ -58,3 +58,32 @@ func TestServiceAddress(t *testing.T) {
 		t.Errorf("IP expected not found, got: %s", sa)
 	}
 }
+
+func TestServiceAddressIPv6(t *testing.T) {
+	sa, err := ServiceAddressFromString("[::1]:8082")
+	if err != nil {
+		t.Fatalf("should not return an error: %s", err)
+	}
+	if sa.Addr != "[::1]" || sa.Port != 8082 {
+		t.Errorf("expected [::1]:8082, got: %s", sa)
+	}
+
+	if addr := JoinHostPort(sa.Addr, sa.Port); addr != "[::1]:8082" {
+		t.Errorf("expected [::1]:8082, got: %s", addr)
+	}
+
+	if _, err := ServiceAddressFromString("::1:8082"); err == nil {
+		t.Error("unbracketed IPv6 address should return an error")
+	}
+
+	ServiceIPFamily = IPv4Family
+	defer func() { ServiceIPFamily = AnyIPFamily }()
+
+	if _, err := ServiceAddressFromString("[::1]:8082"); err == nil {
+		t.Error("IPv6 address should not be resolved with the ipv4 family")
+	}
+
+	if network := IPNetwork("tcp"); network != "tcp4" {
+		t.Errorf("expected tcp4, got: %s", network)
+	}
+}
@@ -75,3 +75,139 @@ func SetupTLSServerConfig(certPEM string, keyPEM string) (*tls.Config, error) {
 
 	return cfgTLS, nil
 }
+
+// TLSProfile constrains the TLS versions, cipher suites and curves used by
+// the listeners and the clients
+type TLSProfile struct {
+	Name             string
+	MinVersion       uint16
+	CipherSuites     []uint16
+	CurvePreferences []tls.CurveID
+}
+
+// TLS versions by name
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// TLS cipher suites by name
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":     tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+}
+
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+}
+
+// TLSProfiles available, "intermediate" keeps the historical server settings.
+// The "fips" profile only allows the FIPS 140-2 approved AES-GCM suites and
+// NIST curves.
+var TLSProfiles = map[string]TLSProfile{
+	"intermediate": {
+		Name:       "intermediate",
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP521, tls.CurveP384, tls.CurveP256},
+	},
+	"modern": {
+		Name:       "modern",
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	},
+	"fips": {
+		Name:             "fips",
+		MinVersion:       tls.VersionTLS12,
+		CipherSuites:     fipsCipherSuites,
+		CurvePreferences: []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
+	},
+}
+
+// NewTLSProfile returns the named profile, its minimal version and cipher
+// suites can be overridden as long as they stay within the profile bounds
+// for the "fips" profile
+func NewTLSProfile(name string, minVersion string, cipherSuites []string) (*TLSProfile, error) {
+	base, ok := TLSProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown TLS profile '%s'", name)
+	}
+	profile := base
+
+	if minVersion != "" {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("Unknown TLS version '%s'", minVersion)
+		}
+		if profile.Name == "fips" && version < tls.VersionTLS12 {
+			return nil, fmt.Errorf("TLS version '%s' not allowed by the fips profile", minVersion)
+		}
+		profile.MinVersion = version
+	}
+
+	if len(cipherSuites) > 0 {
+		profile.CipherSuites = nil
+		for _, name := range cipherSuites {
+			id, ok := tlsCipherSuites[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown TLS cipher suite '%s'", name)
+			}
+			if profile.Name == "fips" && !containsCipherSuite(fipsCipherSuites, id) {
+				return nil, fmt.Errorf("TLS cipher suite '%s' not allowed by the fips profile", name)
+			}
+			profile.CipherSuites = append(profile.CipherSuites, id)
+		}
+	}
+
+	return &profile, nil
+}
+
+func containsCipherSuite(suites []uint16, id uint16) bool {
+	for _, suite := range suites {
+		if suite == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply the profile to a TLS configuration
+func (p *TLSProfile) Apply(cfg *tls.Config) {
+	cfg.MinVersion = p.MinVersion
+	cfg.CipherSuites = p.CipherSuites
+	cfg.CurvePreferences = p.CurvePreferences
+}
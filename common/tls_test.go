@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package common
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestTLSProfile(t *testing.T) {
+	profile, err := NewTLSProfile("fips", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &tls.Config{}
+	profile.Apply(cfg)
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.CipherSuites) != len(fipsCipherSuites) {
+		t.Fatalf("Wrong fips TLS configuration: %+v", cfg)
+	}
+
+	if _, err := NewTLSProfile("fips", "1.0", nil); err == nil {
+		t.Fatal("TLS 1.0 should not be allowed by the fips profile")
+	}
+
+	if _, err := NewTLSProfile("fips", "", []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305"}); err == nil {
+		t.Fatal("ChaCha20 should not be allowed by the fips profile")
+	}
+
+	if _, err := NewTLSProfile("unknown", "", nil); err == nil {
+		t.Fatal("Unknown profile should be refused")
+	}
+
+	profile, err = NewTLSProfile("intermediate", "1.1", []string{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.MinVersion != tls.VersionTLS11 || len(profile.CipherSuites) != 1 {
+		t.Fatalf("Overrides not applied: %+v", profile)
+	}
+
+	if TLSProfiles["intermediate"].MinVersion != tls.VersionTLS12 {
+		t.Fatal("Overrides should not modify the profiles")
+	}
+}
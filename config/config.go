@@ -23,8 +23,10 @@
 package config
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net/url"
 	"os"
@@ -61,28 +63,156 @@ func init() {
 
 	cfg = viper.New()
 
+	cfg.SetDefault("agent.capture.dhcp.ttl", 86400)
 	cfg.SetDefault("agent.capture.stats_update", 1)
+	cfg.SetDefault("agent.edge.enabled", false)
+	cfg.SetDefault("agent.edge.flow_batch", 500)
+	cfg.SetDefault("agent.edge.flow_buffer", 100000)
+	cfg.SetDefault("agent.edge.topology_buffer", 100000)
 	cfg.SetDefault("agent.flow.probes", []string{"gopacket", "pcapsocket"})
 	cfg.SetDefault("agent.flow.pcapsocket.bind_address", "127.0.0.1")
-	cfg.SetDefault("agent.flow.pcapsocket.min_port", 8100)
+	cfg.SetDefault("agent.flow.pcapsocket.handshake_timeout", 5)
+	cfg.SetDefault("agent.flow.pcapsocket.max_clients", 16)
 	cfg.SetDefault("agent.flow.pcapsocket.max_port", 8132)
+	cfg.SetDefault("agent.flow.pcapsocket.min_port", 8100)
+	cfg.SetDefault("agent.flow.pcapsocket.tokens", map[string]string{})
+	cfg.SetDefault("agent.hardening.capabilities", []string{})
+	cfg.SetDefault("agent.hardening.enabled", false)
+	cfg.SetDefault("agent.hardening.seccomp", true)
 	cfg.SetDefault("agent.listen", "127.0.0.1:8081")
+	cfg.SetDefault("agent.registration.key", "")
+	cfg.SetDefault("agent.topology.acks.enabled", false)
+	cfg.SetDefault("agent.topology.acks.window", 10000)
 	cfg.SetDefault("agent.topology.probes", []string{"ovsdb"})
+	cfg.SetDefault("agent.topology.multicast.interval", 10)
+	cfg.SetDefault("agent.topology.netlink.fdb_update", 5)
 	cfg.SetDefault("agent.topology.netlink.metrics_update", 30)
 	cfg.SetDefault("agent.topology.neutron.domain_name", "Default")
 	cfg.SetDefault("agent.topology.neutron.endpoint_type", "public")
 	cfg.SetDefault("agent.topology.neutron.region_name", "RegionOne")
 	cfg.SetDefault("agent.topology.neutron.tenant_name", "service")
 	cfg.SetDefault("agent.topology.neutron.username", "neutron")
+	cfg.SetDefault("agent.topology.record_trace", "")
+	cfg.SetDefault("agent.topology.shadow_probes", []string{})
 	cfg.SetDefault("agent.topology.socketinfo.host_update", 10)
+	cfg.SetDefault("agent.unix_socket.path", "")
+	cfg.SetDefault("agent.unix_socket.users", map[string]string{"0": "admin"})
 	cfg.SetDefault("agent.X509_servername", "")
 
+	cfg.SetDefault("analyzer.accounting.keys", []string{"K8s.Namespace", "Neutron.TenantID"})
+	cfg.SetDefault("analyzer.accounting.mappings", map[string]string{})
+	cfg.SetDefault("analyzer.alert.shards", 8)
+	cfg.SetDefault("analyzer.anonymization.enabled", false)
+	cfg.SetDefault("analyzer.anonymization.flows", true)
+	cfg.SetDefault("analyzer.anonymization.metadata", []string{"IPV4", "IPV6", "MAC", "PeerIntfMAC", "Hostname", "DHCP.ClientMAC", "DHCP.Hostname", "DHCP.OfferedIP", "DHCP.RequestedIP", "DHCP.Server"})
+	cfg.SetDefault("analyzer.anonymization.mode", "hash")
+	cfg.SetDefault("analyzer.anonymization.salt", "")
+	cfg.SetDefault("analyzer.capture.host_exclusions.interfaces", []string{"lo"})
+	cfg.SetDefault("analyzer.capture.host_exclusions.vlans", []string{})
+	cfg.SetDefault("analyzer.clock_skew.enabled", true)
+	cfg.SetDefault("analyzer.clock_skew.threshold", 50)
+	cfg.SetDefault("analyzer.edge.resume_delay", 0)
+	cfg.SetDefault("analyzer.events.ttl", 86400)
 	cfg.SetDefault("analyzer.flow.backend", "memory")
 	cfg.SetDefault("analyzer.flow.max_buffer_size", 100000)
+	cfg.SetDefault("analyzer.flow.metrics_alignment", false)
+	cfg.SetDefault("analyzer.flow_chain.enabled", true)
+	cfg.SetDefault("analyzer.flow_chain.window", 5)
+	cfg.SetDefault("analyzer.flow_retention.default", "")
+	cfg.SetDefault("analyzer.flow_retention.enabled", false)
+	cfg.SetDefault("analyzer.flow_retention.interval", 3600)
+	cfg.SetDefault("analyzer.flow_matrix.enabled", false)
+	cfg.SetDefault("analyzer.flow_matrix.group_by", "host")
+	cfg.SetDefault("analyzer.flow_matrix.interval", 5)
+	cfg.SetDefault("analyzer.flow_matrix.window", 60)
 	cfg.SetDefault("analyzer.listen", "127.0.0.1:8082")
+	cfg.SetDefault("analyzer.loop.action", "")
+	cfg.SetDefault("analyzer.loop.bpdu_threshold", 10)
+	cfg.SetDefault("analyzer.loop.broadcast_threshold", 1000)
+	cfg.SetDefault("analyzer.loop.enabled", false)
+	cfg.SetDefault("analyzer.loop.interval", 30)
+	cfg.SetDefault("analyzer.loop.max_hops", 10)
+	cfg.SetDefault("analyzer.loop.window", 60)
+	cfg.SetDefault("analyzer.mtu.enabled", false)
+	cfg.SetDefault("analyzer.mtu.interval", 60)
+	cfg.SetDefault("analyzer.mtu.max_hops", 10)
+	cfg.SetDefault("analyzer.mtu.window", 600)
+	cfg.SetDefault("analyzer.probe_degradation_alert.action", "")
+	cfg.SetDefault("analyzer.probe_degradation_alert.enabled", false)
+	cfg.SetDefault("analyzer.registration.bootstrap_tokens", []string{})
+	cfg.SetDefault("analyzer.registration.enabled", false)
+	cfg.SetDefault("analyzer.registration.keys", map[string]string{})
+	cfg.SetDefault("analyzer.registration.max_skew", 300)
+	cfg.SetDefault("analyzer.remote_capture.known_hosts", "/etc/skydive/ssh/known_hosts")
+	cfg.SetDefault("analyzer.remote_capture.ssh_key", "/etc/skydive/ssh/id_rsa")
+	cfg.SetDefault("analyzer.remote_capture.ssh_key_dir", "/etc/skydive/ssh")
+	cfg.SetDefault("analyzer.remote_capture.tcpdump", "tcpdump")
+	cfg.SetDefault("analyzer.remote_capture.timeout", 10)
 	cfg.SetDefault("analyzer.replication.debug", false)
+	cfg.SetDefault("analyzer.report.count", 4)
+	cfg.SetDefault("analyzer.report.directory", "/var/lib/skydive/reports")
+	cfg.SetDefault("analyzer.report.enabled", false)
+	cfg.SetDefault("analyzer.report.format", "csv")
+	cfg.SetDefault("analyzer.report.group_by", []string{"Application"})
+	cfg.SetDefault("analyzer.report.interval", 86400)
+	cfg.SetDefault("analyzer.report.period", "week")
+	cfg.SetDefault("analyzer.report.smtp.address", "localhost:25")
+	cfg.SetDefault("analyzer.report.smtp.from", "skydive@localhost")
+	cfg.SetDefault("analyzer.report.webhook_timeout", 30)
+	cfg.SetDefault("analyzer.sla.history", 100)
+	cfg.SetDefault("analyzer.sla.interval", 60)
+	cfg.SetDefault("analyzer.sla.window", 300)
+	cfg.SetDefault("analyzer.threat_intel.action", "")
+	cfg.SetDefault("analyzer.threat_intel.enabled", false)
+	cfg.SetDefault("analyzer.threat_intel.interval", 5)
+	cfg.SetDefault("analyzer.threat_intel.refresh", 3600)
+	cfg.SetDefault("analyzer.threat_intel.resolve_timeout", 5)
+	cfg.SetDefault("analyzer.threat_intel.resolve_workers", 8)
+	cfg.SetDefault("analyzer.threat_intel.timeout", 30)
+	cfg.SetDefault("analyzer.threat_intel.ttl", 3600)
+	cfg.SetDefault("analyzer.topology.ack_every", 100)
+	cfg.SetDefault("analyzer.traffic.enabled", false)
+	cfg.SetDefault("analyzer.traffic.interval", 60)
+	cfg.SetDefault("analyzer.traffic.max_hops", 10)
+	cfg.SetDefault("analyzer.traffic.window", 3600)
 	cfg.SetDefault("analyzer.topology.backend", "memory")
+	cfg.SetDefault("analyzer.topology.fingerprint.asns", []string{})
+	cfg.SetDefault("analyzer.topology.fingerprint.interval", 30)
+	cfg.SetDefault("analyzer.topology.fingerprint.ipv4_prefix", 24)
+	cfg.SetDefault("analyzer.topology.fingerprint.ipv6_prefix", 64)
+	cfg.SetDefault("analyzer.topology.fingerprint.max_hosts", 1000)
+	cfg.SetDefault("analyzer.topology.fingerprint.ttl", 3600)
+	cfg.SetDefault("analyzer.topology.ipconflict.interval", 10)
+	cfg.SetDefault("analyzer.topology.journal.path", "")
+	cfg.SetDefault("analyzer.topology.macflap.max_hops", 10)
+	cfg.SetDefault("analyzer.topology.macflap.moves", 3)
+	cfg.SetDefault("analyzer.topology.macflap.window", 60)
+	cfg.SetDefault("analyzer.topology.nova.domain_name", "Default")
+	cfg.SetDefault("analyzer.topology.nova.endpoint_type", "public")
+	cfg.SetDefault("analyzer.topology.nova.interval", 60)
+	cfg.SetDefault("analyzer.topology.nova.ironic", false)
+	cfg.SetDefault("analyzer.topology.nova.region_name", "RegionOne")
+	cfg.SetDefault("analyzer.topology.nova.tenant_name", "admin")
+	cfg.SetDefault("analyzer.topology.nova.username", "admin")
 	cfg.SetDefault("analyzer.topology.probes", []string{})
+	cfg.SetDefault("analyzer.topology.query_cache.enabled", false)
+	cfg.SetDefault("analyzer.topology.query_cache.size", 100)
+	cfg.SetDefault("analyzer.topology.query_cache.ttl", 10)
+	cfg.SetDefault("analyzer.topology.scrub_metadata", []string{})
+	cfg.SetDefault("analyzer.topology.stack.heat.domain_name", "Default")
+	cfg.SetDefault("analyzer.topology.stack.heat.enabled", false)
+	cfg.SetDefault("analyzer.topology.stack.heat.endpoint_type", "public")
+	cfg.SetDefault("analyzer.topology.stack.heat.region_name", "RegionOne")
+	cfg.SetDefault("analyzer.topology.stack.heat.tenant_name", "admin")
+	cfg.SetDefault("analyzer.topology.stack.heat.username", "admin")
+	cfg.SetDefault("analyzer.topology.stack.id_fields", []string{})
+	cfg.SetDefault("analyzer.topology.stack.interval", 60)
+	cfg.SetDefault("analyzer.topology.stack.terraform.states", map[string]string{})
+	cfg.SetDefault("analyzer.unix_socket.path", "")
+	cfg.SetDefault("analyzer.unix_socket.users", map[string]string{"0": "admin"})
+	cfg.SetDefault("analyzer.ws.max_subscriptions", 0)
+	cfg.SetDefault("analyzer.ws.rate_limit", 0)
+	cfg.SetDefault("analyzer.ws.rate_limit_burst", 0)
 
 	cfg.SetDefault("auth.keystone.tenant_name", "admin")
 	cfg.SetDefault("auth.keystone.domain_name", "Default")
@@ -91,6 +221,18 @@ func init() {
 	cfg.SetDefault("cache.expire", 300)
 	cfg.SetDefault("cache.cleanup", 30)
 
+	cfg.SetDefault("calico.etcd_servers", []string{"http://127.0.0.1:2379"})
+	cfg.SetDefault("calico.node", "")
+
+	cfg.SetDefault("cilium.poll_interval", 10)
+	cfg.SetDefault("cilium.socket", "/var/run/cilium/cilium.sock")
+
+	cfg.SetDefault("dns.cluster_domain", "cluster.local")
+	cfg.SetDefault("dns.interval", 60)
+	cfg.SetDefault("dns.names", []string{})
+	cfg.SetDefault("dns.server", "")
+	cfg.SetDefault("dns.timeout", 5)
+
 	cfg.SetDefault("docker.url", "unix:///var/run/docker.sock")
 	cfg.SetDefault("docker.netns.run_path", "/var/run/docker/netns")
 
@@ -99,21 +241,35 @@ func init() {
 	cfg.SetDefault("etcd.name", host)
 	cfg.SetDefault("etcd.listen", "127.0.0.1:12379")
 
+	cfg.SetDefault("flow.direction", "first-packet")
 	cfg.SetDefault("flow.expire", 600)
 	cfg.SetDefault("flow.update", 60)
 	cfg.SetDefault("flow.protocol", "udp")
 
 	cfg.SetDefault("host_id", host)
 
+	cfg.SetDefault("ip_family", "any")
+
 	cfg.SetDefault("http.rest.debug", false)
+	cfg.SetDefault("http.compression.enabled", true)
+	cfg.SetDefault("http.compression.level", -1)
+	cfg.SetDefault("http.http2.enabled", true)
+	cfg.SetDefault("http.pprof.enabled", false)
+	cfg.SetDefault("http.proxy.ssh_host_key", "")
+	cfg.SetDefault("http.proxy.ssh_key", "/etc/skydive/ssh/id_rsa")
+	cfg.SetDefault("http.proxy.url", "")
 	cfg.SetDefault("http.ws.ping_delay", 2)
 	cfg.SetDefault("http.ws.pong_timeout", 5)
 	cfg.SetDefault("http.ws.bulk_maxmsgs", 100)
 	cfg.SetDefault("http.ws.bulk_maxdelay", 1)
 	cfg.SetDefault("http.ws.queue_size", 10000)
 	cfg.SetDefault("http.ws.enable_write_compression", true)
+	cfg.SetDefault("http.ws.backoff_min", 1)
+	cfg.SetDefault("http.ws.backoff_max", 30)
+	cfg.SetDefault("http.ws.session_resume_delay", 30)
 
 	cfg.SetDefault("k8s.config_file", "/etc/skydive/kubeconfig")
+	cfg.SetDefault("k8s.custom_resources", []interface{}{})
 
 	cfg.SetDefault("logging.backends", []string{"stderr"})
 	cfg.SetDefault("logging.color", true)
@@ -126,10 +282,22 @@ func init() {
 
 	cfg.SetDefault("opencontrail.mpls_udp_port", 51234)
 
+	cfg.SetDefault("ovs.fdb.enable", false)
+	cfg.SetDefault("ovs.fdb.interval", 5)
 	cfg.SetDefault("ovs.ovsdb", "unix:///var/run/openvswitch/db.sock")
 	cfg.SetDefault("ovs.oflow.enable", false)
 	cfg.SetDefault("ovs.oflow.openflow_versions", []string{"OpenFlow10"})
 
+	cfg.SetDefault("seed.flows.count", 100)
+	cfg.SetDefault("seed.flows.lifetime", 60)
+	cfg.SetDefault("seed.flows.update", 10)
+	cfg.SetDefault("seed.host_id", "synthetic-seed")
+	cfg.SetDefault("seed.random_seed", 0)
+	cfg.SetDefault("seed.topology.hosts", 10)
+	cfg.SetDefault("seed.topology.interfaces", 2)
+	cfg.SetDefault("seed.topology.k8s", true)
+	cfg.SetDefault("seed.topology.pods", 10)
+
 	cfg.SetDefault("sflow.port_min", 6345)
 	cfg.SetDefault("sflow.port_max", 6355)
 
@@ -139,21 +307,48 @@ func init() {
 	cfg.SetDefault("rbac.model.policy_effect", []string{"some(where (p_eft == allow)) && !some(where (p_eft == deny))"})
 	cfg.SetDefault("rbac.model.matchers", []string{"g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act"})
 
+	cfg.SetDefault("storage.bolt.driver", "bolt")
+	cfg.SetDefault("storage.bolt.path", "/var/lib/skydive/topology.db")
 	cfg.SetDefault("storage.elasticsearch.driver", "elasticsearch")
 	cfg.SetDefault("storage.elasticsearch.host", "127.0.0.1:9200")
 	cfg.SetDefault("storage.elasticsearch.maxconns", 10)
 	cfg.SetDefault("storage.elasticsearch.retry", 60)
 	cfg.SetDefault("storage.elasticsearch.bulk_maxdocs", 100)
 	cfg.SetDefault("storage.elasticsearch.bulk_maxdelay", 5)
+	cfg.SetDefault("storage.elasticsearch.bulk_retry.max_attempts", 10)
+	cfg.SetDefault("storage.elasticsearch.bulk_retry.max_backoff", 300)
+	cfg.SetDefault("storage.elasticsearch.bulk_retry.path", "")
+	cfg.SetDefault("storage.elasticsearch.bulk_retry.queue_size", 100000)
+	cfg.SetDefault("storage.elasticsearch.history.purge_action", "delete")
+	cfg.SetDefault("storage.elasticsearch.history.purge_interval", 3600)
+	cfg.SetDefault("storage.elasticsearch.history.retention", 0)
+	cfg.SetDefault("storage.elasticsearch.history.snapshot_path", "")
 	cfg.SetDefault("storage.elasticsearch.index_age_limit", 0)
 	cfg.SetDefault("storage.elasticsearch.index_entries_limit", 0)
+	cfg.SetDefault("storage.elasticsearch.index_retention", 0)
+	cfg.SetDefault("storage.elasticsearch.index_rollover", "")
 	cfg.SetDefault("storage.elasticsearch.indices_to_keep", 0)
+	cfg.SetDefault("storage.elasticsearch.upgrade", "reindex")
 	cfg.SetDefault("storage.memory.driver", "memory")
+	cfg.SetDefault("storage.neo4j.driver", "neo4j")
+	cfg.SetDefault("storage.neo4j.addr", "http://localhost:7474")
+	cfg.SetDefault("storage.neo4j.username", "neo4j")
+	cfg.SetDefault("storage.neo4j.password", "")
 	cfg.SetDefault("storage.orientdb.driver", "orientdb")
 	cfg.SetDefault("storage.orientdb.addr", "http://localhost:2480")
 	cfg.SetDefault("storage.orientdb.database", "Skydive")
 	cfg.SetDefault("storage.orientdb.username", "root")
 	cfg.SetDefault("storage.orientdb.password", "root")
+	cfg.SetDefault("storage.redis.driver", "redis")
+	cfg.SetDefault("storage.redis.addr", "localhost:6379")
+	cfg.SetDefault("storage.redis.password", "")
+	cfg.SetDefault("storage.redis.db", 0)
+	cfg.SetDefault("storage.redis.prefix", "skydive:")
+	cfg.SetDefault("storage.redis.history", "")
+
+	cfg.SetDefault("tls.cipher_suites", []string{})
+	cfg.SetDefault("tls.min_version", "")
+	cfg.SetDefault("tls.profile", "intermediate")
 
 	cfg.SetDefault("ui", map[string]interface{}{})
 
@@ -205,6 +400,17 @@ func checkConfig() error {
 		return err
 	}
 
+	switch family := GetString("ip_family"); family {
+	case common.AnyIPFamily, common.IPv4Family, common.IPv6Family:
+		common.ServiceIPFamily = family
+	default:
+		return fmt.Errorf("invalid value for ip_family (%s), should be any, ipv4 or ipv6", family)
+	}
+
+	if _, err := GetTLSProfile(); err != nil {
+		return fmt.Errorf("invalid TLS configuration: %s", err)
+	}
+
 	return nil
 }
 
@@ -263,6 +469,37 @@ func GetConfig() *viper.Viper {
 	return cfg
 }
 
+// GetSettings returns the configuration as a tree of settings where each
+// leaf is a configuration key, the excluded keys being left out
+func GetSettings(excludedKeys ...string) map[string]interface{} {
+	excluded := make(map[string]bool)
+	for _, key := range excludedKeys {
+		excluded[key] = true
+	}
+
+	settings := make(map[string]interface{})
+	for _, key := range cfg.AllKeys() {
+		if excluded[key] {
+			continue
+		}
+
+		path := strings.Split(key, ".")
+
+		m := settings
+		for _, k := range path[:len(path)-1] {
+			sub, ok := m[k].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				m[k] = sub
+			}
+			m = sub
+		}
+		m[path[len(path)-1]] = cfg.Get(key)
+	}
+
+	return settings
+}
+
 // SetDefault set default configuration key the value
 func SetDefault(key string, value interface{}) {
 	cfg.SetDefault(key, value)
@@ -309,11 +546,61 @@ func GetEtcdServerAddrs() []string {
 		return etcdServers
 	}
 	if address, err := GetOneAnalyzerServiceAddress(); err == nil {
-		return []string{"http://" + address.Addr + ":12379"}
+		return []string{"http://" + common.JoinHostPort(address.Addr, 12379)}
 	}
 	return []string{"http://localhost:12379"}
 }
 
+// GetTLSProfile returns the TLS profile applied to all the listeners and clients
+func GetTLSProfile() (*common.TLSProfile, error) {
+	return common.NewTLSProfile(GetString("tls.profile"), GetString("tls.min_version"), GetStringSlice("tls.cipher_suites"))
+}
+
+// loadKey returns a key of the configuration, either read from an
+// environment variable with env:NAME, from a file with file:/path, like the
+// secrets mounted by a secret manager, or given inline, all of them base64
+// encoded
+func loadKey(source string) ([]byte, error) {
+	value := source
+	switch {
+	case strings.HasPrefix(source, "env:"):
+		value = os.Getenv(strings.TrimPrefix(source, "env:"))
+		if value == "" {
+			return nil, fmt.Errorf("Environment variable %s not set", strings.TrimPrefix(source, "env:"))
+		}
+	case strings.HasPrefix(source, "file:"):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(source, "file:"))
+		if err != nil {
+			return nil, err
+		}
+		value = string(data)
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+}
+
+// GetDataCipher returns the cipher of the data encrypted at rest described
+// at path, nil if no key is set. The keys are indexed by their ID, the key
+// entry being the ID of the current key.
+func GetDataCipher(path string) (*common.DataCipher, error) {
+	current := GetString(path + ".key")
+	if current == "" {
+		return nil, nil
+	}
+
+	keys := make(map[string][]byte)
+	for id, source := range GetStringMapString(path + ".keys") {
+		key, err := loadKey(source)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key %s of %s: %s", id, path, err)
+		}
+		keys[id] = key
+	}
+
+	// the IDs of the keys map are lowercased by viper
+	return common.NewDataCipher(strings.ToLower(current), keys)
+}
+
 // IsTLSenabled returns true is the analyzer certificates are set
 func IsTLSenabled() bool {
 	certPEM := GetString("analyzer.X509_cert")
@@ -383,7 +670,7 @@ func BindPFlag(key string, flag *pflag.Flag) error {
 // GetURL constructs a URL from a tuple of protocol, address, port and path
 // If TLS is enabled, it will return the https (or wss) version of the URL.
 func GetURL(protocol string, addr string, port int, path string) *url.URL {
-	u, _ := url.Parse(fmt.Sprintf("%s://%s:%d%s", protocol, addr, port, path))
+	u, _ := url.Parse(fmt.Sprintf("%s://%s%s", protocol, common.JoinHostPort(addr, port), path))
 
 	if (protocol == "http" || protocol == "ws") && IsTLSenabled() == true {
 		u.Scheme += "s"
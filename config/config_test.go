@@ -87,3 +87,20 @@ openstack:
 		t.Fatal("Relocation with default failed")
 	}
 }
+
+func TestGetSettings(t *testing.T) {
+	settings := GetSettings("host_id")
+
+	if _, ok := settings["host_id"]; ok {
+		t.Fatal("host_id should have been excluded")
+	}
+
+	flow, ok := settings["flow"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("flow section expected, got: %v", settings)
+	}
+
+	if flow["expire"] != cfg.Get("flow.expire") {
+		t.Fatalf("flow.expire doesn't match the configuration, got: %v", flow["expire"])
+	}
+}
@@ -15,6 +15,40 @@ We truncate at 63 chars because some Kubernetes name fields are limited to this
 {{- printf "%s-%s" .Release.Name $name | trunc 63 | trimSuffix "-" -}}
 {{- end -}}
 
+{{/*
+API versions of the deployments and of the horizontal pod autoscalers, the
+most recent one supported by the cluster.
+*/}}
+{{- define "deployment.apiVersion" -}}
+{{- if .Capabilities.APIVersions.Has "apps/v1" -}}
+apps/v1
+{{- else -}}
+extensions/v1beta1
+{{- end -}}
+{{- end -}}
+
+{{- define "hpa.apiVersion" -}}
+{{- if .Capabilities.APIVersions.Has "autoscaling/v2" -}}
+autoscaling/v2
+{{- else if .Capabilities.APIVersions.Has "autoscaling/v2beta2" -}}
+autoscaling/v2beta2
+{{- else -}}
+autoscaling/v2beta1
+{{- end -}}
+{{- end -}}
+
+{{/*
+Several analyzers share their state through an external etcd cluster and an
+external Elasticsearch, the embedded ones being local to each analyzer pod.
+*/}}
+{{- define "analyzer.checkReplicas" -}}
+{{- if or (gt (int .Values.analyzer.replicas) 1) .Values.analyzer.autoscaling.enabled -}}
+{{- if or (not .Values.etcd.servers) .Values.storage.elasticsearch.embedded -}}
+{{- fail "several analyzer replicas require an external etcd cluster, etcd.servers, and an external Elasticsearch, storage.elasticsearch.embedded set to false" -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+
 {{/*
 Helper functions which can be used for used for .Values.arch in PPA Charts
 Check if tag contains specific platform suffix and if not set based on kube platform
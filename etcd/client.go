@@ -24,19 +24,25 @@ package etcd
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	etcd "github.com/coreos/etcd/client"
 
+	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 )
 
 // Client describes a ETCD configuration client
 type Client struct {
-	client  *etcd.Client
-	KeysAPI etcd.KeysAPI
+	client    *etcd.Client
+	transport etcd.CancelableTransport
+	KeysAPI   etcd.KeysAPI
 }
 
 // GetInt64 returns an int64 value from the configuration key
@@ -54,20 +60,80 @@ func (client *Client) SetInt64(key string, value int64) error {
 	return err
 }
 
+// Ping checks that the ETCD servers are reachable
+func (client *Client) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := client.KeysAPI.Get(ctx, "/", nil)
+	return err
+}
+
 // Stop the client
 func (client *Client) Stop() {
-	if tr, ok := etcd.DefaultTransport.(interface {
+	if tr, ok := client.transport.(interface {
 		CloseIdleConnections()
 	}); ok {
 		tr.CloseIdleConnections()
 	}
 }
 
+// newTLSTransport returns a transport for the https endpoints, using the
+// TLS profile and the certificates set in the configuration
+func newTLSTransport() (etcd.CancelableTransport, error) {
+	tlsConfig := &tls.Config{}
+
+	certPEM := config.GetString("etcd.X509_cert")
+	keyPEM := config.GetString("etcd.X509_key")
+	if certPEM != "" && keyPEM != "" {
+		var err error
+		if tlsConfig, err = common.SetupTLSClientConfig(certPEM, keyPEM); err != nil {
+			return nil, err
+		}
+	}
+
+	if caPEM := config.GetString("etcd.X509_ca"); caPEM != "" {
+		roots, err := common.SetupTLSLoadCertificate(caPEM)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	profile, err := config.GetTLSProfile()
+	if err != nil {
+		return nil, err
+	}
+	profile.Apply(tlsConfig)
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}, nil
+}
+
 // NewClient creates a new ETCD client connection to ETCD servers
 func NewClient(etcdServers []string, clientTimeout time.Duration) (*Client, error) {
+	transport := etcd.DefaultTransport
+	for _, server := range etcdServers {
+		if strings.HasPrefix(server, "https://") {
+			tr, err := newTLSTransport()
+			if err != nil {
+				return nil, fmt.Errorf("Failed to configure TLS for etcd: %s", err)
+			}
+			transport = tr
+			break
+		}
+	}
+
 	cfg := etcd.Config{
 		Endpoints:               etcdServers,
-		Transport:               etcd.DefaultTransport,
+		Transport:               transport,
 		HeaderTimeoutPerRequest: clientTimeout,
 	}
 
@@ -79,8 +145,9 @@ func NewClient(etcdServers []string, clientTimeout time.Duration) (*Client, erro
 	kapi := etcd.NewKeysAPI(client)
 
 	return &Client{
-		client:  &client,
-		KeysAPI: kapi,
+		client:    &client,
+		transport: transport,
+		KeysAPI:   kapi,
 	}, nil
 }
 
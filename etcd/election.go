@@ -132,10 +132,6 @@ func (le *MasterElector) start(first chan struct{}) {
 		}
 	}
 
-	if first != nil {
-		first <- struct{}{}
-	}
-
 	// now watch for changes
 	watcher := le.EtcdKeyAPI.Watcher(le.path, &etcd.WatcherOptions{})
 
@@ -145,7 +141,14 @@ func (le *MasterElector) start(first chan struct{}) {
 	le.wg.Add(1)
 	defer le.wg.Done()
 
+	// the elector has to be running before notifying the first election so
+	// that it can be stopped right after
 	atomic.StoreInt64(&le.state, common.RunningState)
+
+	if first != nil {
+		first <- struct{}{}
+	}
+
 	for atomic.LoadInt64(&le.state) == common.RunningState {
 		resp, err := watcher.Next(ctx)
 		if err != nil {
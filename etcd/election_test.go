@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package etcd_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	etcdclient "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/etcd/etcdtest"
+)
+
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal(msg)
+}
+
+func TestMasterElectorStartAndWait(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	master := etcd.NewMasterElector("host1", common.AnalyzerService, "test", server.Client)
+	master.StartAndWait()
+	if !master.IsMaster() {
+		t.Fatal("Expected the first elector to be the master once the first election is done")
+	}
+
+	follower := etcd.NewMasterElector("host2", common.AnalyzerService, "test", server.Client)
+	follower.StartAndWait()
+	defer follower.Stop()
+	if follower.IsMaster() {
+		t.Fatal("Expected the second elector to be a follower")
+	}
+
+	// the elector is running once the first election is notified so that
+	// stopping it right after releases the lock
+	master.Stop()
+
+	resp, err := server.Client.KeysAPI.Get(context.Background(), "/master-"+common.AnalyzerService.String()+"-test", nil)
+	if err == nil && resp.Node.Value == "host1" {
+		t.Fatal("Expected the lock to be released when the master is stopped")
+	} else if err != nil && !etcdclient.IsKeyNotFound(err) {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "Expected the follower to become the master", follower.IsMaster)
+}
+
+func TestShardedElector(t *testing.T) {
+	server := etcdtest.NewServer(t)
+	defer server.Stop()
+
+	const shards = 8
+
+	se1 := etcd.NewShardedElector("host1", common.AnalyzerService, "test", shards, server.Client)
+	se1.Start()
+
+	if len(se1.MasterShards()) != shards {
+		t.Fatalf("Expected the single member to be the master of all the shards, got: %v", se1.MasterShards())
+	}
+
+	se2 := etcd.NewShardedElector("host2", common.AnalyzerService, "test", shards, server.Client)
+	se2.Start()
+	defer se2.Stop()
+
+	exclusive := func() bool {
+		for shard := 0; shard < shards; shard++ {
+			if se1.IsMaster(shard) == se2.IsMaster(shard) {
+				return false
+			}
+		}
+		return se1.IsAnyMaster() && se2.IsAnyMaster()
+	}
+	waitFor(t, fmt.Sprintf("Expected the shards to be split among the members, got %v and %v", se1.MasterShards(), se2.MasterShards()), exclusive)
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("alert-%d", i)
+		if se1.IsKeyMaster(key) == se2.IsKeyMaster(key) {
+			t.Errorf("Expected a single master for %s", key)
+		}
+	}
+
+	se1.Stop()
+	waitFor(t, "Expected the remaining member to take over all the shards", func() bool {
+		return len(se2.MasterShards()) == shards
+	})
+}
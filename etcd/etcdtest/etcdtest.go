@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+// Package etcdtest runs an embedded etcd server for the tests of the
+// components relying on etcd, like the elections and the API resources
+package etcdtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/etcd"
+)
+
+// Server is an embedded etcd server listening on the loopback and a client
+// connected to it
+type Server struct {
+	Embedded *etcd.EmbeddedEtcd
+	Client   *etcd.Client
+	dataDir  string
+}
+
+// freePort returns a port of the loopback free along with the next one, used
+// as peer port by the embedded server
+func freePort() (int, error) {
+	for i := 0; i < 100; i++ {
+		port := 20000 + rand.Intn(40000)
+
+		var listeners []net.Listener
+		for _, p := range []int{port, port + 1} {
+			if l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", p)); err == nil {
+				listeners = append(listeners, l)
+			}
+		}
+		for _, l := range listeners {
+			l.Close()
+		}
+
+		if len(listeners) == 2 {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("No free port found")
+}
+
+// NewServer starts an embedded etcd server with an empty data directory,
+// failing the test when the server can't be started
+func NewServer(t testing.TB) *Server {
+	rand.Seed(time.Now().UnixNano())
+
+	dataDir, err := ioutil.TempDir("", "skydive-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatal(err)
+	}
+
+	embedded, err := etcd.NewEmbeddedEtcd("skydive-test", fmt.Sprintf("127.0.0.1:%d", port), dataDir, 1, 1, false)
+	if err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatal(err)
+	}
+
+	client, err := etcd.NewClient([]string{fmt.Sprintf("http://127.0.0.1:%d", port)}, 5*time.Second)
+	if err != nil {
+		embedded.Stop()
+		os.RemoveAll(dataDir)
+		t.Fatal(err)
+	}
+
+	return &Server{Embedded: embedded, Client: client, dataDir: dataDir}
+}
+
+// Stop the server and remove its data
+func (s *Server) Stop() {
+	s.Client.Stop()
+	s.Embedded.Stop()
+	os.RemoveAll(s.dataDir)
+}
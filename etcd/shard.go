@@ -0,0 +1,278 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package etcd
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// ShardedElector distributes a set of shards among the instances of a
+// service. Each instance registers itself as a member and the shards are
+// assigned to the members by rendezvous hashing. An instance only stands for
+// the master election of the shards assigned to it so that a shard is handled
+// by a single instance, even while the members disagree on the assignments.
+type ShardedElector struct {
+	common.RWMutex
+	etcdClient  *Client
+	host        string
+	serviceType common.ServiceType
+	key         string
+	shards      int
+	membersPath string
+	members     []string
+	electors    map[int]*MasterElector
+	cancel      context.CancelFunc
+	quit        chan struct{}
+	wg          sync.WaitGroup
+}
+
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// ShardOf returns the shard of a key
+func (se *ShardedElector) ShardOf(key string) int {
+	return int(hash32(key) % uint32(se.shards))
+}
+
+// weight returns the weight of a member for a shard. The hash is mixed so
+// that members with close names, like host1 and host2, get unrelated weights.
+func weight(member string, shard int) uint32 {
+	h := hash32(fmt.Sprintf("%s/%d", member, shard))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// preferredMember returns the member with the highest weight for the shard
+func preferredMember(shard int, members []string) (preferred string) {
+	var max uint32
+	for _, member := range members {
+		if w := weight(member, shard); preferred == "" || w > max {
+			preferred, max = member, w
+		}
+	}
+	return
+}
+
+// IsMaster returns true if the current instance is the master of the shard
+func (se *ShardedElector) IsMaster(shard int) bool {
+	se.RLock()
+	defer se.RUnlock()
+
+	elector, ok := se.electors[shard]
+	return ok && elector.IsMaster()
+}
+
+// IsKeyMaster returns true if the current instance is the master of the shard of the key
+func (se *ShardedElector) IsKeyMaster(key string) bool {
+	return se.IsMaster(se.ShardOf(key))
+}
+
+// IsAnyMaster returns true if the current instance is the master of at least one shard
+func (se *ShardedElector) IsAnyMaster() bool {
+	return len(se.MasterShards()) > 0
+}
+
+// MasterShards returns the shards the current instance is the master of
+func (se *ShardedElector) MasterShards() []int {
+	se.RLock()
+	defer se.RUnlock()
+
+	return se.masterShards()
+}
+
+func (se *ShardedElector) register() error {
+	_, err := se.etcdClient.KeysAPI.Set(context.Background(), path.Join(se.membersPath, se.host), se.host, &etcd.SetOptions{TTL: timeout})
+	return err
+}
+
+func (se *ShardedElector) getMembers() ([]string, error) {
+	resp, err := se.etcdClient.KeysAPI.Get(context.Background(), se.membersPath, &etcd.GetOptions{Recursive: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var members []string
+	for _, node := range resp.Node.Nodes {
+		members = append(members, path.Base(node.Key))
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// rebalance stands for the election of the shards assigned to the current
+// instance and withdraws from the others
+func (se *ShardedElector) rebalance() {
+	members, err := se.getMembers()
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to retrieve the members of %s: %s", se.membersPath, err.Error())
+		return
+	}
+
+	se.Lock()
+	defer se.Unlock()
+
+	if strings.Join(members, ",") == strings.Join(se.members, ",") {
+		return
+	}
+	se.members = members
+
+	for shard := 0; shard < se.shards; shard++ {
+		elector, running := se.electors[shard]
+		switch assigned := preferredMember(shard, members) == se.host; {
+		case assigned && !running:
+			elector = NewMasterElector(se.host, se.serviceType, fmt.Sprintf("%s-%d", se.key, shard), se.etcdClient)
+			elector.StartAndWait()
+			se.electors[shard] = elector
+		case !assigned && running:
+			elector.Stop()
+			delete(se.electors, shard)
+		}
+	}
+
+	logging.GetLogger().Infof("Shards of %s assigned among %d members, master of %v", se.key, len(members), se.masterShards())
+}
+
+func (se *ShardedElector) masterShards() (shards []int) {
+	for shard, elector := range se.electors {
+		if elector.IsMaster() {
+			shards = append(shards, shard)
+		}
+	}
+	sort.Ints(shards)
+	return
+}
+
+func (se *ShardedElector) watchMembers(ctx context.Context) {
+	defer se.wg.Done()
+
+	watcher := se.etcdClient.KeysAPI.Watcher(se.membersPath, &etcd.WatcherOptions{Recursive: true})
+	for {
+		if _, err := watcher.Next(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			logging.GetLogger().Errorf("Error while watching etcd: %s", err.Error())
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		se.rebalance()
+	}
+}
+
+func (se *ShardedElector) run() {
+	defer se.wg.Done()
+
+	tick := time.NewTicker(timeout / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := se.register(); err != nil {
+				logging.GetLogger().Errorf("Failed to register as a member of %s: %s", se.membersPath, err.Error())
+			}
+			se.rebalance()
+		case <-se.quit:
+			return
+		}
+	}
+}
+
+// Start registers the instance as a member and waits for the first
+// assignment of the shards before returning
+func (se *ShardedElector) Start() {
+	if err := se.register(); err != nil {
+		logging.GetLogger().Errorf("Failed to register as a member of %s: %s", se.membersPath, err.Error())
+	}
+	se.rebalance()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	se.cancel = cancel
+
+	se.wg.Add(2)
+	go se.watchMembers(ctx)
+	go se.run()
+}
+
+// Stop withdraws from the elections and unregisters the instance
+func (se *ShardedElector) Stop() {
+	se.cancel()
+	close(se.quit)
+	se.wg.Wait()
+
+	se.etcdClient.KeysAPI.Delete(context.Background(), path.Join(se.membersPath, se.host), &etcd.DeleteOptions{})
+
+	se.Lock()
+	for shard, elector := range se.electors {
+		elector.Stop()
+		delete(se.electors, shard)
+	}
+	se.members = nil
+	se.Unlock()
+}
+
+// NewShardedElector returns a new elector distributing shards among the
+// instances of a service
+func NewShardedElector(host string, serviceType common.ServiceType, key string, shards int, etcdClient *Client) *ShardedElector {
+	if shards < 1 {
+		shards = 1
+	}
+
+	return &ShardedElector{
+		etcdClient:  etcdClient,
+		host:        host,
+		serviceType: serviceType,
+		key:         key,
+		shards:      shards,
+		membersPath: "/members-" + serviceType.String() + "-" + key,
+		electors:    make(map[int]*MasterElector),
+		quit:        make(chan struct{}),
+	}
+}
+
+// NewShardedElectorFromConfig returns a new sharded elector from configuration
+func NewShardedElectorFromConfig(serviceType common.ServiceType, key string, shards int, etcdClient *Client) *ShardedElector {
+	host := config.GetString("host_id")
+	return NewShardedElector(host, serviceType, key, shards, etcdClient)
+}
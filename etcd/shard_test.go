@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package etcd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+func TestShardOf(t *testing.T) {
+	se := NewShardedElector("host1", common.AnalyzerService, "test", 8, nil)
+	other := NewShardedElector("host2", common.AnalyzerService, "test", 8, nil)
+
+	shards := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("alert-%d", i)
+
+		shard := se.ShardOf(key)
+		if shard < 0 || shard >= 8 {
+			t.Fatalf("Shard of %s out of range: %d", key, shard)
+		}
+		if se.ShardOf(key) != shard || other.ShardOf(key) != shard {
+			t.Fatalf("Expected the shard of %s to be the same for all the instances", key)
+		}
+		shards[shard] = true
+	}
+
+	if len(shards) != 8 {
+		t.Errorf("Expected the keys to be spread over all the shards, got: %v", shards)
+	}
+
+	if single := NewShardedElector("host1", common.AnalyzerService, "test", 0, nil); single.ShardOf("alert-1") != 0 {
+		t.Error("Expected a single shard when no shard is given")
+	}
+}
+
+func TestPreferredMember(t *testing.T) {
+	members := []string{"host1", "host2", "host3"}
+	reversed := []string{"host3", "host2", "host1"}
+
+	assigned := make(map[int]string)
+	counts := make(map[string]int)
+	for shard := 0; shard < 64; shard++ {
+		preferred := preferredMember(shard, members)
+		if preferred != preferredMember(shard, reversed) {
+			t.Fatalf("Expected the preferred member of shard %d not to depend on the order of the members", shard)
+		}
+		assigned[shard] = preferred
+		counts[preferred]++
+	}
+
+	for _, member := range members {
+		if counts[member] == 0 {
+			t.Errorf("Expected %s to be assigned some shards, got: %v", member, counts)
+		}
+	}
+
+	// only the shards of a leaving member move, to the remaining members
+	remaining := []string{"host1", "host3"}
+	for shard, preferred := range assigned {
+		moved := preferredMember(shard, remaining)
+		if preferred != "host2" && moved != preferred {
+			t.Errorf("Expected shard %d to stay on %s, moved to %s", shard, preferred, moved)
+		}
+		if moved == "host2" {
+			t.Errorf("Expected shard %d to be assigned to a remaining member", shard)
+		}
+	}
+
+	// only the shards taken by a new member move
+	joined := []string{"host1", "host2", "host3", "host4"}
+	for shard, preferred := range assigned {
+		if moved := preferredMember(shard, joined); moved != preferred && moved != "host4" {
+			t.Errorf("Expected shard %d to stay on %s or to move to host4, moved to %s", shard, preferred, moved)
+		}
+	}
+
+	// members with close names share the shards too
+	pair := make(map[string]int)
+	for shard := 0; shard < 8; shard++ {
+		pair[preferredMember(shard, []string{"host1", "host2"})]++
+	}
+	if pair["host1"] == 0 || pair["host2"] == 0 {
+		t.Errorf("Expected the shards to be shared by host1 and host2, got: %v", pair)
+	}
+
+	if preferred := preferredMember(0, nil); preferred != "" {
+		t.Errorf("Expected no preferred member without members, got: %s", preferred)
+	}
+}
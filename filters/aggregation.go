@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package filters
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Aggregation types
+const (
+	TermsAggregation       = "terms"
+	HistogramAggregation   = "histogram"
+	CardinalityAggregation = "cardinality"
+	SumAggregation         = "sum"
+)
+
+// AggregationBucket describes a bucket of an aggregation, with the results
+// of its sub aggregations
+type AggregationBucket struct {
+	Key          interface{}
+	Count        int64
+	Aggregations map[string]*AggregationResult `json:",omitempty"`
+}
+
+// AggregationResult describes the result of an aggregation, either buckets
+// or the value of a cardinality or a sum aggregation
+type AggregationResult struct {
+	Value   *int64               `json:",omitempty"`
+	Buckets []*AggregationBucket `json:",omitempty"`
+}
+
+func isMetricAggregation(aggregations []*Aggregation, name string) bool {
+	for _, a := range aggregations {
+		if a.Name == name {
+			return a.Type == CardinalityAggregation || a.Type == SumAggregation
+		}
+	}
+	return false
+}
+
+// ValidateAggregations checks the aggregations and their sub aggregations
+func ValidateAggregations(aggregations []*Aggregation) error {
+	names := make(map[string]bool)
+	for _, a := range aggregations {
+		if a.Name == "" {
+			return errors.New("Aggregation name missing")
+		}
+		if names[a.Name] {
+			return fmt.Errorf("Duplicate aggregation name '%s'", a.Name)
+		}
+		names[a.Name] = true
+
+		if a.Field == "" {
+			return fmt.Errorf("Field of aggregation '%s' missing", a.Name)
+		}
+
+		switch a.Type {
+		case TermsAggregation:
+			if a.Size < 0 {
+				return fmt.Errorf("Size of aggregation '%s' has to be positive", a.Name)
+			}
+			if a.Order != "" && !isMetricAggregation(a.Aggregations, a.Order) {
+				return fmt.Errorf("Aggregation '%s' has to be ordered by one of its cardinality or sum aggregations", a.Name)
+			}
+		case HistogramAggregation:
+			if a.Interval <= 0 {
+				return fmt.Errorf("Interval of aggregation '%s' has to be positive", a.Name)
+			}
+		case CardinalityAggregation, SumAggregation:
+			if len(a.Aggregations) > 0 {
+				return fmt.Errorf("Aggregation '%s' of type %s can't have sub aggregations", a.Name, a.Type)
+			}
+		default:
+			return fmt.Errorf("Aggregation type '%s' not supported", a.Type)
+		}
+
+		if err := ValidateAggregations(a.Aggregations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
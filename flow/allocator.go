@@ -82,6 +82,16 @@ func (a *TableAllocator) QueryTable(query *TableQuery) *TableReply {
 	return a.aggregateReplies(query, replies)
 }
 
+// Flush flushes all the allocated tables
+func (a *TableAllocator) Flush() {
+	a.RLock()
+	defer a.RUnlock()
+
+	for table := range a.tables {
+		table.Flush()
+	}
+}
+
 // Alloc instanciate/allocate a new table
 func (a *TableAllocator) Alloc(flowCallBack ExpireUpdateFunc, nodeTID string, opts TableOpts) *Table {
 	a.Lock()
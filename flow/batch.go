@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// MaxBatchSize is the maximum size of a decompressed batch of flows
+const MaxBatchSize = 64 * 1024 * 1024
+
+// ErrBatchTooLarge is returned when a decompressed batch exceeds MaxBatchSize
+var ErrBatchTooLarge = errors.New("Batch of flows too large")
+
+// IsBatch returns whether the data is a compressed batch of flows and not a
+// single protobuf flow. The gzip magic can't start a protobuf message, 0x1f
+// being the tag of the field 3 with the invalid wire type 7.
+func IsBatch(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// EncodeBatch returns the gzip compressed FlowSet made of the given protobuf
+// encoded flows
+func EncodeBatch(flows [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+
+	for _, data := range flows {
+		// Flows, field 1 of FlowSet, is length delimited
+		if _, err := w.Write(append([]byte{0x0a}, proto.EncodeVarint(uint64(len(data)))...)); err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeBatch returns the flows of a compressed batch
+func DecodeBatch(data []byte) ([]*Flow, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, MaxBatchSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	var fs FlowSet
+	if err := proto.Unmarshal(b, &fs); err != nil {
+		return nil, err
+	}
+
+	return fs.Flows, nil
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	flows := []*Flow{
+		{UUID: "aaa", TrackingID: "111", Start: 1000},
+		{UUID: "bbb", TrackingID: "222", Start: 2000, Last: 3000},
+	}
+
+	var encoded [][]byte
+	for _, f := range flows {
+		data, err := f.GetData()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if IsBatch(data) {
+			t.Errorf("A single flow shouldn't be detected as a batch")
+		}
+		encoded = append(encoded, data)
+	}
+
+	data, err := EncodeBatch(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsBatch(data) {
+		t.Fatalf("Batch not detected")
+	}
+
+	decoded, err := DecodeBatch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded) != len(flows) {
+		t.Fatalf("Expected %d flows, got %d", len(flows), len(decoded))
+	}
+
+	for i, f := range decoded {
+		if f.UUID != flows[i].UUID || f.Start != flows[i].Start || f.Last != flows[i].Last {
+			t.Errorf("Flow mismatch, expected %+v, got %+v", flows[i], f)
+		}
+	}
+}
@@ -23,6 +23,9 @@
 package flow
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/golang/protobuf/proto"
 
 	"github.com/skydive-project/skydive/common"
@@ -132,6 +135,68 @@ func (f *TableClient) LookupFlowsByNodes(hnmap topology.HostNodeTIDMap, flowSear
 	return flowset, nil
 }
 
+// dumpTables requests the dumps of the tables of the nodes of a host
+func (f *TableClient) dumpTables(host string, tids []string) ([]*TableDump, error) {
+	obj, _ := json.Marshal(&TableDumpQuery{NodeTIDs: tids})
+	tq := TableQuery{Type: "DumpQuery", Obj: obj}
+	msg := shttp.NewWSStructMessage(Namespace, "TableQuery", tq)
+
+	resp, err := f.WSStructServer.Request(host, msg, shttp.DefaultRequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to send message to agent %s: %s", host, err.Error())
+	}
+
+	var reply TableReply
+	if resp == nil || resp.UnmarshalObj(&reply) != nil {
+		return nil, fmt.Errorf("Error returned while reading TableReply from: %s", host)
+	}
+
+	var dumps []*TableDump
+	for _, b := range reply.Obj {
+		var dump TableDump
+		if err := json.Unmarshal(b, &dump); err != nil {
+			return nil, fmt.Errorf("Unable to decode flow table dump from %s: %s", host, err.Error())
+		}
+		dumps = append(dumps, &dump)
+	}
+	return dumps, nil
+}
+
+// DumpTables returns the live state of the flow tables of the nodes,
+// requested from their agents. The agents failing to reply are skipped, an
+// error being returned when none of them replied.
+func (f *TableClient) DumpTables(hnmap topology.HostNodeTIDMap) ([]*TableDump, error) {
+	type result struct {
+		dumps []*TableDump
+		err   error
+	}
+
+	ch := make(chan result, len(hnmap))
+	for host, tids := range hnmap {
+		go func(host string, tids []string) {
+			dumps, err := f.dumpTables(host, tids)
+			ch <- result{dumps: dumps, err: err}
+		}(host, tids)
+	}
+
+	var dumps []*TableDump
+	var lastErr error
+	for i := 0; i != len(hnmap); i++ {
+		r := <-ch
+		if r.err != nil {
+			logging.GetLogger().Error(r.err)
+			lastErr = r.err
+			continue
+		}
+		dumps = append(dumps, r.dumps...)
+	}
+
+	if len(dumps) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return dumps, nil
+}
+
 // NewTableClient creates a new table client based on websocket
 func NewTableClient(w *shttp.WSStructServer) *TableClient {
 	return &TableClient{WSStructServer: w}
@@ -24,6 +24,7 @@ package flow
 
 import (
 	"encoding/binary"
+	"errors"
 	"runtime"
 
 	"github.com/google/gopacket"
@@ -57,10 +58,19 @@ func (m rawIPLayer) LayerPayload() []byte {
 	return m.payload
 }
 
+// ICMPv6 Multicast Listener Discovery message types, RFC 2710 and RFC 3810
+const (
+	icmpv6TypeMLDQuery    = 130
+	icmpv6TypeMLDReport   = 131
+	icmpv6TypeMLDDone     = 132
+	icmpv6TypeMLDv2Report = 143
+)
+
 // ICMPv4 aims to store ICMP metadata and aims to be used for the flow hash key
 type ICMPv4 struct {
 	layers.ICMPv4
-	Type ICMPType
+	Type     ICMPType
+	Original *ICMPOriginal
 }
 
 // Payload returns the ICMP payload
@@ -69,8 +79,9 @@ func (i *ICMPv4) Payload() []byte { return i.LayerPayload() }
 // ICMPv6 aims to store ICMP metadata and aims to be used for the flow hash key
 type ICMPv6 struct {
 	layers.ICMPv6
-	Type ICMPType
-	Id   uint16
+	Type     ICMPType
+	Id       uint16
+	Original *ICMPOriginal
 }
 
 // Payload returns the ICMP payload
@@ -78,30 +89,34 @@ func (i *ICMPv6) Payload() []byte { return i.LayerPayload() }
 
 // Try to decode data as IP4 or IP6. If data starts by 4 or 6,
 // ipPrefix is set to true to indicate it seems to be an IP header,
-// and a decoding failure would be reported in error.
+// and a decoding failure would be reported in error. A layer that failed
+// to decode is not added to the packet, to avoid building flows on top of
+// bogus headers.
 func ipDecoderFromRawData(data []byte, p gopacket.PacketBuilder) (ipPrefix bool, e error) {
+	if len(data) == 0 {
+		return false, nil
+	}
+
 	switch (data[0] >> 4) & 0xf {
 	case 4:
 		ip4 := &layers.IPv4{}
-		err := ip4.DecodeFromBytes(data, p)
+		if err := ip4.DecodeFromBytes(data, p); err != nil {
+			return true, err
+		}
 		p.AddLayer(ip4)
 
 		// Only the first call to this function is kept by
 		// gopacket. So, this works even if this layer is not
 		// the network layer (in case of encapsulation).
 		p.SetNetworkLayer(ip4)
-		if err != nil {
-			return true, err
-		}
 		return true, p.NextDecoder(ip4.NextLayerType())
 	case 6:
 		ip6 := &layers.IPv6{}
-		err := ip6.DecodeFromBytes(data, p)
-		p.AddLayer(ip6)
-		p.SetNetworkLayer(ip6)
-		if err != nil {
+		if err := ip6.DecodeFromBytes(data, p); err != nil {
 			return true, err
 		}
+		p.AddLayer(ip6)
+		p.SetNetworkLayer(ip6)
 		return true, p.NextDecoder(ip6.NextLayerType())
 	default:
 		return false, nil
@@ -113,9 +128,12 @@ func decodeInGRELayer(data []byte, p gopacket.PacketBuilder) error {
 		return err
 	}
 	packet := gopacket.NewPacket(data, layers.LayerTypeARP, gopacket.Lazy)
-	layer := packet.Layer(layers.LayerTypeARP)
-	p.AddLayer(layer)
-	return nil
+	if layer := packet.Layer(layers.LayerTypeARP); layer != nil {
+		p.AddLayer(layer)
+		return nil
+	}
+	// neither IP nor ARP, keep the remaining bytes as an opaque payload
+	return p.NextDecoder(gopacket.LayerTypePayload)
 }
 
 func decodeInMplsEthOrIPLayer(data []byte, p gopacket.PacketBuilder) error {
@@ -124,11 +142,10 @@ func decodeInMplsEthOrIPLayer(data []byte, p gopacket.PacketBuilder) error {
 	}
 	// If IPv4 or IPv6 fails, we fallback to Ethernet
 	eth := &layers.Ethernet{}
-	err := eth.DecodeFromBytes(data, p)
-	p.AddLayer(eth)
-	if err != nil {
+	if err := eth.DecodeFromBytes(data, p); err != nil {
 		return err
 	}
+	p.AddLayer(eth)
 	return p.NextDecoder(eth.NextLayerType())
 }
 
@@ -169,6 +186,12 @@ func decodeICMPv4(data []byte, p gopacket.PacketBuilder) error {
 
 	icmpv4.Type = ICMPV4TypeToFlowICMPType(icmpv4.TypeCode.Type())
 
+	switch icmpv4.TypeCode.Type() {
+	case layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4TypeSourceQuench, layers.ICMPv4TypeRedirect,
+		layers.ICMPv4TypeTimeExceeded, layers.ICMPv4TypeParameterProblem:
+		icmpv4.Original = decodeICMPOriginal(icmpv4.LayerPayload(), false)
+	}
+
 	p.AddLayer(icmpv4)
 	p.SetApplicationLayer(icmpv4)
 	return p.NextDecoder(icmpv4.NextLayerType())
@@ -193,6 +216,8 @@ func ICMPV6TypeToFlowICMPType(kind uint8) ICMPType {
 		return ICMPType_ROUTER
 	case layers.ICMPv6TypeTimeExceeded:
 		return ICMPType_TIME_EXCEEDED
+	case icmpv6TypeMLDQuery, icmpv6TypeMLDReport, icmpv6TypeMLDDone, icmpv6TypeMLDv2Report:
+		return ICMPType_MULTICAST_LISTENER
 	}
 
 	return ICMPType_UNKNOWN
@@ -209,7 +234,13 @@ func decodeICMPv6(data []byte, p gopacket.PacketBuilder) error {
 
 	switch icmpv6.TypeCode.Type() {
 	case layers.ICMPv6TypeEchoRequest, layers.ICMPv6TypeEchoReply:
+		if len(icmpv6.TypeBytes) < 2 {
+			return errors.New("ICMPv6 echo header too short")
+		}
 		icmpv6.Id = binary.BigEndian.Uint16(icmpv6.TypeBytes[0:2])
+	case layers.ICMPv6TypeDestinationUnreachable, layers.ICMPv6TypePacketTooBig,
+		layers.ICMPv6TypeTimeExceeded, layers.ICMPv6TypeParameterProblem:
+		icmpv6.Original = decodeICMPOriginal(icmpv6.LayerPayload(), true)
 	}
 
 	p.AddLayer(icmpv6)
@@ -217,6 +248,63 @@ func decodeICMPv6(data []byte, p gopacket.PacketBuilder) error {
 	return p.NextDecoder(icmpv6.NextLayerType())
 }
 
+// decodeICMPOriginal decodes the headers of the packet quoted by an ICMP
+// error message. Only the first bytes of the transport header are usually
+// quoted, so transport layers are limited to their ports.
+func decodeICMPOriginal(data []byte, ipv6 bool) *ICMPOriginal {
+	var (
+		protocol layers.IPProtocol
+		payload  []byte
+		original = &ICMPOriginal{}
+	)
+
+	if ipv6 {
+		ip6 := &layers.IPv6{}
+		if err := ip6.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+			return nil
+		}
+		original.Network = &FlowLayer{
+			Protocol: FlowProtocol_IPV6,
+			A:        ip6.SrcIP.String(),
+			B:        ip6.DstIP.String(),
+		}
+		protocol, payload = ip6.NextHeader, ip6.Payload
+	} else {
+		ip4 := &layers.IPv4{}
+		if err := ip4.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+			return nil
+		}
+		original.Network = &FlowLayer{
+			Protocol: FlowProtocol_IPV4,
+			A:        ip4.SrcIP.String(),
+			B:        ip4.DstIP.String(),
+		}
+		protocol, payload = ip4.Protocol, ip4.Payload
+	}
+
+	var transport FlowProtocol
+	switch protocol {
+	case layers.IPProtocolTCP:
+		transport = FlowProtocol_TCP
+	case layers.IPProtocolUDP:
+		transport = FlowProtocol_UDP
+	case layers.IPProtocolSCTP:
+		transport = FlowProtocol_SCTP
+	default:
+		return original
+	}
+
+	if len(payload) >= 4 {
+		original.Transport = &TransportLayer{
+			Protocol: transport,
+			A:        int64(binary.BigEndian.Uint16(payload[0:2])),
+			B:        int64(binary.BigEndian.Uint16(payload[2:4])),
+		}
+	}
+
+	return original
+}
+
 func init() {
 	// By default, gopacket tries to decode IPv4 or IPv6 in the
 	// MPLS next layer and fails otherwise. Instead, we also tries
@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+// dhcpTransactionTimeout is the delay after which a transaction without
+// acknowledgement, a DISCOVER/OFFER without REQUEST for instance, is
+// reported as is
+const dhcpTransactionTimeout = time.Minute
+
+// DHCPTransaction describes a DHCP lease transaction between a client and
+// a server. Messages holds the types of the exchanged messages, State the
+// type of the last one, and Options the options of the last server reply.
+// VendorClass and ParameterList, the codes of the options requested by the
+// client, fingerprint the DHCP client. The timestamps are in milliseconds.
+type DHCPTransaction struct {
+	TransactionID int64
+	ClientMAC     string
+	Hostname      string
+	RequestedIP   string
+	VendorClass   string
+	ParameterList string
+	OfferedIP     string
+	Server        string
+	State         string
+	Messages      []string
+	LeaseTime     int64
+	Options       map[string]string
+	Start         int64
+	Last          int64
+	last          time.Time
+}
+
+// DHCPTracker gathers the DHCP messages of a capture into transactions
+type DHCPTracker struct {
+	sync.Mutex
+	pending   map[string]*DHCPTransaction
+	completed []*DHCPTransaction
+	clock     packetClock
+}
+
+func dhcpOptionValue(o layers.DHCPOption) string {
+	switch o.Type {
+	case layers.DHCPOptSubnetMask, layers.DHCPOptRouter, layers.DHCPOptDNS, layers.DHCPOptServerID, layers.DHCPOptRequestIP:
+		var ips []string
+		for data := o.Data; len(data) >= net.IPv4len; data = data[net.IPv4len:] {
+			ips = append(ips, net.IP(data[:net.IPv4len]).String())
+		}
+		return strings.Join(ips, ",")
+	case layers.DHCPOptLeaseTime, layers.DHCPOptT1, layers.DHCPOptT2:
+		if len(o.Data) == 4 {
+			return fmt.Sprintf("%d", binary.BigEndian.Uint32(o.Data))
+		}
+	case layers.DHCPOptHostname, layers.DHCPOptDomainName, layers.DHCPOptMessage:
+		return string(o.Data)
+	}
+	return hex.EncodeToString(o.Data)
+}
+
+func (t *DHCPTransaction) update(dhcp *layers.DHCPv4, source net.IP, msgType layers.DHCPMsgType, ts time.Time) {
+	state := strings.ToUpper(msgType.String())
+	t.State, t.Messages = state, append(t.Messages, state)
+	t.Last, t.last = common.UnixMillis(ts), ts
+
+	if dhcp.Operation == layers.DHCPOpRequest {
+		for _, o := range dhcp.Options {
+			switch o.Type {
+			case layers.DHCPOptHostname:
+				t.Hostname = string(o.Data)
+			case layers.DHCPOptRequestIP:
+				t.RequestedIP = dhcpOptionValue(o)
+			case layers.DHCPOptClassID:
+				t.VendorClass = string(o.Data)
+			case layers.DHCPOptParamsRequest:
+				codes := make([]string, len(o.Data))
+				for i, code := range o.Data {
+					codes[i] = strconv.Itoa(int(code))
+				}
+				t.ParameterList = strings.Join(codes, ",")
+			}
+		}
+		return
+	}
+
+	if ip := dhcp.YourClientIP; ip != nil && !ip.IsUnspecified() {
+		t.OfferedIP = ip.String()
+	}
+
+	t.Server = source.String()
+	t.Options = make(map[string]string)
+	for _, o := range dhcp.Options {
+		switch o.Type {
+		case layers.DHCPOptMessageType, layers.DHCPOptPad, layers.DHCPOptEnd:
+		case layers.DHCPOptServerID:
+			t.Server = dhcpOptionValue(o)
+		case layers.DHCPOptLeaseTime:
+			if len(o.Data) == 4 {
+				t.LeaseTime = int64(binary.BigEndian.Uint32(o.Data))
+			}
+			t.Options[o.Type.String()] = dhcpOptionValue(o)
+		default:
+			t.Options[o.Type.String()] = dhcpOptionValue(o)
+		}
+	}
+}
+
+// Observe adds the DHCP message of a captured packet to its transaction
+func (dt *DHCPTracker) Observe(packet gopacket.Packet) {
+	layer := packet.Layer(layers.LayerTypeDHCPv4)
+	if layer == nil {
+		return
+	}
+	dhcp := layer.(*layers.DHCPv4)
+
+	var msgType layers.DHCPMsgType
+	for _, o := range dhcp.Options {
+		if o.Type == layers.DHCPOptMessageType && len(o.Data) == 1 {
+			msgType = layers.DHCPMsgType(o.Data[0])
+		}
+	}
+	if msgType == layers.DHCPMsgTypeUnspecified || dhcp.ClientHWAddr == nil {
+		return
+	}
+
+	var source net.IP
+	if ip, ok := packet.NetworkLayer().(*layers.IPv4); ok {
+		source = ip.SrcIP
+	}
+
+	ts := packet.Metadata().CaptureInfo.Timestamp
+	if ts.IsZero() {
+		ts = time.Now().UTC()
+	}
+
+	dt.Lock()
+	defer dt.Unlock()
+
+	dt.clock.tick(ts)
+	dt.expire(ts)
+
+	key := fmt.Sprintf("%s/%d", dhcp.ClientHWAddr, dhcp.Xid)
+	t, found := dt.pending[key]
+	if !found {
+		t = &DHCPTransaction{
+			TransactionID: int64(dhcp.Xid),
+			ClientMAC:     dhcp.ClientHWAddr.String(),
+			Start:         common.UnixMillis(ts),
+		}
+		dt.pending[key] = t
+	}
+	t.update(dhcp, source, msgType, ts)
+
+	switch msgType {
+	case layers.DHCPMsgTypeAck, layers.DHCPMsgTypeNak, layers.DHCPMsgTypeDecline, layers.DHCPMsgTypeRelease:
+		dt.completed = append(dt.completed, t)
+		delete(dt.pending, key)
+	}
+}
+
+func (dt *DHCPTracker) expire(now time.Time) {
+	for key, t := range dt.pending {
+		if now.Sub(t.last) > dhcpTransactionTimeout {
+			dt.completed = append(dt.completed, t)
+			delete(dt.pending, key)
+		}
+	}
+}
+
+// Now returns the current time in the clock of the captured packets
+func (dt *DHCPTracker) Now() time.Time {
+	dt.Lock()
+	defer dt.Unlock()
+
+	return dt.clock.now()
+}
+
+// Transactions returns and forgets the completed transactions, along with
+// the pending transactions which timed out at the given time of the
+// packets clock
+func (dt *DHCPTracker) Transactions(now time.Time) []*DHCPTransaction {
+	dt.Lock()
+	defer dt.Unlock()
+
+	dt.expire(now)
+
+	completed := dt.completed
+	dt.completed = nil
+	return completed
+}
+
+// NewDHCPTracker returns a new DHCP transaction tracker
+func NewDHCPTracker() *DHCPTracker {
+	return &DHCPTracker{
+		pending: make(map[string]*DHCPTransaction),
+	}
+}
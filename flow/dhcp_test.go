@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	dhcpClientMAC = net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	dhcpServerIP  = net.IP{10, 0, 0, 1}
+)
+
+func dhcpPacket(t *testing.T, ts time.Time, xid uint32, msgType layers.DHCPMsgType, options ...layers.DHCPOption) gopacket.Packet {
+	op, src, dst := layers.DHCPOpRequest, net.IPv4zero, net.IPv4bcast
+	sport, dport := layers.UDPPort(68), layers.UDPPort(67)
+	if msgType == layers.DHCPMsgTypeOffer || msgType == layers.DHCPMsgTypeAck || msgType == layers.DHCPMsgTypeNak {
+		op, src = layers.DHCPOpReply, dhcpServerIP
+		sport, dport = dport, sport
+	}
+
+	dhcp := &layers.DHCPv4{
+		Operation:    op,
+		HardwareType: layers.LinkTypeEthernet,
+		HardwareLen:  6,
+		Xid:          xid,
+		ClientHWAddr: dhcpClientMAC,
+		ClientIP:     net.IPv4zero,
+		YourClientIP: net.IPv4zero,
+		NextServerIP: net.IPv4zero,
+		RelayAgentIP: net.IPv4zero,
+		Options:      append([]layers.DHCPOption{layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(msgType)})}, options...),
+	}
+	if op == layers.DHCPOpReply {
+		dhcp.YourClientIP = net.IP{10, 0, 0, 42}
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       dhcpClientMAC,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	udp := &layers.UDP{SrcPort: sport, DstPort: dport}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buffer := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opts, eth, ip, udp, dhcp); err != nil {
+		t.Fatal(err)
+	}
+
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	packet.Metadata().CaptureInfo.Timestamp = ts
+	return packet
+}
+
+func TestDHCPTransactions(t *testing.T) {
+	dt := NewDHCPTracker()
+	start := time.Unix(1500000000, 0)
+
+	serverOptions := []layers.DHCPOption{
+		layers.NewDHCPOption(layers.DHCPOptServerID, dhcpServerIP),
+		layers.NewDHCPOption(layers.DHCPOptLeaseTime, []byte{0, 0, 0x0e, 0x10}),
+		layers.NewDHCPOption(layers.DHCPOptRouter, []byte{10, 0, 0, 254}),
+		layers.NewDHCPOption(layers.DHCPOptDNS, []byte{10, 0, 0, 2, 10, 0, 0, 3}),
+	}
+
+	dt.Observe(dhcpPacket(t, start, 1, layers.DHCPMsgTypeDiscover,
+		layers.NewDHCPOption(layers.DHCPOptHostname, []byte("myhost")),
+		layers.NewDHCPOption(layers.DHCPOptClassID, []byte("MSFT 5.0")),
+		layers.NewDHCPOption(layers.DHCPOptParamsRequest, []byte{1, 3, 6, 15}),
+	))
+	dt.Observe(dhcpPacket(t, start, 1, layers.DHCPMsgTypeOffer, serverOptions...))
+	dt.Observe(dhcpPacket(t, start, 1, layers.DHCPMsgTypeRequest, layers.NewDHCPOption(layers.DHCPOptRequestIP, []byte{10, 0, 0, 42})))
+
+	if transactions := dt.Transactions(start); len(transactions) != 0 {
+		t.Fatalf("Transaction should not be completed before the acknowledgement: %+v", transactions)
+	}
+
+	dt.Observe(dhcpPacket(t, start.Add(time.Second), 1, layers.DHCPMsgTypeAck, serverOptions...))
+
+	transactions := dt.Transactions(start.Add(time.Second))
+	if len(transactions) != 1 {
+		t.Fatalf("Expected one transaction, got %+v", transactions)
+	}
+
+	tr := transactions[0]
+	if tr.ClientMAC != dhcpClientMAC.String() || tr.Hostname != "myhost" || tr.RequestedIP != "10.0.0.42" || tr.OfferedIP != "10.0.0.42" {
+		t.Errorf("Wrong client of the transaction: %+v", tr)
+	}
+	if tr.VendorClass != "MSFT 5.0" || tr.ParameterList != "1,3,6,15" {
+		t.Errorf("Wrong fingerprint of the client: %+v", tr)
+	}
+	if tr.Server != "10.0.0.1" || tr.State != "ACK" || tr.LeaseTime != 3600 || tr.Last-tr.Start != 1000 {
+		t.Errorf("Wrong server reply of the transaction: %+v", tr)
+	}
+	if expected := []string{"DISCOVER", "OFFER", "REQUEST", "ACK"}; !reflect.DeepEqual(tr.Messages, expected) {
+		t.Errorf("Expected messages %v, got %v", expected, tr.Messages)
+	}
+	if tr.Options["Router"] != "10.0.0.254" || tr.Options["DNS"] != "10.0.0.2,10.0.0.3" {
+		t.Errorf("Wrong options of the server reply: %+v", tr.Options)
+	}
+
+	// an offer never requested is reported once timed out
+	dt.Observe(dhcpPacket(t, start, 2, layers.DHCPMsgTypeDiscover))
+	dt.Observe(dhcpPacket(t, start, 2, layers.DHCPMsgTypeOffer, serverOptions...))
+	if transactions := dt.Transactions(start.Add(time.Second)); len(transactions) != 0 {
+		t.Errorf("Pending transaction reported before its timeout: %+v", transactions)
+	}
+	if transactions := dt.Transactions(start.Add(2 * dhcpTransactionTimeout)); len(transactions) != 1 || transactions[0].State != "OFFER" {
+		t.Errorf("Expected the timed out offer, got %+v", transactions)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"errors"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/config"
+)
+
+// DirectionMode defines how the A side of a new flow is chosen
+type DirectionMode int
+
+const (
+	// FirstPacketDirectionMode uses the sender of the first packet seen as A side
+	FirstPacketDirectionMode DirectionMode = iota
+	// AutoDirectionMode uses the TCP handshake, the ICMP echo requests and the
+	// well-known ports to find the initiator of the flow, falling back on the
+	// first packet seen
+	AutoDirectionMode
+)
+
+// DefaultDirectionMode is the direction mode used when not configured
+const DefaultDirectionMode = FirstPacketDirectionMode
+
+// ErrUnknownDirectionMode is returned for an unknown direction mode name
+var ErrUnknownDirectionMode = errors.New("DirectionMode unknown")
+
+func (d DirectionMode) String() string {
+	if d == AutoDirectionMode {
+		return "auto"
+	}
+	return "first-packet"
+}
+
+// DirectionModeByName returns the direction mode matching the given name
+func DirectionModeByName(name string) (DirectionMode, error) {
+	switch name {
+	case "first-packet":
+		return FirstPacketDirectionMode, nil
+	case "auto":
+		return AutoDirectionMode, nil
+	}
+	return DefaultDirectionMode, ErrUnknownDirectionMode
+}
+
+// DefaultDirectionModeName returns the direction mode set in the configuration
+func DefaultDirectionModeName() string {
+	mode := config.GetString("flow.direction")
+	if mode == "" {
+		mode = DefaultDirectionMode.String()
+	}
+	return mode
+}
+
+// isServerPort returns whether the port is reserved to a service, either
+// through the application port mapping or by being a privileged port
+func isServerPort(port int, apps map[int]string) bool {
+	if _, ok := apps[port]; ok {
+		return true
+	}
+	return port > 0 && port < 1024
+}
+
+// serverPortDirection returns whether the source of a packet is the client,
+// the only side not using a server port
+func serverPortDirection(srcPort, dstPort int, apps map[int]string) (fromInitiator bool, found bool) {
+	srcServer, dstServer := isServerPort(srcPort, apps), isServerPort(dstPort, apps)
+	if srcServer == dstServer {
+		// a mapped port beats a privileged one, two mapped ports being ambiguous
+		_, srcMapped := apps[srcPort]
+		_, dstMapped := apps[dstPort]
+		if srcMapped == dstMapped {
+			return false, false
+		}
+		return dstMapped, true
+	}
+	return dstServer, true
+}
+
+// packetDirection returns whether the packet has been sent by the initiator
+// of the conversation and the way it has been found out
+func packetDirection(packet *Packet, opts FlowOpts) (fromInitiator bool, direction FlowDirection, found bool) {
+	if layer, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		if layer.SYN {
+			return !layer.ACK, FlowDirection_TCP_HANDSHAKE, true
+		}
+
+		var apps map[int]string
+		if opts.AppPortMap != nil {
+			apps = opts.AppPortMap.TCP
+		}
+		if fromInitiator, found := serverPortDirection(int(layer.SrcPort), int(layer.DstPort), apps); found {
+			return fromInitiator, FlowDirection_WELL_KNOWN_PORT, true
+		}
+		return false, FlowDirection_FIRST_PACKET, false
+	}
+
+	if layer, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		var apps map[int]string
+		if opts.AppPortMap != nil {
+			apps = opts.AppPortMap.UDP
+		}
+		if fromInitiator, found := serverPortDirection(int(layer.SrcPort), int(layer.DstPort), apps); found {
+			return fromInitiator, FlowDirection_WELL_KNOWN_PORT, true
+		}
+		return false, FlowDirection_FIRST_PACKET, false
+	}
+
+	if layer, ok := packet.Layer(layers.LayerTypeICMPv4).(*ICMPv4); ok {
+		switch layer.TypeCode.Type() {
+		case layers.ICMPv4TypeEchoRequest:
+			return true, FlowDirection_ICMP_ECHO, true
+		case layers.ICMPv4TypeEchoReply:
+			return false, FlowDirection_ICMP_ECHO, true
+		}
+	}
+
+	if layer, ok := packet.Layer(layers.LayerTypeICMPv6).(*ICMPv6); ok {
+		switch layer.TypeCode.Type() {
+		case layers.ICMPv6TypeEchoRequest:
+			return true, FlowDirection_ICMP_ECHO, true
+		case layers.ICMPv6TypeEchoReply:
+			return false, FlowDirection_ICMP_ECHO, true
+		}
+	}
+
+	return false, FlowDirection_FIRST_PACKET, false
+}
+
+// directionConfidence returns the confidence given by a direction heuristic
+func directionConfidence(direction FlowDirection) FlowDirectionConfidence {
+	switch direction {
+	case FlowDirection_TCP_HANDSHAKE, FlowDirection_ICMP_ECHO:
+		return FlowDirectionConfidence_HIGH
+	case FlowDirection_WELL_KNOWN_PORT:
+		return FlowDirectionConfidence_MEDIUM
+	}
+	return FlowDirectionConfidence_LOW
+}
+
+// initDirection sets the direction of a flow out of its first packet. With
+// the first packet mode, the confidence tells whether the heuristics agree
+// with the sender of the first packet being the initiator.
+func (f *Flow) initDirection(packet *Packet, opts FlowOpts) {
+	fromInitiator, direction, found := packetDirection(packet, opts)
+
+	f.Direction = FlowDirection_FIRST_PACKET
+	f.DirectionConfidence = FlowDirectionConfidence_LOW
+
+	switch {
+	case !found:
+	case opts.DirectionMode == AutoDirectionMode:
+		f.Direction = direction
+		f.DirectionConfidence = directionConfidence(direction)
+		if !fromInitiator {
+			f.reverse()
+		}
+	case fromInitiator:
+		f.DirectionConfidence = directionConfidence(direction)
+	}
+}
+
+// reverse swaps the A and B sides of the flow layers
+func (f *Flow) reverse() {
+	if f.Link != nil {
+		f.Link.A, f.Link.B = f.Link.B, f.Link.A
+	}
+	if f.Network != nil {
+		f.Network.A, f.Network.B = f.Network.B, f.Network.A
+	}
+	if f.Transport != nil {
+		f.Transport.A, f.Transport.B = f.Transport.B, f.Transport.A
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func tcpDirectionPacket(t *testing.T, srcPort, dstPort layers.TCPPort, syn, ack bool) *Packet {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &layers.TCP{SrcPort: srcPort, DstPort: dstPort, SYN: syn, ACK: ack, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buffer := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opts, eth, ip, tcp); err != nil {
+		t.Fatal(err)
+	}
+
+	gp := gopacket.NewPacket(buffer.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	gp.Metadata().CaptureInfo.Timestamp = time.Unix(1500000000, 0)
+
+	ps := PacketSeqFromGoPacket(gp, 0, nil, nil)
+	if len(ps.Packets) != 1 {
+		t.Fatalf("Expected one packet, got %d", len(ps.Packets))
+	}
+	return ps.Packets[0]
+}
+
+func TestFlowDirection(t *testing.T) {
+	apps := &ApplicationPortMap{TCP: map[int]string{8080: "HTTP"}}
+
+	tests := []struct {
+		name       string
+		mode       DirectionMode
+		srcPort    layers.TCPPort
+		dstPort    layers.TCPPort
+		syn, ack   bool
+		reversed   bool
+		direction  FlowDirection
+		confidence FlowDirectionConfidence
+	}{
+		{"syn", AutoDirectionMode, 34567, 80, true, false, false, FlowDirection_TCP_HANDSHAKE, FlowDirectionConfidence_HIGH},
+		{"syn-ack", AutoDirectionMode, 80, 34567, true, true, true, FlowDirection_TCP_HANDSHAKE, FlowDirectionConfidence_HIGH},
+		{"mid-stream from server", AutoDirectionMode, 80, 34567, false, true, true, FlowDirection_WELL_KNOWN_PORT, FlowDirectionConfidence_MEDIUM},
+		{"mid-stream from client", AutoDirectionMode, 34567, 22, false, true, false, FlowDirection_WELL_KNOWN_PORT, FlowDirectionConfidence_MEDIUM},
+		{"mapped port", AutoDirectionMode, 8080, 443, false, true, true, FlowDirection_WELL_KNOWN_PORT, FlowDirectionConfidence_MEDIUM},
+		{"ephemeral ports", AutoDirectionMode, 34567, 40000, false, true, false, FlowDirection_FIRST_PACKET, FlowDirectionConfidence_LOW},
+		{"first packet syn", FirstPacketDirectionMode, 34567, 80, true, false, false, FlowDirection_FIRST_PACKET, FlowDirectionConfidence_HIGH},
+		{"first packet syn-ack", FirstPacketDirectionMode, 80, 34567, true, true, false, FlowDirection_FIRST_PACKET, FlowDirectionConfidence_LOW},
+	}
+
+	for _, test := range tests {
+		opts := FlowOpts{DirectionMode: test.mode, AppPortMap: apps}
+		packet := tcpDirectionPacket(t, test.srcPort, test.dstPort, test.syn, test.ack)
+
+		f := NewFlow()
+		f.initFromPacket(packet.Key("", opts), packet, "", FlowUUIDs{}, opts)
+
+		if f.Direction != test.direction || f.DirectionConfidence != test.confidence {
+			t.Errorf("%s: expected direction %s/%s, got %s/%s", test.name, test.direction, test.confidence, f.Direction, f.DirectionConfidence)
+		}
+
+		mac, ip, port, packets := "00:00:00:00:00:01", "10.0.0.1", int64(test.srcPort), f.Metric.ABPackets
+		if test.reversed {
+			mac, ip, port, packets = "00:00:00:00:00:02", "10.0.0.2", int64(test.dstPort), f.Metric.BAPackets
+		}
+		if f.Link.A != mac || f.Network.A != ip || f.Transport.A != port {
+			t.Errorf("%s: wrong A side %s %s %d", test.name, f.Link.A, f.Network.A, f.Transport.A)
+		}
+		if packets != 1 {
+			t.Errorf("%s: packet accounted in the wrong direction: %+v", test.name, f.Metric)
+		}
+	}
+}
+
+func TestICMPFlowDirection(t *testing.T) {
+	flows := flowsFromPCAP(t, "pcaptraces/icmpv4-symetric.pcap", layers.LinkTypeEthernet, nil, TableOpts{DirectionMode: AutoDirectionMode})
+	if len(flows) == 0 {
+		t.Fatal("No flow found")
+	}
+
+	for _, f := range flows {
+		if f.Direction != FlowDirection_ICMP_ECHO || f.DirectionConfidence != FlowDirectionConfidence_HIGH {
+			t.Errorf("Echo flows should have been oriented by their requests: %s/%s", f.Direction, f.DirectionConfidence)
+		}
+	}
+}
+
+func TestDirectionModeByName(t *testing.T) {
+	for _, mode := range []DirectionMode{FirstPacketDirectionMode, AutoDirectionMode} {
+		if m, err := DirectionModeByName(mode.String()); err != nil || m != mode {
+			t.Errorf("Direction mode %s not parsed back: %v", mode, err)
+		}
+	}
+	if _, err := DirectionModeByName("syn"); err != ErrUnknownDirectionMode {
+		t.Error("Unknown direction modes should be rejected")
+	}
+}
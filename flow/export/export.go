@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+)
+
+// Export formats
+const (
+	Parquet = "parquet"
+	IPFIX   = "ipfix"
+)
+
+// pageSize is the number of flows retrieved at once from the storage
+const pageSize = 10000
+
+// Writer describes a file writer of flows
+type Writer interface {
+	Write(f *flow.Flow) error
+	Close() error
+}
+
+// NewWriter returns a writer of flows in the given format
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case Parquet:
+		return NewParquetWriter(w, DefaultRowGroupSize)
+	case IPFIX:
+		return NewIPFIXWriter(w, 0), nil
+	default:
+		return nil, fmt.Errorf("Unknown export format %s", format)
+	}
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	if format == IPFIX {
+		return "application/ipfix"
+	}
+	return "application/vnd.apache.parquet"
+}
+
+// Flows writes the stored flows active between from and to, in
+// milliseconds, and matching the filter if any. The writer is closed once
+// all the flows got written.
+func Flows(w Writer, store storage.Storage, from, to int64, filter *filters.Filter) (int, error) {
+	f := filters.NewFilterActiveIn(filters.Range{From: from, To: to}, "")
+	if filter != nil {
+		f = filters.NewAndFilter(f, filter)
+	}
+
+	var count int
+	for offset := int64(0); ; offset += pageSize {
+		fsq := filters.SearchQuery{
+			Filter:          f,
+			PaginationRange: &filters.Range{From: offset, To: offset + pageSize},
+			Sort:            true,
+			SortBy:          "Start",
+		}
+
+		flowset, err := store.SearchFlows(fsq)
+		if err != nil {
+			return count, err
+		}
+
+		for _, fl := range flowset.Flows {
+			if err := w.Write(fl); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		if len(flowset.Flows) < pageSize {
+			break
+		}
+	}
+
+	return count, w.Close()
+}
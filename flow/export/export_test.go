@@ -0,0 +1,166 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"testing"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+)
+
+type fakeStorage struct {
+	flows []*flow.Flow
+}
+
+func (s *fakeStorage) Start() {}
+func (s *fakeStorage) Stop()  {}
+
+func (s *fakeStorage) Ping() error { return nil }
+
+func (s *fakeStorage) StoreFlows(flows []*flow.Flow) error { return nil }
+
+func (s *fakeStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+	from, to := fsq.PaginationRange.From, fsq.PaginationRange.To
+	if from > int64(len(s.flows)) {
+		from = int64(len(s.flows))
+	}
+	if to > int64(len(s.flows)) {
+		to = int64(len(s.flows))
+	}
+	return &flow.FlowSet{Flows: s.flows[from:to]}, nil
+}
+
+func (s *fakeStorage) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
+	return nil, nil
+}
+
+func (s *fakeStorage) SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error) {
+	return nil, nil
+}
+
+func testFlows(n int) []*flow.Flow {
+	flows := make([]*flow.Flow, n)
+	for i := range flows {
+		flows[i] = &flow.Flow{
+			UUID:       strconv.Itoa(i),
+			LayersPath: "Ethernet/IPv4/TCP",
+			Link:       &flow.FlowLayer{Protocol: flow.FlowProtocol_ETHERNET, A: "52:54:00:00:00:01", B: "52:54:00:00:00:02"},
+			Network:    &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "10.0.0.1", B: "10.0.0.2"},
+			Transport:  &flow.TransportLayer{Protocol: flow.FlowProtocol_TCP, A: 43210, B: 80},
+			Metric:     &flow.FlowMetric{ABPackets: 2, ABBytes: 120, BAPackets: 1, BABytes: 60},
+			Start:      1000,
+			Last:       2000,
+		}
+	}
+	return flows
+}
+
+func TestCompact(t *testing.T) {
+	c := &compact{}
+	c.begin(0)
+	c.i32(1, 1)
+	c.i64(20, -1)
+	c.list(21, thriftBinary, 1)
+	c.str("a")
+	c.end()
+
+	expected := []byte{0x15, 0x02, 0x06, 0x28, 0x01, 0x19, 0x18, 0x01, 'a', 0x00}
+	if !bytes.Equal(c.Bytes(), expected) {
+		t.Errorf("Expected %v, got %v", expected, c.Bytes())
+	}
+}
+
+func TestParquet(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewParquetWriter(&buf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := Flows(w, &fakeStorage{flows: testFlows(5)}, 0, 3000, nil)
+	if err != nil || count != 5 {
+		t.Fatalf("Expected 5 exported flows, got %d: %v", count, err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, parquetMagic) || !bytes.HasSuffix(data, parquetMagic) {
+		t.Fatal("Expected the file to be framed by the Parquet magic")
+	}
+
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if size <= 0 || size > len(data)-12 {
+		t.Fatalf("Invalid footer size %d", size)
+	}
+
+	footer := data[len(data)-8-size : len(data)-8]
+	for _, column := range Schema {
+		if !bytes.Contains(footer, []byte(column.Name)) {
+			t.Errorf("Expected the column %s in the footer", column.Name)
+		}
+	}
+
+	if len(w.rowGroups) != 3 || w.rowCounts[2] != 1 {
+		t.Errorf("Expected 3 row groups, got %v", w.rowCounts)
+	}
+}
+
+func TestIPFIX(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewIPFIXWriter(&buf, 1)
+
+	count, err := Flows(w, &fakeStorage{flows: testFlows(3)}, 0, 3000, nil)
+	if err != nil || count != 3 {
+		t.Fatalf("Expected 3 exported flows, got %d: %v", count, err)
+	}
+
+	data := buf.Bytes()
+	if version := binary.BigEndian.Uint16(data[0:]); version != ipfixVersion {
+		t.Fatalf("Expected IPFIX version 10, got %d", version)
+	}
+	if length := binary.BigEndian.Uint16(data[2:]); int(length) != len(data) {
+		t.Fatalf("Expected a message length of %d, got %d", len(data), length)
+	}
+
+	var templates, records int
+	for set := data[ipfixHeaderSize:]; len(set) > 0; {
+		id, length := binary.BigEndian.Uint16(set[0:]), binary.BigEndian.Uint16(set[2:])
+		switch id {
+		case ipfixTemplateSetID:
+			templates++
+		case ipfixIPv4Template:
+			records += (int(length) - 4) / 57
+		default:
+			t.Errorf("Unexpected set %d", id)
+		}
+		set = set[length:]
+	}
+
+	if templates != 1 || records != 6 {
+		t.Errorf("Expected a template set and 6 records, got %d and %d", templates, records)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+// IPFIX set and template identifiers
+const (
+	ipfixVersion        = 10
+	ipfixHeaderSize     = 16
+	ipfixMaxMessageSize = 65535
+	ipfixTemplateSetID  = 2
+)
+
+const (
+	ipfixL2Template = 256 + iota
+	ipfixIPv4Template
+	ipfixIPv6Template
+)
+
+type ipfixField struct {
+	id     uint16
+	length uint16
+}
+
+// IPFIX information elements, RFC 5102
+var (
+	ipfixStart     = ipfixField{152, 8} // flowStartMilliseconds
+	ipfixEnd       = ipfixField{153, 8} // flowEndMilliseconds
+	ipfixSrcMAC    = ipfixField{56, 6}  // sourceMacAddress
+	ipfixDstMAC    = ipfixField{80, 6}  // destinationMacAddress
+	ipfixSrcIPv4   = ipfixField{8, 4}   // sourceIPv4Address
+	ipfixDstIPv4   = ipfixField{12, 4}  // destinationIPv4Address
+	ipfixSrcIPv6   = ipfixField{27, 16} // sourceIPv6Address
+	ipfixDstIPv6   = ipfixField{28, 16} // destinationIPv6Address
+	ipfixProtocol  = ipfixField{4, 1}   // protocolIdentifier
+	ipfixSrcPort   = ipfixField{7, 2}   // sourceTransportPort
+	ipfixDstPort   = ipfixField{11, 2}  // destinationTransportPort
+	ipfixOctets    = ipfixField{1, 8}   // octetDeltaCount
+	ipfixPackets   = ipfixField{2, 8}   // packetDeltaCount
+	ipfixTemplates = map[uint16][]ipfixField{
+		ipfixL2Template:   {ipfixStart, ipfixEnd, ipfixSrcMAC, ipfixDstMAC, ipfixOctets, ipfixPackets},
+		ipfixIPv4Template: {ipfixStart, ipfixEnd, ipfixSrcMAC, ipfixDstMAC, ipfixSrcIPv4, ipfixDstIPv4, ipfixProtocol, ipfixSrcPort, ipfixDstPort, ipfixOctets, ipfixPackets},
+		ipfixIPv6Template: {ipfixStart, ipfixEnd, ipfixSrcMAC, ipfixDstMAC, ipfixSrcIPv6, ipfixDstIPv6, ipfixProtocol, ipfixSrcPort, ipfixDstPort, ipfixOctets, ipfixPackets},
+	}
+)
+
+// IPFIXWriter writes flows as an IPFIX file, RFC 5655, the templates being
+// sent in the first message. IPFIX records being unidirectional, a flow
+// gives a record per direction having seen packets.
+type IPFIXWriter struct {
+	w        io.Writer
+	domainID uint32
+	sequence uint32
+	records  uint32
+	message  bytes.Buffer
+	set      bytes.Buffer
+	setID    uint16
+}
+
+func (p *IPFIXWriter) closeSet() {
+	if p.set.Len() == 0 {
+		return
+	}
+
+	var header [4]byte
+	binary.BigEndian.PutUint16(header[:2], p.setID)
+	binary.BigEndian.PutUint16(header[2:], uint16(4+p.set.Len()))
+	p.message.Write(header[:])
+	p.message.Write(p.set.Bytes())
+	p.set.Reset()
+}
+
+func (p *IPFIXWriter) flush() error {
+	p.closeSet()
+	if p.message.Len() == 0 {
+		return nil
+	}
+
+	var header [ipfixHeaderSize]byte
+	binary.BigEndian.PutUint16(header[0:], ipfixVersion)
+	binary.BigEndian.PutUint16(header[2:], uint16(ipfixHeaderSize+p.message.Len()))
+	binary.BigEndian.PutUint32(header[4:], uint32(time.Now().Unix()))
+	binary.BigEndian.PutUint32(header[8:], p.sequence)
+	binary.BigEndian.PutUint32(header[12:], p.domainID)
+
+	p.sequence += p.records
+	p.records = 0
+
+	defer p.message.Reset()
+	if _, err := p.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := p.w.Write(p.message.Bytes())
+	return err
+}
+
+func (p *IPFIXWriter) add(setID uint16, record []byte, data bool) error {
+	if p.setID != setID {
+		p.closeSet()
+		p.setID = setID
+	}
+
+	if ipfixHeaderSize+p.message.Len()+4+p.set.Len()+len(record) > ipfixMaxMessageSize {
+		if err := p.flush(); err != nil {
+			return err
+		}
+		p.setID = setID
+	}
+
+	p.set.Write(record)
+	if data {
+		p.records++
+	}
+	return nil
+}
+
+func ipfixProtocolNumber(f *flow.Flow) byte {
+	if f.Transport != nil {
+		switch f.Transport.Protocol {
+		case flow.FlowProtocol_TCP:
+			return 6
+		case flow.FlowProtocol_UDP:
+			return 17
+		case flow.FlowProtocol_SCTP:
+			return 132
+		}
+	}
+	if f.ICMP != nil {
+		if network(f).Protocol == flow.FlowProtocol_IPV6 {
+			return 58
+		}
+		return 1
+	}
+	return 0
+}
+
+func ipfixRecord(f *flow.Flow, template uint16, reverse bool) []byte {
+	var record bytes.Buffer
+	u16 := func(v uint16) { binary.Write(&record, binary.BigEndian, v) }
+	u64 := func(v int64) { binary.Write(&record, binary.BigEndian, v) }
+	mac := func(s string) {
+		hw, err := net.ParseMAC(s)
+		if err != nil || len(hw) != 6 {
+			hw = make(net.HardwareAddr, 6)
+		}
+		record.Write(hw)
+	}
+	ip := func(s string, size int) {
+		addr := net.ParseIP(s)
+		if size == net.IPv4len {
+			addr = addr.To4()
+		}
+		if len(addr) != size {
+			addr = make(net.IP, size)
+		}
+		record.Write(addr)
+	}
+
+	u64(f.Start)
+	u64(f.Last)
+
+	l, n, t, m := link(f), network(f), transport(f), metric(f)
+	srcMAC, dstMAC, srcIP, dstIP, srcPort, dstPort := l.A, l.B, n.A, n.B, t.A, t.B
+	octets, packets := m.ABBytes, m.ABPackets
+	if reverse {
+		srcMAC, dstMAC, srcIP, dstIP, srcPort, dstPort = l.B, l.A, n.B, n.A, t.B, t.A
+		octets, packets = m.BABytes, m.BAPackets
+	}
+
+	mac(srcMAC)
+	mac(dstMAC)
+	if template != ipfixL2Template {
+		size := net.IPv4len
+		if template == ipfixIPv6Template {
+			size = net.IPv6len
+		}
+		ip(srcIP, size)
+		ip(dstIP, size)
+		record.WriteByte(ipfixProtocolNumber(f))
+		u16(uint16(srcPort))
+		u16(uint16(dstPort))
+	}
+	u64(octets)
+	u64(packets)
+
+	return record.Bytes()
+}
+
+// Write adds the records of a flow, one per direction having seen packets
+func (p *IPFIXWriter) Write(f *flow.Flow) error {
+	template := uint16(ipfixL2Template)
+	if f.Network != nil {
+		switch f.Network.Protocol {
+		case flow.FlowProtocol_IPV4:
+			template = ipfixIPv4Template
+		case flow.FlowProtocol_IPV6:
+			template = ipfixIPv6Template
+		}
+	}
+
+	m := metric(f)
+	if m.ABPackets > 0 || m.BAPackets == 0 {
+		if err := p.add(template, ipfixRecord(f, template, false), true); err != nil {
+			return err
+		}
+	}
+	if m.BAPackets > 0 {
+		return p.add(template, ipfixRecord(f, template, true), true)
+	}
+	return nil
+}
+
+// Close writes the pending records
+func (p *IPFIXWriter) Close() error {
+	return p.flush()
+}
+
+// NewIPFIXWriter returns a writer of an IPFIX file of flows for the given
+// observation domain
+func NewIPFIXWriter(w io.Writer, domainID uint32) *IPFIXWriter {
+	p := &IPFIXWriter{w: w, domainID: domainID}
+
+	for _, id := range []uint16{ipfixL2Template, ipfixIPv4Template, ipfixIPv6Template} {
+		var record bytes.Buffer
+		fields := ipfixTemplates[id]
+		binary.Write(&record, binary.BigEndian, []uint16{id, uint16(len(fields))})
+		for _, field := range fields {
+			binary.Write(&record, binary.BigEndian, []uint16{field.id, field.length})
+		}
+		p.add(ipfixTemplateSetID, record.Bytes(), false)
+	}
+
+	return p
+}
@@ -0,0 +1,323 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/version"
+)
+
+// DefaultRowGroupSize is the default number of flows of a Parquet row group
+const DefaultRowGroupSize = 10000
+
+var parquetMagic = []byte("PAR1")
+
+// Parquet physical, converted and thrift compact protocol types
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetDataPage     = 0
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetRequired     = 0
+
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// compact encodes the Parquet metadata with the thrift compact protocol
+type compact struct {
+	bytes.Buffer
+	last  int16
+	stack []int16
+}
+
+func (c *compact) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	c.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (c *compact) zigzag(v int64) {
+	c.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (c *compact) field(t byte, id int16) {
+	if d := id - c.last; d > 0 && d <= 15 {
+		c.WriteByte(byte(d)<<4 | t)
+	} else {
+		c.WriteByte(t)
+		c.zigzag(int64(id))
+	}
+	c.last = id
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(thriftI32, id)
+	c.zigzag(int64(v))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(thriftI64, id)
+	c.zigzag(v)
+}
+
+func (c *compact) str(s string) {
+	c.uvarint(uint64(len(s)))
+	c.WriteString(s)
+}
+
+func (c *compact) binary(id int16, s string) {
+	c.field(thriftBinary, id)
+	c.str(s)
+}
+
+func (c *compact) list(id int16, elem byte, size int) {
+	c.field(thriftList, id)
+	if size < 15 {
+		c.WriteByte(byte(size)<<4 | elem)
+	} else {
+		c.WriteByte(0xf0 | elem)
+		c.uvarint(uint64(size))
+	}
+}
+
+// begin starts a struct, either a field, a list element or the top level one
+func (c *compact) begin(id int16) {
+	if id != 0 {
+		c.field(thriftStruct, id)
+	}
+	c.stack = append(c.stack, c.last)
+	c.last = 0
+}
+
+func (c *compact) end() {
+	c.WriteByte(0)
+	c.last = c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+// ParquetWriter writes flows as a Parquet file following the export Schema.
+// The columns are required, PLAIN encoded and not compressed, each column
+// chunk of a row group being a single data page.
+type ParquetWriter struct {
+	w            io.Writer
+	offset       int64
+	rowGroupSize int
+	rows         []*flow.Flow
+	numRows      int64
+	rowGroups    [][]parquetChunk
+	rowCounts    []int64
+}
+
+func (p *ParquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+func physicalType(c Column) int32 {
+	if c.Type == String {
+		return parquetByteArray
+	}
+	return parquetInt64
+}
+
+func (p *ParquetWriter) flushRowGroup() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+
+	chunks := make([]parquetChunk, len(Schema))
+	for i, column := range Schema {
+		var values bytes.Buffer
+		var b [8]byte
+		for _, f := range p.rows {
+			switch v := column.value(f).(type) {
+			case string:
+				binary.LittleEndian.PutUint32(b[:4], uint32(len(v)))
+				values.Write(b[:4])
+				values.WriteString(v)
+			case int64:
+				binary.LittleEndian.PutUint64(b[:], uint64(v))
+				values.Write(b[:])
+			}
+		}
+
+		header := &compact{}
+		header.begin(0)
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(values.Len()))
+		header.i32(3, int32(values.Len()))
+		header.begin(5)
+		header.i32(1, int32(len(p.rows)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunks[i].offset = p.offset
+		if err := p.write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(values.Bytes()); err != nil {
+			return err
+		}
+		chunks[i].size = p.offset - chunks[i].offset
+	}
+
+	p.rowGroups = append(p.rowGroups, chunks)
+	p.rowCounts = append(p.rowCounts, int64(len(p.rows)))
+	p.rows = p.rows[:0]
+	return nil
+}
+
+// Write adds a flow to the file, a row group being written every
+// rowGroupSize flows
+func (p *ParquetWriter) Write(f *flow.Flow) error {
+	p.rows = append(p.rows, f)
+	p.numRows++
+	if len(p.rows) >= p.rowGroupSize {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+func (p *ParquetWriter) footer() []byte {
+	c := &compact{}
+	c.begin(0)
+	c.i32(1, 1)
+
+	c.list(2, thriftStruct, len(Schema)+1)
+	c.begin(0)
+	c.binary(4, "flow")
+	c.i32(5, int32(len(Schema)))
+	c.end()
+	for _, column := range Schema {
+		c.begin(0)
+		c.i32(1, physicalType(column))
+		c.i32(3, parquetRequired)
+		c.binary(4, column.Name)
+		switch column.Type {
+		case String:
+			c.i32(6, parquetUTF8)
+		case Timestamp:
+			c.i32(6, parquetTimestampMillis)
+		}
+		c.end()
+	}
+
+	c.i64(3, p.numRows)
+
+	c.list(4, thriftStruct, len(p.rowGroups))
+	for i, chunks := range p.rowGroups {
+		var total int64
+		for _, chunk := range chunks {
+			total += chunk.size
+		}
+
+		c.begin(0)
+		c.list(1, thriftStruct, len(chunks))
+		for j, chunk := range chunks {
+			c.begin(0)
+			c.i64(2, chunk.offset)
+			c.begin(3)
+			c.i32(1, physicalType(Schema[j]))
+			c.list(2, thriftI32, 2)
+			c.zigzag(parquetPlain)
+			c.zigzag(parquetRLE)
+			c.list(3, thriftBinary, 1)
+			c.str(Schema[j].Name)
+			c.i32(4, parquetUncompressed)
+			c.i64(5, p.rowCounts[i])
+			c.i64(6, chunk.size)
+			c.i64(7, chunk.size)
+			c.i64(9, chunk.offset)
+			c.end()
+			c.end()
+		}
+		c.i64(2, total)
+		c.i64(3, p.rowCounts[i])
+		c.end()
+	}
+
+	c.list(5, thriftStruct, 1)
+	c.begin(0)
+	c.binary(1, "skydive.schema.version")
+	c.binary(2, SchemaVersion)
+	c.end()
+
+	c.binary(6, "skydive version "+version.Version)
+	c.end()
+
+	return c.Bytes()
+}
+
+// Close writes the pending row group and the file footer
+func (p *ParquetWriter) Close() error {
+	if err := p.flushRowGroup(); err != nil {
+		return err
+	}
+
+	footer := p.footer()
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+
+	for _, b := range [][]byte{footer, size[:], parquetMagic} {
+		if err := p.write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewParquetWriter returns a writer of a Parquet file of flows, the flows
+// being buffered by row groups of rowGroupSize flows
+func NewParquetWriter(w io.Writer, rowGroupSize int) (*ParquetWriter, error) {
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultRowGroupSize
+	}
+
+	p := &ParquetWriter{w: w, rowGroupSize: rowGroupSize}
+	if err := p.write(parquetMagic); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
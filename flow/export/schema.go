@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package export
+
+import (
+	"github.com/skydive-project/skydive/flow"
+)
+
+// SchemaVersion is the version of the export schema, bumped whenever a
+// column is renamed, removed or changes type
+const SchemaVersion = "1"
+
+// ColumnType describes the type of the values of a column
+type ColumnType string
+
+// Column types
+const (
+	// String columns are UTF8 byte arrays, empty when not relevant
+	String ColumnType = "string"
+	// Int64 columns are signed 64 bits integers, 0 when not relevant
+	Int64 ColumnType = "int64"
+	// Timestamp columns are milliseconds since the epoch, as int64
+	Timestamp ColumnType = "timestamp"
+)
+
+// Column describes a column of the exported flows
+type Column struct {
+	Name        string
+	Type        ColumnType
+	Description string
+	value       func(f *flow.Flow) interface{}
+}
+
+func link(f *flow.Flow) *flow.FlowLayer {
+	if f.Link != nil {
+		return f.Link
+	}
+	return &flow.FlowLayer{}
+}
+
+func network(f *flow.Flow) *flow.FlowLayer {
+	if f.Network != nil {
+		return f.Network
+	}
+	return &flow.FlowLayer{}
+}
+
+func transport(f *flow.Flow) *flow.TransportLayer {
+	if f.Transport != nil {
+		return f.Transport
+	}
+	return &flow.TransportLayer{}
+}
+
+func metric(f *flow.Flow) *flow.FlowMetric {
+	if f.Metric != nil {
+		return f.Metric
+	}
+	return &flow.FlowMetric{}
+}
+
+// Schema is the list of the columns of the exported flows, one row per flow
+var Schema = []Column{
+	{"UUID", String, "Flow identifier", func(f *flow.Flow) interface{} { return f.UUID }},
+	{"LayersPath", String, "Layers of the flow, e.g. Ethernet/IPv4/TCP", func(f *flow.Flow) interface{} { return f.LayersPath }},
+	{"Application", String, "Last layer that is not a payload", func(f *flow.Flow) interface{} { return f.Application }},
+	{"NodeTID", String, "TID of the node the flow was captured on", func(f *flow.Flow) interface{} { return f.NodeTID }},
+	{"LinkA", String, "MAC of the A side, the initiator", func(f *flow.Flow) interface{} { return link(f).A }},
+	{"LinkB", String, "MAC of the B side", func(f *flow.Flow) interface{} { return link(f).B }},
+	{"NetworkProtocol", String, "IPV4 or IPV6, empty for the layer 2 flows", func(f *flow.Flow) interface{} { return protocol(f.Network != nil, network(f).Protocol) }},
+	{"NetworkA", String, "IP of the A side", func(f *flow.Flow) interface{} { return network(f).A }},
+	{"NetworkB", String, "IP of the B side", func(f *flow.Flow) interface{} { return network(f).B }},
+	{"TransportProtocol", String, "TCP, UDP or SCTP, empty otherwise", func(f *flow.Flow) interface{} { return protocol(f.Transport != nil, transport(f).Protocol) }},
+	{"TransportA", Int64, "Port of the A side", func(f *flow.Flow) interface{} { return transport(f).A }},
+	{"TransportB", Int64, "Port of the B side", func(f *flow.Flow) interface{} { return transport(f).B }},
+	{"ICMPType", String, "Type of the ICMP flows, e.g. ECHO", func(f *flow.Flow) interface{} {
+		if f.ICMP != nil {
+			return f.ICMP.Type.String()
+		}
+		return ""
+	}},
+	{"ABPackets", Int64, "Packets sent by the A side", func(f *flow.Flow) interface{} { return metric(f).ABPackets }},
+	{"ABBytes", Int64, "Bytes sent by the A side", func(f *flow.Flow) interface{} { return metric(f).ABBytes }},
+	{"BAPackets", Int64, "Packets sent by the B side", func(f *flow.Flow) interface{} { return metric(f).BAPackets }},
+	{"BABytes", Int64, "Bytes sent by the B side", func(f *flow.Flow) interface{} { return metric(f).BABytes }},
+	{"Start", Timestamp, "First packet of the flow", func(f *flow.Flow) interface{} { return f.Start }},
+	{"Last", Timestamp, "Last packet of the flow", func(f *flow.Flow) interface{} { return f.Last }},
+	{"RTT", Int64, "Round trip time of the first exchange, in nanoseconds", func(f *flow.Flow) interface{} { return f.RTT }},
+	{"TrackingID", String, "Identifier shared by the captures of the flow across the infrastructure", func(f *flow.Flow) interface{} { return f.TrackingID }},
+	{"L3TrackingID", String, "Same as TrackingID, ignoring the layer 2", func(f *flow.Flow) interface{} { return f.L3TrackingID }},
+	{"ParentUUID", String, "UUID of the encapsulating flow", func(f *flow.Flow) interface{} { return f.ParentUUID }},
+}
+
+func protocol(set bool, p flow.FlowProtocol) string {
+	if !set {
+		return ""
+	}
+	return p.String()
+}
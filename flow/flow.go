@@ -23,10 +23,13 @@
 package flow
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"reflect"
 	"strconv"
 	"strings"
@@ -47,6 +50,10 @@ var (
 	ErrFlowProtocol = errors.New("FlowProtocol invalid")
 	// ErrLayerNotFound layer not present in the packet
 	ErrLayerNotFound = errors.New("Layer not found")
+	// ErrMalformedPacket packet headers inconsistent with the packet size
+	ErrMalformedPacket = errors.New("Malformed packet")
+	// ErrTooManyEncapsulations packet nesting more tunnels than MaxEncapsulationDepth
+	ErrTooManyEncapsulations = errors.New("Too many encapsulation levels")
 )
 
 const (
@@ -58,6 +65,8 @@ const (
 	MaxRawPacketLimit uint32 = 10
 	// DefaultProtobufFlowSize : the default protobuf size without any raw packet for a flow
 	DefaultProtobufFlowSize = 500
+	// MaxEncapsulationDepth : maximum number of nested tunnels split into packets
+	MaxEncapsulationDepth = 8
 )
 
 // flowState is used internally to track states within the flow table.
@@ -67,6 +76,8 @@ type flowState struct {
 	link1stPacket    int64
 	network1stPacket int64
 	updateVersion    int64
+	httpRequests     []int64
+	http2            *http2State
 }
 
 // Packet describes one packet
@@ -104,12 +115,106 @@ const (
 	L3PreferedKeyMode   LayerKeyMode = 1 // uses Layer3 only and layer2 if no Layer3
 )
 
-// FlowOpts describes options that can be used to process flows
+// KeyFields defines the parts of the packets used to aggregate them in
+// flows, the layer key mode being used for the default key
+type KeyFields uint8
+
+// Fields of the flow key
+const (
+	LinkKeyField KeyFields = 1 << iota
+	NetworkKeyField
+	ProtocolKeyField
+	PortsKeyField
+	ApplicationKeyField
+)
+
+var keyFieldNames = []struct {
+	field KeyFields
+	name  string
+}{
+	{LinkKeyField, "Link"},
+	{NetworkKeyField, "Network"},
+	{ProtocolKeyField, "Protocol"},
+	{PortsKeyField, "Ports"},
+	{ApplicationKeyField, "Application"},
+}
+
+// FlowKeys are the predefined flow keys
+var FlowKeys = map[string]KeyFields{
+	"5-tuple": NetworkKeyField | ProtocolKeyField | PortsKeyField | ApplicationKeyField,
+	"3-tuple": NetworkKeyField | ProtocolKeyField,
+	"ip-pair": NetworkKeyField,
+	"l2-pair": LinkKeyField,
+}
+
+// KeyFieldsByName returns the fields of a predefined flow key or of a comma
+// separated list of fields among Link, Network, Protocol, Ports and
+// Application
+func KeyFieldsByName(name string) (KeyFields, error) {
+	if name == "" {
+		return 0, nil
+	}
+
+	if fields, found := FlowKeys[name]; found {
+		return fields, nil
+	}
+
+	var fields KeyFields
+	for _, field := range strings.Split(name, ",") {
+		var found bool
+		for _, kf := range keyFieldNames {
+			if strings.TrimSpace(field) == kf.name {
+				fields, found = fields|kf.field, true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Unknown flow key field: %s", field)
+		}
+	}
+
+	// ports and application identifiers depend on the transport protocol
+	if fields&(PortsKeyField|ApplicationKeyField) != 0 {
+		fields |= ProtocolKeyField
+	}
+	return fields, nil
+}
+
+func (k KeyFields) String() string {
+	var names []string
+	for _, kf := range keyFieldNames {
+		if k&kf.field != 0 {
+			names = append(names, kf.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// FlowOpts describes options that can be used to process flows. The flow
+// key is defined by the layer key mode unless KeyFields is set.
 type FlowOpts struct {
-	TCPMetric    bool
-	IPDefrag     bool
-	LayerKeyMode LayerKeyMode
-	AppPortMap   *ApplicationPortMap
+	TCPMetric     bool
+	IPDefrag      bool
+	LayerKeyMode  LayerKeyMode
+	KeyFields     KeyFields
+	DirectionMode DirectionMode
+	AppPortMap    *ApplicationPortMap
+}
+
+// keyFields returns the fields of the flow key
+func (o FlowOpts) keyFields() KeyFields {
+	if o.KeyFields != 0 {
+		return o.KeyFields
+	}
+
+	fields := FlowKeys["5-tuple"]
+	if o.LayerKeyMode == L2KeyMode {
+		fields |= LinkKeyField
+	}
+	return fields
+}
+
+func (o FlowOpts) linkKeyed() bool {
+	return o.keyFields()&LinkKeyField != 0
 }
 
 // FlowUUIDs describes UUIDs that can be applied to flows
@@ -200,19 +305,36 @@ func (p *Packet) TransportLayer() gopacket.TransportLayer {
 func (p *Packet) ApplicationFlow() (gopacket.Flow, error) {
 	if layer := p.Layer(layers.LayerTypeICMPv4); layer != nil {
 		l := layer.(*ICMPv4)
-		value32 := make([]byte, 4)
-		binary.BigEndian.PutUint32(value32, uint32(l.Type)<<24|uint32(l.TypeCode.Code())<<16|uint32(l.Id))
-		return gopacket.NewFlow(0, value32, nil), nil
+		return icmpApplicationFlow(l.Type, l.TypeCode.Code(), l.Id, l.Original), nil
 	} else if layer := p.Layer(layers.LayerTypeICMPv6); layer != nil {
 		l := layer.(*ICMPv6)
-		value32 := make([]byte, 4)
-		binary.BigEndian.PutUint32(value32, uint32(l.Type)<<24|uint32(l.TypeCode.Code())<<16|uint32(l.Id))
-		return gopacket.NewFlow(0, value32, nil), nil
+		return icmpApplicationFlow(l.Type, l.TypeCode.Code(), l.Id, l.Original), nil
+	} else if layer := p.Layer(layers.LayerTypeARP); layer != nil {
+		l := layer.(*layers.ARP)
+		if len(l.SourceProtAddress) <= gopacket.MaxEndpointSize && len(l.DstProtAddress) <= gopacket.MaxEndpointSize {
+			return gopacket.NewFlow(0, l.SourceProtAddress, l.DstProtAddress), nil
+		}
 	}
 
 	return gopacket.Flow{}, ErrLayerNotFound
 }
 
+// icmpApplicationFlow returns the ICMP application flow, errors quoting
+// packets of different flows being kept in different flows.
+func icmpApplicationFlow(kind ICMPType, code uint8, id uint16, original *ICMPOriginal) gopacket.Flow {
+	value32 := make([]byte, 4)
+	binary.BigEndian.PutUint32(value32, uint32(kind)<<24|uint32(code)<<16|uint32(id))
+
+	var value64 []byte
+	if original != nil {
+		hasher := murmur3.New64()
+		original.Hash(hasher)
+		value64 = hasher.Sum(nil)
+	}
+
+	return gopacket.NewFlow(0, value32, value64)
+}
+
 // TransportFlow returns first transport flow
 func (p *Packet) TransportFlow() (gopacket.Flow, error) {
 	layer := p.TransportLayer()
@@ -247,26 +369,52 @@ func (p *Packet) TransportFlow() (gopacket.Flow, error) {
 // The unique key is calculated based on parentUUID, network, transport and applicable layers
 func (p *Packet) Key(parentUUID string, opts FlowOpts) string {
 	var uuid uint64
+	fields := opts.keyFields()
 
 	// uses L2 is requested or if there is no network layer
-	if opts.LayerKeyMode == L2KeyMode || p.NetworkLayer() == nil {
+	if fields&LinkKeyField != 0 || p.NetworkLayer() == nil {
 		if layer := p.LinkLayer(); layer != nil {
 			uuid ^= layer.LinkFlow().FastHash()
 		}
 	}
-	if layer := p.NetworkLayer(); layer != nil {
+	if layer := p.NetworkLayer(); layer != nil && fields&NetworkKeyField != 0 {
 		uuid ^= layer.NetworkFlow().FastHash()
 	}
-	if tf, err := p.TransportFlow(); err == nil {
-		uuid ^= tf.FastHash()
+	if fields&PortsKeyField != 0 {
+		if tf, err := p.TransportFlow(); err == nil {
+			uuid ^= tf.FastHash()
+		}
+	} else if fields&ProtocolKeyField != 0 {
+		if pf, err := p.ProtocolFlow(); err == nil {
+			uuid ^= pf.FastHash()
+		}
 	}
-	if af, err := p.ApplicationFlow(); err == nil {
+	if af, err := p.ApplicationFlow(); err == nil && fields&ApplicationKeyField != 0 {
 		uuid ^= af.FastHash()
 	}
 
 	return parentUUID + strconv.FormatUint(uuid, 10)
 }
 
+// ProtocolFlow returns a flow of the transport protocol, or of the ICMP
+// protocol, of the packet
+func (p *Packet) ProtocolFlow() (gopacket.Flow, error) {
+	var layerType gopacket.LayerType
+	if layer := p.TransportLayer(); layer != nil {
+		layerType = layer.LayerType()
+	} else if layer := p.Layer(layers.LayerTypeICMPv4); layer != nil {
+		layerType = layers.LayerTypeICMPv4
+	} else if layer := p.Layer(layers.LayerTypeICMPv6); layer != nil {
+		layerType = layers.LayerTypeICMPv6
+	} else {
+		return gopacket.Flow{}, ErrLayerNotFound
+	}
+
+	value32 := make([]byte, 4)
+	binary.BigEndian.PutUint32(value32, uint32(layerType))
+	return gopacket.NewFlow(0, value32, nil), nil
+}
+
 // Value returns int32 value of a FlowProtocol
 func (p FlowProtocol) Value() int32 {
 	return int32(p)
@@ -363,13 +511,15 @@ func (f *FlowLayer) UnmarshalJSON(b []byte) error {
 // MarshalJSON serialize a ICMPLayer in JSON
 func (i *ICMPLayer) MarshalJSON() ([]byte, error) {
 	obj := &struct {
-		Type string
-		Code uint32
-		ID   uint32
+		Type     string
+		Code     uint32
+		ID       uint32
+		Original *ICMPOriginal `json:",omitempty"`
 	}{
-		Type: i.Type.String(),
-		Code: i.Code,
-		ID:   i.ID,
+		Type:     i.Type.String(),
+		Code:     i.Code,
+		ID:       i.ID,
+		Original: i.Original,
 	}
 
 	return json.Marshal(&obj)
@@ -378,9 +528,10 @@ func (i *ICMPLayer) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON deserialize a JSON object in ICMPLayer
 func (i *ICMPLayer) UnmarshalJSON(b []byte) error {
 	m := struct {
-		Type string
-		Code uint32
-		ID   uint32
+		Type     string
+		Code     uint32
+		ID       uint32
+		Original *ICMPOriginal
 	}{}
 
 	if err := json.Unmarshal(b, &m); err != nil {
@@ -394,6 +545,57 @@ func (i *ICMPLayer) UnmarshalJSON(b []byte) error {
 	i.Type = ICMPType(icmpType)
 	i.Code = m.Code
 	i.ID = m.ID
+	i.Original = m.Original
+
+	return nil
+}
+
+// MarshalJSON serialize a ARPLayer in JSON
+func (a *ARPLayer) MarshalJSON() ([]byte, error) {
+	obj := &struct {
+		Operation  string
+		SenderMAC  string
+		SenderIP   string
+		TargetMAC  string
+		TargetIP   string
+		Gratuitous bool
+	}{
+		Operation:  a.Operation.String(),
+		SenderMAC:  a.SenderMAC,
+		SenderIP:   a.SenderIP,
+		TargetMAC:  a.TargetMAC,
+		TargetIP:   a.TargetIP,
+		Gratuitous: a.Gratuitous,
+	}
+
+	return json.Marshal(&obj)
+}
+
+// UnmarshalJSON deserialize a JSON object in ARPLayer
+func (a *ARPLayer) UnmarshalJSON(b []byte) error {
+	m := struct {
+		Operation  string
+		SenderMAC  string
+		SenderIP   string
+		TargetMAC  string
+		TargetIP   string
+		Gratuitous bool
+	}{}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	operation, ok := ARPOperation_value[m.Operation]
+	if !ok {
+		return ErrFlowProtocol
+	}
+	a.Operation = ARPOperation(operation)
+	a.SenderMAC = m.SenderMAC
+	a.SenderIP = m.SenderIP
+	a.TargetMAC = m.TargetMAC
+	a.TargetIP = m.TargetIP
+	a.Gratuitous = m.Gratuitous
 
 	return nil
 }
@@ -502,13 +704,14 @@ func (f *Flow) UpdateUUID(key string, opts FlowOpts) {
 	hasher := murmur3.New64()
 	f.Network.Hash(hasher)
 	f.ICMP.Hash(hasher)
+	f.ARP.Hash(hasher)
 	f.Transport.Hash(hasher)
 
 	// only need network and transport to compute l3trackingID
 	hasher.Write([]byte(strings.TrimPrefix(layersPath, "Ethernet/")))
 	f.L3TrackingID = hex.EncodeToString(hasher.Sum(nil))
 
-	if opts.LayerKeyMode == L2KeyMode || f.Network == nil {
+	if opts.linkKeyed() || f.Network == nil {
 		f.Link.Hash(hasher)
 	}
 
@@ -579,6 +782,53 @@ func (f *Flow) Init(now int64, nodeTID string, uuids FlowUUIDs) {
 	f.ParentUUID = uuids.ParentUUID
 }
 
+// ShiftTime shifts the timestamps of the flow by the given number of
+// milliseconds, used to compensate the clock skew of the agent it comes from
+func (f *Flow) ShiftTime(ms int64) {
+	shift := func(ts ...*int64) {
+		for _, t := range ts {
+			if *t != 0 {
+				*t += ms
+			}
+		}
+	}
+
+	shift(&f.Start, &f.Last)
+	if f.Metric != nil {
+		shift(&f.Metric.Start, &f.Metric.Last)
+	}
+	if f.LastUpdateMetric != nil {
+		shift(&f.LastUpdateMetric.Start, &f.LastUpdateMetric.Last)
+	}
+	if f.TCPMetric != nil {
+		m := f.TCPMetric
+		shift(&m.ABSynStart, &m.BASynStart, &m.ABFinStart, &m.BAFinStart, &m.ABRstStart, &m.BARstStart)
+	}
+	for _, p := range f.LastRawPackets {
+		shift(&p.Timestamp)
+	}
+}
+
+// Anonymize anonymizes the MAC and the IP addresses of the flow. The raw
+// packets, holding the original addresses, are dropped.
+func (f *Flow) Anonymize(a *common.Anonymizer) {
+	if f.Link != nil {
+		f.Link.A, f.Link.B = a.MAC(f.Link.A), a.MAC(f.Link.B)
+	}
+	if f.Network != nil {
+		f.Network.A, f.Network.B = a.IP(f.Network.A), a.IP(f.Network.B)
+	}
+	if f.ICMP != nil && f.ICMP.Original != nil && f.ICMP.Original.Network != nil {
+		n := f.ICMP.Original.Network
+		n.A, n.B = a.IP(n.A), a.IP(n.B)
+	}
+	if f.ARP != nil {
+		f.ARP.SenderMAC, f.ARP.TargetMAC = a.MAC(f.ARP.SenderMAC), a.MAC(f.ARP.TargetMAC)
+		f.ARP.SenderIP, f.ARP.TargetIP = a.IP(f.ARP.SenderIP), a.IP(f.ARP.TargetIP)
+	}
+	f.LastRawPackets = nil
+}
+
 // initFromPacket initializes the flow based on packet data, flow key and ids
 func (f *Flow) initFromPacket(key string, packet *Packet, nodeTID string, uuids FlowUUIDs, opts FlowOpts) {
 	now := common.UnixMillis(packet.GoPacket.Metadata().CaptureInfo.Timestamp)
@@ -591,7 +841,11 @@ func (f *Flow) initFromPacket(key string, packet *Packet, nodeTID string, uuids
 	// no network layer then no transport layer
 	if err := f.newNetworkLayer(packet); err == nil {
 		f.newTransportLayer(packet, opts)
+	} else {
+		f.newARPLayer(packet)
 	}
+	f.initDirection(packet, opts)
+	f.stripUnkeyedLayers(opts)
 
 	// need to have as most variable filled as possible to get correct UUID
 	f.UpdateUUID(key, opts)
@@ -600,13 +854,33 @@ func (f *Flow) initFromPacket(key string, packet *Packet, nodeTID string, uuids
 	f.Update(packet, opts)
 }
 
+// stripUnkeyedLayers removes the layers, or the parts of layers, which are
+// not part of the flow key as they may differ among the aggregated packets
+func (f *Flow) stripUnkeyedLayers(opts FlowOpts) {
+	fields := opts.keyFields()
+
+	if f.Network != nil && fields&NetworkKeyField == 0 {
+		f.Network, f.IPMetric, f.Transport, f.ICMP = nil, nil, nil, nil
+	}
+	if f.Transport != nil && fields&PortsKeyField == 0 {
+		f.Transport.A, f.Transport.B = 0, 0
+		f.TCPMetric, f.HTTPMetric, f.HTTP2Metric = nil, nil, nil
+	}
+	if fields&ProtocolKeyField == 0 {
+		f.Transport = nil
+	}
+	if fields&ApplicationKeyField == 0 {
+		f.ICMP, f.ARP = nil, nil
+	}
+}
+
 // Update a flow metrics and latency
 func (f *Flow) Update(packet *Packet, opts FlowOpts) {
 	now := common.UnixMillis(packet.GoPacket.Metadata().CaptureInfo.Timestamp)
 	f.Last = now
 	f.Metric.Last = now
 
-	if opts.LayerKeyMode == L3PreferedKeyMode {
+	if !opts.linkKeyed() {
 		// use the ethernet length as we want to get the full size and we want to
 		// rely on the l3 address order.
 		length := packet.Length
@@ -616,15 +890,27 @@ func (f *Flow) Update(packet *Packet, opts FlowOpts) {
 			}
 		}
 
-		f.updateMetricsWithNetworkLayer(packet, length)
+		// L2 only packets like ARP are accounted on their link layer
+		if err := f.updateMetricsWithNetworkLayer(packet, length); err != nil {
+			f.updateMetricsWithLinkLayer(packet)
+		}
 	} else {
 		if updated := f.updateMetricsWithLinkLayer(packet); !updated {
 			f.updateMetricsWithNetworkLayer(packet, 0)
 		}
 	}
+	if f.PayloadTrackingID == "" {
+		f.PayloadTrackingID = payloadTrackingID(packet)
+	}
 	if f.TCPMetric != nil {
 		f.updateTCPMetrics(packet)
 	}
+	if f.HTTPMetric != nil {
+		f.updateHTTPMetrics(packet)
+	}
+	if f.Transport != nil && f.Transport.Protocol == FlowProtocol_TCP && opts.keyFields()&PortsKeyField != 0 {
+		f.updateHTTP2Metrics(packet)
+	}
 }
 
 func (f *Flow) newLinkLayer(packet *Packet) error {
@@ -683,7 +969,9 @@ func (f *Flow) updateMetricsWithLinkLayer(packet *Packet) bool {
 	}
 
 	if f.XXX_state.link1stPacket == 0 {
-		f.XXX_state.link1stPacket = packet.GoPacket.Metadata().Timestamp.UnixNano()
+		if f.Link.A == ethernetPacket.SrcMAC.String() {
+			f.XXX_state.link1stPacket = packet.GoPacket.Metadata().Timestamp.UnixNano()
+		}
 	} else {
 		if (f.RTT == 0) && (f.Link.A == ethernetPacket.DstMAC.String()) {
 			f.RTT = packet.GoPacket.Metadata().Timestamp.UnixNano() - f.XXX_state.link1stPacket
@@ -706,11 +994,7 @@ func (f *Flow) newNetworkLayer(packet *Packet) error {
 
 		icmpLayer := packet.Layer(layers.LayerTypeICMPv4)
 		if layer, ok := icmpLayer.(*ICMPv4); ok {
-			f.ICMP = &ICMPLayer{
-				Code: uint32(layer.TypeCode.Code()),
-				Type: layer.Type,
-				ID:   uint32(layer.Id),
-			}
+			f.ICMP = newICMPLayer(layer.Type, layer.TypeCode.Code(), layer.Id, layer.Original, f.Network.ID)
 		}
 		return nil
 	}
@@ -726,11 +1010,7 @@ func (f *Flow) newNetworkLayer(packet *Packet) error {
 
 		icmpLayer := packet.Layer(layers.LayerTypeICMPv6)
 		if layer, ok := icmpLayer.(*ICMPv6); ok {
-			f.ICMP = &ICMPLayer{
-				Code: uint32(layer.TypeCode.Code()),
-				Type: layer.Type,
-				ID:   uint32(layer.Id),
-			}
+			f.ICMP = newICMPLayer(layer.Type, layer.TypeCode.Code(), layer.Id, layer.Original, f.Network.ID)
 		}
 		return nil
 	}
@@ -738,6 +1018,52 @@ func (f *Flow) newNetworkLayer(packet *Packet) error {
 	return ErrLayerNotFound
 }
 
+func (f *Flow) newARPLayer(packet *Packet) error {
+	arpLayer := packet.Layer(layers.LayerTypeARP)
+	arpPacket, ok := arpLayer.(*layers.ARP)
+	if !ok {
+		return ErrLayerNotFound
+	}
+
+	f.ARP = &ARPLayer{
+		SenderMAC:  net.HardwareAddr(arpPacket.SourceHwAddress).String(),
+		SenderIP:   net.IP(arpPacket.SourceProtAddress).String(),
+		TargetMAC:  net.HardwareAddr(arpPacket.DstHwAddress).String(),
+		TargetIP:   net.IP(arpPacket.DstProtAddress).String(),
+		Gratuitous: bytes.Equal(arpPacket.SourceProtAddress, arpPacket.DstProtAddress),
+	}
+
+	switch arpPacket.Operation {
+	case layers.ARPRequest:
+		f.ARP.Operation = ARPOperation_REQUEST
+	case layers.ARPReply:
+		f.ARP.Operation = ARPOperation_REPLY
+	}
+
+	return nil
+}
+
+func newICMPLayer(kind ICMPType, code uint8, id uint16, original *ICMPOriginal, networkID int64) *ICMPLayer {
+	icmp := &ICMPLayer{
+		Code: uint32(code),
+		Type: kind,
+		ID:   uint32(id),
+	}
+
+	if original != nil {
+		// the quoted packet went through the same tunnel as the error
+		network := *original.Network
+		network.ID = networkID
+
+		icmp.Original = &ICMPOriginal{
+			Network:   &network,
+			Transport: original.Transport,
+		}
+	}
+
+	return icmp
+}
+
 func (f *Flow) updateMetricsWithNetworkLayer(packet *Packet, length int64) error {
 	ipv4Layer := packet.Layer(layers.LayerTypeIPv4)
 	if ipv4Packet, ok := ipv4Layer.(*layers.IPv4); ok {
@@ -754,7 +1080,10 @@ func (f *Flow) updateMetricsWithNetworkLayer(packet *Packet, length int64) error
 
 		// update RTT
 		if f.XXX_state.network1stPacket == 0 {
-			f.XXX_state.network1stPacket = packet.GoPacket.Metadata().Timestamp.UnixNano()
+			// a reversed flow measures from the first packet of its A side
+			if f.Network.A == ipv4Packet.SrcIP.String() {
+				f.XXX_state.network1stPacket = packet.GoPacket.Metadata().Timestamp.UnixNano()
+			}
 		} else {
 			if (f.RTT == 0) && (f.Network.A == ipv4Packet.DstIP.String()) {
 				f.RTT = packet.GoPacket.Metadata().Timestamp.UnixNano() - f.XXX_state.network1stPacket
@@ -777,7 +1106,9 @@ func (f *Flow) updateMetricsWithNetworkLayer(packet *Packet, length int64) error
 
 		// update RTT
 		if f.XXX_state.network1stPacket == 0 {
-			f.XXX_state.network1stPacket = packet.GoPacket.Metadata().Timestamp.UnixNano()
+			if f.Network.A == ipv6Packet.SrcIP.String() {
+				f.XXX_state.network1stPacket = packet.GoPacket.Metadata().Timestamp.UnixNano()
+			}
 		} else {
 			if (f.RTT == 0) && (f.Network.A == ipv6Packet.DstIP.String()) {
 				f.RTT = packet.GoPacket.Metadata().Timestamp.UnixNano() - f.XXX_state.network1stPacket
@@ -789,6 +1120,37 @@ func (f *Flow) updateMetricsWithNetworkLayer(packet *Packet, length int64) error
 	return ErrLayerNotFound
 }
 
+// tcpSynSignature returns the maximum segment size, the window scale and
+// the layout of the options of a SYN packet, identifying its TCP stack
+func tcpSynSignature(tcp *layers.TCP) (mss uint32, wscale uint32, options string) {
+	var kinds []string
+	for _, o := range tcp.Options {
+		switch o.OptionType {
+		case layers.TCPOptionKindEndList:
+			kinds = append(kinds, "E")
+		case layers.TCPOptionKindNop:
+			kinds = append(kinds, "N")
+		case layers.TCPOptionKindMSS:
+			if len(o.OptionData) == 2 {
+				mss = uint32(binary.BigEndian.Uint16(o.OptionData))
+			}
+			kinds = append(kinds, "M")
+		case layers.TCPOptionKindWindowScale:
+			if len(o.OptionData) == 1 {
+				wscale = uint32(o.OptionData[0])
+			}
+			kinds = append(kinds, "W")
+		case layers.TCPOptionKindSACKPermitted:
+			kinds = append(kinds, "S")
+		case layers.TCPOptionKindTimestamps:
+			kinds = append(kinds, "T")
+		default:
+			kinds = append(kinds, strconv.Itoa(int(o.OptionType)))
+		}
+	}
+	return mss, wscale, strings.Join(kinds, ",")
+}
+
 func (f *Flow) updateTCPMetrics(packet *Packet) error {
 	// capture content of SYN packets
 	// bypass if not TCP
@@ -844,11 +1206,15 @@ func (f *Flow) updateTCPMetrics(packet *Packet) error {
 			if f.TCPMetric.ABSynStart == 0 {
 				f.TCPMetric.ABSynStart = captureTime
 				f.TCPMetric.ABSynTTL = timeToLive
+				f.TCPMetric.ABSynWindow = uint32(tcpPacket.Window)
+				f.TCPMetric.ABSynMSS, f.TCPMetric.ABSynWScale, f.TCPMetric.ABSynOptions = tcpSynSignature(tcpPacket)
 			}
 		} else {
 			if f.TCPMetric.BASynStart == 0 {
 				f.TCPMetric.BASynStart = captureTime
 				f.TCPMetric.BASynTTL = timeToLive
+				f.TCPMetric.BASynWindow = uint32(tcpPacket.Window)
+				f.TCPMetric.BASynMSS, f.TCPMetric.BASynWScale, f.TCPMetric.BASynOptions = tcpSynSignature(tcpPacket)
 			}
 		}
 	case tcpPacket.FIN:
@@ -883,6 +1249,13 @@ func (f *Flow) newTransportLayer(packet *Packet, opts FlowOpts) error {
 		if opts.TCPMetric {
 			f.TCPMetric = &TCPMetric{}
 		}
+
+		switch f.Application {
+		case "HTTP":
+			f.HTTPMetric = newHTTPMetric()
+		case "HTTP2", "GRPC":
+			f.HTTP2Metric = &HTTP2Metric{}
+		}
 	} else if layer := packet.Layer(layers.LayerTypeUDP); layer != nil {
 		f.Transport = &TransportLayer{Protocol: FlowProtocol_UDP}
 
@@ -907,8 +1280,31 @@ func (f *Flow) newTransportLayer(packet *Packet, opts FlowOpts) error {
 }
 
 // PacketSeqFromGoPacket split original packet into multiple packets in
-// case of encapsulation like GRE, VXLAN, etc.
+// case of encapsulation like GRE, VXLAN, etc. Malformed packets lead to an
+// empty sequence.
 func PacketSeqFromGoPacket(packet gopacket.Packet, outerLength int64, bpf *BPF, defragger *IPDefragger) *PacketSequence {
+	ps, err := packetSeqFromGoPacket(packet, outerLength, bpf, defragger)
+	if err != nil {
+		logging.GetLogger().Debugf("Packet rejected, %s : %s\n", err, packet.Dump())
+		return &PacketSequence{}
+	}
+	return ps
+}
+
+// validateLayers rejects the network layers that gopacket kept while
+// failing to decode them, like IPv4 headers with a bogus IHL.
+func validateLayers(packetLayers []gopacket.Layer) error {
+	for _, layer := range packetLayers {
+		if ipv4Packet, ok := layer.(*layers.IPv4); ok {
+			if ipv4Packet.IHL < 5 || (ipv4Packet.Length != 0 && int(ipv4Packet.Length) < int(ipv4Packet.IHL)*4) {
+				return ErrMalformedPacket
+			}
+		}
+	}
+	return nil
+}
+
+func packetSeqFromGoPacket(packet gopacket.Packet, outerLength int64, bpf *BPF, defragger *IPDefragger) (*PacketSequence, error) {
 	ps := &PacketSequence{}
 
 	// defragment and set ip metric if requested
@@ -916,7 +1312,7 @@ func PacketSeqFromGoPacket(packet gopacket.Packet, outerLength int64, bpf *BPF,
 	if defragger != nil {
 		m, ok := defragger.Defrag(packet)
 		if !ok {
-			return ps
+			return ps, nil
 		}
 		ipMetric = m
 	}
@@ -927,19 +1323,25 @@ func PacketSeqFromGoPacket(packet gopacket.Packet, outerLength int64, bpf *BPF,
 
 	if packet.LinkLayer() == nil && packet.NetworkLayer() == nil {
 		logging.GetLogger().Debugf("Unknown packet : %s\n", packet.Dump())
-		return ps
+		return ps, nil
 	}
 
 	packetData := packet.Data()
 	if bpf != nil && !bpf.Matches(packetData) {
-		return ps
+		return ps, nil
 	}
 
 	packetLayers := packet.Layers()
+	if err := validateLayers(packetLayers); err != nil {
+		return ps, err
+	}
 	metadata := packet.Metadata()
 
 	var topLayer = packetLayers[0]
 
+	// the IPv6 payload length doesn't account for the fixed header
+	var ipv6HeaderLength int
+
 	if outerLength == 0 {
 		if ethernetPacket, ok := topLayer.(*layers.Ethernet); ok {
 			if metadata != nil && metadata.Length > 0 {
@@ -951,16 +1353,20 @@ func PacketSeqFromGoPacket(packet gopacket.Packet, outerLength int64, bpf *BPF,
 			outerLength = int64(ipv4Packet.Length)
 		} else if ipv6Packet, ok := topLayer.(*layers.IPv6); ok {
 			outerLength = int64(ipv6Packet.Length)
+			ipv6HeaderLength = len(ipv6Packet.Contents)
 		}
 	}
 
 	// length of the encapsulation header + the inner packet
 	topLayerIndex, topLayerOffset, topLayerLength := 0, 0, int(outerLength)
 
-	offset, length := topLayerOffset, topLayerLength
+	offset, length := topLayerOffset, topLayerLength+ipv6HeaderLength
 	for i, layer := range packetLayers {
 		length -= len(layer.LayerContents())
 		offset += len(layer.LayerContents())
+		if offset > len(packetData) {
+			return &PacketSequence{}, ErrMalformedPacket
+		}
 
 		switch layer.LayerType() {
 		case layers.LayerTypeGRE:
@@ -972,6 +1378,14 @@ func PacketSeqFromGoPacket(packet gopacket.Packet, outerLength int64, bpf *BPF,
 			fallthrough
 			// We don't split on vlan layers.LayerTypeDot1Q
 		case layers.LayerTypeVXLAN, layers.LayerTypeMPLS, layers.LayerTypeGeneve:
+			// the outer headers claim less bytes than the headers decoded so far
+			if topLayerLength < 0 {
+				return &PacketSequence{}, ErrMalformedPacket
+			}
+			if len(ps.Packets) == MaxEncapsulationDepth {
+				return &PacketSequence{}, ErrTooManyEncapsulations
+			}
+
 			p := &Packet{
 				GoPacket: packet,
 				Layers:   packetLayers[topLayerIndex : i+1],
@@ -993,6 +1407,15 @@ func PacketSeqFromGoPacket(packet gopacket.Packet, outerLength int64, bpf *BPF,
 		}
 	}
 
+	if topLayerLength < 0 {
+		return &PacketSequence{}, ErrMalformedPacket
+	}
+
+	// nothing could be decoded after the last tunnel header
+	if topLayerIndex > 0 && (topLayerIndex == len(packetLayers) || packetLayers[topLayerIndex].LayerType() == gopacket.LayerTypeDecodeFailure) {
+		return ps, nil
+	}
+
 	p := &Packet{
 		GoPacket: packet,
 		Layers:   packetLayers[topLayerIndex:],
@@ -1009,12 +1432,18 @@ func PacketSeqFromGoPacket(packet gopacket.Packet, outerLength int64, bpf *BPF,
 
 	ps.Packets = append(ps.Packets, p)
 
-	return ps
+	return ps, nil
 }
 
 // PacketSeqFromSFlowSample returns an array of Packets as a sample
 // contains mutlple records which generate a Packets each.
 func PacketSeqFromSFlowSample(sample *layers.SFlowFlowSample, bpf *BPF, defragger *IPDefragger) []*PacketSequence {
+	return packetSeqsFromSFlowSample(sample, func(packet gopacket.Packet, outerLength int64) *PacketSequence {
+		return PacketSeqFromGoPacket(packet, outerLength, bpf, defragger)
+	})
+}
+
+func packetSeqsFromSFlowSample(sample *layers.SFlowFlowSample, packetSeq func(packet gopacket.Packet, outerLength int64) *PacketSequence) []*PacketSequence {
 	var pss []*PacketSequence
 
 	for _, rec := range sample.Records {
@@ -1031,7 +1460,7 @@ func PacketSeqFromSFlowSample(sample *layers.SFlowFlowSample, bpf *BPF, defragge
 			m.CaptureInfo.Timestamp = time.Now()
 		}
 		// each record can generate multiple Packet in case of encapsulation
-		if ps := PacketSeqFromGoPacket(record.Header, int64(record.FrameLength-record.PayloadRemoved), bpf, defragger); len(ps.Packets) > 0 {
+		if ps := packetSeq(record.Header, int64(record.FrameLength-record.PayloadRemoved)); len(ps.Packets) > 0 {
 			pss = append(pss, ps)
 		}
 	}
@@ -1108,9 +1537,12 @@ func (i *ICMPLayer) GetStringField(field string) (string, error) {
 	switch field {
 	case "Type":
 		return i.Type.String(), nil
-	default:
-		return "", common.ErrFieldNotFound
 	}
+
+	if strings.HasPrefix(field, "Original.") {
+		return i.Original.GetStringField(strings.TrimPrefix(field, "Original."))
+	}
+	return "", common.ErrFieldNotFound
 }
 
 // GetFieldInt64 returns the value of a ICMP field
@@ -1122,9 +1554,136 @@ func (i *ICMPLayer) GetFieldInt64(field string) (int64, error) {
 	switch field {
 	case "ID":
 		return int64(i.ID), nil
-	default:
+	case "Code":
+		return int64(i.Code), nil
+	}
+
+	if strings.HasPrefix(field, "Original.") {
+		return i.Original.GetFieldInt64(strings.TrimPrefix(field, "Original."))
+	}
+	return 0, common.ErrFieldNotFound
+}
+
+// GetStringField returns the value of a field of the packet quoted by an ICMP error
+func (o *ICMPOriginal) GetStringField(field string) (string, error) {
+	if o == nil {
+		return "", common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "UUID":
+		return o.UUID, nil
+	case "L3TrackingID":
+		return o.L3TrackingID, nil
+	}
+
+	fields := strings.SplitN(field, ".", 2)
+	if len(fields) != 2 {
+		return "", common.ErrFieldNotFound
+	}
+
+	switch fields[0] {
+	case "Network":
+		return o.Network.GetStringField(fields[1])
+	case "Transport":
+		return o.Transport.GetStringField(fields[1])
+	}
+	return "", common.ErrFieldNotFound
+}
+
+// GetFieldInt64 returns the value of a field of the packet quoted by an ICMP error
+func (o *ICMPOriginal) GetFieldInt64(field string) (int64, error) {
+	if o == nil {
+		return 0, common.ErrFieldNotFound
+	}
+
+	fields := strings.SplitN(field, ".", 2)
+	if len(fields) != 2 {
 		return 0, common.ErrFieldNotFound
 	}
+
+	switch fields[0] {
+	case "Network":
+		return o.Network.GetFieldInt64(fields[1])
+	case "Transport":
+		return o.Transport.GetFieldInt64(fields[1])
+	}
+	return 0, common.ErrFieldNotFound
+}
+
+// Key returns the flow table key of the quoted packet, see Packet.Key. Only
+// quoted packets carrying ports can be keyed. The link layer of the quoted
+// packet being unknown, the one of the ICMP error packet is used, both
+// usually going through the same gateway.
+func (o *ICMPOriginal) Key(packet *Packet, parentUUID string, opts FlowOpts) (string, bool) {
+	if o.Network == nil || o.Transport == nil {
+		return "", false
+	}
+
+	// the flows have to be keyed by the layers quoted by the error
+	if fields := opts.keyFields(); fields&NetworkKeyField == 0 || fields&PortsKeyField == 0 {
+		return "", false
+	}
+
+	var uuid uint64
+	if opts.linkKeyed() {
+		if layer := packet.LinkLayer(); layer != nil {
+			uuid ^= layer.LinkFlow().FastHash()
+		}
+	}
+
+	a, b := net.ParseIP(o.Network.A), net.ParseIP(o.Network.B)
+	if a == nil || b == nil {
+		return "", false
+	}
+
+	endpoint := layers.EndpointIPv6
+	if o.Network.Protocol == FlowProtocol_IPV4 {
+		endpoint, a, b = layers.EndpointIPv4, a.To4(), b.To4()
+	}
+	uuid ^= gopacket.NewFlow(endpoint, a, b).FastHash()
+
+	switch o.Transport.Protocol {
+	case FlowProtocol_TCP:
+		endpoint = layers.EndpointTCPPort
+	case FlowProtocol_UDP:
+		endpoint = layers.EndpointUDPPort
+	case FlowProtocol_SCTP:
+		endpoint = layers.EndpointSCTPPort
+	default:
+		return "", false
+	}
+
+	portA, portB := make([]byte, 2), make([]byte, 2)
+	binary.BigEndian.PutUint16(portA, uint16(o.Transport.A))
+	binary.BigEndian.PutUint16(portB, uint16(o.Transport.B))
+	uuid ^= gopacket.NewFlow(endpoint, portA, portB).FastHash()
+
+	return parentUUID + strconv.FormatUint(uuid, 10), true
+}
+
+// GetStringField returns the value of a ARP field
+func (a *ARPLayer) GetStringField(field string) (string, error) {
+	if a == nil {
+		return "", common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "Operation":
+		return a.Operation.String(), nil
+	case "SenderMAC":
+		return a.SenderMAC, nil
+	case "SenderIP":
+		return a.SenderIP, nil
+	case "TargetMAC":
+		return a.TargetMAC, nil
+	case "TargetIP":
+		return a.TargetIP, nil
+	case "Gratuitous":
+		return strconv.FormatBool(a.Gratuitous), nil
+	default:
+		return "", common.ErrFieldNotFound
+	}
 }
 
 // GetFieldInt64 returns the value of a IPMetric field
@@ -1153,6 +1712,18 @@ func (i *TCPMetric) GetFieldInt64(field string) (int64, error) {
 		return int64(i.ABSynTTL), nil
 	case "BASynTTL":
 		return int64(i.BASynTTL), nil
+	case "ABSynWindow":
+		return int64(i.ABSynWindow), nil
+	case "BASynWindow":
+		return int64(i.BASynWindow), nil
+	case "ABSynMSS":
+		return int64(i.ABSynMSS), nil
+	case "BASynMSS":
+		return int64(i.BASynMSS), nil
+	case "ABSynWScale":
+		return int64(i.ABSynWScale), nil
+	case "BASynWScale":
+		return int64(i.BASynWScale), nil
 	case "ABSynStart":
 		return i.ABSynStart, nil
 	case "BASynStart":
@@ -1216,15 +1787,32 @@ func (f *Flow) GetFieldString(field string) (string, error) {
 		return f.TrackingID, nil
 	case "L3TrackingID":
 		return f.L3TrackingID, nil
+	case "PayloadTrackingID":
+		return f.PayloadTrackingID, nil
+	case "ChainID":
+		return f.ChainID, nil
+	case "ThreatFeed":
+		return f.ThreatFeed, nil
+	case "ThreatIndicator":
+		return f.ThreatIndicator, nil
+	case "RetentionClass":
+		return f.RetentionClass, nil
 	case "ParentUUID":
 		return f.ParentUUID, nil
 	case "NodeTID":
 		return f.NodeTID, nil
 	case "Application":
 		return f.Application, nil
+	case "Direction":
+		return f.Direction.String(), nil
+	case "DirectionConfidence":
+		return f.DirectionConfidence.String(), nil
 	}
 
 	// sub field
+	if name == "ICMP" && len(fields) > 2 {
+		return f.ICMP.GetStringField(strings.Join(fields[1:], "."))
+	}
 	if len(fields) != 2 {
 		return "", common.ErrFieldNotFound
 	}
@@ -1236,6 +1824,8 @@ func (f *Flow) GetFieldString(field string) (string, error) {
 		return f.Network.GetStringField(fields[1])
 	case "ICMP":
 		return f.ICMP.GetStringField(fields[1])
+	case "ARP":
+		return f.ARP.GetStringField(fields[1])
 	case "Transport":
 		return f.Transport.GetStringField(fields[1])
 	case "UDP", "TCP", "SCTP":
@@ -1257,9 +1847,14 @@ func (f *Flow) GetFieldInt64(field string) (_ int64, err error) {
 		return f.Start, nil
 	case "RTT":
 		return f.RTT, nil
+	case "RetainUntil":
+		return f.RetainUntil, nil
 	}
 
 	fields := strings.Split(field, ".")
+	if fields[0] == "ICMP" && len(fields) > 2 {
+		return f.ICMP.GetFieldInt64(strings.Join(fields[1:], "."))
+	}
 	if len(fields) != 2 {
 		return 0, common.ErrFieldNotFound
 	}
@@ -1273,6 +1868,10 @@ func (f *Flow) GetFieldInt64(field string) (_ int64, err error) {
 		return f.TCPMetric.GetFieldInt64(fields[1])
 	case "IPMetric":
 		return f.IPMetric.GetFieldInt64(fields[1])
+	case "HTTPMetric":
+		return f.HTTPMetric.GetFieldInt64(fields[1])
+	case "HTTP2Metric":
+		return f.HTTP2Metric.GetFieldInt64(fields[1])
 	case "Link":
 		return f.Link.GetFieldInt64(fields[1])
 	case "Network":
@@ -1297,12 +1896,18 @@ func (f *Flow) GetFieldInterface(field string) (_ interface{}, err error) {
 		return f.LastUpdateMetric, nil
 	case "TCPMetric":
 		return f.TCPMetric, nil
+	case "HTTPMetric":
+		return f.HTTPMetric, nil
+	case "HTTP2Metric":
+		return f.HTTP2Metric, nil
 	case "Link":
 		return f.Link, nil
 	case "Network":
 		return f.Network, nil
 	case "ICMP":
 		return f.ICMP, nil
+	case "ARP":
+		return f.ARP, nil
 	case "Transport":
 		return f.Transport, nil
 	default:
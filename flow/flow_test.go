@@ -26,6 +26,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"reflect"
 	"strings"
 	"testing"
@@ -34,6 +35,7 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/filters"
 )
 
@@ -1066,6 +1068,25 @@ func TestFlowSimpleSynFin(t *testing.T) {
 	}
 }
 
+func TestTCPSynSignature(t *testing.T) {
+	tcp := &layers.TCP{
+		SYN:    true,
+		Window: 29200,
+		Options: []layers.TCPOption{
+			{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+			{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+			{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: make([]byte, 8)},
+			{OptionType: layers.TCPOptionKindNop, OptionLength: 1},
+			{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+		},
+	}
+
+	mss, wscale, options := tcpSynSignature(tcp)
+	if mss != 1460 || wscale != 7 || options != "M,S,T,N,W" {
+		t.Errorf("Wrong SYN signature, got MSS %d, window scale %d, options %s", mss, wscale, options)
+	}
+}
+
 func TestGetFieldsXXX(t *testing.T) {
 	f := &Flow{}
 
@@ -1537,3 +1558,220 @@ func TestLayerKeyMode(t *testing.T) {
 
 	validatePCAP(t, "pcaptraces/layer-key-mode.pcap", layers.LinkTypeEthernet, nil, expected, TableOpts{LayerKeyMode: L2KeyMode})
 }
+
+func TestMalformedPackets(t *testing.T) {
+	tests := []struct {
+		file      string
+		malformed bool
+		flows     int
+	}{
+		{file: "icmpv4", flows: 1},
+		{file: "empty"},
+		{file: "ipv4-truncated-header", malformed: true},
+		{file: "ipv4-bogus-ihl", malformed: true},
+		{file: "ipv4-bogus-length", malformed: true},
+		{file: "gre-nested-2", flows: 3},
+		{file: "gre-nested-10", malformed: true},
+		{file: "gre-truncated-inner", malformed: true},
+		{file: "gre-mpls-garbage", flows: 1},
+		{file: "vxlan-empty", flows: 1},
+		{file: "icmpv6-echo-truncated", flows: 1},
+	}
+
+	for _, test := range tests {
+		data, err := ioutil.ReadFile("fuzz/corpus/" + test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		table := NewTable(nil, nil, NewEnhancerPipeline(), "", TableOpts{IPDefrag: true})
+
+		packet := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+		table.processPacketSeq(table.packetSeqFromGoPacket(packet, 0, nil))
+
+		stats := table.Stats()
+		if malformed := stats.PacketsMalformed > 0; malformed != test.malformed {
+			t.Errorf("%s: expected malformed %v, got stats %+v", test.file, test.malformed, stats)
+		}
+
+		if packet.ErrorLayer() != nil && stats.PacketsDecodingErrors != 1 {
+			t.Errorf("%s: decoding error not accounted, got stats %+v", test.file, stats)
+		}
+
+		if flows := table.getFlows(&filters.SearchQuery{}).GetFlows(); len(flows) != test.flows {
+			f, _ := json.MarshalIndent(flows, "", "\t")
+			t.Errorf("%s: expected %d flows, got %s", test.file, test.flows, string(f))
+		}
+	}
+}
+
+func TestICMPErrorOriginal(t *testing.T) {
+	flows := flowsFromPCAP(t, "pcaptraces/icmp-errors-ndp-mld.pcap", layers.LinkTypeEthernet, nil)
+
+	var udp, icmp *Flow
+	for _, f := range flows {
+		switch f.LayersPath {
+		case "Ethernet/IPv4/UDP":
+			udp = f
+		case "Ethernet/IPv4/ICMPv4":
+			icmp = f
+		}
+	}
+	if udp == nil || icmp == nil {
+		t.Fatalf("UDP and ICMPv4 flows expected, got %+v", flows)
+	}
+
+	if icmp.ICMP.Type != ICMPType_TIME_EXCEEDED {
+		t.Errorf("Expected TIME_EXCEEDED ICMP type, got %s", icmp.ICMP.Type)
+	}
+
+	original := icmp.ICMP.Original
+	if original == nil || original.Network == nil || original.Transport == nil {
+		t.Fatalf("Expected the quoted headers, got %+v", icmp.ICMP)
+	}
+
+	if original.Network.A != "10.0.0.1" || original.Network.B != "192.168.1.10" ||
+		original.Transport.Protocol != FlowProtocol_UDP || original.Transport.A != 33000 || original.Transport.B != 33434 {
+		t.Errorf("Wrong quoted headers, got %+v / %+v", original.Network, original.Transport)
+	}
+
+	if original.UUID != udp.UUID || original.L3TrackingID != udp.L3TrackingID {
+		t.Errorf("ICMP error should reference the UDP flow %s, got %+v", udp.UUID, original)
+	}
+
+	if port, err := icmp.GetFieldInt64("ICMP.Original.Transport.B"); err != nil || port != 33434 {
+		t.Errorf("Wrong quoted port field, got %d, %v", port, err)
+	}
+	if uuid, err := icmp.GetFieldString("ICMP.Original.UUID"); err != nil || uuid != udp.UUID {
+		t.Errorf("Wrong quoted UUID field, got %s, %v", uuid, err)
+	}
+}
+
+func TestICMPv6Types(t *testing.T) {
+	flows := flowsFromPCAP(t, "pcaptraces/icmp-errors-ndp-mld.pcap", layers.LinkTypeEthernet, nil)
+
+	types := make(map[string]ICMPType)
+	for _, f := range flows {
+		if f.LayersPath == "Ethernet/IPv6/ICMPv6" {
+			types[f.Network.B] = f.ICMP.Type
+		}
+	}
+
+	if types["ff02::16"] != ICMPType_MULTICAST_LISTENER {
+		t.Errorf("MLD report should be typed MULTICAST_LISTENER, got %s", types["ff02::16"])
+	}
+	if types["ff02::1:ff00:2"] != ICMPType_NEIGHBOR {
+		t.Errorf("Neighbor solicitation should be typed NEIGHBOR, got %s", types["ff02::1:ff00:2"])
+	}
+}
+
+func TestARPFlows(t *testing.T) {
+	flows := flowsFromPCAP(t, "pcaptraces/eth-ip4-arp-dns-req-http-google.pcap", layers.LinkTypeEthernet, nil)
+
+	operations := make(map[ARPOperation]*ARPLayer)
+	for _, f := range flows {
+		if f.LayersPath == "Ethernet/ARP" {
+			if f.ARP == nil {
+				t.Fatalf("ARP layer expected, got %+v", f)
+			}
+			operations[f.ARP.Operation] = f.ARP
+		}
+	}
+
+	request, reply := operations[ARPOperation_REQUEST], operations[ARPOperation_REPLY]
+	if request == nil || reply == nil {
+		t.Fatalf("ARP request and reply expected, got %+v", operations)
+	}
+
+	if request.SenderMAC != "fa:16:3e:29:e0:82" || request.SenderIP != "192.168.0.5" || request.TargetIP != "192.168.0.1" || request.Gratuitous {
+		t.Errorf("Wrong ARP request, got %+v", request)
+	}
+	if reply.SenderMAC != "fa:16:3e:96:06:e8" || reply.SenderIP != "192.168.0.1" || reply.TargetMAC != "fa:16:3e:29:e0:82" {
+		t.Errorf("Wrong ARP reply, got %+v", reply)
+	}
+
+	flows = flowsFromPCAP(t, "pcaptraces/arp-gratuitous.pcap", layers.LinkTypeEthernet, nil)
+	if len(flows) != 1 || flows[0].ARP == nil {
+		t.Fatalf("A single ARP flow expected, got %+v", flows)
+	}
+
+	if gratuitous, _ := flows[0].GetFieldString("ARP.Gratuitous"); gratuitous != "true" {
+		t.Errorf("Gratuitous ARP expected, got %+v", flows[0].ARP)
+	}
+	if ip, _ := flows[0].GetFieldString("ARP.SenderIP"); ip != "10.0.0.42" {
+		t.Errorf("Wrong ARP sender, got %+v", flows[0].ARP)
+	}
+}
+
+func TestFlowKeys(t *testing.T) {
+	tests := []struct {
+		file  string
+		key   string
+		flows int
+	}{
+		{"pcaptraces/icmpv4-symetric.pcap", "", 100},
+		{"pcaptraces/icmpv4-symetric.pcap", "5-tuple", 100},
+		{"pcaptraces/icmpv4-symetric.pcap", "3-tuple", 50},
+		{"pcaptraces/icmpv4-symetric.pcap", "ip-pair", 50},
+		{"pcaptraces/icmpv4-symetric.pcap", "l2-pair", 1},
+		{"pcaptraces/icmpv4-symetric.pcap", "Network,Application", 100},
+		{"pcaptraces/eth-ip4-arp-dns-req-http-google.pcap", "3-tuple", 5},
+		{"pcaptraces/eth-ip4-arp-dns-req-http-google.pcap", "l2-pair", 2},
+	}
+
+	for _, test := range tests {
+		fields, err := KeyFieldsByName(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		flows := flowsFromPCAP(t, test.file, layers.LinkTypeEthernet, nil, TableOpts{KeyFields: fields})
+		if len(flows) != test.flows {
+			t.Errorf("Expected %d flows for %s with key '%s', got %d", test.flows, test.file, test.key, len(flows))
+		}
+
+		if fields == 0 {
+			continue
+		}
+
+		for _, f := range flows {
+			if fields&PortsKeyField == 0 && f.Transport != nil && (f.Transport.A != 0 || f.Transport.B != 0) {
+				t.Errorf("Ports not part of the key '%s' should be removed: %s", test.key, f.Transport)
+			}
+			if fields&NetworkKeyField == 0 && f.Network != nil {
+				t.Errorf("Network not part of the key '%s' should be removed: %s", test.key, f.Network)
+			}
+		}
+	}
+
+	if _, err := KeyFieldsByName("Network,VLAN"); err == nil {
+		t.Error("Unknown key fields should be rejected")
+	}
+	if fields, _ := KeyFieldsByName("Network, Ports"); fields.String() != "Network,Protocol,Ports" {
+		t.Errorf("Ports should imply the protocol: %s", fields)
+	}
+}
+
+func TestFlowAnonymize(t *testing.T) {
+	a, err := common.NewAnonymizer(common.AnonymizeTruncate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Flow{
+		Link:           &FlowLayer{Protocol: FlowProtocol_ETHERNET, A: "52:54:00:12:34:56", B: "ff:ff:ff:ff:ff:ff"},
+		Network:        &FlowLayer{Protocol: FlowProtocol_IPV4, A: "192.168.1.10", B: "10.0.0.1"},
+		LastRawPackets: []*RawPacket{{Timestamp: 1}},
+	}
+	f.Anonymize(a)
+
+	if f.Link.A != "52:54:00:00:00:00" || f.Link.B != "ff:ff:ff:ff:ff:ff" {
+		t.Errorf("Expected the MACs to be truncated, got %s and %s", f.Link.A, f.Link.B)
+	}
+	if f.Network.A != "192.168.1.0" || f.Network.B != "10.0.0.0" {
+		t.Errorf("Expected the IPs to be truncated, got %s and %s", f.Network.A, f.Network.B)
+	}
+	if f.LastRawPackets != nil {
+		t.Error("Expected the raw packets to be dropped")
+	}
+}
@@ -0,0 +1,56 @@
+// +build gofuzz
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Fuzz is the go-fuzz entry point of the packet decoding path, the corpus
+// of crafted packets being in flow/fuzz/corpus:
+//
+//	go-fuzz-build github.com/skydive-project/skydive/flow
+//	go-fuzz -bin=flow-fuzz.zip -workdir=flow/fuzz
+//
+// Unlike the table feeders, panics are not recovered so that the fuzzer
+// reports them.
+func Fuzz(data []byte) int {
+	table := NewTable(nil, nil, NewEnhancerPipeline(), "", TableOpts{ExtraTCPMetric: true, ReassembleTCP: true, IPDefrag: true})
+
+	packet := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+	ps, err := packetSeqFromGoPacket(packet, 0, nil, table.IPDefragger())
+	if err != nil || len(ps.Packets) == 0 {
+		return 0
+	}
+
+	var parentUUID string
+	for _, packet := range ps.Packets {
+		f := table.packetToFlow(packet, parentUUID)
+		parentUUID = f.UUID
+	}
+
+	return 1
+}
@@ -36,6 +36,33 @@ func (fl *ICMPLayer) Hash(hasher hash.Hash) {
 	value32 := make([]byte, 4)
 	binary.BigEndian.PutUint32(value32, uint32(fl.Type)<<24|uint32(fl.Code<<16|uint32(fl.ID)))
 	hasher.Write(value32)
+
+	fl.Original.Hash(hasher)
+}
+
+// Hash calculates the hash of the packet quoted by an ICMP error
+func (o *ICMPOriginal) Hash(hasher hash.Hash) {
+	if o == nil {
+		return
+	}
+
+	o.Network.Hash(hasher)
+	o.Transport.Hash(hasher)
+}
+
+// Hash calculates a symetric hash of the ARP protocol addresses
+func (a *ARPLayer) Hash(hasher hash.Hash) {
+	if a == nil {
+		return
+	}
+
+	if strings.Compare(a.SenderIP, a.TargetIP) > 0 {
+		hasher.Write([]byte(a.SenderIP))
+		hasher.Write([]byte(a.TargetIP))
+	} else {
+		hasher.Write([]byte(a.TargetIP))
+		hasher.Write([]byte(a.SenderIP))
+	}
 }
 
 func (tl *TransportLayer) Hash(hasher hash.Hash) {
@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+// HTTPLatencyBuckets are the upper bounds, in milliseconds, of the buckets
+// of the HTTP latency histogram
+var HTTPLatencyBuckets = []int64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// maxHTTPPendingRequests bounds the number of requests waiting for their
+// response, pipelined or whose response was not captured
+const maxHTTPPendingRequests = 32
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "), []byte("HEAD "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+}
+
+func newHTTPMetric() *HTTPMetric {
+	return &HTTPMetric{LatencyBuckets: make([]int64, len(HTTPLatencyBuckets)+1)}
+}
+
+// isHTTPRequest returns whether the payload starts with a request line
+func isHTTPRequest(payload []byte) bool {
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(payload, method) {
+			line := payload
+			if i := bytes.IndexByte(payload, '\n'); i != -1 {
+				line = payload[:i]
+			}
+			return bytes.Contains(line, []byte(" HTTP/1."))
+		}
+	}
+	return false
+}
+
+// httpResponseCode returns the status code of the status line the payload
+// starts with
+func httpResponseCode(payload []byte) (int, bool) {
+	// HTTP/1.x NNN
+	if len(payload) < 12 || !bytes.HasPrefix(payload, []byte("HTTP/1.")) || payload[8] != ' ' {
+		return 0, false
+	}
+
+	code, err := strconv.Atoi(string(payload[9:12]))
+	if err != nil || code < 100 || code > 599 {
+		return 0, false
+	}
+	return code, true
+}
+
+func (hm *HTTPMetric) addLatency(latency int64) {
+	hm.LatencySum += latency
+	if latency > hm.LatencyMax {
+		hm.LatencyMax = latency
+	}
+
+	i := 0
+	for i < len(HTTPLatencyBuckets) && latency > HTTPLatencyBuckets[i] {
+		i++
+	}
+	hm.LatencyBuckets[i]++
+}
+
+func (hm *HTTPMetric) addResponse(code int) {
+	hm.Responses++
+	switch code / 100 {
+	case 1:
+		hm.Informational++
+	case 2:
+		hm.Success++
+	case 3:
+		hm.Redirection++
+	case 4:
+		hm.ClientErrors++
+	case 5:
+		hm.ServerErrors++
+	}
+	hm.ErrorRatio = float64(hm.ClientErrors+hm.ServerErrors) / float64(hm.Responses)
+}
+
+// updateHTTPMetrics accounts the request or the response starting the TCP
+// payload of the packet. The responses are matched to the requests in
+// order, as HTTP/1 answers the pipelined requests in sequence.
+func (f *Flow) updateHTTPMetrics(packet *Packet) {
+	tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok || len(tcp.Payload) == 0 {
+		return
+	}
+
+	now := common.UnixMillis(packet.GoPacket.Metadata().CaptureInfo.Timestamp)
+
+	if isHTTPRequest(tcp.Payload) {
+		f.HTTPMetric.Requests++
+		if len(f.XXX_state.httpRequests) < maxHTTPPendingRequests {
+			f.XXX_state.httpRequests = append(f.XXX_state.httpRequests, now)
+		}
+		return
+	}
+
+	code, ok := httpResponseCode(tcp.Payload)
+	if !ok {
+		return
+	}
+	f.HTTPMetric.addResponse(code)
+
+	// interim responses are followed by the final one
+	if code >= 200 && len(f.XXX_state.httpRequests) > 0 {
+		f.HTTPMetric.addLatency(now - f.XXX_state.httpRequests[0])
+		f.XXX_state.httpRequests = f.XXX_state.httpRequests[1:]
+	}
+}
+
+// GetFieldInt64 returns the value of a HTTPMetric field
+func (hm *HTTPMetric) GetFieldInt64(field string) (int64, error) {
+	if hm == nil {
+		return 0, common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "Requests":
+		return hm.Requests, nil
+	case "Responses":
+		return hm.Responses, nil
+	case "Informational":
+		return hm.Informational, nil
+	case "Success":
+		return hm.Success, nil
+	case "Redirection":
+		return hm.Redirection, nil
+	case "ClientErrors":
+		return hm.ClientErrors, nil
+	case "ServerErrors":
+		return hm.ServerErrors, nil
+	case "LatencySum":
+		return hm.LatencySum, nil
+	case "LatencyMax":
+		return hm.LatencyMax, nil
+	default:
+		return 0, common.ErrFieldNotFound
+	}
+}
@@ -0,0 +1,322 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+// MaxHTTP2Streams is the number of streams kept in the HTTP/2 metric of a
+// flow, the oldest ones being dropped
+const MaxHTTP2Streams = 64
+
+const (
+	maxGRPCMethods = 32
+
+	http2FrameHeaderLen    = 9
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FrameContinuation = 0x9
+	http2FlagEndHeaders    = 0x4
+	http2FlagPadded        = 0x8
+	http2FlagPriority      = 0x20
+)
+
+var http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+// http2Direction holds the parsing state of one direction of a HTTP/2
+// connection. The frame boundaries are lost when a frame header is not
+// captured, the header compression context when a header block is not.
+type http2Direction struct {
+	decoder     *hpack.Decoder
+	remaining   int
+	block       []byte
+	blockStream uint32
+	framesLost  bool
+	headersLost bool
+}
+
+type http2State struct {
+	directions [2]http2Direction
+}
+
+// tcpSegment returns the length of the TCP payload of the packet, including
+// the bytes beyond the capture length, and whether it goes from A to B
+func (f *Flow) tcpSegment(packet *Packet, tcp *layers.TCP) (length int, ab bool) {
+	var src string
+	length = len(tcp.Payload)
+
+	if ip, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		src = ip.SrcIP.String()
+		if l := int(ip.Length) - int(ip.IHL)*4 - int(tcp.DataOffset)*4; l > length {
+			length = l
+		}
+	} else if ip, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+		src = ip.SrcIP.String()
+		if l := int(ip.Length) - int(tcp.DataOffset)*4; l > length {
+			length = l
+		}
+	}
+
+	ab = int64(tcp.SrcPort) == f.Transport.A
+	if f.Network != nil && f.Network.A != f.Network.B {
+		ab = src == f.Network.A
+	}
+	return
+}
+
+// updateHTTP2Metrics follows the frames of the cleartext HTTP/2
+// connections, detected by their client preface or classified as HTTP2 or
+// GRPC by the application ports
+func (f *Flow) updateHTTP2Metrics(packet *Packet) {
+	tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok || len(tcp.Payload) == 0 {
+		return
+	}
+
+	payload := tcp.Payload
+	length, ab := f.tcpSegment(packet, tcp)
+
+	preface := bytes.HasPrefix(payload, http2Preface)
+	if f.HTTP2Metric == nil {
+		if !preface {
+			return
+		}
+		f.HTTP2Metric = &HTTP2Metric{}
+	}
+
+	if f.XXX_state.http2 == nil {
+		f.XXX_state.http2 = &http2State{}
+	}
+
+	d := &f.XXX_state.http2.directions[1]
+	if ab {
+		d = &f.XXX_state.http2.directions[0]
+	}
+
+	if preface {
+		payload, length = payload[len(http2Preface):], length-len(http2Preface)
+	}
+
+	f.HTTP2Metric.parseFrames(d, ab, payload, length)
+}
+
+func (hm *HTTP2Metric) parseFrames(d *http2Direction, ab bool, payload []byte, length int) {
+	if d.framesLost {
+		return
+	}
+
+	// skip the end of the frame started in a previous segment
+	if d.remaining >= length {
+		d.remaining -= length
+		return
+	}
+	offset := d.remaining
+	d.remaining = 0
+
+	for offset < length {
+		if offset+http2FrameHeaderLen > len(payload) {
+			// frame header beyond the capture length or split in two segments
+			d.framesLost = true
+			return
+		}
+
+		header := payload[offset : offset+http2FrameHeaderLen]
+		size := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+		kind, flags := header[3], header[4]
+		id := binary.BigEndian.Uint32(header[5:]) & 0x7fffffff
+
+		start, end := offset+http2FrameHeaderLen, offset+http2FrameHeaderLen+size
+
+		// payload of the frame, unless not fully captured
+		var frame []byte
+		if end <= len(payload) {
+			frame = payload[start:end]
+		}
+
+		hm.onFrame(d, ab, kind, flags, id, size, frame)
+
+		if end > length {
+			d.remaining = end - length
+			return
+		}
+		offset = end
+	}
+}
+
+func (hm *HTTP2Metric) onFrame(d *http2Direction, ab bool, kind, flags byte, id uint32, size int, frame []byte) {
+	switch kind {
+	case http2FrameData:
+		if ab {
+			hm.ABBytes += int64(size)
+		} else {
+			hm.BABytes += int64(size)
+		}
+		if s := hm.stream(id, false); s != nil {
+			if ab {
+				s.ABBytes += int64(size)
+			} else {
+				s.BABytes += int64(size)
+			}
+		}
+	case http2FrameHeaders:
+		s := hm.stream(id, true)
+		if frame == nil {
+			d.block, d.headersLost = nil, true
+			return
+		}
+
+		block := frame
+		if flags&http2FlagPadded != 0 {
+			if len(block) < 1 || int(block[0]) > len(block)-1 {
+				return
+			}
+			block = block[1 : len(block)-int(block[0])]
+		}
+		if flags&http2FlagPriority != 0 {
+			if len(block) < 5 {
+				return
+			}
+			block = block[5:]
+		}
+
+		if flags&http2FlagEndHeaders != 0 {
+			hm.decodeHeaders(d, s, block)
+		} else {
+			d.block, d.blockStream = append([]byte{}, block...), id
+		}
+	case http2FrameContinuation:
+		if d.block == nil || d.blockStream != id {
+			return
+		}
+		if frame == nil {
+			d.block, d.headersLost = nil, true
+			return
+		}
+
+		d.block = append(d.block, frame...)
+		if flags&http2FlagEndHeaders != 0 {
+			hm.decodeHeaders(d, hm.stream(id, false), d.block)
+			d.block = nil
+		}
+	}
+}
+
+// stream returns the metric of a stream, created if requested
+func (hm *HTTP2Metric) stream(id uint32, create bool) *HTTP2Stream {
+	for i := len(hm.StreamMetrics) - 1; i >= 0; i-- {
+		if hm.StreamMetrics[i].ID == id {
+			return hm.StreamMetrics[i]
+		}
+	}
+
+	if !create {
+		return nil
+	}
+
+	hm.Streams++
+	s := &HTTP2Stream{ID: id}
+	if hm.StreamMetrics = append(hm.StreamMetrics, s); len(hm.StreamMetrics) > MaxHTTP2Streams {
+		hm.StreamMetrics = hm.StreamMetrics[1:]
+	}
+	return s
+}
+
+func (hm *HTTP2Metric) decodeHeaders(d *http2Direction, s *HTTP2Stream, block []byte) {
+	if d.headersLost {
+		return
+	}
+
+	if d.decoder == nil {
+		d.decoder = hpack.NewDecoder(4096, nil)
+	}
+
+	fields, err := d.decoder.DecodeFull(block)
+	if err != nil {
+		// references to a dynamic table not captured
+		d.headersLost = true
+		return
+	}
+
+	if s == nil {
+		return
+	}
+
+	var grpc bool
+	for _, field := range fields {
+		switch field.Name {
+		case ":method":
+			s.Method = field.Value
+		case ":path":
+			s.Path = field.Value
+		case "content-type":
+			grpc = strings.HasPrefix(field.Value, "application/grpc")
+		case "grpc-status":
+			s.GRPCStatus = field.Value
+		}
+	}
+
+	if grpc && s.GRPCMethod == "" && s.Path != "" {
+		s.GRPCMethod = s.Path
+		hm.GRPCStreams++
+		hm.addGRPCMethod(s.Path)
+	}
+}
+
+func (hm *HTTP2Metric) addGRPCMethod(method string) {
+	for _, m := range hm.GRPCMethods {
+		if m == method {
+			return
+		}
+	}
+	if len(hm.GRPCMethods) < maxGRPCMethods {
+		hm.GRPCMethods = append(hm.GRPCMethods, method)
+	}
+}
+
+// GetFieldInt64 returns the value of a HTTP2Metric field
+func (hm *HTTP2Metric) GetFieldInt64(field string) (int64, error) {
+	if hm == nil {
+		return 0, common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "Streams":
+		return hm.Streams, nil
+	case "GRPCStreams":
+		return hm.GRPCStreams, nil
+	case "ABBytes":
+		return hm.ABBytes, nil
+	case "BABytes":
+		return hm.BABytes, nil
+	default:
+		return 0, common.ErrFieldNotFound
+	}
+}
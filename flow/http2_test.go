@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+func http2Frame(kind, flags byte, id uint32, payload []byte) []byte {
+	frame := make([]byte, http2FrameHeaderLen, http2FrameHeaderLen+len(payload))
+	frame[0], frame[1], frame[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	frame[3], frame[4] = kind, flags
+	binary.BigEndian.PutUint32(frame[5:], id)
+	return append(frame, payload...)
+}
+
+func http2Headers(t *testing.T, encoder *hpack.Encoder, buf *bytes.Buffer, fields ...string) []byte {
+	buf.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		if err := encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return append([]byte{}, buf.Bytes()...)
+}
+
+func TestHTTP2Metric(t *testing.T) {
+	var clientBuf, serverBuf bytes.Buffer
+	client, server := hpack.NewEncoder(&clientBuf), hpack.NewEncoder(&serverBuf)
+
+	request := func(id uint32) []byte {
+		block := http2Headers(t, client, &clientBuf, ":method", "POST", ":path", "/helloworld.Greeter/SayHello", "content-type", "application/grpc")
+		return http2Frame(http2FrameHeaders, http2FlagEndHeaders, id, block)
+	}
+
+	var segments []struct {
+		request bool
+		payload []byte
+	}
+	add := func(request bool, frames ...[]byte) {
+		segments = append(segments, struct {
+			request bool
+			payload []byte
+		}{request, bytes.Join(frames, nil)})
+	}
+
+	add(true, http2Preface, http2Frame(0x4, 0, 0, nil), request(1), http2Frame(http2FrameData, 0, 1, make([]byte, 10)))
+	add(false,
+		http2Frame(http2FrameHeaders, http2FlagEndHeaders, 1, http2Headers(t, server, &serverBuf, ":status", "200", "content-type", "application/grpc")),
+		http2Frame(http2FrameData, 0, 1, make([]byte, 20)),
+		http2Frame(http2FrameHeaders, http2FlagEndHeaders|0x1, 1, http2Headers(t, server, &serverBuf, "grpc-status", "0")))
+
+	// the second request references the dynamic table, its data frame
+	// being split in two segments
+	data := http2Frame(http2FrameData, 0, 3, make([]byte, 30))
+	add(true, request(3), data[:http2FrameHeaderLen+10])
+	add(true, data[http2FrameHeaderLen+10:], http2Frame(http2FrameData, 0, 3, make([]byte, 5)))
+
+	var f *Flow
+	opts := FlowOpts{}
+	ts := time.Unix(1500000000, 0)
+	for _, segment := range segments {
+		packet := httpPacket(t, ts, segment.request, string(segment.payload))
+		if f == nil {
+			f = NewFlow()
+			f.initFromPacket(packet.Key("", opts), packet, "", FlowUUIDs{}, opts)
+		} else {
+			f.Update(packet, opts)
+		}
+	}
+
+	m := f.HTTP2Metric
+	if m == nil {
+		t.Fatal("HTTP/2 connection not detected")
+	}
+	if m.Streams != 2 || m.GRPCStreams != 2 || m.ABBytes != 45 || m.BABytes != 20 {
+		t.Errorf("Wrong HTTP/2 counters: %+v", m)
+	}
+	if len(m.GRPCMethods) != 1 || m.GRPCMethods[0] != "/helloworld.Greeter/SayHello" {
+		t.Errorf("Wrong gRPC methods: %v", m.GRPCMethods)
+	}
+
+	if len(m.StreamMetrics) != 2 {
+		t.Fatalf("Expected 2 streams, got %+v", m.StreamMetrics)
+	}
+	if s := m.StreamMetrics[0]; s.ID != 1 || s.Method != "POST" || s.GRPCStatus != "0" || s.ABBytes != 10 || s.BABytes != 20 {
+		t.Errorf("Wrong first stream: %+v", s)
+	}
+	if s := m.StreamMetrics[1]; s.ID != 3 || s.GRPCMethod != "/helloworld.Greeter/SayHello" || s.ABBytes != 35 {
+		t.Errorf("Wrong second stream: %+v", s)
+	}
+}
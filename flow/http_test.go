@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func httpPacket(t *testing.T, ts time.Time, request bool, payload string) *Packet {
+	client, server := net.IP{192, 168, 0, 1}, net.IP{192, 168, 0, 2}
+	clientMAC, serverMAC := net.HardwareAddr{0, 0, 0, 0, 0, 1}, net.HardwareAddr{0, 0, 0, 0, 0, 2}
+
+	eth := &layers.Ethernet{SrcMAC: clientMAC, DstMAC: serverMAC, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: client, DstIP: server}
+	tcp := &layers.TCP{SrcPort: 34567, DstPort: 80, PSH: true, ACK: true, Window: 1024}
+	if !request {
+		eth.SrcMAC, eth.DstMAC = eth.DstMAC, eth.SrcMAC
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buffer := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opts, eth, ip, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	gp := gopacket.NewPacket(buffer.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	gp.Metadata().CaptureInfo.Timestamp = ts
+
+	ps := PacketSeqFromGoPacket(gp, 0, nil, nil)
+	if len(ps.Packets) != 1 {
+		t.Fatalf("Expected one packet, got %d", len(ps.Packets))
+	}
+	return ps.Packets[0]
+}
+
+func TestHTTPMetric(t *testing.T) {
+	opts := FlowOpts{AppPortMap: &ApplicationPortMap{TCP: map[int]string{80: "HTTP"}}}
+	start := time.Unix(1500000000, 0)
+
+	exchanges := []struct {
+		request  string
+		response string
+		latency  time.Duration
+	}{
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\n\r\n", 3 * time.Millisecond},
+		{"POST /form HTTP/1.1\r\n\r\n", "HTTP/1.1 302 Found\r\n\r\n", 40 * time.Millisecond},
+		{"GET /missing HTTP/1.1\r\n\r\n", "HTTP/1.1 404 Not Found\r\n\r\n", 200 * time.Millisecond},
+		{"GET /broken HTTP/1.0\r\n\r\n", "HTTP/1.0 500 Internal Server Error\r\n\r\n", 7 * time.Second},
+	}
+
+	var f *Flow
+	ts := start
+	for _, e := range exchanges {
+		request := httpPacket(t, ts, true, e.request)
+		if f == nil {
+			f = NewFlow()
+			f.initFromPacket(request.Key("", opts), request, "", FlowUUIDs{}, opts)
+		} else {
+			f.Update(request, opts)
+		}
+
+		ts = ts.Add(e.latency)
+		f.Update(httpPacket(t, ts, false, e.response), opts)
+
+		// request body, not a request line
+		f.Update(httpPacket(t, ts, true, "field=GET / HTTP/1.1"), opts)
+	}
+
+	m := f.HTTPMetric
+	if m == nil {
+		t.Fatal("HTTP flow without HTTP metric")
+	}
+	if m.Requests != 4 || m.Responses != 4 || m.Success != 1 || m.Redirection != 1 || m.ClientErrors != 1 || m.ServerErrors != 1 {
+		t.Errorf("Wrong HTTP counters: %+v", m)
+	}
+	if m.ErrorRatio != 0.5 {
+		t.Errorf("Expected an error ratio of 0.5, got %f", m.ErrorRatio)
+	}
+	if m.LatencyMax != 7000 || m.LatencySum != 7243 {
+		t.Errorf("Wrong latencies: %+v", m)
+	}
+
+	expected := map[int]int64{1: 1, 4: 1, 6: 1, 11: 1}
+	for i, count := range m.LatencyBuckets {
+		if count != expected[i] {
+			t.Errorf("Expected %d exchanges in latency bucket %d, got %d", expected[i], i, count)
+		}
+	}
+
+	if requests, err := f.GetFieldInt64("HTTPMetric.Requests"); err != nil || requests != 4 {
+		t.Errorf("HTTPMetric.Requests field not found: %d, %v", requests, err)
+	}
+}
@@ -55,6 +55,14 @@ func (tm *TCPMetric) Copy() *TCPMetric {
 		BASynStart:            tm.BASynStart,
 		ABSynTTL:              tm.ABSynTTL,
 		BASynTTL:              tm.BASynTTL,
+		ABSynWindow:           tm.ABSynWindow,
+		BASynWindow:           tm.BASynWindow,
+		ABSynMSS:              tm.ABSynMSS,
+		BASynMSS:              tm.BASynMSS,
+		ABSynWScale:           tm.ABSynWScale,
+		BASynWScale:           tm.BASynWScale,
+		ABSynOptions:          tm.ABSynOptions,
+		BASynOptions:          tm.BASynOptions,
 		ABFinStart:            tm.ABFinStart,
 		BAFinStart:            tm.BAFinStart,
 		ABRstStart:            tm.ABRstStart,
@@ -1,4 +1,4 @@
-// +build !linux
+// +build !linux !cgo
 
 /*
  * Copyright (C) 2018 Red Hat, Inc.
@@ -36,6 +36,7 @@ import (
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/topology/graph"
 )
 
@@ -53,6 +54,7 @@ type OnDemandProbeClient struct {
 	registeredNodes      map[string]string
 	deletedNodeCache     *cache.Cache
 	checkForRegistration *common.Debouncer
+	hostExclusions       *hostExclusions
 }
 
 type nodeProbe struct {
@@ -198,6 +200,25 @@ func (o *OnDemandProbeClient) applyGremlinExpr(query string) []interface{} {
 	return res.Values()
 }
 
+// captureNodes returns the nodes targeted by a capture. The nodes of the
+// namespace and host captures are looked up in the graph rather than through
+// their gremlin expression. The caller has to hold the lock of the graph.
+func (o *OnDemandProbeClient) captureNodes(capture *types.Capture) []interface{} {
+	if capture.NetNS == "" && len(capture.Hosts) == 0 {
+		return o.applyGremlinExpr(capture.GremlinQuery)
+	}
+
+	var nodes []interface{}
+	for _, owner := range o.graph.GetNodes(ownerFilter(capture)) {
+		for _, child := range o.graph.LookupChildren(owner, nil, topology.OwnershipMetadata) {
+			if o.isOwnedTarget(capture, child) {
+				nodes = append(nodes, child)
+			}
+		}
+	}
+	return nodes
+}
+
 // checkForRegistration check the capture gremlin expression in order to
 // register new probe.
 func (o *OnDemandProbeClient) checkForRegistrationCallback() {
@@ -212,7 +233,7 @@ func (o *OnDemandProbeClient) checkForRegistrationCallback() {
 	defer o.RUnlock()
 
 	for _, capture := range o.captures {
-		res := o.applyGremlinExpr(capture.GremlinQuery)
+		res := o.captureNodes(capture)
 		if len(res) > 0 {
 			go o.registerProbes(res, capture)
 		}
@@ -260,6 +281,23 @@ func (o *OnDemandProbeClient) OnNodeDeleted(n *graph.Node) {
 
 // OnEdgeAdded graph event
 func (o *OnDemandProbeClient) OnEdgeAdded(e *graph.Edge) {
+	// the interfaces moved or created in a captured namespace are registered
+	// right away, not to miss their first packets
+	if relationType, _ := e.GetFieldString("RelationType"); relationType == topology.OwnershipLink && o.IsMaster() {
+		parents, children := o.graph.GetEdgeNodes(e, graph.Metadata{"Type": "netns"}, nil)
+		if len(parents) > 0 && len(children) > 0 {
+			name, _ := parents[0].GetFieldString("Name")
+
+			o.RLock()
+			for _, capture := range o.captures {
+				if capture.NetNS != "" && capture.NetNS == name {
+					go o.registerProbes([]interface{}{children[0]}, capture)
+				}
+			}
+			o.RUnlock()
+		}
+	}
+
 	o.checkForRegistration.Call()
 }
 
@@ -271,7 +309,7 @@ func (o *OnDemandProbeClient) registerCapture(capture *types.Capture) {
 	o.captures[capture.UUID] = capture
 	o.Unlock()
 
-	nodes := o.applyGremlinExpr(capture.GremlinQuery)
+	nodes := o.captureNodes(capture)
 	if len(nodes) > 0 {
 		go o.registerProbes(nodes, capture)
 	}
@@ -295,13 +333,7 @@ func (o *OnDemandProbeClient) unregisterCapture(capture *types.Capture) {
 	delete(o.captures, capture.UUID)
 	o.Unlock()
 
-	res, err := ge.TopologyGremlinQuery(o.graph, capture.GremlinQuery)
-	if err != nil {
-		logging.GetLogger().Errorf("Gremlin error: %s", err.Error())
-		return
-	}
-
-	for _, value := range res.Values() {
+	for _, value := range o.captureNodes(capture) {
 		switch e := value.(type) {
 		case *graph.Node:
 			o.unregisterProbe(e, capture)
@@ -400,6 +432,7 @@ func NewOnDemandProbeClient(g *graph.Graph, ch *api.CaptureAPIHandler, agentPool
 		captures:         captures,
 		registeredNodes:  make(map[string]string),
 		deletedNodeCache: cache.New(elector.TTL()*2, elector.TTL()*2),
+		hostExclusions:   newHostExclusionsFromConfig(),
 	}
 	o.checkForRegistration = common.NewDebouncer(time.Second, o.checkForRegistrationCallback)
 
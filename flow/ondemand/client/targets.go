@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"path/filepath"
+	"strconv"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// hostExclusions describes the interfaces left out of the host captures,
+// like the loopback or the interfaces of the management VLANs
+type hostExclusions struct {
+	interfaces []string
+	vlans      map[int64]bool
+}
+
+// excluded returns whether the interface matches one of the name patterns
+// or carries one of the excluded VLANs. The caller has to hold the lock of
+// the graph.
+func (e *hostExclusions) excluded(g *graph.Graph, n *graph.Node) bool {
+	name, _ := n.GetFieldString("Name")
+	for _, pattern := range e.interfaces {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	if len(e.vlans) > 0 {
+		for _, child := range g.LookupChildren(n, nil, graph.Metadata{"RelationType": topology.Layer2Link, "Type": "vlan"}) {
+			if vlan, err := child.GetFieldInt64("Vlan"); err == nil && e.vlans[vlan] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newHostExclusionsFromConfig() *hostExclusions {
+	e := &hostExclusions{
+		interfaces: config.GetStringSlice("analyzer.capture.host_exclusions.interfaces"),
+		vlans:      make(map[int64]bool),
+	}
+
+	for _, vlan := range config.GetStringSlice("analyzer.capture.host_exclusions.vlans") {
+		id, err := strconv.ParseInt(vlan, 10, 64)
+		if err != nil {
+			logging.GetLogger().Errorf("Invalid excluded VLAN %s: %s", vlan, err)
+			continue
+		}
+		e.vlans[id] = true
+	}
+
+	return e
+}
+
+// ownerFilter returns the filter of the nodes owning the interfaces of a
+// namespace or host capture
+func ownerFilter(capture *types.Capture) graph.GraphElementMatcher {
+	if capture.NetNS != "" {
+		return graph.Metadata{"Type": "netns", "Name": capture.NetNS}
+	}
+
+	var names []*filters.Filter
+	for _, host := range capture.Hosts {
+		if host == types.AllHosts {
+			return graph.Metadata{"Type": "host"}
+		}
+		names = append(names, filters.NewTermStringFilter("Name", host))
+	}
+
+	return graph.NewGraphElementFilter(filters.NewAndFilter(
+		filters.NewTermStringFilter("Type", "host"),
+		filters.NewOrFilter(names...),
+	))
+}
+
+// isOwnedTarget returns whether an interface owned by the namespace or the
+// host of a capture has to be captured. Host captures only target the
+// physical interfaces which are not excluded.
+func (o *OnDemandProbeClient) isOwnedTarget(capture *types.Capture, n *graph.Node) bool {
+	if capture.NetNS != "" {
+		return true
+	}
+
+	if tp, _ := n.GetFieldString("Type"); tp != "device" {
+		return false
+	}
+	return !o.hostExclusions.excluded(o.graph, n)
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"encoding/hex"
+
+	"github.com/google/gopacket/layers"
+	"github.com/spaolacci/murmur3"
+)
+
+const (
+	// payloadTrackingMinLength is the minimal payload length used to track a
+	// flow, shorter payloads like keep-alives being too common
+	payloadTrackingMinLength = 16
+	// payloadTrackingLength is the number of payload bytes hashed, the
+	// proxies being likely to rewrite the payload farther, like the HTTP headers
+	payloadTrackingLength = 64
+)
+
+// payloadTrackingID returns an identifier of the first bytes of the packet
+// payload. The IPv4/IPv6 translators and the transparent proxies keep them
+// unchanged while changing the addresses, the ports or the address family.
+func payloadTrackingID(packet *Packet) string {
+	var protocol string
+	var payload []byte
+
+	if layer, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		protocol, payload = "TCP", layer.LayerPayload()
+	} else if layer, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		protocol, payload = "UDP", layer.LayerPayload()
+	} else if layer, ok := packet.Layer(layers.LayerTypeICMPv4).(*ICMPv4); ok {
+		// the ICMP echoes are translated from one family to the other
+		if layer.Type == ICMPType_ECHO {
+			protocol, payload = "ECHO", layer.Payload()
+		}
+	} else if layer, ok := packet.Layer(layers.LayerTypeICMPv6).(*ICMPv6); ok {
+		if layer.Type == ICMPType_ECHO {
+			protocol, payload = "ECHO", layer.Payload()
+		}
+	}
+
+	if len(payload) < payloadTrackingMinLength {
+		return ""
+	}
+	if len(payload) > payloadTrackingLength {
+		payload = payload[:payloadTrackingLength]
+	}
+
+	hasher := murmur3.New64()
+	hasher.Write([]byte(protocol))
+	hasher.Write(payload)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func payloadFlow(t *testing.T, network gopacket.NetworkLayer, transport gopacket.SerializableLayer, payload string) *Flow {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2}}
+
+	eth.EthernetType = layers.EthernetTypeIPv4
+	if network.LayerType() == layers.LayerTypeIPv6 {
+		eth.EthernetType = layers.EthernetTypeIPv6
+	}
+	transport.(interface {
+		SetNetworkLayerForChecksum(gopacket.NetworkLayer) error
+	}).SetNetworkLayerForChecksum(network)
+
+	buffer := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opts, eth, network.(gopacket.SerializableLayer), transport, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	gp := gopacket.NewPacket(buffer.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	gp.Metadata().CaptureInfo.Timestamp = time.Unix(1500000000, 0)
+
+	ps := PacketSeqFromGoPacket(gp, 0, nil, nil)
+	if len(ps.Packets) != 1 {
+		t.Fatalf("Expected one packet, got %d", len(ps.Packets))
+	}
+
+	f := NewFlow()
+	f.initFromPacket(ps.Packets[0].Key("", FlowOpts{}), ps.Packets[0], "", FlowUUIDs{}, FlowOpts{})
+	return f
+}
+
+func TestPayloadTrackingID(t *testing.T) {
+	request := "GET /index.html HTTP/1.1\r\nHost: www.example.com\r\nUser-Agent: curl/7.58.0\r\nAccept: */*\r\n\r\n"
+
+	ipv6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("64:ff9b::c000:201")}
+	ipv4 := &layers.IPv4{Version: 4, TTL: 63, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{192, 0, 2, 100}, DstIP: net.IP{192, 0, 2, 1}}
+
+	// both sides of a NAT64 translation, the source port being translated too
+	f6 := payloadFlow(t, ipv6, &layers.TCP{SrcPort: 40000, DstPort: 80, PSH: true, ACK: true, Window: 1024}, request)
+	f4 := payloadFlow(t, ipv4, &layers.TCP{SrcPort: 61000, DstPort: 80, PSH: true, ACK: true, Window: 1024}, request)
+
+	if f6.PayloadTrackingID == "" || f6.PayloadTrackingID != f4.PayloadTrackingID {
+		t.Errorf("Translated flows should share their payload tracking ID: %s %s", f6.PayloadTrackingID, f4.PayloadTrackingID)
+	}
+	if f6.L3TrackingID == f4.L3TrackingID {
+		t.Error("Translated flows should have different L3 tracking IDs")
+	}
+
+	// the headers rewritten by a proxy after the first bytes are ignored
+	proxied := payloadFlow(t, ipv4, &layers.TCP{SrcPort: 61001, DstPort: 80, PSH: true, ACK: true, Window: 1024}, request[:len(request)-2]+"Via: 1.1 proxy\r\n\r\n")
+	if proxied.PayloadTrackingID != f4.PayloadTrackingID {
+		t.Error("Proxied flows should share their payload tracking ID")
+	}
+
+	udp := &layers.IPv4{Version: 4, TTL: 63, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{192, 0, 2, 100}, DstIP: net.IP{192, 0, 2, 1}}
+	if f := payloadFlow(t, udp, &layers.UDP{SrcPort: 61000, DstPort: 80}, request); f.PayloadTrackingID == f4.PayloadTrackingID {
+		t.Error("Payload tracking ID should depend on the transport protocol")
+	}
+
+	if f := payloadFlow(t, ipv4, &layers.TCP{SrcPort: 61000, DstPort: 80, ACK: true, Window: 1024}, "\r\n"); f.PayloadTrackingID != "" {
+		t.Errorf("Too short payloads should not be tracked: %s", f.PayloadTrackingID)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skydive-project/skydive/version"
+)
+
+const (
+	pcapNgSectionHeaderBlock       uint32 = 0x0A0D0D0A
+	pcapNgInterfaceDescBlock       uint32 = 0x00000001
+	pcapNgEnhancedPacketBlock      uint32 = 0x00000006
+	pcapNgByteOrderMagic           uint32 = 0x1A2B3C4D
+	pcapNgOptionEnd                uint16 = 0
+	pcapNgOptionComment            uint16 = 1
+	pcapNgOptionIfName             uint16 = 2
+	pcapNgOptionIfDescription      uint16 = 3
+	pcapNgOptionShbUserApplication uint16 = 4
+)
+
+// PcapNgWriter writes raw packets in the pcapng format. Each flow is
+// described by its own interface block holding the flow metadata so that
+// the packets can be related to their flow in Wireshark.
+type PcapNgWriter struct {
+	w          io.Writer
+	interfaces map[string]uint32
+}
+
+type pcapNgOption struct {
+	code  uint16
+	value []byte
+}
+
+func pcapNgPadding(l int) int {
+	return (4 - l%4) % 4
+}
+
+func pcapNgOptionsLength(options []pcapNgOption) int {
+	if len(options) == 0 {
+		return 0
+	}
+
+	l := 4 // end of options
+	for _, o := range options {
+		l += 4 + len(o.value) + pcapNgPadding(len(o.value))
+	}
+	return l
+}
+
+func (p *PcapNgWriter) writeBlock(blockType uint32, body []byte, options []pcapNgOption) error {
+	length := 12 + len(body) + pcapNgPadding(len(body)) + pcapNgOptionsLength(options)
+
+	buf := make([]byte, 0, length)
+	buf = appendUint32(buf, blockType)
+	buf = appendUint32(buf, uint32(length))
+	buf = append(buf, body...)
+	buf = append(buf, make([]byte, pcapNgPadding(len(body)))...)
+
+	if len(options) > 0 {
+		for _, o := range options {
+			buf = appendUint16(buf, o.code)
+			buf = appendUint16(buf, uint16(len(o.value)))
+			buf = append(buf, o.value...)
+			buf = append(buf, make([]byte, pcapNgPadding(len(o.value)))...)
+		}
+		buf = appendUint16(buf, pcapNgOptionEnd)
+		buf = appendUint16(buf, 0)
+	}
+
+	buf = appendUint32(buf, uint32(length))
+
+	_, err := p.w.Write(buf)
+	return err
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var a [2]byte
+	binary.LittleEndian.PutUint16(a[:], v)
+	return append(b, a[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var a [4]byte
+	binary.LittleEndian.PutUint32(a[:], v)
+	return append(b, a[:]...)
+}
+
+func (p *PcapNgWriter) writeSectionHeader() error {
+	body := appendUint32(nil, pcapNgByteOrderMagic)
+	body = appendUint16(body, 1)
+	body = appendUint16(body, 0)
+	// section length not specified
+	body = appendUint32(body, 0xFFFFFFFF)
+	body = appendUint32(body, 0xFFFFFFFF)
+
+	options := []pcapNgOption{
+		{code: pcapNgOptionShbUserApplication, value: []byte("Skydive " + version.Version)},
+	}
+
+	return p.writeBlock(pcapNgSectionHeaderBlock, body, options)
+}
+
+// flowDescription returns the flow metadata as a list of key=value
+func flowDescription(f *Flow) string {
+	fields := map[string]string{
+		"UUID":         f.UUID,
+		"TrackingID":   f.TrackingID,
+		"L3TrackingID": f.L3TrackingID,
+		"NodeTID":      f.NodeTID,
+		"LayersPath":   f.LayersPath,
+		"Application":  f.Application,
+	}
+
+	if f.Link != nil {
+		fields["Link.A"], fields["Link.B"] = f.Link.A, f.Link.B
+	}
+	if f.Network != nil {
+		fields["Network.A"], fields["Network.B"] = f.Network.A, f.Network.B
+	}
+	if f.Transport != nil {
+		fields["Transport.A"] = strconv.FormatInt(f.Transport.A, 10)
+		fields["Transport.B"] = strconv.FormatInt(f.Transport.B, 10)
+	}
+
+	var desc []string
+	for k, v := range fields {
+		if v != "" {
+			desc = append(desc, k+"="+v)
+		}
+	}
+	sort.Strings(desc)
+
+	return strings.Join(desc, " ")
+}
+
+func (p *PcapNgWriter) writeInterface(uuid string, fr *RawPackets, f *Flow) (uint32, error) {
+	if id, ok := p.interfaces[uuid]; ok {
+		return id, nil
+	}
+
+	body := appendUint16(nil, uint16(fr.LinkType))
+	body = appendUint16(body, 0)
+	body = appendUint32(body, MaxCaptureLength)
+
+	options := []pcapNgOption{
+		{code: pcapNgOptionIfName, value: []byte(uuid)},
+	}
+	if f != nil {
+		options = append(options, pcapNgOption{code: pcapNgOptionIfDescription, value: []byte(flowDescription(f))})
+	}
+
+	if err := p.writeBlock(pcapNgInterfaceDescBlock, body, options); err != nil {
+		return 0, err
+	}
+
+	id := uint32(len(p.interfaces))
+	p.interfaces[uuid] = id
+
+	return id, nil
+}
+
+// WriteRawPackets writes the raw packets of the flow identified by uuid. The
+// flow, if not nil, is used to add metadata to the packets.
+func (p *PcapNgWriter) WriteRawPackets(uuid string, fr *RawPackets, f *Flow) error {
+	id, err := p.writeInterface(uuid, fr, f)
+	if err != nil {
+		return err
+	}
+
+	comment := fmt.Sprintf("Flow %s", uuid)
+	if f != nil && f.TrackingID != "" {
+		comment += " TrackingID " + f.TrackingID
+	}
+
+	for _, r := range fr.RawPackets {
+		// raw packet timestamps are in milliseconds, the default resolution is the microsecond
+		ts := uint64(r.Timestamp) * 1000
+
+		body := appendUint32(nil, id)
+		body = appendUint32(body, uint32(ts>>32))
+		body = appendUint32(body, uint32(ts))
+		body = appendUint32(body, uint32(len(r.Data)))
+		body = appendUint32(body, uint32(len(r.Data)))
+		body = append(body, r.Data...)
+
+		options := []pcapNgOption{
+			{code: pcapNgOptionComment, value: []byte(fmt.Sprintf("%s Index %d", comment, r.Index))},
+		}
+
+		if err := p.writeBlock(pcapNgEnhancedPacketBlock, body, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewPcapNgWriter returns a new PcapNgWriter writing the section header
+// to the given io.Writer
+func NewPcapNgWriter(w io.Writer) (*PcapNgWriter, error) {
+	p := &PcapNgWriter{
+		w:          w,
+		interfaces: make(map[string]uint32),
+	}
+
+	if err := p.writeSectionHeader(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestPcapNgWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	pw, err := NewPcapNgWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr := &RawPackets{
+		LinkType: layers.LinkTypeEthernet,
+		RawPackets: []*RawPacket{
+			{Timestamp: 1, Index: 0, Data: []byte{1, 2, 3}},
+			{Timestamp: 2, Index: 1, Data: []byte{4, 5, 6, 7, 8}},
+		},
+	}
+	f := &Flow{UUID: "flow1", TrackingID: "tracking1", NodeTID: "node1"}
+
+	if err := pw.WriteRawPackets("flow1", fr, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.WriteRawPackets("flow2", fr, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	var blocks []uint32
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("Truncated block: %v", data)
+		}
+
+		blockType := binary.LittleEndian.Uint32(data[0:4])
+		length := binary.LittleEndian.Uint32(data[4:8])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatalf("Wrong block length %d", length)
+		}
+		if trailer := binary.LittleEndian.Uint32(data[length-4 : length]); trailer != length {
+			t.Fatalf("Block length mismatch %d != %d", trailer, length)
+		}
+
+		blocks = append(blocks, blockType)
+		data = data[length:]
+	}
+
+	expected := []uint32{
+		pcapNgSectionHeaderBlock,
+		pcapNgInterfaceDescBlock, pcapNgEnhancedPacketBlock, pcapNgEnhancedPacketBlock,
+		pcapNgInterfaceDescBlock, pcapNgEnhancedPacketBlock, pcapNgEnhancedPacketBlock,
+	}
+
+	if len(blocks) != len(expected) {
+		t.Fatalf("Expected %d blocks, got %d", len(expected), len(blocks))
+	}
+	for i := range expected {
+		if blocks[i] != expected[i] {
+			t.Errorf("Expected block %d to be of type %x, got %x", i, expected[i], blocks[i])
+		}
+	}
+
+	if !strings.Contains(buf.String(), "TrackingID=tracking1") {
+		t.Error("Flow metadata not found in the interface description")
+	}
+}
@@ -1,4 +1,4 @@
-// +build linux
+// +build linux,cgo
 
 /*
  * Copyright (C) 2016 Red Hat, Inc.
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+)
+
+// CheckByteOrder runs the byte order self-test of the capture paths, the
+// host conversions, the decoding of a reference frame and the eBPF object
+// when compiled in all have to agree with the byte order of the host
+func CheckByteOrder() error {
+	if err := common.CheckByteOrder(); err != nil {
+		return err
+	}
+
+	if err := checkDecodeByteOrder(); err != nil {
+		return err
+	}
+
+	return checkEBPFByteOrder()
+}
+
+// checkDecodeByteOrder decodes a reference UDP frame in which none of the
+// multi-byte fields is a palindrome so that any swap is noticed
+func checkDecodeByteOrder() error {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+		DstMAC:       net.HardwareAddr{0x00, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{192, 0, 2, 1},
+		DstIP:    net.IP{198, 51, 100, 2},
+	}
+	udp := &layers.UDP{SrcPort: 0x1234, DstPort: 0x5678}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buffer := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opts, eth, ip, udp, gopacket.Payload([]byte{0xca, 0xfe})); err != nil {
+		return fmt.Errorf("Unable to build the reference frame: %s", err)
+	}
+
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	f := flow.NewFlowFromGoPacket(packet, "", flow.FlowUUIDs{}, flow.FlowOpts{})
+	if f == nil || f.Network == nil || f.Transport == nil {
+		return fmt.Errorf("Unable to decode the reference frame")
+	}
+
+	if f.Network.A != "192.0.2.1" || f.Network.B != "198.51.100.2" {
+		return fmt.Errorf("Wrong addresses decoded from the reference frame: %s -> %s", f.Network.A, f.Network.B)
+	}
+
+	if f.Transport.A != 0x1234 || f.Transport.B != 0x5678 {
+		return fmt.Errorf("Wrong ports decoded from the reference frame: 0x%04x -> 0x%04x", f.Transport.A, f.Transport.B)
+	}
+
+	return nil
+}
@@ -27,6 +27,8 @@ package probes
 import (
 	"bytes"
 	"crypto/sha1"
+	delf "debug/elf"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -416,12 +418,49 @@ func (p *EBPFProbesHandler) Stop() {
 	p.wg.Wait()
 }
 
+// checkModuleByteOrder ensures that the eBPF elf binary was built for the
+// byte order of the host, the flow structures of the maps are read as is
+func checkModuleByteOrder(data []byte) error {
+	f, err := delf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("Unable to parse eBPF elf binary: %s", err)
+	}
+	defer f.Close()
+
+	if f.Machine != delf.EM_BPF {
+		return fmt.Errorf("eBPF elf binary is built for %s instead of BPF", f.Machine)
+	}
+
+	if f.ByteOrder != common.NativeEndian {
+		march := "bpfel"
+		if common.NativeEndian == binary.BigEndian {
+			march = "bpfeb"
+		}
+		return fmt.Errorf("eBPF elf binary is %s while the host is %s, rebuild it with EBPF_MARCH=%s", f.ByteOrder, common.NativeEndian, march)
+	}
+
+	return nil
+}
+
+func checkEBPFByteOrder() error {
+	data, err := statics.Asset("probe/ebpf/flow.o")
+	if err != nil {
+		return fmt.Errorf("Unable to find eBPF elf binary in bindata")
+	}
+
+	return checkModuleByteOrder(data)
+}
+
 func loadModule() (*elf.Module, error) {
 	data, err := statics.Asset("probe/ebpf/flow.o")
 	if err != nil {
 		return nil, fmt.Errorf("Unable to find eBPF elf binary in bindata")
 	}
 
+	if err := checkModuleByteOrder(data); err != nil {
+		return nil, err
+	}
+
 	reader := bytes.NewReader(data)
 
 	module := elf.NewModuleFromReader(reader)
@@ -1,4 +1,4 @@
-// +build linux
+// +build linux,cgo
 
 /*
  * Copyright (C) 2016 Red Hat, Inc.
@@ -69,6 +69,122 @@ type GoPacketProbesHandler struct {
 	probesLock common.RWMutex
 }
 
+func (p *GoPacketProbe) addTableStats(t *graph.MetadataTransaction) {
+	stats := p.flowTable.Stats()
+	t.AddMetadata("Capture.PacketsDecodingErrors", stats.PacketsDecodingErrors)
+	t.AddMetadata("Capture.PacketsMalformed", stats.PacketsMalformed)
+	t.AddMetadata("Capture.Packets", stats.Packets)
+	t.AddMetadata("Capture.Bytes", stats.Bytes)
+	t.AddMetadata("Capture.FlowsCreated", stats.FlowsCreated)
+
+	// top talkers of the last stats interval
+	talkers := p.flowTable.TopTalkers()
+	top := make([]interface{}, len(talkers))
+	for i, talker := range talkers {
+		top[i] = map[string]interface{}{
+			"Address": talker.Address,
+			"Packets": talker.Packets,
+			"Bytes":   talker.Bytes,
+		}
+	}
+	t.AddMetadata("Capture.TopTalkers", top)
+
+	// the state of the virtual routers is reported as metadata so that
+	// their failovers are graph events that alerts can match
+	if routers := p.flowTable.VirtualRouters(); len(routers) > 0 {
+		m := make(map[string]interface{}, len(routers))
+		for key, r := range routers {
+			vips := make([]interface{}, len(r.VirtualIPs))
+			for i, ip := range r.VirtualIPs {
+				vips[i] = ip
+			}
+
+			m[key] = map[string]interface{}{
+				"Protocol":       r.Protocol,
+				"ID":             r.ID,
+				"VirtualIPs":     vips,
+				"State":          r.State,
+				"Master":         r.Master,
+				"PreviousMaster": r.PreviousMaster,
+				"Standby":        r.Standby,
+				"Priority":       r.Priority,
+				"Transitions":    r.Transitions,
+				"LastTransition": r.LastTransition,
+				"LastSeen":       r.LastSeen,
+			}
+		}
+		t.AddMetadata("Capture.VirtualRouters", m)
+	}
+}
+
+// addDHCPTransactions attaches the completed DHCP transactions, as
+// annotation nodes, to the captured node and to the interface of the
+// client. The caller has to hold the lock of the graph.
+func (p *GoPacketProbe) addDHCPTransactions(g *graph.Graph, n *graph.Node) {
+	transactions := p.flowTable.DHCPTransactions()
+	if len(transactions) == 0 {
+		return
+	}
+
+	if ttl := time.Duration(config.GetInt("agent.capture.dhcp.ttl")) * time.Second; ttl > 0 {
+		expire := common.UnixMillis(time.Now().Add(-ttl))
+		for _, an := range g.GetNodes(graph.Metadata{"Type": "annotation", "Manager": "dhcp"}) {
+			if timestamp, err := an.GetFieldInt64("Annotation.Timestamp"); err == nil && timestamp < expire {
+				g.DelNode(an)
+			}
+		}
+	}
+
+	for _, t := range transactions {
+		messages := make([]interface{}, len(t.Messages))
+		for i, message := range t.Messages {
+			messages[i] = message
+		}
+
+		options := make(map[string]interface{}, len(t.Options))
+		for key, value := range t.Options {
+			options[key] = value
+		}
+
+		dhcp := map[string]interface{}{
+			"TransactionID": t.TransactionID,
+			"ClientMAC":     t.ClientMAC,
+			"State":         t.State,
+			"Messages":      messages,
+			"Start":         t.Start,
+			"Last":          t.Last,
+		}
+		for key, value := range map[string]string{"Hostname": t.Hostname, "RequestedIP": t.RequestedIP, "VendorClass": t.VendorClass, "ParameterList": t.ParameterList, "OfferedIP": t.OfferedIP, "Server": t.Server} {
+			if value != "" {
+				dhcp[key] = value
+			}
+		}
+		if t.LeaseTime != 0 {
+			dhcp["LeaseTime"] = t.LeaseTime
+		}
+		if len(options) > 0 {
+			dhcp["Options"] = options
+		}
+
+		an := g.NewNode(graph.GenID(), graph.Metadata{
+			"Type":    "annotation",
+			"Manager": "dhcp",
+			"Name":    fmt.Sprintf("DHCP %s %s", t.State, t.ClientMAC),
+			"Annotation": map[string]interface{}{
+				"Source":    "dhcp",
+				"Kind":      "dhcp",
+				"Timestamp": t.Last,
+			},
+			"DHCP": dhcp,
+		})
+
+		g.Link(an, n, graph.Metadata{"RelationType": "annotation"})
+		if client := g.LookupFirstNode(graph.Metadata{"MAC": t.ClientMAC}); client != nil && client.ID != n.ID {
+			g.Link(an, client, graph.Metadata{"RelationType": "annotation"})
+		}
+	}
+}
+
 func (p *GoPacketProbe) pcapUpdateStats(g *graph.Graph, n *graph.Node, handle *pcap.Handle, ticker *time.Ticker, done chan bool, wg *sync.WaitGroup) {
 	defer wg.Done()
 
@@ -83,7 +199,9 @@ func (p *GoPacketProbe) pcapUpdateStats(g *graph.Graph, n *graph.Node, handle *p
 				t.AddMetadata("Capture.PacketsReceived", stats.PacketsReceived)
 				t.AddMetadata("Capture.PacketsDropped", stats.PacketsDropped)
 				t.AddMetadata("Capture.PacketsIfDropped", stats.PacketsIfDropped)
+				p.addTableStats(t)
 				t.Commit()
+				p.addDHCPTransactions(g, n)
 				g.Unlock()
 			}
 		case <-done:
@@ -105,7 +223,9 @@ func (p *GoPacketProbe) afpacketUpdateStats(g *graph.Graph, n *graph.Node, handl
 				t := g.StartMetadataTransaction(n)
 				t.AddMetadata("Capture.PacketsReceived", v3.Packets())
 				t.AddMetadata("Capture.PacketsDropped", v3.Drops())
+				p.addTableStats(t)
 				t.Commit()
+				p.addDHCPTransactions(g, n)
 				g.Unlock()
 			}
 		case <-done:
@@ -55,3 +55,7 @@ func (p *EBPFProbesHandler) Stop() {
 func NewEBPFProbesHandler(g *graph.Graph, fpta *FlowProbeTableAllocator) (*EBPFProbesHandler, error) {
 	return nil, ErrProbeNotCompiled
 }
+
+func checkEBPFByteOrder() error {
+	return nil
+}
@@ -1,4 +1,4 @@
-// +build !linux
+// +build !linux !cgo
 
 /*
  * Copyright (C) 2016 Red Hat, Inc.
@@ -54,5 +54,5 @@ func (p *GoPacketProbesHandler) Stop() {
 
 // NewGoPacketProbesHandler creates a new gopacket probe in the graph
 func NewGoPacketProbesHandler(g *graph.Graph, fpta *FlowProbeTableAllocator) (*GoPacketProbesHandler, error) {
-	return nil, common.ErrNotImplemented
+	return nil, ErrProbeNotCompiled
 }
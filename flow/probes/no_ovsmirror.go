@@ -1,4 +1,4 @@
-// +build !linux
+// +build !linux noovsdb
 
 /*
  * Copyright (C) 2018 Red Hat, Inc.
@@ -54,5 +54,5 @@ func (p *OvsMirrorProbesHandler) Stop() {
 
 // NewOvsMirrorProbesHandler creates a new OVS Mirror probes
 func NewOvsMirrorProbesHandler(g *graph.Graph, tb, fb *probe.ProbeBundle) (*OvsMirrorProbesHandler, error) {
-	return nil, common.ErrNotImplemented
+	return nil, ErrProbeNotCompiled
 }
@@ -1,3 +1,5 @@
+// +build !noovsdb
+
 /*
  * Copyright (C) 2015 Red Hat, Inc.
  *
@@ -1,4 +1,4 @@
-// +build linux
+// +build linux,!noovsdb
 
 /*
  * Copyright (C) 2017 Red Hat, Inc.
@@ -23,27 +23,58 @@
 package probes
 
 import (
+	"bufio"
+	"crypto/subtle"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/topology/graph"
 )
 
 // PcapSocketProbe describes a TCP packet listener that inject packets in a flowtable
 type PcapSocketProbe struct {
-	node      *graph.Node
-	state     int64
-	flowTable *flow.Table
-	listener  *net.TCPListener
-	port      int
-	bpfFilter string
+	sync.Mutex
+	graph         *graph.Graph
+	node          *graph.Node
+	state         int64
+	fpta          *FlowProbeTableAllocator
+	flowTable     *flow.Table
+	opts          flow.TableOpts
+	listener      *net.TCPListener
+	port          int
+	bpfFilter     string
+	tokens        map[string]string
+	maxClients    int
+	timeout       time.Duration
+	wg            sync.WaitGroup
+	clients       map[net.Conn]bool
+	capturePoints map[string]*pcapSocketCapturePoint
+}
+
+// pcapSocketCapturePoint describes the synthetic capture point, child of the
+// captured node, to which the packets of the clients using a token are
+// attributed
+type pcapSocketCapturePoint struct {
+	node          *graph.Node
+	flowTable     *flow.Table
+	packetSeqChan chan *flow.PacketSequence
+}
+
+// pcapSocketConn reads the pcap stream of a client after its handshake,
+// through the reader which may have buffered the beginning of the stream
+type pcapSocketConn struct {
+	net.Conn
+	reader *bufio.Reader
 }
 
 // PcapSocketProbeHandler describes a Pcap socket probe in the graph
@@ -51,12 +82,19 @@ type PcapSocketProbeHandler struct {
 	graph         *graph.Graph
 	fpta          *FlowProbeTableAllocator
 	addr          *net.TCPAddr
+	tokens        map[string]string
+	maxClients    int
+	timeout       time.Duration
 	wg            sync.WaitGroup
 	probes        map[string]*PcapSocketProbe
 	probesLock    common.RWMutex
 	portAllocator *common.PortAllocator
 }
 
+func (c *pcapSocketConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
 func (p *PcapSocketProbe) run() {
 	atomic.StoreInt64(&p.state, common.RunningState)
 
@@ -72,15 +110,163 @@ func (p *PcapSocketProbe) run() {
 			break
 		}
 
-		feeder, err := flow.NewPcapTableFeeder(conn, packetSeqChan, true, p.bpfFilter)
+		if !p.addClient(conn) {
+			logging.GetLogger().Warningf("Rejected pcap socket client %s, maximum of %d clients reached", conn.RemoteAddr(), p.maxClients)
+			conn.Close()
+			continue
+		}
+
+		p.wg.Add(1)
+		go p.serveClient(conn, packetSeqChan)
+	}
+
+	p.closeClients()
+	p.wg.Wait()
+	p.releaseCapturePoints()
+}
+
+func (p *PcapSocketProbe) addClient(conn net.Conn) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.maxClients > 0 && len(p.clients) >= p.maxClients {
+		return false
+	}
+	p.clients[conn] = true
+
+	return true
+}
+
+func (p *PcapSocketProbe) removeClient(conn net.Conn) {
+	p.Lock()
+	delete(p.clients, conn)
+	p.Unlock()
+
+	conn.Close()
+}
+
+func (p *PcapSocketProbe) closeClients() {
+	p.Lock()
+	defer p.Unlock()
+
+	for conn := range p.clients {
+		conn.Close()
+	}
+}
+
+// authenticate returns the capture point associated to the token, an empty
+// capture point meaning the captured node itself
+func (p *PcapSocketProbe) authenticate(token string) (string, bool) {
+	for t, capturePoint := range p.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return capturePoint, true
+		}
+	}
+	return "", false
+}
+
+// capturePoint returns the packet channel of the flow table of a capture
+// point, creating its node and its flow table at its first client
+func (p *PcapSocketProbe) capturePoint(name string) (chan *flow.PacketSequence, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if cp, found := p.capturePoints[name]; found {
+		return cp.packetSeqChan, nil
+	}
+
+	p.graph.Lock()
+	node := p.graph.NewNode(graph.GenID(), graph.Metadata{
+		"Type":    "capturepoint",
+		"Manager": "pcapsocket",
+		"Name":    name,
+	})
+	topology.AddOwnershipLink(p.graph, p.node, node, nil)
+	tid, _ := node.GetFieldString("TID")
+	if tid == "" {
+		p.graph.DelNode(node)
+	}
+	p.graph.Unlock()
+
+	if tid == "" {
+		return nil, fmt.Errorf("No TID for capture point %s", name)
+	}
+
+	ft := p.fpta.Alloc(tid, p.opts)
+	packetSeqChan, _ := ft.Start()
+
+	p.capturePoints[name] = &pcapSocketCapturePoint{
+		node:          node,
+		flowTable:     ft,
+		packetSeqChan: packetSeqChan,
+	}
+
+	return packetSeqChan, nil
+}
+
+func (p *PcapSocketProbe) releaseCapturePoints() {
+	p.Lock()
+	defer p.Unlock()
+
+	for name, cp := range p.capturePoints {
+		cp.flowTable.Stop()
+		p.fpta.Release(cp.flowTable)
+
+		p.graph.Lock()
+		p.graph.DelNode(cp.node)
+		p.graph.Unlock()
+
+		delete(p.capturePoints, name)
+	}
+}
+
+// serveClient authenticates a client, when tokens are configured, and feeds
+// the flow table of its capture point with its pcap stream until it closes it
+func (p *PcapSocketProbe) serveClient(conn net.Conn, packetSeqChan chan *flow.PacketSequence) {
+	defer p.wg.Done()
+	defer p.removeClient(conn)
+
+	if p.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(p.timeout))
+	}
+
+	reader := bufio.NewReader(conn)
+
+	if len(p.tokens) > 0 {
+		line, err := reader.ReadString('\n')
 		if err != nil {
-			logging.GetLogger().Errorf("Failed to create pcap table feeder: %s", err)
+			logging.GetLogger().Warningf("Failed to read token of pcap socket client %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+
+		capturePoint, ok := p.authenticate(strings.TrimSpace(line))
+		if !ok {
+			logging.GetLogger().Warningf("Rejected pcap socket client %s, invalid token", conn.RemoteAddr())
 			return
 		}
 
-		feeder.Start()
-		defer feeder.Stop()
+		if capturePoint != "" {
+			if packetSeqChan, err = p.capturePoint(capturePoint); err != nil {
+				logging.GetLogger().Errorf("Failed to create capture point of pcap socket client %s: %s", conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+
+	feeder, err := flow.NewPcapTableFeeder(&pcapSocketConn{Conn: conn, reader: reader}, packetSeqChan, true, p.bpfFilter)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to create pcap table feeder: %s", err)
+		return
 	}
+	conn.SetReadDeadline(time.Time{})
+
+	logging.GetLogger().Debugf("Pcap socket client %s connected", conn.RemoteAddr())
+
+	feeder.Start()
+	feeder.Wait()
+	feeder.Stop()
+
+	logging.GetLogger().Debugf("Pcap socket client %s disconnected", conn.RemoteAddr())
 }
 
 // RegisterProbe registers a new probe in the graph
@@ -103,7 +289,7 @@ func (p *PcapSocketProbeHandler) RegisterProbe(n *graph.Node, capture *types.Cap
 	var tcpAddr = *p.addr
 	tcpAddr.Port = port
 
-	listener, err := net.ListenTCP("tcp", &tcpAddr)
+	listener, err := net.ListenTCP(common.IPNetwork("tcp"), &tcpAddr)
 	if err != nil {
 		logging.GetLogger().Errorf("Failed to listen on TDP socket %s: %s", tcpAddr.String(), err)
 		return err
@@ -113,12 +299,20 @@ func (p *PcapSocketProbeHandler) RegisterProbe(n *graph.Node, capture *types.Cap
 	ft := p.fpta.Alloc(tid, opts)
 
 	probe := &PcapSocketProbe{
-		node:      n,
-		state:     common.StoppedState,
-		flowTable: ft,
-		listener:  listener,
-		port:      port,
-		bpfFilter: capture.BPFFilter,
+		graph:         p.graph,
+		node:          n,
+		state:         common.StoppedState,
+		fpta:          p.fpta,
+		flowTable:     ft,
+		opts:          opts,
+		listener:      listener,
+		port:          port,
+		bpfFilter:     capture.BPFFilter,
+		tokens:        p.tokens,
+		maxClients:    p.maxClients,
+		timeout:       p.timeout,
+		clients:       make(map[net.Conn]bool),
+		capturePoints: make(map[string]*pcapSocketCapturePoint),
 	}
 
 	p.probesLock.Lock()
@@ -186,8 +380,10 @@ func NewPcapSocketProbeHandler(g *graph.Graph, fpta *FlowProbeTableAllocator) (*
 	listen := config.GetString("agent.flow.pcapsocket.bind_address")
 	minPort := config.GetInt("agent.flow.pcapsocket.min_port")
 	maxPort := config.GetInt("agent.flow.pcapsocket.max_port")
+	maxClients := config.GetInt("agent.flow.pcapsocket.max_clients")
+	timeout := time.Duration(config.GetInt("agent.flow.pcapsocket.handshake_timeout")) * time.Second
 
-	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", listen, minPort))
+	addr, err := net.ResolveTCPAddr(common.IPNetwork("tcp"), common.JoinHostPort(listen, minPort))
 	if err != nil {
 		return nil, err
 	}
@@ -201,6 +397,9 @@ func NewPcapSocketProbeHandler(g *graph.Graph, fpta *FlowProbeTableAllocator) (*
 		graph:         g,
 		fpta:          fpta,
 		addr:          addr,
+		tokens:        config.GetStringMapString("agent.flow.pcapsocket.tokens"),
+		maxClients:    maxClients,
+		timeout:       timeout,
 		probes:        make(map[string]*PcapSocketProbe),
 		portAllocator: portAllocator,
 	}, nil
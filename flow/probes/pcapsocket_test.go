@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/analyzer"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// tidListener gives a TID to the nodes, like the TID mapper of the agent
+type tidListener struct {
+	graph.DefaultGraphListener
+	g *graph.Graph
+}
+
+func (l *tidListener) OnNodeAdded(n *graph.Node) {
+	if tid, _ := n.GetFieldString("TID"); tid == "" {
+		l.g.AddMetadata(n, "TID", string(graph.GenID()))
+	}
+}
+
+func newTestPcapSocketProbe(t *testing.T, tokens map[string]string) *PcapSocketProbe {
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host", backend)
+	g.AddEventListener(&tidListener{g: g})
+
+	g.Lock()
+	node := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	g.Unlock()
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fpta := &FlowProbeTableAllocator{
+		TableAllocator: flow.NewTableAllocator(time.Hour, time.Hour, flow.NewEnhancerPipeline()),
+		fcpool:         &analyzer.FlowClientPool{},
+	}
+	tid, _ := node.GetFieldString("TID")
+	opts := flow.TableOpts{}
+
+	return &PcapSocketProbe{
+		graph:         g,
+		node:          node,
+		state:         common.StoppedState,
+		fpta:          fpta,
+		flowTable:     fpta.Alloc(tid, opts),
+		opts:          opts,
+		listener:      listener,
+		tokens:        tokens,
+		timeout:       500 * time.Millisecond,
+		clients:       make(map[net.Conn]bool),
+		capturePoints: make(map[string]*pcapSocketCapturePoint),
+	}
+}
+
+// dialPcapSocket sends the token, if any, and a pcap stream and returns
+// whether the probe kept the connection open
+func dialPcapSocket(t *testing.T, p *PcapSocketProbe, token string, pcap []byte) bool {
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if token != "" {
+		if _, err := conn.Write([]byte(token + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pcap != nil {
+		if _, err := conn.Write(pcap); err != nil {
+			return false
+		}
+	}
+
+	// a rejected client is disconnected once the handshake timed out at the latest
+	conn.SetReadDeadline(time.Now().Add(2 * p.timeout))
+	_, err = conn.Read(make([]byte, 1))
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return true
+	}
+	return false
+}
+
+func capturePointNodes(p *PcapSocketProbe) []*graph.Node {
+	p.graph.RLock()
+	defer p.graph.RUnlock()
+
+	return p.graph.GetNodes(graph.Metadata{"Type": "capturepoint"})
+}
+
+func runPcapSocketProbe(t *testing.T, p *PcapSocketProbe) func() {
+	done := make(chan struct{})
+	go func() {
+		p.run()
+		close(done)
+	}()
+
+	return func() {
+		p.listener.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Pcap socket probe not stopped")
+		}
+	}
+}
+
+func TestPcapSocketAuthentication(t *testing.T) {
+	pcap, err := ioutil.ReadFile("../pcaptraces/eth-ip4-arp-dns-req-http-google.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestPcapSocketProbe(t, map[string]string{"node-token": "", "cp-token": "capture1"})
+	stop := runPcapSocketProbe(t, p)
+
+	if dialPcapSocket(t, p, "", pcap) {
+		t.Error("Expected a client without token to be rejected")
+	}
+	if dialPcapSocket(t, p, "", nil) {
+		t.Error("Expected a client sending nothing to be rejected")
+	}
+	if dialPcapSocket(t, p, "invalid-token", pcap) {
+		t.Error("Expected a client with an invalid token to be rejected")
+	}
+	if nodes := capturePointNodes(p); len(nodes) != 0 {
+		t.Errorf("Expected no capture point for the rejected clients, got: %v", nodes)
+	}
+
+	if !dialPcapSocket(t, p, "node-token", pcap) {
+		t.Error("Expected a client with a valid token to be served")
+	}
+	if nodes := capturePointNodes(p); len(nodes) != 0 {
+		t.Errorf("Expected the packets of the token to be attributed to the captured node, got: %v", nodes)
+	}
+
+	if !dialPcapSocket(t, p, "cp-token", pcap) {
+		t.Error("Expected a client with a valid token to be served")
+	}
+
+	nodes := capturePointNodes(p)
+	if len(nodes) != 1 {
+		t.Fatalf("Expected a capture point for the token, got: %v", nodes)
+	}
+	if name, _ := nodes[0].GetFieldString("Name"); name != "capture1" {
+		t.Errorf("Expected the capture point of the token, got: %s", name)
+	}
+
+	stop()
+
+	if nodes := capturePointNodes(p); len(nodes) != 0 {
+		t.Errorf("Expected the capture points to be removed with the probe, got: %v", nodes)
+	}
+}
+
+func TestPcapSocketNoAuthentication(t *testing.T) {
+	pcap, err := ioutil.ReadFile("../pcaptraces/eth-ip4-arp-dns-req-http-google.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestPcapSocketProbe(t, nil)
+	stop := runPcapSocketProbe(t, p)
+	defer stop()
+
+	if !dialPcapSocket(t, p, "", pcap) {
+		t.Error("Expected a client to be served when no token is configured")
+	}
+}
@@ -126,6 +126,8 @@ func NewFlowProbeBundle(tb *probe.ProbeBundle, g *graph.Graph, fta *flow.TableAl
 
 func tableOptsFromCapture(capture *types.Capture) flow.TableOpts {
 	layerKeyMode, _ := flow.LayerKeyModeByName(capture.LayerKeyMode)
+	keyFields, _ := flow.KeyFieldsByName(capture.FlowKey)
+	directionMode, _ := flow.DirectionModeByName(capture.FlowDirection)
 
 	return flow.TableOpts{
 		RawPacketLimit: int64(capture.RawPacketLimit),
@@ -133,5 +135,7 @@ func tableOptsFromCapture(capture *types.Capture) flow.TableOpts {
 		IPDefrag:       capture.IPDefrag,
 		ReassembleTCP:  capture.ReassembleTCP,
 		LayerKeyMode:   layerKeyMode,
+		KeyFields:      keyFields,
+		DirectionMode:  directionMode,
 	}
 }
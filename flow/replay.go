@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"io"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+// ReplayPcap feeds synchronously the table with the packets of the pcap read
+// from r. The update, expire and connection tracking jobs are triggered by
+// the capture timestamps instead of the wall clock, and all the flows are
+// expired at the end, so the handlers get callbacks only depending on the
+// pcap content. It must not be called on a started table, it returns the
+// number of packets read.
+func (ft *Table) ReplayPcap(r io.Reader, bpf *BPF) (int, error) {
+	handle, err := pcapgo.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var lastUpdate, lastExpire, lastCt time.Time
+
+	count := 0
+	for {
+		data, ci, err := handle.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+		count++
+
+		now := ci.Timestamp
+		if count == 1 {
+			lastUpdate, lastExpire, lastCt = now, now, now
+			ft.lastUpdate = common.UnixMillis(now)
+		}
+
+		if ft.updateHandler != nil && now.Sub(lastUpdate) >= ft.updateHandler.every {
+			ft.updateAt(now)
+			lastUpdate = now
+		}
+
+		if ft.expireHandler != nil && now.Sub(lastExpire) >= ft.expireHandler.every {
+			ft.expireAt(now)
+			lastExpire = now
+		}
+
+		if now.Sub(lastCt) >= ctDuration {
+			t := now.Add(-ctDuration)
+			ft.tcpAssembler.FlushOlderThan(t)
+			ft.ipDefragger.FlushOlderThan(t)
+			lastCt = now
+		}
+
+		packet := gopacket.NewPacket(data, handle.LinkType(), gopacket.Default)
+		packet.Metadata().CaptureInfo = ci
+
+		if ps := ft.packetSeqFromGoPacket(packet, 0, bpf); len(ps.Packets) > 0 {
+			ft.processPacketSeq(ps)
+		}
+	}
+
+	ft.tcpAssembler.FlushAll()
+	if ft.expireHandler != nil {
+		ft.expireNow()
+	}
+
+	return count, nil
+}
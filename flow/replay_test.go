@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func replayPcap(t *testing.T, filename string) (updates int, expired []string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	updateHandler := NewFlowHandler(func(flows []*Flow) { updates += len(flows) }, time.Second)
+	expireHandler := NewFlowHandler(func(flows []*Flow) {
+		for _, f := range flows {
+			expired = append(expired, f.UUID)
+		}
+	}, 10*time.Second)
+
+	opts := TableOpts{ExtraTCPMetric: true, ReassembleTCP: true, IPDefrag: true}
+	table := NewTable(updateHandler, expireHandler, NewEnhancerPipeline(), "", opts)
+
+	count, err := table.ReplayPcap(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatalf("No packet replayed from %s", filename)
+	}
+
+	sort.Strings(expired)
+
+	return updates, expired
+}
+
+func TestReplayPcap(t *testing.T) {
+	filename := "pcaptraces/eth-ip4-arp-dns-req-http-google.pcap"
+
+	updates, expired := replayPcap(t, filename)
+
+	flows := flowsFromPCAP(t, filename, layers.LinkTypeEthernet, nil)
+	if len(expired) != len(flows) {
+		t.Errorf("Expected %d flows expired, got %d", len(flows), len(expired))
+	}
+
+	// a second replay has to give exactly the same callbacks
+	updates2, expired2 := replayPcap(t, filename)
+	if updates != updates2 || !reflect.DeepEqual(expired, expired2) {
+		t.Errorf("Replay not deterministic, got %d updates and %v expired then %d and %v", updates, expired, updates2, expired2)
+	}
+}
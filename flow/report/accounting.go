@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package report
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// maximum number of ownership ancestors looked up for the tenant metadata
+const maxTenantDepth = 5
+
+// TenantMapping attributes the nodes to tenants based on the first of the
+// metadata keys found on the node or on its owners. Mappings optionally
+// translate the metadata values into tenant names.
+type TenantMapping struct {
+	Keys     []string
+	Mappings map[string]string
+}
+
+// TenantUsage describes the network usage of a tenant
+type TenantUsage struct {
+	Tenant  string
+	Bytes   int64
+	Packets int64
+	Flows   int
+}
+
+// Accounting describes the network usage per tenant during a time range
+type Accounting struct {
+	Start   int64
+	Last    int64
+	Tenants []*TenantUsage
+}
+
+// tenant returns the tenant of the node, looking at its owners as well
+func (tm *TenantMapping) tenant(g *graph.Graph, n *graph.Node) (string, bool) {
+	for depth := 0; n != nil && depth <= maxTenantDepth; depth++ {
+		for _, key := range tm.Keys {
+			v, err := n.GetField(key)
+			if err != nil {
+				continue
+			}
+
+			value := fmt.Sprintf("%v", v)
+			if value == "" {
+				continue
+			}
+			if tenant, ok := tm.Mappings[value]; ok {
+				return tenant, true
+			}
+			return value, true
+		}
+
+		if parents := g.LookupParents(n, nil, topology.OwnershipMetadata); len(parents) > 0 {
+			n = parents[0]
+		} else {
+			n = nil
+		}
+	}
+	return "", false
+}
+
+// Usage returns the bytes, packets and flows of each tenant between from and to
+func (r *Reporter) Usage(tm *TenantMapping, from, to time.Time) (*Accounting, error) {
+	accounting := &Accounting{
+		Start:   common.UnixMillis(from),
+		Last:    common.UnixMillis(to),
+		Tenants: []*TenantUsage{},
+	}
+
+	flows, metrics, err := r.search(accounting.Start, accounting.Last)
+	if err != nil {
+		return nil, err
+	}
+
+	// tenant of each capture node
+	tenants := make(map[string]string)
+	if r.graph != nil {
+		r.graph.RLock()
+		for _, n := range r.graph.GetNodes(nil) {
+			if tid, _ := n.GetFieldString("TID"); tid != "" {
+				if tenant, ok := tm.tenant(r.graph, n); ok {
+					tenants[tid] = tenant
+				}
+			}
+		}
+		r.graph.RUnlock()
+	}
+
+	usages := make(map[string]*TenantUsage)
+	for _, f := range flows {
+		tenant, ok := tenants[f.NodeTID]
+		if !ok {
+			tenant = unknownGroup
+		}
+
+		usage, ok := usages[tenant]
+		if !ok {
+			usage = &TenantUsage{Tenant: tenant}
+			usages[tenant] = usage
+			accounting.Tenants = append(accounting.Tenants, usage)
+		}
+		usage.Flows++
+
+		for _, m := range metrics[f.UUID] {
+			bytes, packets := metricTraffic(m)
+			usage.Bytes += bytes
+			usage.Packets += packets
+		}
+	}
+
+	sort.Slice(accounting.Tenants, func(i, j int) bool {
+		if accounting.Tenants[i].Bytes == accounting.Tenants[j].Bytes {
+			return accounting.Tenants[i].Tenant < accounting.Tenants[j].Tenant
+		}
+		return accounting.Tenants[i].Bytes > accounting.Tenants[j].Bytes
+	})
+
+	return accounting, nil
+}
+
+// NewTenantMappingFromConfig returns the tenant mapping defined in the configuration
+func NewTenantMappingFromConfig() *TenantMapping {
+	return &TenantMapping{
+		Keys:     config.GetStringSlice("analyzer.accounting.keys"),
+		Mappings: config.GetStringMapString("analyzer.accounting.mappings"),
+	}
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package report
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Window describes a time range in milliseconds
+type Window struct {
+	Start int64
+	Last  int64
+}
+
+// Conversation describes the traffic of the flows sharing the same values
+// for the comparison keys during both windows. Change is the variation in
+// percent of the bytes of the second window compared to the first one.
+type Conversation struct {
+	Key           []string
+	BeforeBytes   int64
+	BeforePackets int64
+	AfterBytes    int64
+	AfterPackets  int64
+	Change        float64
+}
+
+// Comparison describes the conversations which appeared, disappeared or
+// whose traffic changed by more than the threshold, in percent, between two
+// time windows
+type Comparison struct {
+	By          []string
+	Before      Window
+	After       Window
+	Threshold   float64
+	New         []*Conversation
+	Disappeared []*Conversation
+	Changed     []*Conversation
+}
+
+// within returns whether a timestamp is in the window
+func (w Window) within(t int64) bool {
+	return t >= w.Start && t < w.Last
+}
+
+// conversations returns the conversations having traffic during the window,
+// the metrics being accounted by the window they start in
+func (r *Reporter) conversations(w Window, by []string, after bool, conversations map[string]*Conversation) error {
+	flows, metrics, err := r.search(w.Start, w.Last)
+	if err != nil {
+		return err
+	}
+
+	groups := make([]map[string]string, len(by))
+	for i, key := range by {
+		groups[i] = r.groups(flows, key)
+	}
+
+	for _, f := range flows {
+		values := make([]string, len(by))
+		for i := range by {
+			values[i] = groups[i][f.UUID]
+		}
+		id := strings.Join(values, "\x00")
+
+		for _, m := range metrics[f.UUID] {
+			if !w.within(m.GetStart()) {
+				continue
+			}
+
+			c, ok := conversations[id]
+			if !ok {
+				c = &Conversation{Key: values}
+				conversations[id] = c
+			}
+
+			bytes, packets := metricTraffic(m)
+			if after {
+				c.AfterBytes += bytes
+				c.AfterPackets += packets
+			} else {
+				c.BeforeBytes += bytes
+				c.BeforePackets += packets
+			}
+		}
+	}
+
+	return nil
+}
+
+// Compare returns the conversations, grouped by the flow fields or capture
+// node metadata prefixed by "Node.", new in the after window, disappeared
+// from it or whose bytes changed by more than threshold percent
+func (r *Reporter) Compare(before, after Window, by []string, threshold float64) (*Comparison, error) {
+	if before.Start >= before.Last || after.Start >= after.Last {
+		return nil, errors.New("Start of the windows has to be before their end")
+	}
+
+	if len(by) == 0 {
+		return nil, errors.New("At least one comparison key is required")
+	}
+
+	if threshold < 0 {
+		return nil, errors.New("Threshold has to be positive")
+	}
+
+	conversations := make(map[string]*Conversation)
+	if err := r.conversations(before, by, false, conversations); err != nil {
+		return nil, err
+	}
+	if err := r.conversations(after, by, true, conversations); err != nil {
+		return nil, err
+	}
+
+	comparison := &Comparison{
+		By:          by,
+		Before:      before,
+		After:       after,
+		Threshold:   threshold,
+		New:         []*Conversation{},
+		Disappeared: []*Conversation{},
+		Changed:     []*Conversation{},
+	}
+
+	for _, c := range conversations {
+		switch {
+		case c.BeforePackets == 0 && c.BeforeBytes == 0:
+			comparison.New = append(comparison.New, c)
+		case c.AfterPackets == 0 && c.AfterBytes == 0:
+			comparison.Disappeared = append(comparison.Disappeared, c)
+		case c.BeforeBytes != 0:
+			c.Change = float64(c.AfterBytes-c.BeforeBytes) * 100 / float64(c.BeforeBytes)
+			if math.Abs(c.Change) >= threshold {
+				comparison.Changed = append(comparison.Changed, c)
+			}
+		}
+	}
+
+	sortConversations(comparison.New, func(c *Conversation) int64 { return c.AfterBytes })
+	sortConversations(comparison.Disappeared, func(c *Conversation) int64 { return c.BeforeBytes })
+	sortConversations(comparison.Changed, func(c *Conversation) int64 {
+		delta := c.AfterBytes - c.BeforeBytes
+		if delta < 0 {
+			return -delta
+		}
+		return delta
+	})
+
+	return comparison, nil
+}
+
+// sortConversations sorts the conversations by decreasing weight
+func sortConversations(conversations []*Conversation, weight func(c *Conversation) int64) {
+	sort.Slice(conversations, func(i, j int) bool {
+		wi, wj := weight(conversations[i]), weight(conversations[j])
+		if wi == wj {
+			return strings.Join(conversations[i].Key, "\x00") < strings.Join(conversations[j].Key, "\x00")
+		}
+		return wi > wj
+	})
+}
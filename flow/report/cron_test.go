@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package report
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	monday := time.Date(2018, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr  string
+		t     time.Time
+		match bool
+	}{
+		{"0 8 * * 1", monday, true},
+		{"0 8 * * 1", monday.Add(time.Minute), false},
+		{"0 8 * * 1", monday.AddDate(0, 0, 1), false},
+		{"*/15 * * * *", monday.Add(45 * time.Minute), true},
+		{"*/15 * * * *", monday.Add(50 * time.Minute), false},
+		{"0 6-10/2 * * *", monday, true},
+		{"0 6-10/2 * * *", monday.Add(time.Hour), false},
+		{"0 0 * * 7", time.Date(2018, time.October, 7, 0, 0, 0, 0, time.UTC), true},
+		// restricted days of month and week are matched with a OR
+		{"0 8 15 * 1", monday, true},
+		{"0 8 1,15 * 5", monday, true},
+		{"0 8 2 * 5", monday, false},
+		{"@daily", monday.Add(16 * time.Hour), true},
+		{"@monthly", monday.Add(-8 * time.Hour), true},
+	}
+
+	for _, test := range tests {
+		c, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", test.expr, err)
+		}
+		if match := c.Match(test.t); match != test.match {
+			t.Errorf("Expected %s to match %s: %v, got %v", test.expr, test.t, test.match, match)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected an error for %s", expr)
+		}
+	}
+}
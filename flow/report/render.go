@@ -0,0 +1,361 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Formats of the rendered reports
+const (
+	FormatCSV  = "csv"
+	FormatHTML = "html"
+	FormatJSON = "json"
+	FormatPDF  = "pdf"
+)
+
+// ContentTypes of the rendered reports by format
+var ContentTypes = map[string]string{
+	FormatCSV:  "text/csv",
+	FormatHTML: "text/html; charset=utf-8",
+	FormatJSON: "application/json",
+	FormatPDF:  "application/pdf",
+}
+
+// default columns of the results of the Gremlin queries
+var (
+	flowFields = []string{"UUID", "LayersPath", "Application", "Network.A", "Network.B", "Transport.A", "Transport.B", "Metric.ABBytes", "Metric.BABytes", "Metric.Start", "Metric.Last"}
+	nodeFields = []string{"ID", "Host", "Metadata.Type", "Metadata.Name"}
+	edgeFields = []string{"ID", "Parent", "Child", "Metadata.RelationType"}
+)
+
+// valueField is the column of the values of the results that are neither
+// flows nor graph elements
+const valueField = "Value"
+
+// maxCellWidth is the width beyond which the cells are truncated in the
+// PDF reports
+const maxCellWidth = 40
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; font-family: monospace; text-align: left; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}, {{len .Rows}} rows</p>
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Table holds the results of a query as rows of formatted cells
+type Table struct {
+	Title     string
+	Generated time.Time
+	Columns   []string
+	Rows      [][]string
+}
+
+// flattenValues returns the values of a query result, the lists being
+// expanded
+func flattenValues(values []interface{}) (flat []interface{}) {
+	for _, value := range values {
+		v := reflect.ValueOf(value)
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				flat = append(flat, v.Index(i).Interface())
+			}
+			continue
+		}
+		flat = append(flat, value)
+	}
+	return
+}
+
+// defaultFields returns the columns of a type of values
+func defaultFields(value interface{}) []string {
+	switch value.(type) {
+	case *flow.Flow:
+		return flowFields
+	case *graph.Node:
+		return nodeFields
+	case *graph.Edge:
+		return edgeFields
+	}
+	return []string{valueField}
+}
+
+// lookupField returns the value of a dotted path in a JSON document
+func lookupField(doc interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = m[key]
+	}
+	return doc
+}
+
+// formatCell formats a JSON value, the numbers being written without
+// exponent and the objects in JSON
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// NewTable returns a table of the values of a query result, with a column
+// per field. The default fields depend on the type of the first value.
+func NewTable(title string, values []interface{}, fields []string, now time.Time) (*Table, error) {
+	values = flattenValues(values)
+	if len(fields) == 0 {
+		if len(values) > 0 {
+			fields = defaultFields(values[0])
+		} else {
+			fields = []string{valueField}
+		}
+	}
+
+	table := &Table{Title: title, Generated: now, Columns: fields}
+	for _, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		var doc interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+
+		row := make([]string, len(fields))
+		for i, field := range fields {
+			if field == valueField {
+				row[i] = formatCell(doc)
+			} else {
+				row[i] = formatCell(lookupField(doc, field))
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	return table, nil
+}
+
+// WriteCSV writes the table as CSV, with a header line
+func (t *Table) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(t.Columns); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(t.Rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// WriteHTML writes the table as an HTML document
+func (t *Table) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, t)
+}
+
+// textLines returns the table as lines of fixed width columns
+func (t *Table) textLines() []string {
+	widths := make([]int, len(t.Columns))
+	for i, column := range t.Columns {
+		widths[i] = len(column)
+	}
+	for _, row := range t.Rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	format := func(cells []string) string {
+		var line []string
+		for i, cell := range cells {
+			width := widths[i]
+			if width > maxCellWidth {
+				width = maxCellWidth
+			}
+			if len(cell) > width {
+				cell = cell[:width-3] + "..."
+			}
+			line = append(line, fmt.Sprintf("%-*s", width, cell))
+		}
+		return strings.TrimRight(strings.Join(line, "  "), " ")
+	}
+
+	lines := []string{
+		t.Title,
+		fmt.Sprintf("Generated %s, %d rows", t.Generated.Format("2006-01-02 15:04:05 MST"), len(t.Rows)),
+		"",
+		format(t.Columns),
+	}
+	for _, row := range t.Rows {
+		lines = append(lines, format(row))
+	}
+	return lines
+}
+
+// pdfEscape escapes a string of a PDF text object, the characters out of
+// the Courier font being replaced
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// WritePDF writes the table as a PDF document of landscape A4 pages using
+// the Courier standard font, so that no font needs to be embedded
+func (t *Table) WritePDF(w io.Writer) error {
+	const (
+		pageWidth  = 842
+		pageHeight = 595
+		margin     = 36
+		fontSize   = 8
+		leading    = 10
+		// Courier characters are 0.6 of the font size wide
+		maxLine = (pageWidth - 2*margin) * 10 / (fontSize * 6)
+	)
+
+	lines := t.textLines()
+	perPage := (pageHeight - 2*margin) / leading
+
+	var pages [][]string
+	for len(lines) > 0 {
+		n := perPage
+		if n > len(lines) {
+			n = len(lines)
+		}
+		pages = append(pages, lines[:n])
+		lines = lines[n:]
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>")
+
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pageWidth, pageHeight, 5+2*i))
+
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", fontSize, leading, margin, pageHeight-margin)
+		for _, line := range page {
+			if len(line) > maxLine {
+				line = line[:maxLine]
+			}
+			fmt.Fprintf(&content, "(%s) '\n", pdfEscape(line))
+		}
+		content.WriteString("ET")
+
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Write renders the table in the given format
+func (t *Table) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatCSV:
+		return t.WriteCSV(w)
+	case FormatHTML:
+		return t.WriteHTML(w)
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(t)
+	case FormatPDF:
+		return t.WritePDF(w)
+	}
+	return fmt.Errorf("Unsupported report format: %s", format)
+}
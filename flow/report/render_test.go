@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+func TestTable(t *testing.T) {
+	flows := []*flow.Flow{
+		{
+			UUID:    "flow1",
+			Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "10.0.0.1", B: "10.0.0.2"},
+			Metric:  &flow.FlowMetric{ABBytes: 1500, BABytes: 300},
+		},
+		{
+			UUID:    "flow2",
+			Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "10.0.0.1", B: "10.0.0.3"},
+			Metric:  &flow.FlowMetric{ABBytes: 100},
+		},
+	}
+
+	now := time.Date(2018, time.October, 1, 8, 0, 0, 0, time.UTC)
+	table, err := NewTable("talkers", []interface{}{flows}, []string{"UUID", "Network.B", "Metric.ABBytes"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.Rows) != 2 || strings.Join(table.Rows[0], ",") != "flow1,10.0.0.2,1500" {
+		t.Fatalf("Unexpected rows: %v", table.Rows)
+	}
+
+	var csv bytes.Buffer
+	if err := table.Write(&csv, FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "UUID,Network.B,Metric.ABBytes\nflow1,10.0.0.2,1500\nflow2,10.0.0.3,100\n"; csv.String() != expected {
+		t.Errorf("Expected CSV %q, got %q", expected, csv.String())
+	}
+
+	var html bytes.Buffer
+	if err := table.Write(&html, FormatHTML); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), "<td>10.0.0.3</td>") {
+		t.Errorf("Row missing in the HTML report: %s", html.String())
+	}
+
+	var pdf bytes.Buffer
+	if err := table.Write(&pdf, FormatPDF); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(pdf.Bytes(), []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf.Bytes(), []byte("%%EOF\n")) || !bytes.Contains(pdf.Bytes(), []byte("flow2")) {
+		t.Errorf("Invalid PDF report: %s", pdf.String())
+	}
+
+	q := &QuerySchedule{}
+	q.filterNew(table)
+	table.Rows = append(table.Rows, []string{"flow3", "10.0.0.4", "10"})
+	q.filterNew(table)
+	if len(table.Rows) != 1 || table.Rows[0][0] != "flow3" {
+		t.Errorf("Expected only the new row, got %v", table.Rows)
+	}
+}
@@ -0,0 +1,335 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package report
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Periods of the trend reports
+const (
+	Day   = "day"
+	Week  = "week"
+	Month = "month"
+)
+
+// nodePrefix selects the metadata of the capture node as group key
+const nodePrefix = "Node."
+
+const unknownGroup = "unknown"
+
+// ErrUnknownPeriod the period is not supported
+var ErrUnknownPeriod = errors.New("Period has to be day, week or month")
+
+// Bucket holds the traffic of a group during one period
+type Bucket struct {
+	Start   int64
+	Last    int64
+	Bytes   int64
+	Packets int64
+}
+
+// Trend describes the traffic of a group over the periods of a report.
+// Change is the variation in percent of the last period compared to the
+// previous one, Forecast the bytes expected for the next period.
+type Trend struct {
+	Group    string
+	Bytes    int64
+	Packets  int64
+	Change   float64
+	Forecast int64
+	Buckets  []*Bucket
+}
+
+// Report describes the traffic trends of the flows grouped by a key
+type Report struct {
+	Period  string
+	GroupBy string
+	Start   int64
+	Last    int64
+	Trends  []*Trend
+}
+
+// Reporter computes trend reports from the stored flows
+type Reporter struct {
+	graph   *graph.Graph
+	storage storage.Storage
+}
+
+// periodStart returns the start of the period ending at t
+func periodStart(period string, t time.Time) (time.Time, error) {
+	switch period {
+	case Day:
+		return t.AddDate(0, 0, -1), nil
+	case Week:
+		return t.AddDate(0, 0, -7), nil
+	case Month:
+		return t.AddDate(0, -1, 0), nil
+	}
+	return t, ErrUnknownPeriod
+}
+
+// forecast extrapolates the next value using a least squares linear regression
+func forecast(values []int64) int64 {
+	n := float64(len(values))
+	if n == 0 {
+		return 0
+	}
+	if n == 1 {
+		return values[0]
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for i, v := range values {
+		x, y := float64(i), float64(v)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	intercept := (sumY - slope*sumX) / n
+
+	if next := intercept + slope*n; next > 0 {
+		return int64(next)
+	}
+	return 0
+}
+
+// metricTraffic returns the bytes and packets of both directions of a flow metric
+func metricTraffic(m common.Metric) (bytes int64, packets int64) {
+	for _, field := range []string{"ABBytes", "BABytes"} {
+		v, _ := m.GetFieldInt64(field)
+		bytes += v
+	}
+	for _, field := range []string{"ABPackets", "BAPackets"} {
+		v, _ := m.GetFieldInt64(field)
+		packets += v
+	}
+	return
+}
+
+// search returns the flows active and their metrics between from and to
+func (r *Reporter) search(from, to int64) ([]*flow.Flow, map[string][]common.Metric, error) {
+	fr := filters.Range{From: from, To: to}
+	fsq := filters.SearchQuery{Filter: filters.NewFilterActiveIn(fr, "")}
+
+	flowset, err := r.storage.SearchFlows(fsq)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metrics, err := r.storage.SearchMetrics(fsq, filters.NewFilterIncludedIn(fr, ""))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return flowset.Flows, metrics, nil
+}
+
+// groups returns the group of each flow
+func (r *Reporter) groups(flows []*flow.Flow, groupBy string) map[string]string {
+	groups := make(map[string]string, len(flows))
+
+	if strings.HasPrefix(groupBy, nodePrefix) {
+		key := strings.TrimPrefix(groupBy, nodePrefix)
+
+		nodes := make(map[string]string)
+		if r.graph != nil {
+			r.graph.RLock()
+			for _, n := range r.graph.GetNodes(nil) {
+				if tid, _ := n.GetFieldString("TID"); tid != "" {
+					if v, err := n.GetField(key); err == nil {
+						nodes[tid] = fmt.Sprintf("%v", v)
+					}
+				}
+			}
+			r.graph.RUnlock()
+		}
+
+		for _, f := range flows {
+			if group, ok := nodes[f.NodeTID]; ok {
+				groups[f.UUID] = group
+			} else {
+				groups[f.UUID] = unknownGroup
+			}
+		}
+		return groups
+	}
+
+	for _, f := range flows {
+		if v, err := f.GetField(groupBy); err == nil && fmt.Sprintf("%v", v) != "" {
+			groups[f.UUID] = fmt.Sprintf("%v", v)
+		} else {
+			groups[f.UUID] = unknownGroup
+		}
+	}
+	return groups
+}
+
+// Trends computes the traffic of the flows grouped by the given key, either a
+// flow field or a metadata of the capture node prefixed by "Node.", over the
+// count periods preceding now
+func (r *Reporter) Trends(period string, groupBy string, count int, now time.Time) (*Report, error) {
+	if count <= 0 {
+		return nil, errors.New("Number of periods has to be positive")
+	}
+
+	bounds := []time.Time{now}
+	for i := 0; i < count; i++ {
+		start, err := periodStart(period, bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		bounds = append([]time.Time{start}, bounds...)
+	}
+
+	report := &Report{
+		Period:  period,
+		GroupBy: groupBy,
+		Start:   common.UnixMillis(bounds[0]),
+		Last:    common.UnixMillis(now),
+		Trends:  []*Trend{},
+	}
+
+	flows, metrics, err := r.search(report.Start, report.Last)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := r.groups(flows, groupBy)
+	trends := make(map[string]*Trend)
+
+	for uuid, ms := range metrics {
+		group, ok := groups[uuid]
+		if !ok {
+			continue
+		}
+
+		trend, ok := trends[group]
+		if !ok {
+			trend = &Trend{Group: group, Buckets: make([]*Bucket, count)}
+			for i := range trend.Buckets {
+				trend.Buckets[i] = &Bucket{Start: common.UnixMillis(bounds[i]), Last: common.UnixMillis(bounds[i+1])}
+			}
+			trends[group] = trend
+			report.Trends = append(report.Trends, trend)
+		}
+
+		for _, m := range ms {
+			i := sort.Search(count, func(i int) bool { return trend.Buckets[i].Last > m.GetStart() })
+			if i == count {
+				continue
+			}
+
+			bytes, packets := metricTraffic(m)
+			trend.Buckets[i].Bytes += bytes
+			trend.Buckets[i].Packets += packets
+			trend.Bytes += bytes
+			trend.Packets += packets
+		}
+	}
+
+	for _, trend := range report.Trends {
+		values := make([]int64, count)
+		for i, b := range trend.Buckets {
+			values[i] = b.Bytes
+		}
+		trend.Forecast = forecast(values)
+
+		if count > 1 && values[count-2] != 0 {
+			trend.Change = float64(values[count-1]-values[count-2]) * 100 / float64(values[count-2])
+		}
+	}
+
+	sort.Slice(report.Trends, func(i, j int) bool {
+		if report.Trends[i].Bytes == report.Trends[j].Bytes {
+			return report.Trends[i].Group < report.Trends[j].Group
+		}
+		return report.Trends[i].Bytes > report.Trends[j].Bytes
+	})
+
+	return report, nil
+}
+
+// WriteCSV writes one line per group and period, the forecast of the next
+// period being flagged in the last column
+func (rp *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{rp.GroupBy, "Start", "Last", "Bytes", "Packets", "Forecast"}); err != nil {
+		return err
+	}
+
+	for _, trend := range rp.Trends {
+		for _, b := range trend.Buckets {
+			record := []string{
+				trend.Group,
+				strconv.FormatInt(b.Start, 10),
+				strconv.FormatInt(b.Last, 10),
+				strconv.FormatInt(b.Bytes, 10),
+				strconv.FormatInt(b.Packets, 10),
+				"false",
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+
+		if len(trend.Buckets) > 0 {
+			last := trend.Buckets[len(trend.Buckets)-1]
+			record := []string{
+				trend.Group,
+				strconv.FormatInt(last.Last, 10),
+				strconv.FormatInt(last.Last+last.Last-last.Start, 10),
+				strconv.FormatInt(trend.Forecast, 10),
+				"",
+				"true",
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// NewReporter returns a new trend reporter
+func NewReporter(g *graph.Graph, s storage.Storage) *Reporter {
+	return &Reporter{graph: g, storage: s}
+}
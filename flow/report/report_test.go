@@ -0,0 +1,227 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+type fakeStorage struct {
+	flows   []*flow.Flow
+	metrics map[string][]common.Metric
+}
+
+func (s *fakeStorage) Start() {}
+func (s *fakeStorage) Stop()  {}
+
+func (s *fakeStorage) Ping() error { return nil }
+
+func (s *fakeStorage) StoreFlows(flows []*flow.Flow) error { return nil }
+
+func (s *fakeStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+	return &flow.FlowSet{Flows: s.flows}, nil
+}
+
+func (s *fakeStorage) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
+	return s.metrics, nil
+}
+
+func (s *fakeStorage) SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error) {
+	return nil, nil
+}
+
+func TestForecast(t *testing.T) {
+	if f := forecast([]int64{100, 200, 300}); f != 400 {
+		t.Errorf("Expected a forecast of 400, got: %d", f)
+	}
+
+	if f := forecast([]int64{300, 200, 100}); f != 0 {
+		t.Errorf("Expected a forecast of 0, got: %d", f)
+	}
+}
+
+func TestTrends(t *testing.T) {
+	now := time.Date(2018, 6, 30, 0, 0, 0, 0, time.UTC)
+	day := func(d int) int64 {
+		return common.UnixMillis(now.AddDate(0, 0, -d))
+	}
+
+	s := &fakeStorage{
+		flows: []*flow.Flow{
+			{UUID: "f1", Application: "TCP"},
+			{UUID: "f2", Application: "TCP"},
+			{UUID: "f3", Application: "UDP"},
+		},
+		metrics: map[string][]common.Metric{
+			"f1": {
+				&flow.FlowMetric{ABBytes: 100, BABytes: 100, ABPackets: 2, Start: day(10), Last: day(10)},
+				&flow.FlowMetric{ABBytes: 300, BABytes: 100, ABPackets: 4, Start: day(3), Last: day(3)},
+			},
+			"f2": {
+				&flow.FlowMetric{ABBytes: 200, Start: day(2), Last: day(2)},
+			},
+			"f3": {
+				&flow.FlowMetric{ABBytes: 50, Start: day(1), Last: day(1)},
+			},
+		},
+	}
+
+	report, err := NewReporter(nil, s).Trends(Week, "Application", 2, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Trends) != 2 || report.Trends[0].Group != "TCP" || report.Trends[1].Group != "UDP" {
+		t.Fatalf("Expected TCP and UDP trends, got: %+v", report.Trends)
+	}
+
+	tcp := report.Trends[0]
+	if tcp.Buckets[0].Bytes != 200 || tcp.Buckets[1].Bytes != 600 || tcp.Bytes != 800 || tcp.Packets != 6 {
+		t.Errorf("Wrong TCP buckets: %+v %+v", tcp.Buckets[0], tcp.Buckets[1])
+	}
+
+	if tcp.Change != 200 || tcp.Forecast != 1000 {
+		t.Errorf("Wrong TCP change or forecast: %f %d", tcp.Change, tcp.Forecast)
+	}
+
+	var b bytes.Buffer
+	if err := report.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	// header, 2 periods and the forecast for each group
+	if lines := strings.Split(strings.TrimSpace(b.String()), "\n"); len(lines) != 7 {
+		t.Errorf("Expected 7 CSV lines, got: %s", b.String())
+	}
+}
+
+func TestUsage(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+
+	pod := g.NewNode(graph.GenID(), graph.Metadata{"Type": "pod", "K8s": map[string]interface{}{"Namespace": "team-a"}})
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "veth", "TID": "tid1"})
+	g.NewNode(graph.GenID(), graph.Metadata{"Type": "tap", "TID": "tid2", "Neutron": map[string]interface{}{"TenantID": "1234"}})
+	g.Link(pod, eth0, graph.Metadata{"RelationType": "ownership"})
+
+	now := time.Now()
+	s := &fakeStorage{
+		flows: []*flow.Flow{
+			{UUID: "f1", NodeTID: "tid1"},
+			{UUID: "f2", NodeTID: "tid2"},
+			{UUID: "f3", NodeTID: "tid3"},
+		},
+		metrics: map[string][]common.Metric{
+			"f1": {&flow.FlowMetric{ABBytes: 100, BABytes: 50, ABPackets: 3}},
+			"f2": {&flow.FlowMetric{ABBytes: 10, BAPackets: 1}},
+		},
+	}
+
+	tm := &TenantMapping{
+		Keys:     []string{"K8s.Namespace", "Neutron.TenantID"},
+		Mappings: map[string]string{"1234": "team-b"},
+	}
+
+	accounting, err := NewReporter(g, s).Usage(tm, now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(accounting.Tenants) != 3 {
+		t.Fatalf("Expected 3 tenants, got: %+v", accounting.Tenants)
+	}
+
+	expected := []TenantUsage{
+		{Tenant: "team-a", Bytes: 150, Packets: 3, Flows: 1},
+		{Tenant: "team-b", Bytes: 10, Packets: 1, Flows: 1},
+		{Tenant: "unknown", Flows: 1},
+	}
+	for i, usage := range accounting.Tenants {
+		if *usage != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], *usage)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	s := &fakeStorage{
+		flows: []*flow.Flow{
+			{UUID: "f1", Network: &flow.FlowLayer{A: "10.0.0.1", B: "10.0.0.2"}},
+			{UUID: "f2", Network: &flow.FlowLayer{A: "10.0.0.1", B: "10.0.0.3"}},
+			{UUID: "f3", Network: &flow.FlowLayer{A: "10.0.0.4", B: "10.0.0.2"}},
+			{UUID: "f4", Network: &flow.FlowLayer{A: "10.0.0.5", B: "10.0.0.2"}},
+		},
+		metrics: map[string][]common.Metric{
+			// doubled
+			"f1": {
+				&flow.FlowMetric{ABBytes: 100, ABPackets: 1, Start: 1000, Last: 1500},
+				&flow.FlowMetric{ABBytes: 200, ABPackets: 2, Start: 3000, Last: 3500},
+			},
+			// disappeared
+			"f2": {
+				&flow.FlowMetric{ABBytes: 50, ABPackets: 1, Start: 1200, Last: 1300},
+			},
+			// new
+			"f3": {
+				&flow.FlowMetric{ABBytes: 70, ABPackets: 1, Start: 3200, Last: 3300},
+			},
+			// unchanged
+			"f4": {
+				&flow.FlowMetric{ABBytes: 100, ABPackets: 1, Start: 1000, Last: 1500},
+				&flow.FlowMetric{ABBytes: 110, ABPackets: 1, Start: 3000, Last: 3500},
+			},
+		},
+	}
+
+	comparison, err := NewReporter(nil, s).Compare(Window{Start: 1000, Last: 2000}, Window{Start: 3000, Last: 4000}, []string{"Network.A", "Network.B"}, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(comparison.New) != 1 || strings.Join(comparison.New[0].Key, " ") != "10.0.0.4 10.0.0.2" || comparison.New[0].AfterBytes != 70 {
+		t.Errorf("Expected the conversation of f3 to be new, got: %+v", comparison.New)
+	}
+
+	if len(comparison.Disappeared) != 1 || strings.Join(comparison.Disappeared[0].Key, " ") != "10.0.0.1 10.0.0.3" {
+		t.Errorf("Expected the conversation of f2 to be gone, got: %+v", comparison.Disappeared)
+	}
+
+	if len(comparison.Changed) != 1 || comparison.Changed[0].Change != 100 || comparison.Changed[0].BeforeBytes != 100 {
+		t.Errorf("Expected the conversation of f1 to have doubled, got: %+v", comparison.Changed)
+	}
+
+	if _, err := NewReporter(nil, s).Compare(Window{Start: 2000, Last: 1000}, Window{Start: 3000, Last: 4000}, []string{"Network.A"}, 50); err == nil {
+		t.Error("Expected an error for an empty window")
+	}
+}
@@ -0,0 +1,324 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// QuerySchedule describes a saved Gremlin query run on a cron schedule,
+// its results being rendered and delivered by email or to a webhook. With
+// OnlyNew, the rows of the previous run are left out, to report the new
+// destinations for instance. Limit is the maximum number of rows.
+type QuerySchedule struct {
+	Name    string   `mapstructure:"name"`
+	Cron    string   `mapstructure:"cron"`
+	Query   string   `mapstructure:"query"`
+	Fields  []string `mapstructure:"fields"`
+	Format  string   `mapstructure:"format"`
+	OnlyNew bool     `mapstructure:"only_new"`
+	Limit   int      `mapstructure:"limit"`
+	Email   []string `mapstructure:"email"`
+	Webhook string   `mapstructure:"webhook"`
+
+	cron *cronSchedule
+	seen map[string]bool
+}
+
+// smtpConfig describes the server the reports are emailed through
+type smtpConfig struct {
+	address  string
+	from     string
+	username string
+	password string
+}
+
+// QueryScheduler runs the scheduled queries of the configuration. Only the
+// master analyzer runs them, so that the reports are delivered once.
+type QueryScheduler struct {
+	*etcd.MasterElector
+	graph     *graph.Graph
+	parser    *traversal.GremlinTraversalParser
+	schedules []*QuerySchedule
+	smtp      smtpConfig
+	client    *http.Client
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// filename returns the name of the report file of a run
+func (q *QuerySchedule) filename(now time.Time) string {
+	return fmt.Sprintf("%s-%s.%s", q.Name, now.Format("20060102-1504"), q.Format)
+}
+
+// filterNew keeps the rows absent from the previous run
+func (q *QuerySchedule) filterNew(table *Table) {
+	seen := make(map[string]bool)
+	var rows [][]string
+	for _, row := range table.Rows {
+		key := strings.Join(row, "\x00")
+		seen[key] = true
+		if !q.seen[key] {
+			rows = append(rows, row)
+		}
+	}
+	q.seen = seen
+	table.Rows = rows
+}
+
+func (s *QueryScheduler) render(q *QuerySchedule, now time.Time) (*Table, []byte, error) {
+	ts, err := s.parser.Parse(strings.NewReader(q.Query))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := ts.Exec(s.graph, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	table, err := NewTable(q.Name, res.Values(), q.Fields, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if q.OnlyNew {
+		q.filterNew(table)
+	}
+
+	if q.Limit > 0 && len(table.Rows) > q.Limit {
+		table.Rows = table.Rows[:q.Limit]
+	}
+
+	var buf bytes.Buffer
+	if err := table.Write(&buf, q.Format); err != nil {
+		return nil, nil, err
+	}
+	return table, buf.Bytes(), nil
+}
+
+// email sends the report as the attachment of a mail
+func (s *QueryScheduler) email(q *QuerySchedule, table *Table, report []byte, now time.Time) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	header := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Skydive report %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		s.smtp.from, strings.Join(q.Email, ", "), q.Name, now.Format(time.RFC1123Z), writer.Boundary())
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(part, "%s: %d rows, generated %s\r\n", q.Name, len(table.Rows), now.Format("2006-01-02 15:04:05 MST"))
+
+	part, err = writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {ContentTypes[q.Format]},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf(`attachment; filename="%s"`, q.filename(now))},
+	})
+	if err != nil {
+		return err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(report)
+	for len(encoded) > 76 {
+		fmt.Fprintf(part, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(part, "%s\r\n", encoded)
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.smtp.username != "" {
+		host, _, err := net.SplitHostPort(s.smtp.address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.smtp.username, s.smtp.password, host)
+	}
+
+	return smtp.SendMail(s.smtp.address, auth, s.smtp.from, q.Email, append([]byte(header), body.Bytes()...))
+}
+
+// post sends the report to the webhook of the schedule
+func (s *QueryScheduler) post(q *QuerySchedule, report []byte, now time.Time) error {
+	req, err := http.NewRequest("POST", q.Webhook, bytes.NewReader(report))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentTypes[q.Format])
+	req.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, q.filename(now)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("Webhook %s returned %s", q.Webhook, resp.Status)
+	}
+	return nil
+}
+
+func (s *QueryScheduler) runSchedule(q *QuerySchedule, now time.Time) {
+	table, report, err := s.render(q, now)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to generate the scheduled report %s: %s", q.Name, err.Error())
+		return
+	}
+
+	if len(q.Email) > 0 {
+		if err := s.email(q, table, report, now); err != nil {
+			logging.GetLogger().Errorf("Failed to email the scheduled report %s: %s", q.Name, err.Error())
+		}
+	}
+
+	if q.Webhook != "" {
+		if err := s.post(q, report, now); err != nil {
+			logging.GetLogger().Errorf("Failed to post the scheduled report %s: %s", q.Name, err.Error())
+		}
+	}
+
+	logging.GetLogger().Debugf("Scheduled report %s delivered, %d rows", q.Name, len(table.Rows))
+}
+
+func (s *QueryScheduler) run() {
+	defer s.wg.Done()
+
+	for {
+		// wake up at the start of each minute
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+		select {
+		case t := <-timer.C:
+			if !s.IsMaster() {
+				continue
+			}
+
+			t = t.Truncate(time.Minute)
+			for _, q := range s.schedules {
+				if q.cron.Match(t) {
+					s.runSchedule(q, t)
+				}
+			}
+		case <-s.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Start the query scheduler
+func (s *QueryScheduler) Start() {
+	s.StartAndWait()
+
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop the query scheduler
+func (s *QueryScheduler) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+	s.MasterElector.Stop()
+}
+
+// NewQuerySchedulerFromConfig returns a new scheduler of the queries of the
+// configuration, nil if none is scheduled
+func NewQuerySchedulerFromConfig(g *graph.Graph, parser *traversal.GremlinTraversalParser, etcdClient *etcd.Client) (*QueryScheduler, error) {
+	var schedules []*QuerySchedule
+	if err := config.GetConfig().UnmarshalKey("analyzer.report.schedules", &schedules); err != nil {
+		return nil, fmt.Errorf("Invalid analyzer.report.schedules: %s", err.Error())
+	}
+
+	if len(schedules) == 0 {
+		return nil, nil
+	}
+
+	names := make(map[string]bool)
+	for i, q := range schedules {
+		if q.Name == "" || q.Query == "" {
+			return nil, fmt.Errorf("Scheduled report %d: name and query are mandatory", i)
+		}
+		if names[q.Name] {
+			return nil, fmt.Errorf("Scheduled report %s defined twice", q.Name)
+		}
+		names[q.Name] = true
+
+		if q.Format == "" {
+			q.Format = FormatHTML
+		}
+		if _, ok := ContentTypes[q.Format]; !ok {
+			return nil, fmt.Errorf("Scheduled report %s: unsupported format %s", q.Name, q.Format)
+		}
+
+		if len(q.Email) == 0 && q.Webhook == "" {
+			return nil, fmt.Errorf("Scheduled report %s: an email or a webhook is required", q.Name)
+		}
+
+		if _, err := parser.Parse(strings.NewReader(q.Query)); err != nil {
+			return nil, fmt.Errorf("Scheduled report %s: %s", q.Name, err.Error())
+		}
+
+		var err error
+		if q.cron, err = parseCron(q.Cron); err != nil {
+			return nil, fmt.Errorf("Scheduled report %s: %s", q.Name, err.Error())
+		}
+	}
+
+	return &QueryScheduler{
+		MasterElector: etcd.NewMasterElectorFromConfig(common.AnalyzerService, "report-scheduler", etcdClient),
+		graph:         g,
+		parser:        parser,
+		schedules:     schedules,
+		smtp: smtpConfig{
+			address:  config.GetString("analyzer.report.smtp.address"),
+			from:     config.GetString("analyzer.report.smtp.from"),
+			username: config.GetString("analyzer.report.smtp.username"),
+			password: config.GetString("analyzer.report.smtp.password"),
+		},
+		client: &http.Client{Timeout: time.Duration(config.GetInt("analyzer.report.webhook_timeout")) * time.Second},
+		quit:   make(chan struct{}),
+	}, nil
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Scheduler periodically writes the trend reports to a directory
+type Scheduler struct {
+	reporter  *Reporter
+	interval  time.Duration
+	directory string
+	period    string
+	count     int
+	groupBy   []string
+	format    string
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func (s *Scheduler) write(groupBy string, now time.Time) error {
+	rp, err := s.reporter.Trends(s.period, groupBy, s.count, now)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("trends-%s-%s-%s.%s", strings.ToLower(groupBy), s.period, now.Format("20060102"), s.format)
+	file, err := os.Create(filepath.Join(s.directory, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if s.format == "csv" {
+		return rp.WriteCSV(file)
+	}
+	return json.NewEncoder(file).Encode(rp)
+}
+
+func (s *Scheduler) generate() {
+	now := time.Now().UTC()
+	for _, groupBy := range s.groupBy {
+		if err := s.write(groupBy, now); err != nil {
+			logging.GetLogger().Errorf("Failed to generate the %s trend report by %s: %s", s.period, groupBy, err.Error())
+		}
+	}
+}
+
+func (s *Scheduler) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.generate()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Start the report scheduler
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop the report scheduler
+func (s *Scheduler) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+// NewSchedulerFromConfig returns a new report scheduler, nil if disabled or
+// if no flow storage is configured
+func NewSchedulerFromConfig(g *graph.Graph, store storage.Storage) (*Scheduler, error) {
+	if store == nil || !config.GetBool("analyzer.report.enabled") {
+		return nil, nil
+	}
+
+	format := config.GetString("analyzer.report.format")
+	if format != "csv" && format != "json" {
+		return nil, fmt.Errorf("Unsupported report format: %s", format)
+	}
+
+	period := config.GetString("analyzer.report.period")
+	if _, err := periodStart(period, time.Now()); err != nil {
+		return nil, err
+	}
+
+	directory := config.GetString("analyzer.report.directory")
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create report directory %s: %s", directory, err.Error())
+	}
+
+	return &Scheduler{
+		reporter:  NewReporter(g, store),
+		interval:  time.Duration(config.GetInt("analyzer.report.interval")) * time.Second,
+		directory: directory,
+		period:    period,
+		count:     config.GetInt("analyzer.report.count"),
+		groupBy:   config.GetStringSlice("analyzer.report.group_by"),
+		format:    format,
+		quit:      make(chan struct{}),
+	}, nil
+}
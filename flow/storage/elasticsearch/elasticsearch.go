@@ -30,13 +30,20 @@ import (
 	"github.com/google/gopacket/layers"
 	elastic "github.com/olivere/elastic"
 
+	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/logging"
 	esclient "github.com/skydive-project/skydive/storage/elasticsearch"
 )
 
+// flowMappingsVersion is the version of the flow mappings, to be increased
+// with any incompatible change, the indices of the previous versions being
+// then reindexed
+const flowMappingsVersion = 11
+
 const flowMapping = `
 {
 	"dynamic_templates": [
@@ -158,9 +165,38 @@ const rawPacketMapping = `
 	]
 }`
 
+const alertEventMapping = `
+{
+	"dynamic_templates": [
+		{
+			"strings": {
+				"match": "*",
+				"match_mapping_type": "string",
+				"mapping": {
+					"type": "string", "index": "not_analyzed", "doc_values": false
+				}
+			}
+		},
+		{
+			"timestamp": {
+				"match": "Timestamp",
+				"mapping": {
+					"type": "date", "format": "epoch_millis"
+				}
+			}
+		}
+	],
+	"properties": {
+		"ReasonData": {
+			"type": "string", "index": "no"
+		}
+	}
+}`
+
 // ElasticSearchStorage describes an ElasticSearch flow backend
 type ElasticSearchStorage struct {
 	client *esclient.ElasticSearchClient
+	cipher *common.DataCipher
 }
 
 func (c *ElasticSearchStorage) rollIndex(shouldRoll bool, err error) error {
@@ -200,11 +236,17 @@ func (c *ElasticSearchStorage) StoreFlows(flows []*flow.Flow) error {
 			continue
 		}
 		for _, r := range f.LastRawPackets {
+			data, err := c.cipher.Encrypt(r.Data)
+			if err != nil {
+				logging.GetLogger().Errorf("Error while encrypting raw packet: %s", err.Error())
+				continue
+			}
+
 			rawpacket := map[string]interface{}{
 				"LinkType":  linkType,
 				"Timestamp": r.Timestamp,
 				"Index":     r.Index,
-				"Data":      r.Data,
+				"Data":      data,
 			}
 			if c.rollIndex(c.client.BulkIndexChild("rawpacket", f.UUID, "", rawpacket)) != nil {
 				logging.GetLogger().Errorf(err.Error())
@@ -253,16 +295,22 @@ func (c *ElasticSearchStorage) SearchRawPackets(fsq filters.SearchQuery, packetF
 				return nil, err
 			}
 
+			data, err := c.cipher.Decrypt(obj.Data)
+			if err != nil {
+				return nil, err
+			}
+
 			r := &flow.RawPacket{
 				Timestamp: obj.Timestamp,
 				Index:     obj.Index,
-				Data:      obj.Data,
+				Data:      data,
 			}
 
-			if fr, ok := rawpackets[d.Parent]; ok {
+			parent := c.client.HitParent(d)
+			if fr, ok := rawpackets[parent]; ok {
 				fr.RawPackets = append(fr.RawPackets, r)
 			} else {
-				rawpackets[d.Parent] = &flow.RawPackets{
+				rawpackets[parent] = &flow.RawPackets{
 					LinkType:   obj.LinkType,
 					RawPackets: []*flow.RawPacket{r},
 				}
@@ -300,7 +348,8 @@ func (c *ElasticSearchStorage) SearchMetrics(fsq filters.SearchQuery, metricFilt
 				return nil, err
 			}
 
-			metrics[d.Parent] = append(metrics[d.Parent], m)
+			parent := c.client.HitParent(d)
+			metrics[parent] = append(metrics[parent], m)
 		}
 	}
 
@@ -338,6 +387,83 @@ func (c *ElasticSearchStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowS
 	return flowset, nil
 }
 
+// AggregateFlows returns the aggregations of the flows matching filters in the database
+func (c *ElasticSearchStorage) AggregateFlows(fsq filters.SearchQuery) (map[string]*filters.AggregationResult, error) {
+	if !c.client.Started() {
+		return nil, errors.New("ElasticSearchStorage is not yet started")
+	}
+
+	return c.client.Aggregate("flow", c.client.FormatFilter(fsq.Filter, ""), "", fsq.Aggregations)
+}
+
+// DeleteExpiredFlows deletes the flows retained until before now, in ms,
+// along with their metrics and raw packets
+func (c *ElasticSearchStorage) DeleteExpiredFlows(now int64) error {
+	if !c.client.Started() {
+		return errors.New("ElasticSearchStorage is not yet started")
+	}
+
+	expired := c.client.FormatFilter(filters.NewAndFilter(
+		filters.NewGtInt64Filter("RetainUntil", 0),
+		filters.NewLtInt64Filter("RetainUntil", now),
+	), "")
+
+	// the children first so that they are still reachable by their parent
+	for _, obj := range []string{"metric", "rawpacket", "flow"} {
+		query := expired
+		if obj != "flow" {
+			query = elastic.NewHasParentQuery("flow", expired)
+		}
+
+		deleted, err := c.client.DeleteByQuery(obj, query)
+		if err != nil {
+			return fmt.Errorf("Error while deleting the expired %s documents: %s", obj, err.Error())
+		}
+		logging.GetLogger().Debugf("%d expired %s documents deleted", deleted, obj)
+	}
+	return nil
+}
+
+// StoreAlertEvent pushes an alert event in the database
+func (c *ElasticSearchStorage) StoreAlertEvent(event *types.AlertEvent) error {
+	if !c.client.Started() {
+		return errors.New("ElasticSearchStorage is not yet started")
+	}
+
+	return c.rollIndex(c.client.BulkIndex("alertevent", event.UUID, event))
+}
+
+// SearchAlertEvents searches the alert events matching filters in the database
+func (c *ElasticSearchStorage) SearchAlertEvents(fsq filters.SearchQuery) ([]*types.AlertEvent, error) {
+	if !c.client.Started() {
+		return nil, errors.New("ElasticSearchStorage is not yet started")
+	}
+
+	out, err := c.sendRequest("alertevent", c.client.FormatFilter(fsq.Filter, ""), fsq)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*types.AlertEvent
+	for _, d := range out.Hits.Hits {
+		event := new(types.AlertEvent)
+		if err := json.Unmarshal([]byte(*d.Source), event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// Ping checks that the client is connected to Elasticsearch
+func (c *ElasticSearchStorage) Ping() error {
+	if !c.client.Started() {
+		return errors.New("Not connected to Elasticsearch")
+	}
+	return nil
+}
+
 // Start the Database client
 func (c *ElasticSearchStorage) Start() {
 	go c.client.Start()
@@ -351,15 +477,22 @@ func (c *ElasticSearchStorage) Stop() {
 // New creates a new ElasticSearch database client
 func New(backend string) (*ElasticSearchStorage, error) {
 	cfg := esclient.NewConfig(backend)
+	cfg.MappingsVersion = flowMappingsVersion
 	mappings := esclient.Mappings{
 		{"metric": []byte(metricMapping)},
 		{"rawpacket": []byte(rawPacketMapping)},
 		{"flow": []byte(flowMapping)},
+		{"alertevent": []byte(alertEventMapping)},
 	}
+	cipher, err := config.GetDataCipher("storage." + backend + ".encryption")
+	if err != nil {
+		return nil, err
+	}
+
 	client, err := esclient.NewElasticSearchClient("flows", mappings, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ElasticSearchStorage{client: client}, nil
+	return &ElasticSearchStorage{client: client, cipher: cipher}, nil
 }
@@ -30,6 +30,7 @@ import (
 
 	"github.com/google/gopacket/layers"
 	"github.com/mitchellh/mapstructure"
+	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/filters"
@@ -41,17 +42,23 @@ import (
 // OrientDBStorage describes a OrientDB database client
 type OrientDBStorage struct {
 	client *orient.Client
+	cipher *common.DataCipher
 }
 
-func flowRawPacketToDocument(linkType layers.LinkType, rawpacket *flow.RawPacket) orient.Document {
+func flowRawPacketToDocument(linkType layers.LinkType, rawpacket *flow.RawPacket, cipher *common.DataCipher) (orient.Document, error) {
+	data, err := cipher.Encrypt(rawpacket.Data)
+	if err != nil {
+		return nil, err
+	}
+
 	return orient.Document{
 		"@class":    "FlowRawPacket",
 		"@type":     "d",
 		"LinkType":  linkType,
 		"Timestamp": rawpacket.Timestamp,
 		"Index":     rawpacket.Index,
-		"Data":      rawpacket.Data,
-	}
+		"Data":      data,
+	}, nil
 }
 
 func flowMetricToDocument(flow *flow.Flow, metric *flow.FlowMetric) orient.Document {
@@ -81,6 +88,14 @@ func flowTCPMetricToDocument(flow *flow.Flow, tcp_metric *flow.TCPMetric) orient
 		"BASynStart":            tcp_metric.BASynStart,
 		"ABSynTTL":              tcp_metric.ABSynTTL,
 		"BASynTTL":              tcp_metric.BASynTTL,
+		"ABSynWindow":           tcp_metric.ABSynWindow,
+		"BASynWindow":           tcp_metric.BASynWindow,
+		"ABSynMSS":              tcp_metric.ABSynMSS,
+		"BASynMSS":              tcp_metric.BASynMSS,
+		"ABSynWScale":           tcp_metric.ABSynWScale,
+		"BASynWScale":           tcp_metric.BASynWScale,
+		"ABSynOptions":          tcp_metric.ABSynOptions,
+		"BASynOptions":          tcp_metric.BASynOptions,
 		"ABFinStart":            tcp_metric.ABFinStart,
 		"BAFinStart":            tcp_metric.BAFinStart,
 		"ABRstStart":            tcp_metric.ABRstStart,
@@ -114,6 +129,56 @@ func flowIPMetricToDocument(flow *flow.Flow, ipMetric *flow.IPMetric) orient.Doc
 	}
 }
 
+func flowHTTPMetricToDocument(flow *flow.Flow, httpMetric *flow.HTTPMetric) orient.Document {
+	if httpMetric == nil {
+		return nil
+	}
+	return orient.Document{
+		"@type":          "d",
+		"Requests":       httpMetric.Requests,
+		"Responses":      httpMetric.Responses,
+		"Informational":  httpMetric.Informational,
+		"Success":        httpMetric.Success,
+		"Redirection":    httpMetric.Redirection,
+		"ClientErrors":   httpMetric.ClientErrors,
+		"ServerErrors":   httpMetric.ServerErrors,
+		"ErrorRatio":     httpMetric.ErrorRatio,
+		"LatencySum":     httpMetric.LatencySum,
+		"LatencyMax":     httpMetric.LatencyMax,
+		"LatencyBuckets": httpMetric.LatencyBuckets,
+	}
+}
+
+func flowHTTP2MetricToDocument(flow *flow.Flow, http2Metric *flow.HTTP2Metric) orient.Document {
+	if http2Metric == nil {
+		return nil
+	}
+
+	var streams []orient.Document
+	for _, s := range http2Metric.StreamMetrics {
+		streams = append(streams, orient.Document{
+			"@type":      "d",
+			"ID":         s.ID,
+			"Method":     s.Method,
+			"Path":       s.Path,
+			"GRPCMethod": s.GRPCMethod,
+			"GRPCStatus": s.GRPCStatus,
+			"ABBytes":    s.ABBytes,
+			"BABytes":    s.BABytes,
+		})
+	}
+
+	return orient.Document{
+		"@type":         "d",
+		"Streams":       http2Metric.Streams,
+		"GRPCStreams":   http2Metric.GRPCStreams,
+		"ABBytes":       http2Metric.ABBytes,
+		"BABytes":       http2Metric.BABytes,
+		"StreamMetrics": streams,
+		"GRPCMethods":   http2Metric.GRPCMethods,
+	}
+}
+
 func flowToDocument(flow *flow.Flow) orient.Document {
 	metricDoc := flowMetricToDocument(flow, flow.Metric)
 	lastMetricDoc := flowMetricToDocument(flow, flow.LastUpdateMetric)
@@ -121,19 +186,27 @@ func flowToDocument(flow *flow.Flow) orient.Document {
 	ipMetricDoc := flowIPMetricToDocument(flow, flow.IPMetric)
 	var flowDoc orient.Document
 	flowDoc = orient.Document{
-		"@class":             "Flow",
-		"UUID":               flow.UUID,
-		"LayersPath":         flow.LayersPath,
-		"Application":        flow.Application,
-		"Metric":             metricDoc,
-		"Start":              flow.Start,
-		"Last":               flow.Last,
-		"RTT":                flow.RTT,
-		"TrackingID":         flow.TrackingID,
-		"L3TrackingID":       flow.L3TrackingID,
-		"ParentUUID":         flow.ParentUUID,
-		"NodeTID":            flow.NodeTID,
-		"RawPacketsCaptured": flow.RawPacketsCaptured,
+		"@class":              "Flow",
+		"UUID":                flow.UUID,
+		"LayersPath":          flow.LayersPath,
+		"Application":         flow.Application,
+		"Metric":              metricDoc,
+		"Start":               flow.Start,
+		"Last":                flow.Last,
+		"RTT":                 flow.RTT,
+		"Direction":           flow.Direction.String(),
+		"DirectionConfidence": flow.DirectionConfidence.String(),
+		"TrackingID":          flow.TrackingID,
+		"L3TrackingID":        flow.L3TrackingID,
+		"PayloadTrackingID":   flow.PayloadTrackingID,
+		"ChainID":             flow.ChainID,
+		"ThreatFeed":          flow.ThreatFeed,
+		"ThreatIndicator":     flow.ThreatIndicator,
+		"RetentionClass":      flow.RetentionClass,
+		"RetainUntil":         flow.RetainUntil,
+		"ParentUUID":          flow.ParentUUID,
+		"NodeTID":             flow.NodeTID,
+		"RawPacketsCaptured":  flow.RawPacketsCaptured,
 	}
 
 	if tcpMetricDoc != nil {
@@ -145,6 +218,12 @@ func flowToDocument(flow *flow.Flow) orient.Document {
 	if flow.IPMetric != nil {
 		flowDoc["IPMetric"] = ipMetricDoc
 	}
+	if httpMetricDoc := flowHTTPMetricToDocument(flow, flow.HTTPMetric); httpMetricDoc != nil {
+		flowDoc["HTTPMetric"] = httpMetricDoc
+	}
+	if http2MetricDoc := flowHTTP2MetricToDocument(flow, flow.HTTP2Metric); http2MetricDoc != nil {
+		flowDoc["HTTP2Metric"] = http2MetricDoc
+	}
 	if flow.Link != nil {
 		flowDoc["Link"] = orient.Document{
 			"Protocol": flow.Link.Protocol.String(),
@@ -162,11 +241,45 @@ func flowToDocument(flow *flow.Flow) orient.Document {
 		}
 	}
 	if flow.ICMP != nil {
-		flowDoc["ICMP"] = orient.Document{
+		icmpDoc := orient.Document{
 			"Type": flow.ICMP.Type.String(),
 			"Code": flow.ICMP.Code,
 			"ID":   flow.ICMP.ID,
 		}
+		if original := flow.ICMP.Original; original != nil {
+			originalDoc := orient.Document{
+				"UUID":         original.UUID,
+				"L3TrackingID": original.L3TrackingID,
+			}
+			if original.Network != nil {
+				originalDoc["Network"] = orient.Document{
+					"Protocol": original.Network.Protocol.String(),
+					"A":        original.Network.A,
+					"B":        original.Network.B,
+					"ID":       original.Network.ID,
+				}
+			}
+			if original.Transport != nil {
+				originalDoc["Transport"] = orient.Document{
+					"Protocol": original.Transport.Protocol.String(),
+					"A":        original.Transport.A,
+					"B":        original.Transport.B,
+					"ID":       original.Transport.ID,
+				}
+			}
+			icmpDoc["Original"] = originalDoc
+		}
+		flowDoc["ICMP"] = icmpDoc
+	}
+	if flow.ARP != nil {
+		flowDoc["ARP"] = orient.Document{
+			"Operation":  flow.ARP.Operation.String(),
+			"SenderMAC":  flow.ARP.SenderMAC,
+			"SenderIP":   flow.ARP.SenderIP,
+			"TargetMAC":  flow.ARP.TargetMAC,
+			"TargetIP":   flow.ARP.TargetIP,
+			"Gratuitous": flow.ARP.Gratuitous,
+		}
 	}
 	if flow.Transport != nil {
 		flowDoc["Transport"] = orient.Document{
@@ -195,11 +308,14 @@ func documentToMetric(document orient.Document) (common.Metric, error) {
 	return flowMetric, nil
 }
 
-func documentToRawPacket(document orient.Document) (*flow.RawPacket, layers.LinkType, error) {
+func documentToRawPacket(document orient.Document, cipher *common.DataCipher) (*flow.RawPacket, layers.LinkType, error) {
 	// decode base64 by hand as the json decoder used by orient db client just see a string
 	// and can not know that this is a array of byte as it only see interface{} as value
-	var err error
-	if document["Data"], err = base64.StdEncoding.DecodeString(document["Data"].(string)); err != nil {
+	data, err := base64.StdEncoding.DecodeString(document["Data"].(string))
+	if err != nil {
+		return nil, layers.LinkType(0), err
+	}
+	if document["Data"], err = cipher.Decrypt(data); err != nil {
 		return nil, layers.LinkType(0), err
 	}
 
@@ -247,7 +363,11 @@ func (c *OrientDBStorage) StoreFlows(flows []*flow.Flow) error {
 			continue
 		}
 		for _, r := range flow.LastRawPackets {
-			doc := flowRawPacketToDocument(linkType, r)
+			doc, err := flowRawPacketToDocument(linkType, r, c.cipher)
+			if err != nil {
+				logging.GetLogger().Errorf("Error while encrypting raw packet %+v: %s\n", r, err.Error())
+				continue
+			}
 			doc["Flow"] = flowID
 			if _, err = c.client.CreateDocument(doc); err != nil {
 				logging.GetLogger().Errorf("Error while pushing raw packet %+v: %s\n", r, err.Error())
@@ -310,7 +430,7 @@ func (c *OrientDBStorage) SearchRawPackets(fsq filters.SearchQuery, packetFilter
 
 	rawpackets := make(map[string]*flow.RawPackets)
 	for _, doc := range docs {
-		r, linkType, err := documentToRawPacket(doc)
+		r, linkType, err := documentToRawPacket(doc, c.cipher)
 		if err != nil {
 			return nil, err
 		}
@@ -363,6 +483,83 @@ func (c *OrientDBStorage) SearchMetrics(fsq filters.SearchQuery, metricFilter *f
 	return metrics, nil
 }
 
+// DeleteExpiredFlows deletes the flows retained until before now, in ms,
+// along with their metrics and raw packets
+func (c *OrientDBStorage) DeleteExpiredFlows(now int64) error {
+	for _, class := range []string{"FlowMetric", "FlowRawPacket", "TCPMetric", "IPMetric"} {
+		sql := fmt.Sprintf("DELETE FROM %s WHERE Flow.RetainUntil > 0 AND Flow.RetainUntil < %d", class, now)
+		if err := c.client.SQL(sql, nil); err != nil {
+			return fmt.Errorf("Error while deleting the expired %s documents: %s", class, err.Error())
+		}
+	}
+
+	sql := fmt.Sprintf("DELETE FROM Flow WHERE RetainUntil > 0 AND RetainUntil < %d", now)
+	if err := c.client.SQL(sql, nil); err != nil {
+		return fmt.Errorf("Error while deleting the expired flows: %s", err.Error())
+	}
+	return nil
+}
+
+// StoreAlertEvent pushes an alert event in the database
+func (c *OrientDBStorage) StoreAlertEvent(event *types.AlertEvent) error {
+	doc := orient.Document{
+		"@class":     "AlertEvent",
+		"@type":      "d",
+		"UUID":       event.UUID,
+		"AlertUUID":  event.AlertUUID,
+		"State":      event.State,
+		"Timestamp":  event.Timestamp,
+		"Host":       event.Host,
+		"ReasonData": event.ReasonData,
+	}
+
+	if _, err := c.client.CreateDocument(doc); err != nil {
+		return fmt.Errorf("Error while pushing alert event %s: %s", event.UUID, err.Error())
+	}
+	return nil
+}
+
+// SearchAlertEvents searches the alert events matching filters in the database
+func (c *OrientDBStorage) SearchAlertEvents(fsq filters.SearchQuery) ([]*types.AlertEvent, error) {
+	sql := "SELECT FROM AlertEvent"
+	if conditional := orient.FilterToExpression(fsq.Filter, nil); conditional != "" {
+		sql += " WHERE " + conditional
+	}
+
+	if fsq.Sort {
+		sql += " ORDER BY " + fsq.SortBy
+		if fsq.SortOrder != "" {
+			sql += " " + strings.ToUpper(fsq.SortOrder)
+		}
+	}
+
+	if r := fsq.PaginationRange; r != nil {
+		sql += fmt.Sprintf(" SKIP %d LIMIT %d", r.From, r.To-r.From)
+	}
+
+	docs, err := c.client.Search(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*types.AlertEvent
+	for _, doc := range docs {
+		event := new(types.AlertEvent)
+		if err := mapstructure.WeakDecode(doc, event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// Ping checks that the OrientDB database is reachable
+func (c *OrientDBStorage) Ping() error {
+	_, err := c.client.GetDatabase()
+	return err
+}
+
 // Start the database client
 func (c *OrientDBStorage) Start() {
 }
@@ -383,6 +580,11 @@ func New(backend string) (*OrientDBStorage, error) {
 	username := config.GetString(path + ".username")
 	password := config.GetString(path + ".password")
 
+	cipher, err := config.GetDataCipher(path + ".encryption")
+	if err != nil {
+		return nil, err
+	}
+
 	client, err := orient.NewClient(addr, database, username, password)
 	if err != nil {
 		return nil, err
@@ -434,6 +636,14 @@ func New(backend string) (*OrientDBStorage, error) {
 				{Name: "BASynStart", Type: "LONG", Mandatory: false, NotNull: true},
 				{Name: "ABSynTTL", Type: "INTEGER", Mandatory: false, NotNull: true},
 				{Name: "BASynTTL", Type: "INTEGER", Mandatory: false, NotNull: true},
+				{Name: "ABSynWindow", Type: "INTEGER", Mandatory: false, NotNull: true},
+				{Name: "BASynWindow", Type: "INTEGER", Mandatory: false, NotNull: true},
+				{Name: "ABSynMSS", Type: "INTEGER", Mandatory: false, NotNull: true},
+				{Name: "BASynMSS", Type: "INTEGER", Mandatory: false, NotNull: true},
+				{Name: "ABSynWScale", Type: "INTEGER", Mandatory: false, NotNull: true},
+				{Name: "BASynWScale", Type: "INTEGER", Mandatory: false, NotNull: true},
+				{Name: "ABSynOptions", Type: "STRING", Mandatory: false, NotNull: true},
+				{Name: "BASynOptions", Type: "STRING", Mandatory: false, NotNull: true},
 				{Name: "ABFinStart", Type: "LONG", Mandatory: false, NotNull: true},
 				{Name: "BAFinStart", Type: "LONG", Mandatory: false, NotNull: true},
 				{Name: "ABRstStart", Type: "LONG", Mandatory: false, NotNull: true},
@@ -488,8 +698,16 @@ func New(backend string) (*OrientDBStorage, error) {
 				{Name: "TCPMetric", Type: "EMBEDDED", LinkedClass: "TCPMetric"},
 				{Name: "Start", Type: "LONG"},
 				{Name: "Last", Type: "LONG"},
+				{Name: "Direction", Type: "STRING"},
+				{Name: "DirectionConfidence", Type: "STRING"},
 				{Name: "TrackingID", Type: "STRING", Mandatory: true, NotNull: true},
 				{Name: "L3TrackingID", Type: "STRING"},
+				{Name: "PayloadTrackingID", Type: "STRING"},
+				{Name: "ChainID", Type: "STRING"},
+				{Name: "ThreatFeed", Type: "STRING"},
+				{Name: "ThreatIndicator", Type: "STRING"},
+				{Name: "RetentionClass", Type: "STRING"},
+				{Name: "RetainUntil", Type: "LONG"},
 				{Name: "ParentUUID", Type: "STRING"},
 				{Name: "NodeTID", Type: "STRING"},
 				{Name: "RawPacketsCaptured", Type: "LONG"},
@@ -497,6 +715,8 @@ func New(backend string) (*OrientDBStorage, error) {
 			Indexes: []orient.Index{
 				{Name: "Flow.UUID", Fields: []string{"UUID"}, Type: "UNIQUE"},
 				{Name: "Flow.TrackingID", Fields: []string{"TrackingID"}, Type: "NOTUNIQUE"},
+				{Name: "Flow.ChainID", Fields: []string{"ChainID"}, Type: "NOTUNIQUE"},
+				{Name: "Flow.RetainUntil", Fields: []string{"RetainUntil"}, Type: "NOTUNIQUE"},
 				{Name: "Flow.TimeSpan", Fields: []string{"Start", "Last"}, Type: "NOTUNIQUE"},
 			},
 		}
@@ -505,6 +725,27 @@ func New(backend string) (*OrientDBStorage, error) {
 		}
 	}
 
+	if _, err := client.GetDocumentClass("AlertEvent"); err != nil {
+		class := orient.ClassDefinition{
+			Name: "AlertEvent",
+			Properties: []orient.Property{
+				{Name: "UUID", Type: "STRING", Mandatory: true, NotNull: true},
+				{Name: "AlertUUID", Type: "STRING", Mandatory: true, NotNull: true},
+				{Name: "State", Type: "STRING", Mandatory: true, NotNull: true},
+				{Name: "Timestamp", Type: "LONG", Mandatory: true, NotNull: true},
+				{Name: "Host", Type: "STRING"},
+				{Name: "ReasonData", Type: "STRING"},
+			},
+			Indexes: []orient.Index{
+				{Name: "AlertEvent.UUID", Fields: []string{"UUID"}, Type: "UNIQUE"},
+				{Name: "AlertEvent.AlertUUID", Fields: []string{"AlertUUID", "Timestamp"}, Type: "NOTUNIQUE"},
+			},
+		}
+		if err := client.CreateDocumentClass(class); err != nil {
+			return nil, fmt.Errorf("Failed to register class AlertEvent: %s", err.Error())
+		}
+	}
+
 	flowProp := orient.Property{Name: "Flow", Type: "LINK", LinkedClass: "Flow", Mandatory: false, NotNull: true}
 
 	client.CreateProperty("FlowMetric", flowProp)
@@ -521,5 +762,6 @@ func New(backend string) (*OrientDBStorage, error) {
 
 	return &OrientDBStorage{
 		client: client,
+		cipher: cipher,
 	}, nil
 }
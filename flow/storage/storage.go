@@ -26,6 +26,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/filters"
@@ -47,9 +48,28 @@ type Storage interface {
 	SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error)
 	SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error)
 	SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error)
+	Ping() error
 	Stop()
 }
 
+// AlertStorage interface of the storages persisting the alert events
+type AlertStorage interface {
+	StoreAlertEvent(event *types.AlertEvent) error
+	SearchAlertEvents(fsq filters.SearchQuery) ([]*types.AlertEvent, error)
+}
+
+// ExpirationStorage interface of the storages deleting the flows retained
+// until before now, in ms, along with their metrics and raw packets
+type ExpirationStorage interface {
+	DeleteExpiredFlows(now int64) error
+}
+
+// AggregationStorage interface of the storages computing aggregations of
+// the flows server side
+type AggregationStorage interface {
+	AggregateFlows(fsq filters.SearchQuery) (map[string]*filters.AggregationResult, error)
+}
+
 // NewStorage creates a new flow storage based on the backend
 func NewStorage(backend string) (s Storage, err error) {
 	driver := config.GetString("storage." + backend + ".driver")
@@ -23,6 +23,7 @@
 package flow
 
 import (
+	"encoding/json"
 	"net/http"
 	"sync"
 	"sync/atomic"
@@ -51,6 +52,48 @@ type TableReply struct {
 	status int
 }
 
+// TableDumpQuery requests the dump of the tables of the capture nodes, all
+// the tables when empty
+type TableDumpQuery struct {
+	NodeTIDs []string
+}
+
+// TableDump is the live state of a flow table, its flows being the ones not
+// expired yet, to debug the flows not reaching the analyzer. The times are in
+// milliseconds, the next update and expiration ones being estimated from the
+// previous ones.
+type TableDump struct {
+	NodeTID        string
+	Time           int64
+	UpdateEvery    int64
+	ExpireEvery    int64
+	LastUpdate     int64
+	NextUpdate     int64 `json:",omitempty"`
+	LastExpire     int64
+	NextExpire     int64 `json:",omitempty"`
+	UpdateVersion  int64
+	PendingPackets int
+	PendingFlows   int
+	Stats          TableStats
+	Flows          []*TableDumpFlow
+}
+
+// TableDumpFlow is a flow of a table dump with its internal state. Updated
+// reports that the flow changed since the last update sent, ExpireAt is the
+// estimated time of its expiration if no packet is seen until then.
+type TableDumpFlow struct {
+	Key           string
+	Flow          *Flow
+	UpdateVersion int64
+	Updated       bool
+	Idle          int64
+	ExpireAt      int64 `json:",omitempty"`
+}
+
+// ctDuration is the period of the internal tracking of the fragments and
+// tcp connections
+const ctDuration = 30 * time.Second
+
 // ExpireUpdateFunc defines expire and updates callback
 type ExpireUpdateFunc func(f []*Flow)
 
@@ -75,6 +118,21 @@ type TableOpts struct {
 	IPDefrag       bool
 	ReassembleTCP  bool
 	LayerKeyMode   LayerKeyMode
+	KeyFields      KeyFields
+	DirectionMode  DirectionMode
+}
+
+// TableStats holds the packet parsing counters of a flow table
+type TableStats struct {
+	// PacketsDecodingErrors counts the packets partially decoded
+	PacketsDecodingErrors int64
+	// PacketsMalformed counts the packets rejected as malformed
+	PacketsMalformed int64
+	// Packets and Bytes count the packets accepted by the table
+	Packets int64
+	Bytes   int64
+	// FlowsCreated counts the flows created by the table
+	FlowsCreated int64
 }
 
 // Table store the flow table and related metrics mechanism
@@ -103,6 +161,14 @@ type Table struct {
 	tcpAssembler   *TCPAssembler
 	flowOpts       FlowOpts
 	appPortMap     *ApplicationPortMap
+	decodingErrors int64
+	malformed      int64
+	packets        int64
+	bytes          int64
+	flowsCreated   int64
+	talkers        *TalkerTracker
+	virtualRouters *VirtualRouterTracker
+	dhcp           *DHCPTracker
 }
 
 // NewTable creates a new flow table
@@ -123,16 +189,21 @@ func NewTable(updateHandler *Handler, expireHandler *Handler, pipeline *Enhancer
 		ipDefragger:    NewIPDefragger(),
 		tcpAssembler:   NewTCPAssembler(),
 		appPortMap:     NewApplicationPortMapFromConfig(),
+		talkers:        NewTalkerTracker(),
+		virtualRouters: NewVirtualRouterTracker(nodeTID),
+		dhcp:           NewDHCPTracker(),
 	}
 	if len(opts) > 0 {
 		t.Opts = opts[0]
 	}
 
 	t.flowOpts = FlowOpts{
-		TCPMetric:    t.Opts.ExtraTCPMetric,
-		IPDefrag:     t.Opts.IPDefrag,
-		LayerKeyMode: t.Opts.LayerKeyMode,
-		AppPortMap:   t.appPortMap,
+		TCPMetric:     t.Opts.ExtraTCPMetric,
+		IPDefrag:      t.Opts.IPDefrag,
+		LayerKeyMode:  t.Opts.LayerKeyMode,
+		KeyFields:     t.Opts.KeyFields,
+		DirectionMode: t.Opts.DirectionMode,
+		AppPortMap:    t.appPortMap,
 	}
 
 	t.updateVersion = 0
@@ -298,6 +369,75 @@ func (ft *Table) onSearchQueryMessage(fsq *filters.SearchQuery) (*FlowSearchRepl
 	}, http.StatusOK
 }
 
+// matchNodeTIDs returns whether the table is one of the nodes, all the tables
+// matching an empty list
+func (ft *Table) matchNodeTIDs(tids []string) bool {
+	if len(tids) == 0 {
+		return true
+	}
+	for _, tid := range tids {
+		if tid == ft.nodeTID {
+			return true
+		}
+	}
+	return false
+}
+
+func handlerEvery(h *Handler) int64 {
+	if h == nil {
+		return 0
+	}
+	return int64(h.every / time.Millisecond)
+}
+
+// expireTime returns the estimated expiration time of a flow, expired by the
+// first expiration after the one following its last packet
+func (ft *Table) expireTime(f *Flow, every int64) int64 {
+	if ft.lastExpire == 0 || every == 0 {
+		return 0
+	}
+	if f.Last < ft.lastExpire {
+		return ft.lastExpire + every
+	}
+	return ft.lastExpire + ((f.Last-ft.lastExpire)/every+2)*every
+}
+
+// dump returns the state of the table and of its flows
+func (ft *Table) dump(now time.Time) *TableDump {
+	dump := &TableDump{
+		NodeTID:        ft.nodeTID,
+		Time:           common.UnixMillis(now),
+		UpdateEvery:    handlerEvery(ft.updateHandler),
+		ExpireEvery:    handlerEvery(ft.expireHandler),
+		LastUpdate:     ft.lastUpdate,
+		LastExpire:     ft.lastExpire,
+		UpdateVersion:  ft.updateVersion,
+		PendingPackets: len(ft.packetSeqChan),
+		PendingFlows:   len(ft.flowChan),
+		Stats:          ft.Stats(),
+		Flows:          make([]*TableDumpFlow, 0, len(ft.table)),
+	}
+	if dump.LastUpdate != 0 {
+		dump.NextUpdate = dump.LastUpdate + dump.UpdateEvery
+	}
+	if dump.LastExpire != 0 {
+		dump.NextExpire = dump.LastExpire + dump.ExpireEvery
+	}
+
+	for key, f := range ft.table {
+		dump.Flows = append(dump.Flows, &TableDumpFlow{
+			Key:           key,
+			Flow:          f,
+			UpdateVersion: f.XXX_state.updateVersion,
+			Updated:       f.XXX_state.updateVersion > ft.updateVersion,
+			Idle:          dump.Time - f.Last,
+			ExpireAt:      ft.expireTime(f, dump.ExpireEvery),
+		})
+	}
+
+	return dump
+}
+
 func (ft *Table) onQuery(query *TableQuery) *TableReply {
 	reply := &TableReply{
 		status: http.StatusBadRequest,
@@ -323,6 +463,27 @@ func (ft *Table) onQuery(query *TableQuery) *TableReply {
 		// protobuf replies
 		reply.Obj = append(reply.Obj, pb)
 
+		reply.status = http.StatusOK
+	case "DumpQuery":
+		var tdq TableDumpQuery
+		if err := json.Unmarshal(query.Obj, &tdq); err != nil {
+			logging.GetLogger().Errorf("Unable to decode the flow table dump query: %s", err.Error())
+			break
+		}
+
+		if !ft.matchNodeTIDs(tdq.NodeTIDs) {
+			reply.status = http.StatusNoContent
+			break
+		}
+
+		b, err := json.Marshal(ft.dump(time.Now()))
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to encode the flow table dump of %s: %s", ft.nodeTID, err.Error())
+			reply.status = http.StatusInternalServerError
+			break
+		}
+		reply.Obj = append(reply.Obj, b)
+
 		reply.status = http.StatusOK
 	}
 
@@ -358,6 +519,8 @@ func (ft *Table) packetToFlow(packet *Packet, parentUUID string) *Flow {
 	key := packet.Key(parentUUID, ft.flowOpts)
 	flow, new := ft.getOrCreateFlow(key)
 	if new {
+		atomic.AddInt64(&ft.flowsCreated, 1)
+
 		uuids := FlowUUIDs{
 			ParentUUID: parentUUID,
 		}
@@ -369,6 +532,10 @@ func (ft *Table) packetToFlow(packet *Packet, parentUUID string) *Flow {
 		}
 
 		flow.initFromPacket(key, packet, ft.nodeTID, uuids, ft.flowOpts)
+
+		if flow.ICMP != nil && flow.ICMP.Original != nil {
+			ft.linkICMPOriginal(flow.ICMP.Original, packet, parentUUID)
+		}
 	} else {
 		if ft.Opts.ReassembleTCP {
 			if layer := packet.GoPacket.TransportLayer(); layer != nil && layer.LayerType() == layers.LayerTypeTCP {
@@ -394,7 +561,24 @@ func (ft *Table) packetToFlow(packet *Packet, parentUUID string) *Flow {
 	return flow
 }
 
+// linkICMPOriginal references the flow of the packet quoted by an ICMP error
+func (ft *Table) linkICMPOriginal(original *ICMPOriginal, packet *Packet, parentUUID string) {
+	if key, ok := original.Key(packet, parentUUID, ft.flowOpts); ok {
+		if fl, found := ft.table[key]; found {
+			original.UUID = fl.UUID
+			original.L3TrackingID = fl.L3TrackingID
+		}
+	}
+}
+
 func (ft *Table) processPacketSeq(ps *PacketSequence) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddInt64(&ft.malformed, 1)
+			logging.GetLogger().Errorf("Failed to process packet for capture node %s: %v", ft.nodeTID, r)
+		}
+	}()
+
 	var parentUUID string
 	logging.GetLogger().Debugf("%d Packets received for capture node %s", len(ps.Packets), ft.nodeTID)
 	for _, packet := range ps.Packets {
@@ -423,9 +607,6 @@ func (ft *Table) Run() {
 	expireTicker := time.NewTicker(ft.expireHandler.every)
 	defer expireTicker.Stop()
 
-	// ticker used internally to track fragment and tcp connections
-	const ctDuration = 30 * time.Second
-
 	ctTicker := time.NewTicker(ctDuration)
 	defer ctTicker.Stop()
 
@@ -473,26 +654,107 @@ func (ft *Table) IPDefragger() *IPDefragger {
 	return nil
 }
 
+// packetSeqFromGoPacket splits the packet while accounting for the
+// decoding errors and the malformed packets
+func (ft *Table) packetSeqFromGoPacket(packet gopacket.Packet, outerLength int64, bpf *BPF) (ps *PacketSequence) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddInt64(&ft.malformed, 1)
+			logging.GetLogger().Errorf("Failed to parse packet for capture node %s: %v", ft.nodeTID, r)
+			ps = &PacketSequence{}
+		}
+	}()
+
+	if packet.ErrorLayer() != nil {
+		atomic.AddInt64(&ft.decodingErrors, 1)
+	}
+
+	ps, err := packetSeqFromGoPacket(packet, outerLength, bpf, ft.ipDefragger)
+	if err != nil {
+		atomic.AddInt64(&ft.malformed, 1)
+		logging.GetLogger().Debugf("Packet rejected for capture node %s: %s", ft.nodeTID, err)
+		return &PacketSequence{}
+	}
+
+	length := outerLength
+	if length == 0 {
+		if length = int64(packet.Metadata().Length); length == 0 {
+			length = int64(len(packet.Data()))
+		}
+	}
+	atomic.AddInt64(&ft.packets, 1)
+	atomic.AddInt64(&ft.bytes, length)
+
+	ft.talkers.Observe(packet, length)
+	ft.virtualRouters.Observe(packet)
+	ft.dhcp.Observe(packet)
+
+	return ps
+}
+
 // FeedWithGoPacket feeds the table with a gopacket
 func (ft *Table) FeedWithGoPacket(packet gopacket.Packet, bpf *BPF) {
-	if ps := PacketSeqFromGoPacket(packet, 0, bpf, ft.ipDefragger); len(ps.Packets) > 0 {
+	if ps := ft.packetSeqFromGoPacket(packet, 0, bpf); len(ps.Packets) > 0 {
 		ft.packetSeqChan <- ps
 	}
 }
 
 // FeedWithSFlowSample feeds the table with sflow samples
 func (ft *Table) FeedWithSFlowSample(sample *layers.SFlowFlowSample, bpf *BPF) {
-	for _, ps := range PacketSeqFromSFlowSample(sample, bpf, ft.ipDefragger) {
+	pss := packetSeqsFromSFlowSample(sample, func(packet gopacket.Packet, outerLength int64) *PacketSequence {
+		return ft.packetSeqFromGoPacket(packet, outerLength, bpf)
+	})
+	for _, ps := range pss {
 		ft.packetSeqChan <- ps
 	}
 }
 
+// Stats returns the packet, flow and parsing counters of the table
+func (ft *Table) Stats() TableStats {
+	return TableStats{
+		PacketsDecodingErrors: atomic.LoadInt64(&ft.decodingErrors),
+		PacketsMalformed:      atomic.LoadInt64(&ft.malformed),
+		Packets:               atomic.LoadInt64(&ft.packets),
+		Bytes:                 atomic.LoadInt64(&ft.bytes),
+		FlowsCreated:          atomic.LoadInt64(&ft.flowsCreated),
+	}
+}
+
+// TopTalkers returns the addresses which sent the most bytes since the
+// previous call
+func (ft *Table) TopTalkers() []Talker {
+	return ft.talkers.Top(TopTalkersCount)
+}
+
+// VirtualRouters returns the VRRP and HSRP virtual routers seen by the table
+func (ft *Table) VirtualRouters() map[string]VirtualRouter {
+	return ft.virtualRouters.Routers(ft.virtualRouters.Now())
+}
+
+// DHCPTransactions returns the DHCP transactions completed since the
+// previous call
+func (ft *Table) DHCPTransactions() []*DHCPTransaction {
+	return ft.dhcp.Transactions(ft.dhcp.Now())
+}
+
 // Start the flow table
 func (ft *Table) Start() (chan *PacketSequence, chan *Flow) {
 	go ft.Run()
 	return ft.packetSeqChan, ft.flowChan
 }
 
+// Flush sends all the flows of the table through the expire handler and
+// removes them from the table
+func (ft *Table) Flush() {
+	ft.lockState.Lock()
+	defer ft.lockState.Unlock()
+
+	if atomic.LoadInt64(&ft.state) == common.RunningState {
+		ft.flush <- true
+		<-ft.flushDone
+	}
+}
+
 // Stop the flow table
 func (ft *Table) Stop() {
 	ft.lockState.Lock()
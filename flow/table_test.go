@@ -23,10 +23,16 @@
 package flow
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/filters"
 )
@@ -75,6 +81,32 @@ func TestFlowExpire(t *testing.T) {
 	}
 }
 
+func TestFlowFlush(t *testing.T) {
+	var received int
+	callback := func(f []*Flow) {
+		received += len(f)
+	}
+	updHandler := NewFlowHandler(func(f []*Flow) {}, 300*time.Second)
+	expHandler := NewFlowHandler(callback, 300*time.Second)
+
+	table := NewTable(updHandler, expHandler, NewEnhancerPipeline(), "", TableOpts{})
+	fillTableFromPCAP(t, table, "pcaptraces/icmpv4-symetric.pcap", layers.LinkTypeEthernet, nil)
+
+	table.Start()
+	defer table.Stop()
+
+	for atomic.LoadInt64(&table.state) != common.RunningState {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	table.Flush()
+
+	// check that the handler sent all the flows
+	if received != 100 {
+		t.Errorf("Should receive 100 flows got : %d", received)
+	}
+}
+
 type fakeEnhancer struct {
 	enhanced bool
 }
@@ -226,3 +258,98 @@ func TestUpdate(t *testing.T) {
 		t.Errorf("Should have been notified : %+v", flow2)
 	}
 }
+
+func TestTableStats(t *testing.T) {
+	table := NewTable(nil, nil, NewEnhancerPipeline(), "", TableOpts{})
+
+	handleRead, err := pcap.OpenOffline("pcaptraces/icmpv4-symetric.pcap")
+	if err != nil {
+		t.Fatal("PCAP OpenOffline error (handle to read packet): ", err)
+	}
+	defer handleRead.Close()
+
+	var bytes int64
+	for {
+		data, ci, err := handleRead.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("PCAP OpenOffline error (handle to read packet): ", err)
+		}
+
+		p := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+		p.Metadata().CaptureInfo = ci
+		bytes += int64(ci.Length)
+
+		table.processPacketSeq(table.packetSeqFromGoPacket(p, 0, nil))
+	}
+
+	stats := table.Stats()
+	if stats.Packets != 200 || stats.Bytes != bytes || stats.FlowsCreated != 100 {
+		t.Errorf("Wrong table stats, expected 200 packets, %d bytes and 100 flows, got %+v", bytes, stats)
+	}
+
+	talkers := table.TopTalkers()
+	if len(talkers) != TopTalkersCount {
+		t.Fatalf("Expected %d top talkers, got %+v", TopTalkersCount, talkers)
+	}
+
+	for i := 1; i < len(talkers); i++ {
+		if talkers[i].Bytes > talkers[i-1].Bytes {
+			t.Errorf("Top talkers should be sorted by bytes, got %+v", talkers)
+		}
+	}
+
+	if talkers := table.TopTalkers(); len(talkers) != 0 {
+		t.Errorf("Top talkers should be reset after being reported, got %+v", talkers)
+	}
+}
+
+func TestTableDump(t *testing.T) {
+	updHandler := NewFlowHandler(func(f []*Flow) {}, 5*time.Second)
+	expHandler := NewFlowHandler(func(f []*Flow) {}, 10*time.Second)
+	table := NewTable(updHandler, expHandler, NewEnhancerPipeline(), "probe-1", TableOpts{})
+
+	table.lastExpire = 100000
+	table.updateAt(time.Unix(100, 0))
+
+	idle, _ := table.getOrCreateFlow("idle")
+	idle.Last = 95000
+
+	active, _ := table.getOrCreateFlow("active")
+	active.Last = 125000
+	active.XXX_state.updateVersion = table.updateVersion + 1
+
+	obj, _ := json.Marshal(&TableDumpQuery{NodeTIDs: []string{"probe-2"}})
+	if reply := table.onQuery(&TableQuery{Type: "DumpQuery", Obj: obj}); reply.status != http.StatusNoContent || len(reply.Obj) != 0 {
+		t.Fatalf("Expected no dump for another node, got status %d", reply.status)
+	}
+
+	obj, _ = json.Marshal(&TableDumpQuery{NodeTIDs: []string{"probe-1"}})
+	reply := table.onQuery(&TableQuery{Type: "DumpQuery", Obj: obj})
+	if reply.status != http.StatusOK || len(reply.Obj) != 1 {
+		t.Fatalf("Expected a dump of the table, got status %d", reply.status)
+	}
+
+	var dump TableDump
+	if err := json.Unmarshal(reply.Obj[0], &dump); err != nil {
+		t.Fatal(err)
+	}
+
+	if dump.NodeTID != "probe-1" || dump.UpdateEvery != 5000 || dump.NextUpdate != 105000 || dump.NextExpire != 110000 {
+		t.Errorf("Wrong table state: %+v", dump)
+	}
+
+	flows := make(map[string]*TableDumpFlow)
+	for _, f := range dump.Flows {
+		flows[f.Key] = f
+	}
+
+	if f := flows["idle"]; f == nil || f.Updated || f.ExpireAt != 110000 {
+		t.Errorf("Expected the idle flow to be expired by the next expiration, got: %+v", f)
+	}
+	// expired by the expiration following the first one after its last packet
+	if f := flows["active"]; f == nil || !f.Updated || f.ExpireAt != 140000 {
+		t.Errorf("Expected the active flow to be updated and expired later, got: %+v", f)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/gopacket"
+)
+
+const (
+	// TopTalkersCount is the number of top talkers reported by a table
+	TopTalkersCount = 10
+	// maxTalkers bounds the number of addresses accounted between two
+	// reports, the new addresses being ignored above
+	maxTalkers = 10000
+)
+
+// Talker describes the traffic sent by an address, the network address of
+// the packets or their link address for the packets without network layer
+type Talker struct {
+	Address string
+	Packets int64
+	Bytes   int64
+}
+
+// TalkerTracker accounts the traffic sent by each address of a capture
+type TalkerTracker struct {
+	sync.Mutex
+	talkers map[string]*Talker
+}
+
+// Observe accounts a captured packet of the given length to its sender
+func (tt *TalkerTracker) Observe(packet gopacket.Packet, length int64) {
+	var address string
+	if layer := packet.NetworkLayer(); layer != nil {
+		address = layer.NetworkFlow().Src().String()
+	} else if layer := packet.LinkLayer(); layer != nil {
+		address = layer.LinkFlow().Src().String()
+	} else {
+		return
+	}
+
+	tt.Lock()
+	defer tt.Unlock()
+
+	talker, found := tt.talkers[address]
+	if !found {
+		if len(tt.talkers) >= maxTalkers {
+			return
+		}
+		talker = &Talker{Address: address}
+		tt.talkers[address] = talker
+	}
+	talker.Packets++
+	talker.Bytes += length
+}
+
+// Top returns the addresses which sent the most bytes since the previous call
+func (tt *TalkerTracker) Top(count int) []Talker {
+	tt.Lock()
+	talkers := tt.talkers
+	tt.talkers = make(map[string]*Talker)
+	tt.Unlock()
+
+	top := make([]Talker, 0, len(talkers))
+	for _, talker := range talkers {
+		top = append(top, *talker)
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Bytes != top[j].Bytes {
+			return top[i].Bytes > top[j].Bytes
+		}
+		return top[i].Address < top[j].Address
+	})
+
+	if len(top) > count {
+		top = top[:count]
+	}
+	return top
+}
+
+// NewTalkerTracker returns a new talker tracker
+func NewTalkerTracker() *TalkerTracker {
+	return &TalkerTracker{
+		talkers: make(map[string]*Talker),
+	}
+}
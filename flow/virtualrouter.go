@@ -0,0 +1,368 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// Protocols of the virtual routers
+const (
+	VRRPProtocol = "VRRP"
+	HSRPProtocol = "HSRP"
+)
+
+// States of the virtual routers
+const (
+	VirtualRouterMaster = "MASTER"
+	VirtualRouterDown   = "DOWN"
+)
+
+const (
+	hsrpPort = 1985
+
+	hsrpOpResign       = 2
+	hsrpV1StateActive  = 16
+	hsrpV1StateStandby = 8
+	hsrpV2StateActive  = 6
+	hsrpV2StateStandby = 5
+)
+
+// ErrInvalidHello is returned when a VRRP or HSRP hello can not be parsed
+var ErrInvalidHello = errors.New("invalid virtual router hello")
+
+// VirtualRouter describes the state of a VRRP or HSRP virtual router as
+// observed from the hello packets of its members. PreviousMaster holds the
+// master before the last failover and Standby the HSRP standby router.
+type VirtualRouter struct {
+	Protocol       string
+	ID             int64
+	VirtualIPs     []string
+	State          string
+	Master         string
+	PreviousMaster string
+	Standby        string
+	Priority       int64
+	Transitions    int64
+	LastTransition int64
+	LastSeen       int64
+	expiresAt      time.Time
+}
+
+type virtualRouterHello struct {
+	protocol string
+	id       int64
+	priority int64
+	vips     []net.IP
+	master   bool
+	standby  bool
+	resign   bool
+	holdTime time.Duration
+}
+
+// VirtualRouterTracker tracks the master/backup transitions of the virtual
+// routers whose hellos go through a capture
+type VirtualRouterTracker struct {
+	sync.Mutex
+	nodeTID string
+	routers map[string]*VirtualRouter
+	clock   packetClock
+}
+
+// packetClock follows the time of the captured packets, that could be
+// replayed from a trace
+type packetClock struct {
+	last time.Time
+	wall time.Time
+}
+
+func (c *packetClock) tick(t time.Time) {
+	c.last, c.wall = t, time.Now()
+}
+
+func (c *packetClock) now() time.Time {
+	if c.last.IsZero() {
+		return time.Now().UTC()
+	}
+	return c.last.Add(time.Since(c.wall))
+}
+
+// parseVRRP parses a VRRP version 2 or 3 advertisement
+func parseVRRP(data []byte, ipv6 bool) (*virtualRouterHello, error) {
+	if len(data) < 8 || data[0]&0x0f != 1 {
+		return nil, ErrInvalidHello
+	}
+
+	version := data[0] >> 4
+	hello := &virtualRouterHello{
+		protocol: VRRPProtocol,
+		id:       int64(data[1]),
+		priority: int64(data[2]),
+	}
+
+	var interval time.Duration
+	switch version {
+	case 2:
+		if ipv6 {
+			return nil, ErrInvalidHello
+		}
+		interval = time.Duration(data[5]) * time.Second
+	case 3:
+		interval = time.Duration(binary.BigEndian.Uint16(data[4:6])&0x0fff) * 10 * time.Millisecond
+	default:
+		return nil, ErrInvalidHello
+	}
+
+	size := net.IPv4len
+	if ipv6 {
+		size = net.IPv6len
+	}
+
+	count := int(data[3])
+	if len(data) < 8+count*size {
+		return nil, ErrInvalidHello
+	}
+	for i := 0; i < count; i++ {
+		offset := 8 + i*size
+		hello.vips = append(hello.vips, net.IP(data[offset:offset+size]))
+	}
+
+	// priority 0 is sent by a master giving up its role
+	hello.resign = hello.priority == 0
+	hello.master = !hello.resign
+
+	// master down interval of the backups
+	skew := time.Duration(256-hello.priority) * interval / 256
+	hello.holdTime = 3*interval + skew
+
+	return hello, nil
+}
+
+// parseHSRP parses a HSRP version 1 hello or the group state TLV of a HSRP
+// version 2 hello
+func parseHSRP(data []byte) (*virtualRouterHello, error) {
+	hello := &virtualRouterHello{protocol: HSRPProtocol}
+
+	switch {
+	case len(data) >= 20 && data[0] == 0:
+		hello.resign = data[1] == hsrpOpResign
+		hello.master = data[2] == hsrpV1StateActive
+		hello.standby = data[2] == hsrpV1StateStandby
+		hello.holdTime = time.Duration(data[4]) * time.Second
+		hello.priority = int64(data[5])
+		hello.id = int64(data[6])
+		hello.vips = []net.IP{net.IP(data[16:20])}
+	case len(data) >= 2 && data[0] == 1:
+		for len(data) >= 2 {
+			kind, length := data[0], int(data[1])
+			if len(data) < 2+length {
+				return nil, ErrInvalidHello
+			}
+
+			// group state TLV
+			if kind == 1 && length >= 40 && data[2] == 2 {
+				tlv := data[2 : 2+length]
+				hello.resign = tlv[1] == hsrpOpResign
+				hello.master = tlv[2] == hsrpV2StateActive
+				hello.standby = tlv[2] == hsrpV2StateStandby
+				hello.id = int64(binary.BigEndian.Uint16(tlv[4:6]))
+				hello.priority = int64(binary.BigEndian.Uint32(tlv[12:16]))
+				hello.holdTime = time.Duration(binary.BigEndian.Uint32(tlv[20:24])) * time.Millisecond
+				if tlv[3] == 6 {
+					hello.vips = []net.IP{net.IP(tlv[24:40])}
+				} else {
+					hello.vips = []net.IP{net.IP(tlv[24:28])}
+				}
+				return hello, nil
+			}
+			data = data[2+length:]
+		}
+		return nil, ErrInvalidHello
+	default:
+		return nil, ErrInvalidHello
+	}
+
+	return hello, nil
+}
+
+// helloFromPacket returns the VRRP or HSRP hello carried by the innermost
+// IP layer of a packet along with its sender
+func helloFromPacket(packet gopacket.Packet) (*virtualRouterHello, string) {
+	var source net.IP
+	var hello *virtualRouterHello
+
+	for _, layer := range packet.Layers() {
+		switch layer := layer.(type) {
+		case *layers.IPv4:
+			source, hello = layer.SrcIP, nil
+			if layer.Protocol == layers.IPProtocolVRRP {
+				hello, _ = parseVRRP(layer.Payload, false)
+			}
+		case *layers.IPv6:
+			source, hello = layer.SrcIP, nil
+			if layer.NextHeader == layers.IPProtocolVRRP {
+				hello, _ = parseVRRP(layer.Payload, true)
+			}
+		case *layers.UDP:
+			if source != nil && layer.SrcPort == hsrpPort && layer.DstPort == hsrpPort {
+				hello, _ = parseHSRP(layer.Payload)
+			}
+		}
+	}
+
+	if hello == nil {
+		return nil, ""
+	}
+	return hello, source.String()
+}
+
+func (r *VirtualRouter) transition(state, master string, t time.Time) {
+	if r.Master != "" && r.Master != master {
+		r.PreviousMaster = r.Master
+	}
+
+	// the first hello only discovers the virtual router
+	if r.State != "" {
+		r.Transitions++
+		r.LastTransition = common.UnixMillis(t)
+	}
+
+	r.State, r.Master = state, master
+}
+
+func (r *VirtualRouter) update(hello *virtualRouterHello, source string, t time.Time) {
+	r.LastSeen = common.UnixMillis(t)
+
+	if len(hello.vips) > 0 && !hello.resign {
+		r.VirtualIPs = r.VirtualIPs[:0]
+		for _, ip := range hello.vips {
+			if !ip.IsUnspecified() {
+				r.VirtualIPs = append(r.VirtualIPs, ip.String())
+			}
+		}
+	}
+
+	switch {
+	case hello.resign:
+		if source == r.Master && r.State == VirtualRouterMaster {
+			r.transition(VirtualRouterDown, source, t)
+		}
+	case hello.master:
+		if r.State != VirtualRouterMaster || r.Master != source {
+			r.transition(VirtualRouterMaster, source, t)
+		}
+		if r.Standby == source {
+			r.Standby = ""
+		}
+		r.Priority = hello.priority
+		r.expiresAt = t.Add(hello.holdTime)
+	case hello.standby:
+		r.Standby = source
+	}
+}
+
+// Now returns the current time in the clock of the captured packets
+func (vt *VirtualRouterTracker) Now() time.Time {
+	vt.Lock()
+	defer vt.Unlock()
+
+	return vt.clock.now()
+}
+
+func (vt *VirtualRouterTracker) expire(now time.Time) {
+	for key, r := range vt.routers {
+		if r.State == VirtualRouterMaster && now.After(r.expiresAt) {
+			logging.GetLogger().Infof("Virtual router %s of capture node %s lost its master %s", key, vt.nodeTID, r.Master)
+			r.transition(VirtualRouterDown, r.Master, r.expiresAt)
+		}
+	}
+}
+
+// Observe updates the state of the virtual routers with a captured packet
+func (vt *VirtualRouterTracker) Observe(packet gopacket.Packet) {
+	hello, source := helloFromPacket(packet)
+	if hello == nil {
+		return
+	}
+
+	t := packet.Metadata().CaptureInfo.Timestamp
+	if t.IsZero() {
+		t = time.Now().UTC()
+	}
+
+	vt.Lock()
+	defer vt.Unlock()
+
+	vt.clock.tick(t)
+	vt.expire(t)
+
+	key := fmt.Sprintf("%s-%d", hello.protocol, hello.id)
+	r, found := vt.routers[key]
+	if !found {
+		r = &VirtualRouter{Protocol: hello.protocol, ID: hello.id}
+		vt.routers[key] = r
+	}
+
+	master := r.Master
+	r.update(hello, source, t)
+	if found && r.Master != master {
+		logging.GetLogger().Infof("Virtual router %s of capture node %s failed over from %s to %s", key, vt.nodeTID, master, r.Master)
+	}
+}
+
+// Routers returns a copy of the virtual routers, indexed by protocol and
+// identifier, after having expired the masters not heard from at the
+// given time of the packets clock
+func (vt *VirtualRouterTracker) Routers(now time.Time) map[string]VirtualRouter {
+	vt.Lock()
+	defer vt.Unlock()
+
+	vt.expire(now)
+
+	routers := make(map[string]VirtualRouter, len(vt.routers))
+	for key, r := range vt.routers {
+		vr := *r
+		vr.VirtualIPs = append([]string{}, r.VirtualIPs...)
+		routers[key] = vr
+	}
+	return routers
+}
+
+// NewVirtualRouterTracker returns a new virtual router tracker
+func NewVirtualRouterTracker(nodeTID string) *VirtualRouterTracker {
+	return &VirtualRouterTracker{
+		nodeTID: nodeTID,
+		routers: make(map[string]*VirtualRouter),
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func virtualRouterPacket(t *testing.T, ts time.Time, src string, l4 ...gopacket.SerializableLayer) gopacket.Packet {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      255,
+		Protocol: layers.IPProtocolVRRP,
+		SrcIP:    net.ParseIP(src),
+		DstIP:    net.ParseIP("224.0.0.18"),
+	}
+
+	if udp, ok := l4[0].(*layers.UDP); ok {
+		ip.Protocol = layers.IPProtocolUDP
+		ip.DstIP = net.ParseIP("224.0.0.2")
+		udp.SetNetworkLayerForChecksum(ip)
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x01, 0x01},
+		DstMAC:       net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0x12},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opts, append([]gopacket.SerializableLayer{eth, ip}, l4...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	packet.Metadata().CaptureInfo.Timestamp = ts
+	return packet
+}
+
+func vrrpv2(vrid, priority, interval byte, vip string) gopacket.Payload {
+	return append([]byte{0x21, vrid, priority, 1, 0, interval, 0, 0}, append(net.ParseIP(vip).To4(), make([]byte, 8)...)...)
+}
+
+func hsrpv1(group, state, priority byte, vip string) gopacket.Payload {
+	return append([]byte{0, 0, state, 3, 10, priority, group, 0, 'c', 'i', 's', 'c', 'o', 0, 0, 0}, net.ParseIP(vip).To4()...)
+}
+
+func TestVirtualRouterFailover(t *testing.T) {
+	vt := NewVirtualRouterTracker("node")
+	start := time.Unix(1500000000, 0)
+
+	// master 10.0.0.2 advertising every second
+	for i := 0; i < 3; i++ {
+		vt.Observe(virtualRouterPacket(t, start.Add(time.Duration(i)*time.Second), "10.0.0.2", vrrpv2(51, 150, 1, "10.0.0.1")))
+	}
+
+	r := vt.Routers(start.Add(2 * time.Second))["VRRP-51"]
+	if r.State != VirtualRouterMaster || r.Master != "10.0.0.2" || r.Transitions != 0 {
+		t.Fatalf("Wrong virtual router discovery: %+v", r)
+	}
+	if len(r.VirtualIPs) != 1 || r.VirtualIPs[0] != "10.0.0.1" || r.Priority != 150 {
+		t.Errorf("Wrong virtual router attributes: %+v", r)
+	}
+
+	// the master stops advertising and the backup takes over
+	vt.Observe(virtualRouterPacket(t, start.Add(10*time.Second), "10.0.0.3", vrrpv2(51, 100, 1, "10.0.0.1")))
+
+	r = vt.Routers(start.Add(10 * time.Second))["VRRP-51"]
+	if r.State != VirtualRouterMaster || r.Master != "10.0.0.3" || r.PreviousMaster != "10.0.0.2" {
+		t.Errorf("Failover not tracked: %+v", r)
+	}
+	if r.Transitions != 2 {
+		t.Errorf("Expected master down and backup promoted transitions, got %d", r.Transitions)
+	}
+
+	// the new master resigns
+	vt.Observe(virtualRouterPacket(t, start.Add(11*time.Second), "10.0.0.3", vrrpv2(51, 0, 1, "10.0.0.1")))
+	if r = vt.Routers(start.Add(11 * time.Second))["VRRP-51"]; r.State != VirtualRouterDown || r.Transitions != 3 {
+		t.Errorf("Resignation not tracked: %+v", r)
+	}
+}
+
+func TestVirtualRouterHSRP(t *testing.T) {
+	vt := NewVirtualRouterTracker("node")
+	start := time.Unix(1500000000, 0)
+	udp := func() *layers.UDP { return &layers.UDP{SrcPort: hsrpPort, DstPort: hsrpPort} }
+
+	vt.Observe(virtualRouterPacket(t, start, "10.0.0.2", udp(), hsrpv1(1, hsrpV1StateActive, 110, "10.0.0.1")))
+	vt.Observe(virtualRouterPacket(t, start, "10.0.0.3", udp(), hsrpv1(1, hsrpV1StateStandby, 100, "10.0.0.1")))
+
+	r := vt.Routers(start)["HSRP-1"]
+	if r.State != VirtualRouterMaster || r.Master != "10.0.0.2" || r.Standby != "10.0.0.3" {
+		t.Fatalf("Wrong HSRP group state: %+v", r)
+	}
+
+	// the hold time of 10 seconds expires
+	if r = vt.Routers(start.Add(11 * time.Second))["HSRP-1"]; r.State != VirtualRouterDown || r.Transitions != 1 {
+		t.Errorf("Expiration of the active router not tracked: %+v", r)
+	}
+
+	vt.Observe(virtualRouterPacket(t, start.Add(12*time.Second), "10.0.0.3", udp(), hsrpv1(1, hsrpV1StateActive, 100, "10.0.0.1")))
+	r = vt.Routers(start.Add(12 * time.Second))["HSRP-1"]
+	if r.Master != "10.0.0.3" || r.PreviousMaster != "10.0.0.2" || r.Standby != "" || r.Transitions != 2 {
+		t.Errorf("Standby router promotion not tracked: %+v", r)
+	}
+}
+
+func TestParseVirtualRouterHellos(t *testing.T) {
+	if _, err := parseVRRP([]byte{0x21, 1, 100, 4, 0, 1, 0, 0, 10, 0, 0, 1}, false); err != ErrInvalidHello {
+		t.Error("Truncated VRRP advertisement should be rejected")
+	}
+
+	// VRRPv3 over IPv6 with a 100 centiseconds interval
+	data := append([]byte{0x31, 7, 200, 1, 0, 100, 0, 0}, net.ParseIP("fe80::1")...)
+	hello, err := parseVRRP(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello.id != 7 || hello.vips[0].String() != "fe80::1" || hello.holdTime != 3*time.Second+time.Second*56/256 {
+		t.Errorf("Wrong VRRPv3 advertisement: %+v", hello)
+	}
+
+	// HSRPv2 group state TLV for group 300 and active state
+	tlv := []byte{1, 40, 2, 0, hsrpV2StateActive, 4, 0x01, 0x2c, 0, 0, 0x0c, 0x9f, 0xf1, 0x2c, 0, 0, 0, 120, 0, 0, 0x0b, 0xb8, 0, 0, 0x27, 0x10}
+	tlv = append(tlv, append(net.ParseIP("10.0.0.1").To4(), make([]byte, 12)...)...)
+	if hello, err = parseHSRP(tlv); err != nil {
+		t.Fatal(err)
+	}
+	if hello.id != 300 || !hello.master || hello.priority != 120 || hello.holdTime != 10*time.Second || hello.vips[0].String() != "10.0.0.1" {
+		t.Errorf("Wrong HSRPv2 hello: %+v", hello)
+	}
+}
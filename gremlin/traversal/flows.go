@@ -72,6 +72,7 @@ type FlowGremlinTraversalStep struct {
 	sort               bool
 	sortBy             string
 	sortOrder          common.SortOrder
+	topN               *TopNGremlinTraversalStep
 }
 
 // FlowTraversalStep a flow step linked to a storage
@@ -87,6 +88,7 @@ type FlowTraversalStep struct {
 type RawPacketsTraversalStep struct {
 	GraphTraversal *traversal.GraphTraversal
 	rawPackets     map[string]*flow.RawPackets
+	flows          map[string]*flow.Flow
 	error          error
 }
 
@@ -626,6 +628,12 @@ func (r *RawPacketsTraversalStep) Values() []interface{} {
 	return []interface{}{r.rawPackets}
 }
 
+// Flow returns the flow the raw packets identified by uuid belong to, nil if
+// the flow is not known
+func (r *RawPacketsTraversalStep) Flow(uuid string) *flow.Flow {
+	return r.flows[uuid]
+}
+
 // MarshalJSON serialize in JSON
 func (r *RawPacketsTraversalStep) MarshalJSON() ([]byte, error) {
 	values := r.Values()
@@ -694,7 +702,16 @@ func (f *FlowTraversalStep) RawPackets() *RawPacketsTraversalStep {
 		}
 	}
 
-	return &RawPacketsTraversalStep{GraphTraversal: f.GraphTraversal, rawPackets: rawPackets}
+	flows := make(map[string]*flow.Flow)
+	if f.flowset != nil {
+		for _, fl := range f.flowset.Flows {
+			if _, ok := rawPackets[fl.UUID]; ok {
+				flows[fl.UUID] = fl
+			}
+		}
+	}
+
+	return &RawPacketsTraversalStep{GraphTraversal: f.GraphTraversal, rawPackets: rawPackets, flows: flows}
 }
 
 // BPF returns only the raw packets that matches the specified BPF filter
@@ -739,7 +756,7 @@ func (r *RawPacketsTraversalStep) BPF(s ...interface{}) *RawPacketsTraversalStep
 		}
 	}
 
-	return &RawPacketsTraversalStep{GraphTraversal: r.GraphTraversal, rawPackets: rawPackets}
+	return &RawPacketsTraversalStep{GraphTraversal: r.GraphTraversal, rawPackets: rawPackets, flows: r.flows}
 }
 
 // Sockets returns the sockets at both sides of the specified flows
@@ -887,6 +904,12 @@ func (s *FlowGremlinTraversalStep) makeSearchQuery() (fsq filters.SearchQuery, e
 		SortOrder:       string(s.sortOrder),
 	}
 
+	// only the top flows are retrieved, from each agent or from the storage
+	if s.topN != nil && s.topN.groupBy == "" {
+		fsq.Sort, fsq.SortBy, fsq.SortOrder = true, s.topN.by, string(common.SortDescending)
+		fsq.PaginationRange = &filters.Range{From: 0, To: int64(s.topN.count)}
+	}
+
 	return
 }
 
@@ -990,6 +1013,11 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 			return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowSearchQuery: flowSearchQuery}, nil
 		}
 
+		// the following TopN step gets the talkers from the storage aggregations
+		if _, ok := s.Storage.(storage.AggregationStorage); ok && s.topN != nil && s.topN.groupBy != "" {
+			return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowSearchQuery: flowSearchQuery}, nil
+		}
+
 		if flowset, err = s.Storage.SearchFlows(flowSearchQuery); err != nil {
 			return nil, err
 		}
@@ -1047,11 +1075,15 @@ func (s *FlowGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) t
 		return s
 	}
 
-	switch next.(type) {
+	switch next := next.(type) {
 	case *MetricsGremlinTraversalStep:
 		s.metricsNextStep = true
 	case *RawPacketsGremlinTraversalStep:
 		s.rawpacketsNextStep = true
+	case *TopNGremlinTraversalStep:
+		if next.pushDown(s) {
+			s.topN = next
+		}
 	}
 
 	if s.context.ReduceRange(next) {
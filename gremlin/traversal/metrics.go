@@ -28,6 +28,7 @@ import (
 	"fmt"
 
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/topology/graph/traversal"
 )
 
@@ -35,6 +36,17 @@ const (
 	defaultAggregatesSliceLength = int64(30000) // 30 seconds
 )
 
+// Filling modes of the empty slices of aggregated metrics
+const (
+	// FillNone removes the empty slices
+	FillNone = "none"
+	// FillZero returns zero metrics for the empty slices
+	FillZero = "zero"
+	// FillLinear interpolates the empty slices from the surrounding ones,
+	// the leading and trailing empty slices being zero metrics
+	FillLinear = "linear"
+)
+
 // MetricsTraversalExtension describes a new extension to enhance the topology
 type MetricsTraversalExtension struct {
 	MetricsToken traversal.Token
@@ -204,8 +216,93 @@ func aggregateMetrics(m []common.Metric, start, last int64, sliceLength int64, r
 	return
 }
 
+// zeroMetric returns an empty metric of the same type as m for the slice
+func zeroMetric(m common.Metric, start, last int64) common.Metric {
+	zero := m.Sub(m)
+	zero.SetStart(start)
+	zero.SetLast(last)
+	return zero
+}
+
+// interpolate returns the metric of the k-th of the n empty slices between
+// the prev and next metrics, k starting at 1
+func interpolate(prev, next common.Metric, k, n int64, start, last int64) common.Metric {
+	// split the difference of the metrics as if it was spanning n+1 units
+	diff := next.Sub(prev)
+	diff.SetStart(0)
+	diff.SetLast(n + 1)
+
+	part, _ := diff.Split(k)
+
+	m := prev.Add(part)
+	m.SetStart(start)
+	m.SetLast(last)
+	return m
+}
+
+// fillMetrics returns the aggregated metrics with their empty slices filled
+// according to the fill mode
+func fillMetrics(aggregated []common.Metric, start, last, sliceLength int64, fill string) []common.Metric {
+	final := make([]common.Metric, 0, len(aggregated))
+	if fill == FillNone {
+		for _, e := range aggregated {
+			if e != nil {
+				final = append(final, e)
+			}
+		}
+		return final
+	}
+
+	var ref common.Metric
+	for _, e := range aggregated {
+		if e != nil {
+			ref = e
+			break
+		}
+	}
+	if ref == nil {
+		return final
+	}
+
+	prev := -1
+	for i, e := range aggregated {
+		sStart := start + int64(i)*sliceLength
+		sLast := sStart + sliceLength
+		if sLast > last {
+			sLast = last
+		}
+
+		if e != nil {
+			prev = i
+			final = append(final, e)
+			continue
+		}
+
+		next := -1
+		for j := i + 1; j < len(aggregated); j++ {
+			if aggregated[j] != nil {
+				next = j
+				break
+			}
+		}
+
+		if fill == FillLinear && prev != -1 && next != -1 {
+			n := int64(next - prev - 1)
+			final = append(final, interpolate(aggregated[prev], aggregated[next], int64(i-prev), n, sStart, sLast))
+		} else {
+			final = append(final, zeroMetric(ref, sStart, sLast))
+		}
+	}
+
+	return final
+}
+
 // Aggregates merges multiple metrics array into one by summing overlapping
-// metrics. It returns a unique array will all the aggregated metrics.
+// metrics. It returns a unique array will all the aggregated metrics. The
+// first parameter is the length in seconds of the slices, the second one the
+// filling mode of the empty slices, none, zero or linear. The slices are
+// aligned on multiples of their length when analyzer.flow.metrics_alignment
+// is enabled.
 func (m *MetricsTraversalStep) Aggregates(s ...interface{}) *MetricsTraversalStep {
 	if m.error != nil {
 		return NewMetricsTraversalStepFromError(m.error)
@@ -220,11 +317,33 @@ func (m *MetricsTraversalStep) Aggregates(s ...interface{}) *MetricsTraversalSte
 		sliceLength = sl * 1000 // Millisecond
 	}
 
+	fill := FillNone
+	if len(s) > 1 {
+		f, ok := s[1].(string)
+		if !ok || (f != FillNone && f != FillZero && f != FillLinear) {
+			return NewMetricsTraversalStepFromError(fmt.Errorf("Aggregates fill mode has to be one of %s, %s or %s", FillNone, FillZero, FillLinear))
+		}
+		fill = f
+	}
+
+	return m.aggregates(sliceLength, config.GetBool("analyzer.flow.metrics_alignment"), fill)
+}
+
+func (m *MetricsTraversalStep) aggregates(sliceLength int64, align bool, fill string) *MetricsTraversalStep {
 	context := m.GraphTraversal.Graph.GetContext()
 
 	start := context.TimeSlice.Start
 	last := context.TimeSlice.Last
 
+	// align the slices on the wall clock so that consecutive queries return
+	// the same slices
+	if align {
+		start -= start % sliceLength
+		if r := last % sliceLength; r != 0 {
+			last += sliceLength - r
+		}
+	}
+
 	steps := (last - start) / sliceLength
 	if (last-start)%sliceLength != 0 {
 		steps++
@@ -235,13 +354,7 @@ func (m *MetricsTraversalStep) Aggregates(s ...interface{}) *MetricsTraversalSte
 		aggregateMetrics(metrics, start, last, sliceLength, aggregated)
 	}
 
-	// filter out empty metrics
-	final := make([]common.Metric, 0)
-	for _, e := range aggregated {
-		if e != nil {
-			final = append(final, e)
-		}
-	}
+	final := fillMetrics(aggregated, start, last, sliceLength, fill)
 
 	return NewMetricsTraversalStep(m.GraphTraversal, map[string][]common.Metric{"Aggregated": final})
 }
@@ -703,3 +703,96 @@ func TestFlowMetricsAggregatesSum3(t *testing.T) {
 
 	testMetricSum(t, metrics, expected, time.Unix(30, 0), 30*time.Second)
 }
+
+func testMetricFill(t *testing.T, metrics, expected map[string][]common.Metric, tm time.Time, dr time.Duration, align bool, fill string) {
+	g := graph.NewGraph("test", &FakeGraphBackend{})
+
+	gt := traversal.NewGraphTraversal(g, false)
+	gt = gt.Context(tm, dr)
+
+	step := NewMetricsTraversalStep(gt, metrics)
+
+	got := step.aggregates(10000, align, fill)
+
+	exp := NewMetricsTraversalStep(gt, expected)
+	if !reflect.DeepEqual(exp.Values(), got.Values()) {
+		e, _ := exp.MarshalJSON()
+		g, _ := got.MarshalJSON()
+		t.Errorf("Metrics mismatch, expected: \n\n%s\n\ngot: \n\n%s", string(e), string(g))
+	}
+}
+
+func gapMetrics() map[string][]common.Metric {
+	return map[string][]common.Metric{
+		"aa": {
+			&flow.FlowMetric{
+				ABBytes:   1000,
+				ABPackets: 1000,
+				BABytes:   1000,
+				BAPackets: 1000,
+				Start:     0,
+				Last:      10000,
+			},
+			&flow.FlowMetric{
+				ABBytes:   3000,
+				ABPackets: 3000,
+				BABytes:   3000,
+				BAPackets: 3000,
+				Start:     20000,
+				Last:      30000,
+			},
+		},
+	}
+}
+
+// |- Metric -|          |- Metric -|
+// |- Slice  -|- Slice -|- Slice  -|
+func TestFlowMetricsAggregatesFillZero(t *testing.T) {
+	expected := map[string][]common.Metric{
+		"Aggregated": {
+			&flow.FlowMetric{ABBytes: 1000, ABPackets: 1000, BABytes: 1000, BAPackets: 1000, Start: 0, Last: 10000},
+			&flow.FlowMetric{Start: 10000, Last: 20000},
+			&flow.FlowMetric{ABBytes: 3000, ABPackets: 3000, BABytes: 3000, BAPackets: 3000, Start: 20000, Last: 30000},
+		},
+	}
+
+	testMetricFill(t, gapMetrics(), expected, time.Unix(30, 0), 30*time.Second, false, FillZero)
+}
+
+func TestFlowMetricsAggregatesFillLinear(t *testing.T) {
+	expected := map[string][]common.Metric{
+		"Aggregated": {
+			&flow.FlowMetric{ABBytes: 1000, ABPackets: 1000, BABytes: 1000, BAPackets: 1000, Start: 0, Last: 10000},
+			&flow.FlowMetric{ABBytes: 2000, ABPackets: 2000, BABytes: 2000, BAPackets: 2000, Start: 10000, Last: 20000},
+			&flow.FlowMetric{ABBytes: 3000, ABPackets: 3000, BABytes: 3000, BAPackets: 3000, Start: 20000, Last: 30000},
+		},
+	}
+
+	testMetricFill(t, gapMetrics(), expected, time.Unix(30, 0), 30*time.Second, false, FillLinear)
+}
+
+//     |- Metric -|
+// |- Slice -|- Slice -|
+func TestFlowMetricsAggregatesAlignment(t *testing.T) {
+	metrics := map[string][]common.Metric{
+		"aa": {
+			&flow.FlowMetric{
+				ABBytes:   1000,
+				ABPackets: 1000,
+				BABytes:   1000,
+				BAPackets: 1000,
+				Start:     5000,
+				Last:      15000,
+			},
+		},
+	}
+
+	expected := map[string][]common.Metric{
+		"Aggregated": {
+			&flow.FlowMetric{ABBytes: 500, ABPackets: 500, BABytes: 500, BAPackets: 500, Start: 0, Last: 10000},
+			&flow.FlowMetric{ABBytes: 500, ABPackets: 500, BABytes: 500, BAPackets: 500, Start: 10000, Last: 20000},
+		},
+	}
+
+	testMetricFill(t, metrics, expected, time.Unix(35, 0), 30*time.Second, true, FillNone)
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"errors"
+
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// StagingTraversalExtension describes a new extension to query the staging graph
+type StagingTraversalExtension struct {
+	StagingToken traversal.Token
+	staging      *graph.Graph
+}
+
+// StagingGremlinTraversalStep describes the Staging gremlin traversal step
+type StagingGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+	staging *graph.Graph
+}
+
+// NewStagingTraversalExtension returns a new graph traversal extension
+// switching the traversal to the staging graph of the shadow probes
+func NewStagingTraversalExtension(staging *graph.Graph) *StagingTraversalExtension {
+	return &StagingTraversalExtension{
+		StagingToken: traversalStagingToken,
+		staging:      staging,
+	}
+}
+
+// ScanIdent returns an associated graph token
+func (e *StagingTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
+	switch s {
+	case "STAGING":
+		return e.StagingToken, true
+	}
+	return traversal.IDENT, false
+}
+
+// ParseStep parse staging step
+func (e *StagingTraversalExtension) ParseStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	switch t {
+	case e.StagingToken:
+		if len(p.Params) != 0 {
+			return nil, errors.New("Staging doesn't accept any parameter")
+		}
+		return &StagingGremlinTraversalStep{context: p, staging: e.staging}, nil
+	}
+	return nil, nil
+}
+
+// Exec executes the staging step
+func (s *StagingGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch last.(type) {
+	case *traversal.GraphTraversal:
+		if s.staging == nil {
+			return nil, errors.New("No probe running in shadow mode")
+		}
+		return traversal.NewGraphTraversal(s.staging, true), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce staging step
+func (s *StagingGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context staging step
+func (s *StagingGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
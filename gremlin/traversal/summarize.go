@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"errors"
+
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// SummarizeTraversalExtension describes a new extension to enhance the topology
+type SummarizeTraversalExtension struct {
+	SummarizeToken traversal.Token
+}
+
+// SummarizeGremlinTraversalStep describes the Summarize gremlin traversal step
+type SummarizeGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+	key     string
+}
+
+// NewSummarizeTraversalExtension returns a new graph traversal extension
+func NewSummarizeTraversalExtension() *SummarizeTraversalExtension {
+	return &SummarizeTraversalExtension{
+		SummarizeToken: traversalSummarizeToken,
+	}
+}
+
+// ScanIdent returns an associated graph token
+func (e *SummarizeTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
+	switch s {
+	case "SUMMARIZE":
+		return e.SummarizeToken, true
+	}
+	return traversal.IDENT, false
+}
+
+// ParseStep parse summarize step
+func (e *SummarizeTraversalExtension) ParseStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	switch t {
+	case e.SummarizeToken:
+		if len(p.Params) != 1 {
+			return nil, errors.New("Summarize requires one metadata key as parameter")
+		}
+		key, ok := p.Params[0].(string)
+		if !ok {
+			return nil, errors.New("Summarize parameter has to be a string key")
+		}
+		return &SummarizeGremlinTraversalStep{context: p, key: key}, nil
+	}
+	return nil, nil
+}
+
+// Exec executes the summarize step
+func (s *SummarizeGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch tv := last.(type) {
+	case *traversal.GraphTraversalV:
+		gt, err := Summarize(tv, s.key)
+		if err != nil {
+			return nil, err
+		}
+		return gt, nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce summarize step
+func (s *SummarizeGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context summarize step
+func (s *SummarizeGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
+
+// Summarize returns a graph where the nodes sharing the same value for the
+// given metadata key are collapsed into a single node
+func Summarize(tv *traversal.GraphTraversalV, key string) (*traversal.GraphTraversal, error) {
+	if err := tv.Error(); err != nil {
+		return nil, err
+	}
+
+	tv.GraphTraversal.RLock()
+	defer tv.GraphTraversal.RUnlock()
+
+	sg, err := graph.NewSummaryGraph(tv.GraphTraversal.Graph, tv.GetNodes(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return traversal.NewGraphTraversal(sg, false), nil
+}
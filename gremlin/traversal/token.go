@@ -34,4 +34,8 @@ const (
 	traversalBpfToken         traversal.Token = 1007
 	traversalMetricsToken     traversal.Token = 1008
 	traversalSocketsToken     traversal.Token = 1009
+	traversalSummarizeToken   traversal.Token = 1010
+	traversalUtilizationToken traversal.Token = 1011
+	traversalStagingToken     traversal.Token = 1012
+	traversalTopNToken        traversal.Token = 1013
 )
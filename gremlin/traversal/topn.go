@@ -0,0 +1,276 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+const defaultTopNBy = "Metric.ABBytes"
+
+// TopNTraversalExtension describes a new extension to enhance the topology
+type TopNTraversalExtension struct {
+	TopNToken traversal.Token
+}
+
+// TopNGremlinTraversalStep describes the TopN gremlin traversal step,
+// 'TopN(10, "Metric.ABBytes")' returning the ten flows which sent the most
+// bytes and 'TopN(10, "Metric.ABBytes", "Network.A")' the ten addresses
+type TopNGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+	count   int
+	by      string
+	groupBy string
+}
+
+// Talker describes the sum of an integer field of the flows sharing the
+// same value for the grouping field
+type Talker struct {
+	Key   interface{}
+	Value int64
+	Flows int64
+}
+
+// NewTopNTraversalExtension returns a new graph traversal extension
+func NewTopNTraversalExtension() *TopNTraversalExtension {
+	return &TopNTraversalExtension{
+		TopNToken: traversalTopNToken,
+	}
+}
+
+// ScanIdent returns an associated graph token
+func (e *TopNTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
+	switch s {
+	case "TOPN":
+		return e.TopNToken, true
+	}
+	return traversal.IDENT, false
+}
+
+// ParseStep parse TopN step
+func (e *TopNTraversalExtension) ParseStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	switch t {
+	case e.TopNToken:
+		if len(p.Params) == 0 || len(p.Params) > 3 {
+			return nil, errors.New("TopN requires a count, and optionally a key and a grouping key, as parameters")
+		}
+
+		count, ok := p.Params[0].(int64)
+		if !ok || count <= 0 {
+			return nil, errors.New("TopN count has to be a positive integer")
+		}
+
+		step := &TopNGremlinTraversalStep{context: p, count: int(count), by: defaultTopNBy}
+		for i, param := range p.Params[1:] {
+			key, ok := param.(string)
+			if !ok {
+				return nil, errors.New("TopN keys have to be strings")
+			}
+			if i == 0 {
+				step.by = key
+			} else {
+				step.groupBy = key
+			}
+		}
+		return step, nil
+	}
+	return nil, nil
+}
+
+// Exec executes the TopN step
+func (s *TopNGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch tv := last.(type) {
+	case *FlowTraversalStep:
+		if s.groupBy == "" {
+			return TopFlows(tv, s.count, s.by), nil
+		}
+		return TopTalkers(tv, s.count, s.by, s.groupBy), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce TopN step
+func (s *TopNGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context TopN step
+func (s *TopNGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
+
+// pushDown returns whether the step can be computed by the flow search
+// query, the flows being neither paginated nor deduplicated before
+func (s *TopNGremlinTraversalStep) pushDown(fs *FlowGremlinTraversalStep) bool {
+	return s.context.StepContext.PaginationRange == nil && fs.context.StepContext.PaginationRange == nil && !fs.dedup
+}
+
+// flows returns the flows of the step, searched in the storage if their
+// search was deferred
+func (f *FlowTraversalStep) flows() (*flow.FlowSet, error) {
+	if f.flowset != nil {
+		return f.flowset, nil
+	}
+
+	if f.Storage == nil {
+		return nil, storage.ErrNoStorageConfigured
+	}
+	return f.Storage.SearchFlows(f.flowSearchQuery)
+}
+
+// TopFlows returns the count flows having the highest value for the key
+func TopFlows(f *FlowTraversalStep, count int, by string) *FlowTraversalStep {
+	if f.error != nil {
+		return f
+	}
+
+	flowset, err := f.flows()
+	if err != nil {
+		return &FlowTraversalStep{error: err}
+	}
+
+	flowset.Sort(common.SortDescending, by)
+	flowset.Slice(0, count)
+
+	return &FlowTraversalStep{GraphTraversal: f.GraphTraversal, Storage: f.Storage, flowset: flowset}
+}
+
+// TopTalkers returns the count values of the groupBy field for which the sum
+// of the by field of their flows is the highest, computed by the storage
+// when it supports aggregations and the flows were not retrieved yet
+func TopTalkers(f *FlowTraversalStep, count int, by string, groupBy string) *traversal.GraphTraversalValue {
+	if f.error != nil {
+		return traversal.NewGraphTraversalValueFromError(f.error)
+	}
+
+	var (
+		talkers []*Talker
+		err     error
+	)
+
+	if store, ok := f.Storage.(storage.AggregationStorage); ok && f.flowset == nil {
+		talkers, err = aggregateTalkers(store, f.flowSearchQuery, count, by, groupBy)
+	} else {
+		var flowset *flow.FlowSet
+		if flowset, err = f.flows(); err == nil {
+			talkers = sumTalkers(flowset, count, by, groupBy)
+		}
+	}
+
+	if err != nil {
+		return traversal.NewGraphTraversalValueFromError(err)
+	}
+
+	values := make([]interface{}, len(talkers))
+	for i, talker := range talkers {
+		values[i] = talker
+	}
+	return traversal.NewGraphTraversalValue(f.GraphTraversal, values)
+}
+
+func aggregateTalkers(store storage.AggregationStorage, fsq filters.SearchQuery, count int, by string, groupBy string) ([]*Talker, error) {
+	fsq.Aggregations = []*filters.Aggregation{
+		{
+			Name:  "talkers",
+			Type:  filters.TermsAggregation,
+			Field: groupBy,
+			Size:  int64(count),
+			Order: "sum",
+			Aggregations: []*filters.Aggregation{
+				{Name: "sum", Type: filters.SumAggregation, Field: by},
+			},
+		},
+	}
+
+	results, err := store.AggregateFlows(fsq)
+	if err != nil {
+		return nil, err
+	}
+
+	talkers := []*Talker{}
+	for _, bucket := range results["talkers"].Buckets {
+		talker := &Talker{Key: bucket.Key, Flows: bucket.Count}
+		if sum := bucket.Aggregations["sum"]; sum != nil && sum.Value != nil {
+			talker.Value = *sum.Value
+		}
+		talkers = append(talkers, talker)
+	}
+	return talkers, nil
+}
+
+// groupKey returns the value of the grouping field of a flow, either a
+// string or an integer
+func groupKey(f *flow.Flow, groupBy string) (interface{}, bool) {
+	if value, err := f.GetFieldString(groupBy); err == nil {
+		return value, true
+	}
+	if value, err := f.GetFieldInt64(groupBy); err == nil {
+		return value, true
+	}
+	return nil, false
+}
+
+func sumTalkers(flowset *flow.FlowSet, count int, by string, groupBy string) []*Talker {
+	groups := make(map[interface{}]*Talker)
+	for _, f := range flowset.Flows {
+		key, ok := groupKey(f, groupBy)
+		if !ok {
+			continue
+		}
+
+		talker, ok := groups[key]
+		if !ok {
+			talker = &Talker{Key: key}
+			groups[key] = talker
+		}
+
+		value, _ := f.GetFieldInt64(by)
+		talker.Value += value
+		talker.Flows++
+	}
+
+	talkers := make([]*Talker, 0, len(groups))
+	for _, talker := range groups {
+		talkers = append(talkers, talker)
+	}
+
+	sort.Slice(talkers, func(i, j int) bool {
+		if talkers[i].Value != talkers[j].Value {
+			return talkers[i].Value > talkers[j].Value
+		}
+		return fmt.Sprint(talkers[i].Key) < fmt.Sprint(talkers[j].Key)
+	})
+
+	if len(talkers) > count {
+		talkers = talkers[:count]
+	}
+	return talkers
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+)
+
+type fakeAggregationStorage struct {
+	storage.Storage
+	fsq filters.SearchQuery
+}
+
+func (s *fakeAggregationStorage) AggregateFlows(fsq filters.SearchQuery) (map[string]*filters.AggregationResult, error) {
+	s.fsq = fsq
+
+	sum := int64(4000)
+	return map[string]*filters.AggregationResult{
+		"talkers": {
+			Buckets: []*filters.AggregationBucket{
+				{Key: "10.0.0.1", Count: 2, Aggregations: map[string]*filters.AggregationResult{"sum": {Value: &sum}}},
+			},
+		},
+	}, nil
+}
+
+func newTopNFlowSet() *flow.FlowSet {
+	newFlow := func(uuid, a string, bytes int64) *flow.Flow {
+		return &flow.Flow{
+			UUID:    uuid,
+			Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: a, B: "10.0.0.254"},
+			Metric:  &flow.FlowMetric{ABBytes: bytes},
+		}
+	}
+
+	flowset := flow.NewFlowSet()
+	flowset.Flows = []*flow.Flow{
+		newFlow("f1", "10.0.0.1", 1000),
+		newFlow("f2", "10.0.0.2", 3000),
+		newFlow("f3", "10.0.0.1", 2500),
+		newFlow("f4", "10.0.0.3", 10),
+	}
+	return flowset
+}
+
+func TestTopFlows(t *testing.T) {
+	step := TopFlows(&FlowTraversalStep{flowset: newTopNFlowSet()}, 2, "Metric.ABBytes")
+	if step.Error() != nil {
+		t.Fatal(step.Error())
+	}
+
+	var uuids []string
+	for _, f := range step.flowset.Flows {
+		uuids = append(uuids, f.UUID)
+	}
+
+	if !reflect.DeepEqual(uuids, []string{"f2", "f3"}) {
+		t.Errorf("Expected the flows f2 and f3, got: %v", uuids)
+	}
+}
+
+func TestTopTalkers(t *testing.T) {
+	values := TopTalkers(&FlowTraversalStep{flowset: newTopNFlowSet()}, 2, "Metric.ABBytes", "Network.A").Values()
+
+	expected := []interface{}{
+		&Talker{Key: "10.0.0.1", Value: 3500, Flows: 2},
+		&Talker{Key: "10.0.0.2", Value: 3000, Flows: 1},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected talkers %+v, got: %+v", expected, values)
+	}
+
+	// the talkers of a deferred search are aggregated by the storage
+	store := &fakeAggregationStorage{}
+	step := &FlowTraversalStep{Storage: store, flowSearchQuery: filters.SearchQuery{Filter: filters.NewTermStringFilter("NodeTID", "123")}}
+	values = TopTalkers(step, 5, "Metric.ABBytes", "Network.A").Values()
+
+	if len(store.fsq.Aggregations) != 1 || store.fsq.Aggregations[0].Field != "Network.A" || store.fsq.Aggregations[0].Size != 5 {
+		t.Fatalf("Wrong aggregation requested: %+v", store.fsq.Aggregations)
+	}
+
+	if err := filters.ValidateAggregations(store.fsq.Aggregations); err != nil {
+		t.Error(err)
+	}
+
+	expected = []interface{}{&Talker{Key: "10.0.0.1", Value: 4000, Flows: 2}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected talkers %+v, got: %+v", expected, values)
+	}
+}
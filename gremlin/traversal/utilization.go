@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"encoding/json"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// UtilizationTraversalExtension describes a new extension to enhance the topology
+type UtilizationTraversalExtension struct {
+	UtilizationToken traversal.Token
+}
+
+// UtilizationGremlinTraversalStep describes the Utilization gremlin traversal step
+type UtilizationGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+}
+
+// NewUtilizationTraversalExtension returns a new graph traversal extension
+func NewUtilizationTraversalExtension() *UtilizationTraversalExtension {
+	return &UtilizationTraversalExtension{
+		UtilizationToken: traversalUtilizationToken,
+	}
+}
+
+// ScanIdent returns an associated graph token
+func (e *UtilizationTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
+	switch s {
+	case "UTILIZATION":
+		return e.UtilizationToken, true
+	}
+	return traversal.IDENT, false
+}
+
+// ParseStep parse utilization step
+func (e *UtilizationTraversalExtension) ParseStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	switch t {
+	case e.UtilizationToken:
+		return &UtilizationGremlinTraversalStep{context: p}, nil
+	}
+	return nil, nil
+}
+
+// Exec executes the utilization step
+func (s *UtilizationGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch tv := last.(type) {
+	case *traversal.GraphTraversalV:
+		return InterfaceUtilizations(tv), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce utilization step
+func (s *UtilizationGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context utilization step
+func (s *UtilizationGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
+
+// Utilization of the link of an interface, computed from its speed, in Mb/s,
+// and the throughput measured during the last metric update
+type Utilization struct {
+	Speed   int64
+	RxBps   int64
+	TxBps   int64
+	Percent int64
+}
+
+// GetField implements Getter interface
+func (u *Utilization) GetField(field string) (interface{}, error) {
+	return u.GetFieldInt64(field)
+}
+
+// GetFieldInt64 implements Getter interface
+func (u *Utilization) GetFieldInt64(field string) (int64, error) {
+	switch field {
+	case "Speed":
+		return u.Speed, nil
+	case "RxBps":
+		return u.RxBps, nil
+	case "TxBps":
+		return u.TxBps, nil
+	case "Percent":
+		return u.Percent, nil
+	}
+	return 0, common.ErrFieldNotFound
+}
+
+// GetFieldString implements Getter interface
+func (u *Utilization) GetFieldString(field string) (string, error) {
+	return "", common.ErrFieldNotFound
+}
+
+// newUtilization returns the utilization of the interface, nil if its speed
+// or its metrics are not known
+func newUtilization(n *graph.Node) (*Utilization, error) {
+	speed, err := n.GetFieldInt64("Speed")
+	if err != nil || speed <= 0 {
+		return nil, nil
+	}
+
+	m, _ := n.GetField("LastUpdateMetric")
+	if m == nil {
+		return nil, nil
+	}
+
+	var metric topology.InterfaceMetric
+	if err := mapstructure.WeakDecode(m, &metric); err != nil {
+		return nil, err
+	}
+
+	elapsed := metric.Last - metric.Start
+	if elapsed <= 0 {
+		return nil, nil
+	}
+
+	u := &Utilization{
+		Speed: speed,
+		RxBps: metric.RxBytes * 8 * 1000 / elapsed,
+		TxBps: metric.TxBytes * 8 * 1000 / elapsed,
+	}
+
+	max := u.RxBps
+	if u.TxBps > max {
+		max = u.TxBps
+	}
+	u.Percent = max * 100 / (speed * 1000000)
+
+	return u, nil
+}
+
+// InterfaceUtilizations returns an Utilization step from the speed and the
+// metric metadata of the interfaces
+func InterfaceUtilizations(tv *traversal.GraphTraversalV) *UtilizationTraversalStep {
+	if tv.Error() != nil {
+		return &UtilizationTraversalStep{error: tv.Error()}
+	}
+
+	tv.GraphTraversal.RLock()
+	defer tv.GraphTraversal.RUnlock()
+
+	utilizations := make(map[string]*Utilization)
+	for _, n := range tv.GetNodes() {
+		u, err := newUtilization(n)
+		if err != nil {
+			return &UtilizationTraversalStep{error: err}
+		}
+
+		if u != nil {
+			utilizations[string(n.ID)] = u
+		}
+	}
+
+	return &UtilizationTraversalStep{GraphTraversal: tv.GraphTraversal, utilizations: utilizations}
+}
+
+// UtilizationTraversalStep utilization step
+type UtilizationTraversalStep struct {
+	GraphTraversal *traversal.GraphTraversal
+	utilizations   map[string]*Utilization
+	error          error
+}
+
+// Has step, 'Has("Percent", GT(80))' keeps the links used over 80%
+func (u *UtilizationTraversalStep) Has(params ...interface{}) *UtilizationTraversalStep {
+	if u.error != nil {
+		return u
+	}
+
+	filter, err := paramsToFilter(params...)
+	if err != nil {
+		return &UtilizationTraversalStep{error: err}
+	}
+
+	utilizations := make(map[string]*Utilization)
+	for id, utilization := range u.utilizations {
+		if filter.Eval(utilization) {
+			utilizations[id] = utilization
+		}
+	}
+
+	return &UtilizationTraversalStep{GraphTraversal: u.GraphTraversal, utilizations: utilizations}
+}
+
+// Count step
+func (u *UtilizationTraversalStep) Count(s ...interface{}) *traversal.GraphTraversalValue {
+	return traversal.NewGraphTraversalValue(u.GraphTraversal, len(u.utilizations))
+}
+
+// Values returns the utilizations
+func (u *UtilizationTraversalStep) Values() []interface{} {
+	if len(u.utilizations) == 0 {
+		return []interface{}{}
+	}
+	return []interface{}{u.utilizations}
+}
+
+// MarshalJSON serialize in JSON
+func (u *UtilizationTraversalStep) MarshalJSON() ([]byte, error) {
+	values := u.Values()
+	u.GraphTraversal.RLock()
+	defer u.GraphTraversal.RUnlock()
+	return json.Marshal(values)
+}
+
+// Error returns traversal error
+func (u *UtilizationTraversalStep) Error() error {
+	return u.error
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+func TestInterfaceUtilizations(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	g := graph.NewGraphFromConfig(b)
+
+	// 900 Mb received in one second on a 1 Gb/s link
+	busy := g.NewNode(graph.GenID(), graph.Metadata{
+		"Type":  "device",
+		"Speed": int64(1000),
+		"LastUpdateMetric": &topology.InterfaceMetric{
+			RxBytes: 112500000,
+			TxBytes: 1000,
+			Start:   1000,
+			Last:    2000,
+		},
+	}, "host")
+
+	g.NewNode(graph.GenID(), graph.Metadata{
+		"Type":  "device",
+		"Speed": int64(1000),
+		"LastUpdateMetric": &topology.InterfaceMetric{
+			RxBytes: 1000,
+			TxBytes: 1000,
+			Start:   1000,
+			Last:    2000,
+		},
+	}, "host")
+
+	// no speed, no utilization
+	g.NewNode(graph.GenID(), graph.Metadata{"Type": "veth"}, "host")
+
+	gt := traversal.NewGraphTraversal(g, false)
+
+	step := InterfaceUtilizations(gt.V())
+	if step.Error() != nil {
+		t.Fatal(step.Error())
+	}
+
+	if len(step.utilizations) != 2 {
+		t.Fatalf("Expected 2 utilizations, got: %+v", step.utilizations)
+	}
+
+	u := step.utilizations[string(busy.ID)]
+	if u == nil || u.Percent != 90 || u.RxBps != 900000000 || u.TxBps != 8000 {
+		t.Errorf("Wrong utilization: %+v", u)
+	}
+
+	step = step.Has("Percent", traversal.Gt(int64(80)))
+	if step.Error() != nil {
+		t.Fatal(step.Error())
+	}
+
+	if len(step.utilizations) != 1 || step.utilizations[string(busy.ID)] == nil {
+		t.Errorf("Expected only the busy interface, got: %+v", step.utilizations)
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/abbot/go-http-auth"
 	"github.com/skydive-project/skydive/config"
@@ -37,11 +38,16 @@ import (
 var (
 	// ErrWrongCredentials error wrong credentials
 	ErrWrongCredentials = errors.New("Wrong credentials")
+
+	authClientOnce   sync.Once
+	authClientShared *http.Client
+	authClientErr    error
 )
 
 type AuthenticationOpts struct {
 	Username string
 	Password string
+	Token    string
 }
 
 type AuthenticationClient struct {
@@ -64,6 +70,12 @@ func setCookies(headers *http.Header, c *AuthenticationClient) {
 	headers.Set("Cookie", b.String())
 }
 
+func setAuthorization(headers *http.Header, c *AuthenticationClient) {
+	if c != nil && c.authOptions.Token != "" {
+		headers.Set("Authorization", "Bearer "+c.authOptions.Token)
+	}
+}
+
 func cookies(c *AuthenticationClient) []*http.Cookie {
 	var cookies []*http.Cookie
 	cookies = append(cookies, configCookies()...)
@@ -93,7 +105,22 @@ func (c *AuthenticationClient) Cookie() *http.Cookie {
 	return &http.Cookie{Name: "authtok", Value: c.AuthToken}
 }
 
+// getAuthHttpClient returns the client of the authentication requests, shared
+// by the authentication clients so that their connections get reused
+func getAuthHttpClient() (*http.Client, error) {
+	authClientOnce.Do(func() {
+		authClientShared, authClientErr = getHttpClient()
+	})
+	return authClientShared, authClientErr
+}
+
 func (c *AuthenticationClient) Authenticate() error {
+	// API tokens are sent along with each request, no login needed
+	if c.authOptions.Token != "" {
+		c.authenticated = true
+		return nil
+	}
+
 	values := url.Values{"username": {c.authOptions.Username}, "password": {c.authOptions.Password}}
 
 	u := c.Url.ResolveReference(&url.URL{Path: "/login"})
@@ -104,16 +131,16 @@ func (c *AuthenticationClient) Authenticate() error {
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	client, err := getAuthHttpClient()
+	if err != nil {
+		return err
+	}
+
 	var resp *http.Response
 	if config.IsTLSenabled() == true {
-		var client *http.Client
-		client, err = getHttpClient()
-		if err != nil {
-			return err
-		}
 		resp, err = client.Do(req)
 	} else {
-		resp, err = http.DefaultTransport.RoundTrip(req)
+		resp, err = client.Transport.RoundTrip(req)
 	}
 	if err != nil {
 		return fmt.Errorf("Authentication failed: %s", err)
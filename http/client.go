@@ -33,6 +33,8 @@ import (
 	"net/http/httputil"
 	"net/url"
 
+	"golang.org/x/net/http2"
+
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/logging"
@@ -57,16 +59,22 @@ func readBody(resp *http.Response) string {
 }
 
 func getHttpClient() (*http.Client, error) {
-	client := &http.Client{}
+	tr, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+
 	if config.IsTLSenabled() {
-		tlsConfig, err := getTLSConfig(true)
-		if err != nil {
+		if tr.TLSClientConfig, err = getTLSConfig(true); err != nil {
 			return nil, err
 		}
-		tr := &http.Transport{TLSClientConfig: tlsConfig}
-		client = &http.Client{Transport: tr}
+		if config.GetBool("http.http2.enabled") {
+			if err := http2.ConfigureTransport(tr); err != nil {
+				return nil, err
+			}
+		}
 	}
-	return client, nil
+	return &http.Client{Transport: tr}, nil
 }
 
 func NewRestClient(url *url.URL, authOptions *AuthenticationOpts) (*RestClient, error) {
@@ -78,7 +86,7 @@ func NewRestClient(url *url.URL, authOptions *AuthenticationOpts) (*RestClient,
 		client: client,
 		url:    url,
 	}
-	if authOptions.Username != "" {
+	if authOptions.Username != "" || authOptions.Token != "" {
 		rc.authClient = NewAuthenticationClient(url, authOptions)
 	}
 	return rc, nil
@@ -95,7 +103,12 @@ func (c *RestClient) Request(method, path string, body io.Reader, header http.He
 		}
 	}
 
-	url := c.url.ResolveReference(&url.URL{Path: path})
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	url := c.url.ResolveReference(ref)
 	req, err := http.NewRequest(method, url.String(), body)
 	if err != nil {
 		return nil, err
@@ -108,6 +121,7 @@ func (c *RestClient) Request(method, path string, body io.Reader, header http.He
 	req.Header.Add("Accept-Encoding", "gzip")
 
 	setCookies(&req.Header, c.authClient)
+	setAuthorization(&req.Header, c.authClient)
 
 	if c.debug() {
 		if buf, err := httputil.DumpRequest(req, true); err == nil {
@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockSamples is the number of heartbeats the clock offset of a peer is
+// estimated from
+const clockSamples = 8
+
+// clockSample is the clock offset of a peer, measured by a heartbeat, and
+// the round trip time of the heartbeat
+type clockSample struct {
+	offset time.Duration
+	rtt    time.Duration
+}
+
+// pingPayload returns the payload of a ping, the local time it is sent at
+func pingPayload(now time.Time) []byte {
+	return []byte(strconv.FormatInt(now.UnixNano(), 10))
+}
+
+// pongPayload returns the payload of the pong answering a ping, the payload
+// of the ping followed by the local time it was received at. Pings not
+// carrying a timestamp are echoed.
+func pongPayload(ping string, now time.Time) []byte {
+	if _, err := strconv.ParseInt(ping, 10, 64); err != nil {
+		return []byte(ping)
+	}
+	return []byte(ping + " " + strconv.FormatInt(now.UnixNano(), 10))
+}
+
+// parsePong returns the clock sample carried by a pong received at now, the
+// offset being the difference between the clock of the peer and the local
+// clock
+func parsePong(pong string, now time.Time) (clockSample, bool) {
+	fields := strings.Fields(pong)
+	if len(fields) != 2 {
+		return clockSample{}, false
+	}
+
+	sent, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return clockSample{}, false
+	}
+
+	received, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return clockSample{}, false
+	}
+
+	rtt := now.Sub(time.Unix(0, sent))
+	if rtt < 0 {
+		return clockSample{}, false
+	}
+
+	// the ping is assumed to reach the peer after half of the round trip
+	offset := time.Unix(0, received).Sub(time.Unix(0, sent)) - rtt/2
+	return clockSample{offset: offset, rtt: rtt}, true
+}
+
+// addClockSample records a clock sample of the peer. The offset retained is
+// the one of the sample having the lowest round trip time among the last
+// ones, as it is the least affected by network delays.
+func (c *WSConn) addClockSample(s clockSample) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.clockSamples = append(c.clockSamples, s)
+	if len(c.clockSamples) > clockSamples {
+		c.clockSamples = c.clockSamples[1:]
+	}
+
+	best := c.clockSamples[0]
+	for _, sample := range c.clockSamples[1:] {
+		if sample.rtt < best.rtt {
+			best = sample
+		}
+	}
+	c.ClockOffset, c.RoundTripTime = best.offset, best.rtt
+}
+
+// GetClockOffset returns the estimated difference between the clock of the
+// peer and the local clock, only measured on incoming connections
+func (c *WSConn) GetClockOffset() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.ClockOffset
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockOffset(t *testing.T) {
+	sent := time.Now()
+
+	// the peer clock is 3 seconds ahead, the ping and the pong take 10ms each
+	ping := string(pingPayload(sent))
+	pong := string(pongPayload(ping, sent.Add(3*time.Second+10*time.Millisecond)))
+
+	sample, ok := parsePong(pong, sent.Add(20*time.Millisecond))
+	if !ok {
+		t.Fatalf("Failed to parse pong '%s'", pong)
+	}
+
+	if sample.rtt != 20*time.Millisecond {
+		t.Errorf("Expected a round trip time of 20ms, got %s", sample.rtt)
+	}
+
+	if sample.offset != 3*time.Second {
+		t.Errorf("Expected an offset of 3s, got %s", sample.offset)
+	}
+}
+
+func TestClockOffsetLegacyPeer(t *testing.T) {
+	// peers not aware of the clock offset measurement echo the ping
+	ping := string(pingPayload(time.Now()))
+	if _, ok := parsePong(ping, time.Now()); ok {
+		t.Error("Expected an echoed ping not to give a clock sample")
+	}
+
+	if pong := string(pongPayload("", time.Now())); pong != "" {
+		t.Errorf("Expected an empty ping to be echoed, got '%s'", pong)
+	}
+}
+
+func TestClockOffsetLowestRoundTrip(t *testing.T) {
+	c := &WSConn{}
+	c.addClockSample(clockSample{offset: 2 * time.Second, rtt: 50 * time.Millisecond})
+	c.addClockSample(clockSample{offset: 3 * time.Second, rtt: 10 * time.Millisecond})
+	c.addClockSample(clockSample{offset: 4 * time.Second, rtt: 30 * time.Millisecond})
+
+	if offset := c.GetClockOffset(); offset != 3*time.Second {
+		t.Errorf("Expected the offset of the lowest round trip sample, got %s", offset)
+	}
+
+	for i := 0; i < clockSamples; i++ {
+		c.addClockSample(clockSample{offset: time.Second, rtt: 40 * time.Millisecond})
+	}
+
+	if offset := c.GetClockOffset(); offset != time.Second {
+		t.Errorf("Expected old samples to be discarded, got %s", offset)
+	}
+}
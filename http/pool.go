@@ -207,10 +207,20 @@ func (s *WSPool) DisconnectAll() {
 	s.eventHandlersLock.Unlock()
 
 	s.RLock()
-	for _, c := range s.speakers {
+	speakers := append([]WSSpeaker{}, s.speakers...)
+	s.RUnlock()
+
+	for _, c := range speakers {
 		c.Disconnect()
 	}
-	s.RUnlock()
+
+	// give a chance to the speakers to flush their sending queue
+	deadline := time.Now().Add(writeWait)
+	for _, c := range speakers {
+		for c.IsConnected() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
 }
 
 func (s *WSPool) broadcastMessage(m WSMessage) {
@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+
+	"github.com/skydive-project/skydive/config"
+)
+
+// DialFunc establishes a connection to an address
+type DialFunc func(network, addr string) (net.Conn, error)
+
+// clientProxy describes how the clients reach the analyzers. HTTP proxies
+// are used through CONNECT, SOCKS5 proxies and SSH tunnels through a
+// dedicated dial function.
+type clientProxy struct {
+	proxy func(*http.Request) (*url.URL, error)
+	dial  DialFunc
+}
+
+// sshTunnel dials the connections through an SSH connection to a bastion,
+// the SSH connection is established on first use and re-established when
+// it gets closed
+type sshTunnel struct {
+	sync.Mutex
+	addr   string
+	config *ssh.ClientConfig
+	client *ssh.Client
+}
+
+var (
+	proxyOnce   sync.Once
+	proxyShared *clientProxy
+	proxyErr    error
+)
+
+func (t *sshTunnel) getClient() (*ssh.Client, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.client == nil {
+		client, err := ssh.Dial("tcp", t.addr, t.config)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to connect to SSH bastion %s: %s", t.addr, err)
+		}
+		t.client = client
+
+		go func() {
+			client.Wait()
+			t.Lock()
+			if t.client == client {
+				t.client = nil
+			}
+			t.Unlock()
+		}()
+	}
+
+	return t.client, nil
+}
+
+// Dial a connection through the tunnel
+func (t *sshTunnel) Dial(network, addr string) (net.Conn, error) {
+	client, err := t.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(network, addr)
+}
+
+func newSSHTunnel(u *url.URL) (*sshTunnel, error) {
+	keyFile := config.GetString("http.proxy.ssh_key")
+	pem, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read SSH key %s: %s", keyFile, err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse SSH key %s: %s", keyFile, err)
+	}
+
+	hostKey := config.GetString("http.proxy.ssh_host_key")
+	if hostKey == "" {
+		return nil, fmt.Errorf("No host key given for the SSH bastion %s, http.proxy.ssh_host_key has to be set", u.Host)
+	}
+
+	expected, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse SSH host key: %s", err)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	return &sshTunnel{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:    u.User.Username(),
+			Auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			Timeout: 10 * time.Second,
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				if !bytes.Equal(key.Marshal(), expected.Marshal()) {
+					return fmt.Errorf("Host key mismatch for %s", hostname)
+				}
+				return nil
+			},
+		},
+	}, nil
+}
+
+func newClientProxy(proxyURL string) (*clientProxy, error) {
+	if proxyURL == "" {
+		return &clientProxy{proxy: http.ProxyFromEnvironment}, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy URL %s: %s", proxyURL, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &clientProxy{proxy: http.ProxyURL(u)}, nil
+	case "socks5":
+		dialer, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		return &clientProxy{dial: dialer.Dial}, nil
+	case "ssh":
+		tunnel, err := newSSHTunnel(u)
+		if err != nil {
+			return nil, err
+		}
+		return &clientProxy{dial: tunnel.Dial}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme %s, should be http, https, socks5 or ssh", u.Scheme)
+	}
+}
+
+// getClientProxy returns the proxy set in the configuration, shared by all
+// the clients so that a single SSH connection is used
+func getClientProxy() (*clientProxy, error) {
+	proxyOnce.Do(func() {
+		proxyShared, proxyErr = newClientProxy(config.GetString("http.proxy.url"))
+	})
+	return proxyShared, proxyErr
+}
+
+// newTransport returns an HTTP transport going through the configured proxy
+func newTransport() (*http.Transport, error) {
+	p, err := getClientProxy()
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &http.Transport{
+		Proxy:               p.proxy,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if p.dial != nil {
+		tr.Dial = p.dial
+	}
+	return tr, nil
+}
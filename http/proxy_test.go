@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/skydive-project/skydive/config"
+)
+
+// tunnel copies the data between the connections until one gets closed
+func tunnel(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+func newTestTarget() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+}
+
+func getThroughProxy(t *testing.T, p *clientProxy, target string) string {
+	tr := &http.Transport{
+		Proxy:           p.proxy,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	if p.dial != nil {
+		tr.Dial = p.dial
+	}
+	defer tr.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: tr}).Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestProxyConnect(t *testing.T) {
+	target := newTestTarget()
+	defer target.Close()
+
+	var connects int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		atomic.AddInt32(&connects, 1)
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		tunnel(conn, upstream)
+	}))
+	defer proxy.Close()
+
+	p, err := newClientProxy(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body := getThroughProxy(t, p, target.URL); body != "hello" {
+		t.Errorf("Expected the target response, got: %s", body)
+	}
+	if n := atomic.LoadInt32(&connects); n != 1 {
+		t.Errorf("Expected a single CONNECT to the proxy, got: %d", n)
+	}
+}
+
+// serveSOCKS5 handles the connections of a SOCKS5 proxy without
+// authentication supporting the CONNECT command only
+func serveSOCKS5(l net.Listener, connects *int32) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			buf := make([]byte, 262)
+			// greeting: version, number of methods and methods
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				conn.Close()
+				return
+			}
+			if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+				conn.Close()
+				return
+			}
+			conn.Write([]byte{5, 0})
+
+			// request: version, command, reserved, address type
+			if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 {
+				conn.Close()
+				return
+			}
+
+			var host string
+			switch buf[3] {
+			case 1:
+				io.ReadFull(conn, buf[:4])
+				host = net.IP(buf[:4]).String()
+			case 3:
+				io.ReadFull(conn, buf[:1])
+				io.ReadFull(conn, buf[1:1+buf[0]])
+				host = string(buf[1 : 1+buf[0]])
+			default:
+				conn.Close()
+				return
+			}
+			io.ReadFull(conn, buf[:2])
+			port := binary.BigEndian.Uint16(buf[:2])
+
+			upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+			if err != nil {
+				conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+				conn.Close()
+				return
+			}
+			atomic.AddInt32(connects, 1)
+
+			conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+			tunnel(conn, upstream)
+		}()
+	}
+}
+
+func TestProxySOCKS5(t *testing.T) {
+	target := newTestTarget()
+	defer target.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var connects int32
+	go serveSOCKS5(l, &connects)
+
+	p, err := newClientProxy("socks5://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.dial == nil {
+		t.Fatal("Expected the SOCKS5 proxy to dial the connections")
+	}
+
+	if body := getThroughProxy(t, p, target.URL); body != "hello" {
+		t.Errorf("Expected the target response, got: %s", body)
+	}
+	if n := atomic.LoadInt32(&connects); n != 1 {
+		t.Errorf("Expected a single connection through the proxy, got: %d", n)
+	}
+}
+
+func TestProxySSHHostKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := ioutil.TempFile("", "skydive-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	file.Write(pem.EncodeToMemory(block))
+	file.Close()
+
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Set("http.proxy.ssh_key", file.Name())
+	defer config.Set("http.proxy.ssh_host_key", "")
+
+	u, _ := url.Parse("ssh://skydive@bastion")
+
+	config.Set("http.proxy.ssh_host_key", "")
+	if _, err := newSSHTunnel(u); err == nil {
+		t.Error("Expected the SSH tunnel to require the host key of the bastion")
+	}
+
+	config.Set("http.proxy.ssh_host_key", string(ssh.MarshalAuthorizedKey(pub)))
+	tunnel, err := newSSHTunnel(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tunnel.addr != "bastion:22" {
+		t.Errorf("Expected the default SSH port, got: %s", tunnel.addr)
+	}
+	if err := tunnel.config.HostKeyCallback("bastion:22", nil, pub); err != nil {
+		t.Errorf("Expected the host key to be accepted, got: %s", err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	otherPub, _ := ssh.NewPublicKey(&other.PublicKey)
+	if err := tunnel.config.HostKeyCallback("bastion:22", nil, otherPub); err == nil {
+		t.Error("Expected another host key to be rejected")
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/config"
+)
+
+var (
+	// ErrRegistrationNotSigned is returned when a registration request is not signed
+	ErrRegistrationNotSigned = errors.New("Registration request not signed")
+	// ErrRegistrationExpired is returned when the timestamp of a registration request is too old or in the future
+	ErrRegistrationExpired = errors.New("Registration request expired")
+	// ErrRegistrationReplayed is returned when the nonce of a registration request was already used
+	ErrRegistrationReplayed = errors.New("Registration request replayed")
+	// ErrRegistrationSignature is returned when the signature of a registration request doesn't match any key
+	ErrRegistrationSignature = errors.New("Invalid registration signature")
+)
+
+// registrationSignature returns the HMAC-SHA256 of the registration
+// parameters, binding the signature to a host and an endpoint
+func registrationSignature(key, host, path, timestamp, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(host + "\n" + path + "\n" + timestamp + "\n" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRegistration adds the headers authenticating the registration of the
+// host on the endpoint with the given key
+func signRegistration(headers http.Header, key, host, path string) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(b)
+
+	headers.Set("X-Registration-Timestamp", timestamp)
+	headers.Set("X-Registration-Nonce", nonce)
+	headers.Set("X-Registration-Signature", registrationSignature(key, host, path, timestamp, nonce))
+
+	return nil
+}
+
+// RegistrationVerifier authenticates the hosts connecting to a websocket
+// server. A host signs its registration either with its own pre-shared key
+// or, when none is configured for it, with one of the bootstrap tokens. Each
+// nonce is only accepted once within the allowed clock skew.
+type RegistrationVerifier struct {
+	sync.Mutex
+	keys            map[string]string
+	bootstrapTokens []string
+	maxSkew         time.Duration
+	nonces          map[string]time.Time
+}
+
+func (v *RegistrationVerifier) candidateKeys(host string) []string {
+	if key, ok := v.keys[strings.ToLower(host)]; ok {
+		return []string{key}
+	}
+	return v.bootstrapTokens
+}
+
+// useNonce records the nonce, returns false if it was already used
+func (v *RegistrationVerifier) useNonce(nonce string, now time.Time) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	for n, expire := range v.nonces {
+		if now.After(expire) {
+			delete(v.nonces, n)
+		}
+	}
+
+	if _, ok := v.nonces[nonce]; ok {
+		return false
+	}
+
+	// a nonce only needs to be remembered as long as its timestamp is valid
+	v.nonces[nonce] = now.Add(2 * v.maxSkew)
+	return true
+}
+
+// Verify checks the registration headers of a request
+func (v *RegistrationVerifier) Verify(r *auth.AuthenticatedRequest, host string) error {
+	timestamp := r.Header.Get("X-Registration-Timestamp")
+	nonce := r.Header.Get("X-Registration-Nonce")
+	signature := r.Header.Get("X-Registration-Signature")
+
+	if timestamp == "" || nonce == "" || signature == "" {
+		return ErrRegistrationNotSigned
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid registration timestamp: %s", err)
+	}
+
+	now := time.Now()
+	if skew := now.Sub(time.Unix(sec, 0)); skew > v.maxSkew || skew < -v.maxSkew {
+		return ErrRegistrationExpired
+	}
+
+	valid := false
+	for _, key := range v.candidateKeys(host) {
+		expected := registrationSignature(key, host, r.URL.Path, timestamp, nonce)
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		return ErrRegistrationSignature
+	}
+
+	// only record the nonces of valid requests so that they can't be
+	// exhausted by unauthenticated hosts
+	if !v.useNonce(nonce, now) {
+		return ErrRegistrationReplayed
+	}
+
+	return nil
+}
+
+// NewRegistrationVerifier returns a new registration verifier
+func NewRegistrationVerifier(keys map[string]string, bootstrapTokens []string, maxSkew time.Duration) *RegistrationVerifier {
+	// host IDs are case insensitive as the configuration keys are
+	hostKeys := make(map[string]string)
+	for host, key := range keys {
+		hostKeys[strings.ToLower(host)] = key
+	}
+
+	return &RegistrationVerifier{
+		keys:            hostKeys,
+		bootstrapTokens: bootstrapTokens,
+		maxSkew:         maxSkew,
+		nonces:          make(map[string]time.Time),
+	}
+}
+
+// NewRegistrationVerifierFromConfig returns a registration verifier based on
+// the configuration, nil if the registration authentication is disabled
+func NewRegistrationVerifierFromConfig() (*RegistrationVerifier, error) {
+	if !config.GetBool("analyzer.registration.enabled") {
+		return nil, nil
+	}
+
+	keys := config.GetStringMapString("analyzer.registration.keys")
+	tokens := config.GetStringSlice("analyzer.registration.bootstrap_tokens")
+	if len(keys) == 0 && len(tokens) == 0 {
+		return nil, errors.New("Registration authentication enabled but neither keys nor bootstrap tokens are configured")
+	}
+
+	maxSkew := time.Duration(config.GetInt("analyzer.registration.max_skew")) * time.Second
+
+	return NewRegistrationVerifier(keys, tokens, maxSkew), nil
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+)
+
+func newRegistrationRequest(t *testing.T, key, host string) *auth.AuthenticatedRequest {
+	headers := http.Header{}
+	if err := signRegistration(headers, key, host, "/ws/agent"); err != nil {
+		t.Fatal(err)
+	}
+
+	return &auth.AuthenticatedRequest{
+		Request: http.Request{Header: headers, URL: &url.URL{Path: "/ws/agent"}},
+	}
+}
+
+func TestRegistrationVerifier(t *testing.T) {
+	v := NewRegistrationVerifier(map[string]string{"Host1": "key1"}, []string{"bootstrap"}, time.Minute)
+
+	r := newRegistrationRequest(t, "key1", "host1")
+	if err := v.Verify(r, "host1"); err != nil {
+		t.Fatalf("Registration with the pre-shared key refused: %s", err)
+	}
+
+	if err := v.Verify(r, "host1"); err != ErrRegistrationReplayed {
+		t.Fatalf("Replayed registration should be refused, got: %v", err)
+	}
+
+	if err := v.Verify(newRegistrationRequest(t, "bootstrap", "host1"), "host1"); err != ErrRegistrationSignature {
+		t.Fatalf("Bootstrap token should not be accepted for a host having a key, got: %v", err)
+	}
+
+	if err := v.Verify(newRegistrationRequest(t, "bootstrap", "host2"), "host2"); err != nil {
+		t.Fatalf("Registration with a bootstrap token refused: %s", err)
+	}
+
+	if err := v.Verify(newRegistrationRequest(t, "key1", "host1"), "host2"); err != ErrRegistrationSignature {
+		t.Fatalf("Registration signed for another host should be refused, got: %v", err)
+	}
+
+	r = newRegistrationRequest(t, "bootstrap", "host3")
+	r.Header.Set("X-Registration-Timestamp", "0")
+	if err := v.Verify(r, "host3"); err != ErrRegistrationExpired {
+		t.Fatalf("Expired registration should be refused, got: %v", err)
+	}
+
+	if err := v.Verify(&auth.AuthenticatedRequest{Request: http.Request{Header: http.Header{}, URL: &url.URL{}}}, "host3"); err != ErrRegistrationNotSigned {
+		t.Fatalf("Unsigned registration should be refused, got: %v", err)
+	}
+}
@@ -44,6 +44,7 @@ import (
 	gcontext "github.com/gorilla/context"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
@@ -87,11 +88,15 @@ type Server struct {
 	Addr        string
 	Port        int
 	Auth        AuthenticationBackend
+	tokenAuth   *TokenAuthenticationBackend
 	lock        sync.Mutex
 	listener    net.Listener
+	unixSocket  string
+	unixLn      net.Listener
 	CnxType     ConnectionType
 	wg          sync.WaitGroup
 	extraAssets map[string]ExtraAsset
+	uiPlugins   map[string]UIPlugin
 }
 
 func copyRequestVars(old, new *http.Request) {
@@ -117,9 +122,9 @@ func (s *Server) RegisterRoutes(routes []Route) {
 }
 
 func (s *Server) Listen() error {
-	listenAddrPort := fmt.Sprintf("%s:%d", s.Addr, s.Port)
+	listenAddrPort := common.JoinHostPort(s.Addr, s.Port)
 	socketType := "TCP"
-	ln, err := net.Listen("tcp", listenAddrPort)
+	ln, err := net.Listen(common.IPNetwork("tcp"), listenAddrPort)
 	if err != nil {
 		return fmt.Errorf("Failed to listen on %s:%d: %s", s.Addr, s.Port, err.Error())
 	}
@@ -138,10 +143,38 @@ func (s *Server) Listen() error {
 		if err != nil {
 			return err
 		}
+
+		profile, err := config.GetTLSProfile()
+		if err != nil {
+			return err
+		}
+		profile.Apply(tlsConfig)
+		logging.GetLogger().Debugf("Using TLS profile %s on %s:%d", profile.Name, s.Addr, s.Port)
+
+		// HTTP/2 is negotiated through TLS ALPN, WebSocket clients keep using HTTP/1.1
+		if config.GetBool("http.http2.enabled") {
+			if err := http2.ConfigureServer(&s.Server, nil); err != nil {
+				return fmt.Errorf("Failed to enable HTTP/2 on %s:%d: %s", s.Addr, s.Port, err.Error())
+			}
+			tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+			socketType += " (HTTP/2)"
+		}
+
 		s.listener = tls.NewListener(ln.(*net.TCPListener), tlsConfig)
 	}
 
 	logging.GetLogger().Infof("Listening on %s socket %s:%d", socketType, s.Addr, s.Port)
+
+	if s.unixSocket != "" {
+		if s.unixLn, err = listenUnix(s.unixSocket); err != nil {
+			s.listener.Close()
+			return fmt.Errorf("Failed to listen on %s: %s", s.unixSocket, err.Error())
+		}
+		s.Server.ConnContext = unixConnContext
+
+		logging.GetLogger().Infof("Listening on Unix socket %s", s.unixSocket)
+	}
+
 	return nil
 }
 
@@ -157,7 +190,21 @@ func (s *Server) Serve() {
 	defer s.wg.Done()
 	s.wg.Add(1)
 
-	s.Handler = handlers.CompressHandler(s.Router)
+	// responses are compressed when the client accepts it through Accept-Encoding
+	s.Handler = s.Router
+	if config.GetBool("http.compression.enabled") {
+		s.Handler = handlers.CompressHandlerLevel(s.Router, config.GetInt("http.compression.level"))
+	}
+	if s.unixLn != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := s.Server.Serve(s.unixLn); err != nil && err != http.ErrServerClosed {
+				logging.GetLogger().Errorf("Failed to Serve on %s: %s", s.unixSocket, err.Error())
+			}
+		}()
+	}
+
 	if err := s.Server.Serve(s.listener); err != nil {
 		if err == http.ErrServerClosed {
 			return
@@ -173,6 +220,12 @@ func (s *Server) Stop() {
 		logging.GetLogger().Error("Shutdown error :", err.Error())
 	}
 	s.listener.Close()
+	if s.unixLn != nil {
+		s.unixLn.Close()
+		if !strings.HasPrefix(s.unixSocket, "@") {
+			os.Remove(s.unixSocket)
+		}
+	}
 	s.wg.Wait()
 }
 
@@ -220,10 +273,12 @@ func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
 	data := struct {
 		ExtraAssets map[string]ExtraAsset
 		UIConfig    interface{}
+		UIPlugins   []UIPlugin
 		Permissions [][]string
 	}{
 		ExtraAssets: s.extraAssets,
 		UIConfig:    config.Get("ui"),
+		UIPlugins:   s.UIPlugins(),
 		Permissions: rbac.GetPermissionsForUser("admin"),
 	}
 
@@ -278,6 +333,12 @@ func unauthorized(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("401 Unauthorized\n"))
 }
 
+// SetTokenValidator sets the validator of the API tokens sent as bearer
+// tokens in the Authorization header
+func (s *Server) SetTokenValidator(validator TokenValidator) {
+	s.tokenAuth.SetValidator(validator)
+}
+
 func (s *Server) HandleFunc(path string, f auth.AuthenticatedHandlerFunc) {
 	s.Router.HandleFunc(path, s.Auth.Wrap(f))
 }
@@ -328,14 +389,18 @@ func NewServer(host string, serviceType common.ServiceType, addr string, port in
 	router := mux.NewRouter().StrictSlash(true)
 	router.Headers("X-Host-ID", host, "X-Service-Type", serviceType.String())
 
+	tokenAuth := NewTokenAuthenticationBackend(auth)
+
 	server := &Server{
 		Host:        host,
 		ServiceType: serviceType,
 		Router:      router,
 		Addr:        addr,
 		Port:        port,
-		Auth:        auth,
+		Auth:        tokenAuth,
+		tokenAuth:   tokenAuth,
 		extraAssets: make(map[string]ExtraAsset),
+		uiPlugins:   make(map[string]UIPlugin),
 	}
 
 	if assetsFolder != "" {
@@ -344,6 +409,7 @@ func NewServer(host string, serviceType common.ServiceType, addr string, port in
 
 	router.PathPrefix("/statics").HandlerFunc(server.serveStatics)
 	router.PathPrefix(ExtraAssetPrefix).HandlerFunc(server.serveStatics)
+	router.PathPrefix(UIPluginPrefix).HandlerFunc(server.serveStatics)
 	router.HandleFunc("/login", server.serveLogin)
 	router.PathPrefix("/topology").HandlerFunc(server.serveIndex)
 	router.PathPrefix("/conversation").HandlerFunc(server.serveIndex)
@@ -368,5 +434,27 @@ func NewServerFromConfig(serviceType common.ServiceType) (*Server, error) {
 	host := config.GetString("host_id")
 	assets := config.GetString("ui.extra_assets")
 
-	return NewServer(host, serviceType, sa.Addr, sa.Port, auth, assets), nil
+	server := NewServer(host, serviceType, sa.Addr, sa.Port, auth, assets)
+
+	for _, folder := range config.GetStringSlice("ui.plugins") {
+		plugin, err := LoadUIPlugin(folder)
+		if err == nil {
+			err = server.RegisterUIPlugin(plugin)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load UI plugin %s: %s", folder, err)
+		}
+	}
+
+	// local clients can use a Unix socket, authenticated by their user ID
+	if path := config.GetString(serviceType.String() + ".unix_socket.path"); path != "" {
+		unixAuth, err := NewUnixSocketAuthenticationBackend(server.Auth, config.GetStringMapString(serviceType.String()+".unix_socket.users"))
+		if err != nil {
+			return nil, err
+		}
+		server.Auth = unixAuth
+		server.unixSocket = path
+	}
+
+	return server, nil
 }
@@ -55,6 +55,12 @@ func getTLSConfig(setupRootCA bool) (*tls.Config, error) {
 			}
 		}
 		checkTLSConfig(tlsConfig)
+
+		profile, err := config.GetTLSProfile()
+		if err != nil {
+			return nil, err
+		}
+		profile.Apply(tlsConfig)
 	}
 	return tlsConfig, nil
 }
@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/context"
+)
+
+// TokenValidator checks an API token and returns the name of the identity
+// it was issued for
+type TokenValidator interface {
+	ValidateToken(token string) (string, error)
+}
+
+// TokenAuthenticationBackend authenticates the requests carrying an API token
+// in their Authorization header with a TokenValidator, other requests are
+// handed over to the wrapped authentication backend
+type TokenAuthenticationBackend struct {
+	sync.RWMutex
+	AuthenticationBackend
+	validator TokenValidator
+}
+
+func bearerToken(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):])
+	}
+	return ""
+}
+
+// SetValidator sets the validator used to check API tokens
+func (b *TokenAuthenticationBackend) SetValidator(validator TokenValidator) {
+	b.Lock()
+	b.validator = validator
+	b.Unlock()
+}
+
+// Wrap an authenticated handler
+func (b *TokenAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
+	fallback := b.AuthenticationBackend.Wrap(wrapped)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			fallback(w, r)
+			return
+		}
+
+		b.RLock()
+		validator := b.validator
+		b.RUnlock()
+
+		if validator == nil {
+			unauthorized(w, r)
+			return
+		}
+
+		setTLSHeader(w, r)
+
+		username, err := validator.ValidateToken(token)
+		if err != nil {
+			unauthorized(w, r)
+			return
+		}
+
+		// the token must not reach the wrapped backend
+		r.Header.Del("Authorization")
+
+		ar := &auth.AuthenticatedRequest{Request: *r, Username: username}
+		copyRequestVars(r, &ar.Request)
+		wrapped(w, ar)
+		context.Clear(&ar.Request)
+	}
+}
+
+// NewTokenAuthenticationBackend returns a new token authentication backend
+// falling back to the given backend when no token is provided
+func NewTokenAuthenticationBackend(backend AuthenticationBackend) *TokenAuthenticationBackend {
+	return &TokenAuthenticationBackend{AuthenticationBackend: backend}
+}
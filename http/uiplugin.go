@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+// UIPluginPrefix is the path the assets of the WebUI plugins are served under
+const UIPluginPrefix = "/ui-plugins"
+
+// uiPluginManifest is the file describing a plugin loaded from a folder
+const uiPluginManifest = "plugin.json"
+
+// UINodeAction is an action added to the metadata panel of the nodes
+// matching Match in the WebUI. Either Handler, the name of a function
+// registered by the plugin bundle with skydivePlugins.registerHandler, is
+// called with the node, or URL is requested with Method, GET opening it in a
+// new window. The {{ID}} and {{Metadata.<key>}} placeholders of the URL are
+// replaced by the values of the node.
+type UINodeAction struct {
+	Name    string
+	Title   string
+	Icon    string                 `json:",omitempty"`
+	Match   map[string]interface{} `json:",omitempty"`
+	Handler string                 `json:",omitempty"`
+	URL     string                 `json:",omitempty"`
+	Method  string                 `json:",omitempty"`
+}
+
+// UIMetadataRenderer renders the metadata value at Key, dotted for the nested
+// ones, in the WebUI with the Handler function registered by the plugin
+// bundle, called with the value and returning HTML
+type UIMetadataRenderer struct {
+	Key     string
+	Handler string
+}
+
+// UIPlugin describes a WebUI plugin, the JavaScript and CSS files of its
+// bundle being added to the WebUI
+type UIPlugin struct {
+	Name              string
+	Assets            map[string][]byte `json:"-"`
+	NodeActions       []UINodeAction
+	MetadataRenderers []UIMetadataRenderer
+}
+
+// RegisterUIPlugin adds a plugin to the WebUI, its assets being served under
+// UIPluginPrefix/<name>/. It has to be called before the server is started.
+func (s *Server) RegisterUIPlugin(p UIPlugin) error {
+	if p.Name == "" || p.Name != path.Base(p.Name) {
+		return fmt.Errorf("Invalid UI plugin name '%s'", p.Name)
+	}
+	if _, ok := s.uiPlugins[p.Name]; ok {
+		return fmt.Errorf("UI plugin %s already registered", p.Name)
+	}
+
+	for i, action := range p.NodeActions {
+		if (action.Handler == "") == (action.URL == "") {
+			return fmt.Errorf("Node action %d of UI plugin %s has to define either a handler or an URL", i, p.Name)
+		}
+	}
+
+	for file, content := range p.Assets {
+		filename := path.Join(UIPluginPrefix, p.Name, file)
+		s.extraAssets[filename[1:]] = ExtraAsset{
+			Filename: filename,
+			Ext:      filepath.Ext(file),
+			Content:  content,
+		}
+	}
+
+	s.uiPlugins[p.Name] = p
+	logging.GetLogger().Infof("UI plugin %s registered", p.Name)
+	return nil
+}
+
+// UIPlugins returns the registered WebUI plugins
+func (s *Server) UIPlugins() []UIPlugin {
+	plugins := make([]UIPlugin, 0, len(s.uiPlugins))
+	for _, p := range s.uiPlugins {
+		plugins = append(plugins, p)
+	}
+	return plugins
+}
+
+// LoadUIPlugin reads a WebUI plugin from a folder holding its plugin.json
+// manifest, the other files being the assets of its bundle. The name of the
+// plugin defaults to the one of the folder.
+func LoadUIPlugin(folder string) (p UIPlugin, err error) {
+	manifest, err := ioutil.ReadFile(filepath.Join(folder, uiPluginManifest))
+	if err != nil {
+		return p, err
+	}
+
+	if err = json.Unmarshal(manifest, &p); err != nil {
+		return p, fmt.Errorf("Invalid manifest of UI plugin %s: %s", folder, err)
+	}
+	if p.Name == "" {
+		p.Name = filepath.Base(folder)
+	}
+
+	p.Assets = make(map[string][]byte)
+	err = filepath.Walk(folder, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() == uiPluginManifest {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(folder, file)
+		if err != nil {
+			return err
+		}
+		p.Assets[filepath.ToSlash(rel)] = content
+		return nil
+	})
+
+	return p, err
+}
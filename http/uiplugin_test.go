@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+func TestUIPlugin(t *testing.T) {
+	folder, err := ioutil.TempDir("", "skydive-ui-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	manifest := `{"NodeActions": [{"Name": "scan", "Match": {"Type": "host"}, "Handler": "scan"}]}`
+	if err := ioutil.WriteFile(filepath.Join(folder, "plugin.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(folder, "security.js"), []byte("var security;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadUIPlugin(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != filepath.Base(folder) || len(p.NodeActions) != 1 || len(p.Assets) != 1 {
+		t.Fatalf("Unexpected plugin %+v", p)
+	}
+
+	s := NewServer("host", common.AnalyzerService, "127.0.0.1", 0, NewNoAuthenticationBackend(), "")
+	if err := s.RegisterUIPlugin(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterUIPlugin(p); err == nil {
+		t.Error("Expected an error when registering a plugin twice")
+	}
+
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, httptest.NewRequest("GET", UIPluginPrefix+"/"+p.Name+"/security.js", nil))
+	if w.Code != 200 || w.Body.String() != "var security;" {
+		t.Errorf("Expected the plugin script to be served, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := s.RegisterUIPlugin(UIPlugin{Name: "invalid", NodeActions: []UINodeAction{{Name: "none"}}}); err == nil {
+		t.Error("Expected an error for an action without handler nor URL")
+	}
+}
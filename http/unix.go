@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abbot/go-http-auth"
+	gcontext "github.com/gorilla/context"
+)
+
+// UnixCredentials holds the credentials of the peer of a Unix socket
+type UnixCredentials struct {
+	PID int
+	UID int
+	GID int
+}
+
+type unixCredentialsKey struct{}
+
+// unixConnContext attaches the peer credentials of the Unix socket
+// connections to the context of their requests
+func unixConnContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	creds, err := peerCredentials(uc)
+	if err != nil {
+		// keep the marker so that the request is rejected
+		creds = nil
+	}
+	return context.WithValue(ctx, unixCredentialsKey{}, creds)
+}
+
+// UnixSocketAuthenticationBackend authenticates the requests received on a
+// Unix socket with the credentials of the peer process, mapping its user ID
+// to a username. Other requests are handed over to the wrapped backend.
+type UnixSocketAuthenticationBackend struct {
+	AuthenticationBackend
+	users map[int]string
+}
+
+// Wrap an authenticated handler
+func (b *UnixSocketAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
+	fallback := b.AuthenticationBackend.Wrap(wrapped)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := r.Context().Value(unixCredentialsKey{})
+		if value == nil {
+			fallback(w, r)
+			return
+		}
+
+		creds, _ := value.(*UnixCredentials)
+		if creds == nil {
+			unauthorized(w, r)
+			return
+		}
+
+		username, ok := b.users[creds.UID]
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		ar := &auth.AuthenticatedRequest{Request: *r, Username: username}
+		copyRequestVars(r, &ar.Request)
+		wrapped(w, ar)
+		gcontext.Clear(&ar.Request)
+	}
+}
+
+// NewUnixSocketAuthenticationBackend returns a new Unix socket authentication
+// backend, users maps user IDs, as strings, to usernames
+func NewUnixSocketAuthenticationBackend(backend AuthenticationBackend, users map[string]string) (*UnixSocketAuthenticationBackend, error) {
+	b := &UnixSocketAuthenticationBackend{
+		AuthenticationBackend: backend,
+		users:                 make(map[int]string),
+	}
+
+	for uid, username := range users {
+		id, err := strconv.Atoi(uid)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid user ID for the Unix socket: %s", uid)
+		}
+		b.users[id] = username
+	}
+
+	return b, nil
+}
+
+// listenUnix listens on a Unix socket, a path starting with @ designates an
+// abstract socket
+func listenUnix(path string) (net.Listener, error) {
+	if !strings.HasPrefix(path, "@") {
+		// remove the socket left by a previous run
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+	}
+
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abbot/go-http-auth"
+)
+
+func TestUnixSocketAuthentication(t *testing.T) {
+	backend, err := NewUnixSocketAuthenticationBackend(NewNoAuthenticationBackend(), map[string]string{"1000": "operator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var username string
+	handler := backend.Wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+		username = r.Username
+	})
+
+	serve := func(creds *UnixCredentials, unix bool) int {
+		username = ""
+		r := httptest.NewRequest("GET", "/api", nil)
+		if unix {
+			r = r.WithContext(context.WithValue(r.Context(), unixCredentialsKey{}, creds))
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	if serve(&UnixCredentials{UID: 1000}, true); username != "operator" {
+		t.Errorf("Expected operator, got '%s'", username)
+	}
+
+	if code := serve(&UnixCredentials{UID: 1001}, true); code != http.StatusUnauthorized || username != "" {
+		t.Errorf("Unknown user ID should be refused, got %d", code)
+	}
+
+	if code := serve(nil, true); code != http.StatusUnauthorized {
+		t.Errorf("Missing credentials should be refused, got %d", code)
+	}
+
+	if serve(nil, false); username != "admin" {
+		t.Errorf("TCP requests should use the wrapped backend, got '%s'", username)
+	}
+
+	if _, err := NewUnixSocketAuthenticationBackend(NewNoAuthenticationBackend(), map[string]string{"root": "admin"}); err == nil {
+		t.Error("Invalid user ID should return an error")
+	}
+}
@@ -26,6 +26,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -35,6 +37,7 @@ import (
 
 	auth "github.com/abbot/go-http-auth"
 	"github.com/gorilla/websocket"
+	"github.com/juju/ratelimit"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
@@ -69,6 +72,7 @@ type WSSpeaker interface {
 	GetClientProtocol() string
 	GetHeaders() http.Header
 	GetURL() *url.URL
+	GetClockOffset() time.Duration
 	IsConnected() bool
 	SendMessage(m WSMessage) error
 	Connect()
@@ -90,6 +94,10 @@ type WSConnStatus struct {
 	Url            *url.URL     `json:"-"`
 	headers        http.Header
 	ConnectTime    time.Time
+	SessionToken   string `json:"-"`
+	Resumed        bool
+	ClockOffset    time.Duration
+	RoundTripTime  time.Duration
 }
 
 func (s *WSConnState) MarshalJSON() ([]byte, error) {
@@ -130,7 +138,17 @@ type WSConn struct {
 	running       atomic.Value
 	pingTicker    *time.Ticker // only used by incoming connections
 	eventHandlers []WSSpeakerEventHandler
-	wsSpeaker     WSSpeaker // speaker owning the connection
+	wsSpeaker     WSSpeaker         // speaker owning the connection
+	limiter       *ratelimit.Bucket // only used by incoming connections
+	rejected      int
+	lastRejection time.Time
+	clockSamples  []clockSample // only used by incoming connections
+}
+
+// WSRateLimitStatus is sent to a client whose messages were rejected because
+// of the rate limit
+type WSRateLimitStatus struct {
+	Rejected int
 }
 
 // wsIncomingClient is only used internally to handle incoming client. It embeds a WSConn.
@@ -142,8 +160,9 @@ type wsIncomingClient struct {
 // It embeds a WSConn.
 type WSClient struct {
 	*WSConn
-	Path       string
-	AuthClient *AuthenticationClient
+	Path            string
+	AuthClient      *AuthenticationClient
+	RegistrationKey string
 }
 
 // WSSpeakerEventHandler is the interface to be implement by the client events listeners.
@@ -297,7 +316,10 @@ func (c *WSConn) run() {
 	}()
 
 	done := make(chan bool, 2)
+	writerDone := make(chan bool)
 	go func() {
+		defer close(writerDone)
+
 		for {
 			select {
 			case m := <-c.send:
@@ -313,33 +335,96 @@ func (c *WSConn) run() {
 					c.quit <- true
 				}
 			case <-done:
+				// the disconnection has been requested, flush the pending
+				// messages before closing the connection
+				if c.running.Load() == false {
+					c.flush()
+				}
 				return
 			}
 		}
 	}()
 	defer func() {
 		done <- true
+		<-writerDone
 	}()
 
 	for {
 		select {
 		case <-c.quit:
+			// dispatch the messages already received before leaving
+			for len(c.read) > 0 {
+				c.dispatch(<-c.read)
+			}
 			return
 		case m := <-c.read:
-			c.RLock()
-			for _, l := range c.eventHandlers {
-				l.OnMessage(c.wsSpeaker, WSRawMessage(m))
+			if c.allow() {
+				c.dispatch(m)
 			}
-			c.RUnlock()
+		}
+	}
+}
+
+// allow returns whether a received message can be dispatched according to
+// the rate limit. The client is notified of the rejected messages at most
+// once per second.
+func (c *WSConn) allow() bool {
+	if c.limiter == nil || c.limiter.TakeAvailable(1) == 1 {
+		return true
+	}
+
+	c.rejected++
+	if time.Since(c.lastRejection) >= time.Second {
+		logging.GetLogger().Warningf("Rate limit exceeded by %s, %d messages rejected", c.GetHost(), c.rejected)
+
+		msg := NewWSStructMessage(StatusNamespace, "RateLimited", WSRateLimitStatus{Rejected: c.rejected})
+		msg.Status = int64(http.StatusTooManyRequests)
+
+		select {
+		case c.send <- msg.Bytes(c.GetClientProtocol()):
+		default:
+		}
+
+		c.rejected = 0
+		c.lastRejection = time.Now()
+	}
+
+	return false
+}
+
+func (c *WSConn) dispatch(m []byte) {
+	c.RLock()
+	for _, l := range c.eventHandlers {
+		l.OnMessage(c.wsSpeaker, WSRawMessage(m))
+	}
+	c.RUnlock()
+}
+
+// flush writes the messages pending in the sending queue and notifies the
+// remote end that the connection is going to be closed.
+func (c *WSConn) flush() {
+	for {
+		select {
+		case m := <-c.send:
+			if err := c.write(m); err != nil {
+				logging.GetLogger().Errorf("Error while flushing the WebSocket: %s", err)
+				return
+			}
+		default:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
 		}
 	}
 }
 
 // sendPing is used for remote connections by the server to send PingMessage
-// to remote client.
+// to remote client. The ping carries the time it is sent at so that the pong
+// measures the clock offset of the client.
 func (c *WSConn) sendPing() error {
-	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-	return c.conn.WriteMessage(websocket.PingMessage, []byte{})
+	now := time.Now()
+	c.conn.SetWriteDeadline(now.Add(writeWait))
+	return c.conn.WriteMessage(websocket.PingMessage, pingPayload(now))
 }
 
 // AddEventHandler registers a new event handler
@@ -396,7 +481,9 @@ func (c *WSClient) scheme() string {
 	return "ws://"
 }
 
-func (c *WSClient) connect() {
+// connect establishes the connection and serves it until it gets closed. It
+// returns whether the connection succeeded.
+func (c *WSClient) connect() bool {
 	var err error
 	endpoint := c.Url.String()
 	headers := http.Header{
@@ -407,38 +494,79 @@ func (c *WSClient) connect() {
 		"X-Websocket-Namespace": {WildcardNamespace},
 	}
 
+	// ask the server to resume the previous session
+	c.RLock()
+	if c.SessionToken != "" {
+		headers.Set("X-Session-Token", c.SessionToken)
+	}
+	c.RUnlock()
+
 	if c.AuthClient != nil {
 		if err = c.AuthClient.Authenticate(); err != nil {
 			logging.GetLogger().Errorf("Unable to authenticate %s : %s", endpoint, err)
-			return
+			return false
 		}
 	}
 
 	setCookies(&headers, c.AuthClient)
+	setAuthorization(&headers, c.AuthClient)
+
+	if c.RegistrationKey != "" {
+		if err = signRegistration(headers, c.RegistrationKey, c.Host, c.Url.Path); err != nil {
+			logging.GetLogger().Errorf("Unable to sign the registration to %s : %s", endpoint, err)
+			return false
+		}
+	}
+
+	p, err := getClientProxy()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to create a WebSocket connection %s : %s", endpoint, err)
+		return false
+	}
 
 	d := websocket.Dialer{
-		Proxy:           http.ProxyFromEnvironment,
+		Proxy:           p.proxy,
+		NetDial:         p.dial,
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
 	d.TLSClientConfig, err = getTLSConfig(false)
 	if err != nil {
 		logging.GetLogger().Errorf("Unable to create a WebSocket connection %s : %s", endpoint, err)
-		return
+		return false
 	}
-	c.conn, _, err = d.Dial(endpoint, headers)
+
+	var resp *http.Response
+	c.conn, resp, err = d.Dial(endpoint, headers)
 
 	if err != nil {
 		logging.GetLogger().Errorf("Unable to create a WebSocket connection %s : %s", endpoint, err)
-		return
+		return false
 	}
-	c.conn.SetPingHandler(nil)
+
+	c.Lock()
+	c.SessionToken = resp.Header.Get("X-Session-Token")
+	c.Resumed = resp.Header.Get("X-Session-Resumed") == "true"
+	c.Unlock()
+	c.conn.SetPingHandler(func(data string) error {
+		err := c.conn.WriteControl(websocket.PongMessage, pongPayload(data, time.Now()), time.Now().Add(writeWait))
+		if err == websocket.ErrCloseSent {
+			return nil
+		} else if e, ok := err.(net.Error); ok && e.Temporary() {
+			return nil
+		}
+		return err
+	})
 	c.conn.EnableWriteCompression(config.GetBool("http.ws.enable_write_compression"))
 
 	atomic.StoreInt32((*int32)(c.State), common.RunningState)
 	defer atomic.StoreInt32((*int32)(c.State), common.StoppedState)
 
-	logging.GetLogger().Infof("Connected to %s", endpoint)
+	if c.Resumed {
+		logging.GetLogger().Infof("Connected to %s, session resumed", endpoint)
+	} else {
+		logging.GetLogger().Infof("Connected to %s", endpoint)
+	}
 
 	// notify connected
 	c.RLock()
@@ -449,20 +577,47 @@ func (c *WSClient) connect() {
 	for _, l := range eventHandlers {
 		l.OnConnected(c)
 		if !c.IsConnected() {
-			return
+			return true
 		}
 	}
 
 	c.wg.Add(1)
 	c.run()
+
+	return true
 }
 
-// Connect to the server - and reconnect if necessary
+// reconnectDelay returns a random delay between the half and the totality of
+// the backoff so that the clients don't reconnect all at once
+func reconnectDelay(backoff time.Duration) time.Duration {
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Connect to the server - and reconnect if necessary. The delay between two
+// attempts grows exponentially up to http.ws.backoff_max.
 func (c *WSClient) Connect() {
+	minBackoff := time.Duration(config.GetInt("http.ws.backoff_min")) * time.Second
+	maxBackoff := time.Duration(config.GetInt("http.ws.backoff_max")) * time.Second
+	if minBackoff <= 0 {
+		minBackoff = time.Second
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+
 	go func() {
+		backoff := minBackoff
 		for c.running.Load() == true {
-			c.connect()
-			time.Sleep(1 * time.Second)
+			if c.connect() {
+				backoff = minBackoff
+			}
+
+			time.Sleep(reconnectDelay(backoff))
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
 		}
 	}()
 }
@@ -513,11 +668,20 @@ func newIncomingWSClient(conn *websocket.Conn, r *auth.AuthenticatedRequest) *ws
 
 	conn.SetReadLimit(maxMessageSize)
 	conn.SetReadDeadline(time.Now().Add(pongTimeout))
-	conn.SetPongHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(data string) error {
+		now := time.Now()
+		conn.SetReadDeadline(now.Add(pongTimeout))
+		if sample, ok := parsePong(data, now); ok {
+			wsconn.addClockSample(sample)
+		}
 		return nil
 	})
 
+	if session, ok := r.Context().Value(wsSessionKey{}).(wsSession); ok {
+		wsconn.SessionToken = session.token
+		wsconn.Resumed = session.resumed
+	}
+
 	c := &wsIncomingClient{
 		WSConn: wsconn,
 	}
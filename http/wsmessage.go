@@ -43,8 +43,10 @@ import (
 const (
 	// WildcardNamespace is the namespace used as wildcard. It is used by listeners to filter callbacks.
 	WildcardNamespace = "*"
-	ProtobufProtocol  = "protobuf"
-	JsonProtocol      = "json"
+	// StatusNamespace is the namespace of the messages reporting the connection status to the client.
+	StatusNamespace  = "Status"
+	ProtobufProtocol = "protobuf"
+	JsonProtocol     = "json"
 )
 
 // DefaultRequestTimeout default timeout used for Request/Reply JSON message.
@@ -56,6 +58,7 @@ type WSStructMessageJSON struct {
 	UUID      string
 	Status    int64
 	Obj       *json.RawMessage
+	Sequence  int64 `json:",omitempty"`
 }
 
 type WSStructMessage struct {
@@ -64,6 +67,7 @@ type WSStructMessage struct {
 	Type      string
 	UUID      string
 	Status    int64
+	Sequence  int64 // optional, used by the protocols acknowledging the messages
 	value     interface{}
 
 	JsonObj            *json.RawMessage
@@ -113,6 +117,7 @@ func (g WSStructMessage) Bytes(protocol string) []byte {
 			UUID:      g.UUID,
 			Status:    g.Status,
 			Obj:       g.ProtobufObj,
+			Sequence:  g.Sequence,
 		}
 		g.protobufSerialized = msgProto.Marshal()
 		return g.protobufSerialized
@@ -128,6 +133,7 @@ func (g WSStructMessage) Bytes(protocol string) []byte {
 		UUID:      g.UUID,
 		Status:    g.Status,
 		Obj:       g.JsonObj,
+		Sequence:  g.Sequence,
 	}
 	g.jsonSerialized = msgJSON.Marshal()
 	return g.jsonSerialized
@@ -377,6 +383,7 @@ func (s *WSStructSpeaker) OnMessage(c WSSpeaker, m WSMessage) {
 			msg.Type = mProtobuf.Type
 			msg.UUID = mProtobuf.UUID
 			msg.Status = mProtobuf.Status
+			msg.Sequence = mProtobuf.Sequence
 			msg.ProtobufObj = mProtobuf.Obj
 		} else {
 			mJSON := WSStructMessageJSON{}
@@ -390,6 +397,7 @@ func (s *WSStructSpeaker) OnMessage(c WSSpeaker, m WSMessage) {
 			msg.Type = mJSON.Type
 			msg.UUID = mJSON.UUID
 			msg.Status = mJSON.Status
+			msg.Sequence = mJSON.Sequence
 			msg.JsonObj = mJSON.Obj
 		}
 		s.wsStructSpeakerEventDispatcher.dispatchMessage(c, &msg)
@@ -502,7 +510,7 @@ func (s *WSStructServer) OnDisconnected(c WSSpeaker) {
 // NewWSStructServer returns a new WSStructServer
 func NewWSStructServer(server *WSServer) *WSStructServer {
 	s := &WSStructServer{
-		WSServer: server,
+		WSServer:                           server,
 		wsStructSpeakerPoolEventDispatcher: newWSStructSpeakerPoolEventDispatcher(server),
 	}
 
@@ -514,7 +522,7 @@ func NewWSStructServer(server *WSServer) *WSStructServer {
 	s.WSServer.incomerHandler = func(conn *websocket.Conn, r *auth.AuthenticatedRequest) WSSpeaker {
 		// the default incomer handler creates a standard wsIncomerClient that we upgrade to a WSStructSpeaker
 		// being able to handle the StructMessage
-		c := defaultIncomerHandler(conn, r).upgradeToWSStructSpeaker()
+		c := defaultIncomerHandler(conn, r, s.WSServer.getRateLimit()).upgradeToWSStructSpeaker()
 
 		// from headers
 		if namespaces, ok := r.Header["X-Websocket-Namespace"]; ok {
@@ -23,13 +23,19 @@
 package http
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/abbot/go-http-auth"
 	"github.com/gorilla/websocket"
+	"github.com/juju/ratelimit"
+	"github.com/nu7hatch/gouuid"
 
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/rbac"
 )
@@ -37,11 +43,59 @@ import (
 // WSIncomerHandler incoming client handler interface.
 type WSIncomerHandler func(*websocket.Conn, *auth.AuthenticatedRequest) WSSpeaker
 
+// WSRateLimit describes the limits enforced on the clients of a WSServer. Zero
+// values mean unlimited.
+type WSRateLimit struct {
+	Rate           int64 // messages per second accepted from a client
+	Burst          int64 // messages accepted at once above the rate
+	MaxConnections int   // concurrent connections of a same client
+}
+
 // WSServer implements a websocket server. It owns a WSPool of incoming WSSpeakers.
 type WSServer struct {
 	common.RWMutex
 	*wsIncomerPool
 	incomerHandler WSIncomerHandler
+	rateLimit      WSRateLimit
+	clientConns    map[string]int
+	sessions       map[string]wsClosedSession
+	resumeDelay    time.Duration
+	verifier       *RegistrationVerifier
+}
+
+// wsSession holds the session token issued to an incoming connection and
+// whether it resumed a previous session
+type wsSession struct {
+	token   string
+	resumed bool
+}
+
+type wsSessionKey struct{}
+
+type wsIncomerTrackerKey struct{}
+
+// wsClosedSession is a session that can still be resumed until it expires,
+// its timer removing it then
+type wsClosedSession struct {
+	host   string
+	expire time.Time
+	timer  *time.Timer
+}
+
+// wsIncomerTracker releases the connection quota of a client and keeps its
+// session resumable on disconnection
+type wsIncomerTracker struct {
+	DefaultWSSpeakerEventHandler
+	server *WSServer
+	client string
+	host   string
+	token  string
+}
+
+// OnDisconnected event
+func (t *wsIncomerTracker) OnDisconnected(c WSSpeaker) {
+	t.server.releaseQuota(t.client)
+	t.server.closeSession(t.host, t.token)
 }
 
 func getRequestParameter(r *auth.AuthenticatedRequest, name string) string {
@@ -52,15 +106,125 @@ func getRequestParameter(r *auth.AuthenticatedRequest, name string) string {
 	return param
 }
 
-func defaultIncomerHandler(conn *websocket.Conn, r *auth.AuthenticatedRequest) *wsIncomingClient {
+func defaultIncomerHandler(conn *websocket.Conn, r *auth.AuthenticatedRequest, limit WSRateLimit) *wsIncomingClient {
 	logging.GetLogger().Infof("New WebSocket Connection from %s : URI path %s", conn.RemoteAddr().String(), r.URL.Path)
 
 	c := newIncomingWSClient(conn, r)
+	if limit.Rate > 0 {
+		burst := limit.Burst
+		if burst < limit.Rate {
+			burst = limit.Rate
+		}
+		c.limiter = ratelimit.NewBucketWithRate(float64(limit.Rate), burst)
+	}
+
+	// the tracker is registered before the connection is started so that
+	// the quota of a client disconnecting at once is released
+	if tracker, ok := r.Context().Value(wsIncomerTrackerKey{}).(*wsIncomerTracker); ok {
+		c.AddEventHandler(tracker)
+	}
 	c.start()
 
 	return c
 }
 
+// clientID returns the identifier used to enforce the limits, the username
+// for authenticated clients, the remote address otherwise
+func clientID(r *auth.AuthenticatedRequest) string {
+	if r.Username != "" {
+		return r.Username
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SetRateLimit sets the limits enforced on the clients connecting to the server
+func (s *WSServer) SetRateLimit(limit WSRateLimit) {
+	s.Lock()
+	s.rateLimit = limit
+	s.Unlock()
+}
+
+// SetRegistrationVerifier makes the server only accept the hosts signing
+// their registration with a known key
+func (s *WSServer) SetRegistrationVerifier(verifier *RegistrationVerifier) {
+	s.Lock()
+	s.verifier = verifier
+	s.Unlock()
+}
+
+// SetResumeDelay sets the delay during which a closed session can be resumed
+func (s *WSServer) SetResumeDelay(delay time.Duration) {
+	s.Lock()
+	s.resumeDelay = delay
+	s.Unlock()
+}
+
+func (s *WSServer) getRateLimit() WSRateLimit {
+	s.RLock()
+	defer s.RUnlock()
+	return s.rateLimit
+}
+
+func (s *WSServer) acquireQuota(client string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.rateLimit.MaxConnections > 0 && s.clientConns[client] >= s.rateLimit.MaxConnections {
+		return false
+	}
+	s.clientConns[client]++
+	return true
+}
+
+func (s *WSServer) closeSession(host, token string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.resumeDelay <= 0 {
+		return
+	}
+
+	timer := time.AfterFunc(s.resumeDelay, func() {
+		s.Lock()
+		delete(s.sessions, token)
+		s.Unlock()
+	})
+	s.sessions[token] = wsClosedSession{host: host, expire: time.Now().Add(s.resumeDelay), timer: timer}
+}
+
+// resumeSession returns whether the given token identifies a session of the
+// host closed for less than the resume delay, http.ws.session_resume_delay
+// by default
+func (s *WSServer) resumeSession(host, token string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok || session.host != host {
+		return false
+	}
+
+	if session.timer != nil {
+		session.timer.Stop()
+	}
+	delete(s.sessions, token)
+
+	return time.Now().Before(session.expire)
+}
+
+func (s *WSServer) releaseQuota(client string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.clientConns[client]--; s.clientConns[client] <= 0 {
+		delete(s.clientConns, client)
+	}
+}
+
 func (s *WSServer) serveMessages(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
 	logging.GetLogger().Debugf("Enforcing websocket for %s, %s", s.name, r.Username)
 	if rbac.Enforce(r.Username, "websocket", s.name) == false {
@@ -76,6 +240,19 @@ func (s *WSServer) serveMessages(w http.ResponseWriter, r *auth.AuthenticatedReq
 	}
 	logging.GetLogger().Debugf("Serving messages for client %s for pool %s", host, s.GetName())
 
+	s.RLock()
+	verifier := s.verifier
+	s.RUnlock()
+
+	if verifier != nil {
+		if err := verifier.Verify(r, host); err != nil {
+			logging.GetLogger().Warningf("Registration of %s(%s) on %s refused: %s", r.RemoteAddr, host, s.GetName(), err)
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
 	s.wsIncomerPool.RLock()
 	c := s.GetSpeakerByHost(host)
 	s.wsIncomerPool.RUnlock()
@@ -86,10 +263,34 @@ func (s *WSServer) serveMessages(w http.ResponseWriter, r *auth.AuthenticatedReq
 		return
 	}
 
-	conn, err := websocket.Upgrade(w, &r.Request, nil, 1024, 1024)
+	client := clientID(r)
+	if !s.acquireQuota(client) {
+		logging.GetLogger().Warningf("Too many connections from %s on %s, connection refused", client, s.GetName())
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Maximum number of connections reached"))
+		return
+	}
+
+	u, _ := uuid.NewV4()
+	session := wsSession{token: u.String()}
+	if token := getRequestParameter(r, "X-Session-Token"); token != "" {
+		session.resumed = s.resumeSession(host, token)
+	}
+
+	header := http.Header{"X-Session-Token": {session.token}}
+	if session.resumed {
+		header.Set("X-Session-Resumed", "true")
+	}
+
+	conn, err := websocket.Upgrade(w, &r.Request, header, 1024, 1024)
 	if err != nil {
+		s.releaseQuota(client)
 		return
 	}
+	tracker := &wsIncomerTracker{server: s, client: client, host: host, token: session.token}
+	ctx := context.WithValue(r.Context(), wsSessionKey{}, session)
+	r.Request = *r.Request.WithContext(context.WithValue(ctx, wsIncomerTrackerKey{}, tracker))
 
 	// call the incomerHandler that will create the WSSpeaker
 	c = s.incomerHandler(conn, r)
@@ -105,9 +306,12 @@ func (s *WSServer) serveMessages(w http.ResponseWriter, r *auth.AuthenticatedReq
 func NewWSServer(server *Server, endpoint string) *WSServer {
 	s := &WSServer{
 		wsIncomerPool: newWSIncomerPool(endpoint), // server inherites from a WSSpeaker pool
-		incomerHandler: func(c *websocket.Conn, a *auth.AuthenticatedRequest) WSSpeaker {
-			return defaultIncomerHandler(c, a)
-		},
+		clientConns:   make(map[string]int),
+		sessions:      make(map[string]wsClosedSession),
+		resumeDelay:   time.Duration(config.GetInt("http.ws.session_resume_delay")) * time.Second,
+	}
+	s.incomerHandler = func(c *websocket.Conn, a *auth.AuthenticatedRequest) WSSpeaker {
+		return defaultIncomerHandler(c, a, s.getRateLimit())
 	}
 
 	server.HandleFunc(endpoint, s.serveMessages)
@@ -153,3 +153,74 @@ func TestSubscription(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+func TestWSServerQuota(t *testing.T) {
+	s := &WSServer{clientConns: make(map[string]int)}
+	s.SetRateLimit(WSRateLimit{MaxConnections: 2})
+
+	if !s.acquireQuota("user1") || !s.acquireQuota("user1") {
+		t.Fatal("Connections under the quota should be accepted")
+	}
+
+	if s.acquireQuota("user1") {
+		t.Error("Connection above the quota should be refused")
+	}
+
+	if !s.acquireQuota("user2") {
+		t.Error("Quota should be enforced per client")
+	}
+
+	s.releaseQuota("user1")
+	if !s.acquireQuota("user1") {
+		t.Error("Connection should be accepted once a quota is released")
+	}
+}
+
+func TestWSServerSessionResume(t *testing.T) {
+	s := &WSServer{sessions: make(map[string]wsClosedSession), resumeDelay: time.Minute}
+
+	s.closeSession("host1", "token1")
+
+	if s.resumeSession("host2", "token1") {
+		t.Error("Session should not be resumed by another host")
+	}
+
+	if !s.resumeSession("host1", "token1") {
+		t.Error("Session should be resumed")
+	}
+
+	if s.resumeSession("host1", "token1") {
+		t.Error("Session should be resumed only once")
+	}
+
+	s.resumeDelay = -time.Second
+	s.sessions["token2"] = wsClosedSession{host: "host1", expire: time.Now().Add(-time.Second)}
+	if s.resumeSession("host1", "token2") {
+		t.Error("Expired session should not be resumed")
+	}
+}
+
+func TestWSServerSessionExpire(t *testing.T) {
+	s := &WSServer{sessions: make(map[string]wsClosedSession), resumeDelay: 100 * time.Millisecond}
+
+	s.closeSession("host1", "token1")
+	s.closeSession("host2", "token2")
+
+	// the sessions expire without any resumption attempt
+	err := common.Retry(func() error {
+		s.RLock()
+		defer s.RUnlock()
+
+		if len(s.sessions) != 0 {
+			return fmt.Errorf("Expired sessions should be removed: %v", s.sessions)
+		}
+		return nil
+	}, 10, 100*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if s.resumeSession("host1", "token1") {
+		t.Error("Expired session should not be resumed")
+	}
+}
@@ -54,6 +54,12 @@ type OvsMonitorHandler interface {
 	OnOvsPortUpdate(monitor *OvsMonitor, uuid string, row *libovsdb.RowUpdate)
 }
 
+// OvsMonitorConnectionHandler describes an OVS Monitor connection state interface mechanism
+type OvsMonitorConnectionHandler interface {
+	OnOvsConnected(monitor *OvsMonitor)
+	OnOvsDisconnected(monitor *OvsMonitor, err error)
+}
+
 // OvsMonitor describes an OVS client Monitor
 type OvsMonitor struct {
 	common.RWMutex
@@ -61,6 +67,7 @@ type OvsMonitor struct {
 	Target          string
 	OvsClient       *OvsClient
 	MonitorHandlers []OvsMonitorHandler
+	ConnHandlers    []OvsMonitorConnectionHandler
 	bridgeCache     map[string]string
 	interfaceCache  map[string]string
 	portCache       map[string]string
@@ -80,6 +87,7 @@ type Notifier struct {
 
 // Update OVS notifier tables event
 func (n Notifier) Update(context interface{}, tableUpdates libovsdb.TableUpdates) {
+	n.monitor.recordUpdates(&tableUpdates)
 	n.monitor.updateHandler(&tableUpdates)
 }
 
@@ -100,6 +108,8 @@ func (n Notifier) Disconnected(c *libovsdb.OvsdbClient) {
 	/* trigger re-connection */
 	atomic.StoreUint64(&n.monitor.OvsClient.connected, 0)
 	logging.GetLogger().Warningf("Disconnected from OVSDB")
+
+	n.monitor.notifyDisconnected(errors.New("Disconnected from OVSDB"))
 }
 
 // Exec execute a transaction on the OVS database
@@ -380,6 +390,14 @@ func (o *OvsMonitor) AddMonitorHandler(handler OvsMonitorHandler) {
 	o.MonitorHandlers = append(o.MonitorHandlers, handler)
 }
 
+// AddConnectionHandler registers a handler notified of the OVS database connection state
+func (o *OvsMonitor) AddConnectionHandler(handler OvsMonitorConnectionHandler) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.ConnHandlers = append(o.ConnHandlers, handler)
+}
+
 // ExcludeColumn excludes the given table/column to be monitored. All columns can be
 // excluded using "*" as column name.
 func (o *OvsMonitor) ExcludeColumn(table, column string) {
@@ -401,7 +419,34 @@ func (o *OvsMonitor) IncludeColumn(table, column string) {
 	}
 }
 
+func (o *OvsMonitor) notifyDisconnected(err error) {
+	o.RLock()
+	handlers := o.ConnHandlers
+	o.RUnlock()
+
+	for _, h := range handlers {
+		h.OnOvsDisconnected(o, err)
+	}
+}
+
 func (o *OvsMonitor) monitorOvsdb() error {
+	if err := o.connectOvsdb(); err != nil {
+		o.notifyDisconnected(err)
+		return err
+	}
+
+	o.RLock()
+	handlers := o.ConnHandlers
+	o.RUnlock()
+
+	for _, h := range handlers {
+		h.OnOvsConnected(o)
+	}
+
+	return nil
+}
+
+func (o *OvsMonitor) connectOvsdb() error {
 	ovsdb, err := libovsdb.ConnectUsingProtocol(o.Protocol, o.Target)
 	if err != nil {
 		return err
@@ -436,6 +481,7 @@ func (o *OvsMonitor) monitorOvsdb() error {
 		return err
 	}
 
+	o.recordUpdates(updates)
 	o.updateHandler(updates)
 
 	return nil
@@ -23,9 +23,12 @@
 package ovsdb
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/socketplane/libovsdb"
+
+	"github.com/skydive-project/skydive/probe/replay"
 )
 
 type FakeBridgeHandler struct {
@@ -127,4 +130,50 @@ func TestBridgeDeleted(t *testing.T) {
 	}
 }
 
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func TestBridgeReplay(t *testing.T) {
+	buffer := &bufferCloser{}
+	replay.SetRecorder(replay.NewRecorder(buffer))
+
+	monitor := NewOvsMonitor("tcp", "127.0.0.1:8888")
+	monitor.recordUpdates(getTableUpdates("bridge1", "add"))
+	monitor.recordUpdates(getTableUpdates("bridge2", "add"))
+	monitor.recordUpdates(getTableUpdates("bridge1", "del"))
+
+	replay.SetRecorder(nil)
+
+	events, err := replay.ReadTrace(bytes.NewReader(buffer.Bytes()), ReplaySource)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monitor = NewOvsMonitor("tcp", "127.0.0.1:8888")
+
+	handler := NewFakeBridgeHandler()
+	monitor.AddMonitorHandler(&handler)
+
+	if err := monitor.ReplayTrace(events[:2], "127.0.0.1:8888"); err != nil {
+		t.Fatal(err)
+	}
+
+	if handler.BridgeUUID != "bridge2-uuid" || handler.Added == false || handler.Deleted == true {
+		t.Errorf("Bridge handler not called on replay: %+v", handler)
+	}
+
+	if err := monitor.ReplayTrace(events[2:], ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if handler.BridgeUUID != "bridge1-uuid" || handler.Deleted == false {
+		t.Errorf("Bridge handler not called on replay: %+v", handler)
+	}
+}
+
 /* TODO(safchain) Add UT for interface adding */
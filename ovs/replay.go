@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ovsdb
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/socketplane/libovsdb"
+
+	"github.com/skydive-project/skydive/probe/replay"
+)
+
+// ReplaySource is the source of the OVSDB events in the replay traces
+const ReplaySource = "ovsdb"
+
+// ReplayRow is the recorded form of a row update, the columns being kept in
+// the OVSDB notation so that they are decoded like the live ones
+type ReplayRow struct {
+	Table string
+	UUID  string
+	New   json.RawMessage `json:",omitempty"`
+	Old   json.RawMessage `json:",omitempty"`
+}
+
+// replayTables is the order in which the tables are recorded, the bridges
+// first so that the ports and the interfaces find them
+var replayTables = []string{"Bridge", "Port", "Interface"}
+
+func (o *OvsMonitor) recordUpdates(updates *libovsdb.TableUpdates) {
+	if !replay.Recording() {
+		return
+	}
+
+	var rows []ReplayRow
+	for _, table := range replayTables {
+		tableUpdate, ok := updates.Updates[table]
+		if !ok {
+			continue
+		}
+
+		uuids := make([]string, 0, len(tableUpdate.Rows))
+		for uuid := range tableUpdate.Rows {
+			uuids = append(uuids, uuid)
+		}
+		sort.Strings(uuids)
+
+		for _, uuid := range uuids {
+			row := tableUpdate.Rows[uuid]
+			rr := ReplayRow{Table: table, UUID: uuid}
+			if row.New.Fields != nil {
+				rr.New, _ = json.Marshal(row.New.Fields)
+			}
+			if row.Old.Fields != nil {
+				rr.Old, _ = json.Marshal(row.Old.Fields)
+			}
+			rows = append(rows, rr)
+		}
+	}
+
+	replay.Record(ReplaySource, o.Target, rows)
+}
+
+// ReplayRows applies recorded row updates one at a time in the recorded
+// order, the handlers being called as for live updates. The monitor has not
+// to be started.
+func (o *OvsMonitor) ReplayRows(rows []ReplayRow) error {
+	for _, rr := range rows {
+		var row libovsdb.RowUpdate
+		row.UUID = libovsdb.UUID{GoUUID: rr.UUID}
+		if rr.New != nil {
+			if err := json.Unmarshal(rr.New, &row.New); err != nil {
+				return err
+			}
+		}
+		if rr.Old != nil {
+			if err := json.Unmarshal(rr.Old, &row.Old); err != nil {
+				return err
+			}
+		}
+
+		o.updateHandler(&libovsdb.TableUpdates{
+			Updates: map[string]libovsdb.TableUpdate{
+				rr.Table: {Rows: map[string]libovsdb.RowUpdate{rr.UUID: row}},
+			},
+		})
+	}
+
+	return nil
+}
+
+// ReplayTrace applies the OVSDB events of a trace, only the ones recorded
+// from target if not empty
+func (o *OvsMonitor) ReplayTrace(events []replay.Event, target string) error {
+	for _, event := range events {
+		if event.Source != ReplaySource || (target != "" && event.Key != target) {
+			continue
+		}
+
+		var rows []ReplayRow
+		if err := json.Unmarshal(event.Data, &rows); err != nil {
+			return err
+		}
+
+		if err := o.ReplayRows(rows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -22,7 +22,11 @@
 
 package probe
 
-import "github.com/skydive-project/skydive/common"
+import (
+	"sync/atomic"
+
+	"github.com/skydive-project/skydive/common"
+)
 
 // Probe describes a Probe (topology or flow) mechanism API
 type Probe interface {
@@ -34,6 +38,7 @@ type Probe interface {
 type ProbeBundle struct {
 	common.RWMutex
 	probes map[string]Probe
+	state  int64
 }
 
 // Start a bundle of probes
@@ -44,6 +49,8 @@ func (p *ProbeBundle) Start() {
 	for _, probe := range p.probes {
 		probe.Start()
 	}
+
+	atomic.StoreInt64(&p.state, common.RunningState)
 }
 
 // Stop a bundle of probes
@@ -51,9 +58,18 @@ func (p *ProbeBundle) Stop() {
 	p.RLock()
 	defer p.RUnlock()
 
+	atomic.StoreInt64(&p.state, common.StoppingState)
+
 	for _, probe := range p.probes {
 		probe.Stop()
 	}
+
+	atomic.StoreInt64(&p.state, common.StoppedState)
+}
+
+// IsStarted returns whether all the probes of the bundle have been started
+func (p *ProbeBundle) IsStarted() bool {
+	return atomic.LoadInt64(&p.state) == common.RunningState
 }
 
 // GetProbe retrieve a specific probe name
@@ -91,5 +107,6 @@ func (p *ProbeBundle) AddProbe(name string, probe Probe) {
 func NewProbeBundle(p map[string]Probe) *ProbeBundle {
 	return &ProbeBundle{
 		probes: p,
+		state:  common.StoppedState,
 	}
 }
@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probe
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// DegradationMetadataKey is the metadata key holding the probe degradations of a node
+const DegradationMetadataKey = "Degradations"
+
+// Degradation describes a probe failing to report its part of the topology
+type Degradation struct {
+	Error string
+	Since int64
+	Time  int64
+	Count int64
+}
+
+// DegradationHandler is notified when a probe fails or recovers
+type DegradationHandler interface {
+	OnProbeDegraded(name string, d Degradation)
+	OnProbeRecovered(name string)
+}
+
+type degradationRegistry struct {
+	common.RWMutex
+	degradations map[string]*Degradation
+	handlers     []DegradationHandler
+}
+
+var degradations = &degradationRegistry{
+	degradations: make(map[string]*Degradation),
+}
+
+// ReportError reports that the probe name failed with err. Repeated errors
+// only update the last error, the time and the count of the degradation.
+func ReportError(name string, err error) {
+	now := common.UnixMillis(time.Now())
+
+	degradations.Lock()
+	d, found := degradations.degradations[name]
+	if !found {
+		d = &Degradation{Since: now}
+		degradations.degradations[name] = d
+		logging.GetLogger().Warningf("Probe %s degraded: %s", name, err.Error())
+	}
+	d.Error = err.Error()
+	d.Time = now
+	d.Count++
+	dc := *d
+	handlers := degradations.handlers
+	degradations.Unlock()
+
+	for _, h := range handlers {
+		h.OnProbeDegraded(name, dc)
+	}
+}
+
+// ReportRecovery reports that the probe name is working again
+func ReportRecovery(name string) {
+	degradations.Lock()
+	if _, found := degradations.degradations[name]; !found {
+		degradations.Unlock()
+		return
+	}
+	delete(degradations.degradations, name)
+	handlers := degradations.handlers
+	degradations.Unlock()
+
+	logging.GetLogger().Infof("Probe %s recovered", name)
+
+	for _, h := range handlers {
+		h.OnProbeRecovered(name)
+	}
+}
+
+// Degradations returns the currently degraded probes
+func Degradations() map[string]Degradation {
+	degradations.RLock()
+	defer degradations.RUnlock()
+
+	result := make(map[string]Degradation, len(degradations.degradations))
+	for name, d := range degradations.degradations {
+		result[name] = *d
+	}
+	return result
+}
+
+// AddDegradationHandler registers a new degradation handler, the current
+// degradations are replayed to it
+func AddDegradationHandler(h DegradationHandler) {
+	degradations.Lock()
+	degradations.handlers = append(degradations.handlers, h)
+	current := make(map[string]Degradation, len(degradations.degradations))
+	for name, d := range degradations.degradations {
+		current[name] = *d
+	}
+	degradations.Unlock()
+
+	for name, d := range current {
+		h.OnProbeDegraded(name, d)
+	}
+}
+
+// RemoveDegradationHandler unregisters a degradation handler
+func RemoveDegradationHandler(h DegradationHandler) {
+	degradations.Lock()
+	defer degradations.Unlock()
+
+	for i, el := range degradations.handlers {
+		if el == h {
+			degradations.handlers = append(degradations.handlers[:i], degradations.handlers[i+1:]...)
+			break
+		}
+	}
+}
+
+// GraphDegradationHandler reflects the degradations of the probes whose name
+// starts with a prefix into the metadata of a graph node
+type GraphDegradationHandler struct {
+	sync.Mutex
+	graph        *graph.Graph
+	lookup       func() *graph.Node
+	prefix       string
+	degradations map[string]Degradation
+}
+
+func (h *GraphDegradationHandler) sync() {
+	h.graph.Lock()
+	defer h.graph.Unlock()
+
+	n := h.lookup()
+	if n == nil {
+		return
+	}
+
+	if len(h.degradations) == 0 {
+		if _, err := n.GetField(DegradationMetadataKey); err == nil {
+			h.graph.DelMetadata(n, DegradationMetadataKey)
+		}
+		return
+	}
+
+	m := make(map[string]interface{}, len(h.degradations))
+	for name, d := range h.degradations {
+		m[name] = map[string]interface{}{
+			"Error": d.Error,
+			"Since": d.Since,
+			"Time":  d.Time,
+			"Count": d.Count,
+		}
+	}
+	h.graph.AddMetadata(n, DegradationMetadataKey, m)
+}
+
+func (h *GraphDegradationHandler) match(name string) bool {
+	return strings.HasPrefix(name, h.prefix)
+}
+
+// OnProbeDegraded event
+func (h *GraphDegradationHandler) OnProbeDegraded(name string, d Degradation) {
+	if !h.match(name) {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	h.degradations[name] = d
+	h.sync()
+}
+
+// OnProbeRecovered event
+func (h *GraphDegradationHandler) OnProbeRecovered(name string) {
+	if !h.match(name) {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	delete(h.degradations, name)
+	h.sync()
+}
+
+// NewGraphDegradationHandler returns a handler reporting the degradations of
+// the probes prefixed by prefix on the node returned by lookup. lookup is
+// called with the graph lock held.
+func NewGraphDegradationHandler(g *graph.Graph, lookup func() *graph.Node, prefix string) *GraphDegradationHandler {
+	return &GraphDegradationHandler{
+		graph:        g,
+		lookup:       lookup,
+		prefix:       prefix,
+		degradations: make(map[string]Degradation),
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probe
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeDegradationHandler struct {
+	degraded  map[string]Degradation
+	recovered []string
+}
+
+func (f *fakeDegradationHandler) OnProbeDegraded(name string, d Degradation) {
+	f.degraded[name] = d
+}
+
+func (f *fakeDegradationHandler) OnProbeRecovered(name string) {
+	delete(f.degraded, name)
+	f.recovered = append(f.recovered, name)
+}
+
+func TestDegradation(t *testing.T) {
+	ReportError("test.early", errors.New("early failure"))
+	defer ReportRecovery("test.early")
+
+	h := &fakeDegradationHandler{degraded: make(map[string]Degradation)}
+	AddDegradationHandler(h)
+	defer RemoveDegradationHandler(h)
+
+	if _, ok := h.degraded["test.early"]; !ok {
+		t.Fatal("Existing degradation not replayed to the new handler")
+	}
+
+	ReportError("test.probe", errors.New("first failure"))
+	ReportError("test.probe", errors.New("second failure"))
+
+	d, ok := h.degraded["test.probe"]
+	if !ok {
+		t.Fatal("Degradation not reported")
+	}
+	if d.Count != 2 || d.Error != "second failure" || d.Since > d.Time {
+		t.Errorf("Unexpected degradation: %+v", d)
+	}
+
+	ReportRecovery("test.probe")
+	ReportRecovery("test.probe")
+
+	if _, ok := h.degraded["test.probe"]; ok {
+		t.Error("Degradation still reported after recovery")
+	}
+	if len(h.recovered) != 1 {
+		t.Errorf("Recovery should be notified once, got %v", h.recovered)
+	}
+	if _, ok := Degradations()["test.probe"]; ok {
+		t.Error("Recovered probe still listed in the degradations")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// Event describes an event recorded from a probe, the data being the event
+// as received by the probe so that it can be replayed through the same code
+type Event struct {
+	Time   int64
+	Source string
+	Key    string `json:",omitempty"`
+	Data   json.RawMessage
+}
+
+// Recorder writes the probe events as a trace of JSON lines
+type Recorder struct {
+	sync.Mutex
+	w       io.WriteCloser
+	encoder *json.Encoder
+}
+
+var recorder struct {
+	common.RWMutex
+	r *Recorder
+}
+
+// Record appends an event of the source, key being the instance of the
+// probe like the namespace or the OVSDB target
+func (r *Recorder) Record(source, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	event := &Event{
+		Time:   common.UnixMillis(time.Now()),
+		Source: source,
+		Key:    key,
+		Data:   data,
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	return r.encoder.Encode(event)
+}
+
+// Close the trace
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.w.Close()
+}
+
+// NewRecorder returns a recorder writing into w
+func NewRecorder(w io.WriteCloser) *Recorder {
+	return &Recorder{
+		w:       w,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// NewFileRecorder returns a recorder writing into the file at path
+func NewFileRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRecorder(f), nil
+}
+
+// SetRecorder sets the recorder used by the probes, nil stops the recording
+func SetRecorder(r *Recorder) {
+	recorder.Lock()
+	recorder.r = r
+	recorder.Unlock()
+}
+
+// Recording returns whether the probes events are recorded
+func Recording() bool {
+	recorder.RLock()
+	defer recorder.RUnlock()
+
+	return recorder.r != nil
+}
+
+// Record records an event with the recorder set, if any
+func Record(source, key string, v interface{}) {
+	recorder.RLock()
+	r := recorder.r
+	recorder.RUnlock()
+
+	if r == nil {
+		return
+	}
+
+	if err := r.Record(source, key, v); err != nil {
+		logging.GetLogger().Errorf("Unable to record %s event: %s", source, err)
+	}
+}
+
+// ReadTrace returns the events of the trace read from r in the recorded
+// order, only the ones of source if not empty
+func ReadTrace(r io.Reader, source string) ([]Event, error) {
+	var events []Event
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, err
+		}
+
+		if source == "" || event.Source == source {
+			events = append(events, event)
+		}
+	}
+
+	return events, scanner.Err()
+}
+
+// ReadTraceFile returns the events of the trace file at path
+func ReadTraceFile(path string, source string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadTrace(f, source)
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func TestTrace(t *testing.T) {
+	buffer := &bufferCloser{}
+
+	SetRecorder(NewRecorder(buffer))
+	Record("netlink", "/var/run/netns/ns1", map[string]int{"Index": 1})
+	Record("ovsdb", "unix:///var/run/openvswitch/db.sock", []string{"br-int"})
+	Record("netlink", "/var/run/netns/ns1", map[string]int{"Index": 2})
+	SetRecorder(nil)
+
+	// not recorded anymore
+	Record("netlink", "", map[string]int{"Index": 3})
+
+	events, err := ReadTrace(bytes.NewReader(buffer.Bytes()), "netlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 netlink events, got %d", len(events))
+	}
+
+	for i, event := range events {
+		var data map[string]int
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+
+		if event.Key != "/var/run/netns/ns1" || data["Index"] != i+1 {
+			t.Errorf("Wrong event replayed: %+v", event)
+		}
+	}
+
+	if events, _ = ReadTrace(bytes.NewReader(buffer.Bytes()), ""); len(events) != 3 {
+		t.Errorf("Expected 3 events, got %d", len(events))
+	}
+}
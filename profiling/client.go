@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package profiling
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	shttp "github.com/skydive-project/skydive/http"
+)
+
+// ProfilingClient requests profiles to the agents
+type ProfilingClient struct {
+	pool shttp.WSStructSpeakerPool
+}
+
+// Capture requests a profile to the agent of the given host
+func (pc *ProfilingClient) Capture(host string, p *ProfileRequest) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	timeout := shttp.DefaultRequestTimeout
+	if p.Type == CPUProfile {
+		timeout += time.Duration(p.Duration) * time.Second
+	}
+
+	msg := shttp.NewWSStructMessage(Namespace, "ProfileRequest", p)
+
+	resp, err := pc.pool.Request(host, msg, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to send message to agent %s: %s", host, err.Error())
+	}
+
+	var reply ProfileReply
+	if err := resp.UnmarshalObj(&reply); err != nil {
+		return nil, fmt.Errorf("Failed to parse response from %s: %s", host, err.Error())
+	}
+
+	if resp.Status != http.StatusOK {
+		return nil, errors.New(reply.Error)
+	}
+
+	return reply.Data, nil
+}
+
+// NewClient returns a new profiling client sending its requests through the
+// given pool
+func NewClient(pool shttp.WSStructSpeakerPool) *ProfilingClient {
+	return &ProfilingClient{pool: pool}
+}
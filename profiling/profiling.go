@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package profiling
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+const (
+	// Namespace Profiling
+	Namespace = "Profiling"
+
+	// CPUProfile is the type of the profiles sampling the CPU usage during the
+	// requested duration
+	CPUProfile = "cpu"
+
+	// MaxDuration is the longest CPU profile that can be captured
+	MaxDuration = 300 * time.Second
+)
+
+// ErrProfilingInProgress is returned when a CPU profile is already being captured
+var ErrProfilingInProgress = errors.New("A CPU profile is already being captured")
+
+// ProfileRequest describes the profile to capture
+type ProfileRequest struct {
+	Type     string
+	Duration int64 // seconds, for CPU profiles
+}
+
+// ProfileReply holds the captured profile, in the pprof format
+type ProfileReply struct {
+	Data  []byte
+	Error string
+}
+
+// Validate checks the type and the duration of the profile request
+func (p *ProfileRequest) Validate() error {
+	if p.Type == CPUProfile {
+		d := time.Duration(p.Duration) * time.Second
+		if d <= 0 || d > MaxDuration {
+			return fmt.Errorf("Duration of the CPU profile has to be between 1 and %d seconds", int(MaxDuration.Seconds()))
+		}
+		return nil
+	}
+
+	if pprof.Lookup(p.Type) == nil {
+		return fmt.Errorf("Unknown profile type: %s", p.Type)
+	}
+	return nil
+}
+
+// Capture captures a profile of the running process. CPU profiles block for the
+// requested duration, the other types are snapshots of the runtime profiles,
+// heap, goroutine, block, mutex, ...
+func Capture(p *ProfileRequest) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if p.Type == CPUProfile {
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return nil, ErrProfilingInProgress
+		}
+		time.Sleep(time.Duration(p.Duration) * time.Second)
+		pprof.StopCPUProfile()
+
+		return buf.Bytes(), nil
+	}
+
+	if p.Type == "heap" {
+		// report the up-to-date statistics of the live objects
+		runtime.GC()
+	}
+
+	if err := pprof.Lookup(p.Type).WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package profiling
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []ProfileRequest{
+		{Type: CPUProfile, Duration: 1},
+		{Type: "heap"},
+		{Type: "goroutine"},
+	}
+	for _, p := range valid {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got: %s", p, err)
+		}
+	}
+
+	invalid := []ProfileRequest{
+		{Type: CPUProfile},
+		{Type: CPUProfile, Duration: int64(MaxDuration.Seconds()) + 1},
+		{Type: "unknown"},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", p)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	for _, p := range []*ProfileRequest{{Type: CPUProfile, Duration: 1}, {Type: "heap"}} {
+		data, err := Capture(p)
+		if err != nil {
+			t.Fatalf("Failed to capture the %s profile: %s", p.Type, err)
+		}
+		if len(data) == 0 {
+			t.Errorf("Expected a non empty %s profile", p.Type)
+		}
+	}
+}
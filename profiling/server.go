@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package profiling
+
+import (
+	"net/http"
+
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// ProfilingServer captures the profiles requested by the analyzers
+type ProfilingServer struct {
+}
+
+func (ps *ProfilingServer) captureProfile(c shttp.WSSpeaker, msg *shttp.WSStructMessage) {
+	var reply *shttp.WSStructMessage
+
+	var req ProfileRequest
+	if err := msg.DecodeObj(&req); err != nil {
+		reply = msg.Reply(&ProfileReply{Error: err.Error()}, "ProfileResult", http.StatusBadRequest)
+		c.SendMessage(reply)
+		return
+	}
+
+	logging.GetLogger().Infof("Capturing %s profile requested by %s", req.Type, c.GetHost())
+
+	data, err := Capture(&req)
+	if err != nil {
+		logging.GetLogger().Error(err)
+
+		status := http.StatusBadRequest
+		if err == ErrProfilingInProgress {
+			status = http.StatusConflict
+		}
+		reply = msg.Reply(&ProfileReply{Error: err.Error()}, "ProfileResult", status)
+	} else {
+		reply = msg.Reply(&ProfileReply{Data: data}, "ProfileResult", http.StatusOK)
+	}
+
+	c.SendMessage(reply)
+}
+
+// OnWSStructMessage event, websocket ProfileRequest message
+func (ps *ProfilingServer) OnWSStructMessage(c shttp.WSSpeaker, msg *shttp.WSStructMessage) {
+	switch msg.Type {
+	case "ProfileRequest":
+		// CPU profiles last for seconds, don't block the other messages
+		go ps.captureProfile(c, msg)
+	}
+}
+
+// NewServer creates a new profiling server answering the requests received
+// through the given pool
+func NewServer(pool shttp.WSStructSpeakerPool) *ProfilingServer {
+	s := &ProfilingServer{}
+	pool.AddStructMessageHandler(s, []string{Namespace})
+	return s
+}
@@ -28,6 +28,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/casbin/casbin"
 	"github.com/casbin/casbin/model"
@@ -40,6 +41,14 @@ import (
 
 var enforcer *casbin.Enforcer
 
+// scopes holds the permissions of the subjects that are not part of the
+// policy, like the service accounts. They are expressed as "object:action",
+// "object:*" or "*".
+var scopes = struct {
+	sync.RWMutex
+	subjects map[string][]string
+}{subjects: make(map[string][]string)}
+
 func loadSection(model model.Model, key string, sec string) {
 	getKey := func(i int) string {
 		if i == 0 {
@@ -125,8 +134,40 @@ func Init(kapi etcd.KeysAPI) error {
 	return nil
 }
 
+// SetScopes restricts the permissions of a subject to the given scopes,
+// the policy is not taken into account for this subject anymore
+func SetScopes(sub string, perms []string) {
+	scopes.Lock()
+	scopes.subjects[sub] = perms
+	scopes.Unlock()
+}
+
+// RemoveScopes removes the scopes of a subject
+func RemoveScopes(sub string) {
+	scopes.Lock()
+	delete(scopes.subjects, sub)
+	scopes.Unlock()
+}
+
+func enforceScopes(perms []string, obj, act string) bool {
+	for _, perm := range perms {
+		if perm == "*" || perm == obj+":*" || perm == obj+":"+act {
+			return true
+		}
+	}
+	return false
+}
+
 // Enforce decides whether a "subject" can access an "object" with the operation "action"
 func Enforce(sub, obj, act string) bool {
+	scopes.RLock()
+	perms, scoped := scopes.subjects[sub]
+	scopes.RUnlock()
+
+	if scoped {
+		return enforceScopes(perms, obj, act)
+	}
+
 	if enforcer == nil {
 		return true
 	}
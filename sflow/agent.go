@@ -111,9 +111,9 @@ func (sfa *SFlowAgent) start() error {
 	sfa.Lock()
 	addr := net.UDPAddr{
 		Port: sfa.Port,
-		IP:   net.ParseIP(sfa.Addr),
+		IP:   net.ParseIP(strings.Trim(sfa.Addr, "[]")),
 	}
-	conn, err := net.ListenUDP("udp", &addr)
+	conn, err := net.ListenUDP(common.IPNetwork("udp"), &addr)
 	if err != nil {
 		logging.GetLogger().Errorf("Unable to listen on port %d: %s", sfa.Port, err.Error())
 		sfa.Unlock()
@@ -42,8 +42,16 @@ var Capture = {
         <dd v-if="capture.Description">{{capture.Description}}</dd>\
         <dt v-if="capture.Type">Type</dt>\
         <dd v-if="capture.Type">{{capture.Type}}</dd>\
+        <dt v-if="capture.Hosts">Hosts</dt>\
+        <dd v-if="capture.Hosts">{{capture.Hosts.join(\', \')}}</dd>\
+        <dt v-if="capture.NetNS">Namespace</dt>\
+        <dd v-if="capture.NetNS">{{capture.NetNS}}</dd>\
         <dt v-if="capture.LayerKeyMode">Layer mode</dt>\
         <dd v-if="capture.LayerKeyMode">{{capture.LayerKeyMode}}</dd>\
+        <dt v-if="capture.FlowKey">Flow key</dt>\
+        <dd v-if="capture.FlowKey">{{capture.FlowKey}}</dd>\
+        <dt v-if="capture.FlowDirection">Flow direction</dt>\
+        <dd v-if="capture.FlowDirection">{{capture.FlowDirection}}</dd>\
         <dt v-if="capture.BPFFilter">BPF</dt>\
         <dd v-if="capture.BPFFilter">{{capture.BPFFilter}}</dd>\
         <dt v-if="capture.HeaderSize">Header</dt>\
@@ -766,7 +766,8 @@ TopologyGraphLayout.prototype = {
     if (node.metadata.Manager && !node._metadata.Manager) {
       this.managerSet(node);
     }
-    if (node.metadata.State !== node._metadata.State) {
+    if (node.metadata.State !== node._metadata.State ||
+        this.stackName(node.metadata) !== this.stackName(node._metadata)) {
        this.stateSet(node);
     }
     node._metadata = node.metadata;
@@ -1676,6 +1677,8 @@ TopologyGraphLayout.prototype = {
 
     if (d.metadata.Probe) clazz += " " + d.metadata.Probe;
     if (d.metadata.State == "DOWN") clazz += " down";
+    if (d.metadata.Maintenance && d.metadata.Maintenance.ExpireTime > Date.now()) clazz += " maintenance";
+    if (d.metadata.Stack) clazz += " stack";
     if (d.highlighted) clazz += " highlighted";
     if (d.selected) clazz += " selected";
 
@@ -1699,6 +1702,10 @@ TopologyGraphLayout.prototype = {
     return clazz;
   },
 
+  stackName: function(metadata) {
+    return metadata.Stack ? metadata.Stack.Name : undefined;
+  },
+
   nodeTitle: function(d) {
     if (d.metadata.Type === "host") {
       return d.metadata.Name.split(".")[0];
@@ -171,9 +171,16 @@ var TopologyComponent = {
               <i class="node-action fa"\
                  :class="{\'fa-expand\': currentNode.group.collapsed, \'fa-compress\': !currentNode.group.collapsed}" />\
             </button>\
+            <button v-for="action in pluginNodeActions"\
+                    :title="action.Title || action.Name"\
+                    class="btn btn-default btn-xs"\
+                    @click.stop="runPluginNodeAction(action)">\
+              <i class="node-action fa" :class="action.Icon || \'fa-plug\'" />\
+            </button>\
           </template>\
           <object-detail :object="currentNodeMetadata"\
                          :links="metadataLinks(currentNodeMetadata)"\
+                         :transformer="renderPluginMetadata"\
                          :collapsed="metadataCollapseState">\
           </object-detail>\
         </panel>\
@@ -391,6 +398,11 @@ var TopologyComponent = {
         ['LastUpdateMetric', 'Metric', 'Ovs.Metric', 'Ovs.LastUpdateMetric', 'RoutingTable', 'Features']);
     },
 
+    pluginNodeActions: function() {
+      if (!this.currentNode) return [];
+      return skydivePlugins.actionsForNode(this.currentNode);
+    },
+
     currentNodeFlowsQuery: function() {
       if (this.currentNodeMetadata && this.currentNode.isCaptureAllowed())
         return "G.Flows().Has('NodeTID', '" + this.currentNode.metadata.TID + "').Sort()";
@@ -464,6 +476,14 @@ var TopologyComponent = {
       return links;
     },
 
+    runPluginNodeAction: function(action) {
+      skydivePlugins.runNodeAction(action, this.currentNode, this);
+    },
+
+    renderPluginMetadata: function(key, value) {
+      return skydivePlugins.renderMetadata(key, value);
+    },
+
     unwatch: function() {
       clearTimeout(this.timeId);
       this.timeId = null;
@@ -0,0 +1,105 @@
+/* WebUI plugins, declared by the analyzer in uiPlugins. The plugin scripts
+   register the functions named by their node actions and metadata renderers
+   with skydivePlugins.registerHandler. */
+var skydivePlugins = {
+
+  handlers: {},
+
+  nodeActions: [],
+
+  metadataRenderers: {},
+
+  init: function(plugins) {
+    var self = this;
+
+    $.each(plugins || [], function(i, plugin) {
+      $.each(plugin.NodeActions || [], function(j, action) {
+        self.registerNodeAction(Object.assign({Plugin: plugin.Name}, action));
+      });
+      $.each(plugin.MetadataRenderers || [], function(j, renderer) {
+        self.metadataRenderers[renderer.Key] = renderer.Handler;
+      });
+    });
+  },
+
+  registerHandler: function(name, fn) {
+    this.handlers[name] = fn;
+  },
+
+  registerNodeAction: function(action) {
+    this.nodeActions.push(action);
+  },
+
+  field: function(metadata, key) {
+    var value = metadata;
+    $.each(key.split("."), function(i, k) {
+      value = (value !== null && typeof value === "object") ? value[k] : undefined;
+    });
+    return value;
+  },
+
+  // actions of which all the Match values are equal to the node metadata
+  actionsForNode: function(node) {
+    var self = this;
+
+    return this.nodeActions.filter(function(action) {
+      var match = action.Match || {};
+      return Object.keys(match).every(function(key) {
+        return self.field(node.metadata, key) === match[key];
+      });
+    });
+  },
+
+  expandURL: function(url, node) {
+    var self = this;
+
+    return url.replace(/{{\s*([\w.]+)\s*}}/g, function(m, key) {
+      var value = key === "ID" ? node.id : self.field(node.metadata, key.replace(/^Metadata\./, ""));
+      return encodeURIComponent(value === undefined ? "" : value);
+    });
+  },
+
+  runNodeAction: function(action, node, vm) {
+    if (action.Handler) {
+      var handler = this.handlers[action.Handler];
+      if (!handler) {
+        vm.$error({message: "Handler " + action.Handler + " of the action " + action.Name + " not registered"});
+        return;
+      }
+      handler(node, vm);
+      return;
+    }
+
+    var url = this.expandURL(action.URL, node);
+    var method = action.Method || "GET";
+    if (method === "GET") {
+      window.open(url, "_blank");
+      return;
+    }
+
+    $.ajax({url: url, method: method})
+      .then(function() {
+        vm.$success({message: (action.Title || action.Name) + " done"});
+      })
+      .fail(function(e) {
+        vm.$error({message: (action.Title || action.Name) + " failed: " + e.responseText});
+      });
+  },
+
+  // returns the HTML rendering of a metadata value, the value itself without
+  // renderer
+  renderMetadata: function(key, value) {
+    var name = this.metadataRenderers[key];
+    if (name && this.handlers[name]) {
+      try {
+        return this.handlers[name](value);
+      } catch (e) {
+        console.log("Renderer " + name + " of " + key + " failed: " + e);
+      }
+    }
+    return value;
+  },
+
+};
+
+skydivePlugins.init(typeof(uiPlugins) !== "undefined" ? uiPlugins : []);
@@ -24,10 +24,16 @@ package elasticsearch
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"net/url"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -42,6 +48,7 @@ import (
 	"github.com/skydive-project/skydive/logging"
 )
 
+// indexVersion is the version of the mappings of the clients not setting their own
 const indexVersion = 11
 const indexPrefix = "skydive"
 const indexAllAlias = "all"
@@ -56,8 +63,63 @@ type Config struct {
 	EntriesLimit int
 	AgeLimit     int
 	IndicesLimit int
+	// Rollover rolls the index at the start of each day or week, in local
+	// time, whatever its number of entries
+	Rollover string
+	// Retention is the age in seconds after which the rolled indices are
+	// deleted, 0 keeping them
+	Retention int
+	// MappingsVersion is the version of the mappings, part of the index
+	// names, to be increased with any incompatible change of the mappings
+	MappingsVersion int
+	// Upgrade is what is done with the indices of the previous mappings
+	// versions, either reindexed into the current version or left as is
+	Upgrade string
+	// UpgradeScript is a painless script applied to the reindexed
+	// documents, with the now parameter holding the upgrade time in ms
+	UpgradeScript string
+	// Username and Password are the credentials of the basic
+	// authentication, overriding the ones of the host URL
+	Username string
+	Password string
+	// APIKey is sent by the Authorization header, either already base64
+	// encoded or as the key ID and the key separated by a colon
+	APIKey string
+	// X509CA is the certificate authority verifying the certificate of the
+	// cluster, X509Cert and X509Key the client certificate, all of them
+	// used with https hosts only
+	X509CA   string
+	X509Cert string
+	X509Key  string
+	// InsecureSkipVerify disables the verification of the certificate of
+	// the cluster
+	InsecureSkipVerify bool
+	// BulkRetryPath is the directory of the bulk retry queue and
+	// dead-letter files, the failed bulk requests being only kept in
+	// memory and dropped once out of attempts when empty
+	BulkRetryPath string
+	// BulkRetryAttempts is the number of attempts of a failed bulk request,
+	// retried after a backoff doubled at each attempt up to
+	// BulkRetryMaxBackoff seconds
+	BulkRetryAttempts   int
+	BulkRetryMaxBackoff int
+	// BulkRetryQueueSize is the maximum number of queued requests, the
+	// oldest ones being dead-lettered when reached
+	BulkRetryQueueSize int
 }
 
+// Upgrade modes of the indices of the previous mappings versions
+const (
+	UpgradeReindex = "reindex"
+	UpgradeNone    = "none"
+)
+
+// Periods of the time based rollover of the indices
+const (
+	RolloverDaily  = "daily"
+	RolloverWeekly = "weekly"
+)
+
 func NewConfig(name ...string) Config {
 	cfg := Config{}
 
@@ -75,12 +137,34 @@ func NewConfig(name ...string) Config {
 	cfg.BulkMaxDelay = config.GetInt(path + ".bulk_maxdelay")
 
 	cfg.EntriesLimit = config.GetInt(path + ".index_entries_limit")
-	cfg.AgeLimit = 0
-	// TODO: read the AgeLimit from the configuration. At this stage we are setting statically to zero since
-	// TODO: the feature of reading the index creation date is not supported.
-	// TODO: the code that need to happen when we will
-	// TODO: be ready:: cfg.AgeLimit = config.GetInt(path + ".index_age_limit")
+	cfg.AgeLimit = config.GetInt(path + ".index_age_limit")
 	cfg.IndicesLimit = config.GetInt(path + ".indices_to_keep")
+	cfg.Rollover = config.GetString(path + ".index_rollover")
+	cfg.Retention = config.GetInt(path + ".index_retention")
+
+	cfg.MappingsVersion = indexVersion
+	if cfg.Upgrade = config.GetString(path + ".upgrade"); cfg.Upgrade == "" {
+		cfg.Upgrade = UpgradeReindex
+	}
+
+	cfg.Username = config.GetString(path + ".username")
+	cfg.Password = config.GetString(path + ".password")
+	cfg.APIKey = config.GetString(path + ".api_key")
+	cfg.X509CA = config.GetString(path + ".X509_ca")
+	cfg.X509Cert = config.GetString(path + ".X509_cert")
+	cfg.X509Key = config.GetString(path + ".X509_key")
+	cfg.InsecureSkipVerify = config.GetBool(path + ".insecure_skip_verify")
+
+	cfg.BulkRetryPath = config.GetString(path + ".bulk_retry.path")
+	if cfg.BulkRetryAttempts = config.GetInt(path + ".bulk_retry.max_attempts"); cfg.BulkRetryAttempts <= 0 {
+		cfg.BulkRetryAttempts = 10
+	}
+	if cfg.BulkRetryMaxBackoff = config.GetInt(path + ".bulk_retry.max_backoff"); cfg.BulkRetryMaxBackoff <= 0 {
+		cfg.BulkRetryMaxBackoff = 300
+	}
+	if cfg.BulkRetryQueueSize = config.GetInt(path + ".bulk_retry.queue_size"); cfg.BulkRetryQueueSize <= 0 {
+		cfg.BulkRetryQueueSize = 100000
+	}
 
 	return cfg
 }
@@ -101,6 +185,8 @@ type ElasticSearchClientInterface interface {
 	Delete(obj string, id string) (*elastic.DeleteResponse, error)
 	BulkDelete(obj string, id string)
 	Search(obj string, query elastic.Query, index string, pagination filters.SearchQuery) (*elastic.SearchResult, error)
+	SearchAfter(obj string, query elastic.Query, index string, pagination filters.SearchQuery, size int, after []interface{}) (*elastic.SearchResult, error)
+	DeleteByQuery(obj string, query elastic.Query) (int64, error)
 	Start()
 	GetIndexAlias() string
 	GetIndexAllAlias() string
@@ -121,6 +207,7 @@ type ElasticIndex struct {
 type ElasticSearchClient struct {
 	client        *elastic.Client
 	bulkProcessor *elastic.BulkProcessor
+	retryQueue    *retryQueue
 	started       atomic.Value
 	quit          chan bool
 	wg            sync.WaitGroup
@@ -128,6 +215,12 @@ type ElasticSearchClient struct {
 	mappings      Mappings
 	cfg           Config
 	index         *ElasticIndex
+	url           string
+	// typeless is set with Elasticsearch 6 and later, see typeless.go
+	typeless        bool
+	typelessMapping string
+	parents         map[string]string
+	totalHits       *totalHitsTransport
 }
 
 // ErrBadConfig error bad configuration file
@@ -143,13 +236,26 @@ func getTimeNow() string {
 		t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
 }
 
+func (c *ElasticSearchClient) mappingsVersion() int {
+	if c.cfg.MappingsVersion == 0 {
+		return indexVersion
+	}
+	return c.cfg.MappingsVersion
+}
+
 func (c *ElasticSearchClient) getIndexPath() string {
 	var suffix string
-	if c.cfg.EntriesLimit != 0 || c.cfg.AgeLimit != 0 {
+	if c.rolling() {
 		suffix = "_" + getTimeNow()
 	}
 
-	return fmt.Sprintf("%s_%s_v%d%s", indexPrefix, c.name, indexVersion, suffix)
+	return fmt.Sprintf("%s_%s_v%d%s", indexPrefix, c.name, c.mappingsVersion(), suffix)
+}
+
+// getUpgradeIndexPath returns the index receiving the documents reindexed
+// from the previous mappings versions
+func (c *ElasticSearchClient) getUpgradeIndexPath() string {
+	return fmt.Sprintf("%s_%s_v%d_upgrade", indexPrefix, c.name, c.mappingsVersion())
 }
 
 // Get the rolling alias which points to the currently active index
@@ -169,35 +275,108 @@ func (c *ElasticSearchClient) countEntries() int {
 }
 
 func (c *ElasticSearchClient) createAlias() error {
-	newAlias := c.GetIndexAlias()
-	allAlias := c.GetIndexAllAlias()
-	aliasServer := c.client.Alias()
-
 	aliasResult, err := c.client.Aliases().Do(context.Background())
 	if err != nil {
 		return err
 	}
 
+	var aliased []string
 	for k := range aliasResult.Indices {
-		if strings.HasPrefix(k, newAlias) {
-			aliasServer.Remove(k, newAlias)
+		aliased = append(aliased, k)
+	}
+
+	actions := rollingAliasActions(aliased, c.index.path, c.GetIndexAlias(), c.GetIndexAllAlias())
+	return c.applyAliasActions(context.Background(), actions)
+}
+
+// detectVersion enables the typeless indices if supported by the server
+func (c *ElasticSearchClient) detectVersion() error {
+	version, err := c.client.ElasticsearchVersion(c.url)
+	if err != nil {
+		return fmt.Errorf("Unable to get the Elasticsearch version: %s", err)
+	}
+
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return fmt.Errorf("Unexpected Elasticsearch version %s", version)
+	}
+
+	if c.typeless = major >= typelessVersion; c.typeless {
+		if c.typelessMapping, err = typelessMappings(c.mappings, major); err != nil {
+			return err
 		}
 	}
 
-	aliasServer.Add(c.index.path, newAlias)
-	aliasServer.Add(c.index.path, allAlias)
+	c.totalHits.enable(major >= totalHitsVersion)
+
+	logging.GetLogger().Debugf("Elasticsearch %s detected for %s, typeless indices: %t", version, c.name, c.typeless)
+	return nil
+}
+
+// docType returns the mapping type of the documents of the object
+func (c *ElasticSearchClient) docType(obj string) string {
+	if c.typeless {
+		return typelessDocType
+	}
+	return obj
+}
+
+// document returns the document to index for data, with the fields of the
+// typeless documents if needed
+func (c *ElasticSearchClient) document(obj string, parent string, data interface{}) (interface{}, error) {
+	if !c.typeless {
+		return data, nil
+	}
 
-	if _, err := aliasServer.Do(context.Background()); err != nil {
+	isParent := false
+	for _, p := range c.parents {
+		if p == obj {
+			isParent = true
+		}
+	}
+	return typelessDocument(obj, parent, isParent, data)
+}
+
+// typeQuery restricts the query to the documents of the object when the
+// indices are typeless
+func (c *ElasticSearchClient) typeQuery(obj string, query elastic.Query) elastic.Query {
+	if !c.typeless {
+		return query
+	}
+
+	boolQuery := elastic.NewBoolQuery().Filter(elastic.NewTermQuery(DocTypeField, obj))
+	if query != nil {
+		boolQuery = boolQuery.Must(query)
+	}
+	return boolQuery
+}
+
+// HitParent returns the ID of the parent document of a search hit, the
+// child documents being routed by their parent in the typeless indices
+func (c *ElasticSearchClient) HitParent(hit *elastic.SearchHit) string {
+	if c.typeless {
+		return hit.Routing
+	}
+	return hit.Parent
+}
+
+// newIndex creates an index with the mappings of the objects
+func (c *ElasticSearchClient) newIndex(ctx context.Context, index string) error {
+	if c.typeless {
+		_, err := c.client.CreateIndex(index).BodyString(c.typelessMapping).Do(ctx)
 		return err
 	}
 
-	return nil
+	if _, err := c.client.CreateIndex(index).Do(ctx); err != nil {
+		return err
+	}
+	return c.addMappings(index)
 }
 
-func (c *ElasticSearchClient) addMappings() error {
+func (c *ElasticSearchClient) addMappings(index string) error {
 	for _, document := range c.mappings {
 		for obj, mapping := range document {
-			if _, err := c.client.PutMapping().Index(c.index.path).Type(obj).BodyString(string(mapping)).Do(context.Background()); err != nil {
+			if _, err := c.client.PutMapping().Index(index).Type(obj).BodyString(string(mapping)).Do(context.Background()); err != nil {
 				return fmt.Errorf("Unable to create %s mapping: %s", obj, err.Error())
 			}
 		}
@@ -205,9 +384,50 @@ func (c *ElasticSearchClient) addMappings() error {
 	return nil
 }
 
+// rolling returns whether the index is rolled, its name being then
+// suffixed by its creation time
+func (c *ElasticSearchClient) rolling() bool {
+	return c.cfg.EntriesLimit != 0 || c.cfg.AgeLimit != 0 || c.cfg.Rollover != ""
+}
+
+// creationTimes returns the creation time of the indices, from their
+// index.creation_date setting
+func (c *ElasticSearchClient) creationTimes(indices ...string) (map[string]time.Time, error) {
+	settings, err := c.client.IndexGetSettings(indices...).Name("index.creation_date").Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	times := make(map[string]time.Time)
+	for index, response := range settings {
+		if response == nil {
+			continue
+		}
+
+		s, ok := response.Settings["index"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if ms, err := strconv.ParseInt(fmt.Sprintf("%v", s["creation_date"]), 10, 64); err == nil {
+			times[index] = time.Unix(0, ms*int64(time.Millisecond))
+		}
+	}
+	return times, nil
+}
+
+// timeCreated returns the creation time of the current index, so that its
+// age is kept across restarts
 func (c *ElasticSearchClient) timeCreated() time.Time {
-	//TODO: Get the index creation time (so we can compare to current time and decide if we need
-	//TODO: to roll the index by time. Make sure this supports restart of the Analyzer
+	times, err := c.creationTimes(c.index.path)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to get the creation time of %s: %s", c.index.path, err)
+		return time.Now()
+	}
+
+	if t, ok := times[c.index.path]; ok {
+		return t
+	}
 	return time.Now()
 }
 
@@ -215,31 +435,51 @@ func (c *ElasticSearchClient) createIndex() error {
 	c.index.path = c.getIndexPath()
 
 	if exists, _ := c.client.IndexExists(c.index.path).Do(context.Background()); !exists {
-		if _, err := c.client.CreateIndex(c.index.path).Do(context.Background()); err != nil {
+		if err := c.newIndex(context.Background(), c.index.path); err != nil {
 			return errors.New("Unable to create the skydive index: " + err.Error())
 		}
+	} else if !c.typeless {
+		if err := c.addMappings(c.index.path); err != nil {
+			return err
+		}
 	}
 
 	c.index.timeCreated = c.timeCreated()
 	c.index.entriesCounter = c.countEntries()
-	return c.addMappings()
+	return nil
 }
 
 func (c *ElasticSearchClient) start() error {
+	if err := c.detectVersion(); err != nil {
+		return err
+	}
+
 	c.index = &ElasticIndex{}
 	if err := c.createIndex(); err != nil {
 		logging.GetLogger().Errorf("Failed to create index %s", c.name)
 		return err
 	}
 
+	previous, err := c.previousIndices()
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to list the indices of the previous versions")
+		return err
+	}
+
 	if err := c.createAlias(); err != nil {
 		logging.GetLogger().Errorf("Failed to create alias")
 		return err
 	}
 
 	c.bulkProcessor.Start(context.Background())
+	c.retryQueue.start(c.bulkProcessor)
 	c.started.Store(true)
 
+	if len(previous) > 0 && c.cfg.Upgrade == UpgradeReindex {
+		c.wg.Add(1)
+		go c.upgrade(previous)
+	}
+
 	logging.GetLogger().Infof("ElasticSearchStorage started with skydive index %s", c.name)
 
 	return nil
@@ -354,8 +594,31 @@ func (c *ElasticSearchClient) shouldRollIndexByAge() bool {
 	return true
 }
 
+// rolloverStart returns the start of the rollover period holding t, the
+// weeks starting on Monday
+func rolloverStart(rollover string, t time.Time) time.Time {
+	year, month, day := t.Date()
+	start := time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	if rollover == RolloverWeekly {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	}
+	return start
+}
+
+func (c *ElasticSearchClient) shouldRollIndexByPeriod() bool {
+	if c.cfg.Rollover == "" {
+		return false
+	}
+
+	if c.index.timeCreated.Before(rolloverStart(c.cfg.Rollover, time.Now())) {
+		logging.GetLogger().Debugf("%s created in a previous %s period, rolling", c.name, c.cfg.Rollover)
+		return true
+	}
+	return false
+}
+
 func (c *ElasticSearchClient) shouldRollIndex() bool {
-	return (c.shouldRollIndexByAge() || c.shouldRollIndexByCount())
+	return (c.shouldRollIndexByPeriod() || c.shouldRollIndexByAge() || c.shouldRollIndexByCount())
 }
 
 func (c *ElasticSearchClient) ShouldRollIndex() bool {
@@ -370,21 +633,77 @@ func (c *ElasticSearchClient) IndexPath() string {
 	return c.index.path
 }
 
+// rolledIndices returns the rolled indices of the current mappings version,
+// the oldest first
+func (c *ElasticSearchClient) rolledIndices() ([]string, error) {
+	indices, err := c.client.IndexNames()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := fmt.Sprintf("%s_%s_v%d_", indexPrefix, c.name, c.mappingsVersion())
+
+	var rolled []string
+	for _, index := range indices {
+		if strings.HasPrefix(index, prefix) && index != c.getUpgradeIndexPath() {
+			rolled = append(rolled, index)
+		}
+	}
+
+	// the suffix being the creation time, the names sort chronologically
+	sort.Strings(rolled)
+	return rolled, nil
+}
+
+// delIndices deletes the rolled indices beyond the number of indices to
+// keep or older than the retention, never the current one
 func (c *ElasticSearchClient) delIndices() {
-	if c.cfg.IndicesLimit == 0 {
+	if c.cfg.IndicesLimit == 0 && c.cfg.Retention == 0 {
+		return
+	}
+
+	indices, err := c.rolledIndices()
+	if err != nil {
+		logging.GetLogger().Errorf("Error listing the indices of %s: %s", c.name, err)
 		return
 	}
 
-	indices, _ := c.client.IndexNames()
-	sort.Strings(indices)
+	expired := make(map[string]bool)
+	if c.cfg.IndicesLimit != 0 && len(indices) > c.cfg.IndicesLimit {
+		for _, index := range indices[:len(indices)-c.cfg.IndicesLimit] {
+			expired[index] = true
+		}
+	}
 
-	numToDel := len(indices) - c.cfg.IndicesLimit
-	if numToDel <= 0 {
+	if c.cfg.Retention != 0 && len(indices) > 0 {
+		times, err := c.creationTimes(indices...)
+		if err != nil {
+			logging.GetLogger().Errorf("Error getting the creation time of the indices of %s: %s", c.name, err)
+		}
+
+		limit := time.Now().Add(-time.Duration(c.cfg.Retention) * time.Second)
+		for index, t := range times {
+			if t.Before(limit) {
+				expired[index] = true
+			}
+		}
+	}
+
+	current := c.IndexPath()
+
+	var toDel []string
+	for _, index := range indices {
+		if expired[index] && index != current {
+			toDel = append(toDel, index)
+		}
+	}
+	if len(toDel) == 0 {
 		return
 	}
 
-	if _, err := c.client.DeleteIndex(indices[:numToDel]...).Do(context.Background()); err != nil {
-		logging.GetLogger().Errorf("Error deleting indexes %+v: %s", indices, err.Error())
+	logging.GetLogger().Infof("Deleting the indices %v of %s", toDel, c.name)
+	if _, err := c.client.DeleteIndex(toDel...).Do(context.Background()); err != nil {
+		logging.GetLogger().Errorf("Error deleting indexes %+v: %s", toDel, err.Error())
 	}
 }
 
@@ -419,7 +738,12 @@ func (c *ElasticSearchClient) Index(obj string, id string, data interface{}) (bo
 	c.index.Lock()
 	defer c.index.Unlock()
 
-	if _, err := c.client.Index().Index(c.GetIndexAlias()).Type(obj).Id(id).BodyJson(data).Do(context.Background()); err != nil {
+	doc, err := c.document(obj, "", data)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := c.client.Index().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id).BodyJson(doc).Do(context.Background()); err != nil {
 		return false, err
 	}
 
@@ -432,7 +756,12 @@ func (c *ElasticSearchClient) BulkIndex(obj string, id string, data interface{})
 	c.index.Lock()
 	defer c.index.Unlock()
 
-	req := elastic.NewBulkIndexRequest().Index(c.GetIndexAlias()).Type(obj).Id(id).Doc(data)
+	doc, err := c.document(obj, "", data)
+	if err != nil {
+		return false, err
+	}
+
+	req := elastic.NewBulkIndexRequest().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id).Doc(doc)
 	c.bulkProcessor.Add(req)
 
 	c.index.increaseEntries()
@@ -444,7 +773,19 @@ func (c *ElasticSearchClient) IndexChild(obj string, parent string, id string, d
 	c.index.Lock()
 	defer c.index.Unlock()
 
-	if _, err := c.client.Index().Index(c.GetIndexAlias()).Type(obj).Id(id).Parent(parent).BodyJson(data).Do(context.Background()); err != nil {
+	doc, err := c.document(obj, parent, data)
+	if err != nil {
+		return false, err
+	}
+
+	req := c.client.Index().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id).BodyJson(doc)
+	if c.typeless {
+		req = req.Routing(parent)
+	} else {
+		req = req.Parent(parent)
+	}
+
+	if _, err := req.Do(context.Background()); err != nil {
 		return false, err
 	}
 
@@ -457,7 +798,17 @@ func (c *ElasticSearchClient) BulkIndexChild(obj string, parent string, id strin
 	c.index.Lock()
 	defer c.index.Unlock()
 
-	req := elastic.NewBulkIndexRequest().Index(c.GetIndexAlias()).Type(obj).Id(id).Parent(parent).Doc(data)
+	doc, err := c.document(obj, parent, data)
+	if err != nil {
+		return false, err
+	}
+
+	req := elastic.NewBulkIndexRequest().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id).Doc(doc)
+	if c.typeless {
+		req = req.Routing(parent)
+	} else {
+		req = req.Parent(parent)
+	}
 	c.bulkProcessor.Add(req)
 
 	c.index.increaseEntries()
@@ -466,13 +817,13 @@ func (c *ElasticSearchClient) BulkIndexChild(obj string, parent string, id strin
 
 // Update an object
 func (c *ElasticSearchClient) Update(obj string, id string, data interface{}) error {
-	_, err := c.client.Update().Index(c.GetIndexAlias()).Type(obj).Id(id).Doc(data).Do(context.Background())
+	_, err := c.client.Update().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id).Doc(data).Do(context.Background())
 	return err
 }
 
 // BulkUpdate and object with the indexer
 func (c *ElasticSearchClient) BulkUpdate(obj string, id string, data interface{}) error {
-	req := elastic.NewBulkUpdateRequest().Index(c.GetIndexAlias()).Type(obj).Id(id).Doc(data)
+	req := elastic.NewBulkUpdateRequest().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id).Doc(data)
 	c.bulkProcessor.Add(req)
 
 	return nil
@@ -486,29 +837,30 @@ func (c *ElasticSearchClient) UpdateWithPartialDoc(obj string, id string, data i
 
 // BulkUpdateWithPartialDoc  an object with partial data using the indexer
 func (c *ElasticSearchClient) BulkUpdateWithPartialDoc(obj string, id string, data interface{}) error {
-	req := elastic.NewBulkUpdateRequest().Index(c.GetIndexAlias()).Type(obj).Id(id).Doc(data)
+	req := elastic.NewBulkUpdateRequest().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id).Doc(data)
 	c.bulkProcessor.Add(req)
 	return nil
 }
 
 // Get an object
 func (c *ElasticSearchClient) Get(obj string, id string) (*elastic.GetResult, error) {
-	return c.client.Get().Index(c.GetIndexAlias()).Type(obj).Id(id).Do(context.Background())
+	return c.client.Get().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id).Do(context.Background())
 }
 
 // Delete an object
 func (c *ElasticSearchClient) Delete(obj string, id string) (*elastic.DeleteResponse, error) {
-	return c.client.Delete().Index(c.GetIndexAlias()).Type(obj).Id(id).Do(context.Background())
+	return c.client.Delete().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id).Do(context.Background())
 }
 
 // BulkDelete an object with the indexer
 func (c *ElasticSearchClient) BulkDelete(obj string, id string) {
-	req := elastic.NewBulkDeleteRequest().Index(c.GetIndexAlias()).Type(obj).Id(id)
+	req := elastic.NewBulkDeleteRequest().Index(c.GetIndexAlias()).Type(c.docType(obj)).Id(id)
 	c.bulkProcessor.Add(req)
 }
 
-// Search an object
-func (c *ElasticSearchClient) Search(obj string, query elastic.Query, index string, opts filters.SearchQuery) (*elastic.SearchResult, error) {
+// searchService returns the search of the obj documents of the index
+// matching the query, sorted as set by the options
+func (c *ElasticSearchClient) searchService(obj string, query elastic.Query, index string, opts filters.SearchQuery) *elastic.SearchService {
 	if index == "" {
 		index = c.GetIndexAllAlias()
 	}
@@ -516,15 +868,10 @@ func (c *ElasticSearchClient) Search(obj string, query elastic.Query, index stri
 	searchQuery := c.client.
 		Search().
 		Index(index).
-		Type(obj).
-		Query(query).
+		Query(c.typeQuery(obj, query)).
 		Size(10000)
-
-	if r := opts.PaginationRange; r != nil {
-		if r.To < r.From {
-			return nil, errors.New("Incorrect PaginationRange, To < From")
-		}
-		searchQuery = searchQuery.From(int(r.From)).Size(int(r.To - r.From))
+	if !c.typeless {
+		searchQuery = searchQuery.Type(obj)
 	}
 
 	if opts.Sort {
@@ -535,9 +882,211 @@ func (c *ElasticSearchClient) Search(obj string, query elastic.Query, index stri
 		})
 	}
 
+	return searchQuery
+}
+
+// Search an object
+func (c *ElasticSearchClient) Search(obj string, query elastic.Query, index string, opts filters.SearchQuery) (*elastic.SearchResult, error) {
+	searchQuery := c.searchService(obj, query, index, opts)
+
+	if r := opts.PaginationRange; r != nil {
+		if r.To < r.From {
+			return nil, errors.New("Incorrect PaginationRange, To < From")
+		}
+		searchQuery = searchQuery.From(int(r.From)).Size(int(r.To - r.From))
+	}
+
+	return searchQuery.Do(context.Background())
+}
+
+// SearchAfter searches a page of at most size obj documents, sorted as set
+// by the options and then by document so that the order is total, starting
+// after the document of the sort values when given. Unlike Search, reading
+// the pages one after the other is not limited by the result window.
+func (c *ElasticSearchClient) SearchAfter(obj string, query elastic.Query, index string, opts filters.SearchQuery, size int, after []interface{}) (*elastic.SearchResult, error) {
+	if size <= 0 {
+		return nil, errors.New("Incorrect page size, must be positive")
+	}
+
+	// the _id field is only sortable since the typeless versions, _uid
+	// being used before
+	tiebreaker := "_uid"
+	if c.typeless {
+		tiebreaker = "_id"
+	}
+
+	searchQuery := c.searchService(obj, query, index, opts).
+		Size(size).
+		SortWithInfo(elastic.SortInfo{Field: tiebreaker, Ascending: true})
+	if len(after) > 0 {
+		searchQuery = searchQuery.SearchAfter(after...)
+	}
+
 	return searchQuery.Do(context.Background())
 }
 
+// DeleteByQuery deletes the obj documents matching the query in all the
+// indices, refreshed once done, returning the number of deleted documents
+func (c *ElasticSearchClient) DeleteByQuery(obj string, query elastic.Query) (int64, error) {
+	deleteQuery := c.client.
+		DeleteByQuery(c.GetIndexAllAlias()).
+		Query(c.typeQuery(obj, query)).
+		ProceedOnVersionConflict().
+		Refresh("true")
+	if !c.typeless {
+		deleteQuery = deleteQuery.Type(obj)
+	}
+
+	res, err := deleteQuery.Do(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return res.Deleted, nil
+}
+
+func newAggregation(a *filters.Aggregation) (elastic.Aggregation, error) {
+	subAggregations := make(map[string]elastic.Aggregation)
+	for _, sub := range a.Aggregations {
+		aggregation, err := newAggregation(sub)
+		if err != nil {
+			return nil, err
+		}
+		subAggregations[sub.Name] = aggregation
+	}
+
+	switch a.Type {
+	case filters.TermsAggregation:
+		terms := elastic.NewTermsAggregation().Field(a.Field)
+		if a.Size > 0 {
+			terms = terms.Size(int(a.Size))
+		}
+		if a.Order != "" {
+			terms = terms.OrderByAggregation(a.Order, false)
+		}
+		for name, sub := range subAggregations {
+			terms = terms.SubAggregation(name, sub)
+		}
+		return terms, nil
+	case filters.HistogramAggregation:
+		// empty buckets are left out
+		histogram := elastic.NewHistogramAggregation().Field(a.Field).Interval(float64(a.Interval)).MinDocCount(1)
+		for name, sub := range subAggregations {
+			histogram = histogram.SubAggregation(name, sub)
+		}
+		return histogram, nil
+	case filters.CardinalityAggregation:
+		return elastic.NewCardinalityAggregation().Field(a.Field), nil
+	case filters.SumAggregation:
+		return elastic.NewSumAggregation().Field(a.Field), nil
+	}
+
+	return nil, fmt.Errorf("Aggregation type '%s' not supported", a.Type)
+}
+
+func aggregationResults(aggregations elastic.Aggregations, requested []*filters.Aggregation) (map[string]*filters.AggregationResult, error) {
+	results := make(map[string]*filters.AggregationResult)
+	for _, a := range requested {
+		result := &filters.AggregationResult{}
+
+		switch a.Type {
+		case filters.TermsAggregation:
+			items, ok := aggregations.Terms(a.Name)
+			if !ok {
+				return nil, fmt.Errorf("Aggregation '%s' missing from the response", a.Name)
+			}
+			for _, item := range items.Buckets {
+				bucket := &filters.AggregationBucket{Key: item.Key, Count: item.DocCount}
+				if n, err := item.KeyNumber.Int64(); err == nil {
+					bucket.Key = n
+				}
+				if len(a.Aggregations) > 0 {
+					sub, err := aggregationResults(item.Aggregations, a.Aggregations)
+					if err != nil {
+						return nil, err
+					}
+					bucket.Aggregations = sub
+				}
+				result.Buckets = append(result.Buckets, bucket)
+			}
+		case filters.HistogramAggregation:
+			items, ok := aggregations.Histogram(a.Name)
+			if !ok {
+				return nil, fmt.Errorf("Aggregation '%s' missing from the response", a.Name)
+			}
+			for _, item := range items.Buckets {
+				bucket := &filters.AggregationBucket{Key: int64(item.Key), Count: item.DocCount}
+				if len(a.Aggregations) > 0 {
+					sub, err := aggregationResults(item.Aggregations, a.Aggregations)
+					if err != nil {
+						return nil, err
+					}
+					bucket.Aggregations = sub
+				}
+				result.Buckets = append(result.Buckets, bucket)
+			}
+		case filters.CardinalityAggregation:
+			metric, ok := aggregations.Cardinality(a.Name)
+			if !ok {
+				return nil, fmt.Errorf("Aggregation '%s' missing from the response", a.Name)
+			}
+			value := int64(0)
+			if metric.Value != nil {
+				value = int64(*metric.Value)
+			}
+			result.Value = &value
+		case filters.SumAggregation:
+			metric, ok := aggregations.Sum(a.Name)
+			if !ok {
+				return nil, fmt.Errorf("Aggregation '%s' missing from the response", a.Name)
+			}
+			value := int64(0)
+			if metric.Value != nil {
+				value = int64(*metric.Value)
+			}
+			result.Value = &value
+		}
+
+		results[a.Name] = result
+	}
+	return results, nil
+}
+
+// Aggregate returns the buckets of the aggregations of the documents
+// matching the query, no document being returned
+func (c *ElasticSearchClient) Aggregate(obj string, query elastic.Query, index string, aggregations []*filters.Aggregation) (map[string]*filters.AggregationResult, error) {
+	if err := filters.ValidateAggregations(aggregations); err != nil {
+		return nil, err
+	}
+
+	if index == "" {
+		index = c.GetIndexAllAlias()
+	}
+
+	searchQuery := c.client.
+		Search().
+		Index(index).
+		Query(c.typeQuery(obj, query)).
+		Size(0)
+	if !c.typeless {
+		searchQuery = searchQuery.Type(obj)
+	}
+
+	for _, a := range aggregations {
+		aggregation, err := newAggregation(a)
+		if err != nil {
+			return nil, err
+		}
+		searchQuery = searchQuery.Aggregation(a.Name, aggregation)
+	}
+
+	result, err := searchQuery.Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregationResults(result.Aggregations, aggregations)
+}
+
 // Start the Elasticsearch client background jobs
 func (c *ElasticSearchClient) Start() {
 	for {
@@ -551,9 +1100,21 @@ func (c *ElasticSearchClient) Start() {
 	}
 }
 
+// Flush sends the pending bulk requests and flushes the indices
+func (c *ElasticSearchClient) Flush() error {
+	if err := c.bulkProcessor.Flush(); err != nil {
+		return err
+	}
+
+	_, err := c.client.Flush(c.GetIndexAllAlias()).Do(context.Background())
+	return err
+}
+
 // Stop Elasticsearch background client
 func (c *ElasticSearchClient) Stop() {
 	if c.started.Load() == true {
+		c.retryQueue.stop()
+
 		c.quit <- true
 		c.wg.Wait()
 
@@ -579,6 +1140,139 @@ func urlFromHost(host string) (*url.URL, error) {
 	return url, nil
 }
 
+// apiKeyTransport authenticates the requests with an API key
+type apiKeyTransport struct {
+	http.RoundTripper
+	authorization string
+}
+
+// RoundTrip sets the Authorization header of a copy of the request
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", t.authorization)
+
+	return t.RoundTripper.RoundTrip(r)
+}
+
+func newAPIKeyTransport(transport http.RoundTripper, apiKey string) *apiKeyTransport {
+	if strings.Contains(apiKey, ":") {
+		apiKey = base64.StdEncoding.EncodeToString([]byte(apiKey))
+	}
+	return &apiKeyTransport{RoundTripper: transport, authorization: "ApiKey " + apiKey}
+}
+
+// newTLSConfig returns the TLS configuration of the https hosts, using the
+// TLS profile and the certificates of the configuration
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if cfg.X509Cert != "" || cfg.X509Key != "" {
+		if cfg.X509Cert == "" || cfg.X509Key == "" {
+			return nil, errors.New("both X509_cert and X509_key have to be set to use a client certificate")
+		}
+
+		var err error
+		if tlsConfig, err = common.SetupTLSClientConfig(cfg.X509Cert, cfg.X509Key); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.X509CA != "" {
+		roots, err := common.SetupTLSLoadCertificate(cfg.X509CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = roots
+	}
+	tlsConfig.InsecureSkipVerify = cfg.InsecureSkipVerify
+
+	profile, err := config.GetTLSProfile()
+	if err != nil {
+		return nil, err
+	}
+	profile.Apply(tlsConfig)
+
+	return tlsConfig, nil
+}
+
+// clientOptions returns the options of the elastic client, the ones of the
+// host URL as applied by elastic.NewClientFromConfig, the logs, sniffing
+// and healthcheck parameters, and the authentication of the configuration
+func clientOptions(esConfig *esconfig.Config, cfg Config) ([]elastic.ClientOptionFunc, *totalHitsTransport, error) {
+	https := strings.HasPrefix(esConfig.URL, "https://")
+
+	username, password := esConfig.Username, esConfig.Password
+	if cfg.Username != "" || cfg.Password != "" {
+		username, password = cfg.Username, cfg.Password
+	}
+	if cfg.APIKey != "" && (username != "" || password != "") {
+		return nil, nil, errors.New("basic authentication and API key can't be used together")
+	}
+	if !https && (cfg.X509CA != "" || cfg.X509Cert != "" || cfg.X509Key != "") {
+		return nil, nil, errors.New("X509 certificates require an https host")
+	}
+
+	options := []elastic.ClientOptionFunc{elastic.SetURL(esConfig.URL)}
+
+	logs := []struct {
+		name   string
+		path   string
+		option func(elastic.Logger) elastic.ClientOptionFunc
+	}{
+		{"error", esConfig.Errorlog, elastic.SetErrorLog},
+		{"info", esConfig.Infolog, elastic.SetInfoLog},
+		{"trace", esConfig.Tracelog, elastic.SetTraceLog},
+	}
+	for _, l := range logs {
+		if l.path == "" {
+			continue
+		}
+
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to initialize the %s log: %s", l.name, err)
+		}
+		options = append(options, l.option(log.New(f, "", 0)))
+	}
+
+	if username != "" || password != "" {
+		options = append(options, elastic.SetBasicAuth(username, password))
+	}
+	if esConfig.Sniff != nil {
+		options = append(options, elastic.SetSniff(*esConfig.Sniff))
+	}
+	if esConfig.Healthcheck != nil {
+		options = append(options, elastic.SetHealthcheck(*esConfig.Healthcheck))
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if https {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		transport = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+		}
+	}
+
+	if cfg.APIKey != "" {
+		transport = newAPIKeyTransport(transport, cfg.APIKey)
+	}
+
+	totalHits := newTotalHitsTransport(transport)
+	options = append(options, elastic.SetHttpClient(&http.Client{Transport: totalHits}))
+
+	return options, totalHits, nil
+}
+
 // GetClient returns the elastic client object
 func (c *ElasticSearchClient) GetClient() *elastic.Client {
 	return c.client
@@ -595,27 +1289,34 @@ func NewElasticSearchClient(name string, mappings Mappings, cfg Config) (*Elasti
 		return nil, errors.New("maxconns has to be > 0")
 	}
 
+	switch cfg.Rollover {
+	case "", RolloverDaily, RolloverWeekly:
+	default:
+		return nil, fmt.Errorf("index_rollover has to be %s or %s", RolloverDaily, RolloverWeekly)
+	}
+
 	esConfig, err := esconfig.Parse(url.String())
 	if err != nil {
 		return nil, err
 	}
 
-	esClient, err := elastic.NewClientFromConfig(esConfig)
+	options, totalHits, err := clientOptions(esConfig, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	bulkProcessor, err := esClient.BulkProcessor().
-		After(func(executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
-			if err != nil {
-				logging.GetLogger().Errorf("Failed to execute bulk query: %s", err)
-				return
-			}
+	esClient, err := elastic.NewClient(options...)
+	if err != nil {
+		return nil, err
+	}
 
-			if response.Errors {
-				logging.GetLogger().Errorf("Failed to insert %d entries", len(response.Failed()))
-			}
-		}).
+	retryQueue, err := newRetryQueue(name, cfg.BulkRetryPath, cfg.BulkRetryAttempts, time.Duration(cfg.BulkRetryMaxBackoff)*time.Second, cfg.BulkRetryQueueSize)
+	if err != nil {
+		return nil, err
+	}
+
+	bulkProcessor, err := esClient.BulkProcessor().
+		After(retryQueue.afterBulk).
 		FlushInterval(time.Duration(cfg.BulkMaxDelay) * time.Second).
 		Do(context.Background())
 	if err != nil {
@@ -625,11 +1326,15 @@ func NewElasticSearchClient(name string, mappings Mappings, cfg Config) (*Elasti
 	client := &ElasticSearchClient{
 		client:        esClient,
 		bulkProcessor: bulkProcessor,
+		retryQueue:    retryQueue,
 		quit:          make(chan bool, 1),
 		index:         nil,
 		name:          name,
 		mappings:      mappings,
 		cfg:           cfg,
+		url:           esConfig.URL,
+		parents:       parentTypes(mappings),
+		totalHits:     totalHits,
 	}
 
 	client.started.Store(false)
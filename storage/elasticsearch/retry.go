@@ -0,0 +1,351 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	elastic "github.com/olivere/elastic"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+// retryMinBackoff is the delay before the first retry of a bulk request,
+// doubled at each attempt up to the maximum backoff
+const retryMinBackoff = time.Second
+
+// retryRequest is a bulk request retried after a failure. It is kept as the
+// lines of its bulk body so that it can be persisted and sent again as is.
+type retryRequest struct {
+	Lines    []string
+	Attempts int
+	NextTry  time.Time
+	Error    string `json:",omitempty"`
+}
+
+// String returns the bulk body of the request
+func (r *retryRequest) String() string {
+	return strings.Join(r.Lines, "\n")
+}
+
+// Source returns the lines of the bulk body of the request
+func (r *retryRequest) Source() ([]string, error) {
+	return r.Lines, nil
+}
+
+// retryQueue holds the bulk requests that failed on transient errors, like
+// an unavailable cluster or a full bulk queue, sent again with an exponential
+// backoff. The queue is saved in a file of its directory, when given, to be
+// reloaded on restart, and the requests out of attempts, not retryable or
+// evicted from a full queue are appended to a dead-letter file.
+type retryQueue struct {
+	sync.Mutex
+	bulkProcessor  *elastic.BulkProcessor
+	requests       []*retryRequest
+	path           string
+	deadLetterPath string
+	maxAttempts    int
+	maxBackoff     time.Duration
+	maxSize        int
+	dirty          bool
+	quit           chan bool
+	wg             sync.WaitGroup
+}
+
+// retryableStatus returns whether a failed bulk item may succeed later, an
+// update of a document whose creation is itself retried included
+func retryableStatus(action string, status int) bool {
+	return status == 429 || status >= 500 || (action == "update" && status == 404)
+}
+
+func newRetryRequest(req elastic.BulkableRequest) (*retryRequest, error) {
+	if r, ok := req.(*retryRequest); ok {
+		return r, nil
+	}
+
+	lines, err := req.Source()
+	if err != nil {
+		return nil, err
+	}
+	return &retryRequest{Lines: lines}, nil
+}
+
+// backoff returns the delay before the next attempt of a request
+func (q *retryQueue) backoff(attempts int) time.Duration {
+	delay := retryMinBackoff
+	for i := 1; i < attempts && delay < q.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.maxBackoff {
+		delay = q.maxBackoff
+	}
+	return delay
+}
+
+// failed records the failure of a bulk request, queued for a retry when the
+// error is transient and the request has attempts left
+func (q *retryQueue) failed(req elastic.BulkableRequest, reason string, retryable bool) {
+	r, err := newRetryRequest(req)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to get the source of the bulk request %s: %s", req, err)
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	r.Attempts++
+	r.Error = reason
+
+	if !retryable || r.Attempts >= q.maxAttempts {
+		q.deadLetter(r)
+		return
+	}
+
+	r.NextTry = time.Now().Add(q.backoff(r.Attempts))
+	q.requests = append(q.requests, r)
+	if len(q.requests) > q.maxSize {
+		q.deadLetter(q.requests[0])
+		q.requests = q.requests[1:]
+	}
+	q.dirty = true
+}
+
+// afterBulk is called with the result of each bulk commit, queuing the
+// requests that failed
+func (q *retryQueue) afterBulk(executionID int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to execute bulk query, %d requests to be retried: %s", len(requests), err)
+		for _, req := range requests {
+			q.failed(req, err.Error(), true)
+		}
+		return
+	}
+
+	if response == nil || !response.Errors {
+		return
+	}
+
+	var failed int
+	for i, item := range response.Items {
+		if i >= len(requests) {
+			break
+		}
+
+		for action, result := range item {
+			if result.Status >= 200 && result.Status <= 299 || (action == "delete" && result.Status == 404) {
+				continue
+			}
+
+			q.failed(requests[i], bulkItemError(result), retryableStatus(action, result.Status))
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		logging.GetLogger().Errorf("Failed to insert %d entries", failed)
+	}
+}
+
+func bulkItemError(result *elastic.BulkResponseItem) string {
+	if result.Error != nil {
+		return result.Error.Type + ": " + result.Error.Reason
+	}
+	return "status " + strconv.Itoa(result.Status)
+}
+
+// deadLetter appends a request to the dead-letter file, with the lock held
+func (q *retryQueue) deadLetter(r *retryRequest) {
+	if q.deadLetterPath == "" {
+		logging.GetLogger().Errorf("Dropping the bulk request %s after %d attempts: %s", r, r.Attempts, r.Error)
+		return
+	}
+
+	file, err := os.OpenFile(q.deadLetterPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err == nil {
+		err = json.NewEncoder(file).Encode(r)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to write the bulk request %s to %s: %s", r, q.deadLetterPath, err)
+	}
+}
+
+// load reads the requests saved by a previous run
+func (q *retryQueue) load() error {
+	file, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var r retryRequest
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return err
+		}
+		q.requests = append(q.requests, &r)
+	}
+	return scanner.Err()
+}
+
+// save writes the queued requests to the queue file, replaced atomically,
+// with the lock held
+func (q *retryQueue) save() error {
+	file, err := ioutil.TempFile(filepath.Dir(q.path), filepath.Base(q.path))
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, r := range q.requests {
+		if err = encoder.Encode(r); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), q.path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// retry sends again the requests due, saving the queue when it changed.
+// The requests are added to the bulk processor without the lock held, its
+// commits calling back the queue on failure.
+func (q *retryQueue) retry(now time.Time) {
+	q.Lock()
+	var due, pending []*retryRequest
+	for _, r := range q.requests {
+		if now.Before(r.NextTry) {
+			pending = append(pending, r)
+		} else {
+			due = append(due, r)
+		}
+	}
+	q.requests = pending
+	q.Unlock()
+
+	for _, r := range due {
+		q.bulkProcessor.Add(r)
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if (q.dirty || len(due) > 0) && q.path != "" {
+		if err := q.save(); err != nil {
+			logging.GetLogger().Errorf("Failed to save the bulk retry queue to %s: %s", q.path, err)
+			return
+		}
+	}
+	q.dirty = false
+}
+
+func (q *retryQueue) run() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(retryMinBackoff)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			q.retry(now)
+		case <-q.quit:
+			q.Lock()
+			if q.dirty && q.path != "" {
+				if err := q.save(); err != nil {
+					logging.GetLogger().Errorf("Failed to save the bulk retry queue to %s: %s", q.path, err)
+				}
+			}
+			q.Unlock()
+			return
+		}
+	}
+}
+
+func (q *retryQueue) start(bulkProcessor *elastic.BulkProcessor) {
+	q.bulkProcessor = bulkProcessor
+
+	q.wg.Add(1)
+	go q.run()
+}
+
+func (q *retryQueue) stop() {
+	q.quit <- true
+	q.wg.Wait()
+}
+
+// newRetryQueue creates the retry queue of the bulk requests of a client,
+// persisted in the directory when given and reloaded from it
+func newRetryQueue(name string, dir string, maxAttempts int, maxBackoff time.Duration, maxSize int) (*retryQueue, error) {
+	q := &retryQueue{
+		maxAttempts: maxAttempts,
+		maxBackoff:  maxBackoff,
+		maxSize:     maxSize,
+		quit:        make(chan bool, 1),
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		q.path = filepath.Join(dir, name+"-retry.json")
+		q.deadLetterPath = filepath.Join(dir, name+"-deadletter.json")
+
+		if err := q.load(); err != nil {
+			return nil, err
+		}
+		if len(q.requests) > 0 {
+			logging.GetLogger().Infof("Reloaded %d bulk requests to be retried from %s", len(q.requests), q.path)
+		}
+	}
+
+	return q, nil
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	elastic "github.com/olivere/elastic"
+)
+
+func newTestRetryQueue(t *testing.T, maxSize int) (*retryQueue, string) {
+	dir, err := ioutil.TempDir("", "skydive-retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := newRetryQueue("test", dir, 3, 10*time.Second, maxSize)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return q, dir
+}
+
+func newTestRequest(id string) elastic.BulkableRequest {
+	return elastic.NewBulkIndexRequest().Index("skydive").Type("node").Id(id).Doc(map[string]interface{}{"ID": id})
+}
+
+func readDeadLetters(t *testing.T, q *retryQueue) (requests []*retryRequest) {
+	file, err := os.Open(q.deadLetterPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r retryRequest
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, &r)
+	}
+	return
+}
+
+func TestRetryBackoff(t *testing.T) {
+	q := &retryQueue{maxBackoff: 10 * time.Second}
+
+	for attempts, expected := range map[int]time.Duration{
+		1:   time.Second,
+		2:   2 * time.Second,
+		3:   4 * time.Second,
+		4:   8 * time.Second,
+		5:   10 * time.Second,
+		100: 10 * time.Second,
+	} {
+		if delay := q.backoff(attempts); delay != expected {
+			t.Errorf("Expected a backoff of %s after %d attempts, got: %s", expected, attempts, delay)
+		}
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	q, dir := newTestRetryQueue(t, 2)
+	defer os.RemoveAll(dir)
+
+	q.failed(newTestRequest("aaa"), "unavailable", true)
+	if len(q.requests) != 1 || q.requests[0].Attempts != 1 || !q.dirty {
+		t.Fatalf("Expected the request to be queued, got: %+v", q.requests)
+	}
+	if q.requests[0].NextTry.Before(time.Now()) {
+		t.Error("Expected the request to be retried later")
+	}
+
+	// not retryable requests are dead-lettered at once
+	q.failed(newTestRequest("bbb"), "mapper_parsing_exception", false)
+	if len(q.requests) != 1 {
+		t.Fatalf("Expected only the retryable request to be queued, got: %d", len(q.requests))
+	}
+
+	// so are the requests out of attempts
+	r := q.requests[0]
+	q.requests = nil
+	q.failed(r, "unavailable", true)
+	q.requests = nil
+	q.failed(r, "unavailable", true)
+	if len(q.requests) != 0 || r.Attempts != 3 {
+		t.Fatalf("Expected the request to be dead-lettered after 3 attempts, got: %d attempts", r.Attempts)
+	}
+
+	// the oldest requests are evicted from a full queue
+	for _, id := range []string{"ccc", "ddd", "eee"} {
+		q.failed(newTestRequest(id), "unavailable", true)
+	}
+	if len(q.requests) != 2 {
+		t.Fatalf("Expected the queue to be limited to 2 requests, got: %d", len(q.requests))
+	}
+
+	deadLetters := readDeadLetters(t, q)
+	if len(deadLetters) != 3 {
+		t.Fatalf("Expected 3 dead-lettered requests, got: %d", len(deadLetters))
+	}
+	if deadLetters[0].Error != "mapper_parsing_exception" || deadLetters[1].Attempts != 3 {
+		t.Errorf("Expected the reason and attempts of the requests, got: %+v, %+v", deadLetters[0], deadLetters[1])
+	}
+	if evicted, _ := newTestRequest("ccc").Source(); !reflect.DeepEqual(deadLetters[2].Lines, evicted) {
+		t.Errorf("Expected the oldest request to be evicted, got: %v", deadLetters[2].Lines)
+	}
+}
+
+func TestRetryAfterBulk(t *testing.T) {
+	q, dir := newTestRetryQueue(t, 100)
+	defer os.RemoveAll(dir)
+
+	// the whole commit failed
+	q.afterBulk(1, []elastic.BulkableRequest{newTestRequest("aaa"), newTestRequest("bbb")}, nil, errors.New("connection refused"))
+	if len(q.requests) != 2 {
+		t.Fatalf("Expected all the requests to be retried, got: %d", len(q.requests))
+	}
+	q.requests = nil
+
+	requests := []elastic.BulkableRequest{
+		newTestRequest("ok"),
+		elastic.NewBulkDeleteRequest().Index("skydive").Type("node").Id("gone"),
+		newTestRequest("rejected"),
+		newTestRequest("unavailable"),
+		elastic.NewBulkUpdateRequest().Index("skydive").Type("node").Id("missing").Doc(map[string]interface{}{}),
+		newTestRequest("invalid"),
+	}
+	response := &elastic.BulkResponse{
+		Errors: true,
+		Items: []map[string]*elastic.BulkResponseItem{
+			{"index": {Status: 201}},
+			{"delete": {Status: 404}},
+			{"index": {Status: 429}},
+			{"index": {Status: 503}},
+			{"update": {Status: 404}},
+			{"index": {Status: 400, Error: &elastic.ErrorDetails{Type: "mapper_parsing_exception", Reason: "failed to parse"}}},
+		},
+	}
+	q.afterBulk(2, requests, response, nil)
+
+	if len(q.requests) != 3 {
+		t.Fatalf("Expected the 429, 503 and update 404 items to be retried, got: %d", len(q.requests))
+	}
+
+	deadLetters := readDeadLetters(t, q)
+	if len(deadLetters) != 1 || deadLetters[0].Error != "mapper_parsing_exception: failed to parse" {
+		t.Errorf("Expected the invalid document to be dead-lettered, got: %+v", deadLetters)
+	}
+}
+
+func TestRetrySaveLoad(t *testing.T) {
+	q, dir := newTestRetryQueue(t, 100)
+	defer os.RemoveAll(dir)
+
+	q.failed(newTestRequest("aaa"), "unavailable", true)
+	q.failed(newTestRequest("bbb"), "unavailable", true)
+	if err := q.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 || files[0] != q.path {
+		t.Errorf("Expected only the queue file to be left, got: %v", files)
+	}
+
+	reloaded, err := newRetryQueue("test", dir, 3, 10*time.Second, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.requests) != 2 {
+		t.Fatalf("Expected the 2 requests to be reloaded, got: %d", len(reloaded.requests))
+	}
+	for i, r := range reloaded.requests {
+		saved := q.requests[i]
+		if !reflect.DeepEqual(r.Lines, saved.Lines) || r.Attempts != saved.Attempts || !r.NextTry.Equal(saved.NextTry) || r.Error != saved.Error {
+			t.Errorf("Expected the request %+v to be reloaded, got: %+v", saved, r)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Since Elasticsearch 6 an index holds a single mapping type, removed by
+// Elasticsearch 7. The indices are then typeless: the documents of all the
+// objects share the _doc type, their object being kept by DocTypeField,
+// and the _parent mappings are replaced by a join field, DocRelationField.
+const (
+	// DocTypeField is the field holding the object of the typeless documents
+	DocTypeField = "DocType"
+	// DocRelationField is the join field linking the typeless documents
+	// to their parent
+	DocRelationField = "DocRelation"
+
+	typelessDocType = "_doc"
+	typelessVersion = 6
+	// since Elasticsearch 7 the total hits of the searches are returned as
+	// an object unless rest_total_hits_as_int is set
+	totalHitsVersion = 7
+)
+
+// keywordMappings replaces the string field mappings, not supported since
+// Elasticsearch 5, by keyword mappings when not analyzed and by text
+// mappings otherwise
+func keywordMappings(i interface{}) {
+	switch v := i.(type) {
+	case map[string]interface{}:
+		if v["type"] == "string" {
+			switch v["index"] {
+			case "not_analyzed":
+				v["type"] = "keyword"
+				delete(v, "index")
+			case "no":
+				v["type"] = "keyword"
+				v["index"] = false
+			default:
+				v["type"] = "text"
+				delete(v, "index")
+			}
+		}
+		for _, value := range v {
+			keywordMappings(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			keywordMappings(value)
+		}
+	}
+}
+
+// parentTypes returns the parent object of the objects having one
+func parentTypes(mappings Mappings) map[string]string {
+	parents := make(map[string]string)
+	for _, document := range mappings {
+		for obj, data := range document {
+			var mapping struct {
+				Parent struct {
+					Type string `json:"type"`
+				} `json:"_parent"`
+			}
+			if err := json.Unmarshal(data, &mapping); err == nil && mapping.Parent.Type != "" {
+				parents[obj] = mapping.Parent.Type
+			}
+		}
+	}
+	return parents
+}
+
+// typelessMappings merges the mappings of the objects into the body of the
+// creation of a typeless index. The dynamic templates are prefixed by their
+// object, keeping their order, and the properties are merged.
+func typelessMappings(mappings Mappings, version int) (string, error) {
+	var templates []interface{}
+	properties := map[string]interface{}{
+		DocTypeField: map[string]interface{}{"type": "keyword"},
+	}
+
+	for _, document := range mappings {
+		for obj, data := range document {
+			var mapping map[string]interface{}
+			if err := json.Unmarshal(data, &mapping); err != nil {
+				return "", fmt.Errorf("Unable to parse %s mapping: %s", obj, err)
+			}
+			keywordMappings(mapping)
+
+			dynamic, _ := mapping["dynamic_templates"].([]interface{})
+			for _, template := range dynamic {
+				named, _ := template.(map[string]interface{})
+				for name, value := range named {
+					templates = append(templates, map[string]interface{}{obj + "_" + name: value})
+				}
+			}
+
+			props, _ := mapping["properties"].(map[string]interface{})
+			for name, value := range props {
+				properties[name] = value
+			}
+		}
+	}
+
+	relations := make(map[string][]string)
+	for child, parent := range parentTypes(mappings) {
+		relations[parent] = append(relations[parent], child)
+	}
+	for _, children := range relations {
+		sort.Strings(children)
+	}
+	if len(relations) > 0 {
+		properties[DocRelationField] = map[string]interface{}{
+			"type":      "join",
+			"relations": relations,
+		}
+	}
+
+	mapping := map[string]interface{}{"properties": properties}
+	if len(templates) > 0 {
+		mapping["dynamic_templates"] = templates
+	}
+
+	// a single type, _doc, is required by Elasticsearch 6
+	body := map[string]interface{}{"mappings": mapping}
+	if version < 7 {
+		body["mappings"] = map[string]interface{}{typelessDocType: mapping}
+	}
+
+	data, err := json.Marshal(body)
+	return string(data), err
+}
+
+// typelessDocument returns the JSON document of data along with its object
+// and, for the parent and the child objects, its join field
+func typelessDocument(obj string, parent string, isParent bool, data interface{}) (json.RawMessage, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) < 2 || body[0] != '{' {
+		return nil, fmt.Errorf("%s document is not an object", obj)
+	}
+
+	fields := map[string]interface{}{DocTypeField: obj}
+	if parent != "" {
+		fields[DocRelationField] = map[string]interface{}{"name": obj, "parent": parent}
+	} else if isParent {
+		fields[DocRelationField] = obj
+	}
+
+	doc, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if rest := bytes.TrimSpace(body[1:]); len(rest) > 1 {
+		doc = append(doc[:len(doc)-1], ',')
+		doc = append(doc, rest...)
+	}
+	return json.RawMessage(doc), nil
+}
+
+// totalHitsTransport sets rest_total_hits_as_int on the search requests once
+// enabled, the vendored client decoding the total hits as an integer
+type totalHitsTransport struct {
+	http.RoundTripper
+	enabled atomic.Value
+}
+
+func (t *totalHitsTransport) enable(enabled bool) {
+	t.enabled.Store(enabled)
+}
+
+// isSearchPath returns whether the path is the one of a search, a multi
+// search or a scroll
+func isSearchPath(path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	return strings.HasSuffix(path, "/_search") || strings.HasSuffix(path, "/_msearch") || strings.HasSuffix(path, "/_search/scroll")
+}
+
+// RoundTrip sets the rest_total_hits_as_int parameter of a copy of the
+// search requests
+func (t *totalHitsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if enabled, _ := t.enabled.Load().(bool); !enabled || !isSearchPath(req.URL.Path) {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	u := *req.URL
+	query := u.Query()
+	query.Set("rest_total_hits_as_int", "true")
+	u.RawQuery = query.Encode()
+
+	r := new(http.Request)
+	*r = *req
+	r.URL = &u
+
+	return t.RoundTripper.RoundTrip(r)
+}
+
+func newTotalHitsTransport(transport http.RoundTripper) *totalHitsTransport {
+	t := &totalHitsTransport{RoundTripper: transport}
+	t.enable(false)
+	return t
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+var testMappings = Mappings{
+	{"node": []byte(`{
+		"dynamic_templates": [
+			{"strings": {"match": "*", "mapping": {"type": "string", "index": "not_analyzed"}}},
+			{"numbers": {"match": "*", "mapping": {"type": "long"}}}
+		],
+		"properties": {"ID": {"type": "string", "index": "not_analyzed"}}
+	}`)},
+	{"edge": []byte(`{"properties": {"Parent": {"type": "string", "index": "not_analyzed"}}}`)},
+	{"metric": []byte(`{"_parent": {"type": "node"}, "properties": {"Value": {"type": "long"}}}`)},
+	{"event": []byte(`{"_parent": {"type": "node"}, "properties": {"Text": {"type": "string"}}}`)},
+}
+
+func unmarshalMappings(t *testing.T, body string) map[string]interface{} {
+	var mappings map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &mappings); err != nil {
+		t.Fatal(err)
+	}
+	return mappings
+}
+
+func TestKeywordMappings(t *testing.T) {
+	var mapping interface{}
+	if err := json.Unmarshal([]byte(`{
+		"properties": {
+			"ID": {"type": "string", "index": "not_analyzed"},
+			"Blob": {"type": "string", "index": "no"},
+			"Text": {"type": "string"},
+			"Count": {"type": "long"}
+		},
+		"dynamic_templates": [
+			{"strings": {"mapping": {"type": "string", "index": "not_analyzed"}}}
+		]
+	}`), &mapping); err != nil {
+		t.Fatal(err)
+	}
+	keywordMappings(mapping)
+
+	var expected interface{}
+	json.Unmarshal([]byte(`{
+		"properties": {
+			"ID": {"type": "keyword"},
+			"Blob": {"type": "keyword", "index": false},
+			"Text": {"type": "text"},
+			"Count": {"type": "long"}
+		},
+		"dynamic_templates": [
+			{"strings": {"mapping": {"type": "keyword"}}}
+		]
+	}`), &expected)
+
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("Expected the mappings %+v, got: %+v", expected, mapping)
+	}
+}
+
+func TestTypelessMappings(t *testing.T) {
+	body, err := typelessMappings(testMappings, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Elasticsearch 6 requires the single _doc type
+	mappings, ok := unmarshalMappings(t, body)["mappings"].(map[string]interface{})
+	if !ok || len(mappings) != 1 {
+		t.Fatalf("Expected the mappings of a single type, got: %s", body)
+	}
+	mapping, ok := mappings[typelessDocType].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected the mappings of the %s type, got: %s", typelessDocType, body)
+	}
+
+	body, err = typelessMappings(testMappings, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// while Elasticsearch 7 has no type at all
+	if mappings := unmarshalMappings(t, body)["mappings"]; !reflect.DeepEqual(mappings, mapping) {
+		t.Fatalf("Expected the typeless mappings %+v, got: %+v", mapping, mappings)
+	}
+
+	properties := mapping["properties"].(map[string]interface{})
+	for _, name := range []string{DocTypeField, "ID", "Parent", "Value", "Text"} {
+		if _, found := properties[name]; !found {
+			t.Errorf("Expected the %s property to be merged, got: %+v", name, properties)
+		}
+	}
+	if typ := properties["ID"].(map[string]interface{})["type"]; typ != "keyword" {
+		t.Errorf("Expected the string properties to be converted, got: %s", typ)
+	}
+
+	var expected interface{}
+	json.Unmarshal([]byte(`{"type": "join", "relations": {"node": ["event", "metric"]}}`), &expected)
+	if relation := properties[DocRelationField]; !reflect.DeepEqual(relation, expected) {
+		t.Errorf("Expected the join field %+v, got: %+v", expected, relation)
+	}
+
+	var templates []string
+	for _, template := range mapping["dynamic_templates"].([]interface{}) {
+		for name := range template.(map[string]interface{}) {
+			templates = append(templates, name)
+		}
+	}
+	if expected := []string{"node_strings", "node_numbers"}; !reflect.DeepEqual(templates, expected) {
+		t.Errorf("Expected the dynamic templates %v, got: %v", expected, templates)
+	}
+
+	if _, err := typelessMappings(Mappings{{"node": []byte("{")}}, 7); err == nil {
+		t.Error("Expected an error with an invalid mapping")
+	}
+}
+
+func TestTypelessMappingsWithoutRelations(t *testing.T) {
+	body, err := typelessMappings(Mappings{{"node": []byte(`{"properties": {"ID": {"type": "keyword"}}}`)}}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapping := unmarshalMappings(t, body)["mappings"].(map[string]interface{})
+	if _, found := mapping["properties"].(map[string]interface{})[DocRelationField]; found {
+		t.Errorf("Expected no join field without parent, got: %s", body)
+	}
+	if _, found := mapping["dynamic_templates"]; found {
+		t.Errorf("Expected no dynamic templates, got: %s", body)
+	}
+}
+
+func TestTypelessDocument(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		obj      string
+		parent   string
+		isParent bool
+		data     interface{}
+		expected string
+	}{
+		{
+			name:     "plain",
+			obj:      "edge",
+			data:     map[string]interface{}{"ID": "aaa"},
+			expected: `{"DocType": "edge", "ID": "aaa"}`,
+		},
+		{
+			name:     "parent",
+			obj:      "node",
+			isParent: true,
+			data:     map[string]interface{}{"ID": "aaa"},
+			expected: `{"DocType": "node", "DocRelation": "node", "ID": "aaa"}`,
+		},
+		{
+			name:     "child",
+			obj:      "metric",
+			parent:   "aaa",
+			data:     map[string]interface{}{"Value": 1},
+			expected: `{"DocType": "metric", "DocRelation": {"name": "metric", "parent": "aaa"}, "Value": 1}`,
+		},
+		{
+			name:     "empty",
+			obj:      "edge",
+			data:     struct{}{},
+			expected: `{"DocType": "edge"}`,
+		},
+	} {
+		doc, err := typelessDocument(test.obj, test.parent, test.isParent, test.data)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		var actual, expected interface{}
+		if err := json.Unmarshal(doc, &actual); err != nil {
+			t.Fatalf("%s: invalid document %s: %s", test.name, string(doc), err)
+		}
+		json.Unmarshal([]byte(test.expected), &expected)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected the document %s, got: %s", test.name, test.expected, string(doc))
+		}
+	}
+
+	if _, err := typelessDocument("node", "", false, []string{"aaa"}); err == nil {
+		t.Error("Expected an error with a document not being an object")
+	}
+}
+
+func TestTotalHitsTransport(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+	}))
+	defer server.Close()
+
+	transport := newTotalHitsTransport(http.DefaultTransport)
+	client := &http.Client{Transport: transport}
+
+	get := func(path string) string {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return query
+	}
+
+	if q := get("/skydive/_search?size=1"); q != "size=1" {
+		t.Errorf("Expected the query to be kept before Elasticsearch 7, got: %s", q)
+	}
+
+	transport.enable(true)
+	for _, path := range []string{"/_search", "/skydive/_search?size=1", "/_msearch", "/_search/scroll"} {
+		values, err := url.ParseQuery(get(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values.Get("rest_total_hits_as_int") != "true" {
+			t.Errorf("Expected rest_total_hits_as_int to be set for %s, got: %s", path, values.Encode())
+		}
+	}
+	if q := get("/skydive/_doc/aaa"); q != "" {
+		t.Errorf("Expected only the searches to be changed, got: %s", q)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	elastic "github.com/olivere/elastic"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// aliasAction describes the addition or the removal of an index to an alias
+type aliasAction struct {
+	remove bool
+	index  string
+	alias  string
+}
+
+// indexMappingsVersion returns the mappings version of an index named
+// <prefix><version>[_<suffix>], false if the index doesn't match
+func indexMappingsVersion(index, prefix string) (int, bool) {
+	if !strings.HasPrefix(index, prefix) {
+		return 0, false
+	}
+
+	version := strings.TrimPrefix(index, prefix)
+	if i := strings.Index(version, "_"); i != -1 {
+		version = version[:i]
+	}
+
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// filterPreviousIndices returns, sorted, the indices of a mappings version
+// lower than the given one
+func filterPreviousIndices(indices []string, prefix string, version int) []string {
+	var previous []string
+	for _, index := range indices {
+		if v, ok := indexMappingsVersion(index, prefix); ok && v < version {
+			previous = append(previous, index)
+		}
+	}
+	sort.Strings(previous)
+
+	return previous
+}
+
+// rollingAliasActions returns the actions moving the rolling alias from the
+// indices currently owning it to the given index, also added to the all
+// alias
+func rollingAliasActions(aliased []string, index, alias, allAlias string) []aliasAction {
+	sort.Strings(aliased)
+
+	var actions []aliasAction
+	for _, a := range aliased {
+		if strings.HasPrefix(a, alias) {
+			actions = append(actions, aliasAction{remove: true, index: a, alias: alias})
+		}
+	}
+
+	return append(actions,
+		aliasAction{index: index, alias: alias},
+		aliasAction{index: index, alias: allAlias},
+	)
+}
+
+// upgradeAliasActions returns the actions switching the all alias from the
+// indices of the previous mappings versions to the upgrade index. The
+// addition comes first so that the history is always reachable.
+func upgradeAliasActions(previous []string, index, allAlias string) []aliasAction {
+	actions := []aliasAction{{index: index, alias: allAlias}}
+	for _, p := range previous {
+		actions = append(actions, aliasAction{remove: true, index: p, alias: allAlias})
+	}
+	return actions
+}
+
+// applyAliasActions applies atomically the actions on the aliases
+func (c *ElasticSearchClient) applyAliasActions(ctx context.Context, actions []aliasAction) error {
+	aliasServer := c.client.Alias()
+	for _, a := range actions {
+		if a.remove {
+			aliasServer.Remove(a.index, a.alias)
+		} else {
+			aliasServer.Add(a.index, a.alias)
+		}
+	}
+
+	_, err := aliasServer.Do(ctx)
+	return err
+}
+
+// previousIndices returns the indices of the previous mappings versions
+func (c *ElasticSearchClient) previousIndices() ([]string, error) {
+	indices, err := c.client.IndexNames()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := fmt.Sprintf("%s_%s_v", indexPrefix, c.name)
+	return filterPreviousIndices(indices, prefix, c.mappingsVersion()), nil
+}
+
+// upgrade reindexes in the background the documents of the indices of the
+// previous mappings versions into an index of the current mappings. The all
+// alias is then switched atomically from the previous indices to this one
+// before deleting them, so that the history is never seen twice. The
+// upgrade is started over on the next start if interrupted.
+func (c *ElasticSearchClient) upgrade(previous []string) {
+	defer c.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-c.quit:
+			cancel()
+		case <-done:
+			cancel()
+		}
+	}()
+
+	index := c.getUpgradeIndexPath()
+	logging.GetLogger().Infof("Upgrading the %s indices %v to %s", c.name, previous, index)
+
+	if err := c.reindex(ctx, previous, index); err != nil {
+		logging.GetLogger().Errorf("Failed to upgrade the %s indices: %s", c.name, err)
+		return
+	}
+
+	allAlias := c.GetIndexAllAlias()
+	if err := c.applyAliasActions(ctx, upgradeAliasActions(previous, index, allAlias)); err != nil {
+		logging.GetLogger().Errorf("Failed to switch the %s alias to %s: %s", allAlias, index, err)
+		return
+	}
+
+	if _, err := c.client.DeleteIndex(previous...).Do(ctx); err != nil {
+		logging.GetLogger().Errorf("Failed to delete the %s indices %v: %s", c.name, previous, err)
+		return
+	}
+
+	logging.GetLogger().Infof("%s indices upgraded to %s", c.name, index)
+}
+
+// reindex copies the documents of the indices into a new index created
+// with the current mappings
+func (c *ElasticSearchClient) reindex(ctx context.Context, indices []string, index string) error {
+	// a leftover of an interrupted upgrade is started over
+	if exists, _ := c.client.IndexExists(index).Do(ctx); exists {
+		if _, err := c.client.DeleteIndex(index).Do(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := c.newIndex(ctx, index); err != nil {
+		return err
+	}
+
+	for _, source := range indices {
+		reindex := c.client.Reindex().
+			SourceIndex(source).
+			DestinationIndex(index).
+			WaitForCompletion(true).
+			Refresh("true")
+
+		if c.cfg.UpgradeScript != "" {
+			script := elastic.NewScript(c.cfg.UpgradeScript).
+				Lang("painless").
+				Param("now", common.UnixMillis(time.Now()))
+			reindex = reindex.Script(script)
+		}
+
+		resp, err := reindex.Do(ctx)
+		if err != nil {
+			return fmt.Errorf("reindex of %s failed: %s", source, err)
+		}
+		if len(resp.Failures) > 0 {
+			return fmt.Errorf("reindex of %s failed for %d documents", source, len(resp.Failures))
+		}
+
+		logging.GetLogger().Infof("%d documents of %s reindexed into %s", resp.Created, source, index)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexMappingsVersion(t *testing.T) {
+	tests := []struct {
+		index   string
+		version int
+		ok      bool
+	}{
+		{"skydive_flow_v12", 12, true},
+		{"skydive_flow_v12_2018-05-02_10-00-00", 12, true},
+		{"skydive_flow_v12_upgrade", 12, true},
+		{"skydive_flow_vx", 0, false},
+		{"skydive_flow_archive_v1", 0, false},
+		{"skydive_topology_v1", 0, false},
+	}
+
+	for _, test := range tests {
+		version, ok := indexMappingsVersion(test.index, "skydive_flow_v")
+		if version != test.version || ok != test.ok {
+			t.Errorf("Expected version %d (%v) for %s, got: %d (%v)", test.version, test.ok, test.index, version, ok)
+		}
+	}
+}
+
+func TestFilterPreviousIndices(t *testing.T) {
+	indices := []string{
+		"skydive_flow_v3_2018-05-02_10-00-00",
+		"skydive_flow_v12_upgrade",
+		"skydive_flow_v12",
+		"skydive_flow_v11_2018-05-01_10-00-00",
+		"skydive_flow_v3_2018-05-01_10-00-00",
+		"skydive_flow_vx",
+		"skydive_topology_v3",
+		"skydive_flow_v13",
+	}
+
+	expected := []string{
+		"skydive_flow_v11_2018-05-01_10-00-00",
+		"skydive_flow_v3_2018-05-01_10-00-00",
+		"skydive_flow_v3_2018-05-02_10-00-00",
+	}
+	if previous := filterPreviousIndices(indices, "skydive_flow_v", 12); !reflect.DeepEqual(previous, expected) {
+		t.Errorf("Expected the indices of the previous versions %v, got: %v", expected, previous)
+	}
+
+	if previous := filterPreviousIndices(indices, "skydive_flow_v", 3); previous != nil {
+		t.Errorf("Expected no indices before the first version, got: %v", previous)
+	}
+}
+
+func TestRollingAliasActions(t *testing.T) {
+	aliased := []string{
+		"skydive_flow_v12_2018-05-02_10-00-00",
+		"skydive_topology_v12",
+		"skydive_flow_v12_2018-05-01_10-00-00",
+	}
+
+	expected := []aliasAction{
+		{remove: true, index: "skydive_flow_v12_2018-05-01_10-00-00", alias: "skydive_flow"},
+		{remove: true, index: "skydive_flow_v12_2018-05-02_10-00-00", alias: "skydive_flow"},
+		{index: "skydive_flow_v12_2018-05-03_10-00-00", alias: "skydive_flow"},
+		{index: "skydive_flow_v12_2018-05-03_10-00-00", alias: "skydive_flow_all"},
+	}
+
+	actions := rollingAliasActions(aliased, "skydive_flow_v12_2018-05-03_10-00-00", "skydive_flow", "skydive_flow_all")
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected the rolling alias to be moved to the new index %v, got: %v", expected, actions)
+	}
+
+	expected = []aliasAction{
+		{index: "skydive_flow_v12", alias: "skydive_flow"},
+		{index: "skydive_flow_v12", alias: "skydive_flow_all"},
+	}
+	if actions := rollingAliasActions(nil, "skydive_flow_v12", "skydive_flow", "skydive_flow_all"); !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected the aliases to be added to the first index %v, got: %v", expected, actions)
+	}
+}
+
+func TestUpgradeAliasActions(t *testing.T) {
+	previous := []string{"skydive_flow_v11", "skydive_flow_v3_2018-05-01_10-00-00"}
+
+	expected := []aliasAction{
+		{index: "skydive_flow_v12_upgrade", alias: "skydive_flow_all"},
+		{remove: true, index: "skydive_flow_v11", alias: "skydive_flow_all"},
+		{remove: true, index: "skydive_flow_v3_2018-05-01_10-00-00", alias: "skydive_flow_all"},
+	}
+
+	actions := upgradeAliasActions(previous, "skydive_flow_v12_upgrade", "skydive_flow_all")
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected the all alias to be switched to the upgrade index %v, got: %v", expected, actions)
+	}
+
+	// the rolling alias is left to the current index
+	for _, a := range actions {
+		if a.alias != "skydive_flow_all" {
+			t.Errorf("Expected only the all alias to be switched, got: %v", a)
+		}
+	}
+}
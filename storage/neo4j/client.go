@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package neo4j
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+)
+
+// Statement describes a Cypher statement and its parameters
+type Statement struct {
+	Statement  string                 `json:"statement"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Result describes the rows returned by a statement
+type Result struct {
+	Columns []string `json:"columns"`
+	Data    []struct {
+		Row []interface{} `json:"row"`
+	} `json:"data"`
+}
+
+// Error describes a Neo4j error
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type transaction struct {
+	Statements []Statement `json:"statements"`
+}
+
+type transactionResult struct {
+	Results []Result `json:"results"`
+	Errors  []Error  `json:"errors"`
+}
+
+// Client describes a Neo4j client using the transactional HTTP endpoint
+type Client struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+}
+
+// Run executes the statements within a single transaction
+func (c *Client) Run(statements ...Statement) ([]Result, error) {
+	body, err := json.Marshal(&transaction{Statements: statements})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("POST", c.url+"/db/data/transaction/commit", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json; charset=UTF-8")
+	if c.username != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("Neo4j request failed: %s", resp.Status)
+	}
+
+	var result transactionResult
+	if err := common.JSONDecode(resp.Body, &result); err != nil {
+		return nil, fmt.Errorf("Error while parsing Neo4j response: %s", err)
+	}
+
+	if len(result.Errors) > 0 {
+		var messages []string
+		for _, e := range result.Errors {
+			messages = append(messages, e.Code+": "+e.Message)
+		}
+		return nil, errors.New(strings.Join(messages, "\n"))
+	}
+
+	return result.Results, nil
+}
+
+// Query describes a Cypher condition being built, holding the values of its
+// parameters
+type Query struct {
+	Params map[string]interface{}
+}
+
+// NewQuery returns a new Cypher query
+func NewQuery() *Query {
+	return &Query{Params: make(map[string]interface{})}
+}
+
+// Param adds a parameter to the query and returns its reference
+func (q *Query) Param(v interface{}) string {
+	name := fmt.Sprintf("p%d", len(q.Params))
+	q.Params[name] = v
+	return "$" + name
+}
+
+// Property returns the Cypher expression of a property of a variable, quoted
+// as the keys of the flattened metadata contain dots
+func Property(variable, key string) string {
+	return variable + ".`" + strings.Replace(key, "`", "``", -1) + "`"
+}
+
+// FilterToExpression returns the Cypher condition of the filter. Like for the
+// other backends, a term filter on a list matches any of its values, the
+// properties being turned into lists by the concatenation.
+func (q *Query) FilterToExpression(f *filters.Filter, formatter func(string) string) string {
+	if f.BoolFilter != nil {
+		keyword := ""
+		switch f.BoolFilter.Op {
+		case filters.BoolFilterOp_NOT:
+			return "NOT (" + q.FilterToExpression(f.BoolFilter.Filters[0], formatter) + ")"
+		case filters.BoolFilterOp_OR:
+			keyword = "OR"
+		case filters.BoolFilterOp_AND:
+			keyword = "AND"
+		}
+		var conditions []string
+		for _, item := range f.BoolFilter.Filters {
+			if expr := q.FilterToExpression(item, formatter); expr != "" {
+				conditions = append(conditions, "("+expr+")")
+			}
+		}
+		return strings.Join(conditions, " "+keyword+" ")
+	}
+
+	if f.TermStringFilter != nil {
+		return fmt.Sprintf("%s IN ([] + %s)", q.Param(f.TermStringFilter.Value), formatter(f.TermStringFilter.Key))
+	}
+
+	if f.TermInt64Filter != nil {
+		return fmt.Sprintf("%s IN ([] + %s)", q.Param(f.TermInt64Filter.Value), formatter(f.TermInt64Filter.Key))
+	}
+
+	if f.GtInt64Filter != nil {
+		return fmt.Sprintf("%s > %s", formatter(f.GtInt64Filter.Key), q.Param(f.GtInt64Filter.Value))
+	}
+
+	if f.LtInt64Filter != nil {
+		return fmt.Sprintf("%s < %s", formatter(f.LtInt64Filter.Key), q.Param(f.LtInt64Filter.Value))
+	}
+
+	if f.GteInt64Filter != nil {
+		return fmt.Sprintf("%s >= %s", formatter(f.GteInt64Filter.Key), q.Param(f.GteInt64Filter.Value))
+	}
+
+	if f.LteInt64Filter != nil {
+		return fmt.Sprintf("%s <= %s", formatter(f.LteInt64Filter.Key), q.Param(f.LteInt64Filter.Value))
+	}
+
+	if f.RegexFilter != nil {
+		return fmt.Sprintf("%s =~ %s", formatter(f.RegexFilter.Key), q.Param(f.RegexFilter.Value))
+	}
+
+	if f.NullFilter != nil {
+		return fmt.Sprintf("%s IS NULL", formatter(f.NullFilter.Key))
+	}
+
+	if f.IPV4RangeFilter != nil {
+		// ignore the error at this point it should have been catched earlier
+		regex, _ := common.IPV4CIDRToRegex(f.IPV4RangeFilter.Value)
+
+		return fmt.Sprintf("%s =~ %s", formatter(f.IPV4RangeFilter.Key), q.Param(regex))
+	}
+
+	return ""
+}
+
+// NewClient creates a new Neo4j client, checking the server is reachable
+func NewClient(url string, username string, password string) (*Client, error) {
+	client := &Client{
+		url:      strings.TrimSuffix(url, "/"),
+		username: username,
+		password: password,
+		client:   &http.Client{},
+	}
+
+	if _, err := client.Run(Statement{Statement: "RETURN 1"}); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const timeout = 5 * time.Second
+
+// ClientInterface describes the mechanism API of a Redis client
+type ClientInterface interface {
+	Do(args ...string) (interface{}, error)
+	Pipeline(commands ...[]string) ([]interface{}, error)
+}
+
+// Error describes an error replied by the Redis server
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Client describes a Redis client speaking the RESP protocol over a single
+// connection, dialed again after a network error
+type Client struct {
+	sync.Mutex
+	addr     string
+	password string
+	db       int
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+func writeCommand(w *bytes.Buffer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("Invalid Redis reply: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply reads a reply, the bulk strings being returned as strings, a
+// nil bulk string or array as nil and the errors as Error values
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, count)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("Invalid Redis reply: %q", line)
+}
+
+// firstError returns the first error replied, within the transactions too
+func firstError(replies []interface{}) error {
+	for _, reply := range replies {
+		switch reply := reply.(type) {
+		case Error:
+			return reply
+		case []interface{}:
+			if err := firstError(reply); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, timeout)
+	if err != nil {
+		return err
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+
+	var commands [][]string
+	if c.password != "" {
+		commands = append(commands, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+
+	if len(commands) > 0 {
+		replies, err := c.send(commands)
+		if err == nil {
+			err = firstError(replies)
+		}
+		if err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.reader = nil, nil
+	}
+}
+
+func (c *Client) send(commands [][]string) ([]interface{}, error) {
+	var buffer bytes.Buffer
+	for _, args := range commands {
+		writeCommand(&buffer, args)
+	}
+
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.conn.Write(buffer.Bytes()); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	for i := range commands {
+		reply, err := readReply(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// Pipeline sends the commands at once and returns their replies, the
+// error being the first one replied if any
+func (c *Client) Pipeline(commands ...[]string) ([]interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	replies, err := c.send(commands)
+	if err != nil {
+		// the connection state is unknown after a network error
+		c.close()
+		return nil, err
+	}
+
+	return replies, firstError(replies)
+}
+
+// Do sends a command and returns its reply
+func (c *Client) Do(args ...string) (interface{}, error) {
+	replies, err := c.Pipeline(args)
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Close closes the connection
+func (c *Client) Close() {
+	c.Lock()
+	c.close()
+	c.Unlock()
+}
+
+// NewClient creates a new Redis client connected to the server
+func NewClient(addr string, password string, db int) (*Client, error) {
+	client := &Client{addr: addr, password: password, db: db}
+
+	reply, err := client.Do("PING")
+	if err != nil {
+		return nil, err
+	}
+	if reply != "PONG" {
+		return nil, errors.New("Unexpected Redis reply to PING")
+	}
+
+	return client, nil
+}
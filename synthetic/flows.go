@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package synthetic
+
+import (
+	"math/rand"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+)
+
+var applications = []struct {
+	protocol flow.FlowProtocol
+	name     string
+	port     int64
+}{
+	{flow.FlowProtocol_TCP, "HTTP", 80},
+	{flow.FlowProtocol_TCP, "HTTPS", 443},
+	{flow.FlowProtocol_TCP, "SSH", 22},
+	{flow.FlowProtocol_TCP, "MySQL", 3306},
+	{flow.FlowProtocol_UDP, "DNS", 53},
+	{flow.FlowProtocol_UDP, "NTP", 123},
+}
+
+// FlowOpts describes the synthetic flow stream
+type FlowOpts struct {
+	Flows    int   // number of concurrent flows
+	Lifetime int64 // average lifetime of a flow in milliseconds
+}
+
+// FlowGenerator generates flows between the endpoints of a synthetic topology,
+// the active flows are updated at each tick and replaced when they end
+type FlowGenerator struct {
+	opts      FlowOpts
+	endpoints []Endpoint
+	rand      *rand.Rand
+	flows     []*flow.Flow
+	ends      []int64
+}
+
+// NewFlowOptsFromConfig returns the flow options of the seed section of the
+// configuration
+func NewFlowOptsFromConfig() FlowOpts {
+	return FlowOpts{
+		Flows:    config.GetInt("seed.flows.count"),
+		Lifetime: int64(config.GetInt("seed.flows.lifetime")) * 1000,
+	}
+}
+
+func (fg *FlowGenerator) newFlow(now int64) *flow.Flow {
+	src := fg.endpoints[fg.rand.Intn(len(fg.endpoints))]
+	dst := fg.endpoints[fg.rand.Intn(len(fg.endpoints))]
+	for len(fg.endpoints) > 1 && dst.TID == src.TID {
+		dst = fg.endpoints[fg.rand.Intn(len(fg.endpoints))]
+	}
+	app := applications[fg.rand.Intn(len(applications))]
+
+	f := flow.NewFlow()
+	f.Init(now, src.TID, flow.FlowUUIDs{})
+
+	transport := "TCP"
+	if app.protocol == flow.FlowProtocol_UDP {
+		transport = "UDP"
+	}
+	f.LayersPath = "Ethernet/IPv4/" + transport
+	f.Application = app.name
+	f.Link = &flow.FlowLayer{Protocol: flow.FlowProtocol_ETHERNET, A: src.MAC, B: dst.MAC}
+	f.Network = &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: src.IP, B: dst.IP}
+	f.Transport = &flow.TransportLayer{Protocol: app.protocol, A: 32768 + fg.rand.Int63n(28232), B: app.port}
+	f.UpdateUUID(src.TID, flow.FlowOpts{})
+
+	return f
+}
+
+// lifetime returns a random lifetime centered on the average one
+func (fg *FlowGenerator) lifetime() int64 {
+	if fg.opts.Lifetime <= 0 {
+		return 0
+	}
+	return fg.opts.Lifetime/2 + fg.rand.Int63n(fg.opts.Lifetime)
+}
+
+func (fg *FlowGenerator) update(f *flow.Flow, now int64) {
+	abPackets := 1 + fg.rand.Int63n(100)
+	baPackets := 1 + fg.rand.Int63n(100)
+
+	f.LastUpdateMetric = &flow.FlowMetric{
+		ABPackets: abPackets,
+		ABBytes:   abPackets * (64 + fg.rand.Int63n(1436)),
+		BAPackets: baPackets,
+		BABytes:   baPackets * (64 + fg.rand.Int63n(1436)),
+		Start:     f.Last,
+		Last:      now,
+	}
+
+	f.Metric.ABPackets += f.LastUpdateMetric.ABPackets
+	f.Metric.ABBytes += f.LastUpdateMetric.ABBytes
+	f.Metric.BAPackets += f.LastUpdateMetric.BAPackets
+	f.Metric.BABytes += f.LastUpdateMetric.BABytes
+	f.Metric.Last = now
+	f.Last = now
+}
+
+// Tick updates the active flows and returns them, the flows that reached the
+// end of their lifetime are returned one last time before being replaced
+func (fg *FlowGenerator) Tick(now int64) []*flow.Flow {
+	if len(fg.endpoints) == 0 {
+		return nil
+	}
+
+	var flows []*flow.Flow
+	for i := 0; i < fg.opts.Flows; i++ {
+		if i >= len(fg.flows) {
+			fg.flows = append(fg.flows, fg.newFlow(now))
+			fg.ends = append(fg.ends, now+fg.lifetime())
+			flows = append(flows, fg.flows[i])
+			continue
+		}
+
+		f := fg.flows[i]
+		fg.update(f, now)
+		flows = append(flows, f)
+
+		if fg.ends[i] <= now {
+			fg.flows[i] = fg.newFlow(now)
+			fg.ends[i] = now + fg.lifetime()
+		}
+	}
+
+	return flows
+}
+
+// NewFlowGenerator returns a new flow generator for the endpoints of the given
+// topology, the same seed gives the same flows
+func NewFlowGenerator(t *Topology, opts FlowOpts, seed int64) *FlowGenerator {
+	return &FlowGenerator{
+		opts:      opts,
+		endpoints: t.Endpoints,
+		rand:      rand.New(rand.NewSource(seed)),
+	}
+}
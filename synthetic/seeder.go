@@ -0,0 +1,148 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package synthetic
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/analyzer"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Seeder publishes a synthetic topology to the analyzers and streams synthetic
+// flows on its interfaces
+type Seeder struct {
+	shttp.DefaultWSSpeakerEventHandler
+	graph    *graph.Graph
+	pool     *shttp.WSStructClientPool
+	flowPool *analyzer.FlowClientPool
+	flows    *FlowGenerator
+	interval time.Duration
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// OnConnected sends the whole synthetic topology to the analyzer
+func (s *Seeder) OnConnected(c shttp.WSSpeaker) {
+	s.graph.RLock()
+	defer s.graph.RUnlock()
+
+	logging.GetLogger().Infof("Seeding %d nodes and %d edges to %s", len(s.graph.GetNodes(nil)), len(s.graph.GetEdges(nil)), c.GetURL())
+
+	msg := shttp.NewWSStructMessage(graph.Namespace, graph.SyncMsgType, s.graph)
+	if err := c.SendMessage(msg); err != nil {
+		logging.GetLogger().Errorf("Failed to send the synthetic topology to %s: %s", c.GetURL(), err)
+	}
+}
+
+func (s *Seeder) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case t := <-ticker.C:
+			if flows := s.flows.Tick(common.UnixMillis(t)); len(flows) > 0 {
+				s.flowPool.SendFlows(flows)
+			}
+		}
+	}
+}
+
+// Start connects to the analyzers and starts the flow stream
+func (s *Seeder) Start() {
+	s.pool.ConnectAll()
+
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop the flow stream and disconnects from the analyzers, the synthetic
+// topology is removed from the analyzers
+func (s *Seeder) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+
+	s.flowPool.Close()
+	s.pool.DisconnectAll()
+}
+
+// NewSeederFromConfig returns a new seeder publishing the synthetic topology
+// described by the seed section of the configuration
+func NewSeederFromConfig(authOptions *shttp.AuthenticationOpts) (*Seeder, error) {
+	hostID := config.GetString("seed.host_id")
+
+	addresses, err := config.GetAnalyzerServiceAddresses()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the analyzers list: %s", err.Error())
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("No analyzer to seed")
+	}
+
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		return nil, err
+	}
+	g := graph.NewGraph(hostID, backend)
+
+	topology := NewTopology(g, NewTopologyOptsFromConfig())
+	g.Lock()
+	topology.Populate()
+	g.Unlock()
+
+	interval := time.Duration(config.GetInt("seed.flows.update")) * time.Second
+	if interval <= 0 {
+		return nil, errors.New("seed.flows.update has to be a positive number of seconds")
+	}
+
+	pool := shttp.NewWSStructClientPool("SeederClientPool")
+	for _, sa := range addresses {
+		authClient := shttp.NewAuthenticationClient(config.GetURL("http", sa.Addr, sa.Port, ""), authOptions)
+		url := config.GetURL("ws", sa.Addr, sa.Port, "/ws/publisher")
+		pool.AddClient(shttp.NewWSClient(hostID, common.UnknownService, url, authClient, nil, config.GetInt("http.ws.queue_size")))
+	}
+
+	s := &Seeder{
+		graph:    g,
+		pool:     pool,
+		flowPool: analyzer.NewFlowClientPool(pool),
+		flows:    NewFlowGenerator(topology, NewFlowOptsFromConfig(), int64(config.GetInt("seed.random_seed"))),
+		interval: interval,
+		quit:     make(chan struct{}),
+	}
+	pool.AddEventHandler(s)
+
+	return s, nil
+}
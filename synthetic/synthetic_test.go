@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package synthetic
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func newTestTopology(t *testing.T, opts TopologyOpts) (*graph.Graph, *Topology) {
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("test", backend)
+
+	topology := NewTopology(g, opts)
+	topology.Populate()
+
+	return g, topology
+}
+
+func TestPopulate(t *testing.T) {
+	opts := TopologyOpts{Hosts: 3, Interfaces: 2, Pods: 4, K8s: true}
+	g, topology := newTestTopology(t, opts)
+
+	// cluster and namespaces, then host, bridge, interfaces and per pod the
+	// netns, both ends of the veth and the pod
+	expected := 5 + opts.Hosts*(2+opts.Interfaces+opts.Pods*4)
+	if nodes := g.GetNodes(nil); len(nodes) != expected {
+		t.Errorf("Expected %d nodes, got %d", expected, len(nodes))
+	}
+
+	if pods := g.GetNodes(graph.Metadata{"Type": "pod"}); len(pods) != opts.Hosts*opts.Pods {
+		t.Errorf("Expected %d pods, got %d", opts.Hosts*opts.Pods, len(pods))
+	}
+
+	expected = opts.Hosts * (opts.Interfaces + opts.Pods)
+	if len(topology.Endpoints) != expected {
+		t.Errorf("Expected %d endpoints, got %d", expected, len(topology.Endpoints))
+	}
+
+	for _, e := range topology.Endpoints {
+		if n := g.GetNode(graph.Identifier(e.TID)); n == nil {
+			t.Errorf("No node for the endpoint %+v", e)
+		}
+	}
+
+	// the identifiers don't depend on the run
+	g2, _ := newTestTopology(t, opts)
+	for _, n := range g.GetNodes(nil) {
+		if g2.GetNode(n.ID) == nil {
+			t.Errorf("Node %s not generated twice", n.ID)
+		}
+	}
+}
+
+func TestFlowGenerator(t *testing.T) {
+	_, topology := newTestTopology(t, TopologyOpts{Hosts: 2, Interfaces: 1, Pods: 2})
+
+	fg := NewFlowGenerator(topology, FlowOpts{Flows: 10, Lifetime: 2000}, 0)
+
+	flows := fg.Tick(1000)
+	if len(flows) != 10 {
+		t.Fatalf("Expected 10 flows, got %d", len(flows))
+	}
+
+	uuids := make(map[string]bool)
+	for _, f := range flows {
+		if f.Network.A == f.Network.B {
+			t.Errorf("Flow between the same endpoint: %v", f)
+		}
+		uuids[f.UUID] = true
+	}
+
+	flows = fg.Tick(2000)
+	for _, f := range flows {
+		if !uuids[f.UUID] {
+			t.Errorf("Flow %s not updated", f.UUID)
+		}
+		if f.Last != 2000 || f.Metric.ABPackets == 0 || f.LastUpdateMetric == nil {
+			t.Errorf("Wrong metrics of the updated flow: %v", f)
+		}
+	}
+
+	// all the flows end after 1.5 times the lifetime at most
+	fg.Tick(5000)
+	for _, f := range fg.Tick(6000) {
+		if uuids[f.UUID] {
+			t.Errorf("Flow %s should have been replaced", f.UUID)
+		}
+	}
+}
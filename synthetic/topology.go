@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package synthetic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	// ProbeName is the value of the Probe field of the synthetic nodes
+	ProbeName = "synthetic"
+
+	idNamespace = "synthetic"
+)
+
+// TopologyOpts describes the synthetic topology to generate
+type TopologyOpts struct {
+	Hosts      int  // number of hosts
+	Interfaces int  // number of physical interfaces per host
+	Pods       int  // number of pods per host, each in its own namespace
+	K8s        bool // add the k8s layer, cluster, namespaces and pods
+}
+
+// Endpoint is an interface of the synthetic topology flows can be generated for
+type Endpoint struct {
+	TID string
+	MAC string
+	IP  string
+}
+
+// Topology holds the synthetic nodes created in a graph
+type Topology struct {
+	opts      TopologyOpts
+	g         *graph.Graph
+	Endpoints []Endpoint
+}
+
+// NewTopologyOptsFromConfig returns the topology options of the seed section
+// of the configuration
+func NewTopologyOptsFromConfig() TopologyOpts {
+	return TopologyOpts{
+		Hosts:      config.GetInt("seed.topology.hosts"),
+		Interfaces: config.GetInt("seed.topology.interfaces"),
+		Pods:       config.GetInt("seed.topology.pods"),
+		K8s:        config.GetBool("seed.topology.k8s"),
+	}
+}
+
+// the identifiers are name based so that seeding twice gives the same topology
+func (t *Topology) newNode(name string, m graph.Metadata) *graph.Node {
+	id := graph.GenIDNameBased(idNamespace, name)
+
+	m["Probe"] = ProbeName
+	m["TID"] = string(id)
+
+	return t.g.NewNode(id, m)
+}
+
+func (t *Topology) addInterface(parent *graph.Node, name, ty, mac, ip string) *graph.Node {
+	parentName, _ := parent.GetFieldString("Name")
+
+	m := graph.Metadata{
+		"Name":  name,
+		"Type":  ty,
+		"MTU":   int64(1500),
+		"State": "UP",
+		"MAC":   mac,
+	}
+	if ip != "" {
+		m["IPV4"] = []string{ip}
+	}
+	n := t.newNode(parentName+"/"+name, m)
+	topology.AddOwnershipLink(t.g, parent, n, nil)
+
+	if ip != "" {
+		t.Endpoints = append(t.Endpoints, Endpoint{TID: string(n.ID), MAC: mac, IP: strings.SplitN(ip, "/", 2)[0]})
+	}
+	return n
+}
+
+func mac(a, b, c int) string {
+	return fmt.Sprintf("02:42:%02x:%02x:%02x:%02x", a&0xff, (b>>8)&0xff, b&0xff, c&0xff)
+}
+
+func (t *Topology) addHost(i int, cluster *graph.Node, namespaces []*graph.Node) {
+	name := fmt.Sprintf("synthetic-host-%d", i)
+
+	host := t.newNode(name, graph.Metadata{
+		"Name": name,
+		"Type": "host",
+	})
+
+	bridge := t.addInterface(host, "cbr0", "bridge", mac(0, i, 0), "")
+
+	for j := 0; j < t.opts.Interfaces; j++ {
+		intf := t.addInterface(host, fmt.Sprintf("eth%d", j), "device", mac(1+j, i, 0),
+			fmt.Sprintf("10.%d.%d.%d/16", j, i/250, i%250+1))
+		if j == 0 {
+			topology.AddLayer2Link(t.g, bridge, intf, nil)
+		}
+	}
+
+	for p := 0; p < t.opts.Pods; p++ {
+		podName := fmt.Sprintf("pod-%d-%d", i, p)
+
+		netns := t.newNode(name+"/"+podName, graph.Metadata{
+			"Name": podName,
+			"Type": "netns",
+			"Path": "/var/run/netns/" + podName,
+		})
+		topology.AddOwnershipLink(t.g, host, netns, nil)
+
+		podIntf := t.addInterface(netns, "eth0", "veth", mac(0xfe, i, p),
+			fmt.Sprintf("172.%d.%d.%d/24", 16+i/250, i%250, p%250+2))
+		hostIntf := t.addInterface(host, "veth-"+podName, "veth", mac(0xfd, i, p), "")
+		topology.AddLayer2Link(t.g, podIntf, hostIntf, nil)
+		topology.AddLayer2Link(t.g, bridge, hostIntf, nil)
+
+		if cluster == nil {
+			continue
+		}
+
+		namespace := namespaces[p%len(namespaces)]
+		nsName, _ := namespace.GetFieldString("Name")
+
+		pod := t.newNode("k8s/pod/"+podName, graph.Metadata{
+			"Name":      podName,
+			"Type":      "pod",
+			"Manager":   "k8s",
+			"Namespace": nsName,
+		})
+		topology.AddOwnershipLink(t.g, namespace, pod, graph.Metadata{"Manager": "k8s"})
+		t.g.Link(pod, netns, graph.Metadata{"Manager": "k8s", "RelationType": "Association"})
+	}
+}
+
+// Populate creates the synthetic topology in the graph, the graph lock has to
+// be held by the caller
+func (t *Topology) Populate() {
+	var cluster *graph.Node
+	var namespaces []*graph.Node
+
+	if t.opts.K8s {
+		cluster = t.newNode("k8s/cluster", graph.Metadata{
+			"Name":    "synthetic-cluster",
+			"Type":    "cluster",
+			"Manager": "k8s",
+		})
+
+		for _, name := range []string{"default", "kube-system", "frontend", "backend"} {
+			namespace := t.newNode("k8s/namespace/"+name, graph.Metadata{
+				"Name":    name,
+				"Type":    "namespace",
+				"Manager": "k8s",
+			})
+			topology.AddOwnershipLink(t.g, cluster, namespace, graph.Metadata{"Manager": "k8s"})
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	for i := 0; i < t.opts.Hosts; i++ {
+		t.addHost(i, cluster, namespaces)
+	}
+}
+
+// NewTopology returns a synthetic topology generator for the given graph
+func NewTopology(g *graph.Graph, opts TopologyOpts) *Topology {
+	return &Topology{opts: opts, g: g}
+}
@@ -402,3 +402,107 @@ func TestMultipleTriggering(t *testing.T) {
 
 	RunTest(t, test)
 }
+
+func TestCompositeAlert(t *testing.T) {
+	var (
+		err error
+		ws  *websocket.Conn
+		al  *types.Alert
+	)
+
+	test := &Test{
+		mode:    OneShot,
+		retries: 1,
+		setupCmds: []helper.Cmd{
+			{"ip netns add alert-ns-and-1", true},
+			{"ip netns add alert-ns-and-2", true},
+		},
+
+		setupFunction: func(c *TestContext) error {
+			ws, err = helper.WSConnect(config.GetString("analyzer.listen"), 5, nil)
+			if err != nil {
+				return err
+			}
+
+			al = types.NewAlert()
+			al.Expression = "G.V().Has('Name', 'alert-ns-and-1', 'Type', 'netns')"
+			al.Operator = "AND"
+			al.Clauses = []types.AlertClause{
+				{Expression: "G.V().Has('Name', 'alert-ns-and-2', 'Type', 'netns')"},
+			}
+
+			if err = c.client.Create("alert", al); err != nil {
+				return fmt.Errorf("Failed to create alert: %s", err.Error())
+			}
+
+			return nil
+		},
+
+		tearDownCmds: []helper.Cmd{
+			{"ip netns del alert-ns-and-1", true},
+			{"ip netns del alert-ns-and-2", true},
+		},
+
+		tearDownFunction: func(c *TestContext) error {
+			helper.WSClose(ws)
+			return c.client.Delete("alert", al.ID())
+		},
+
+		checks: []CheckFunction{func(c *CheckContext) error {
+			for {
+				_, m, err := ws.ReadMessage()
+				if err != nil {
+					return err
+				}
+
+				msg := helper.DecodeWSStructMessageJSON(m)
+				if msg == nil {
+					t.Fatal("Failed to unmarshal message")
+				}
+				if msg.Namespace != "Alert" {
+					continue
+				}
+
+				var alertMsg alert.AlertMessage
+				if err := common.JSONDecode(bytes.NewReader([]byte(*msg.JsonObj)), &alertMsg); err != nil {
+					return err
+				}
+				if alertMsg.UUID != al.UUID {
+					continue
+				}
+
+				// one result per clause, the alert expression being the first one
+				results, ok := alertMsg.ReasonData.([]interface{})
+				if !ok || len(results) != 2 {
+					return fmt.Errorf("Expected the results of 2 clauses, got: %+v", alertMsg.ReasonData)
+				}
+
+				for i, result := range results {
+					var nodes []*graph.Node
+					if objs, ok := result.([]interface{}); ok {
+						for _, obj := range objs {
+							n := new(graph.Node)
+							if err := n.Decode(obj); err != nil {
+								return err
+							}
+							nodes = append(nodes, n)
+						}
+					}
+
+					if len(nodes) == 0 {
+						return fmt.Errorf("Wrong result for clause %d: %+v", i, result)
+					}
+					if name, _ := nodes[0].GetFieldString("Name"); name != fmt.Sprintf("alert-ns-and-%d", i+1) {
+						return fmt.Errorf("Wrong node for clause %d: %s", i, name)
+					}
+				}
+
+				break
+			}
+
+			return nil
+		}},
+	}
+
+	RunTest(t, test)
+}
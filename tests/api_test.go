@@ -23,6 +23,7 @@
 package tests
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/skydive-project/skydive/api/client"
@@ -49,7 +50,7 @@ func TestAlertAPI(t *testing.T) {
 		t.Error(err)
 	}
 
-	if *alert != *alert2 {
+	if !reflect.DeepEqual(alert, alert2) {
 		t.Errorf("Alert corrupted: %+v != %+v", alert, alert2)
 	}
 
@@ -62,7 +63,7 @@ func TestAlertAPI(t *testing.T) {
 		}
 	}
 
-	if alerts[alert.UUID] != *alert {
+	if !reflect.DeepEqual(alerts[alert.UUID], *alert) {
 		t.Errorf("Alert corrupted: %+v != %+v", alerts[alert.UUID], alert)
 	}
 
@@ -102,7 +103,7 @@ func TestCaptureAPI(t *testing.T) {
 		t.Error(err)
 	}
 
-	if *capture != *capture2 {
+	if !reflect.DeepEqual(capture, capture2) {
 		t.Errorf("Capture corrupted: %+v != %+v", capture, capture2)
 	}
 
@@ -114,7 +115,7 @@ func TestCaptureAPI(t *testing.T) {
 		}
 	}
 
-	if captures[capture.ID()] != *capture {
+	if !reflect.DeepEqual(captures[capture.ID()], *capture) {
 		t.Errorf("Capture corrupted: %+v != %+v", captures[capture.ID()], capture)
 	}
 
@@ -713,6 +713,67 @@ func TestUserMetadata(t *testing.T) {
 	RunTest(t, test)
 }
 
+// TestTagAndGroup tests that tagged nodes are members of the groups defined by their tag
+func TestTagAndGroup(t *testing.T) {
+	tag := types.NewTag(g.G.V().Has("Name", "br-tag", "Type", "ovsbridge").String(), "tag-test")
+	group := types.NewGroup("group-test", "", "tag-test", "")
+	test := &Test{
+		setupCmds: []helper.Cmd{
+			{"ovs-vsctl add-br br-tag", true},
+		},
+
+		setupFunction: func(c *TestContext) error {
+			if err := c.client.Create("tag", tag); err != nil {
+				return err
+			}
+			return c.client.Create("group", group)
+		},
+
+		tearDownFunction: func(c *TestContext) error {
+			c.client.Delete("group", group.ID())
+			c.client.Delete("tag", tag.ID())
+			return nil
+		},
+
+		tearDownCmds: []helper.Cmd{
+			{"ovs-vsctl del-br br-tag", true},
+		},
+
+		checks: []CheckFunction{
+			func(c *CheckContext) error {
+				prefix := g.G
+				prefix = prefix.Context(c.time)
+
+				if _, err := c.gh.GetNode(prefix.V().Has("Name", "br-tag", "Tags", "tag-test")); err != nil {
+					return fmt.Errorf("Failed to find a node with the tag-test tag")
+				}
+
+				if _, err := c.gh.GetNode(prefix.V().Has("Name", "br-tag", "Groups", "group-test")); err != nil {
+					return fmt.Errorf("Failed to find a node in the group-test group")
+				}
+
+				return nil
+			},
+
+			func(c *CheckContext) error {
+				prefix := g.G
+				prefix = prefix.Context(c.time)
+
+				c.client.Delete("tag", tag.ID())
+
+				node, err := c.gh.GetNode(prefix.V().Has("Groups", "group-test"))
+				if err != common.ErrNotFound {
+					return fmt.Errorf("Node %+v is still in the group-test group", node)
+				}
+
+				return nil
+			},
+		},
+	}
+
+	RunTest(t, test)
+}
+
 // TestAgentMetadata tests metadata set to the agent using the configuration file
 func TestAgentMetadata(t *testing.T) {
 	test := &Test{
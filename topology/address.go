@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package topology
+
+import (
+	"net"
+	"strings"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// AddressIndex maps the MAC and IP addresses to the nodes owning them
+type AddressIndex map[string]*graph.Node
+
+// NewAddressIndex indexes the nodes of the graph by their MAC, IPV4 and IPV6
+// metadata. The caller has to hold the lock of the graph.
+func NewAddressIndex(g *graph.Graph) AddressIndex {
+	index := make(AddressIndex)
+	for _, n := range g.GetNodes(nil) {
+		if mac, _ := n.GetFieldString("MAC"); mac != "" {
+			index[strings.ToLower(mac)] = n
+		}
+
+		for _, key := range []string{"IPV4", "IPV6"} {
+			field, err := n.GetField(key)
+			if err != nil {
+				continue
+			}
+
+			var values []string
+			switch field := field.(type) {
+			case []string:
+				values = field
+			case []interface{}:
+				for _, value := range field {
+					if value, ok := value.(string); ok {
+						values = append(values, value)
+					}
+				}
+			}
+
+			for _, value := range values {
+				if ip, _, err := net.ParseCIDR(value); err == nil {
+					index[ip.String()] = n
+				} else if ip := net.ParseIP(value); ip != nil {
+					index[ip.String()] = n
+				}
+			}
+		}
+	}
+	return index
+}
+
+// Lookup returns the node owning the first known address
+func (a AddressIndex) Lookup(addrs ...string) *graph.Node {
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			addr = ip.String()
+		} else {
+			addr = strings.ToLower(addr)
+		}
+
+		if n, ok := a[addr]; ok {
+			return n
+		}
+	}
+	return nil
+}
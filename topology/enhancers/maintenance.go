@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package metadata
+
+import (
+	"github.com/skydive-project/skydive/api/server"
+	api "github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	ge "github.com/skydive-project/skydive/gremlin/traversal"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// MaintenanceManager marks the nodes matching the maintenances with the
+// Maintenance metadata, the nodes they own being under maintenance as well
+type MaintenanceManager struct {
+	common.RWMutex
+	graph.DefaultGraphListener
+	graph              *graph.Graph
+	maintenanceHandler *server.MaintenanceAPIHandler
+	maintenances       map[string]*api.Maintenance
+	watcher            server.StoppableWatcher
+}
+
+// OnNodeAdded event
+func (m *MaintenanceManager) OnNodeAdded(n *graph.Node) {
+	m.nodeEvent()
+}
+
+// OnNodeUpdated event
+func (m *MaintenanceManager) OnNodeUpdated(n *graph.Node) {
+	m.nodeEvent()
+}
+
+func (m *MaintenanceManager) nodeEvent() {
+	for _, mt := range m.list() {
+		m.markNodes(mt)
+	}
+}
+
+// list returns the current maintenances, the lock is not held while marking
+// the nodes as it triggers node events
+func (m *MaintenanceManager) list() []*api.Maintenance {
+	m.RLock()
+	defer m.RUnlock()
+
+	maintenances := make([]*api.Maintenance, 0, len(m.maintenances))
+	for _, mt := range m.maintenances {
+		maintenances = append(maintenances, mt)
+	}
+	return maintenances
+}
+
+func (m *MaintenanceManager) applyGremlinExpr(query string) []*graph.Node {
+	res, err := ge.TopologyGremlinQuery(m.graph, query)
+	if err != nil {
+		logging.GetLogger().Errorf("Gremlin error: %s", err.Error())
+		return nil
+	}
+
+	var nodes []*graph.Node
+	for _, value := range res.Values() {
+		if node, ok := value.(*graph.Node); ok {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func (m *MaintenanceManager) markNodes(mt *api.Maintenance) {
+	metadata := map[string]interface{}{
+		"UUID":       mt.UUID,
+		"Reason":     mt.Reason,
+		"CreateTime": common.UnixMillis(mt.CreateTime),
+		"ExpireTime": common.UnixMillis(mt.ExpireTime),
+	}
+
+	for _, node := range m.applyGremlinExpr(mt.GremlinQuery) {
+		m.graph.AddMetadata(node, topology.MaintenanceMetadataKey, metadata)
+	}
+}
+
+func (m *MaintenanceManager) unmarkNodes(mt *api.Maintenance) {
+	for _, node := range m.applyGremlinExpr(mt.GremlinQuery) {
+		// the node may be under another maintenance in the meantime
+		if id, _ := node.GetFieldString(topology.MaintenanceMetadataKey + ".UUID"); id == mt.UUID {
+			m.graph.DelMetadata(node, topology.MaintenanceMetadataKey)
+		}
+	}
+
+	// reapply the remaining maintenances on the nodes it was overriding
+	m.nodeEvent()
+}
+
+func (m *MaintenanceManager) addMaintenance(mt *api.Maintenance) {
+	m.Lock()
+	m.maintenances[mt.UUID] = mt
+	m.Unlock()
+
+	m.markNodes(mt)
+}
+
+func (m *MaintenanceManager) deleteMaintenance(mt *api.Maintenance) {
+	m.Lock()
+	delete(m.maintenances, mt.UUID)
+	m.Unlock()
+
+	m.unmarkNodes(mt)
+}
+
+func (m *MaintenanceManager) onAPIWatcherEvent(action string, id string, resource api.Resource) {
+	mt := resource.(*api.Maintenance)
+	switch action {
+	case "init", "create", "set", "update":
+		m.graph.Lock()
+		m.addMaintenance(mt)
+		m.graph.Unlock()
+	case "expire", "delete":
+		m.graph.Lock()
+		m.deleteMaintenance(mt)
+		m.graph.Unlock()
+	}
+}
+
+// Start starts the maintenance manager
+func (m *MaintenanceManager) Start() {
+	m.watcher = m.maintenanceHandler.AsyncWatch(m.onAPIWatcherEvent)
+	m.graph.AddEventListener(m)
+}
+
+// Stop stops the maintenance manager
+func (m *MaintenanceManager) Stop() {
+	m.watcher.Stop()
+	m.graph.RemoveEventListener(m)
+}
+
+// NewMaintenanceManager creates a new maintenance manager
+func NewMaintenanceManager(g *graph.Graph, h *server.MaintenanceAPIHandler) *MaintenanceManager {
+	return &MaintenanceManager{
+		graph:              g,
+		maintenanceHandler: h,
+		maintenances:       make(map[string]*api.Maintenance),
+	}
+}
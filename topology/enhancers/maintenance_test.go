@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package metadata
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestMaintenanceManager(t *testing.T) {
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host1", backend)
+
+	g.Lock()
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host1"})
+	intf := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth0"})
+	topology.AddOwnershipLink(g, host, intf, nil)
+	g.Unlock()
+
+	now := time.Now().UTC()
+	host1 := &api.Maintenance{UUID: "host1", GremlinQuery: "G.V().Has('Name', 'host1')", CreateTime: now, ExpireTime: now.Add(time.Hour)}
+	all := &api.Maintenance{UUID: "all", GremlinQuery: "G.V().Has('Type', 'host')", CreateTime: now, ExpireTime: now.Add(time.Minute)}
+
+	maintenance := func() string {
+		g.RLock()
+		defer g.RUnlock()
+
+		id, _ := host.GetFieldString(topology.MaintenanceMetadataKey + ".UUID")
+		if topology.UnderMaintenance(g, intf) != (id != "") {
+			t.Errorf("Expected the owned interface to follow the maintenance of its host")
+		}
+		return id
+	}
+
+	m := NewMaintenanceManager(g, nil)
+
+	m.onAPIWatcherEvent("create", host1.UUID, host1)
+	if id := maintenance(); id != host1.UUID {
+		t.Fatalf("Expected the host to be under the maintenance host1, got: %q", id)
+	}
+
+	// the last maintenance overrides the previous one on the node
+	m.onAPIWatcherEvent("create", all.UUID, all)
+	if id := maintenance(); id != all.UUID {
+		t.Fatalf("Expected the host to be under the maintenance all, got: %q", id)
+	}
+
+	// once expired, the remaining maintenance applies again
+	m.onAPIWatcherEvent("expire", all.UUID, all)
+	if id := maintenance(); id != host1.UUID {
+		t.Fatalf("Expected the maintenance host1 to apply again, got: %q", id)
+	}
+
+	m.onAPIWatcherEvent("delete", host1.UUID, host1)
+	if id := maintenance(); id != "" {
+		t.Fatalf("Expected the host not to be under maintenance anymore, got: %q", id)
+	}
+}
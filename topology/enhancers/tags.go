@@ -0,0 +1,267 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package metadata
+
+import (
+	"sort"
+
+	"github.com/skydive-project/skydive/api/server"
+	api "github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	ge "github.com/skydive-project/skydive/gremlin/traversal"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Metadata keys listing the tags and the groups of a graph element
+const (
+	TagsMetadataKey   = "Tags"
+	GroupsMetadataKey = "Groups"
+)
+
+// elementNames maps the graph elements to the names, tags or groups, they hold
+type elementNames map[graph.Identifier]map[string]bool
+
+func (e elementNames) add(id graph.Identifier, name string) {
+	if _, found := e[id]; !found {
+		e[id] = make(map[string]bool)
+	}
+	e[id][name] = true
+}
+
+func (e elementNames) list(id graph.Identifier) []string {
+	names := make([]string, 0, len(e[id]))
+	for name := range e[id] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TagManager maintains the Tags and Groups metadata of the nodes and edges
+// according to the tag and group resources
+type TagManager struct {
+	common.RWMutex
+	graph.DefaultGraphListener
+	graph        *graph.Graph
+	tagHandler   *server.TagAPIHandler
+	groupHandler *server.GroupAPIHandler
+	tags         map[string]*api.Tag
+	groups       map[string]*api.Group
+	tagWatcher   server.StoppableWatcher
+	groupWatcher server.StoppableWatcher
+	// the following fields are protected by the graph lock
+	assigned    map[string]map[graph.Identifier]bool
+	reconciling bool
+}
+
+// OnNodeAdded event
+func (t *TagManager) OnNodeAdded(n *graph.Node) {
+	t.reconcile()
+}
+
+// OnNodeUpdated event
+func (t *TagManager) OnNodeUpdated(n *graph.Node) {
+	t.reconcile()
+}
+
+// OnNodeDeleted event
+func (t *TagManager) OnNodeDeleted(n *graph.Node) {
+	t.forget(n.ID)
+}
+
+// OnEdgeAdded event
+func (t *TagManager) OnEdgeAdded(e *graph.Edge) {
+	t.reconcile()
+}
+
+// OnEdgeUpdated event
+func (t *TagManager) OnEdgeUpdated(e *graph.Edge) {
+	t.reconcile()
+}
+
+// OnEdgeDeleted event
+func (t *TagManager) OnEdgeDeleted(e *graph.Edge) {
+	t.forget(e.ID)
+}
+
+func (t *TagManager) forget(id graph.Identifier) {
+	for _, assigned := range t.assigned {
+		delete(assigned, id)
+	}
+}
+
+func (t *TagManager) applyGremlinExpr(query string, elements map[graph.Identifier]interface{}) []graph.Identifier {
+	res, err := ge.TopologyGremlinQuery(t.graph, query)
+	if err != nil {
+		logging.GetLogger().Errorf("Gremlin error: %s", err.Error())
+		return nil
+	}
+
+	var ids []graph.Identifier
+	for _, value := range res.Values() {
+		switch value := value.(type) {
+		case *graph.Node:
+			elements[value.ID] = value
+			ids = append(ids, value.ID)
+		case *graph.Edge:
+			elements[value.ID] = value
+			ids = append(ids, value.ID)
+		}
+	}
+	return ids
+}
+
+// assign sets the key metadata of the elements to the list of their names
+// and removes it from the elements that do not hold any name anymore
+func (t *TagManager) assign(key string, names elementNames, elements map[graph.Identifier]interface{}) {
+	assigned := t.assigned[key]
+
+	for id := range assigned {
+		if _, found := names[id]; found {
+			continue
+		}
+
+		if node := t.graph.GetNode(id); node != nil {
+			t.graph.DelMetadata(node, key)
+		} else if edge := t.graph.GetEdge(id); edge != nil {
+			t.graph.DelMetadata(edge, key)
+		}
+		delete(assigned, id)
+	}
+
+	for id := range names {
+		t.graph.AddMetadata(elements[id], key, names.list(id))
+		assigned[id] = true
+	}
+}
+
+// reconcile computes the tags and the groups of all the graph elements, the
+// caller has to hold the lock of the graph
+func (t *TagManager) reconcile() {
+	// updating the metadata triggers graph events
+	if t.reconciling {
+		return
+	}
+	t.reconciling = true
+	defer func() { t.reconciling = false }()
+
+	t.RLock()
+	tags := make([]*api.Tag, 0, len(t.tags))
+	for _, tag := range t.tags {
+		tags = append(tags, tag)
+	}
+	groups := make([]*api.Group, 0, len(t.groups))
+	for _, group := range t.groups {
+		groups = append(groups, group)
+	}
+	t.RUnlock()
+
+	elements := make(map[graph.Identifier]interface{})
+
+	tagged := make(map[string][]graph.Identifier)
+	tagNames := make(elementNames)
+	for _, tag := range tags {
+		for _, id := range t.applyGremlinExpr(tag.GremlinQuery, elements) {
+			tagNames.add(id, tag.Name)
+			tagged[tag.Name] = append(tagged[tag.Name], id)
+		}
+	}
+	t.assign(TagsMetadataKey, tagNames, elements)
+
+	groupNames := make(elementNames)
+	for _, group := range groups {
+		members := tagged[group.Tag]
+		if group.GremlinQuery != "" {
+			members = t.applyGremlinExpr(group.GremlinQuery, elements)
+		}
+
+		for _, id := range members {
+			groupNames.add(id, group.Name)
+		}
+	}
+	t.assign(GroupsMetadataKey, groupNames, elements)
+}
+
+func (t *TagManager) onTagWatcherEvent(action string, id string, resource api.Resource) {
+	tag := resource.(*api.Tag)
+
+	t.Lock()
+	switch action {
+	case "init", "create", "set", "update":
+		t.tags[tag.UUID] = tag
+	case "expire", "delete":
+		delete(t.tags, tag.UUID)
+	}
+	t.Unlock()
+
+	t.graph.Lock()
+	t.reconcile()
+	t.graph.Unlock()
+}
+
+func (t *TagManager) onGroupWatcherEvent(action string, id string, resource api.Resource) {
+	group := resource.(*api.Group)
+
+	t.Lock()
+	switch action {
+	case "init", "create", "set", "update":
+		t.groups[group.UUID] = group
+	case "expire", "delete":
+		delete(t.groups, group.UUID)
+	}
+	t.Unlock()
+
+	t.graph.Lock()
+	t.reconcile()
+	t.graph.Unlock()
+}
+
+// Start starts the tag manager
+func (t *TagManager) Start() {
+	t.tagWatcher = t.tagHandler.AsyncWatch(t.onTagWatcherEvent)
+	t.groupWatcher = t.groupHandler.AsyncWatch(t.onGroupWatcherEvent)
+	t.graph.AddEventListener(t)
+}
+
+// Stop stops the tag manager
+func (t *TagManager) Stop() {
+	t.tagWatcher.Stop()
+	t.groupWatcher.Stop()
+	t.graph.RemoveEventListener(t)
+}
+
+// NewTagManager creates a new tag manager
+func NewTagManager(g *graph.Graph, tagHandler *server.TagAPIHandler, groupHandler *server.GroupAPIHandler) *TagManager {
+	return &TagManager{
+		graph:        g,
+		tagHandler:   tagHandler,
+		groupHandler: groupHandler,
+		tags:         make(map[string]*api.Tag),
+		groups:       make(map[string]*api.Group),
+		assigned: map[string]map[graph.Identifier]bool{
+			TagsMetadataKey:   make(map[graph.Identifier]bool),
+			GroupsMetadataKey: make(map[graph.Identifier]bool),
+		},
+	}
+}
@@ -0,0 +1,467 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+)
+
+var (
+	boltNodes     = []byte("nodes")
+	boltEdges     = []byte("edges")
+	boltLiveNodes = []byte("live_nodes")
+	boltLiveEdges = []byte("live_edges")
+)
+
+// BoltBackend describes an embedded persistent backend based on BoltDB.
+// Every revision of the elements is kept, keyed by ID and revision, the
+// live buckets pointing to the current revisions.
+type BoltBackend struct {
+	GraphBackend
+	db *bolt.DB
+}
+
+// boltRecord describes a revision of a node or an edge, a zero time meaning
+// not set
+type boltRecord struct {
+	ID         Identifier
+	Revision   int64
+	Host       string
+	CreatedAt  int64
+	UpdatedAt  int64
+	DeletedAt  int64           `json:",omitempty"`
+	ArchivedAt int64           `json:",omitempty"`
+	Parent     Identifier      `json:",omitempty"`
+	Child      Identifier      `json:",omitempty"`
+	Metadata   json.RawMessage `json:",omitempty"`
+}
+
+func boltKey(id Identifier, revision int64) []byte {
+	key := make([]byte, len(id)+9)
+	copy(key, id)
+	binary.BigEndian.PutUint64(key[len(id)+1:], uint64(revision))
+	return key
+}
+
+func boltMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return common.UnixMillis(t)
+}
+
+func millisToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func newBoltRecord(e *graphElement) (*boltRecord, error) {
+	metadata, err := json.Marshal(e.metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltRecord{
+		ID:        e.ID,
+		Revision:  e.revision,
+		Host:      e.host,
+		CreatedAt: common.UnixMillis(e.createdAt),
+		UpdatedAt: common.UnixMillis(e.updatedAt),
+		DeletedAt: boltMillis(e.deletedAt),
+		Metadata:  metadata,
+	}, nil
+}
+
+// inTimeSlice returns whether the revision matches the time filter of the
+// other backends, the live revisions when no time slice is given
+func (r *boltRecord) inTimeSlice(t *common.TimeSlice) bool {
+	if t == nil {
+		return r.ArchivedAt == 0
+	}
+
+	within := func(start, end int64) bool {
+		return start <= t.Last && (end == 0 || end >= t.Start)
+	}
+	return within(r.CreatedAt, r.DeletedAt) && within(r.UpdatedAt, r.ArchivedAt)
+}
+
+func (r *boltRecord) element() (e graphElement, err error) {
+	var m map[string]interface{}
+	if len(r.Metadata) > 0 {
+		if err = common.JSONDecode(bytes.NewReader(r.Metadata), &m); err != nil {
+			return
+		}
+		decodeMap(m)
+	}
+
+	e = graphElement{
+		ID:        r.ID,
+		metadata:  Metadata(m),
+		host:      r.Host,
+		createdAt: millisToTime(r.CreatedAt),
+		updatedAt: millisToTime(r.UpdatedAt),
+		revision:  r.Revision,
+	}
+	if r.DeletedAt != 0 {
+		e.deletedAt = millisToTime(r.DeletedAt)
+	}
+	return
+}
+
+func putRecord(tx *bolt.Tx, bucket, live []byte, r *boltRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	key := boltKey(r.ID, r.Revision)
+	if err := tx.Bucket(bucket).Put(key, data); err != nil {
+		return err
+	}
+
+	if r.ArchivedAt != 0 {
+		return nil
+	}
+	return tx.Bucket(live).Put([]byte(r.ID), key)
+}
+
+// archive sets the archive time, and the deletion time if not zero, of the
+// live revision of an element, which is then no longer live
+func archiveRecord(tx *bolt.Tx, bucket, live []byte, id Identifier, archivedAt, deletedAt time.Time) error {
+	key := tx.Bucket(live).Get([]byte(id))
+	if key == nil {
+		return fmt.Errorf("no live revision of %s", id)
+	}
+
+	var r boltRecord
+	if err := json.Unmarshal(tx.Bucket(bucket).Get(key), &r); err != nil {
+		return err
+	}
+
+	r.ArchivedAt = common.UnixMillis(archivedAt)
+	if !deletedAt.IsZero() {
+		r.DeletedAt = common.UnixMillis(deletedAt)
+	}
+
+	if err := putRecord(tx, bucket, live, &r); err != nil {
+		return err
+	}
+	return tx.Bucket(live).Delete([]byte(id))
+}
+
+// scan calls fn for the revisions of the elements within the time slice,
+// only the ones of the element id if not empty
+func (b *BoltBackend) scan(bucket, live []byte, t GraphContext, id Identifier, fn func(r *boltRecord) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bucket)
+
+		visit := func(data []byte) error {
+			var r boltRecord
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			if !r.inTimeSlice(t.TimeSlice) {
+				return nil
+			}
+			return fn(&r)
+		}
+
+		switch {
+		case id != "":
+			prefix := append([]byte(id), 0)
+			c := records.Cursor()
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				if err := visit(v); err != nil {
+					return err
+				}
+			}
+			return nil
+		case t.TimeSlice == nil:
+			return tx.Bucket(live).ForEach(func(_, key []byte) error {
+				return visit(records.Get(key))
+			})
+		default:
+			return records.ForEach(func(_, v []byte) error {
+				return visit(v)
+			})
+		}
+	})
+}
+
+func (b *BoltBackend) searchNodes(t GraphContext, id Identifier, m GraphElementMatcher) (nodes []*Node) {
+	err := b.scan(boltNodes, boltLiveNodes, t, id, func(r *boltRecord) error {
+		e, err := r.element()
+		if err != nil {
+			return err
+		}
+
+		n := &Node{graphElement: e}
+		if n.MatchMetadata(m) {
+			nodes = append(nodes, n)
+		}
+		return nil
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Error while retrieving nodes: %s", err)
+		return nil
+	}
+
+	if len(nodes) > 1 && t.TimePoint {
+		nodes = dedupNodes(nodes)
+	} else if !t.TimePoint {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].updatedAt.Before(nodes[j].updatedAt)
+		})
+	}
+
+	return
+}
+
+func (b *BoltBackend) searchEdges(t GraphContext, id Identifier, node Identifier, m GraphElementMatcher) (edges []*Edge) {
+	err := b.scan(boltEdges, boltLiveEdges, t, id, func(r *boltRecord) error {
+		if node != "" && r.Parent != node && r.Child != node {
+			return nil
+		}
+
+		e, err := r.element()
+		if err != nil {
+			return err
+		}
+
+		edge := &Edge{graphElement: e, parent: r.Parent, child: r.Child}
+		if edge.MatchMetadata(m) {
+			edges = append(edges, edge)
+		}
+		return nil
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Error while retrieving edges: %s", err)
+		return nil
+	}
+
+	if len(edges) > 1 && t.TimePoint {
+		edges = dedupEdges(edges)
+	} else if !t.TimePoint {
+		sort.SliceStable(edges, func(i, j int) bool {
+			return edges[i].updatedAt.Before(edges[j].updatedAt)
+		})
+	}
+
+	return
+}
+
+// NodeAdded add a node in the database
+func (b *BoltBackend) NodeAdded(n *Node) bool {
+	r, err := newBoltRecord(&n.graphElement)
+	if err == nil {
+		err = b.db.Update(func(tx *bolt.Tx) error {
+			return putRecord(tx, boltNodes, boltLiveNodes, r)
+		})
+	}
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding node %s: %s", n.ID, err)
+		return false
+	}
+	return true
+}
+
+// NodeDeleted delete a node in the database
+func (b *BoltBackend) NodeDeleted(n *Node) bool {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return archiveRecord(tx, boltNodes, boltLiveNodes, n.ID, n.deletedAt, n.deletedAt)
+	})
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while deleting node %s: %s", n.ID, err)
+		return false
+	}
+	return true
+}
+
+// GetNode get a node within a time slice
+func (b *BoltBackend) GetNode(i Identifier, t GraphContext) []*Node {
+	nodes := b.searchNodes(t, i, nil)
+	if t.TimePoint || len(nodes) < 2 {
+		return nodes
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].revision < nodes[j].revision
+	})
+	return nodes
+}
+
+// GetNodeEdges returns a list of a node edges within time slice
+func (b *BoltBackend) GetNodeEdges(n *Node, t GraphContext, m GraphElementMatcher) []*Edge {
+	return b.searchEdges(t, "", n.ID, m)
+}
+
+// EdgeAdded add an edge in the database
+func (b *BoltBackend) EdgeAdded(e *Edge) bool {
+	r, err := newBoltRecord(&e.graphElement)
+	if err == nil {
+		r.Parent, r.Child = e.parent, e.child
+		err = b.db.Update(func(tx *bolt.Tx) error {
+			return putRecord(tx, boltEdges, boltLiveEdges, r)
+		})
+	}
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding edge %s: %s", e.ID, err)
+		return false
+	}
+	return true
+}
+
+// EdgeDeleted delete an edge in the database
+func (b *BoltBackend) EdgeDeleted(e *Edge) bool {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return archiveRecord(tx, boltEdges, boltLiveEdges, e.ID, e.deletedAt, e.deletedAt)
+	})
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while deleting edge %s: %s", e.ID, err)
+		return false
+	}
+	return true
+}
+
+// GetEdge get an edge within a time slice
+func (b *BoltBackend) GetEdge(i Identifier, t GraphContext) []*Edge {
+	edges := b.searchEdges(t, i, "", nil)
+	if t.TimePoint || len(edges) < 2 {
+		return edges
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i].revision < edges[j].revision
+	})
+	return edges
+}
+
+// GetEdgeNodes returns the parents and child nodes of an edge within time slice, matching metadata
+func (b *BoltBackend) GetEdgeNodes(e *Edge, t GraphContext, parentMetadata, childMetadata GraphElementMatcher) (parents []*Node, children []*Node) {
+	return b.searchNodes(t, e.parent, parentMetadata), b.searchNodes(t, e.child, childMetadata)
+}
+
+// MetadataUpdated archives the live revision of the element and stores the
+// new one within the same transaction
+func (b *BoltBackend) MetadataUpdated(i interface{}) bool {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		switch i := i.(type) {
+		case *Node:
+			r, err := newBoltRecord(&i.graphElement)
+			if err != nil {
+				return err
+			}
+			if err := archiveRecord(tx, boltNodes, boltLiveNodes, i.ID, i.updatedAt, time.Time{}); err != nil {
+				return err
+			}
+			return putRecord(tx, boltNodes, boltLiveNodes, r)
+		case *Edge:
+			r, err := newBoltRecord(&i.graphElement)
+			if err != nil {
+				return err
+			}
+			r.Parent, r.Child = i.parent, i.child
+			if err := archiveRecord(tx, boltEdges, boltLiveEdges, i.ID, i.updatedAt, time.Time{}); err != nil {
+				return err
+			}
+			return putRecord(tx, boltEdges, boltLiveEdges, r)
+		}
+		return errors.New("unknown graph element")
+	})
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while updating metadata: %s", err)
+		return false
+	}
+	return true
+}
+
+// GetNodes returns a list of nodes within time slice, matching metadata
+func (b *BoltBackend) GetNodes(t GraphContext, m GraphElementMatcher) []*Node {
+	return b.searchNodes(t, "", m)
+}
+
+// GetEdges returns a list of edges within time slice, matching metadata
+func (b *BoltBackend) GetEdges(t GraphContext, m GraphElementMatcher) []*Edge {
+	return b.searchEdges(t, "", "", m)
+}
+
+// IsHistorySupported returns that this backend does support history
+func (b *BoltBackend) IsHistorySupported() bool {
+	return true
+}
+
+// Close closes the database
+func (b *BoltBackend) Close() error {
+	return b.db.Close()
+}
+
+// NewBoltBackend creates a new graph backend storing the topology and its
+// history in the BoltDB database file at path
+func NewBoltBackend(path string) (*BoltBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s: %s", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltNodes, boltEdges, boltLiveNodes, boltLiveEdges} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltBackend{db: db}, nil
+}
+
+// NewBoltBackendFromConfig creates a new BoltDB graph backend based on configuration
+func NewBoltBackendFromConfig(backend string) (*BoltBackend, error) {
+	return NewBoltBackend(config.GetString("storage." + backend + ".path"))
+}
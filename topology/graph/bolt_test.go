@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+func TestBoltHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-bolt-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "topology.db")
+	b, err := NewBoltBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGraphFromConfig(b)
+
+	n1 := g.newNode("n1", Metadata{"Type": "netns"}, time.Unix(1, 0), "host1")
+	n2 := g.newNode("n2", Metadata{"Type": "veth"}, time.Unix(1, 0), "host1")
+	g.newEdge("e1", n1, n2, Metadata{"RelationType": "ownership"}, time.Unix(1, 0), "host1")
+	g.addMetadata(n2, "MTU", 1500, time.Unix(2, 0))
+	g.delNode(n1, time.Unix(3, 0))
+
+	at := func(ms int64) GraphContext {
+		return GraphContext{TimeSlice: common.NewTimeSlice(ms, ms), TimePoint: true}
+	}
+
+	nodes := b.GetNodes(liveContext, nil)
+	if len(nodes) != 1 || nodes[0].ID != "n2" || nodes[0].Metadata()["MTU"] != int64(1500) {
+		t.Fatalf("Expected the live revision of n2 only, got: %v", nodes)
+	}
+
+	if nodes := b.GetNodes(at(1500), Metadata{"Type": "veth"}); len(nodes) != 1 || nodes[0].revision != 1 {
+		t.Errorf("Expected the first revision of n2, got: %v", nodes)
+	}
+
+	if nodes := b.GetNodes(at(2500), nil); len(nodes) != 2 {
+		t.Errorf("Expected n1 and n2 before the deletion, got: %v", nodes)
+	}
+
+	if edges := b.GetNodeEdges(n1, at(2500), nil); len(edges) != 1 || edges[0].ID != "e1" {
+		t.Errorf("Expected the edge of n1 before the deletion, got: %v", edges)
+	}
+
+	if edges := b.GetNodeEdges(n1, at(3500), nil); len(edges) != 0 {
+		t.Errorf("Expected no edge after the deletion, got: %v", edges)
+	}
+
+	slice := GraphContext{TimeSlice: common.NewTimeSlice(0, 5000)}
+	if nodes := b.GetNode("n2", slice); len(nodes) != 2 || nodes[0].revision != 1 || nodes[1].revision != 2 {
+		t.Errorf("Expected the two revisions of n2, got: %v", nodes)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the topology and its history are kept across restarts
+	if b, err = NewBoltBackend(path); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if nodes := b.GetNodes(liveContext, nil); len(nodes) != 1 || nodes[0].ID != "n2" {
+		t.Errorf("Expected n2 to be reloaded, got: %v", nodes)
+	}
+
+	if nodes := b.GetNode("n1", at(2500)); len(nodes) != 1 || nodes[0].Host() != "host1" {
+		t.Errorf("Expected the history of n1 to be reloaded, got: %v", nodes)
+	}
+}
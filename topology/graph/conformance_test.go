@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/graphtest"
+)
+
+// The conformance suite runs against the persistent backends when their
+// address is given, for instance with dockerized instances:
+//
+//   docker run -d -p 9200:9200 elasticsearch:5
+//   docker run -d -p 2480:2480 -e ORIENTDB_ROOT_PASSWORD=root orientdb:2.2
+//   docker run -d -p 7474:7474 -e NEO4J_AUTH=none neo4j:3.4
+//   docker run -d -p 6379:6379 redis:4
+//   SKYDIVE_TEST_ELASTICSEARCH=127.0.0.1:9200 \
+//   SKYDIVE_TEST_ORIENTDB=http://127.0.0.1:2480 \
+//   SKYDIVE_TEST_NEO4J=http://127.0.0.1:7474 \
+//   SKYDIVE_TEST_REDIS=127.0.0.1:6379 \
+//     go test -run Conformance ./topology/graph/
+
+func TestMemoryBackendConformance(t *testing.T) {
+	graphtest.RunConformance(t, func(t *testing.T) graph.GraphBackend {
+		b, err := graph.NewMemoryBackend()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}, graphtest.Opts{})
+}
+
+// newBoltBackend returns a bolt backend using a new database file of dir,
+// closed at the end of the test
+func newBoltBackend(t *testing.T, dir string) *graph.BoltBackend {
+	file, err := ioutil.TempFile(dir, "topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	b, err := graph.NewBoltBackend(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBoltBackendConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var backends []*graph.BoltBackend
+	defer func() {
+		for _, b := range backends {
+			b.Close()
+		}
+	}()
+
+	graphtest.RunConformance(t, func(t *testing.T) graph.GraphBackend {
+		b := newBoltBackend(t, dir)
+		backends = append(backends, b)
+		return b
+	}, graphtest.Opts{})
+}
+
+func TestMultiBackendConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var backends []*graph.BoltBackend
+	defer func() {
+		for _, b := range backends {
+			b.Close()
+		}
+	}()
+
+	// the memory backend is the primary one, the history being served by
+	// the bolt one
+	graphtest.RunConformance(t, func(t *testing.T) graph.GraphBackend {
+		memory, err := graph.NewMemoryBackend()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bolt := newBoltBackend(t, dir)
+		backends = append(backends, bolt)
+
+		b, err := graph.NewMultiBackend([]string{"memory", "bolt"}, []graph.GraphBackend{memory, bolt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}, graphtest.Opts{})
+}
+
+func TestElasticSearchBackendConformance(t *testing.T) {
+	addr := os.Getenv("SKYDIVE_TEST_ELASTICSEARCH")
+	if addr == "" {
+		t.Skip("SKYDIVE_TEST_ELASTICSEARCH not set")
+	}
+	config.Set("storage.elasticsearch.host", addr)
+
+	graphtest.RunConformance(t, func(t *testing.T) graph.GraphBackend {
+		b, err := graph.NewElasticSearchBackendFromConfig("elasticsearch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}, graphtest.Opts{Timeout: 3 * time.Duration(config.GetInt("storage.elasticsearch.bulk_maxdelay")+1) * time.Second})
+}
+
+func TestOrientDBBackendConformance(t *testing.T) {
+	addr := os.Getenv("SKYDIVE_TEST_ORIENTDB")
+	if addr == "" {
+		t.Skip("SKYDIVE_TEST_ORIENTDB not set")
+	}
+	config.Set("storage.orientdb.addr", addr)
+
+	graphtest.RunConformance(t, func(t *testing.T) graph.GraphBackend {
+		b, err := graph.NewOrientDBBackendFromConfig("orientdb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}, graphtest.Opts{Timeout: 5 * time.Second})
+}
+
+func TestNeo4jBackendConformance(t *testing.T) {
+	addr := os.Getenv("SKYDIVE_TEST_NEO4J")
+	if addr == "" {
+		t.Skip("SKYDIVE_TEST_NEO4J not set")
+	}
+	config.Set("storage.neo4j.addr", addr)
+
+	graphtest.RunConformance(t, func(t *testing.T) graph.GraphBackend {
+		b, err := graph.NewNeo4jBackendFromConfig("neo4j")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}, graphtest.Opts{})
+}
+
+func TestRedisBackendConformance(t *testing.T) {
+	addr := os.Getenv("SKYDIVE_TEST_REDIS")
+	if addr == "" {
+		t.Skip("SKYDIVE_TEST_REDIS not set")
+	}
+	config.Set("storage.redis.addr", addr)
+	config.Set("storage.redis.prefix", "skydive-conformance:")
+
+	graphtest.RunConformance(t, func(t *testing.T) graph.GraphBackend {
+		b, err := graph.NewRedisBackendFromConfig("redis")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}, graphtest.Opts{})
+}
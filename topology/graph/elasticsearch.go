@@ -24,20 +24,33 @@ package graph
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
 	elastic "github.com/olivere/elastic"
 
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/storage/elasticsearch"
 )
 
+// ESGraphMappingsVersion is the version of the graph mappings, to be
+// increased with any incompatible change, the indices of the previous
+// versions being then reindexed
+const ESGraphMappingsVersion = 11
+
+// esGraphUpgradeScript archives the elements live in the reindexed indices as
+// a new revision of them is stored by the current index
+const esGraphUpgradeScript = "if (ctx._source.ArchivedAt == null) { ctx._source.ArchivedAt = params.now }"
+
 // ESGraphElementMapping elasticsearch db mapping scheme
 const ESGraphElementMapping = `
 {
@@ -96,11 +109,35 @@ const ESGraphElementMapping = `
 // ErrBadConfig elasticsearch configuration file is incorrect
 var ErrBadConfig = errors.New("elasticsearch : Config file is misconfigured, check elasticsearch key format")
 
+// esGraphPageSize is the default number of hits of the pages read to get
+// the whole results of a search, the default result window of Elasticsearch
+const esGraphPageSize = 10000
+
+// esHistoryBatchSize is the number of archived revisions read at once when
+// snapshotting them
+const esHistoryBatchSize = 10000
+
 // ElasticSearchBackend describes a presisent backend based on ElasticSearch
 type ElasticSearchBackend struct {
 	GraphBackend
 	client       elasticsearch.ElasticSearchClientInterface
 	prevRevision map[Identifier]int64
+	purge        *HistoryPurgeConfig
+}
+
+// HistoryPurgeConfig describes the purge of the archived revisions of the
+// nodes and edges older than the retention, deleted or, when a snapshot path
+// is given, first written to a gzipped JSON lines file of this directory
+type HistoryPurgeConfig struct {
+	Retention    time.Duration
+	Interval     time.Duration
+	SnapshotPath string
+}
+
+// snapshotRevision is a line of a snapshot of archived revisions
+type snapshotRevision struct {
+	Kind     string
+	Revision *json.RawMessage
 }
 
 // TimedSearchQuery describes a search query within a time slice and metadata filters
@@ -108,6 +145,12 @@ type TimedSearchQuery struct {
 	filters.SearchQuery
 	TimeFilter     *filters.Filter
 	MetadataFilter *filters.Filter
+	// PageSize is the number of hits of the pages returned by QueryPage,
+	// esGraphPageSize when not set
+	PageSize int
+	// SearchAfter holds the sort values returned by QueryPage for the last
+	// hit of the previous page, to query the next one
+	SearchAfter []interface{}
 }
 
 func (b *ElasticSearchBackend) mapElement(e *graphElement) map[string]interface{} {
@@ -156,6 +199,65 @@ func (b *ElasticSearchBackend) getElement(kind string, i Identifier, element int
 	return fmt.Errorf("No object found %s", string(i))
 }
 
+// getPrevRevision returns the revision of the live element. An element not
+// known yet, created before a restart and not reloaded, is looked up in the
+// live index.
+func (b *ElasticSearchBackend) getPrevRevision(kind string, i Identifier) (int64, bool) {
+	if revision, ok := b.prevRevision[i]; ok {
+		return revision, true
+	}
+
+	tsq := &TimedSearchQuery{
+		SearchQuery: filters.SearchQuery{
+			Filter: filters.NewTermStringFilter("ID", string(i)),
+		},
+		TimeFilter: getTimeFilter(nil),
+	}
+
+	var revisions []int64
+	switch kind {
+	case "node":
+		for _, n := range b.searchNodes(tsq, b.client.GetIndexAlias()) {
+			revisions = append(revisions, n.revision)
+		}
+	case "edge":
+		for _, e := range b.searchEdges(tsq, b.client.GetIndexAlias()) {
+			revisions = append(revisions, e.revision)
+		}
+	}
+
+	for _, revision := range revisions {
+		b.setPrevRevision(i, revision)
+	}
+
+	revision, ok := b.prevRevision[i]
+	return revision, ok
+}
+
+// setPrevRevision records the revision of a live element, the most recent
+// one being kept when several are found in the live index
+func (b *ElasticSearchBackend) setPrevRevision(i Identifier, revision int64) {
+	if prev, ok := b.prevRevision[i]; !ok || revision > prev {
+		b.prevRevision[i] = revision
+	}
+}
+
+// loadRevisions reloads the revisions of the elements of the live index so
+// that their updates are archived after a restart of the analyzer
+func (b *ElasticSearchBackend) loadRevisions() {
+	tsq := &TimedSearchQuery{TimeFilter: getTimeFilter(nil)}
+	index := b.client.GetIndexAlias()
+
+	for _, n := range b.searchNodes(tsq, index) {
+		b.setPrevRevision(n.ID, n.revision)
+	}
+	for _, e := range b.searchEdges(tsq, index) {
+		b.setPrevRevision(e.ID, e.revision)
+	}
+
+	logging.GetLogger().Debugf("Reloaded the revisions of %d live elements", len(b.prevRevision))
+}
+
 func (b *ElasticSearchBackend) updateTimes(i interface{}) bool {
 	obj := make(map[string]interface{})
 	var id, kind string
@@ -163,7 +265,7 @@ func (b *ElasticSearchBackend) updateTimes(i interface{}) bool {
 	case *Node:
 		kind = "node"
 
-		revision, ok := b.prevRevision[i.ID]
+		revision, ok := b.getPrevRevision(kind, i.ID)
 		if !ok {
 			logging.GetLogger().Errorf("Update from an unknow revision, node: %s", i.ID)
 			return false
@@ -174,7 +276,7 @@ func (b *ElasticSearchBackend) updateTimes(i interface{}) bool {
 	case *Edge:
 		kind = "edge"
 
-		revision, ok := b.prevRevision[i.ID]
+		revision, ok := b.getPrevRevision(kind, i.ID)
 		if !ok {
 			logging.GetLogger().Errorf("Update from an unknow revision, edge: %s", i.ID)
 			return false
@@ -391,8 +493,8 @@ func (b *ElasticSearchBackend) MetadataUpdated(i interface{}) bool {
 	return success
 }
 
-// Query the database for a "node" or "edge"
-func (b *ElasticSearchBackend) Query(obj string, tsq *TimedSearchQuery, index string) (sr *elastic.SearchResult, _ error) {
+// query returns the query of the elements matching the filters
+func (b *ElasticSearchBackend) query(tsq *TimedSearchQuery) elastic.Query {
 	var filters []elastic.Query
 
 	if tf := b.client.FormatFilter(tsq.TimeFilter, ""); tf != nil {
@@ -407,27 +509,77 @@ func (b *ElasticSearchBackend) Query(obj string, tsq *TimedSearchQuery, index st
 		filters = append(filters, mf)
 	}
 
-	mustQuery := elastic.NewBoolQuery().Must(filters...)
+	return elastic.NewBoolQuery().Must(filters...)
+}
+
+// Query the database for a "node" or "edge"
+func (b *ElasticSearchBackend) Query(obj string, tsq *TimedSearchQuery, index string) (sr *elastic.SearchResult, _ error) {
+	return b.client.Search(obj, b.query(tsq), index, tsq.SearchQuery)
+}
+
+// QueryPage queries the database for a page of "node" or "edge", the one
+// after the SearchAfter sort values of the query. It returns the sort values
+// to query the next page with, nil once the last page is reached.
+func (b *ElasticSearchBackend) QueryPage(obj string, tsq *TimedSearchQuery, index string) (sr *elastic.SearchResult, next []interface{}, _ error) {
+	size := tsq.PageSize
+	if size <= 0 {
+		size = esGraphPageSize
+	}
+
+	sr, err := b.client.SearchAfter(obj, b.query(tsq), index, tsq.SearchQuery, size, tsq.SearchAfter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if sr != nil && sr.Hits != nil && len(sr.Hits.Hits) == size {
+		next = sr.Hits.Hits[size-1].Sort
+	}
 
-	return b.client.Search(obj, mustQuery, index, tsq.SearchQuery)
+	return sr, next, nil
+}
+
+// search returns the hits of the query, all of them unless the query sets a
+// pagination range or the page to start after
+func (b *ElasticSearchBackend) search(obj string, tsq *TimedSearchQuery, index string) ([]*elastic.SearchHit, error) {
+	if tsq.PaginationRange != nil {
+		out, err := b.Query(obj, tsq, index)
+		if err != nil || out == nil || out.Hits == nil {
+			return nil, err
+		}
+		return out.Hits.Hits, nil
+	}
+
+	var hits []*elastic.SearchHit
+	page := *tsq
+	for {
+		out, next, err := b.QueryPage(obj, &page, index)
+		if err != nil {
+			return nil, err
+		}
+		if out != nil && out.Hits != nil {
+			hits = append(hits, out.Hits.Hits...)
+		}
+		if next == nil || tsq.SearchAfter != nil {
+			return hits, nil
+		}
+		page.SearchAfter = next
+	}
 }
 
 // searchNodes search nodes matching the query
 func (b *ElasticSearchBackend) searchNodes(tsq *TimedSearchQuery, index string) (nodes []*Node) {
-	out, err := b.Query("node", tsq, index)
+	hits, err := b.search("node", tsq, index)
 	if err != nil {
 		logging.GetLogger().Errorf("Failed to query nodes: %s", err.Error())
 		return
 	}
 
-	if out != nil && len(out.Hits.Hits) > 0 {
-		for _, d := range out.Hits.Hits {
-			var node Node
-			if err := b.hitToNode(d.Source, &node); err != nil {
-				logging.GetLogger().Debugf("Failed to unmarshal node: %+v", d.Source)
-			}
-			nodes = append(nodes, &node)
+	for _, d := range hits {
+		var node Node
+		if err := b.hitToNode(d.Source, &node); err != nil {
+			logging.GetLogger().Debugf("Failed to unmarshal node: %+v", d.Source)
 		}
+		nodes = append(nodes, &node)
 	}
 
 	return
@@ -435,20 +587,18 @@ func (b *ElasticSearchBackend) searchNodes(tsq *TimedSearchQuery, index string)
 
 // searchEdges search edges matching the query
 func (b *ElasticSearchBackend) searchEdges(tsq *TimedSearchQuery, index string) (edges []*Edge) {
-	out, err := b.Query("edge", tsq, index)
+	hits, err := b.search("edge", tsq, index)
 	if err != nil {
 		logging.GetLogger().Errorf("Failed to query edges: %s", err.Error())
 		return
 	}
 
-	if out != nil && len(out.Hits.Hits) > 0 {
-		for _, d := range out.Hits.Hits {
-			var edge Edge
-			if err := b.hitToEdge(d.Source, &edge); err != nil {
-				logging.GetLogger().Debugf("Failed to unmarshal edge: %+v", d.Source)
-			}
-			edges = append(edges, &edge)
+	for _, d := range hits {
+		var edge Edge
+		if err := b.hitToEdge(d.Source, &edge); err != nil {
+			logging.GetLogger().Debugf("Failed to unmarshal edge: %+v", d.Source)
 		}
+		edges = append(edges, &edge)
 	}
 
 	return
@@ -578,26 +728,232 @@ func (b *ElasticSearchBackend) IsHistorySupported() bool {
 	return true
 }
 
+// archivedBefore returns the filter of the revisions archived before the
+// time in milliseconds
+func archivedBefore(ms int64) *filters.Filter {
+	return filters.NewLtInt64Filter("ArchivedAt", ms)
+}
+
+func hitArchivedAt(hit *elastic.SearchHit) (int64, error) {
+	var revision struct {
+		ArchivedAt int64
+	}
+	if err := json.Unmarshal(*hit.Source, &revision); err != nil {
+		return 0, err
+	}
+	return revision.ArchivedAt, nil
+}
+
+// snapshotRevisions writes the revisions of the kind archived before the
+// time in milliseconds, oldest first, deleting them once written
+func (b *ElasticSearchBackend) snapshotRevisions(encoder *json.Encoder, kind string, before int64) (purged int64, _ error) {
+	for {
+		tsq := &TimedSearchQuery{
+			SearchQuery: filters.SearchQuery{
+				Filter:          archivedBefore(before),
+				PaginationRange: &filters.Range{To: esHistoryBatchSize},
+				Sort:            true,
+				SortBy:          "ArchivedAt",
+				SortOrder:       string(common.SortAscending),
+			},
+		}
+
+		out, err := b.Query(kind, tsq, "")
+		if err != nil {
+			return purged, err
+		}
+		if out == nil || out.Hits == nil || len(out.Hits.Hits) == 0 {
+			return purged, nil
+		}
+		hits := out.Hits.Hits
+
+		// a full batch may not hold all the revisions archived at the time
+		// of its last one, left to the next batch unless the whole batch was
+		// archived at that time
+		until := before
+		if len(hits) == esHistoryBatchSize {
+			first, err := hitArchivedAt(hits[0])
+			if err != nil {
+				return purged, err
+			}
+			last, err := hitArchivedAt(hits[len(hits)-1])
+			if err != nil {
+				return purged, err
+			}
+			if until = last; first == last {
+				until = last + 1
+			}
+		}
+
+		for _, hit := range hits {
+			archivedAt, err := hitArchivedAt(hit)
+			if err != nil {
+				return purged, err
+			}
+			if archivedAt >= until {
+				break
+			}
+			if err := encoder.Encode(&snapshotRevision{Kind: kind, Revision: hit.Source}); err != nil {
+				return purged, err
+			}
+		}
+
+		deleted, err := b.client.DeleteByQuery(kind, b.client.FormatFilter(archivedBefore(until), ""))
+		purged += deleted
+		if err != nil || until == before {
+			return purged, err
+		}
+	}
+}
+
+// snapshotHistory snapshots the revisions archived before the time in
+// milliseconds to a new file of the snapshot path
+func (b *ElasticSearchBackend) snapshotHistory(now time.Time, before int64) (purged int64, err error) {
+	path := filepath.Join(b.purge.SnapshotPath, "topology-"+now.Format("20060102T150405")+".json.gz")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	writer := gzip.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, kind := range []string{"node", "edge"} {
+		n, err := b.snapshotRevisions(encoder, kind, before)
+		if purged += n; err != nil {
+			break
+		}
+	}
+
+	if cerr := writer.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if purged == 0 && err == nil {
+		os.Remove(path)
+	} else if purged > 0 {
+		logging.GetLogger().Infof("Snapshotted the archived topology revisions to %s", path)
+	}
+	return purged, err
+}
+
+// purgeHistory deletes, or snapshots, the revisions archived for longer
+// than the retention
+func (b *ElasticSearchBackend) purgeHistory(now time.Time) error {
+	before := common.UnixMillis(now.Add(-b.purge.Retention))
+
+	var purged int64
+	if b.purge.SnapshotPath != "" {
+		n, err := b.snapshotHistory(now, before)
+		if purged = n; err != nil {
+			return err
+		}
+	} else {
+		for _, kind := range []string{"node", "edge"} {
+			n, err := b.client.DeleteByQuery(kind, b.client.FormatFilter(archivedBefore(before), ""))
+			if purged += n; err != nil {
+				return err
+			}
+		}
+	}
+
+	if purged > 0 {
+		logging.GetLogger().Infof("Purged %d topology revisions archived before %s", purged, time.Unix(0, before*int64(time.Millisecond)))
+	}
+	return nil
+}
+
+func (b *ElasticSearchBackend) purgeHistoryPeriodically() {
+	ticker := time.NewTicker(b.purge.Interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := b.purgeHistory(now); err != nil {
+			logging.GetLogger().Errorf("Failed to purge the topology history: %s", err.Error())
+		}
+	}
+}
+
 func NewElasticSearchBackendFromClient(client elasticsearch.ElasticSearchClientInterface) (*ElasticSearchBackend, error) {
 	client.Start()
 
-	return &ElasticSearchBackend{
+	b := &ElasticSearchBackend{
 		client:       client,
 		prevRevision: make(map[Identifier]int64),
-	}, nil
+	}
+	b.loadRevisions()
+
+	return b, nil
 }
 
-// NewElasticSearchBackendFromConfig creates a new graph backend based on configuration file parameters
-func NewElasticSearchBackendFromConfig(backend string) (*ElasticSearchBackend, error) {
+// NewHistoryPurgeConfig returns the purge of the archived revisions based on
+// configuration file parameters, nil if there is no retention
+func NewHistoryPurgeConfig(backend string) (*HistoryPurgeConfig, error) {
+	path := "storage." + backend + ".history"
+
+	retention := config.GetInt(path + ".retention")
+	if retention <= 0 {
+		return nil, nil
+	}
+
+	interval := config.GetInt(path + ".purge_interval")
+	if interval <= 0 {
+		interval = 3600
+	}
+
+	purge := &HistoryPurgeConfig{
+		Retention: time.Duration(retention) * time.Second,
+		Interval:  time.Duration(interval) * time.Second,
+	}
+
+	switch action := config.GetString(path + ".purge_action"); action {
+	case "", "delete":
+	case "snapshot":
+		if purge.SnapshotPath = config.GetString(path + ".snapshot_path"); purge.SnapshotPath == "" {
+			return nil, fmt.Errorf("No snapshot path given for the history of %s", backend)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown history purge action '%s', should be delete or snapshot", action)
+	}
+
+	return purge, nil
+}
+
+// NewElasticSearchClientFromConfig creates the client of the topology indices
+// based on configuration file parameters
+func NewElasticSearchClientFromConfig(backend string) (*elasticsearch.ElasticSearchClient, error) {
 	cfg := elasticsearch.NewConfig(backend)
+	cfg.MappingsVersion = ESGraphMappingsVersion
+	cfg.UpgradeScript = esGraphUpgradeScript
 	mappings := elasticsearch.Mappings{
 		{"node": []byte(ESGraphElementMapping)},
 		{"edge": []byte(ESGraphElementMapping)},
 	}
-	client, err := elasticsearch.NewElasticSearchClient("topology", mappings, cfg)
+	return elasticsearch.NewElasticSearchClient("topology", mappings, cfg)
+}
+
+// NewElasticSearchBackendFromConfig creates a new graph backend based on configuration file parameters
+func NewElasticSearchBackendFromConfig(backend string) (*ElasticSearchBackend, error) {
+	purge, err := NewHistoryPurgeConfig(backend)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewElasticSearchBackendFromClient(client)
+	client, err := NewElasticSearchClientFromConfig(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := NewElasticSearchBackendFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.purge = purge; purge != nil {
+		go b.purgeHistoryPeriodically()
+	}
+
+	return b, nil
 }
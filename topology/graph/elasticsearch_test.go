@@ -23,9 +23,15 @@
 package graph
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
 	"testing"
 	"time"
 
@@ -58,6 +64,7 @@ type fakeElasticsearchClient struct {
 	searches     []elastic.Query
 	searchResult elastic.SearchResult
 	shouldRoll   bool
+	deletes      []elastic.Query
 }
 
 func (f *fakeElasticsearchClient) getRevisions() []interface{} {
@@ -139,6 +146,21 @@ func (f *fakeElasticsearchClient) Search(obj string, query elastic.Query, index
 	f.searches = append(f.searches, query)
 	return &f.searchResult, nil
 }
+func (f *fakeElasticsearchClient) SearchAfter(obj string, query elastic.Query, index string, fsq filters.SearchQuery, size int, after []interface{}) (*elastic.SearchResult, error) {
+	f.searches = append(f.searches, query)
+	hits := f.searchResult.Hits.Hits
+	if len(after) > 0 {
+		hits = hits[after[0].(int):]
+	}
+	if len(hits) > size {
+		hits = hits[:size]
+	}
+	return &elastic.SearchResult{Hits: &elastic.SearchHits{Hits: hits}}, nil
+}
+func (f *fakeElasticsearchClient) DeleteByQuery(obj string, query elastic.Query) (int64, error) {
+	f.deletes = append(f.deletes, query)
+	return int64(len(f.searchResult.Hits.Hits)), nil
+}
 func (f *fakeElasticsearchClient) Start() {
 }
 
@@ -147,8 +169,8 @@ func newElasticsearchGraph(t *testing.T) (*Graph, *fakeElasticsearchClient) {
 		revisions:  make(map[string]interface{}),
 		shouldRoll: false,
 	}
-	b, err := NewElasticSearchBackendFromClient(client)
 	client.searchResult.Hits = &elastic.SearchHits{}
+	b, err := NewElasticSearchBackendFromClient(client)
 
 	if err != nil {
 		t.Error(err.Error())
@@ -428,3 +450,175 @@ func TestElasticsearchForwarded(t *testing.T) {
 		t.Fatalf("Expected elasticsearch records not found: \nexpected: %v\ngot: %v", expected, client.getRevisions())
 	}
 }
+
+// test that the revisions of the live elements are reloaded after a restart
+func TestElasticsearchRestart(t *testing.T) {
+	mg := newGraph(t)
+	node := mg.newNode("aaa", Metadata{"MTU": 1500}, time.Unix(1, 0), "host1")
+	mg.addMetadata(node, "MTU", 1510, time.Unix(2, 0))
+
+	b, _ := node.MarshalJSON()
+	rawMessage := json.RawMessage(b)
+
+	client := &fakeElasticsearchClient{
+		revisions: map[string]interface{}{
+			"aaa-2": map[string]interface{}{"ID": "aaa", "Revision": int64(2)},
+		},
+	}
+	client.searchResult.Hits = &elastic.SearchHits{
+		Hits: []*elastic.SearchHit{{Source: &rawMessage}},
+	}
+
+	backend, err := NewElasticSearchBackendFromClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGraphFromConfig(backend)
+
+	if revision, ok := backend.prevRevision["aaa"]; !ok || revision != 2 {
+		t.Fatalf("Expected the revision of the live node to be reloaded, got: %d", revision)
+	}
+
+	mg.addMetadata(node, "MTU", 1520, time.Unix(3, 0))
+	if !g.NodeUpdated(node) {
+		t.Fatal("Expected the node update to be archived")
+	}
+
+	archived := client.revisions["aaa-2"].(map[string]interface{})
+	if archived["ArchivedAt"] != int64(3000) {
+		t.Errorf("Expected the previous revision to be archived, got: %v", archived)
+	}
+	if _, ok := client.revisions["aaa-3"]; !ok {
+		t.Error("Expected the new revision to be indexed")
+	}
+
+	// elements not reloaded at startup are looked up in the live index
+	delete(backend.prevRevision, "aaa")
+	b, _ = node.MarshalJSON()
+	rawMessage = json.RawMessage(b)
+	client.searchResult.Hits.Hits = []*elastic.SearchHit{{Source: &rawMessage}}
+
+	mg.addMetadata(node, "MTU", 1530, time.Unix(4, 0))
+	if !g.NodeUpdated(node) {
+		t.Fatal("Expected the revision to be looked up in the live index")
+	}
+	if _, ok := client.revisions["aaa-4"]; !ok {
+		t.Error("Expected the new revision to be indexed")
+	}
+}
+
+func TestElasticsearchHistoryPurge(t *testing.T) {
+	_, client := newElasticsearchGraph(t)
+
+	var hits []*elastic.SearchHit
+	for _, archivedAt := range []int64{1000, 2000} {
+		rawMessage := json.RawMessage(`{"ID":"aaa","ArchivedAt":` + strconv.FormatInt(archivedAt, 10) + `}`)
+		hits = append(hits, &elastic.SearchHit{Source: &rawMessage})
+	}
+	client.searchResult.Hits.Hits = hits
+
+	backend, err := NewElasticSearchBackendFromClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.purge = &HistoryPurgeConfig{Retention: time.Hour}
+
+	client.searches = nil
+	if err := backend.purgeHistory(time.Unix(7200, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.searches) != 0 || len(client.deletes) != 2 {
+		t.Fatalf("Expected the node and edge revisions to be deleted without search, got: %d searches, %d deletes", len(client.searches), len(client.deletes))
+	}
+
+	src, _ := client.deletes[0].Source()
+	expected, _ := elastic.NewRangeQuery("ArchivedAt").Lt(int64(3600000)).Source()
+	if !reflect.DeepEqual(src, expected) {
+		t.Errorf("Expected the revisions archived before the retention to be deleted, got: %v", src)
+	}
+
+	dir, err := ioutil.TempDir("", "skydive-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend.purge.SnapshotPath = dir
+	if err := backend.purgeHistory(time.Unix(7200, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "topology-*.json.gz"))
+	if len(files) != 1 {
+		t.Fatalf("Expected a snapshot file, got: %v", files)
+	}
+
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var revision snapshotRevision
+		if err := json.Unmarshal(scanner.Bytes(), &revision); err != nil {
+			t.Fatal(err)
+		}
+		kinds = append(kinds, revision.Kind)
+	}
+
+	if !reflect.DeepEqual(kinds, []string{"node", "node", "edge", "edge"}) {
+		t.Errorf("Expected the node and edge revisions to be snapshotted, got: %v", kinds)
+	}
+	if len(client.deletes) != 4 {
+		t.Errorf("Expected the snapshotted revisions to be deleted, got: %d deletes", len(client.deletes))
+	}
+}
+
+func TestElasticsearchPagination(t *testing.T) {
+	_, client := newElasticsearchGraph(t)
+
+	var hits []*elastic.SearchHit
+	for i := 0; i < 5; i++ {
+		rawMessage := json.RawMessage(`{"ID":"node` + strconv.Itoa(i) + `"}`)
+		hits = append(hits, &elastic.SearchHit{Source: &rawMessage, Sort: []interface{}{i + 1}})
+	}
+	client.searchResult.Hits.Hits = hits
+
+	backend, err := NewElasticSearchBackendFromClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.searches = nil
+	nodes := backend.searchNodes(&TimedSearchQuery{PageSize: 2}, "")
+	if len(nodes) != 5 || len(client.searches) != 3 {
+		t.Fatalf("Expected the 5 nodes to be read in 3 pages, got: %d nodes, %d searches", len(nodes), len(client.searches))
+	}
+	for i, node := range nodes {
+		if node.ID != Identifier("node"+strconv.Itoa(i)) {
+			t.Errorf("Expected the nodes in the order of the pages, got: %s at %d", node.ID, i)
+		}
+	}
+
+	sr, next, err := backend.QueryPage("node", &TimedSearchQuery{PageSize: 2, SearchAfter: []interface{}{2}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Hits.Hits) != 2 || !reflect.DeepEqual(next, []interface{}{4}) {
+		t.Errorf("Expected the second page and the sort values of its last hit, got: %d hits, %v", len(sr.Hits.Hits), next)
+	}
+
+	client.searches = nil
+	nodes = backend.searchNodes(&TimedSearchQuery{PageSize: 2, SearchAfter: next}, "")
+	if len(nodes) != 1 || len(client.searches) != 1 {
+		t.Errorf("Expected only the last page to be read, got: %d nodes, %d searches", len(nodes), len(client.searches))
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"github.com/skydive-project/skydive/common"
+)
+
+// CollapsedRegion summarizes the part of the graph hidden behind a node
+// located at the border of a fragment
+type CollapsedRegion struct {
+	ID    Identifier
+	Nodes int
+	Edges int
+	Types map[string]int
+}
+
+// Fragment is the neighborhood of a node up to a given number of hops.
+// Nodes at the border of the fragment carry a summary of their hidden
+// neighbors so that consumers can expand them lazily.
+type Fragment struct {
+	Root      Identifier
+	Hops      int
+	Nodes     []*Node
+	Edges     []*Edge
+	Collapsed []*CollapsedRegion
+}
+
+func edgePeer(e *Edge, n *Node) Identifier {
+	if e.GetParent() == n.ID {
+		return e.GetChild()
+	}
+	return e.GetParent()
+}
+
+// GetFragment returns the nodes that can be reached from n using at most
+// the given number of hops through edges matching em
+func (g *Graph) GetFragment(n *Node, hops int, em GraphElementMatcher) *Fragment {
+	depth := map[Identifier]int{n.ID: 0}
+	nodes := []*Node{n}
+
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[i]
+		if depth[node.ID] >= hops {
+			continue
+		}
+
+		for _, e := range g.GetNodeEdges(node, em) {
+			peer := edgePeer(e, node)
+			if _, ok := depth[peer]; ok {
+				continue
+			}
+			if pn := g.GetNode(peer); pn != nil {
+				depth[peer] = depth[node.ID] + 1
+				nodes = append(nodes, pn)
+			}
+		}
+	}
+
+	fragment := &Fragment{
+		Root:      n.ID,
+		Hops:      hops,
+		Nodes:     nodes,
+		Edges:     []*Edge{},
+		Collapsed: []*CollapsedRegion{},
+	}
+
+	edges := make(map[Identifier]bool)
+	for _, node := range nodes {
+		var region *CollapsedRegion
+		hidden := make(map[Identifier]bool)
+
+		for _, e := range g.GetNodeEdges(node, em) {
+			peer := edgePeer(e, node)
+			if _, ok := depth[peer]; ok {
+				if !edges[e.ID] {
+					edges[e.ID] = true
+					fragment.Edges = append(fragment.Edges, e)
+				}
+				continue
+			}
+
+			if region == nil {
+				region = &CollapsedRegion{ID: node.ID, Types: make(map[string]int)}
+				fragment.Collapsed = append(fragment.Collapsed, region)
+			}
+			region.Edges++
+
+			if hidden[peer] {
+				continue
+			}
+			hidden[peer] = true
+
+			if pn := g.GetNode(peer); pn != nil {
+				region.Nodes++
+				if tp, err := pn.GetFieldString("Type"); err == nil {
+					region.Types[tp]++
+				}
+			}
+		}
+	}
+
+	SortNodes(fragment.Nodes, "CreatedAt", common.SortAscending)
+	SortEdges(fragment.Edges, "CreatedAt", common.SortAscending)
+
+	return fragment
+}
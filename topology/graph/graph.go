@@ -152,6 +152,8 @@ type Graph struct {
 	backend      GraphBackend
 	context      GraphContext
 	host         string
+	middlewares  []GraphMiddleware
+	chain        GraphEventProcessor
 }
 
 // HostNodeTIDMap a map of host and node ID
@@ -358,6 +360,16 @@ func (e *graphElement) Host() string {
 	return e.host
 }
 
+// ShiftTime shifts the timestamps of the element, used to compensate the
+// clock skew of the host it comes from
+func (e *graphElement) ShiftTime(d time.Duration) {
+	for _, t := range []*time.Time{&e.createdAt, &e.updatedAt, &e.deletedAt} {
+		if !t.IsZero() {
+			*t = t.Add(d)
+		}
+	}
+}
+
 func (e *graphElement) GetFieldInt64(field string) (_ int64, err error) {
 	f, err := e.GetField(field)
 	if err != nil {
@@ -724,16 +736,20 @@ func dedupEdges(edges []*Edge) []*Edge {
 // NodeUpdated updates a node
 func (g *Graph) NodeUpdated(n *Node) bool {
 	if node := g.GetNode(n.ID); node != nil {
+		state := g.saveState(&node.graphElement)
+
 		node.metadata = n.metadata
 		node.updatedAt = n.updatedAt
 		node.revision = n.revision
 
-		if !g.backend.MetadataUpdated(node) {
-			return false
-		}
+		return g.process(&GraphEvent{Type: NodeUpdatedMsgType, Node: node}, func() bool {
+			if !g.backend.MetadataUpdated(node) {
+				return false
+			}
 
-		g.eventHandler.notifyEvent(graphEvent{kind: nodeUpdated, element: node})
-		return true
+			g.eventHandler.notifyEvent(graphEvent{kind: nodeUpdated, element: node})
+			return true
+		}, state)
 	}
 	return false
 }
@@ -741,15 +757,19 @@ func (g *Graph) NodeUpdated(n *Node) bool {
 // EdgeUpdated updates an edge
 func (g *Graph) EdgeUpdated(e *Edge) bool {
 	if edge := g.GetEdge(e.ID); edge != nil {
+		state := g.saveState(&edge.graphElement)
+
 		edge.metadata = e.metadata
 		edge.updatedAt = e.updatedAt
 
-		if !g.backend.MetadataUpdated(edge) {
-			return false
-		}
+		return g.process(&GraphEvent{Type: EdgeUpdatedMsgType, Edge: edge}, func() bool {
+			if !g.backend.MetadataUpdated(edge) {
+				return false
+			}
 
-		g.eventHandler.notifyEvent(graphEvent{kind: edgeUpdated, element: edge})
-		return true
+			g.eventHandler.notifyEvent(graphEvent{kind: edgeUpdated, element: edge})
+			return true
+		}, state)
 	}
 	return false
 }
@@ -772,16 +792,13 @@ func (g *Graph) SetMetadata(i interface{}, m Metadata) bool {
 		return false
 	}
 
+	state := g.saveState(e)
+
 	e.metadata = m
 	e.updatedAt = time.Now().UTC()
 	e.revision++
 
-	if !g.backend.MetadataUpdated(i) {
-		return false
-	}
-
-	g.eventHandler.notifyEvent(ge)
-	return true
+	return g.processUpdate(ge, state)
 }
 
 // DelMetadata delete a metadata to an associated edge or node
@@ -798,17 +815,14 @@ func (g *Graph) DelMetadata(i interface{}, k string) bool {
 		ge.kind = edgeUpdated
 	}
 
+	state := g.saveState(e)
+
 	common.DelField(e.metadata, k)
 
 	e.updatedAt = time.Now().UTC()
 	e.revision++
 
-	if !g.backend.MetadataUpdated(i) {
-		return false
-	}
-
-	g.eventHandler.notifyEvent(ge)
-	return true
+	return g.processUpdate(ge, state)
 }
 
 // SetField set metadata value based on dot key ("a.b.c.d" = "ok")
@@ -833,6 +847,8 @@ func (g *Graph) addMetadata(i interface{}, k string, v interface{}, t time.Time)
 		return false
 	}
 
+	state := g.saveState(e)
+
 	if !e.metadata.SetField(k, v) {
 		return false
 	}
@@ -840,12 +856,28 @@ func (g *Graph) addMetadata(i interface{}, k string, v interface{}, t time.Time)
 	e.updatedAt = t
 	e.revision++
 
-	if !g.backend.MetadataUpdated(i) {
-		return false
+	return g.processUpdate(ge, state)
+}
+
+// processUpdate passes a metadata update of a node or an edge through the
+// middlewares before storing and notifying it
+func (g *Graph) processUpdate(ge graphEvent, state *elementState) bool {
+	ev := &GraphEvent{}
+	switch e := ge.element.(type) {
+	case *Node:
+		ev.Type, ev.Node = NodeUpdatedMsgType, e
+	case *Edge:
+		ev.Type, ev.Edge = EdgeUpdatedMsgType, e
 	}
 
-	g.eventHandler.notifyEvent(ge)
-	return true
+	return g.process(ev, func() bool {
+		if !g.backend.MetadataUpdated(ge.element) {
+			return false
+		}
+
+		g.eventHandler.notifyEvent(ge)
+		return true
+	}, state)
 }
 
 // AddMetadata add a metadata to an associated edge or node
@@ -987,6 +1019,54 @@ func (g *Graph) LookupShortestPath(n *Node, m GraphElementMatcher, em GraphEleme
 	return retNodes
 }
 
+// LookupEdgePath returns the edges of the shortest path between two nodes
+// made of edges matching em, nil if the nodes are not connected within
+// maxHops edges. The excluded nodes and edges are not crossed.
+func (g *Graph) LookupEdgePath(from, to *Node, em GraphElementMatcher, maxHops int, excluded map[Identifier]bool) []*Edge {
+	previous := map[Identifier]*Edge{from.ID: nil}
+	frontier := []*Node{from}
+
+	for hop := 0; hop < maxHops && len(frontier) > 0; hop++ {
+		var next []*Node
+		for _, n := range frontier {
+			for _, e := range g.backend.GetNodeEdges(n, g.context, em) {
+				peer := e.GetParent()
+				if peer == n.ID {
+					peer = e.GetChild()
+				}
+				if _, ok := previous[peer]; ok || excluded[e.ID] || excluded[peer] {
+					continue
+				}
+				previous[peer] = e
+
+				if peer == to.ID {
+					var path []*Edge
+					for id := to.ID; previous[id] != nil; {
+						e := previous[id]
+						path = append(path, e)
+						if e.GetParent() == id {
+							id = e.GetChild()
+						} else {
+							id = e.GetParent()
+						}
+					}
+					for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+						path[i], path[j] = path[j], path[i]
+					}
+					return path
+				}
+
+				if pn := g.GetNode(peer); pn != nil {
+					next = append(next, pn)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	return nil
+}
+
 // LookupParents returns the associated parents edge of a node
 func (g *Graph) LookupParents(n *Node, f GraphElementMatcher, em GraphElementMatcher) (nodes []*Node) {
 	for _, e := range g.backend.GetNodeEdges(n, g.context, em) {
@@ -1095,12 +1175,14 @@ func (g *Graph) EdgeAdded(e *Edge) bool {
 
 // AddEdge in the graph
 func (g *Graph) AddEdge(e *Edge) bool {
-	if !g.backend.EdgeAdded(e) {
-		return false
-	}
-	g.eventHandler.notifyEvent(graphEvent{element: e, kind: edgeAdded})
+	return g.process(&GraphEvent{Type: EdgeAddedMsgType, Edge: e}, func() bool {
+		if !g.backend.EdgeAdded(e) {
+			return false
+		}
+		g.eventHandler.notifyEvent(graphEvent{element: e, kind: edgeAdded})
 
-	return true
+		return true
+	}, g.saveState(&e.graphElement))
 }
 
 // GetEdge with Identifier i
@@ -1121,12 +1203,14 @@ func (g *Graph) NodeAdded(n *Node) bool {
 
 // AddNode in the graph
 func (g *Graph) AddNode(n *Node) bool {
-	if !g.backend.NodeAdded(n) {
-		return false
-	}
-	g.eventHandler.notifyEvent(graphEvent{element: n, kind: nodeAdded})
+	return g.process(&GraphEvent{Type: NodeAddedMsgType, Node: n}, func() bool {
+		if !g.backend.NodeAdded(n) {
+			return false
+		}
+		g.eventHandler.notifyEvent(graphEvent{element: n, kind: nodeAdded})
 
-	return true
+		return true
+	}, g.saveState(&n.graphElement))
 }
 
 // GetNode from Identifier
@@ -1221,17 +1305,26 @@ func (g *Graph) NewEdge(i Identifier, p *Node, c *Node, m Metadata, h ...string)
 
 // EdgeDeleted event
 func (g *Graph) EdgeDeleted(e *Edge) {
-	if g.backend.EdgeDeleted(e) {
+	g.process(&GraphEvent{Type: EdgeDeletedMsgType, Edge: e}, func() bool {
+		if !g.backend.EdgeDeleted(e) {
+			return false
+		}
 		g.eventHandler.notifyEvent(graphEvent{element: e, kind: edgeDeleted})
-	}
+		return true
+	}, g.saveState(&e.graphElement))
 }
 
-func (g *Graph) delEdge(e *Edge, t time.Time) (success bool) {
+func (g *Graph) delEdge(e *Edge, t time.Time) bool {
+	state := g.saveState(&e.graphElement)
+
 	e.deletedAt = t
-	if success = g.backend.EdgeDeleted(e); success {
+	return g.process(&GraphEvent{Type: EdgeDeletedMsgType, Edge: e}, func() bool {
+		if !g.backend.EdgeDeleted(e) {
+			return false
+		}
 		g.eventHandler.notifyEvent(graphEvent{element: e, kind: edgeDeleted})
-	}
-	return
+		return true
+	}, state)
 }
 
 // DelEdge delete an edge
@@ -1241,21 +1334,32 @@ func (g *Graph) DelEdge(e *Edge) bool {
 
 // NodeDeleted event
 func (g *Graph) NodeDeleted(n *Node) {
-	if g.backend.NodeDeleted(n) {
+	g.process(&GraphEvent{Type: NodeDeletedMsgType, Node: n}, func() bool {
+		if !g.backend.NodeDeleted(n) {
+			return false
+		}
 		g.eventHandler.notifyEvent(graphEvent{element: n, kind: nodeDeleted})
-	}
+		return true
+	}, g.saveState(&n.graphElement))
 }
 
-func (g *Graph) delNode(n *Node, t time.Time) (success bool) {
-	for _, e := range g.backend.GetNodeEdges(n, liveContext, nil) {
-		g.delEdge(e, t)
-	}
+func (g *Graph) delNode(n *Node, t time.Time) bool {
+	state := g.saveState(&n.graphElement)
 
+	// the edges are deleted once the node deletion went through the
+	// middlewares so that a vetoed deletion keeps them
 	n.deletedAt = t
-	if success = g.backend.NodeDeleted(n); success {
+	return g.process(&GraphEvent{Type: NodeDeletedMsgType, Node: n}, func() bool {
+		for _, e := range g.backend.GetNodeEdges(n, liveContext, nil) {
+			g.delEdge(e, t)
+		}
+
+		if !g.backend.NodeDeleted(n) {
+			return false
+		}
 		g.eventHandler.notifyEvent(graphEvent{element: n, kind: nodeDeleted})
-	}
-	return
+		return true
+	}, state)
 }
 
 // DelNode delete the node n in the graph
@@ -1394,7 +1498,7 @@ func NewGraphFromConfig(backend GraphBackend) *Graph {
 }
 
 // NewBackendByName creates a new graph backend based on the name
-// memory, orientdb, elasticsearch backend are supported
+// memory, orientdb, elasticsearch, bolt, neo4j, redis and multi backends are supported
 func NewBackendByName(name string) (backend GraphBackend, err error) {
 	driver := config.GetString("storage." + name + ".driver")
 	switch driver {
@@ -1404,6 +1508,14 @@ func NewBackendByName(name string) (backend GraphBackend, err error) {
 		backend, err = NewOrientDBBackendFromConfig(name)
 	case "elasticsearch":
 		backend, err = NewElasticSearchBackendFromConfig(name)
+	case "bolt":
+		backend, err = NewBoltBackendFromConfig(name)
+	case "neo4j":
+		backend, err = NewNeo4jBackendFromConfig(name)
+	case "redis":
+		backend, err = NewRedisBackendFromConfig(name)
+	case "multi":
+		backend, err = NewMultiBackendFromConfig(name)
 	default:
 		return nil, errors.New(fmt.Sprintf("Toplogy backend driver '%s' not supported", driver))
 	}
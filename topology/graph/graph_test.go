@@ -23,6 +23,7 @@
 package graph
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -452,3 +453,226 @@ func TestRecursiveEvents(t *testing.T) {
 		t.Error("Events are not in the right order")
 	}
 }
+
+func TestFragment(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Value": 1, "Type": "host"})
+	n2 := g.NewNode(GenID(), Metadata{"Value": 2, "Type": "netns"})
+	n3 := g.NewNode(GenID(), Metadata{"Value": 3, "Type": "intf"})
+	n4 := g.NewNode(GenID(), Metadata{"Value": 4, "Type": "intf"})
+	n5 := g.NewNode(GenID(), Metadata{"Value": 5, "Type": "intf"})
+
+	g.Link(n1, n2, nil)
+	g.Link(n2, n3, nil)
+	g.Link(n3, n4, nil)
+	g.Link(n3, n5, nil)
+
+	fragment := g.GetFragment(n2, 1, nil)
+	if len(fragment.Nodes) != 3 || len(fragment.Edges) != 2 {
+		t.Fatalf("Expected 3 nodes and 2 edges, got: %v", fragment)
+	}
+
+	if len(fragment.Collapsed) != 1 {
+		t.Fatalf("Expected one collapsed region, got: %v", fragment.Collapsed)
+	}
+
+	region := fragment.Collapsed[0]
+	if region.ID != n3.ID || region.Nodes != 2 || region.Edges != 2 || region.Types["intf"] != 2 {
+		t.Errorf("Wrong collapsed region: %+v", region)
+	}
+
+	fragment = g.GetFragment(n1, 3, nil)
+	if len(fragment.Nodes) != 5 || len(fragment.Edges) != 4 || len(fragment.Collapsed) != 0 {
+		t.Errorf("Expected the whole graph, got: %v", fragment)
+	}
+}
+
+func TestSummaryGraph(t *testing.T) {
+	g := newGraph(t)
+
+	host := g.NewNode(GenID(), Metadata{"Name": "host", "Type": "host"})
+	pod1 := g.NewNode(GenID(), Metadata{"Name": "pod1", "Type": "pod", "K8s": map[string]interface{}{"Namespace": "ns1"}})
+	pod2 := g.NewNode(GenID(), Metadata{"Name": "pod2", "Type": "pod", "K8s": map[string]interface{}{"Namespace": "ns1"}})
+	pod3 := g.NewNode(GenID(), Metadata{"Name": "pod3", "Type": "pod", "K8s": map[string]interface{}{"Namespace": "ns2"}})
+
+	g.Link(host, pod1, Metadata{"RelationType": "ownership"})
+	g.Link(host, pod2, Metadata{"RelationType": "ownership"})
+	g.Link(host, pod3, Metadata{"RelationType": "ownership"})
+	g.Link(pod1, pod2, Metadata{"RelationType": "layer2"})
+	g.Link(pod1, pod3, Metadata{"RelationType": "layer2"})
+
+	sg, err := NewSummaryGraph(g, g.GetNodes(nil), "K8s.Namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nodes := sg.GetNodes(nil); len(nodes) != 3 {
+		t.Fatalf("Expected 3 nodes, got: %v", nodes)
+	}
+
+	ns1 := sg.LookupFirstNode(Metadata{"Type": "summary", "Name": "ns1"})
+	if ns1 == nil {
+		t.Fatalf("Summary node of ns1 not found: %s", sg.String())
+	}
+
+	if count, _ := ns1.GetFieldInt64("Summary.Count"); count != 2 {
+		t.Errorf("Expected 2 nodes in ns1, got: %d", count)
+	}
+
+	edges := sg.GetNodeEdges(ns1, Metadata{"RelationType": "ownership"})
+	if len(edges) != 1 {
+		t.Fatalf("Expected one ownership edge, got: %v", edges)
+	}
+
+	if weight, _ := edges[0].GetFieldInt64("Weight"); weight != 2 {
+		t.Errorf("Expected a weight of 2, got: %d", weight)
+	}
+
+	if edges := sg.GetNodeEdges(ns1, Metadata{"RelationType": "layer2"}); len(edges) != 1 {
+		t.Errorf("Expected one layer2 edge between the namespaces, got: %v", edges)
+	}
+}
+
+func TestLookupEdgePath(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Value": 1})
+	n2 := g.NewNode(GenID(), Metadata{"Value": 2})
+	n3 := g.NewNode(GenID(), Metadata{"Value": 3})
+	n4 := g.NewNode(GenID(), Metadata{"Value": 4})
+
+	e12 := g.Link(n1, n2, Metadata{"RelationType": "layer2"})
+	e23 := g.Link(n2, n3, Metadata{"RelationType": "layer2"})
+	g.Link(n1, n4, Metadata{"RelationType": "layer2"})
+	g.Link(n4, n3, Metadata{"RelationType": "layer2"})
+	g.Link(n1, n3, Metadata{"RelationType": "ownership"})
+
+	path := g.LookupEdgePath(n1, n3, Metadata{"RelationType": "layer2"}, 10, nil)
+	if len(path) != 2 {
+		t.Fatalf("Expected a path of 2 edges, got: %v", path)
+	}
+
+	path = g.LookupEdgePath(n1, n3, Metadata{"RelationType": "layer2"}, 10, map[Identifier]bool{n4.ID: true})
+	if len(path) != 2 || path[0].ID != e12.ID || path[1].ID != e23.ID {
+		t.Errorf("Expected the path through n2, got: %v", path)
+	}
+
+	excluded := map[Identifier]bool{n4.ID: true, e23.ID: true}
+	if path = g.LookupEdgePath(n1, n3, Metadata{"RelationType": "layer2"}, 10, excluded); path != nil {
+		t.Errorf("Expected no path, got: %v", path)
+	}
+
+	if path = g.LookupEdgePath(n1, n3, Metadata{"RelationType": "layer2"}, 1, nil); path != nil {
+		t.Errorf("Expected no path within one hop, got: %v", path)
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	g := newGraph(t)
+
+	var order []string
+	trace := func(name string) GraphMiddleware {
+		return func(next GraphEventProcessor) GraphEventProcessor {
+			return func(ev *GraphEvent) error {
+				order = append(order, name)
+				return next(ev)
+			}
+		}
+	}
+	veto := func(next GraphEventProcessor) GraphEventProcessor {
+		return func(ev *GraphEvent) error {
+			if _, ok := ev.Metadata["Veto"]; ok && ev.Type != NodeDeletedMsgType {
+				return errors.New("vetoed")
+			}
+			if ev.Type == NodeDeletedMsgType && ev.Metadata["Value"] == 2 {
+				return errors.New("vetoed")
+			}
+			return next(ev)
+		}
+	}
+
+	g.Use(trace("first"), trace("second"), ScrubMetadataMiddleware("Secret", "Nested.Secret"), veto)
+
+	n1 := g.NewNode(GenID(), Metadata{"Value": 1, "Secret": "s", "Nested": map[string]interface{}{"Secret": "s", "Public": "p"}})
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("Expected the middlewares to be called in order, got: %v", order)
+	}
+
+	if n1 = g.GetNode(n1.ID); n1 == nil {
+		t.Fatal("Expected the node to be added")
+	}
+	m := n1.Metadata()
+	if _, ok := m["Secret"]; ok {
+		t.Errorf("Expected the Secret metadata to be scrubbed, got: %v", m)
+	}
+	if nested := m["Nested"].(map[string]interface{}); len(nested) != 1 || nested["Public"] != "p" {
+		t.Errorf("Expected only Nested.Public to be kept, got: %v", nested)
+	}
+
+	if n := g.NewNode(GenID(), Metadata{"Value": 3, "Veto": true}); n != nil || len(g.GetNodes(nil)) != 1 {
+		t.Error("Expected the addition to be vetoed")
+	}
+
+	revision := n1.revision
+	if g.AddMetadata(n1, "Veto", true) {
+		t.Error("Expected the update to be vetoed")
+	}
+	if _, ok := n1.Metadata()["Veto"]; ok || n1.revision != revision {
+		t.Errorf("Expected the update to be rolled back, got: %v", n1.Metadata())
+	}
+
+	n2 := g.NewNode(GenID(), Metadata{"Value": 2})
+	g.NewEdge(GenID(), n1, n2, nil)
+
+	if g.DelNode(n2) {
+		t.Error("Expected the deletion to be vetoed")
+	}
+	if g.GetNode(n2.ID) == nil || len(g.GetNodeEdges(n2, nil)) != 1 {
+		t.Error("Expected the node and its edge to be kept")
+	}
+
+	if !g.DelNode(n1) || len(g.GetNodeEdges(n2, nil)) != 0 {
+		t.Error("Expected the node and its edge to be deleted")
+	}
+}
+
+func TestStagingGraph(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "eth0"})
+
+	staging, err := NewStagingGraph(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sn1 := staging.GetNode(n1.ID)
+	if sn1 == nil || sn1 == n1 {
+		t.Fatal("Expected the existing node to be copied into the staging graph")
+	}
+
+	n2 := g.NewNode(GenID(), Metadata{"Name": "eth1"})
+	g.NewEdge(GenID(), n1, n2, Metadata{"RelationType": "layer2"})
+	if staging.GetNode(n2.ID) == nil || len(staging.GetEdges(nil)) != 1 {
+		t.Fatal("Expected the production events to be mirrored")
+	}
+
+	staging.AddMetadata(sn1, "Shadow", true)
+	shadow := staging.NewNode(GenID(), Metadata{"Name": "shadow"})
+	staging.NewEdge(GenID(), sn1, shadow, nil)
+
+	if _, ok := n1.Metadata()["Shadow"]; ok || len(g.GetNodes(nil)) != 2 || len(g.GetEdges(nil)) != 1 {
+		t.Error("Expected the production graph to be left untouched")
+	}
+
+	g.AddMetadata(n1, "MTU", 1500)
+	if m := sn1.Metadata(); m["MTU"] != 1500 || m["Shadow"] != true {
+		t.Errorf("Expected the production update to keep the staging metadata, got: %v", m)
+	}
+
+	g.DelNode(n1)
+	if staging.GetNode(n1.ID) != nil || len(staging.GetEdges(nil)) != 0 || staging.GetNode(shadow.ID) == nil {
+		t.Error("Expected the deletion to be mirrored")
+	}
+}
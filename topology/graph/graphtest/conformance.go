@@ -0,0 +1,481 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+// Package graphtest provides a conformance test suite for the graph backends.
+// The suite only uses the GraphBackend interface and unique identifiers, so it
+// can run against a shared database, a dockerized Elasticsearch or OrientDB
+// for instance.
+package graphtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// BackendFactory returns the backend a test of the suite runs against
+type BackendFactory func(t *testing.T) graph.GraphBackend
+
+// Opts holds the options of the conformance suite
+type Opts struct {
+	// Timeout is the delay for the writes to be visible by the queries, for
+	// the backends indexing asynchronously like Elasticsearch
+	Timeout time.Duration
+
+	// Writers and Updates are the number of concurrent writers and the number
+	// of updates each of them does in the concurrent mutation test
+	Writers int
+	Updates int
+}
+
+var liveContext = graph.GraphContext{TimePoint: true}
+
+type suite struct {
+	t       *testing.T
+	opts    Opts
+	backend graph.GraphBackend
+	g       *graph.Graph
+	host    string
+	base    int64
+}
+
+// RunConformance runs the conformance suite against the backends returned by
+// the factory, the history tests are skipped for the backends without history
+func RunConformance(t *testing.T, factory BackendFactory, opts Opts) {
+	if opts.Writers == 0 {
+		opts.Writers = 4
+	}
+	if opts.Updates == 0 {
+		opts.Updates = 10
+	}
+
+	tests := []struct {
+		name    string
+		history bool
+		run     func(s *suite)
+	}{
+		{"NodeLifecycle", false, testNodeLifecycle},
+		{"EdgeLifecycle", false, testEdgeLifecycle},
+		{"Revisions", true, testRevisions},
+		{"TimeSlice", true, testTimeSlice},
+		{"ConcurrentMutations", false, testConcurrentMutations},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			backend := factory(t)
+			if test.history && !backend.IsHistorySupported() {
+				t.Skip("History not supported by the backend")
+			}
+
+			host := "conformance-" + string(graph.GenID())
+			test.run(&suite{
+				t:       t,
+				opts:    opts,
+				backend: backend,
+				g:       graph.NewGraph(host, backend),
+				host:    host,
+				// the elements are created in the past so that the time slice
+				// queries don't depend on the clock of the backend
+				base: common.UnixMillis(time.Now()) - 3600*1000,
+			})
+		})
+	}
+}
+
+// at returns the timestamp of the given offset, in seconds, from the
+// beginning of the test
+func (s *suite) at(offset int) int64 {
+	return s.base + int64(offset)*1000
+}
+
+func (s *suite) pointContext(offset int) graph.GraphContext {
+	return graph.GraphContext{TimeSlice: common.NewTimeSlice(s.at(offset), s.at(offset)), TimePoint: true}
+}
+
+func (s *suite) sliceContext(from, to int) graph.GraphContext {
+	return graph.GraphContext{TimeSlice: common.NewTimeSlice(s.at(from), s.at(to))}
+}
+
+// element returns the serialized form of a graph element, decoded the same way
+// as the elements forwarded by the agents
+func (s *suite) element(id graph.Identifier, m graph.Metadata, created, updated, deleted int64, revision int64) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	for k, v := range m {
+		metadata[k] = v
+	}
+
+	obj := map[string]interface{}{
+		"ID":        string(id),
+		"Host":      s.host,
+		"Metadata":  metadata,
+		"CreatedAt": created,
+		"UpdatedAt": updated,
+		"Revision":  json.Number(strconv.FormatInt(revision, 10)),
+	}
+	if deleted != 0 {
+		obj["DeletedAt"] = deleted
+	}
+	return obj
+}
+
+func (s *suite) node(id graph.Identifier, m graph.Metadata, created, updated, deleted int64, revision int64) *graph.Node {
+	n := new(graph.Node)
+	if err := n.Decode(s.element(id, m, created, updated, deleted, revision)); err != nil {
+		s.t.Fatal(err)
+	}
+	return n
+}
+
+func (s *suite) edge(id, parent, child graph.Identifier, m graph.Metadata, created, deleted int64) *graph.Edge {
+	obj := s.element(id, m, created, created, deleted, 1)
+	obj["Parent"] = string(parent)
+	obj["Child"] = string(child)
+
+	e := new(graph.Edge)
+	if err := e.Decode(obj); err != nil {
+		s.t.Fatal(err)
+	}
+	return e
+}
+
+// eventually retries the check until it succeeds or the timeout expires
+func (s *suite) eventually(check func() error) {
+	deadline := time.Now().Add(s.opts.Timeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (s *suite) addNode(n *graph.Node) {
+	s.g.Lock()
+	defer s.g.Unlock()
+
+	if !s.g.NodeAdded(n) {
+		s.t.Fatalf("Failed to add node %s", n.ID)
+	}
+}
+
+func (s *suite) updateNode(n *graph.Node) {
+	s.g.Lock()
+	defer s.g.Unlock()
+
+	if !s.g.NodeUpdated(n) {
+		s.t.Fatalf("Failed to update node %s", n.ID)
+	}
+}
+
+// waitNode waits for the node to be visible with the given revision
+func (s *suite) waitNode(id graph.Identifier, revision int64) {
+	s.eventually(func() error {
+		return s.expectNodes(s.backend.GetNode(id, liveContext), revision)
+	})
+}
+
+// expectNodes checks that the nodes are the expected revisions of a node
+func (s *suite) expectNodes(nodes []*graph.Node, revisions ...int64) error {
+	var got []int64
+	for _, n := range nodes {
+		revision, _ := n.GetFieldInt64("Revision")
+		got = append(got, revision)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+
+	if fmt.Sprint(got) != fmt.Sprint(revisions) {
+		return fmt.Errorf("Expected the revisions %v, got %v", revisions, got)
+	}
+	return nil
+}
+
+func testNodeLifecycle(s *suite) {
+	id := graph.GenID()
+	name := "intf-" + string(id)
+
+	s.addNode(s.node(id, graph.Metadata{"Name": name, "Type": "device", "MTU": int64(1500)}, s.at(1), s.at(1), 0, 1))
+	s.waitNode(id, 1)
+
+	s.eventually(func() error {
+		return s.expectNodes(s.backend.GetNodes(liveContext, graph.Metadata{"Name": name}), 1)
+	})
+
+	s.updateNode(s.node(id, graph.Metadata{"Name": name, "Type": "device", "MTU": int64(1510)}, s.at(1), s.at(2), 0, 2))
+	s.waitNode(id, 2)
+
+	nodes := s.backend.GetNodes(liveContext, graph.Metadata{"Name": name})
+	if len(nodes) != 1 {
+		s.t.Fatalf("Expected one node, got %v", nodes)
+	}
+	if mtu, _ := nodes[0].GetFieldInt64("MTU"); mtu != 1510 {
+		s.t.Errorf("Expected the updated metadata, got %v", nodes[0])
+	}
+	if created, _ := nodes[0].GetFieldInt64("CreatedAt"); created != s.at(1) {
+		s.t.Errorf("Expected the creation time to be kept, got %v", nodes[0])
+	}
+
+	s.g.Lock()
+	s.g.NodeDeleted(s.node(id, nil, s.at(1), s.at(2), s.at(3), 2))
+	s.g.Unlock()
+
+	s.eventually(func() error {
+		if nodes := s.backend.GetNode(id, liveContext); len(nodes) != 0 {
+			return fmt.Errorf("Node still alive after deletion: %v", nodes)
+		}
+		return nil
+	})
+}
+
+func testEdgeLifecycle(s *suite) {
+	parentID, childID, edgeID := graph.GenID(), graph.GenID(), graph.GenID()
+
+	s.addNode(s.node(parentID, graph.Metadata{"Name": "parent", "Type": "host"}, s.at(1), s.at(1), 0, 1))
+	s.addNode(s.node(childID, graph.Metadata{"Name": "child", "Type": "device"}, s.at(1), s.at(1), 0, 1))
+	s.waitNode(parentID, 1)
+	s.waitNode(childID, 1)
+
+	m := graph.Metadata{"RelationType": "ownership"}
+
+	s.g.Lock()
+	if !s.g.EdgeAdded(s.edge(edgeID, parentID, childID, m, s.at(2), 0)) {
+		s.t.Fatalf("Failed to add edge %s", edgeID)
+	}
+	s.g.Unlock()
+
+	s.eventually(func() error {
+		edges := s.backend.GetEdge(edgeID, liveContext)
+		if len(edges) != 1 {
+			return fmt.Errorf("Expected one edge, got %v", edges)
+		}
+		if edges[0].GetParent() != parentID || edges[0].GetChild() != childID {
+			return fmt.Errorf("Wrong edge ends: %v", edges[0])
+		}
+		return nil
+	})
+
+	parent := s.backend.GetNode(parentID, liveContext)[0]
+	s.eventually(func() error {
+		edges := s.backend.GetNodeEdges(parent, liveContext, m)
+		if len(edges) != 1 || edges[0].ID != edgeID {
+			return fmt.Errorf("Expected the edge %s, got %v", edgeID, edges)
+		}
+		return nil
+	})
+
+	parents, children := s.backend.GetEdgeNodes(s.backend.GetEdge(edgeID, liveContext)[0], liveContext, nil, nil)
+	if len(parents) != 1 || parents[0].ID != parentID || len(children) != 1 || children[0].ID != childID {
+		s.t.Errorf("Wrong nodes of the edge, got %v and %v", parents, children)
+	}
+
+	s.g.Lock()
+	s.g.EdgeDeleted(s.edge(edgeID, parentID, childID, m, s.at(2), s.at(3)))
+	s.g.Unlock()
+
+	s.eventually(func() error {
+		if edges := s.backend.GetNodeEdges(parent, liveContext, nil); len(edges) != 0 {
+			return fmt.Errorf("Edge still alive after deletion: %v", edges)
+		}
+		return nil
+	})
+}
+
+func testRevisions(s *suite) {
+	id := graph.GenID()
+
+	s.addNode(s.node(id, graph.Metadata{"Type": "device", "MTU": int64(1500)}, s.at(1), s.at(1), 0, 1))
+	s.waitNode(id, 1)
+	s.updateNode(s.node(id, graph.Metadata{"Type": "device", "MTU": int64(1501)}, s.at(1), s.at(2), 0, 2))
+	s.waitNode(id, 2)
+	s.updateNode(s.node(id, graph.Metadata{"Type": "device", "MTU": int64(1502)}, s.at(1), s.at(3), 0, 3))
+	s.waitNode(id, 3)
+
+	s.eventually(func() error {
+		nodes := s.backend.GetNode(id, s.sliceContext(0, 10))
+		if err := s.expectNodes(nodes, 1, 2, 3); err != nil {
+			return err
+		}
+
+		// each revision keeps its own metadata
+		for _, n := range nodes {
+			revision, _ := n.GetFieldInt64("Revision")
+			if mtu, _ := n.GetFieldInt64("MTU"); mtu != 1499+revision {
+				return fmt.Errorf("Wrong metadata for the revision %d: %v", revision, n)
+			}
+		}
+		return nil
+	})
+
+	// the last revision of the slice is returned for a time point
+	s.eventually(func() error {
+		return s.expectNodes(s.backend.GetNode(id, graph.GraphContext{TimeSlice: common.NewTimeSlice(s.at(0), s.at(10)), TimePoint: true}), 3)
+	})
+}
+
+func testTimeSlice(s *suite) {
+	parentID, childID, edgeID := graph.GenID(), graph.GenID(), graph.GenID()
+	name := "intf-" + string(childID)
+
+	s.addNode(s.node(parentID, graph.Metadata{"Name": "parent", "Type": "host"}, s.at(1), s.at(1), 0, 1))
+	s.addNode(s.node(childID, graph.Metadata{"Name": name, "Type": "device", "MTU": int64(1500)}, s.at(1), s.at(1), 0, 1))
+	s.waitNode(parentID, 1)
+	s.waitNode(childID, 1)
+
+	m := graph.Metadata{"RelationType": "ownership"}
+
+	s.g.Lock()
+	s.g.EdgeAdded(s.edge(edgeID, parentID, childID, m, s.at(1), 0))
+	s.g.Unlock()
+
+	s.updateNode(s.node(childID, graph.Metadata{"Name": name, "Type": "device", "MTU": int64(1510)}, s.at(1), s.at(3), 0, 2))
+	s.waitNode(childID, 2)
+
+	s.g.Lock()
+	s.g.EdgeDeleted(s.edge(edgeID, parentID, childID, m, s.at(1), s.at(5)))
+	s.g.NodeDeleted(s.node(childID, nil, s.at(1), s.at(3), s.at(5), 2))
+	s.g.Unlock()
+
+	expected := []struct {
+		offset    int
+		revisions []int64
+		edges     int
+	}{
+		{0, nil, 0},
+		{2, []int64{1}, 1},
+		{4, []int64{2}, 1},
+		{6, nil, 0},
+	}
+
+	for _, e := range expected {
+		e := e
+		s.eventually(func() error {
+			context := s.pointContext(e.offset)
+			if err := s.expectNodes(s.backend.GetNode(childID, context), e.revisions...); err != nil {
+				return fmt.Errorf("At %ds: %s", e.offset, err)
+			}
+			if err := s.expectNodes(s.backend.GetNodes(context, graph.Metadata{"Name": name}), e.revisions...); err != nil {
+				return fmt.Errorf("At %ds, filtered by metadata: %s", e.offset, err)
+			}
+			if edges := s.backend.GetEdge(edgeID, context); len(edges) != e.edges {
+				return fmt.Errorf("At %ds: expected %d edges, got %v", e.offset, e.edges, edges)
+			}
+			return nil
+		})
+	}
+}
+
+// testConcurrentMutations checks that the concurrent updates, serialized by
+// the graph lock as done by the analyzers, are neither lost nor reordered
+func testConcurrentMutations(s *suite) {
+	id := graph.GenID()
+	suiteID := string(id)
+
+	// the revision r is updated r milliseconds after the creation
+	s.addNode(s.node(id, graph.Metadata{"Suite": suiteID, "Counter": int64(1)}, s.at(1), s.at(1)+1, 0, 1))
+	s.waitNode(id, 1)
+
+	revision := int64(1)
+	update := func(writer int) {
+		s.g.Lock()
+		defer s.g.Unlock()
+
+		revision++
+		m := graph.Metadata{"Suite": suiteID, "Counter": revision, "Writer": int64(writer)}
+		n := s.node(id, m, s.at(1), s.at(1)+revision, 0, revision)
+		if !s.g.NodeUpdated(n) {
+			s.t.Errorf("Failed to update node %s to revision %d", id, revision)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < s.opts.Writers; w++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+
+			// each writer also creates its own node
+			own := graph.GenID()
+			s.g.Lock()
+			s.g.NodeAdded(s.node(own, graph.Metadata{"Suite": suiteID, "Writer": int64(writer)}, s.at(1), s.at(1), 0, 1))
+			s.g.Unlock()
+
+			for i := 0; i < s.opts.Updates; i++ {
+				update(writer)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	last := int64(1 + s.opts.Writers*s.opts.Updates)
+	s.waitNode(id, last)
+
+	s.eventually(func() error {
+		nodes := s.backend.GetNodes(liveContext, graph.Metadata{"Suite": suiteID})
+		if len(nodes) != 1+s.opts.Writers {
+			return fmt.Errorf("Expected %d nodes, got %d", 1+s.opts.Writers, len(nodes))
+		}
+		return nil
+	})
+
+	n := s.backend.GetNode(id, liveContext)[0]
+	if counter, _ := n.GetFieldInt64("Counter"); counter != last {
+		s.t.Errorf("Expected the metadata of the last update, got %v", n)
+	}
+
+	if !s.backend.IsHistorySupported() {
+		return
+	}
+
+	s.eventually(func() error {
+		nodes := s.backend.GetNode(id, s.sliceContext(0, 3600))
+
+		var revisions []int64
+		for i := int64(1); i <= last; i++ {
+			revisions = append(revisions, i)
+		}
+		if err := s.expectNodes(nodes, revisions...); err != nil {
+			return err
+		}
+
+		for _, n := range nodes {
+			revision, _ := n.GetFieldInt64("Revision")
+			updated, _ := n.GetFieldInt64("UpdatedAt")
+			counter, _ := n.GetFieldInt64("Counter")
+			if counter != revision || updated != s.at(1)+revision {
+				return fmt.Errorf("Revision %d doesn't match its update: %v", revision, n)
+			}
+		}
+		return nil
+	})
+}
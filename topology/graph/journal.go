@@ -0,0 +1,200 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// JournalEntry describes a graph event of the journal, Obj being the node or
+// the edge as it was passed to the backend
+type JournalEntry struct {
+	Time int64
+	Type string
+	Obj  json.RawMessage
+}
+
+// Journal writes the graph events as JSON lines ahead of the backend, so
+// that the history stored by a persistent backend can be rebuilt from it
+type Journal struct {
+	sync.Mutex
+	w       io.WriteCloser
+	encoder *json.Encoder
+}
+
+// Append writes an event to the journal
+func (j *Journal) Append(ev *GraphEvent) error {
+	var (
+		obj []byte
+		err error
+	)
+	if ev.Node != nil {
+		obj, err = ev.Node.MarshalJSON()
+	} else {
+		obj, err = ev.Edge.MarshalJSON()
+	}
+	if err != nil {
+		return err
+	}
+
+	entry := &JournalEntry{
+		Time: common.UnixMillis(time.Now()),
+		Type: ev.Type,
+		Obj:  obj,
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	return j.encoder.Encode(entry)
+}
+
+// Close the journal
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.w.Close()
+}
+
+// NewJournal returns a journal writing into w
+func NewJournal(w io.WriteCloser) *Journal {
+	return &Journal{
+		w:       w,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// OpenJournal returns a journal appending to the file at path
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJournal(f), nil
+}
+
+// JournalMiddleware returns a middleware writing the events to the journal
+// before passing them on. It has to be the last middleware so that the
+// events are journaled as stored. A failing write is only reported, to not
+// stop the graph updates.
+func JournalMiddleware(j *Journal) GraphMiddleware {
+	return func(next GraphEventProcessor) GraphEventProcessor {
+		return func(ev *GraphEvent) error {
+			if err := j.Append(ev); err != nil {
+				logging.GetLogger().Errorf("Unable to journal graph event %s: %s", ev.Type, err)
+			}
+			return next(ev)
+		}
+	}
+}
+
+// ReplayJournal applies the events of the journal read from r to the
+// backend, in the journaled order, and returns the number of events
+// replayed. The events rejected by the backend are reported and skipped.
+func ReplayJournal(r io.Reader, b GraphBackend) (int, error) {
+	count := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return count, fmt.Errorf("Malformed journal entry at line %d: %s", line, err)
+		}
+
+		var obj interface{}
+		if err := common.JSONDecode(bytes.NewReader(entry.Obj), &obj); err != nil {
+			return count, fmt.Errorf("Malformed journal entry at line %d: %s", line, err)
+		}
+
+		var ok bool
+		switch entry.Type {
+		case NodeAddedMsgType, NodeUpdatedMsgType, NodeDeletedMsgType:
+			var node Node
+			if err := node.Decode(obj); err != nil {
+				return count, fmt.Errorf("Malformed node at line %d: %s", line, err)
+			}
+
+			switch entry.Type {
+			case NodeAddedMsgType:
+				ok = b.NodeAdded(&node)
+			case NodeUpdatedMsgType:
+				ok = b.MetadataUpdated(&node)
+			case NodeDeletedMsgType:
+				ok = b.NodeDeleted(&node)
+			}
+		case EdgeAddedMsgType, EdgeUpdatedMsgType, EdgeDeletedMsgType:
+			var edge Edge
+			if err := edge.Decode(obj); err != nil {
+				return count, fmt.Errorf("Malformed edge at line %d: %s", line, err)
+			}
+
+			switch entry.Type {
+			case EdgeAddedMsgType:
+				ok = b.EdgeAdded(&edge)
+			case EdgeUpdatedMsgType:
+				ok = b.MetadataUpdated(&edge)
+			case EdgeDeletedMsgType:
+				ok = b.EdgeDeleted(&edge)
+			}
+		default:
+			return count, fmt.Errorf("Unknown journal event %s at line %d", entry.Type, line)
+		}
+
+		if !ok {
+			logging.GetLogger().Warningf("Journal event %s at line %d rejected by the backend", entry.Type, line)
+			continue
+		}
+		count++
+	}
+
+	return count, scanner.Err()
+}
+
+// ReplayJournalFile applies the events of the journal file at path to the
+// backend
+func ReplayJournalFile(path string, b GraphBackend) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return ReplayJournal(f, b)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	elastic "github.com/olivere/elastic"
+)
+
+type journalBuffer struct {
+	bytes.Buffer
+}
+
+func (b *journalBuffer) Close() error {
+	return nil
+}
+
+func TestJournalReplay(t *testing.T) {
+	g, client := newElasticsearchGraph(t)
+
+	var buffer journalBuffer
+	g.Use(JournalMiddleware(NewJournal(&buffer)))
+
+	n1 := g.newNode("aaa", Metadata{"Name": "eth0"}, time.Unix(1, 0), "host1")
+	n2 := g.newNode("bbb", Metadata{"Name": "eth1"}, time.Unix(1, 0), "host1")
+	g.addMetadata(n1, "State", "UP", time.Unix(2, 0))
+
+	e := g.newEdge("eee", n1, n2, Metadata{"RelationType": "layer2"}, time.Unix(2, 0), "host1")
+	g.addMetadata(e, "Type", "veth", time.Unix(3, 0))
+	g.delEdge(e, time.Unix(4, 0))
+	g.delNode(n2, time.Unix(4, 0))
+
+	rebuilt := &fakeElasticsearchClient{revisions: make(map[string]interface{})}
+	rebuilt.searchResult.Hits = &elastic.SearchHits{}
+	b, err := NewElasticSearchBackendFromClient(rebuilt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := ReplayJournal(&buffer, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("Expected 7 events to be replayed, got: %d", count)
+	}
+
+	if !reflect.DeepEqual(rebuilt.revisions, client.revisions) {
+		t.Errorf("Expected the rebuilt records to match the original ones: \nexpected: %v\ngot: %v", client.revisions, rebuilt.revisions)
+	}
+}
@@ -32,22 +32,27 @@ import (
 
 // Graph message type
 const (
-	SyncMsgType             = "Sync"
-	SyncRequestMsgType      = "SyncRequest"
-	SyncReplyMsgType        = "SyncReply"
-	HostGraphDeletedMsgType = "HostGraphDeleted"
-	NodeUpdatedMsgType      = "NodeUpdated"
-	NodeDeletedMsgType      = "NodeDeleted"
-	NodeAddedMsgType        = "NodeAdded"
-	EdgeUpdatedMsgType      = "EdgeUpdated"
-	EdgeDeletedMsgType      = "EdgeDeleted"
-	EdgeAddedMsgType        = "EdgeAdded"
+	SyncMsgType              = "Sync"
+	SyncRequestMsgType       = "SyncRequest"
+	SyncReplyMsgType         = "SyncReply"
+	HostGraphDeletedMsgType  = "HostGraphDeleted"
+	HostGraphShutdownMsgType = "HostGraphShutdown"
+	AckMsgType               = "Ack"
+	NackMsgType              = "Nack"
+	NodeUpdatedMsgType       = "NodeUpdated"
+	NodeDeletedMsgType       = "NodeDeleted"
+	NodeAddedMsgType         = "NodeAdded"
+	EdgeUpdatedMsgType       = "EdgeUpdated"
+	EdgeDeletedMsgType       = "EdgeDeleted"
+	EdgeAddedMsgType         = "EdgeAdded"
 )
 
 // Graph error message
 var (
 	ErrSyncRequestMalFormed = errors.New("SyncRequestMsg malformed")
 	ErrSyncMsgMalFormed     = errors.New("SyncMsg/SyncReplyMsg malformed")
+	ErrShutdownMsgMalFormed = errors.New("HostGraphShutdownMsg malformed")
+	ErrAckMsgMalFormed      = errors.New("AckMsg malformed")
 )
 
 // type SyncRequestMsg describes a graph synchro request message
@@ -62,6 +67,19 @@ type SyncMsg struct {
 	Edges []*Edge
 }
 
+// HostGraphShutdownMsg describes the message sent by an agent when it is being
+// stopped cleanly
+type HostGraphShutdownMsg struct {
+	Host string
+	Time int64
+}
+
+// AckMsg acknowledges all the messages up to the given sequence number or,
+// as a Nack, reports that messages following it were lost
+type AckMsg struct {
+	Sequence int64
+}
+
 // UnmarshalWSMessage deserialize the websocket message
 func UnmarshalWSMessage(msg *shttp.WSStructMessage) (string, interface{}, error) {
 	var obj interface{}
@@ -137,6 +155,35 @@ func UnmarshalWSMessage(msg *shttp.WSStructMessage) (string, interface{}, error)
 		return msg.Type, result, nil
 	case HostGraphDeletedMsgType:
 		return msg.Type, obj, nil
+	case HostGraphShutdownMsgType:
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return "", msg, ErrShutdownMsgMalFormed
+		}
+
+		host, ok := m["Host"].(string)
+		if !ok {
+			return "", msg, ErrShutdownMsgMalFormed
+		}
+
+		t, err := common.ToInt64(m["Time"])
+		if err != nil {
+			return "", msg, ErrShutdownMsgMalFormed
+		}
+
+		return msg.Type, &HostGraphShutdownMsg{Host: host, Time: t}, nil
+	case AckMsgType, NackMsgType:
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return "", msg, ErrAckMsgMalFormed
+		}
+
+		seq, err := common.ToInt64(m["Sequence"])
+		if err != nil {
+			return "", msg, ErrAckMsgMalFormed
+		}
+
+		return msg.Type, &AckMsg{Sequence: seq}, nil
 	case NodeUpdatedMsgType, NodeDeletedMsgType, NodeAddedMsgType:
 		var node Node
 		if err := node.Decode(obj); err != nil {
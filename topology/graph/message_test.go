@@ -120,3 +120,66 @@ func TestHost(t *testing.T) {
 		t.Error("Should raise an error")
 	}
 }
+
+func TestHostGraphShutdown(t *testing.T) {
+	raw := json.RawMessage([]byte(`{"Host": "host1", "Time": 1529330000000}`))
+
+	msg := &shttp.WSStructMessage{
+		Protocol:  shttp.JsonProtocol,
+		Namespace: Namespace,
+		Type:      HostGraphShutdownMsgType,
+		UUID:      "aaa",
+		Status:    http.StatusOK,
+		JsonObj:   &raw,
+	}
+
+	msgType, obj, err := UnmarshalWSMessage(msg)
+	if err != nil {
+		t.Fatalf("Unable to parse shutdown message: %s", err)
+	}
+
+	if msgType != HostGraphShutdownMsgType {
+		t.Fatalf("Wrong message type: %s", msgType)
+	}
+
+	shutdown := obj.(*HostGraphShutdownMsg)
+	if shutdown.Host != "host1" || shutdown.Time != 1529330000000 {
+		t.Errorf("Wrong shutdown message content: %+v", shutdown)
+	}
+
+	raw = json.RawMessage([]byte(`{"Time": 1529330000000}`))
+	if _, _, err := UnmarshalWSMessage(msg); err == nil {
+		t.Error("Should raise an error if Host is missing")
+	}
+}
+
+func TestAckMsg(t *testing.T) {
+	raw := json.RawMessage([]byte(`{"Sequence": 42}`))
+
+	msg := &shttp.WSStructMessage{
+		Protocol:  shttp.JsonProtocol,
+		Namespace: Namespace,
+		Type:      AckMsgType,
+		UUID:      "aaa",
+		Status:    http.StatusOK,
+		JsonObj:   &raw,
+	}
+
+	msgType, obj, err := UnmarshalWSMessage(msg)
+	if err != nil {
+		t.Fatalf("Unable to parse ack message: %s", err)
+	}
+
+	if msgType != AckMsgType {
+		t.Fatalf("Wrong message type: %s", msgType)
+	}
+
+	if ack := obj.(*AckMsg); ack.Sequence != 42 {
+		t.Errorf("Wrong ack sequence: %d", ack.Sequence)
+	}
+
+	raw = json.RawMessage([]byte(`{}`))
+	if _, _, err := UnmarshalWSMessage(msg); err == nil {
+		t.Error("Should raise an error if Sequence is missing")
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"errors"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// GraphEvent is a node or edge event going through the middlewares before
+// reaching the backend and the listeners. Type is the one of the matching
+// WebSocket message, NodeAddedMsgType for instance. Metadata is the one of
+// the element as it is going to be stored, the middlewares can modify it in
+// place.
+type GraphEvent struct {
+	Type      string
+	Node      *Node
+	Edge      *Edge
+	Metadata  Metadata
+	commit    func() bool
+	committed bool
+}
+
+// GraphEventProcessor processes an event, a returned error vetoes it
+type GraphEventProcessor func(ev *GraphEvent) error
+
+// GraphMiddleware wraps the processing of the events like an HTTP
+// middleware, next passing the event to the following middleware and after
+// the last one to the backend and the listeners. A middleware is called
+// with the graph lock held and must not modify the graph.
+type GraphMiddleware func(next GraphEventProcessor) GraphEventProcessor
+
+var errNotCommitted = errors.New("Event rejected by the backend")
+
+// elementState is the part of an element modified by an event, restored
+// when the event is vetoed
+type elementState struct {
+	metadata  Metadata
+	updatedAt time.Time
+	deletedAt time.Time
+	revision  int64
+}
+
+func commitEvent(ev *GraphEvent) error {
+	if ev.committed = ev.commit(); !ev.committed {
+		return errNotCommitted
+	}
+	return nil
+}
+
+// Use appends middlewares to the chain, the first one added being the
+// first to see the events. It has to be called with the graph lock held.
+func (g *Graph) Use(middlewares ...GraphMiddleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+
+	chain := GraphEventProcessor(commitEvent)
+	for i := len(g.middlewares) - 1; i >= 0; i-- {
+		chain = g.middlewares[i](chain)
+	}
+	g.chain = chain
+}
+
+// saveState returns the state of the element to be restored if the event
+// is vetoed, nil without middleware
+func (g *Graph) saveState(e *graphElement) *elementState {
+	if g.chain == nil {
+		return nil
+	}
+
+	return &elementState{
+		metadata:  e.metadata.Clone(),
+		updatedAt: e.updatedAt,
+		deletedAt: e.deletedAt,
+		revision:  e.revision,
+	}
+}
+
+// process runs the event through the middlewares, commit storing it in the
+// backend and notifying the listeners. The state of the element, if any, is
+// restored when the event is vetoed.
+func (g *Graph) process(ev *GraphEvent, commit func() bool, state *elementState) bool {
+	if g.chain == nil {
+		return commit()
+	}
+
+	var e *graphElement
+	if ev.Node != nil {
+		e = &ev.Node.graphElement
+	} else {
+		e = &ev.Edge.graphElement
+	}
+	ev.Metadata = e.metadata
+	ev.commit = commit
+
+	if err := g.chain(ev); err != nil && !ev.committed && err != errNotCommitted {
+		logging.GetLogger().Debugf("Graph event %s of %s vetoed: %s", ev.Type, e.ID, err)
+	}
+
+	if !ev.committed && state != nil {
+		e.metadata = state.metadata
+		e.updatedAt = state.updatedAt
+		e.deletedAt = state.deletedAt
+		e.revision = state.revision
+	}
+
+	return ev.committed
+}
+
+// ScrubMetadataMiddleware returns a middleware removing the given metadata
+// keys, dotted for the nested ones, from the elements before they are
+// stored or notified
+func ScrubMetadataMiddleware(keys ...string) GraphMiddleware {
+	return func(next GraphEventProcessor) GraphEventProcessor {
+		return func(ev *GraphEvent) error {
+			if ev.Type != NodeDeletedMsgType && ev.Type != EdgeDeletedMsgType {
+				for _, key := range keys {
+					common.DelField(ev.Metadata, key)
+				}
+			}
+			return next(ev)
+		}
+	}
+}
+
+// AnonymizeMetadataMiddleware returns a middleware anonymizing the IPs, the
+// MACs and the hostnames held by the given metadata keys, dotted for the
+// nested ones, the values being either strings or lists of strings
+func AnonymizeMetadataMiddleware(a *common.Anonymizer, keys ...string) GraphMiddleware {
+	return func(next GraphEventProcessor) GraphEventProcessor {
+		return func(ev *GraphEvent) error {
+			if ev.Type == NodeDeletedMsgType || ev.Type == EdgeDeletedMsgType {
+				return next(ev)
+			}
+
+			for _, key := range keys {
+				value, err := common.GetField(ev.Metadata, key)
+				if err != nil {
+					continue
+				}
+
+				switch value := value.(type) {
+				case string:
+					common.SetField(ev.Metadata, key, a.Anonymize(value))
+				case []string:
+					values := make([]string, len(value))
+					for i, s := range value {
+						values[i] = a.Anonymize(s)
+					}
+					common.SetField(ev.Metadata, key, values)
+				case []interface{}:
+					values := make([]interface{}, len(value))
+					for i, v := range value {
+						if s, ok := v.(string); ok {
+							v = a.Anonymize(s)
+						}
+						values[i] = v
+					}
+					common.SetField(ev.Metadata, key, values)
+				}
+			}
+			return next(ev)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// MultiBackend describes a backend writing the changes of the graph to
+// several backends, to migrate from a storage to another without downtime
+// for instance. The requests are answered by the first backend, the primary
+// one, the historical requests by the first backend supporting history. The
+// write failures of the other backends are only reported, the result of a
+// write being the one of the primary backend.
+type MultiBackend struct {
+	GraphBackend
+	names    []string
+	backends []GraphBackend
+}
+
+// write applies a change to every backend
+func (m *MultiBackend) write(id Identifier, action string, fn func(b GraphBackend) bool) bool {
+	success := true
+	for i, b := range m.backends {
+		if fn(b) {
+			continue
+		}
+
+		if i == 0 {
+			success = false
+		} else {
+			logging.GetLogger().Errorf("Failed to %s %s in the backend %s", action, id, m.names[i])
+		}
+	}
+	return success
+}
+
+// reader returns the backend answering the requests within the context
+func (m *MultiBackend) reader(t GraphContext) GraphBackend {
+	if t.TimeSlice != nil {
+		for _, b := range m.backends {
+			if b.IsHistorySupported() {
+				return b
+			}
+		}
+	}
+	return m.backends[0]
+}
+
+// NodeAdded add a node in the backends
+func (m *MultiBackend) NodeAdded(n *Node) bool {
+	return m.write(n.ID, "add node", func(b GraphBackend) bool { return b.NodeAdded(n) })
+}
+
+// NodeDeleted delete a node in the backends
+func (m *MultiBackend) NodeDeleted(n *Node) bool {
+	return m.write(n.ID, "delete node", func(b GraphBackend) bool { return b.NodeDeleted(n) })
+}
+
+// GetNode get a node
+func (m *MultiBackend) GetNode(i Identifier, t GraphContext) []*Node {
+	return m.reader(t).GetNode(i, t)
+}
+
+// GetNodeEdges returns a list of a node edges
+func (m *MultiBackend) GetNodeEdges(n *Node, t GraphContext, e GraphElementMatcher) []*Edge {
+	return m.reader(t).GetNodeEdges(n, t, e)
+}
+
+// EdgeAdded add an edge in the backends
+func (m *MultiBackend) EdgeAdded(e *Edge) bool {
+	return m.write(e.ID, "add edge", func(b GraphBackend) bool { return b.EdgeAdded(e) })
+}
+
+// EdgeDeleted delete an edge in the backends
+func (m *MultiBackend) EdgeDeleted(e *Edge) bool {
+	return m.write(e.ID, "delete edge", func(b GraphBackend) bool { return b.EdgeDeleted(e) })
+}
+
+// GetEdge get an edge
+func (m *MultiBackend) GetEdge(i Identifier, t GraphContext) []*Edge {
+	return m.reader(t).GetEdge(i, t)
+}
+
+// GetEdgeNodes returns the parents and child nodes of an edge, matching metadata
+func (m *MultiBackend) GetEdgeNodes(e *Edge, t GraphContext, parentMetadata, childMetadata GraphElementMatcher) ([]*Node, []*Node) {
+	return m.reader(t).GetEdgeNodes(e, t, parentMetadata, childMetadata)
+}
+
+// MetadataUpdated updates the metadata of a node or an edge in the backends
+func (m *MultiBackend) MetadataUpdated(i interface{}) bool {
+	var id Identifier
+	switch i := i.(type) {
+	case *Node:
+		id = i.ID
+	case *Edge:
+		id = i.ID
+	}
+
+	return m.write(id, "update metadata of", func(b GraphBackend) bool { return b.MetadataUpdated(i) })
+}
+
+// GetNodes returns a list of nodes matching metadata
+func (m *MultiBackend) GetNodes(t GraphContext, e GraphElementMatcher) []*Node {
+	return m.reader(t).GetNodes(t, e)
+}
+
+// GetEdges returns a list of edges matching metadata
+func (m *MultiBackend) GetEdges(t GraphContext, e GraphElementMatcher) []*Edge {
+	return m.reader(t).GetEdges(t, e)
+}
+
+// IsHistorySupported returns whether one of the backends supports history
+func (m *MultiBackend) IsHistorySupported() bool {
+	for _, b := range m.backends {
+		if b.IsHistorySupported() {
+			return true
+		}
+	}
+	return false
+}
+
+// NewMultiBackend creates a new backend writing to the given backends, the
+// first one being the primary backend. names are used by the logs.
+func NewMultiBackend(names []string, backends []GraphBackend) (*MultiBackend, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("At least one backend is required")
+	}
+	if len(names) != len(backends) {
+		return nil, errors.New("A name is required for each backend")
+	}
+
+	return &MultiBackend{names: names, backends: backends}, nil
+}
+
+// NewMultiBackendFromConfig creates a new multi backend based on
+// configuration, storage.<backend>.backends listing the names of the
+// backends, the primary one first
+func NewMultiBackendFromConfig(backend string) (*MultiBackend, error) {
+	names := config.GetStringSlice("storage." + backend + ".backends")
+
+	var backends []GraphBackend
+	for _, name := range names {
+		if name == backend {
+			return nil, fmt.Errorf("The backend %s can't be one of its own backends", backend)
+		}
+
+		b, err := NewBackendByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create the backend %s: %s", name, err)
+		}
+		backends = append(backends, b)
+	}
+
+	return NewMultiBackend(names, backends)
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"testing"
+	"time"
+)
+
+// failingBackend is a memory backend failing to store the nodes
+type failingBackend struct {
+	*MemoryBackend
+}
+
+func (f *failingBackend) NodeAdded(n *Node) bool {
+	return false
+}
+
+func TestMultiBackend(t *testing.T) {
+	primary, err := NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondary, err := NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing, err := NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewMultiBackend([]string{"primary", "failing", "secondary"}, []GraphBackend{primary, &failingBackend{failing}, secondary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGraphFromConfig(b)
+
+	n1 := g.newNode("n1", Metadata{"Type": "netns"}, time.Unix(1, 0), "host1")
+	n2 := g.newNode("n2", Metadata{"Type": "veth"}, time.Unix(1, 0), "host1")
+	g.newEdge("e1", n1, n2, Metadata{"RelationType": "ownership"}, time.Unix(1, 0), "host1")
+
+	// the failure of a backend doesn't prevent the others to be written
+	for name, backend := range map[string]GraphBackend{"primary": primary, "secondary": secondary} {
+		if nodes := backend.GetNodes(liveContext, nil); len(nodes) != 2 {
+			t.Errorf("Expected the nodes in the %s backend, got: %v", name, nodes)
+		}
+		if edges := backend.GetEdges(liveContext, nil); len(edges) != 1 {
+			t.Errorf("Expected the edge in the %s backend, got: %v", name, edges)
+		}
+	}
+
+	if !b.NodeAdded(newNode("n3", nil, time.Unix(2, 0), "host1")) {
+		t.Error("Expected the write to succeed as long as the primary backend succeeds")
+	}
+
+	if b, _ := NewMultiBackend([]string{"failing"}, []GraphBackend{&failingBackend{failing}}); b.NodeAdded(newNode("n4", nil, time.Unix(2, 0), "host1")) {
+		t.Error("Expected the write to fail when the primary backend fails")
+	}
+
+	g.delNode(n1, time.Unix(3, 0))
+	if nodes := secondary.GetNodes(liveContext, nil); len(nodes) != 2 {
+		t.Errorf("Expected the deletion in the secondary backend, got: %v", nodes)
+	}
+
+	if _, err := NewMultiBackend(nil, nil); err == nil {
+		t.Error("Expected an error without backend")
+	}
+}
@@ -0,0 +1,381 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/storage/neo4j"
+)
+
+// neo4jMetadataPrefix prefixes the flattened metadata properties, queryable
+// with Cypher as n.`Metadata.Type`
+const neo4jMetadataPrefix = "Metadata."
+
+// Neo4jBackend describes a backend storing the live topology in Neo4j, the
+// nodes being Node vertices and the edges Edge relationships between them
+type Neo4jBackend struct {
+	GraphBackend
+	client *neo4j.Client
+}
+
+// neo4jFlatten adds the metadata values supported as properties by Neo4j,
+// the primitive values and the lists of values of the same type
+func neo4jFlatten(props map[string]interface{}, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]interface{}:
+			neo4jFlatten(props, key+".", v)
+		case Metadata:
+			neo4jFlatten(props, key+".", v)
+		case []interface{}:
+			if neo4jHomogeneous(v) {
+				props[key] = v
+			}
+		case []string, []int64, []float64, []bool:
+			props[key] = v
+		case string, bool, int, int32, int64, uint32, uint64, float32, float64, json.Number:
+			props[key] = v
+		}
+	}
+}
+
+func neo4jHomogeneous(values []interface{}) bool {
+	if len(values) == 0 {
+		return false
+	}
+
+	kind := fmt.Sprintf("%T", values[0])
+	for _, value := range values {
+		switch value.(type) {
+		case string, bool, int, int64, float64, json.Number:
+		default:
+			return false
+		}
+		if fmt.Sprintf("%T", value) != kind {
+			return false
+		}
+	}
+	return true
+}
+
+// neo4jProperties returns the properties of an element, the metadata being
+// kept as JSON to be decoded as is
+func neo4jProperties(e *graphElement) (map[string]interface{}, error) {
+	metadata, err := json.Marshal(e.metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	props := map[string]interface{}{
+		"ID":        string(e.ID),
+		"Host":      e.host,
+		"CreatedAt": common.UnixMillis(e.createdAt),
+		"UpdatedAt": common.UnixMillis(e.updatedAt),
+		"Revision":  e.revision,
+		"Metadata":  string(metadata),
+	}
+	neo4jFlatten(props, neo4jMetadataPrefix, e.metadata)
+
+	return props, nil
+}
+
+// neo4jDocument returns the document decodable as a graph element of the
+// properties of a vertex or a relationship
+func neo4jDocument(i interface{}) (map[string]interface{}, error) {
+	props, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Wrong properties format: %v", i)
+	}
+
+	doc := map[string]interface{}{}
+	for _, key := range []string{"ID", "Host", "CreatedAt", "UpdatedAt", "Revision"} {
+		if value, ok := props[key]; ok {
+			doc[key] = value
+		}
+	}
+
+	if metadata, ok := props["Metadata"].(string); ok {
+		var m map[string]interface{}
+		if err := common.JSONDecode(bytes.NewBufferString(metadata), &m); err != nil {
+			return nil, err
+		}
+		if m != nil {
+			doc["Metadata"] = m
+		}
+	}
+
+	return doc, nil
+}
+
+// neo4jWhere returns the Cypher condition of the metadata matcher on the
+// variable
+func neo4jWhere(q *neo4j.Query, variable string, m GraphElementMatcher) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+
+	filter, err := m.Filter()
+	if err != nil {
+		return "", err
+	}
+
+	expr := q.FilterToExpression(filter, func(key string) string {
+		return neo4j.Property(variable, neo4jMetadataPrefix+key)
+	})
+	if expr == "" {
+		return "", nil
+	}
+	return " WHERE " + expr, nil
+}
+
+func (n *Neo4jBackend) run(statement string, params map[string]interface{}) ([]neo4j.Result, error) {
+	return n.client.Run(neo4j.Statement{Statement: statement, Parameters: params})
+}
+
+func (n *Neo4jBackend) searchNodes(statement string, params map[string]interface{}) (nodes []*Node) {
+	results, err := n.run(statement, params)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while retrieving nodes: %s (cypher: %s)", err, statement)
+		return
+	}
+
+	for _, result := range results {
+		for _, data := range result.Data {
+			doc, err := neo4jDocument(data.Row[0])
+			if err == nil {
+				node := new(Node)
+				if err = node.Decode(doc); err == nil {
+					nodes = append(nodes, node)
+					continue
+				}
+			}
+			logging.GetLogger().Errorf("Error while reading node: %s", err)
+		}
+	}
+
+	return
+}
+
+// searchEdges returns the edges of the rows made of the relationship and
+// the IDs of its parent and child
+func (n *Neo4jBackend) searchEdges(statement string, params map[string]interface{}) (edges []*Edge) {
+	results, err := n.run(statement, params)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while retrieving edges: %s (cypher: %s)", err, statement)
+		return
+	}
+
+	seen := make(map[Identifier]bool)
+	for _, result := range results {
+		for _, data := range result.Data {
+			doc, err := neo4jDocument(data.Row[0])
+			if err == nil {
+				doc["Parent"], doc["Child"] = data.Row[1], data.Row[2]
+
+				edge := new(Edge)
+				if err = edge.Decode(doc); err == nil {
+					// a loop is returned for both of its ends
+					if !seen[edge.ID] {
+						seen[edge.ID] = true
+						edges = append(edges, edge)
+					}
+					continue
+				}
+			}
+			logging.GetLogger().Errorf("Error while reading edge: %s", err)
+		}
+	}
+
+	return
+}
+
+func (n *Neo4jBackend) write(id Identifier, statement string, params map[string]interface{}) bool {
+	if _, err := n.run(statement, params); err != nil {
+		logging.GetLogger().Errorf("Error while writing %s: %s (cypher: %s)", id, err, statement)
+		return false
+	}
+	return true
+}
+
+// NodeAdded add a node in the database
+func (n *Neo4jBackend) NodeAdded(node *Node) bool {
+	props, err := neo4jProperties(&node.graphElement)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding node %s: %s", node.ID, err)
+		return false
+	}
+
+	return n.write(node.ID, "CREATE (n:Node) SET n = $props", map[string]interface{}{"props": props})
+}
+
+// NodeDeleted delete a node in the database
+func (n *Neo4jBackend) NodeDeleted(node *Node) bool {
+	return n.write(node.ID, "MATCH (n:Node {ID: $id}) DETACH DELETE n", map[string]interface{}{"id": string(node.ID)})
+}
+
+// GetNode get a node
+func (n *Neo4jBackend) GetNode(i Identifier, t GraphContext) []*Node {
+	return n.searchNodes("MATCH (n:Node {ID: $id}) RETURN n", map[string]interface{}{"id": string(i)})
+}
+
+// GetNodeEdges returns a list of a node edges
+func (n *Neo4jBackend) GetNodeEdges(node *Node, t GraphContext, m GraphElementMatcher) []*Edge {
+	q := neo4j.NewQuery()
+	where, err := neo4jWhere(q, "e", m)
+	if err != nil {
+		return []*Edge{}
+	}
+	q.Params["id"] = string(node.ID)
+
+	return n.searchEdges("MATCH (:Node {ID: $id})-[e:Edge]-()"+where+" RETURN e, startNode(e).ID, endNode(e).ID", q.Params)
+}
+
+// EdgeAdded add an edge in the database
+func (n *Neo4jBackend) EdgeAdded(e *Edge) bool {
+	props, err := neo4jProperties(&e.graphElement)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding edge %s: %s", e.ID, err)
+		return false
+	}
+
+	return n.write(e.ID, "MATCH (p:Node {ID: $parent}), (c:Node {ID: $child}) CREATE (p)-[e:Edge]->(c) SET e = $props", map[string]interface{}{
+		"parent": string(e.parent),
+		"child":  string(e.child),
+		"props":  props,
+	})
+}
+
+// EdgeDeleted delete an edge in the database
+func (n *Neo4jBackend) EdgeDeleted(e *Edge) bool {
+	return n.write(e.ID, "MATCH ()-[e:Edge {ID: $id}]->() DELETE e", map[string]interface{}{"id": string(e.ID)})
+}
+
+// GetEdge get an edge
+func (n *Neo4jBackend) GetEdge(i Identifier, t GraphContext) []*Edge {
+	return n.searchEdges("MATCH (p)-[e:Edge {ID: $id}]->(c) RETURN e, p.ID, c.ID", map[string]interface{}{"id": string(i)})
+}
+
+// GetEdgeNodes returns the parents and child nodes of an edge, matching metadata
+func (n *Neo4jBackend) GetEdgeNodes(e *Edge, t GraphContext, parentMetadata, childMetadata GraphElementMatcher) (parents []*Node, children []*Node) {
+	for _, node := range n.GetNode(e.parent, t) {
+		if node.MatchMetadata(parentMetadata) {
+			parents = append(parents, node)
+		}
+	}
+
+	for _, node := range n.GetNode(e.child, t) {
+		if node.MatchMetadata(childMetadata) {
+			children = append(children, node)
+		}
+	}
+
+	return
+}
+
+// MetadataUpdated replaces the properties of the vertex or the relationship
+func (n *Neo4jBackend) MetadataUpdated(i interface{}) bool {
+	var (
+		e         *graphElement
+		statement string
+	)
+
+	switch i := i.(type) {
+	case *Node:
+		e, statement = &i.graphElement, "MATCH (n:Node {ID: $id}) SET n = $props"
+	case *Edge:
+		e, statement = &i.graphElement, "MATCH ()-[n:Edge {ID: $id}]->() SET n = $props"
+	default:
+		return false
+	}
+
+	props, err := neo4jProperties(e)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while updating %s: %s", e.ID, err)
+		return false
+	}
+
+	return n.write(e.ID, statement, map[string]interface{}{"id": string(e.ID), "props": props})
+}
+
+// GetNodes returns a list of nodes matching metadata
+func (n *Neo4jBackend) GetNodes(t GraphContext, m GraphElementMatcher) []*Node {
+	q := neo4j.NewQuery()
+	where, err := neo4jWhere(q, "n", m)
+	if err != nil {
+		return []*Node{}
+	}
+
+	return n.searchNodes("MATCH (n:Node)"+where+" RETURN n", q.Params)
+}
+
+// GetEdges returns a list of edges matching metadata
+func (n *Neo4jBackend) GetEdges(t GraphContext, m GraphElementMatcher) []*Edge {
+	q := neo4j.NewQuery()
+	where, err := neo4jWhere(q, "e", m)
+	if err != nil {
+		return []*Edge{}
+	}
+
+	return n.searchEdges("MATCH (p:Node)-[e:Edge]->(c:Node)"+where+" RETURN e, p.ID, c.ID", q.Params)
+}
+
+// IsHistorySupported returns that this backend doesn't support history, the
+// Neo4j graph being the live topology
+func (n *Neo4jBackend) IsHistorySupported() bool {
+	return false
+}
+
+// NewNeo4jBackend creates a new Neo4j graph backend, the vertices of a
+// previous run being removed as the topology is rebuilt by the probes
+func NewNeo4jBackend(addr string, username string, password string) (*Neo4jBackend, error) {
+	client, err := neo4j.NewClient(addr, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := client.Run(neo4j.Statement{Statement: "CREATE CONSTRAINT ON (n:Node) ASSERT n.ID IS UNIQUE"}); err != nil {
+		return nil, fmt.Errorf("Failed to create the Node constraint: %s", err)
+	}
+
+	if _, err := client.Run(neo4j.Statement{Statement: "MATCH (n:Node) DETACH DELETE n"}); err != nil {
+		return nil, fmt.Errorf("Failed to clean up the previous topology: %s", err)
+	}
+
+	return &Neo4jBackend{client: client}, nil
+}
+
+// NewNeo4jBackendFromConfig creates a new Neo4j graph backend based on configuration
+func NewNeo4jBackendFromConfig(backend string) (*Neo4jBackend, error) {
+	path := "storage." + backend
+	addr := config.GetString(path + ".addr")
+	username := config.GetString(path + ".username")
+	password := config.GetString(path + ".password")
+	return NewNeo4jBackend(addr, username, password)
+}
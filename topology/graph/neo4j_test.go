@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/storage/neo4j"
+)
+
+func TestNeo4jWhere(t *testing.T) {
+	q := neo4j.NewQuery()
+	where, err := neo4jWhere(q, "n", NewGraphElementFilter(filters.NewAndFilter(
+		filters.NewTermStringFilter("Type", "netns"),
+		filters.NewGtInt64Filter("Captures.Count", 1),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := " WHERE ($p0 IN ([] + n.`Metadata.Type`)) AND (n.`Metadata.Captures.Count` > $p1)"
+	if where != expected {
+		t.Errorf("Expected condition %s, got %s", expected, where)
+	}
+
+	if !reflect.DeepEqual(q.Params, map[string]interface{}{"p0": "netns", "p1": int64(1)}) {
+		t.Errorf("Wrong parameters: %v", q.Params)
+	}
+
+	if where, _ := neo4jWhere(q, "n", nil); where != "" {
+		t.Errorf("Expected no condition without matcher, got %s", where)
+	}
+}
+
+func TestNeo4jProperties(t *testing.T) {
+	metadata := Metadata{
+		"Type":    "netns",
+		"Neutron": map[string]interface{}{"VNI": int64(5)},
+		"IPV4":    []interface{}{"10.0.0.1/24"},
+		"Routes":  []interface{}{map[string]interface{}{"Prefix": "0.0.0.0/0"}},
+	}
+	node := newNode("n1", metadata, time.Unix(1, 0), "host1")
+
+	props, err := neo4jProperties(&node.graphElement)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"Metadata.Type", "Metadata.Neutron.VNI", "Metadata.IPV4"} {
+		if _, ok := props[key]; !ok {
+			t.Errorf("Expected the property %s, got: %v", key, props)
+		}
+	}
+
+	if _, ok := props["Metadata.Routes"]; ok {
+		t.Error("Lists of maps are not supported as properties")
+	}
+
+	// as returned by Neo4j
+	b, _ := json.Marshal(props)
+	var row interface{}
+	if err := common.JSONDecode(bytes.NewReader(b), &row); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := neo4jDocument(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := new(Node)
+	if err := decoded.Decode(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.ID != "n1" || decoded.revision != 1 || decoded.host != "host1" || !decoded.createdAt.Equal(node.createdAt) {
+		t.Errorf("Wrong node decoded: %v", decoded)
+	}
+
+	if !reflect.DeepEqual(decoded.metadata, metadata) {
+		t.Errorf("Expected metadata %v, got %v", metadata, decoded.metadata)
+	}
+}
@@ -0,0 +1,355 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/storage/redis"
+)
+
+// RedisBackend describes a backend storing the live topology in Redis so
+// that it can be shared by several analyzers. The nodes and the edges are
+// kept as JSON in two hashes, a set per node indexing its edges. The
+// historical requests are forwarded to an optional history backend.
+type RedisBackend struct {
+	GraphBackend
+	client  redis.ClientInterface
+	prefix  string
+	history GraphBackend
+}
+
+type redisRecord struct {
+	ID        Identifier
+	Host      string
+	CreatedAt int64
+	UpdatedAt int64
+	Revision  int64
+	Parent    Identifier `json:",omitempty"`
+	Child     Identifier `json:",omitempty"`
+	Metadata  Metadata   `json:",omitempty"`
+}
+
+func redisEncode(e *graphElement, parent, child Identifier) (string, error) {
+	data, err := json.Marshal(&redisRecord{
+		ID:        e.ID,
+		Host:      e.host,
+		CreatedAt: common.UnixMillis(e.createdAt),
+		UpdatedAt: common.UnixMillis(e.updatedAt),
+		Revision:  e.revision,
+		Parent:    parent,
+		Child:     child,
+		Metadata:  e.metadata,
+	})
+	return string(data), err
+}
+
+func redisDecode(reply interface{}, element interface {
+	Decode(i interface{}) error
+}) error {
+	data, ok := reply.(string)
+	if !ok {
+		return fmt.Errorf("Wrong reply format: %v", reply)
+	}
+
+	var doc map[string]interface{}
+	if err := common.JSONDecode(bytes.NewBufferString(data), &doc); err != nil {
+		return err
+	}
+	return element.Decode(doc)
+}
+
+func (r *RedisBackend) key(name string) string {
+	return r.prefix + name
+}
+
+func (r *RedisBackend) nodeEdgesKey(id Identifier) string {
+	return r.key("node_edges:" + string(id))
+}
+
+// isHistorical returns whether the request has to be forwarded to the
+// history backend
+func (r *RedisBackend) isHistorical(t GraphContext) bool {
+	return t.TimeSlice != nil && r.history != nil
+}
+
+// replies returns the values of a reply to a command returning an array
+func (r *RedisBackend) replies(args ...string) []interface{} {
+	reply, err := r.client.Do(args...)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while running Redis %s: %s", args[0], err)
+		return nil
+	}
+
+	values, _ := reply.([]interface{})
+	return values
+}
+
+func (r *RedisBackend) searchNodes(values []interface{}, m GraphElementMatcher) (nodes []*Node) {
+	for _, value := range values {
+		// the nodes deleted meanwhile are replied as nil
+		if value == nil {
+			continue
+		}
+
+		node := new(Node)
+		if err := redisDecode(value, node); err != nil {
+			logging.GetLogger().Errorf("Error while reading node: %s", err)
+			continue
+		}
+
+		if node.MatchMetadata(m) {
+			nodes = append(nodes, node)
+		}
+	}
+	return
+}
+
+func (r *RedisBackend) searchEdges(values []interface{}, m GraphElementMatcher) (edges []*Edge) {
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+
+		edge := new(Edge)
+		if err := redisDecode(value, edge); err != nil {
+			logging.GetLogger().Errorf("Error while reading edge: %s", err)
+			continue
+		}
+
+		if edge.MatchMetadata(m) {
+			edges = append(edges, edge)
+		}
+	}
+	return
+}
+
+// write runs the commands in a transaction, the history backend being
+// updated as well
+func (r *RedisBackend) write(id Identifier, historyWrite func(GraphBackend) bool, commands ...[]string) bool {
+	transaction := append([][]string{{"MULTI"}}, commands...)
+	transaction = append(transaction, []string{"EXEC"})
+
+	success := true
+	if _, err := r.client.Pipeline(transaction...); err != nil {
+		logging.GetLogger().Errorf("Error while writing %s: %s", id, err)
+		success = false
+	}
+
+	if r.history != nil && !historyWrite(r.history) {
+		success = false
+	}
+
+	return success
+}
+
+// NodeAdded add a node in the database
+func (r *RedisBackend) NodeAdded(n *Node) bool {
+	data, err := redisEncode(&n.graphElement, "", "")
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding node %s: %s", n.ID, err)
+		return false
+	}
+
+	return r.write(n.ID, func(b GraphBackend) bool { return b.NodeAdded(n) },
+		[]string{"HSET", r.key("nodes"), string(n.ID), data},
+	)
+}
+
+// NodeDeleted delete a node in the database
+func (r *RedisBackend) NodeDeleted(n *Node) bool {
+	return r.write(n.ID, func(b GraphBackend) bool { return b.NodeDeleted(n) },
+		[]string{"HDEL", r.key("nodes"), string(n.ID)},
+		[]string{"DEL", r.nodeEdgesKey(n.ID)},
+	)
+}
+
+// GetNode get a node
+func (r *RedisBackend) GetNode(i Identifier, t GraphContext) []*Node {
+	if r.isHistorical(t) {
+		return r.history.GetNode(i, t)
+	}
+
+	return r.searchNodes(r.replies("HMGET", r.key("nodes"), string(i)), nil)
+}
+
+// GetNodeEdges returns a list of a node edges
+func (r *RedisBackend) GetNodeEdges(n *Node, t GraphContext, m GraphElementMatcher) []*Edge {
+	if r.isHistorical(t) {
+		return r.history.GetNodeEdges(n, t, m)
+	}
+
+	ids := r.replies("SMEMBERS", r.nodeEdgesKey(n.ID))
+	if len(ids) == 0 {
+		return []*Edge{}
+	}
+
+	args := []string{"HMGET", r.key("edges")}
+	for _, id := range ids {
+		args = append(args, fmt.Sprint(id))
+	}
+
+	return r.searchEdges(r.replies(args...), m)
+}
+
+// EdgeAdded add an edge in the database
+func (r *RedisBackend) EdgeAdded(e *Edge) bool {
+	data, err := redisEncode(&e.graphElement, e.parent, e.child)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding edge %s: %s", e.ID, err)
+		return false
+	}
+
+	return r.write(e.ID, func(b GraphBackend) bool { return b.EdgeAdded(e) },
+		[]string{"HSET", r.key("edges"), string(e.ID), data},
+		[]string{"SADD", r.nodeEdgesKey(e.parent), string(e.ID)},
+		[]string{"SADD", r.nodeEdgesKey(e.child), string(e.ID)},
+	)
+}
+
+// EdgeDeleted delete an edge in the database
+func (r *RedisBackend) EdgeDeleted(e *Edge) bool {
+	return r.write(e.ID, func(b GraphBackend) bool { return b.EdgeDeleted(e) },
+		[]string{"HDEL", r.key("edges"), string(e.ID)},
+		[]string{"SREM", r.nodeEdgesKey(e.parent), string(e.ID)},
+		[]string{"SREM", r.nodeEdgesKey(e.child), string(e.ID)},
+	)
+}
+
+// GetEdge get an edge
+func (r *RedisBackend) GetEdge(i Identifier, t GraphContext) []*Edge {
+	if r.isHistorical(t) {
+		return r.history.GetEdge(i, t)
+	}
+
+	return r.searchEdges(r.replies("HMGET", r.key("edges"), string(i)), nil)
+}
+
+// GetEdgeNodes returns the parents and child nodes of an edge, matching metadata
+func (r *RedisBackend) GetEdgeNodes(e *Edge, t GraphContext, parentMetadata, childMetadata GraphElementMatcher) (parents []*Node, children []*Node) {
+	if r.isHistorical(t) {
+		return r.history.GetEdgeNodes(e, t, parentMetadata, childMetadata)
+	}
+
+	values := r.replies("HMGET", r.key("nodes"), string(e.parent), string(e.child))
+	if len(values) != 2 {
+		return
+	}
+
+	parents = r.searchNodes(values[:1], parentMetadata)
+	children = r.searchNodes(values[1:], childMetadata)
+	return
+}
+
+// MetadataUpdated replaces the JSON document of the node or the edge
+func (r *RedisBackend) MetadataUpdated(i interface{}) bool {
+	var (
+		e             *graphElement
+		hash          string
+		parent, child Identifier
+	)
+
+	switch i := i.(type) {
+	case *Node:
+		e, hash = &i.graphElement, "nodes"
+	case *Edge:
+		e, hash, parent, child = &i.graphElement, "edges", i.parent, i.child
+	default:
+		return false
+	}
+
+	data, err := redisEncode(e, parent, child)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while updating %s: %s", e.ID, err)
+		return false
+	}
+
+	return r.write(e.ID, func(b GraphBackend) bool { return b.MetadataUpdated(i) },
+		[]string{"HSET", r.key(hash), string(e.ID), data},
+	)
+}
+
+// GetNodes returns a list of nodes matching metadata
+func (r *RedisBackend) GetNodes(t GraphContext, m GraphElementMatcher) []*Node {
+	if r.isHistorical(t) {
+		return r.history.GetNodes(t, m)
+	}
+
+	return r.searchNodes(r.replies("HVALS", r.key("nodes")), m)
+}
+
+// GetEdges returns a list of edges matching metadata
+func (r *RedisBackend) GetEdges(t GraphContext, m GraphElementMatcher) []*Edge {
+	if r.isHistorical(t) {
+		return r.history.GetEdges(t, m)
+	}
+
+	return r.searchEdges(r.replies("HVALS", r.key("edges")), m)
+}
+
+// IsHistorySupported returns whether a history backend is configured, Redis
+// only keeping the live topology
+func (r *RedisBackend) IsHistorySupported() bool {
+	return r.history != nil && r.history.IsHistorySupported()
+}
+
+// NewRedisBackendFromClient creates a new Redis graph backend using the
+// given client, the keys being prefixed by prefix. history, if not nil,
+// also receives the changes and answers the historical requests.
+func NewRedisBackendFromClient(client redis.ClientInterface, prefix string, history GraphBackend) *RedisBackend {
+	return &RedisBackend{
+		client:  client,
+		prefix:  prefix,
+		history: history,
+	}
+}
+
+// NewRedisBackendFromConfig creates a new Redis graph backend based on
+// configuration, storage.<backend>.history naming the backend used for the
+// historical requests
+func NewRedisBackendFromConfig(backend string) (*RedisBackend, error) {
+	path := "storage." + backend
+	addr := config.GetString(path + ".addr")
+	password := config.GetString(path + ".password")
+	db := config.GetInt(path + ".db")
+	prefix := config.GetString(path + ".prefix")
+
+	client, err := redis.NewClient(addr, password, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var history GraphBackend
+	if name := config.GetString(path + ".history"); name != "" {
+		if history, err = NewBackendByName(name); err != nil {
+			return nil, fmt.Errorf("Failed to create the history backend %s: %s", name, err)
+		}
+	}
+
+	return NewRedisBackendFromClient(client, prefix, history), nil
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+// fakeRedisClient implements the commands used by the Redis backend
+type fakeRedisClient struct {
+	hashes map[string]map[string]string
+	sets   map[string]map[string]bool
+}
+
+func (c *fakeRedisClient) hash(key string) map[string]string {
+	if _, ok := c.hashes[key]; !ok {
+		c.hashes[key] = make(map[string]string)
+	}
+	return c.hashes[key]
+}
+
+func (c *fakeRedisClient) set(key string) map[string]bool {
+	if _, ok := c.sets[key]; !ok {
+		c.sets[key] = make(map[string]bool)
+	}
+	return c.sets[key]
+}
+
+func (c *fakeRedisClient) Do(args ...string) (interface{}, error) {
+	var values []interface{}
+
+	switch args[0] {
+	case "MULTI", "EXEC":
+		return "OK", nil
+	case "HSET":
+		c.hash(args[1])[args[2]] = args[3]
+	case "HDEL":
+		delete(c.hash(args[1]), args[2])
+	case "HMGET":
+		for _, field := range args[2:] {
+			if value, ok := c.hash(args[1])[field]; ok {
+				values = append(values, value)
+			} else {
+				values = append(values, nil)
+			}
+		}
+	case "HVALS":
+		for _, value := range c.hash(args[1]) {
+			values = append(values, value)
+		}
+	case "SADD":
+		c.set(args[1])[args[2]] = true
+	case "SREM":
+		delete(c.set(args[1]), args[2])
+	case "SMEMBERS":
+		for member := range c.set(args[1]) {
+			values = append(values, member)
+		}
+	case "DEL":
+		delete(c.hashes, args[1])
+		delete(c.sets, args[1])
+	default:
+		return nil, fmt.Errorf("Unsupported command %s", args[0])
+	}
+
+	return values, nil
+}
+
+func (c *fakeRedisClient) Pipeline(commands ...[]string) (replies []interface{}, err error) {
+	for _, args := range commands {
+		reply, err := c.Do(args...)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+func newFakeRedisClient() *fakeRedisClient {
+	return &fakeRedisClient{
+		hashes: make(map[string]map[string]string),
+		sets:   make(map[string]map[string]bool),
+	}
+}
+
+func TestRedisBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-redis-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	history, err := NewBoltBackend(filepath.Join(dir, "topology.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	client := newFakeRedisClient()
+	b := NewRedisBackendFromClient(client, "skydive:", history)
+	g := NewGraphFromConfig(b)
+
+	n1 := g.newNode("n1", Metadata{"Type": "netns"}, time.Unix(1, 0), "host1")
+	n2 := g.newNode("n2", Metadata{"Type": "veth"}, time.Unix(1, 0), "host1")
+	e1 := g.newEdge("e1", n1, n2, Metadata{"RelationType": "ownership"}, time.Unix(1, 0), "host1")
+	g.addMetadata(n2, "MTU", 1500, time.Unix(2, 0))
+
+	if nodes := b.GetNodes(liveContext, Metadata{"Type": "veth"}); len(nodes) != 1 || nodes[0].Metadata()["MTU"] != int64(1500) || nodes[0].revision != 2 {
+		t.Errorf("Expected the live revision of n2, got: %v", nodes)
+	}
+
+	if edges := b.GetNodeEdges(n1, liveContext, nil); len(edges) != 1 || edges[0].ID != "e1" || edges[0].parent != "n1" {
+		t.Errorf("Expected the edge of n1, got: %v", edges)
+	}
+
+	if parents, children := b.GetEdgeNodes(e1, liveContext, nil, Metadata{"Type": "netns"}); len(parents) != 1 || len(children) != 0 {
+		t.Errorf("Expected n1 as parent and no matching child, got: %v, %v", parents, children)
+	}
+
+	g.delNode(n1, time.Unix(3, 0))
+
+	if nodes := b.GetNode("n1", liveContext); len(nodes) != 0 {
+		t.Errorf("Expected n1 to be deleted, got: %v", nodes)
+	}
+
+	if edges := b.GetEdges(liveContext, nil); len(edges) != 0 {
+		t.Errorf("Expected e1 to be deleted, got: %v", edges)
+	}
+
+	if _, ok := client.sets["skydive:node_edges:n2"]["e1"]; ok {
+		t.Error("Expected e1 to be removed from the edges of n2")
+	}
+
+	// the historical requests are answered by the history backend
+	at := GraphContext{TimeSlice: common.NewTimeSlice(2500, 2500), TimePoint: true}
+	if nodes := b.GetNodes(at, nil); len(nodes) != 2 {
+		t.Errorf("Expected n1 and n2 before the deletion, got: %v", nodes)
+	}
+
+	if !b.IsHistorySupported() {
+		t.Error("Expected history to be supported with a history backend")
+	}
+
+	if NewRedisBackendFromClient(client, "skydive:", nil).IsHistorySupported() {
+		t.Error("Expected history not to be supported without history backend")
+	}
+}
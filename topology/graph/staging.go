@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+// stagingMirror copies the events of the production graph into a staging
+// graph. The metadata keys set by the staging probes on the mirrored
+// elements are kept across the production updates.
+type stagingMirror struct {
+	DefaultGraphListener
+	staging *Graph
+	keys    map[Identifier][]string
+}
+
+// NewStagingGraph returns a graph receiving the output of the probes running
+// in shadow mode. It mirrors the production graph so that these probes find
+// the nodes they attach to, while nothing they create or update gets back to
+// the production graph. The elements created by the shadow probes with the
+// identifiers of production elements are overwritten by the mirror.
+// The production lock is always taken before the staging one.
+func NewStagingGraph(production *Graph) (*Graph, error) {
+	memory, err := NewMemoryBackend()
+	if err != nil {
+		return nil, err
+	}
+	staging := NewGraph(production.GetHost(), memory)
+
+	m := &stagingMirror{
+		staging: staging,
+		keys:    make(map[Identifier][]string),
+	}
+
+	production.RLock()
+	defer production.RUnlock()
+
+	staging.Lock()
+	for _, n := range production.GetNodes(nil) {
+		m.addNode(n)
+	}
+	for _, e := range production.GetEdges(nil) {
+		m.addEdge(e)
+	}
+	staging.Unlock()
+
+	production.AddEventListener(m)
+
+	return staging, nil
+}
+
+// mirrorMetadata returns the production metadata merged with the keys set
+// on the staged element since the last mirroring
+func (m *stagingMirror) mirrorMetadata(id Identifier, staged, production Metadata) Metadata {
+	metadata := staged.Clone()
+	for _, k := range m.keys[id] {
+		delete(metadata, k)
+	}
+
+	keys := make([]string, 0, len(production))
+	for k, v := range production {
+		metadata[k] = v
+		keys = append(keys, k)
+	}
+	m.keys[id] = keys
+
+	return metadata
+}
+
+func (m *stagingMirror) addNode(n *Node) {
+	node := *n
+	node.metadata = n.metadata.Clone()
+	if m.staging.NodeAdded(&node) {
+		m.mirrorMetadata(n.ID, nil, node.metadata)
+	}
+}
+
+func (m *stagingMirror) addEdge(e *Edge) {
+	edge := *e
+	edge.metadata = e.metadata.Clone()
+	if m.staging.EdgeAdded(&edge) {
+		m.mirrorMetadata(e.ID, nil, edge.metadata)
+	}
+}
+
+// OnNodeAdded event
+func (m *stagingMirror) OnNodeAdded(n *Node) {
+	m.staging.Lock()
+	defer m.staging.Unlock()
+
+	m.addNode(n)
+}
+
+// OnNodeUpdated event
+func (m *stagingMirror) OnNodeUpdated(n *Node) {
+	m.staging.Lock()
+	defer m.staging.Unlock()
+
+	if node := m.staging.GetNode(n.ID); node != nil {
+		m.staging.SetMetadata(node, m.mirrorMetadata(n.ID, node.metadata, n.metadata.Clone()))
+	} else {
+		m.addNode(n)
+	}
+}
+
+// OnNodeDeleted event
+func (m *stagingMirror) OnNodeDeleted(n *Node) {
+	m.staging.Lock()
+	defer m.staging.Unlock()
+
+	if node := m.staging.GetNode(n.ID); node != nil {
+		m.staging.DelNode(node)
+	}
+	delete(m.keys, n.ID)
+}
+
+// OnEdgeAdded event
+func (m *stagingMirror) OnEdgeAdded(e *Edge) {
+	m.staging.Lock()
+	defer m.staging.Unlock()
+
+	m.addEdge(e)
+}
+
+// OnEdgeUpdated event
+func (m *stagingMirror) OnEdgeUpdated(e *Edge) {
+	m.staging.Lock()
+	defer m.staging.Unlock()
+
+	if edge := m.staging.GetEdge(e.ID); edge != nil {
+		m.staging.SetMetadata(edge, m.mirrorMetadata(e.ID, edge.metadata, e.metadata.Clone()))
+	} else {
+		m.addEdge(e)
+	}
+}
+
+// OnEdgeDeleted event
+func (m *stagingMirror) OnEdgeDeleted(e *Edge) {
+	m.staging.Lock()
+	defer m.staging.Unlock()
+
+	if edge := m.staging.GetEdge(e.ID); edge != nil {
+		m.staging.DelEdge(edge)
+	}
+	delete(m.keys, e.ID)
+}
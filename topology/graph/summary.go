@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"fmt"
+)
+
+const summaryNamespace = "a3d1e6f2-5c4b-4f8e-9d2a-7b6c5e4f3a21"
+
+type summaryEdge struct {
+	parent       Identifier
+	child        Identifier
+	relationType string
+}
+
+// NewSummaryGraph returns a new graph where the given nodes sharing the same
+// value for the metadata key are collapsed into a single node. Nodes without
+// this key are kept as is. Edges between the resulting nodes are merged per
+// relation type, the number of merged edges being stored in the Weight
+// metadata. The caller has to hold the lock of the graph.
+func NewSummaryGraph(g *Graph, nodes []*Node, key string) (*Graph, error) {
+	memory, err := NewMemoryBackend()
+	if err != nil {
+		return nil, err
+	}
+	sg := NewGraph(g.GetHost(), memory)
+
+	groups := make(map[Identifier]*Node)
+	members := make(map[Identifier]bool)
+	for _, n := range nodes {
+		members[n.ID] = true
+
+		v, err := n.GetField(key)
+		if err != nil {
+			groups[n.ID] = n
+			memory.NodeAdded(n)
+			continue
+		}
+
+		value := fmt.Sprintf("%v", v)
+		id := GenIDNameBased(summaryNamespace, key+"="+value)
+
+		group := sg.GetNode(id)
+		if group == nil {
+			group = sg.NewNode(id, Metadata{
+				"Name": value,
+				"Type": "summary",
+				"Summary": map[string]interface{}{
+					"Key":   key,
+					"Count": 0,
+					"Types": map[string]interface{}{},
+				},
+			}, n.host)
+		}
+
+		summary := group.metadata["Summary"].(map[string]interface{})
+		summary["Count"] = summary["Count"].(int) + 1
+		if tp, err := n.GetFieldString("Type"); err == nil {
+			types := summary["Types"].(map[string]interface{})
+			if count, ok := types[tp]; ok {
+				types[tp] = count.(int) + 1
+			} else {
+				types[tp] = 1
+			}
+		}
+
+		groups[n.ID] = group
+	}
+
+	weights := make(map[summaryEdge]*Edge)
+	for _, n := range nodes {
+		for _, e := range g.GetNodeEdges(n, nil) {
+			// only process an edge once, from its parent
+			if e.GetParent() != n.ID || !members[e.GetChild()] {
+				continue
+			}
+
+			parent, child := groups[e.GetParent()], groups[e.GetChild()]
+			if parent.ID == child.ID {
+				continue
+			}
+
+			// edges between two regular nodes are kept as is
+			if parent.ID == e.GetParent() && child.ID == e.GetChild() {
+				memory.EdgeAdded(e)
+				continue
+			}
+
+			relationType, _ := e.GetFieldString("RelationType")
+			se := summaryEdge{parent: parent.ID, child: child.ID, relationType: relationType}
+			if edge, ok := weights[se]; ok {
+				edge.metadata["Weight"] = edge.metadata["Weight"].(int) + 1
+				continue
+			}
+
+			id := GenIDNameBased(summaryNamespace, string(parent.ID)+string(child.ID)+relationType)
+			weights[se] = sg.NewEdge(id, parent, child, Metadata{"RelationType": relationType, "Weight": 1}, e.host)
+		}
+	}
+
+	return sg, nil
+}
@@ -776,6 +776,11 @@ func (s *GremlinTraversalStepSum) Reduce(next GremlinTraversalStep) GremlinTrave
 	return next
 }
 
+// Steps returns the steps of the sequence
+func (s *GremlinTraversalSequence) Steps() []GremlinTraversalStep {
+	return s.steps
+}
+
 // Exec sequence step
 func (s *GremlinTraversalSequence) Exec(g *graph.Graph, lockGraph bool) (GraphTraversalStep, error) {
 	var step GremlinTraversalStep
@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package topology
+
+import (
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// MaintenanceMetadataKey is the metadata key holding the maintenance a node is under
+const MaintenanceMetadataKey = "Maintenance"
+
+// UnderMaintenance returns whether a node, or one of the nodes owning it, is
+// under a maintenance that did not expire yet. The caller has to hold the
+// lock of the graph.
+func UnderMaintenance(g *graph.Graph, n *graph.Node) bool {
+	now := common.UnixMillis(time.Now())
+	visited := make(map[graph.Identifier]bool)
+
+	for n != nil && !visited[n.ID] {
+		visited[n.ID] = true
+
+		if expire, err := n.GetFieldInt64(MaintenanceMetadataKey + ".ExpireTime"); err == nil && expire > now {
+			return true
+		}
+
+		parents := g.LookupParents(n, nil, OwnershipMetadata)
+		if len(parents) == 0 {
+			break
+		}
+		n = parents[0]
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package topology
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestUnderMaintenance(t *testing.T) {
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host1", backend)
+
+	g.Lock()
+	defer g.Unlock()
+
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host"})
+	bridge := g.NewNode(graph.GenID(), graph.Metadata{"Type": "bridge"})
+	intf := g.NewNode(graph.GenID(), graph.Metadata{"Type": "intf"})
+	other := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host"})
+	AddOwnershipLink(g, host, bridge, nil)
+	AddOwnershipLink(g, bridge, intf, nil)
+
+	for _, n := range []*graph.Node{host, bridge, intf, other} {
+		if UnderMaintenance(g, n) {
+			t.Errorf("Expected the node %s not to be under maintenance", n.ID)
+		}
+	}
+
+	// the descendants of a node under maintenance are under maintenance
+	g.AddMetadata(host, MaintenanceMetadataKey, map[string]interface{}{
+		"ExpireTime": common.UnixMillis(time.Now().Add(time.Hour)),
+	})
+	for _, n := range []*graph.Node{host, bridge, intf} {
+		if !UnderMaintenance(g, n) {
+			t.Errorf("Expected the node %s to be under maintenance", n.ID)
+		}
+	}
+	if UnderMaintenance(g, other) {
+		t.Error("Expected a node not owned by the node under maintenance not to be under maintenance")
+	}
+
+	// an expired maintenance does not apply anymore, even before the
+	// metadata is removed
+	g.AddMetadata(host, MaintenanceMetadataKey, map[string]interface{}{
+		"ExpireTime": common.UnixMillis(time.Now().Add(-time.Second)),
+	})
+	if UnderMaintenance(g, intf) {
+		t.Error("Expected an expired maintenance not to apply")
+	}
+
+	// while the maintenance of an intermediate owner still applies
+	g.AddMetadata(bridge, MaintenanceMetadataKey, map[string]interface{}{
+		"ExpireTime": common.UnixMillis(time.Now().Add(time.Hour)),
+	})
+	if !UnderMaintenance(g, intf) || UnderMaintenance(g, host) {
+		t.Error("Expected the maintenance of the bridge to apply to the interface only")
+	}
+
+	// ownership cycles are not followed forever
+	AddOwnershipLink(g, intf, host, nil)
+	g.DelMetadata(bridge, MaintenanceMetadataKey)
+	if UnderMaintenance(g, intf) {
+		t.Error("Expected no maintenance along the ownership cycle")
+	}
+}
@@ -0,0 +1,388 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package calico
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	managerValue = "calico"
+
+	workloadEndpointPrefix = "/calico/resources/v3/projectcalico.org/workloadendpoints/"
+	ipamBlockPrefix        = "/calico/ipam/v2/assignment/"
+
+	retryInterval = 5 * time.Second
+)
+
+var associationMetadata = graph.Metadata{"RelationType": "association", "Manager": managerValue}
+
+// CalicoProbe describes a probe that reads the workload endpoints and the IPAM
+// blocks of the local Calico node from the Calico etcd datastore and links
+// them to the host interfaces
+type CalicoProbe struct {
+	graph.DefaultGraphListener
+	graph       *graph.Graph
+	root        *graph.Node
+	client      *clientv3.Client
+	nodeName    string
+	intfIndexer *graph.MetadataIndexer
+	endpoints   map[string]*calicoEndpoint
+	blocks      map[string]*calicoBlock
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+type calicoEndpoint struct {
+	node          *graph.Node
+	interfaceName string
+	ips           []net.IP
+}
+
+type calicoBlock struct {
+	node  *graph.Node
+	ipnet *net.IPNet
+}
+
+type workloadEndpoint struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Orchestrator  string   `json:"orchestrator"`
+		Workload      string   `json:"workload"`
+		Node          string   `json:"node"`
+		Pod           string   `json:"pod"`
+		Endpoint      string   `json:"endpoint"`
+		IPNetworks    []string `json:"ipNetworks"`
+		Profiles      []string `json:"profiles"`
+		InterfaceName string   `json:"interfaceName"`
+		MAC           string   `json:"mac"`
+	} `json:"spec"`
+}
+
+type ipamBlock struct {
+	CIDR        string   `json:"cidr"`
+	Affinity    *string  `json:"affinity"`
+	Allocations []*int64 `json:"allocations"`
+	Unallocated []int64  `json:"unallocated"`
+}
+
+func toInterfaces(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+func (p *CalicoProbe) link(n1, n2 *graph.Node) {
+	if !p.graph.AreLinked(n1, n2, associationMetadata) {
+		p.graph.Link(n1, n2, associationMetadata.Clone())
+	}
+}
+
+// syncNode creates or updates the node of a Calico object, the graph is
+// only updated when the Calico metadata changed
+func (p *CalicoProbe) syncNode(node *graph.Node, key string, ty string, name string, m map[string]interface{}) *graph.Node {
+	if node == nil {
+		node = p.graph.NewNode(graph.GenIDNameBased(string(p.root.ID), key), graph.Metadata{
+			"Manager": managerValue,
+			"Type":    ty,
+			"Name":    name,
+			"Calico":  m,
+		})
+		topology.AddOwnershipLink(p.graph, p.root, node, nil)
+		return node
+	}
+
+	if current, _ := node.GetField("Calico"); !reflect.DeepEqual(current, m) {
+		p.graph.AddMetadata(node, "Calico", m)
+	}
+	return node
+}
+
+func (p *CalicoProbe) linkBlocks(ep *calicoEndpoint) {
+	for _, block := range p.blocks {
+		for _, ip := range ep.ips {
+			if block.ipnet.Contains(ip) {
+				p.link(block.node, ep.node)
+				break
+			}
+		}
+	}
+}
+
+func (p *CalicoProbe) onEndpoint(key string, value []byte) {
+	var wep workloadEndpoint
+	if err := json.Unmarshal(value, &wep); err != nil {
+		logging.GetLogger().Errorf("Unable to decode Calico workload endpoint %s: %s", key, err.Error())
+		return
+	}
+
+	if wep.Spec.Node != p.nodeName {
+		return
+	}
+
+	m := map[string]interface{}{
+		"Namespace":     wep.Metadata.Namespace,
+		"Orchestrator":  wep.Spec.Orchestrator,
+		"Workload":      wep.Spec.Workload,
+		"Pod":           wep.Spec.Pod,
+		"Endpoint":      wep.Spec.Endpoint,
+		"InterfaceName": wep.Spec.InterfaceName,
+		"MAC":           wep.Spec.MAC,
+		"IPNetworks":    toInterfaces(wep.Spec.IPNetworks),
+		"Profiles":      toInterfaces(wep.Spec.Profiles),
+	}
+
+	ep, found := p.endpoints[key]
+	if !found {
+		ep = &calicoEndpoint{}
+		p.endpoints[key] = ep
+	}
+	ep.node = p.syncNode(ep.node, key, "calicoendpoint", wep.Metadata.Name, m)
+	ep.interfaceName = wep.Spec.InterfaceName
+	ep.ips = nil
+	for _, cidr := range wep.Spec.IPNetworks {
+		if ip, _, err := net.ParseCIDR(cidr); err == nil {
+			ep.ips = append(ep.ips, ip)
+		}
+	}
+
+	if intfs, _ := p.intfIndexer.Get(ep.interfaceName); len(intfs) > 0 {
+		p.link(ep.node, intfs[0])
+	}
+	p.linkBlocks(ep)
+}
+
+func (p *CalicoProbe) onBlock(key string, value []byte) {
+	var block ipamBlock
+	if err := json.Unmarshal(value, &block); err != nil {
+		logging.GetLogger().Errorf("Unable to decode Calico IPAM block %s: %s", key, err.Error())
+		return
+	}
+
+	if block.Affinity == nil || *block.Affinity != "host:"+p.nodeName {
+		p.onDelete(key)
+		return
+	}
+
+	_, ipnet, err := net.ParseCIDR(block.CIDR)
+	if err != nil {
+		logging.GetLogger().Errorf("Invalid CIDR for Calico IPAM block %s: %s", key, err.Error())
+		return
+	}
+
+	var allocated int64
+	for _, allocation := range block.Allocations {
+		if allocation != nil {
+			allocated++
+		}
+	}
+
+	m := map[string]interface{}{
+		"CIDR":      block.CIDR,
+		"Affinity":  *block.Affinity,
+		"Size":      int64(len(block.Allocations)),
+		"Allocated": allocated,
+	}
+
+	b, found := p.blocks[key]
+	if !found {
+		b = &calicoBlock{}
+		p.blocks[key] = b
+	}
+	b.node = p.syncNode(b.node, key, "calicoipamblock", block.CIDR, m)
+	b.ipnet = ipnet
+
+	for _, ep := range p.endpoints {
+		p.linkBlocks(ep)
+	}
+}
+
+func (p *CalicoProbe) onDelete(key string) {
+	if ep, found := p.endpoints[key]; found {
+		p.graph.DelNode(ep.node)
+		delete(p.endpoints, key)
+	}
+
+	if b, found := p.blocks[key]; found {
+		p.graph.DelNode(b.node)
+		delete(p.blocks, key)
+	}
+}
+
+func (p *CalicoProbe) onPut(key string, value []byte) {
+	switch {
+	case strings.HasPrefix(key, workloadEndpointPrefix):
+		p.onEndpoint(key, value)
+	case strings.HasPrefix(key, ipamBlockPrefix) && strings.Contains(key, "/block/"):
+		p.onBlock(key, value)
+	}
+}
+
+// watch lists then watches a prefix of the datastore until the context is
+// cancelled or the watch fails
+func (p *CalicoProbe) watch(ctx context.Context, prefix string) error {
+	resp, err := p.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return fmt.Errorf("Unable to list Calico %s: %s", prefix, err.Error())
+	}
+
+	p.graph.Lock()
+	for _, kv := range resp.Kvs {
+		p.onPut(string(kv.Key), kv.Value)
+	}
+	p.graph.Unlock()
+
+	probe.ReportRecovery(managerValue)
+
+	for wresp := range p.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1)) {
+		if err := wresp.Err(); err != nil {
+			return fmt.Errorf("Unable to watch Calico %s: %s", prefix, err.Error())
+		}
+
+		p.graph.Lock()
+		for _, ev := range wresp.Events {
+			if ev.Type == clientv3.EventTypeDelete {
+				p.onDelete(string(ev.Kv.Key))
+			} else {
+				p.onPut(string(ev.Kv.Key), ev.Kv.Value)
+			}
+		}
+		p.graph.Unlock()
+	}
+
+	return ctx.Err()
+}
+
+func (p *CalicoProbe) run(ctx context.Context, prefix string) {
+	defer p.wg.Done()
+
+	for {
+		err := p.watch(ctx, prefix)
+		if ctx.Err() != nil {
+			return
+		}
+		probe.ReportError(managerValue, err)
+
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// OnNodeAdded links the endpoints to their host interface once it appears
+func (p *CalicoProbe) OnNodeAdded(n *graph.Node) {
+	name, _ := n.GetFieldString("Name")
+	for _, ep := range p.endpoints {
+		if ep.interfaceName == name {
+			p.link(ep.node, n)
+		}
+	}
+}
+
+// OnNodeUpdated event
+func (p *CalicoProbe) OnNodeUpdated(n *graph.Node) {
+	p.OnNodeAdded(n)
+}
+
+// Start the probe
+func (p *CalicoProbe) Start() {
+	p.intfIndexer.AddEventListener(p)
+	p.intfIndexer.Start()
+
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+
+	p.wg.Add(2)
+	go p.run(ctx, ipamBlockPrefix)
+	go p.run(ctx, workloadEndpointPrefix)
+}
+
+// Stop the probe
+func (p *CalicoProbe) Stop() {
+	p.cancel()
+	p.wg.Wait()
+	p.client.Close()
+
+	p.intfIndexer.RemoveEventListener(p)
+	p.intfIndexer.Stop()
+}
+
+// NewCalicoProbe creates a new Calico probe watching the etcd datastore for
+// the objects of the node nodeName
+func NewCalicoProbe(g *graph.Graph, root *graph.Node, endpoints []string, nodeName string) (*CalicoProbe, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to Calico datastore: %s", err.Error())
+	}
+
+	filter := filters.NewAndFilter(
+		filters.NewTermStringFilter("Type", "veth"),
+		filters.NewNotNullFilter("Name"),
+	)
+
+	return &CalicoProbe{
+		graph:       g,
+		root:        root,
+		client:      client,
+		nodeName:    nodeName,
+		intfIndexer: graph.NewMetadataIndexer(g, graph.NewGraphElementFilter(filter), "Name"),
+		endpoints:   make(map[string]*calicoEndpoint),
+		blocks:      make(map[string]*calicoBlock),
+	}, nil
+}
+
+// NewCalicoProbeFromConfig creates a new Calico probe based on configuration
+func NewCalicoProbeFromConfig(g *graph.Graph, root *graph.Node) (*CalicoProbe, error) {
+	nodeName := config.GetString("calico.node")
+	if nodeName == "" {
+		nodeName = config.GetString("host_id")
+	}
+
+	return NewCalicoProbe(g, root, config.GetStringSlice("calico.etcd_servers"), nodeName)
+}
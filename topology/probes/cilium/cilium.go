@@ -0,0 +1,467 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package cilium
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	managerValue = "cilium"
+
+	policyNameLabel      = "io.cilium.k8s.policy.name"
+	policyNamespaceLabel = "io.cilium.k8s.policy.namespace"
+)
+
+var associationMetadata = graph.Metadata{"RelationType": "association", "Manager": managerValue}
+
+// CiliumProbe describes a probe that reads the endpoints, identities and
+// policies of the local Cilium agent and links them to the host interfaces
+type CiliumProbe struct {
+	graph.DefaultGraphListener
+	graph        *graph.Graph
+	root         *graph.Node
+	client       *http.Client
+	interval     time.Duration
+	intfIndexer  *graph.MetadataIndexer
+	endpoints    map[int64]*graph.Node
+	identities   map[int64]*graph.Node
+	policies     map[string]*graph.Node
+	endpointIntf map[int64]string
+	quit         chan bool
+	wg           sync.WaitGroup
+}
+
+type ciliumIdentity struct {
+	ID     int64    `json:"id"`
+	Labels []string `json:"labels"`
+}
+
+type ciliumAddressing struct {
+	IPV4 string `json:"ipv4"`
+	IPV6 string `json:"ipv6"`
+}
+
+type ciliumEndpoint struct {
+	ID     int64 `json:"id"`
+	Status struct {
+		State               string          `json:"state"`
+		Identity            *ciliumIdentity `json:"identity"`
+		ExternalIdentifiers struct {
+			PodName     string `json:"pod-name"`
+			ContainerID string `json:"container-id"`
+		} `json:"external-identifiers"`
+		Networking struct {
+			Addressing     []ciliumAddressing `json:"addressing"`
+			InterfaceName  string             `json:"interface-name"`
+			InterfaceIndex int64              `json:"interface-index"`
+			MAC            string             `json:"mac"`
+			HostMAC        string             `json:"host-mac"`
+		} `json:"networking"`
+		Policy struct {
+			Realized struct {
+				PolicyEnabled  string `json:"policy-enabled"`
+				PolicyRevision int64  `json:"policy-revision"`
+			} `json:"realized"`
+		} `json:"policy"`
+	} `json:"status"`
+}
+
+type ciliumLabel struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+type ciliumRule struct {
+	EndpointSelector struct {
+		MatchLabels map[string]string `json:"matchLabels"`
+	} `json:"endpointSelector"`
+	Ingress []interface{} `json:"ingress"`
+	Egress  []interface{} `json:"egress"`
+	Labels  []ciliumLabel `json:"labels"`
+}
+
+type ciliumPolicy struct {
+	Revision int64  `json:"revision"`
+	Policy   string `json:"policy"`
+}
+
+func (p *CiliumProbe) get(path string, result interface{}) error {
+	resp, err := p.client.Get("http://cilium/v1" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Cilium API %s returned %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// splitPodName splits the pod name reported by Cilium into namespace and name
+func splitPodName(podName string) (string, string) {
+	for _, sep := range []string{"/", ":"} {
+		if s := strings.SplitN(podName, sep, 2); len(s) == 2 {
+			return s[0], s[1]
+		}
+	}
+	return "", podName
+}
+
+// labelsMatch returns whether the identity labels, in the Cilium
+// "source:key=value" form, match the selector labels
+func labelsMatch(selector map[string]string, labels []string) bool {
+	for key, value := range selector {
+		if i := strings.Index(key, ":"); i != -1 {
+			key = key[i+1:]
+		}
+
+		found := false
+		for _, label := range labels {
+			if i := strings.Index(label, ":"); i != -1 {
+				label = label[i+1:]
+			}
+			if label == key+"="+value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func ruleName(rule *ciliumRule, index int) (string, string) {
+	var name, namespace string
+	for _, label := range rule.Labels {
+		switch label.Key {
+		case policyNameLabel:
+			name = label.Value
+		case policyNamespaceLabel:
+			namespace = label.Value
+		}
+	}
+	if name == "" {
+		name = "rule-" + strconv.Itoa(index)
+	}
+	return namespace, name
+}
+
+func endpointMetadata(ep *ciliumEndpoint) map[string]interface{} {
+	namespace, name := splitPodName(ep.Status.ExternalIdentifiers.PodName)
+
+	m := map[string]interface{}{
+		"EndpointID":     ep.ID,
+		"State":          ep.Status.State,
+		"PodNamespace":   namespace,
+		"PodName":        name,
+		"ContainerID":    ep.Status.ExternalIdentifiers.ContainerID,
+		"InterfaceName":  ep.Status.Networking.InterfaceName,
+		"MAC":            ep.Status.Networking.MAC,
+		"HostMAC":        ep.Status.Networking.HostMAC,
+		"PolicyEnabled":  ep.Status.Policy.Realized.PolicyEnabled,
+		"PolicyRevision": ep.Status.Policy.Realized.PolicyRevision,
+	}
+
+	var ipv4, ipv6 []interface{}
+	for _, addr := range ep.Status.Networking.Addressing {
+		if addr.IPV4 != "" {
+			ipv4 = append(ipv4, addr.IPV4)
+		}
+		if addr.IPV6 != "" {
+			ipv6 = append(ipv6, addr.IPV6)
+		}
+	}
+	if len(ipv4) > 0 {
+		m["IPV4"] = ipv4
+	}
+	if len(ipv6) > 0 {
+		m["IPV6"] = ipv6
+	}
+
+	if identity := ep.Status.Identity; identity != nil {
+		m["IdentityID"] = identity.ID
+	}
+
+	return m
+}
+
+func identityMetadata(identity *ciliumIdentity) map[string]interface{} {
+	labels := make([]interface{}, len(identity.Labels))
+	for i, label := range identity.Labels {
+		labels[i] = label
+	}
+
+	return map[string]interface{}{
+		"IdentityID": identity.ID,
+		"Labels":     labels,
+	}
+}
+
+func (p *CiliumProbe) link(n1, n2 *graph.Node) {
+	if !p.graph.AreLinked(n1, n2, associationMetadata) {
+		p.graph.Link(n1, n2, associationMetadata.Clone())
+	}
+}
+
+// syncNode creates or updates the node of a Cilium object, the graph is
+// only updated when the Cilium metadata changed
+func (p *CiliumProbe) syncNode(node *graph.Node, id string, ty string, name string, m map[string]interface{}) *graph.Node {
+	if node == nil {
+		node = p.graph.NewNode(graph.GenIDNameBased(string(p.root.ID), id), graph.Metadata{
+			"Manager": managerValue,
+			"Type":    ty,
+			"Name":    name,
+			"Cilium":  m,
+		})
+		topology.AddOwnershipLink(p.graph, p.root, node, nil)
+		return node
+	}
+
+	if current, _ := node.GetField("Cilium"); !reflect.DeepEqual(current, m) {
+		p.graph.AddMetadata(node, "Cilium", m)
+	}
+	return node
+}
+
+func (p *CiliumProbe) linkInterface(id int64, node *graph.Node) {
+	name := p.endpointIntf[id]
+	if name == "" {
+		return
+	}
+
+	if intfs, _ := p.intfIndexer.Get(name); len(intfs) > 0 {
+		p.link(node, intfs[0])
+	}
+}
+
+func (p *CiliumProbe) sync(endpoints []*ciliumEndpoint, rules []*ciliumRule) {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	endpointLabels := make(map[int64][]string)
+	seenIdentities := make(map[int64]bool)
+	seenEndpoints := make(map[int64]bool)
+
+	for _, ep := range endpoints {
+		seenEndpoints[ep.ID] = true
+
+		node := p.syncNode(p.endpoints[ep.ID], fmt.Sprintf("cilium-endpoint-%d", ep.ID), "ciliumendpoint", fmt.Sprintf("endpoint-%d", ep.ID), endpointMetadata(ep))
+		p.endpoints[ep.ID] = node
+		p.endpointIntf[ep.ID] = ep.Status.Networking.InterfaceName
+		p.linkInterface(ep.ID, node)
+
+		if identity := ep.Status.Identity; identity != nil {
+			seenIdentities[identity.ID] = true
+			endpointLabels[ep.ID] = identity.Labels
+
+			idNode := p.syncNode(p.identities[identity.ID], fmt.Sprintf("cilium-identity-%d", identity.ID), "ciliumidentity", fmt.Sprintf("identity-%d", identity.ID), identityMetadata(identity))
+			p.identities[identity.ID] = idNode
+			p.link(node, idNode)
+		}
+	}
+
+	for id, node := range p.endpoints {
+		if !seenEndpoints[id] {
+			p.graph.DelNode(node)
+			delete(p.endpoints, id)
+			delete(p.endpointIntf, id)
+		}
+	}
+
+	for id, node := range p.identities {
+		if !seenIdentities[id] {
+			p.graph.DelNode(node)
+			delete(p.identities, id)
+		}
+	}
+
+	seenPolicies := make(map[string]bool)
+	for i, rule := range rules {
+		namespace, name := ruleName(rule, i)
+		key := namespace + "/" + name
+		seenPolicies[key] = true
+
+		m := map[string]interface{}{
+			"Namespace":    namespace,
+			"Ingress":      len(rule.Ingress) > 0,
+			"Egress":       len(rule.Egress) > 0,
+			"IngressRules": int64(len(rule.Ingress)),
+			"EgressRules":  int64(len(rule.Egress)),
+		}
+		node := p.syncNode(p.policies[key], "cilium-policy-"+key, "ciliumpolicy", name, m)
+		p.policies[key] = node
+
+		for id, labels := range endpointLabels {
+			if labelsMatch(rule.EndpointSelector.MatchLabels, labels) {
+				p.link(node, p.endpoints[id])
+			} else if epNode := p.endpoints[id]; p.graph.AreLinked(node, epNode, associationMetadata) {
+				p.graph.Unlink(node, epNode)
+			}
+		}
+	}
+
+	for key, node := range p.policies {
+		if !seenPolicies[key] {
+			p.graph.DelNode(node)
+			delete(p.policies, key)
+		}
+	}
+}
+
+func (p *CiliumProbe) poll() error {
+	var endpoints []*ciliumEndpoint
+	if err := p.get("/endpoint", &endpoints); err != nil {
+		return fmt.Errorf("Unable to retrieve Cilium endpoints: %s", err.Error())
+	}
+
+	var policy ciliumPolicy
+	if err := p.get("/policy", &policy); err != nil {
+		return fmt.Errorf("Unable to retrieve Cilium policy: %s", err.Error())
+	}
+
+	var rules []*ciliumRule
+	if policy.Policy != "" {
+		if err := json.Unmarshal([]byte(policy.Policy), &rules); err != nil {
+			return fmt.Errorf("Unable to decode Cilium policy: %s", err.Error())
+		}
+	}
+
+	p.sync(endpoints, rules)
+	return nil
+}
+
+// OnNodeAdded links the endpoints to their host interface once it appears
+func (p *CiliumProbe) OnNodeAdded(n *graph.Node) {
+	name, _ := n.GetFieldString("Name")
+	for id, intf := range p.endpointIntf {
+		if intf == name {
+			p.link(p.endpoints[id], n)
+		}
+	}
+}
+
+// OnNodeUpdated event
+func (p *CiliumProbe) OnNodeUpdated(n *graph.Node) {
+	p.OnNodeAdded(n)
+}
+
+func (p *CiliumProbe) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.poll(); err != nil {
+			probe.ReportError(managerValue, err)
+		} else {
+			probe.ReportRecovery(managerValue)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Start the probe
+func (p *CiliumProbe) Start() {
+	p.intfIndexer.AddEventListener(p)
+	p.intfIndexer.Start()
+
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop the probe
+func (p *CiliumProbe) Stop() {
+	p.quit <- true
+	p.wg.Wait()
+
+	p.intfIndexer.RemoveEventListener(p)
+	p.intfIndexer.Stop()
+}
+
+// NewCiliumProbe creates a new Cilium probe talking to the Cilium agent API
+// through its unix socket
+func NewCiliumProbe(g *graph.Graph, root *graph.Node, socket string, interval time.Duration) *CiliumProbe {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+		Timeout: interval,
+	}
+
+	filter := filters.NewAndFilter(
+		filters.NewTermStringFilter("Type", "veth"),
+		filters.NewNotNullFilter("Name"),
+	)
+
+	return &CiliumProbe{
+		graph:        g,
+		root:         root,
+		client:       client,
+		interval:     interval,
+		intfIndexer:  graph.NewMetadataIndexer(g, graph.NewGraphElementFilter(filter), "Name"),
+		endpoints:    make(map[int64]*graph.Node),
+		identities:   make(map[int64]*graph.Node),
+		policies:     make(map[string]*graph.Node),
+		endpointIntf: make(map[int64]string),
+		quit:         make(chan bool),
+	}
+}
+
+// NewCiliumProbeFromConfig creates a new Cilium probe based on configuration
+func NewCiliumProbeFromConfig(g *graph.Graph, root *graph.Node) *CiliumProbe {
+	socket := config.GetString("cilium.socket")
+	interval := time.Duration(config.GetInt("cilium.poll_interval")) * time.Second
+
+	logging.GetLogger().Infof("Cilium probe using %s", socket)
+
+	return NewCiliumProbe(g, root, socket, interval)
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package cilium
+
+import (
+	"testing"
+)
+
+func TestLabelsMatch(t *testing.T) {
+	labels := []string{"k8s:app=frontend", "k8s:io.kubernetes.pod.namespace=default"}
+
+	if !labelsMatch(map[string]string{"any:app": "frontend"}, labels) {
+		t.Error("Selector with any source should match")
+	}
+
+	if !labelsMatch(map[string]string{"app": "frontend", "k8s:io.kubernetes.pod.namespace": "default"}, labels) {
+		t.Error("Selector with all labels present should match")
+	}
+
+	if labelsMatch(map[string]string{"app": "backend"}, labels) {
+		t.Error("Selector with a different value should not match")
+	}
+}
+
+func TestSplitPodName(t *testing.T) {
+	for podName, expected := range map[string][2]string{
+		"default/frontend": {"default", "frontend"},
+		"default:frontend": {"default", "frontend"},
+		"frontend":         {"", "frontend"},
+	} {
+		if namespace, name := splitPodName(podName); namespace != expected[0] || name != expected[1] {
+			t.Errorf("Unexpected split of %s: %s %s", podName, namespace, name)
+		}
+	}
+}
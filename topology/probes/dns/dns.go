@@ -0,0 +1,424 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const managerValue = "dns"
+
+// fields holding the IP addresses of the endpoint nodes, either plain
+// addresses or CIDRs
+var ipFields = []string{
+	"IPV4",
+	"IPV6",
+	"Neutron.IPV4",
+	"Neutron.IPV6",
+	"K8s.Spec.ClusterIP",
+	"K8s.Status.PodIP",
+}
+
+var edgeMetadata = graph.Metadata{"RelationType": "association", "Manager": managerValue}
+
+// DNSProbe describes a probe resolving the names of the services known by
+// the graph, the k8s services, the Neutron ports with DNS and statically
+// configured names, and linking these names to their endpoint nodes
+type DNSProbe struct {
+	graph         *graph.Graph
+	resolver      *net.Resolver
+	interval      time.Duration
+	timeout       time.Duration
+	clusterDomain string
+	staticNames   []string
+	ipIndexer     *graph.GraphIndexer
+	names         map[string]*graph.Node
+	quit          chan bool
+	wg            sync.WaitGroup
+}
+
+type srvQuery struct {
+	service string
+	proto   string
+}
+
+type dnsQuery struct {
+	name   string
+	source string
+	srv    []srvQuery
+}
+
+type dnsRecord struct {
+	query     *dnsQuery
+	addresses []string
+	cname     string
+	srv       []interface{}
+	err       error
+}
+
+// hashIPs indexes the nodes by each of their IP addresses
+func hashIPs(n *graph.Node) map[string]interface{} {
+	if manager, _ := n.GetFieldString("Manager"); manager == managerValue {
+		return nil
+	}
+
+	kv := make(map[string]interface{})
+	for _, field := range ipFields {
+		value, err := n.GetField(field)
+		if err != nil {
+			continue
+		}
+
+		var ips []string
+		switch v := value.(type) {
+		case string:
+			ips = []string{v}
+		case []string:
+			ips = v
+		case []interface{}:
+			for _, ip := range v {
+				if s, ok := ip.(string); ok {
+					ips = append(ips, s)
+				}
+			}
+		}
+
+		for _, ip := range ips {
+			if i := strings.Index(ip, "/"); i != -1 {
+				ip = ip[:i]
+			}
+			if parsed := net.ParseIP(ip); parsed != nil && !parsed.IsUnspecified() {
+				kv[parsed.String()] = nil
+			}
+		}
+	}
+	return kv
+}
+
+func (p *DNSProbe) k8sServiceQueries() (queries []*dnsQuery) {
+	services := p.graph.GetNodes(graph.Metadata{"Manager": "k8s", "Type": "service"})
+	for _, service := range services {
+		namespace, _ := service.GetFieldString("Namespace")
+		name, _ := service.GetFieldString("Name")
+		if namespace == "" || name == "" {
+			continue
+		}
+
+		query := &dnsQuery{
+			name:   name + "." + namespace + ".svc." + p.clusterDomain,
+			source: "k8s",
+		}
+
+		if ports, err := service.GetField("K8s.Spec.Ports"); err == nil {
+			if ports, ok := ports.([]interface{}); ok {
+				for _, port := range ports {
+					if port, ok := port.(map[string]interface{}); ok {
+						portName, _ := port["Name"].(string)
+						protocol, _ := port["Protocol"].(string)
+						if portName != "" && protocol != "" {
+							query.srv = append(query.srv, srvQuery{service: portName, proto: strings.ToLower(protocol)})
+						}
+					}
+				}
+			}
+		}
+
+		queries = append(queries, query)
+	}
+	return
+}
+
+func (p *DNSProbe) neutronQueries() (queries []*dnsQuery) {
+	filter := graph.NewGraphElementFilter(filters.NewNotNullFilter("Neutron.DNSFQDN"))
+	for _, port := range p.graph.GetNodes(filter) {
+		fqdns, _ := port.GetField("Neutron.DNSFQDN")
+		switch v := fqdns.(type) {
+		case []string:
+			for _, fqdn := range v {
+				queries = append(queries, &dnsQuery{name: strings.TrimSuffix(fqdn, "."), source: "neutron"})
+			}
+		case []interface{}:
+			for _, fqdn := range v {
+				if s, ok := fqdn.(string); ok {
+					queries = append(queries, &dnsQuery{name: strings.TrimSuffix(s, "."), source: "neutron"})
+				}
+			}
+		}
+	}
+	return
+}
+
+func (p *DNSProbe) queries() []*dnsQuery {
+	p.graph.RLock()
+	queries := append(p.k8sServiceQueries(), p.neutronQueries()...)
+	p.graph.RUnlock()
+
+	for _, name := range p.staticNames {
+		queries = append(queries, &dnsQuery{name: name, source: "config"})
+	}
+
+	// the same name may be known from several sources
+	unique := make(map[string]bool)
+	result := queries[:0]
+	for _, query := range queries {
+		if !unique[query.name] {
+			unique[query.name] = true
+			result = append(result, query)
+		}
+	}
+	return result
+}
+
+func (p *DNSProbe) resolve(query *dnsQuery) *dnsRecord {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	record := &dnsRecord{query: query}
+	if record.addresses, record.err = p.resolver.LookupHost(ctx, query.name); record.err != nil {
+		return record
+	}
+	sort.Strings(record.addresses)
+
+	if cname, err := p.resolver.LookupCNAME(ctx, query.name); err == nil && strings.TrimSuffix(cname, ".") != query.name {
+		record.cname = strings.TrimSuffix(cname, ".")
+	}
+
+	for _, srv := range query.srv {
+		_, addrs, err := p.resolver.LookupSRV(ctx, srv.service, srv.proto, query.name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			record.srv = append(record.srv, map[string]interface{}{
+				"Service":  srv.service,
+				"Proto":    srv.proto,
+				"Target":   strings.TrimSuffix(addr.Target, "."),
+				"Port":     int64(addr.Port),
+				"Priority": int64(addr.Priority),
+				"Weight":   int64(addr.Weight),
+			})
+		}
+	}
+
+	return record
+}
+
+func recordMetadata(record *dnsRecord) map[string]interface{} {
+	m := map[string]interface{}{
+		"Source": record.query.source,
+	}
+
+	if record.err != nil {
+		m["Error"] = record.err.Error()
+		return m
+	}
+
+	addresses := make([]interface{}, len(record.addresses))
+	for i, addr := range record.addresses {
+		addresses[i] = addr
+	}
+	m["Addresses"] = addresses
+
+	if record.cname != "" {
+		m["CNAME"] = record.cname
+	}
+	if len(record.srv) > 0 {
+		m["SRV"] = record.srv
+	}
+	return m
+}
+
+// syncEdges links the name node to the nodes owning its addresses
+func (p *DNSProbe) syncEdges(node *graph.Node, record *dnsRecord) {
+	endpoints := make(map[graph.Identifier]*graph.Node)
+	for _, addr := range record.addresses {
+		if ip := net.ParseIP(addr); ip != nil {
+			nodes, _ := p.ipIndexer.FromHash(ip.String())
+			for _, n := range nodes {
+				if n != nil {
+					endpoints[n.ID] = n
+				}
+			}
+		}
+	}
+
+	for _, e := range p.graph.GetNodeEdges(node, edgeMetadata) {
+		if _, found := endpoints[e.GetChild()]; found {
+			delete(endpoints, e.GetChild())
+		} else if e.GetParent() == node.ID {
+			p.graph.DelEdge(e)
+		}
+	}
+
+	for _, endpoint := range endpoints {
+		p.graph.Link(node, endpoint, edgeMetadata.Clone(), "")
+	}
+}
+
+func (p *DNSProbe) apply(records []*dnsRecord) {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	seen := make(map[string]bool)
+	for _, record := range records {
+		name := record.query.name
+		seen[name] = true
+
+		m := recordMetadata(record)
+		node, found := p.names[name]
+		if !found {
+			node = p.graph.NewNode(graph.GenIDNameBased(managerValue, name), graph.Metadata{
+				"Manager": managerValue,
+				"Type":    "dnsname",
+				"Name":    name,
+				"DNS":     m,
+			}, "")
+			p.names[name] = node
+		} else if current, _ := node.GetField("DNS"); !reflect.DeepEqual(current, m) {
+			p.graph.AddMetadata(node, "DNS", m)
+		}
+
+		p.syncEdges(node, record)
+	}
+
+	for name, node := range p.names {
+		if !seen[name] {
+			p.graph.DelNode(node)
+			delete(p.names, name)
+		}
+	}
+}
+
+func (p *DNSProbe) poll() error {
+	queries := p.queries()
+
+	var failures int
+	records := make([]*dnsRecord, len(queries))
+	for i, query := range queries {
+		if records[i] = p.resolve(query); records[i].err != nil {
+			logging.GetLogger().Debugf("Failed to resolve %s: %s", query.name, records[i].err.Error())
+			failures++
+		}
+	}
+
+	p.apply(records)
+
+	if len(queries) > 0 && failures == len(queries) {
+		return errors.New("Unable to resolve any of the DNS names")
+	}
+	return nil
+}
+
+func (p *DNSProbe) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.poll(); err != nil {
+			probe.ReportError(managerValue, err)
+		} else {
+			probe.ReportRecovery(managerValue)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Start the probe
+func (p *DNSProbe) Start() {
+	p.ipIndexer.Start()
+
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop the probe
+func (p *DNSProbe) Stop() {
+	p.quit <- true
+	p.wg.Wait()
+
+	p.ipIndexer.Stop()
+}
+
+// NewDNSProbe creates a new DNS probe using the given DNS server, the
+// system resolver is used when server is empty
+func NewDNSProbe(g *graph.Graph, server string, clusterDomain string, names []string, interval, timeout time.Duration) *DNSProbe {
+	resolver := &net.Resolver{}
+	if server != "" {
+		resolver.PreferGo = true
+		resolver.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		}
+	}
+
+	return &DNSProbe{
+		graph:         g,
+		resolver:      resolver,
+		interval:      interval,
+		timeout:       timeout,
+		clusterDomain: clusterDomain,
+		staticNames:   names,
+		ipIndexer:     graph.NewGraphIndexer(g, hashIPs, false),
+		names:         make(map[string]*graph.Node),
+		quit:          make(chan bool),
+	}
+}
+
+// NewDNSProbeFromConfig creates a new DNS probe based on configuration
+func NewDNSProbeFromConfig(g *graph.Graph) (*DNSProbe, error) {
+	server := config.GetString("dns.server")
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = common.JoinHostPort(server, 53)
+		}
+	}
+
+	interval := time.Duration(config.GetInt("dns.interval")) * time.Second
+	if interval <= 0 {
+		return nil, errors.New("dns.interval must be a positive number of seconds")
+	}
+	timeout := time.Duration(config.GetInt("dns.timeout")) * time.Second
+
+	return NewDNSProbe(g, server, config.GetString("dns.cluster_domain"), config.GetStringSlice("dns.names"), interval, timeout), nil
+}
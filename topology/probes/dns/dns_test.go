@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package dns
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestHashIPs(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+
+	n := g.NewNode(graph.GenID(), graph.Metadata{
+		"IPV4": []string{"10.0.0.1/24"},
+		"K8s": map[string]interface{}{
+			"Spec": map[string]interface{}{"ClusterIP": "None"},
+		},
+		"Neutron": map[string]interface{}{
+			"IPV6": []interface{}{"fd00::1/64"},
+		},
+	})
+
+	kv := hashIPs(n)
+	if len(kv) != 2 {
+		t.Fatalf("Expected 2 addresses, got %v", kv)
+	}
+	for _, ip := range []string{"10.0.0.1", "fd00::1"} {
+		if _, found := kv[ip]; !found {
+			t.Errorf("Address %s not indexed: %v", ip, kv)
+		}
+	}
+
+	dn := g.NewNode(graph.GenID(), graph.Metadata{"Manager": managerValue, "IPV4": []string{"10.0.0.1"}})
+	if kv := hashIPs(dn); len(kv) != 0 {
+		t.Errorf("DNS nodes should not be indexed: %v", kv)
+	}
+}
@@ -0,0 +1,587 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package fingerprint
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	managerValue = "fingerprint"
+	endpointType = "endpoint"
+	subnetType   = "subnet"
+	asType       = "autonomous-system"
+	trafficType  = "traffic"
+)
+
+// ipFields are the metadata holding the addresses of the interfaces
+var ipFields = []string{"IPV4", "IPV6"}
+
+var ipFilter = graph.NewGraphElementFilter(filters.NewOrFilter(
+	filters.NewNotNullFilter("IPV4"),
+	filters.NewNotNullFilter("IPV6"),
+))
+
+// FingerprintProbe creates endpoint nodes for the hosts only known through
+// the flows, the addresses of the flows not used by any node of the graph,
+// like the external hosts or the devices without agent. Their operating
+// system is passively detected from the DHCP options of their leases, the
+// signature of their TCP SYN packets and their TTL, and reported by the
+// Fingerprint metadata. The endpoints are owned by a node of their
+// autonomous system, or of their subnet, and linked to their peers by
+// traffic edges. Implements the flow listener interface.
+type FingerprintProbe struct {
+	sync.Mutex
+	graph      *graph.Graph
+	interval   time.Duration
+	ttl        time.Duration
+	maxHosts   int
+	ipv4Prefix int
+	ipv6Prefix int
+	asns       []*ASPrefix
+	hosts      map[string]*host
+	traffic    map[trafficKey]*traffic
+	groups     map[graph.Identifier]*graph.Node
+	known      map[string]graph.Identifier
+	quit       chan bool
+	wg         sync.WaitGroup
+}
+
+// ASPrefix describes a prefix announced by an autonomous system
+type ASPrefix struct {
+	Network *net.IPNet
+	Name    string
+}
+
+// trafficKey identifies the traffic between two addresses, a being lower
+// than b
+type trafficKey struct {
+	a string
+	b string
+}
+
+// traffic describes the traffic between two addresses, one of them at
+// least being an endpoint
+type traffic struct {
+	packets int64
+	bytes   int64
+	last    time.Time
+}
+
+// host describes an address seen by the flows, signature being nil until
+// one of its SYN is captured
+type host struct {
+	ip        string
+	signature *tcpSignature
+	last      time.Time
+}
+
+// dhcpLease describes the last DHCP transaction of an address
+type dhcpLease struct {
+	mac           string
+	hostname      string
+	vendorClass   string
+	parameterList string
+	last          int64
+}
+
+// nodeIPs returns the addresses of a node
+func nodeIPs(n *graph.Node) []string {
+	var ips []string
+	for _, field := range ipFields {
+		value, err := n.GetField(field)
+		if err != nil {
+			continue
+		}
+
+		var values []string
+		switch v := value.(type) {
+		case string:
+			values = []string{v}
+		case []string:
+			values = v
+		case []interface{}:
+			for _, ip := range v {
+				if s, ok := ip.(string); ok {
+					values = append(values, s)
+				}
+			}
+		}
+
+		for _, ip := range values {
+			if i := strings.Index(ip, "/"); i != -1 {
+				ip = ip[:i]
+			}
+			if parsed := net.ParseIP(ip); parsed != nil {
+				ips = append(ips, parsed.String())
+			}
+		}
+	}
+	return ips
+}
+
+// synSignatures returns the signatures of the SYN packets of both sides of
+// a TCP flow
+func synSignatures(m *flow.TCPMetric) (a *tcpSignature, b *tcpSignature) {
+	if m == nil {
+		return
+	}
+
+	if m.ABSynStart != 0 {
+		a = &tcpSignature{ttl: m.ABSynTTL, window: m.ABSynWindow, mss: m.ABSynMSS, wscale: m.ABSynWScale, options: m.ABSynOptions}
+	}
+	if m.BASynStart != 0 {
+		b = &tcpSignature{ttl: m.BASynTTL, window: m.BASynWindow, mss: m.BASynMSS, wscale: m.BASynWScale, options: m.BASynOptions}
+	}
+	return
+}
+
+// observe records an address seen by a flow and returns its normalized
+// form, and whether it's tracked as an endpoint. The caller has to hold the
+// lock of the probe.
+func (p *FingerprintProbe) observe(ip string, signature *tcpSignature, now time.Time) (string, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsUnspecified() || parsed.IsLoopback() || parsed.IsMulticast() ||
+		parsed.IsLinkLocalUnicast() || parsed.Equal(net.IPv4bcast) {
+		return "", false
+	}
+
+	ip = parsed.String()
+	if _, found := p.known[ip]; found {
+		return ip, false
+	}
+
+	h, found := p.hosts[ip]
+	if !found {
+		if len(p.hosts) >= p.maxHosts {
+			return ip, false
+		}
+		h = &host{ip: ip}
+		p.hosts[ip] = h
+	}
+
+	h.last = now
+	if signature != nil {
+		h.signature = signature
+	}
+	return ip, true
+}
+
+// OnFlows records the addresses of the flows along with the signatures of
+// their SYN packets
+func (p *FingerprintProbe) OnFlows(flows []*flow.Flow) {
+	now := time.Now()
+
+	p.Lock()
+	defer p.Unlock()
+
+	for _, f := range flows {
+		if f.Network == nil || (f.Network.Protocol != flow.FlowProtocol_IPV4 && f.Network.Protocol != flow.FlowProtocol_IPV6) {
+			continue
+		}
+
+		a, b := synSignatures(f.TCPMetric)
+		ipA, endpointA := p.observe(f.Network.A, a, now)
+		ipB, endpointB := p.observe(f.Network.B, b, now)
+		if (!endpointA && !endpointB) || ipA == "" || ipB == "" || ipA == ipB {
+			continue
+		}
+
+		key := trafficKey{a: ipA, b: ipB}
+		if key.b < key.a {
+			key.a, key.b = key.b, key.a
+		}
+
+		t, found := p.traffic[key]
+		if !found {
+			t = &traffic{}
+			p.traffic[key] = t
+		}
+		if m := f.LastUpdateMetric; m != nil {
+			t.packets += m.ABPackets + m.BAPackets
+			t.bytes += m.ABBytes + m.BABytes
+		}
+		t.last = now
+	}
+}
+
+// knownIPs returns the addresses of the nodes not created by the probe,
+// along with the first node using them. The caller has to hold the lock of
+// the graph.
+func (p *FingerprintProbe) knownIPs() map[string]graph.Identifier {
+	known := make(map[string]graph.Identifier)
+	for _, n := range p.graph.GetNodes(ipFilter) {
+		if manager, _ := n.GetFieldString("Manager"); manager == managerValue {
+			continue
+		}
+
+		for _, ip := range nodeIPs(n) {
+			if _, found := known[ip]; !found {
+				known[ip] = n.ID
+			}
+		}
+	}
+	return known
+}
+
+// group returns the name and the metadata of the node grouping an address,
+// its autonomous system if known, its subnet otherwise, or nil if the
+// addresses of its family are not grouped
+func (p *FingerprintProbe) group(ip string) (string, graph.Metadata) {
+	parsed := net.ParseIP(ip)
+
+	var found *ASPrefix
+	for _, prefix := range p.asns {
+		if prefix.Network.Contains(parsed) && (found == nil || prefixLength(prefix.Network) > prefixLength(found.Network)) {
+			found = prefix
+		}
+	}
+	if found != nil {
+		return "as/" + found.Name, graph.Metadata{
+			"Type":    asType,
+			"Manager": managerValue,
+			"Name":    found.Name,
+		}
+	}
+
+	bits, prefix := 128, p.ipv6Prefix
+	if parsed.To4() != nil {
+		parsed, bits, prefix = parsed.To4(), 32, p.ipv4Prefix
+	}
+	if prefix <= 0 || prefix > bits {
+		return "", nil
+	}
+
+	network := &net.IPNet{IP: parsed.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+	return "subnet/" + network.String(), graph.Metadata{
+		"Type":    subnetType,
+		"Manager": managerValue,
+		"Name":    network.String(),
+		"CIDR":    network.String(),
+	}
+}
+
+func prefixLength(network *net.IPNet) int {
+	ones, _ := network.Mask.Size()
+	return ones
+}
+
+// updateGroup makes the endpoint owned by the node of its group, returning
+// the ID of the group node. The caller has to hold the locks of the graph
+// and of the probe.
+func (p *FingerprintProbe) updateGroup(ip string, endpoint *graph.Node) graph.Identifier {
+	name, metadata := p.group(ip)
+	if metadata == nil {
+		return ""
+	}
+
+	id := graph.GenIDNameBased(managerValue, name)
+	group := p.graph.GetNode(id)
+	if group == nil {
+		group = p.graph.NewNode(id, metadata)
+	}
+	p.groups[id] = group
+
+	metadata = graph.Metadata{"Manager": managerValue}
+	if !topology.HaveOwnershipLink(p.graph, group, endpoint, metadata) {
+		topology.AddOwnershipLink(p.graph, group, endpoint, metadata)
+	}
+	return id
+}
+
+// peer returns the node of an address of the traffic, its endpoint or the
+// node using it. The caller has to hold the lock of the graph.
+func (p *FingerprintProbe) peer(ip string, known map[string]graph.Identifier) *graph.Node {
+	if id, found := known[ip]; found {
+		return p.graph.GetNode(id)
+	}
+	return p.graph.GetNode(graph.GenIDNameBased(managerValue, ip))
+}
+
+// updateTraffic creates or updates the traffic edges, and removes the ones
+// not seen within the TTL. The caller has to hold the locks of the graph
+// and of the probe.
+func (p *FingerprintProbe) updateTraffic(known map[string]graph.Identifier, expire time.Time) {
+	for key, t := range p.traffic {
+		id := graph.GenIDNameBased(managerValue, trafficType+"/"+key.a+"/"+key.b)
+		edge := p.graph.GetEdge(id)
+
+		_, knownA := known[key.a]
+		_, knownB := known[key.b]
+		if (knownA && knownB) || (p.ttl > 0 && t.last.Before(expire)) {
+			if edge != nil {
+				p.graph.DelEdge(edge)
+			}
+			delete(p.traffic, key)
+			continue
+		}
+
+		a, b := p.peer(key.a, known), p.peer(key.b, known)
+		if a == nil || b == nil {
+			continue
+		}
+
+		m := map[string]interface{}{
+			"Packets": t.packets,
+			"Bytes":   t.bytes,
+			"Last":    common.UnixMillis(t.last),
+		}
+		if edge == nil {
+			p.graph.NewEdge(id, a, b, graph.Metadata{
+				"RelationType": trafficType,
+				"Manager":      managerValue,
+				"Traffic":      m,
+			})
+		} else if !reflect.DeepEqual(edge.Metadata()["Traffic"], m) {
+			p.graph.AddMetadata(edge, "Traffic", m)
+		}
+	}
+}
+
+// dhcpLeases returns the last DHCP lease of the addresses, from the DHCP
+// annotations of the captures. The caller has to hold the lock of the graph.
+func (p *FingerprintProbe) dhcpLeases() map[string]*dhcpLease {
+	leases := make(map[string]*dhcpLease)
+	for _, n := range p.graph.GetNodes(graph.Metadata{"Type": "annotation", "Manager": "dhcp"}) {
+		ip, _ := n.GetFieldString("DHCP.OfferedIP")
+		if ip == "" {
+			if ip, _ = n.GetFieldString("DHCP.RequestedIP"); ip == "" {
+				continue
+			}
+		}
+
+		last, _ := n.GetFieldInt64("DHCP.Last")
+		if lease, found := leases[ip]; found && lease.last >= last {
+			continue
+		}
+
+		lease := &dhcpLease{last: last}
+		lease.mac, _ = n.GetFieldString("DHCP.ClientMAC")
+		lease.hostname, _ = n.GetFieldString("DHCP.Hostname")
+		lease.vendorClass, _ = n.GetFieldString("DHCP.VendorClass")
+		lease.parameterList, _ = n.GetFieldString("DHCP.ParameterList")
+		leases[ip] = lease
+	}
+	return leases
+}
+
+// fingerprint returns the Fingerprint metadata of a host
+func fingerprint(h *host, lease *dhcpLease) map[string]interface{} {
+	fp := make(map[string]interface{})
+
+	if s := h.signature; s != nil {
+		initial := initialTTL(s.ttl)
+		fp["TTL"] = int64(s.ttl)
+		fp["InitialTTL"] = int64(initial)
+		fp["Hops"] = int64(initial - s.ttl)
+		fp["TCP"] = map[string]interface{}{
+			"Window":  int64(s.window),
+			"MSS":     int64(s.mss),
+			"WScale":  int64(s.wscale),
+			"Options": s.options,
+		}
+	}
+
+	if lease != nil {
+		dhcp := make(map[string]interface{})
+		for key, value := range map[string]string{"ClientMAC": lease.mac, "Hostname": lease.hostname, "VendorClass": lease.vendorClass, "ParameterList": lease.parameterList} {
+			if value != "" {
+				dhcp[key] = value
+			}
+		}
+		fp["DHCP"] = dhcp
+	}
+
+	if os, source := detectOS(h.signature, lease); os != "" {
+		fp["OS"] = os
+		fp["Source"] = source
+	}
+
+	return fp
+}
+
+// update creates or updates the endpoint nodes of the hosts, and removes
+// the ones not seen within the TTL or used meanwhile by a node of the
+// graph, along with their groups and their traffic edges
+func (p *FingerprintProbe) update() {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	known := p.knownIPs()
+	leases := p.dhcpLeases()
+	expire := time.Now().Add(-p.ttl)
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.known = known
+	groups := make(map[graph.Identifier]bool)
+	for ip, h := range p.hosts {
+		id := graph.GenIDNameBased(managerValue, ip)
+		node := p.graph.GetNode(id)
+
+		if _, found := known[ip]; found || (p.ttl > 0 && h.last.Before(expire)) {
+			if node != nil {
+				p.graph.DelNode(node)
+			}
+			delete(p.hosts, ip)
+			continue
+		}
+
+		fp := fingerprint(h, leases[ip])
+		if node == nil {
+			field := "IPV4"
+			if strings.Contains(ip, ":") {
+				field = "IPV6"
+			}
+
+			node = p.graph.NewNode(id, graph.Metadata{
+				"Type":        endpointType,
+				"Manager":     managerValue,
+				"Name":        ip,
+				field:         []string{ip},
+				"Fingerprint": fp,
+			})
+			logging.GetLogger().Debugf("Endpoint %s discovered through the flows", ip)
+		} else if !reflect.DeepEqual(node.Metadata()["Fingerprint"], fp) {
+			p.graph.AddMetadata(node, "Fingerprint", fp)
+		}
+
+		if group := p.updateGroup(ip, node); group != "" {
+			groups[group] = true
+		}
+	}
+
+	for id, group := range p.groups {
+		if !groups[id] {
+			p.graph.DelNode(group)
+			delete(p.groups, id)
+		}
+	}
+
+	p.updateTraffic(known, expire)
+}
+
+func (p *FingerprintProbe) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.update()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Start the probe
+func (p *FingerprintProbe) Start() {
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop the probe
+func (p *FingerprintProbe) Stop() {
+	p.quit <- true
+	p.wg.Wait()
+}
+
+// NewFingerprintProbe creates a new fingerprint probe updating the graph
+// every interval, the hosts not seen within the TTL, if not zero, being
+// removed and at most maxHosts hosts being tracked. The endpoints are
+// grouped by the given autonomous systems, or by their subnet of the given
+// prefix length, 0 disabling the grouping.
+func NewFingerprintProbe(g *graph.Graph, interval time.Duration, ttl time.Duration, maxHosts int, ipv4Prefix, ipv6Prefix int, asns []*ASPrefix) *FingerprintProbe {
+	return &FingerprintProbe{
+		graph:      g,
+		interval:   interval,
+		ttl:        ttl,
+		maxHosts:   maxHosts,
+		ipv4Prefix: ipv4Prefix,
+		ipv6Prefix: ipv6Prefix,
+		asns:       asns,
+		hosts:      make(map[string]*host),
+		traffic:    make(map[trafficKey]*traffic),
+		groups:     make(map[graph.Identifier]*graph.Node),
+		known:      make(map[string]graph.Identifier),
+		quit:       make(chan bool),
+	}
+}
+
+// ParseASPrefixes parses autonomous system prefixes, each one made of a
+// CIDR followed by the name of its autonomous system
+func ParseASPrefixes(entries []string) ([]*ASPrefix, error) {
+	var asns []*ASPrefix
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Invalid autonomous system prefix '%s', expected a CIDR followed by a name", entry)
+		}
+
+		_, network, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid autonomous system prefix '%s': %s", entry, err)
+		}
+		asns = append(asns, &ASPrefix{Network: network, Name: strings.Join(fields[1:], " ")})
+	}
+	return asns, nil
+}
+
+// NewFingerprintProbeFromConfig creates a new fingerprint probe based on
+// configuration
+func NewFingerprintProbeFromConfig(g *graph.Graph) (*FingerprintProbe, error) {
+	interval := time.Duration(config.GetInt("analyzer.topology.fingerprint.interval")) * time.Second
+	if interval <= 0 {
+		return nil, errors.New("analyzer.topology.fingerprint.interval must be a positive number of seconds")
+	}
+
+	ttl := time.Duration(config.GetInt("analyzer.topology.fingerprint.ttl")) * time.Second
+	maxHosts := config.GetInt("analyzer.topology.fingerprint.max_hosts")
+	ipv4Prefix := config.GetInt("analyzer.topology.fingerprint.ipv4_prefix")
+	ipv6Prefix := config.GetInt("analyzer.topology.fingerprint.ipv6_prefix")
+
+	asns, err := ParseASPrefixes(config.GetStringSlice("analyzer.topology.fingerprint.asns"))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFingerprintProbe(g, interval, ttl, maxHosts, ipv4Prefix, ipv6Prefix, asns), nil
+}
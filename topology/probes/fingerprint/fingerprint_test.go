@@ -0,0 +1,193 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package fingerprint
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func tcpFlow(a, b string, metric *flow.TCPMetric) *flow.Flow {
+	return &flow.Flow{
+		Network:   &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: a, B: b},
+		TCPMetric: metric,
+	}
+}
+
+func TestDetectOS(t *testing.T) {
+	linux := &tcpSignature{ttl: 61, window: 29200, mss: 1460, wscale: 7, options: "M,S,T,N,W"}
+	if os, source := detectOS(linux, nil); os != "Linux" || source != TCPSource {
+		t.Errorf("Expected Linux from the TCP signature, got %s from %s", os, source)
+	}
+
+	unknown := &tcpSignature{ttl: 120, options: "M"}
+	if os, source := detectOS(unknown, nil); os != "Windows" || source != TTLSource {
+		t.Errorf("Expected Windows from the TTL, got %s from %s", os, source)
+	}
+
+	lease := &dhcpLease{vendorClass: "android-dhcp-9"}
+	if os, source := detectOS(linux, lease); os != "Android" || source != DHCPSource {
+		t.Errorf("Expected Android from the DHCP vendor class, got %s from %s", os, source)
+	}
+
+	if os, _ := detectOS(nil, nil); os != "" {
+		t.Errorf("Expected no detection without signature, got %s", os)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+	p := NewFingerprintProbe(g, 0, time.Hour, 10, 0, 0, nil)
+
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "IPV4": []string{"10.0.0.1/24"}})
+	g.NewNode(graph.GenID(), graph.Metadata{
+		"Type":    "annotation",
+		"Manager": "dhcp",
+		"DHCP": map[string]interface{}{
+			"ClientMAC":   "00:00:00:00:00:03",
+			"OfferedIP":   "10.0.0.3",
+			"VendorClass": "MSFT 5.0",
+			"Last":        int64(1000),
+		},
+	})
+
+	p.OnFlows([]*flow.Flow{
+		tcpFlow("10.0.0.1", "192.0.2.10", &flow.TCPMetric{
+			ABSynStart: 1, ABSynTTL: 64, ABSynOptions: "M,S,T,N,W",
+			BASynStart: 1, BASynTTL: 52, BASynWindow: 28960, BASynMSS: 1460, BASynWScale: 7, BASynOptions: "M,S,T,N,W",
+		}),
+		tcpFlow("10.0.0.3", "224.0.0.251", nil),
+	})
+	p.update()
+
+	endpoints := g.GetNodes(graph.Metadata{"Manager": managerValue, "Type": endpointType})
+	if len(endpoints) != 2 {
+		t.Fatalf("Expected the endpoints of the unknown unicast addresses, got %v", endpoints)
+	}
+
+	external := g.GetNode(graph.GenIDNameBased(managerValue, "192.0.2.10"))
+	if external == nil {
+		t.Fatal("Expected an endpoint for 192.0.2.10")
+	}
+	if os, _ := external.GetFieldString("Fingerprint.OS"); os != "Linux" {
+		t.Errorf("Expected Linux from the SYN-ACK signature, got %v", external.Metadata())
+	}
+	if hops, _ := external.GetFieldInt64("Fingerprint.Hops"); hops != 12 {
+		t.Errorf("Expected 12 hops, got %v", external.Metadata())
+	}
+
+	client := g.GetNode(graph.GenIDNameBased(managerValue, "10.0.0.3"))
+	if client == nil {
+		t.Fatal("Expected an endpoint for 10.0.0.3")
+	}
+	if os, _ := client.GetFieldString("Fingerprint.OS"); os != "Windows" {
+		t.Errorf("Expected Windows from the DHCP lease, got %v", client.Metadata())
+	}
+
+	// the endpoint is removed once its address is used by a node
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth1", "IPV4": []string{"10.0.0.3/24"}})
+	p.update()
+
+	if g.GetNode(client.ID) != nil {
+		t.Error("Expected the endpoint of 10.0.0.3 to be removed")
+	}
+
+	p.OnFlows([]*flow.Flow{tcpFlow("10.0.0.3", "192.0.2.10", nil)})
+	if _, found := p.hosts["10.0.0.3"]; found {
+		t.Error("Expected the known address to be ignored")
+	}
+}
+
+func TestGroupsAndTraffic(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+
+	asns, err := ParseASPrefixes([]string{"198.51.0.0/16 AS64500 Example", "198.51.100.0/24 AS64501 Example Cloud"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewFingerprintProbe(g, 0, time.Hour, 10, 24, 64, asns)
+
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "IPV4": []string{"10.0.0.1/24"}})
+
+	f := tcpFlow("10.0.0.1", "192.0.2.10", nil)
+	f.LastUpdateMetric = &flow.FlowMetric{ABPackets: 2, ABBytes: 100, BAPackets: 1, BABytes: 60}
+	p.OnFlows([]*flow.Flow{
+		f,
+		tcpFlow("10.0.0.1", "192.0.2.11", nil),
+		tcpFlow("10.0.0.1", "198.51.100.7", nil),
+		tcpFlow("10.0.0.1", "198.51.1.1", nil),
+	})
+	p.update()
+
+	subnet := g.GetNode(graph.GenIDNameBased(managerValue, "subnet/192.0.2.0/24"))
+	if subnet == nil {
+		t.Fatal("Expected a subnet node for 192.0.2.0/24")
+	}
+	if children := g.LookupChildren(subnet, nil, nil); len(children) != 2 {
+		t.Errorf("Expected the subnet to own 2 endpoints, got %v", children)
+	}
+
+	cloud := g.GetNode(graph.GenIDNameBased(managerValue, "as/AS64501 Example Cloud"))
+	if cloud == nil || len(g.LookupChildren(cloud, nil, nil)) != 1 {
+		t.Errorf("Expected the most specific autonomous system to own 198.51.100.7, got %v", cloud)
+	}
+	if g.GetNode(graph.GenIDNameBased(managerValue, "as/AS64500 Example")) == nil {
+		t.Error("Expected an autonomous system node for 198.51.1.1")
+	}
+	if g.GetNode(graph.GenIDNameBased(managerValue, "subnet/10.0.0.0/24")) != nil {
+		t.Error("Expected no group for the known addresses")
+	}
+
+	edge := g.GetEdge(graph.GenIDNameBased(managerValue, "traffic/10.0.0.1/192.0.2.10"))
+	if edge == nil {
+		t.Fatal("Expected a traffic edge between eth0 and 192.0.2.10")
+	}
+	if edge.GetParent() != eth0.ID {
+		t.Errorf("Expected the traffic edge to start from eth0, got %v", edge)
+	}
+	if bytes, _ := edge.GetFieldInt64("Traffic.Bytes"); bytes != 160 {
+		t.Errorf("Expected 160 bytes of traffic, got %v", edge.Metadata())
+	}
+
+	// the groups are removed along with their last endpoint
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth1", "IPV4": []string{"198.51.100.7/32"}})
+	p.update()
+
+	if g.GetNode(cloud.ID) != nil {
+		t.Error("Expected the autonomous system node without endpoint to be removed")
+	}
+	if _, err := ParseASPrefixes([]string{"198.51.100.0/24"}); err == nil {
+		t.Error("Expected an error for a prefix without autonomous system name")
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package fingerprint
+
+import (
+	"strings"
+)
+
+// Sources of the detected operating systems, from the most to the least
+// reliable one
+const (
+	DHCPSource = "dhcp"
+	TCPSource  = "tcp"
+	TTLSource  = "ttl"
+)
+
+// tcpSignature describes the TCP stack of a host, from the IP TTL and the
+// TCP header of its SYN or SYN-ACK packets
+type tcpSignature struct {
+	ttl     uint32
+	window  uint32
+	mss     uint32
+	wscale  uint32
+	options string
+}
+
+// tcpSignatures maps the initial TTL and the layout of the SYN options to
+// the operating systems using them by default
+var tcpSignatures = []struct {
+	os         string
+	initialTTL uint32
+	options    string
+}{
+	{"Linux", 64, "M,S,T,N,W"},
+	{"Linux", 64, "M,N,N,S,N,W"},
+	{"macOS", 64, "M,N,W,N,N,T,S,E,E"},
+	{"macOS", 64, "M,N,W,N,N,T,S,E"},
+	{"FreeBSD", 64, "M,N,W,S,T"},
+	{"OpenBSD", 64, "M,N,N,S,N,W,N,N,T"},
+	{"Windows", 128, "M,N,W,N,N,S"},
+	{"Windows", 128, "M,N,W,S,T"},
+	{"Windows XP", 128, "M,N,N,S"},
+	{"Cisco IOS", 255, "M"},
+}
+
+// dhcpVendorClasses maps the prefixes of the DHCP vendor class identifiers
+// to the operating systems sending them
+var dhcpVendorClasses = []struct {
+	os     string
+	prefix string
+}{
+	{"Windows", "MSFT"},
+	{"Android", "android-dhcp"},
+	{"Linux", "dhcpcd"},
+	{"Linux", "udhcp"},
+	{"Cisco IOS", "Cisco"},
+}
+
+// dhcpParameterLists maps the options requested by the DHCP clients, in
+// their order, to their operating systems
+var dhcpParameterLists = map[string]string{
+	"1,121,3,6,15,119,252":                       "macOS",
+	"1,121,3,6,15,119,252,95,44,46":              "macOS",
+	"1,3,6,15,119,252":                           "macOS",
+	"1,3,6,15,31,33,43,44,46,47,119,121,249,252": "Windows",
+	"1,15,3,6,44,46,47,31,33,121,249,43":         "Windows",
+	"1,15,3,6,44,46,47,31,33,121,249,43,252":     "Windows",
+	"1,28,2,3,15,6,119,12,44,47,26,121,42":       "Linux",
+	"1,3,6,15,26,28,51,58,59,43":                 "Android",
+	"1,3,6,15,26,28,51,58,59":                    "Android",
+}
+
+// ttlSystems maps the initial TTLs to the families of operating systems
+// using them
+var ttlSystems = map[uint32]string{
+	64:  "Linux/Unix",
+	128: "Windows",
+	255: "Network device",
+}
+
+// initialTTL returns the initial TTL of a packet received with the given
+// TTL, the usual initial values being 32, 64, 128 and 255
+func initialTTL(ttl uint32) uint32 {
+	for _, initial := range []uint32{32, 64, 128} {
+		if ttl <= initial {
+			return initial
+		}
+	}
+	return 255
+}
+
+func detectDHCP(lease *dhcpLease) string {
+	if lease == nil {
+		return ""
+	}
+
+	for _, vendor := range dhcpVendorClasses {
+		if strings.HasPrefix(lease.vendorClass, vendor.prefix) {
+			return vendor.os
+		}
+	}
+	return dhcpParameterLists[lease.parameterList]
+}
+
+func detectTCP(signature *tcpSignature) string {
+	if signature == nil {
+		return ""
+	}
+
+	initial := initialTTL(signature.ttl)
+	for _, s := range tcpSignatures {
+		if s.initialTTL == initial && s.options == signature.options {
+			return s.os
+		}
+	}
+	return ""
+}
+
+// detectOS returns the operating system of a host along with the source of
+// the detection, the DHCP options being preferred to the TCP signature,
+// itself preferred to the TTL heuristic
+func detectOS(signature *tcpSignature, lease *dhcpLease) (string, string) {
+	if os := detectDHCP(lease); os != "" {
+		return os, DHCPSource
+	}
+
+	if os := detectTCP(signature); os != "" {
+		return os, TCPSource
+	}
+
+	if signature != nil {
+		if os, found := ttlSystems[initialTTL(signature.ttl)]; found {
+			return os, TTLSource
+		}
+	}
+
+	return "", ""
+}
@@ -0,0 +1,361 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package ipconflict
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	managerValue   = "ipconflict"
+	annotationType = "annotation"
+)
+
+// ipFields are the metadata holding the addresses of the interfaces
+var ipFields = []string{"IPV4", "IPV6"}
+
+var ipFilter = graph.NewGraphElementFilter(filters.NewOrFilter(
+	filters.NewNotNullFilter("IPV4"),
+	filters.NewNotNullFilter("IPV6"),
+))
+
+// ConflictProbe detects the addresses used by several interfaces of the same
+// L2 domain, the interfaces connected by layer2 edges, and reports each
+// conflict with an annotation node linked to the conflicting interfaces
+type ConflictProbe struct {
+	graph.DefaultGraphListener
+	graph     *graph.Graph
+	interval  time.Duration
+	dirty     int64
+	conflicts map[graph.Identifier]*graph.Node
+	quit      chan bool
+	wg        sync.WaitGroup
+}
+
+// conflict describes an address used by interfaces of different MAC
+// addresses in the same L2 domain
+type conflict struct {
+	ip    string
+	macs  []string
+	nodes []*graph.Node
+}
+
+// id identifies a conflict by its address and its MAC addresses, so that
+// its annotation is kept while the conflicting interfaces remain the same
+func (c *conflict) id() graph.Identifier {
+	return graph.GenIDNameBased(managerValue, c.ip+"/"+strings.Join(c.macs, ","))
+}
+
+// nodeIPs returns the addresses of a node, ignoring the loopback and link
+// local ones which are legitimately reused
+func nodeIPs(n *graph.Node) []string {
+	var ips []string
+	for _, field := range ipFields {
+		value, err := n.GetField(field)
+		if err != nil {
+			continue
+		}
+
+		var values []string
+		switch v := value.(type) {
+		case string:
+			values = []string{v}
+		case []string:
+			values = v
+		case []interface{}:
+			for _, ip := range v {
+				if s, ok := ip.(string); ok {
+					values = append(values, s)
+				}
+			}
+		}
+
+		for _, ip := range values {
+			if i := strings.Index(ip, "/"); i != -1 {
+				ip = ip[:i]
+			}
+
+			parsed := net.ParseIP(ip)
+			if parsed == nil || parsed.IsUnspecified() || parsed.IsLoopback() || parsed.IsLinkLocalUnicast() {
+				continue
+			}
+			ips = append(ips, parsed.String())
+		}
+	}
+	return ips
+}
+
+// l2Domains returns the L2 domain, identified by the first node visited, of
+// the given nodes, walking the layer2 edges
+func (p *ConflictProbe) l2Domains(nodes []*graph.Node) map[graph.Identifier]graph.Identifier {
+	domains := make(map[graph.Identifier]graph.Identifier)
+
+	for _, n := range nodes {
+		if _, found := domains[n.ID]; found {
+			continue
+		}
+
+		domains[n.ID] = n.ID
+		queue := []*graph.Node{n}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+
+			for _, e := range p.graph.GetNodeEdges(current, topology.Layer2Metadata) {
+				peer := e.GetChild()
+				if peer == current.ID {
+					peer = e.GetParent()
+				}
+
+				if _, found := domains[peer]; found {
+					continue
+				}
+
+				if node := p.graph.GetNode(peer); node != nil {
+					domains[peer] = n.ID
+					queue = append(queue, node)
+				}
+			}
+		}
+	}
+
+	return domains
+}
+
+// detect returns the conflicts of the graph
+func (p *ConflictProbe) detect() []*conflict {
+	var nodes []*graph.Node
+	for _, n := range p.graph.GetNodes(ipFilter) {
+		if manager, _ := n.GetFieldString("Manager"); manager != managerValue {
+			nodes = append(nodes, n)
+		}
+	}
+
+	domains := p.l2Domains(nodes)
+
+	type key struct {
+		domain graph.Identifier
+		ip     string
+	}
+
+	users := make(map[key]map[string][]*graph.Node)
+	for _, n := range nodes {
+		// interfaces without MAC address are counted as different interfaces
+		mac, _ := n.GetFieldString("MAC")
+		if mac == "" {
+			mac = string(n.ID)
+		}
+
+		for _, ip := range nodeIPs(n) {
+			k := key{domain: domains[n.ID], ip: ip}
+			if _, found := users[k]; !found {
+				users[k] = make(map[string][]*graph.Node)
+			}
+			users[k][mac] = append(users[k][mac], n)
+		}
+	}
+
+	var conflicts []*conflict
+	for k, macs := range users {
+		if len(macs) < 2 {
+			continue
+		}
+
+		c := &conflict{ip: k.ip}
+		for mac, nodes := range macs {
+			c.macs = append(c.macs, mac)
+			c.nodes = append(c.nodes, nodes...)
+		}
+		sort.Strings(c.macs)
+
+		conflicts = append(conflicts, c)
+	}
+
+	return conflicts
+}
+
+// update creates the annotations of the new conflicts and removes the
+// annotations of the resolved ones
+func (p *ConflictProbe) update() {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	seen := make(map[graph.Identifier]bool)
+	for _, c := range p.detect() {
+		id := c.id()
+		seen[id] = true
+
+		if _, found := p.conflicts[id]; found {
+			continue
+		}
+
+		logging.GetLogger().Warningf("IP address %s used by the MAC addresses %s of the same L2 domain", c.ip, strings.Join(c.macs, ", "))
+
+		macs := make([]interface{}, len(c.macs))
+		for i, mac := range c.macs {
+			macs[i] = mac
+		}
+
+		n := p.graph.NewNode(id, graph.Metadata{
+			"Type":    annotationType,
+			"Manager": managerValue,
+			"Name":    "IP conflict " + c.ip,
+			"Annotation": map[string]interface{}{
+				"Source":      managerValue,
+				"Kind":        "ip-conflict",
+				"Timestamp":   common.UnixMillis(time.Now().UTC()),
+				"Description": fmt.Sprintf("IP address %s used by %d interfaces of the same L2 domain", c.ip, len(c.macs)),
+			},
+			"IPConflict": map[string]interface{}{
+				"IP":   c.ip,
+				"MACs": macs,
+			},
+		})
+
+		for _, node := range c.nodes {
+			p.graph.Link(n, node, graph.Metadata{"RelationType": annotationType})
+		}
+		p.conflicts[id] = n
+	}
+
+	for id, n := range p.conflicts {
+		if !seen[id] {
+			logging.GetLogger().Infof("IP conflict %s resolved", n.Metadata()["Name"])
+			p.graph.DelNode(n)
+			delete(p.conflicts, id)
+		}
+	}
+}
+
+func (p *ConflictProbe) invalidate() {
+	atomic.StoreInt64(&p.dirty, 1)
+}
+
+func (p *ConflictProbe) onNodeEvent(n *graph.Node) {
+	if manager, _ := n.GetFieldString("Manager"); manager != managerValue {
+		p.invalidate()
+	}
+}
+
+// OnNodeAdded event
+func (p *ConflictProbe) OnNodeAdded(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeUpdated event
+func (p *ConflictProbe) OnNodeUpdated(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeDeleted event
+func (p *ConflictProbe) OnNodeDeleted(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+func (p *ConflictProbe) onEdgeEvent(e *graph.Edge) {
+	if relationType, _ := e.GetFieldString("RelationType"); relationType == topology.Layer2Link {
+		p.invalidate()
+	}
+}
+
+// OnEdgeAdded event
+func (p *ConflictProbe) OnEdgeAdded(e *graph.Edge) {
+	p.onEdgeEvent(e)
+}
+
+// OnEdgeDeleted event
+func (p *ConflictProbe) OnEdgeDeleted(e *graph.Edge) {
+	p.onEdgeEvent(e)
+}
+
+func (p *ConflictProbe) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// the detection is only done when the graph changed, at most
+			// once per interval
+			if atomic.CompareAndSwapInt64(&p.dirty, 1, 0) {
+				p.update()
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Start the probe
+func (p *ConflictProbe) Start() {
+	p.graph.AddEventListener(p)
+
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop the probe
+func (p *ConflictProbe) Stop() {
+	p.graph.RemoveEventListener(p)
+
+	p.quit <- true
+	p.wg.Wait()
+}
+
+// NewConflictProbe creates a new IP conflict probe, checking the graph at
+// most once per interval
+func NewConflictProbe(g *graph.Graph, interval time.Duration) *ConflictProbe {
+	return &ConflictProbe{
+		graph:     g,
+		interval:  interval,
+		dirty:     1,
+		conflicts: make(map[graph.Identifier]*graph.Node),
+		quit:      make(chan bool),
+	}
+}
+
+// NewConflictProbeFromConfig creates a new IP conflict probe based on
+// configuration
+func NewConflictProbeFromConfig(g *graph.Graph) (*ConflictProbe, error) {
+	interval := time.Duration(config.GetInt("analyzer.topology.ipconflict.interval")) * time.Second
+	if interval <= 0 {
+		return nil, errors.New("analyzer.topology.ipconflict.interval must be a positive number of seconds")
+	}
+
+	return NewConflictProbe(g, interval), nil
+}
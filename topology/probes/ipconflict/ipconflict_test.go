@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package ipconflict
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestConflicts(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+	p := NewConflictProbe(g, 0)
+
+	bridge := g.NewNode(graph.GenID(), graph.Metadata{"Type": "bridge", "Name": "br0"})
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "MAC": "00:00:00:00:00:01", "IPV4": []string{"10.0.0.1/24", "127.0.0.1/8"}})
+	eth1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth1", "MAC": "00:00:00:00:00:02", "IPV4": []interface{}{"10.0.0.1/24"}})
+	eth2 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth2", "MAC": "00:00:00:00:00:03", "IPV4": []string{"10.0.0.1/24"}})
+	lo := g.NewNode(graph.GenID(), graph.Metadata{"Name": "lo", "MAC": "00:00:00:00:00:04", "IPV4": []string{"127.0.0.1/8"}})
+
+	topology.AddLayer2Link(g, bridge, eth0, nil)
+	topology.AddLayer2Link(g, bridge, eth1, nil)
+	topology.AddLayer2Link(g, bridge, lo, nil)
+
+	p.update()
+
+	if len(p.conflicts) != 1 {
+		t.Fatalf("Expected one conflict, got %v", p.conflicts)
+	}
+
+	for _, annotation := range p.conflicts {
+		if ip, _ := annotation.GetFieldString("IPConflict.IP"); ip != "10.0.0.1" {
+			t.Errorf("Wrong conflicting address: %v", annotation.Metadata())
+		}
+
+		edges := g.GetNodeEdges(annotation, nil)
+		if len(edges) != 2 {
+			t.Errorf("The annotation should be linked to eth0 and eth1, got %v", edges)
+		}
+		for _, e := range edges {
+			if e.GetChild() == eth2.ID {
+				t.Error("eth2 is not in the L2 domain of the conflict")
+			}
+		}
+	}
+
+	g.AddMetadata(eth1, "IPV4", []string{"10.0.0.2/24"})
+	p.update()
+
+	if len(p.conflicts) != 0 {
+		t.Errorf("The conflict should be resolved, got %v", p.conflicts)
+	}
+	if len(g.GetNodes(graph.Metadata{"Manager": managerValue})) != 0 {
+		t.Error("The annotation of the resolved conflict should be removed")
+	}
+}
@@ -27,8 +27,10 @@ import (
 	"time"
 
 	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/probe"
 
 	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
@@ -38,8 +40,9 @@ import (
 )
 
 var clientset *kubernetes.Clientset = nil
+var clientConfig *rest.Config = nil
 
-func newClientset() (*kubernetes.Clientset, error) {
+func newClientConfig() (*rest.Config, error) {
 	kubeconfig := config.GetString("k8s.config_file")
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	if err != nil {
@@ -54,6 +57,10 @@ func newClientset() (*kubernetes.Clientset, error) {
 		}
 	}
 
+	return config, nil
+}
+
+func newClientset(config *rest.Config) (*kubernetes.Clientset, error) {
 	clntset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create Kubernetes client: %s", err.Error())
@@ -63,7 +70,10 @@ func newClientset() (*kubernetes.Clientset, error) {
 }
 
 func initClientset() (err error) {
-	clientset, err = newClientset()
+	if clientConfig, err = newClientConfig(); err != nil {
+		return
+	}
+	clientset, err = newClientset(clientConfig)
 	return
 }
 
@@ -124,6 +134,18 @@ func (d *defaultKubeCacheEventHandler) OnDelete(obj interface{}) {
 func newKubeCache(restClient rest.Interface, objType runtime.Object, resources string, handler cache.ResourceEventHandler) *kubeCache {
 	watchlist := cache.NewListWatchFromClient(restClient, resources, api.NamespaceAll, fields.Everything())
 
+	// watch failures end up in a new list, report its outcome as the probe state
+	listFunc := watchlist.ListFunc
+	watchlist.ListFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+		obj, err := listFunc(options)
+		if err != nil {
+			probe.ReportError(managerValue+"."+resources, fmt.Errorf("Failed to list Kubernetes %s: %s", resources, err.Error()))
+		} else {
+			probe.ReportRecovery(managerValue + "." + resources)
+		}
+		return obj, err
+	}
+
 	cacheHandler := cache.ResourceEventHandlerFuncs{}
 	if handler != nil {
 		cacheHandler.AddFunc = handler.OnAdd
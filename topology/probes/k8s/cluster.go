@@ -41,14 +41,19 @@ type clusterProbe struct {
 }
 
 func newClusterLinkedObjectIndexer(g *graph.Graph) *graph.MetadataIndexer {
+	linkedTypeFilter := filters.NewOrFilter(
+		filters.NewTermStringFilter("Type", "namespace"),
+		filters.NewTermStringFilter("Type", "node"),
+		filters.NewTermStringFilter("Type", "persistentvolume"),
+		filters.NewTermStringFilter("Type", "persistentvolumeclaim"),
+	)
+	for _, ty := range customResourceTypes(false) {
+		linkedTypeFilter.BoolFilter.Filters = append(linkedTypeFilter.BoolFilter.Filters, filters.NewTermStringFilter("Type", ty))
+	}
+
 	filter := filters.NewAndFilter(
 		filters.NewTermStringFilter("Manager", managerValue),
-		filters.NewOrFilter(
-			filters.NewTermStringFilter("Type", "namespace"),
-			filters.NewTermStringFilter("Type", "node"),
-			filters.NewTermStringFilter("Type", "persistentvolume"),
-			filters.NewTermStringFilter("Type", "persistentvolumeclaim"),
-		),
+		linkedTypeFilter,
 	)
 	m := graph.NewGraphElementFilter(filter)
 	return graph.NewMetadataIndexer(g, m)
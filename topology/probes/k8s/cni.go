@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package k8s
+
+import (
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// CNI specific fields identifying the pod of an endpoint
+const (
+	CiliumPodNamespaceField = "Cilium.PodNamespace"
+	CiliumPodNameField      = "Cilium.PodName"
+	CalicoPodNamespaceField = "Calico.Namespace"
+	CalicoPodNameField      = "Calico.Pod"
+)
+
+// cniProbe links the pods to the endpoints reported by the CNI probes of
+// the agents
+type cniProbe struct {
+	graph.DefaultGraphListener
+	graph            *graph.Graph
+	podIndexer       *graph.MetadataIndexer
+	endpointIndexers []*graph.MetadataIndexer
+}
+
+func newCNIEndpointIndexer(g *graph.Graph, manager, ty, namespaceField, nameField string) *graph.MetadataIndexer {
+	filter := filters.NewAndFilter(
+		filters.NewTermStringFilter("Manager", manager),
+		filters.NewTermStringFilter("Type", ty),
+		filters.NewNotNullFilter(namespaceField),
+		filters.NewNotNullFilter(nameField))
+	m := graph.NewGraphElementFilter(filter)
+
+	return graph.NewMetadataIndexer(g, m, namespaceField, nameField)
+}
+
+func (p *cniProbe) OnNodeAdded(n *graph.Node) {
+	if ty, _ := n.GetFieldString("Type"); ty == "pod" {
+		namespace, _ := n.GetFieldString("Namespace")
+		name, _ := n.GetFieldString("Name")
+		for _, indexer := range p.endpointIndexers {
+			endpoints, _ := indexer.Get(namespace, name)
+			for _, endpoint := range endpoints {
+				addLink(p.graph, n, endpoint)
+				logging.GetLogger().Debugf("Linked %s to endpoint %s", dumpGraphNode(n), endpoint.ID)
+			}
+		}
+		return
+	}
+
+	for _, fields := range [][]string{
+		{CiliumPodNamespaceField, CiliumPodNameField},
+		{CalicoPodNamespaceField, CalicoPodNameField},
+	} {
+		namespace, _ := n.GetFieldString(fields[0])
+		name, _ := n.GetFieldString(fields[1])
+		if namespace == "" || name == "" {
+			continue
+		}
+
+		if pods, _ := p.podIndexer.Get(namespace, name); len(pods) > 0 {
+			addLink(p.graph, pods[0], n)
+			logging.GetLogger().Debugf("Linked %s to endpoint %s", dumpGraphNode(pods[0]), n.ID)
+		}
+	}
+}
+
+func (p *cniProbe) OnNodeUpdated(n *graph.Node) {
+	p.OnNodeAdded(n)
+}
+
+func (p *cniProbe) Start() {
+	p.podIndexer.AddEventListener(p)
+	p.podIndexer.Start()
+	for _, indexer := range p.endpointIndexers {
+		indexer.AddEventListener(p)
+		indexer.Start()
+	}
+}
+
+func (p *cniProbe) Stop() {
+	p.podIndexer.RemoveEventListener(p)
+	p.podIndexer.Stop()
+	for _, indexer := range p.endpointIndexers {
+		indexer.RemoveEventListener(p)
+		indexer.Stop()
+	}
+}
+
+func newCNIProbe(g *graph.Graph) probe.Probe {
+	return &cniProbe{
+		graph:      g,
+		podIndexer: newPodIndexerByName(g),
+		endpointIndexers: []*graph.MetadataIndexer{
+			newCNIEndpointIndexer(g, "cilium", "ciliumendpoint", CiliumPodNamespaceField, CiliumPodNameField),
+			newCNIEndpointIndexer(g, "calico", "calicoendpoint", CalicoPodNamespaceField, CalicoPodNameField),
+		},
+	}
+}
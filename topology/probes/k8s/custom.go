@@ -0,0 +1,287 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package k8s
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology/graph"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// customResourceField maps a field of a custom resource to a node metadata
+type customResourceField struct {
+	Key  string `mapstructure:"key"`
+	Path string `mapstructure:"path"`
+}
+
+// customResource describes a resource, typically defined by a CRD, to
+// reflect in the graph
+type customResource struct {
+	Group      string                `mapstructure:"group"`
+	Version    string                `mapstructure:"version"`
+	Resource   string                `mapstructure:"resource"`
+	Type       string                `mapstructure:"type"`
+	Namespaced bool                  `mapstructure:"namespaced"`
+	Fields     []customResourceField `mapstructure:"fields"`
+}
+
+var customResources []customResource
+
+func getCustomResources() ([]customResource, error) {
+	var resources []customResource
+	if err := config.GetConfig().UnmarshalKey("k8s.custom_resources", &resources); err != nil {
+		return nil, fmt.Errorf("Invalid k8s.custom_resources: %s", err.Error())
+	}
+
+	for i, cr := range resources {
+		if cr.Version == "" || cr.Resource == "" {
+			return nil, fmt.Errorf("Custom resource %d: version and resource are mandatory", i)
+		}
+		if cr.Type == "" {
+			resources[i].Type = cr.Resource
+		}
+		for _, field := range cr.Fields {
+			if field.Key == "" || field.Path == "" {
+				return nil, fmt.Errorf("Custom resource %s: fields require a key and a path", cr.Resource)
+			}
+		}
+	}
+
+	return resources, nil
+}
+
+func initCustomResources() (err error) {
+	customResources, err = getCustomResources()
+	return
+}
+
+// customResourceTypes returns the node types of the custom resources either
+// namespaced or cluster wide
+func customResourceTypes(namespaced bool) (types []string) {
+	for _, cr := range customResources {
+		if cr.Namespaced == namespaced {
+			types = append(types, cr.Type)
+		}
+	}
+	return
+}
+
+// unstructuredCodec decodes any resource into unstructured objects
+type unstructuredCodec struct{}
+
+func (unstructuredCodec) Decode(data []byte, gvk *schema.GroupVersionKind, obj runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	return unstructured.UnstructuredJSONScheme.Decode(data, gvk, obj)
+}
+
+func (unstructuredCodec) Encode(obj runtime.Object, w io.Writer) error {
+	return unstructured.UnstructuredJSONScheme.Encode(obj, w)
+}
+
+func newCustomResourceRESTClient(cr customResource) (*rest.RESTClient, error) {
+	var info runtime.SerializerInfo
+	for _, i := range scheme.Codecs.SupportedMediaTypes() {
+		if i.MediaType == runtime.ContentTypeJSON {
+			info = i
+			break
+		}
+	}
+	info.Serializer = unstructuredCodec{}
+	info.PrettySerializer = nil
+
+	cfg := *clientConfig
+	cfg.ContentConfig = rest.ContentConfig{
+		AcceptContentTypes:   runtime.ContentTypeJSON,
+		ContentType:          runtime.ContentTypeJSON,
+		GroupVersion:         &schema.GroupVersion{Group: cr.Group, Version: cr.Version},
+		NegotiatedSerializer: serializer.NegotiatedSerializerWrapper(info),
+	}
+	cfg.APIPath = "/apis"
+	if cr.Group == "" {
+		cfg.APIPath = "/api"
+	}
+
+	return rest.RESTClientFor(&cfg)
+}
+
+// lookupField returns the value of a dot separated path of an unstructured object
+func lookupField(obj map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = obj
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+type customResourceProbe struct {
+	defaultKubeCacheEventHandler
+	graph.DefaultGraphListener
+	*kubeCache
+	graph    *graph.Graph
+	resource customResource
+	orphans  map[graph.Identifier][]graph.Identifier
+}
+
+func dumpCustomResource(cr customResource, obj *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s{Namespace: %s, Name: %s}", cr.Type, obj.GetNamespace(), obj.GetName())
+}
+
+func (p *customResourceProbe) fields(obj *unstructured.Unstructured) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, field := range p.resource.Fields {
+		if value, ok := lookupField(obj.Object, field.Path); ok {
+			fields[field.Key] = common.NormalizeValue(value)
+		}
+	}
+	return fields
+}
+
+func (p *customResourceProbe) newMetadata(obj *unstructured.Unstructured) graph.Metadata {
+	m := newMetadata(p.resource.Type, obj.GetNamespace(), obj.GetName(), obj.Object)
+	for k, v := range p.fields(obj) {
+		m.SetField(k, v)
+	}
+	return m
+}
+
+// linkOwners links the node to its owners, owners not yet in the graph are
+// linked once they are added
+func (p *customResourceProbe) linkOwners(obj *unstructured.Unstructured, node *graph.Node) {
+	for _, ref := range obj.GetOwnerReferences() {
+		ownerID := graph.Identifier(ref.UID)
+		if owner := p.graph.GetNode(ownerID); owner != nil {
+			addOwnershipLink(p.graph, owner, node)
+		} else if !containsID(p.orphans[ownerID], node.ID) {
+			p.orphans[ownerID] = append(p.orphans[ownerID], node.ID)
+		}
+	}
+}
+
+func containsID(ids []graph.Identifier, id graph.Identifier) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *customResourceProbe) OnAdd(obj interface{}) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		p.graph.Lock()
+		defer p.graph.Unlock()
+
+		node := newNode(p.graph, graph.Identifier(u.GetUID()), p.newMetadata(u))
+		p.linkOwners(u, node)
+		logging.GetLogger().Debugf("Added %s", dumpCustomResource(p.resource, u))
+	}
+}
+
+func (p *customResourceProbe) OnUpdate(oldObj, newObj interface{}) {
+	if u, ok := newObj.(*unstructured.Unstructured); ok {
+		p.graph.Lock()
+		defer p.graph.Unlock()
+
+		if node := p.graph.GetNode(graph.Identifier(u.GetUID())); node != nil {
+			tr := p.graph.StartMetadataTransaction(node)
+			tr.AddMetadata(extraField, common.NormalizeValue(u.Object))
+			for k, v := range p.fields(u) {
+				tr.AddMetadata(k, v)
+			}
+			tr.Commit()
+
+			p.linkOwners(u, node)
+			logging.GetLogger().Debugf("Updated %s", dumpCustomResource(p.resource, u))
+		}
+	}
+}
+
+func (p *customResourceProbe) OnDelete(obj interface{}) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		p.graph.Lock()
+		defer p.graph.Unlock()
+
+		if node := p.graph.GetNode(graph.Identifier(u.GetUID())); node != nil {
+			p.graph.DelNode(node)
+			logging.GetLogger().Debugf("Deleted %s", dumpCustomResource(p.resource, u))
+		}
+	}
+}
+
+// OnNodeAdded links the custom resources waiting for this owner
+func (p *customResourceProbe) OnNodeAdded(owner *graph.Node) {
+	children, found := p.orphans[owner.ID]
+	if !found {
+		return
+	}
+	delete(p.orphans, owner.ID)
+
+	for _, id := range children {
+		if child := p.graph.GetNode(id); child != nil {
+			addOwnershipLink(p.graph, owner, child)
+		}
+	}
+}
+
+func (p *customResourceProbe) Start() {
+	p.graph.AddEventListener(p)
+	p.kubeCache.Start()
+}
+
+func (p *customResourceProbe) Stop() {
+	p.kubeCache.Stop()
+	p.graph.RemoveEventListener(p)
+}
+
+func newCustomResourceProbe(g *graph.Graph, cr customResource) (probe.Probe, error) {
+	restClient, err := newCustomResourceRESTClient(cr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create client for custom resource %s: %s", cr.Resource, err.Error())
+	}
+
+	p := &customResourceProbe{
+		graph:    g,
+		resource: cr,
+		orphans:  make(map[graph.Identifier][]graph.Identifier),
+	}
+	p.kubeCache = newKubeCache(restClient, &unstructured.Unstructured{}, cr.Resource, p)
+	return p, nil
+}
@@ -52,12 +52,16 @@ func newObjectIndexer(g *graph.Graph) *graph.MetadataIndexer {
 		filters.NewTermStringFilter("Type", "pod"),
 		filters.NewTermStringFilter("Type", "persistentvolume"),
 		filters.NewTermStringFilter("Type", "persistentvolumeclaim"),
+		filters.NewTermStringFilter("Type", "networkattachmentdefinition"),
 		filters.NewTermStringFilter("Type", "networkpolicy"),
 		filters.NewTermStringFilter("Type", "replicaset"),
 		filters.NewTermStringFilter("Type", "replicationcontroller"),
 		filters.NewTermStringFilter("Type", "service"),
 		filters.NewTermStringFilter("Type", "statefulset"),
 	)
+	for _, ty := range customResourceTypes(true) {
+		ownedByNamespaceFilter.BoolFilter.Filters = append(ownedByNamespaceFilter.BoolFilter.Filters, filters.NewTermStringFilter("Type", ty))
+	}
 
 	filter := filters.NewAndFilter(
 		filters.NewTermStringFilter("Manager", managerValue),
@@ -0,0 +1,356 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package k8s
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+
+	api "k8s.io/api/core/v1"
+)
+
+// annotations reporting the network attachments of a pod, the older one is
+// still used by Multus < 3.7
+const (
+	networkStatusAnnotation    = "k8s.v1.cni.cncf.io/network-status"
+	oldNetworkStatusAnnotation = "k8s.v1.cni.cncf.io/networks-status"
+)
+
+const (
+	networkRelationType   = "network"
+	defaultPodInterface   = "eth0"
+	networkAttachmentType = "networkattachmentdefinition"
+)
+
+var ownershipMetadata = graph.Metadata{"RelationType": topology.OwnershipLink}
+
+var networkAttachmentDefinition = customResource{
+	Group:      "k8s.cni.cncf.io",
+	Version:    "v1",
+	Resource:   "network-attachment-definitions",
+	Type:       networkAttachmentType,
+	Namespaced: true,
+	Fields: []customResourceField{
+		{Key: "NetworkAttachment.Config", Path: "spec.config"},
+	},
+}
+
+type networkStatus struct {
+	Name       string   `json:"name"`
+	Interface  string   `json:"interface"`
+	IPs        []string `json:"ips"`
+	MAC        string   `json:"mac"`
+	Default    bool     `json:"default"`
+	DeviceInfo *struct {
+		Type string `json:"type"`
+		PCI  *struct {
+			PCIAddress string `json:"pci-address"`
+		} `json:"pci"`
+	} `json:"device-info"`
+}
+
+// podNetworkProbe links the pods to all their network interfaces, one per
+// network attachment, and the interfaces to their network attachment
+// definition
+type podNetworkProbe struct {
+	defaultKubeCacheEventHandler
+	graph.DefaultGraphListener
+	*kubeCache
+	graph            *graph.Graph
+	podIndexer       *graph.MetadataIndexer
+	dockerIndexer    *graph.MetadataIndexer
+	attachmentIndex  *graph.MetadataIndexer
+	statuses         map[string][]networkStatus
+	pendingIntfNames map[string]map[string]bool
+}
+
+func newDockerPodIndexer(g *graph.Graph) *graph.MetadataIndexer {
+	filter := filters.NewAndFilter(
+		filters.NewTermStringFilter("Manager", "docker"),
+		filters.NewTermStringFilter("Type", "container"),
+		filters.NewNotNullFilter(DockerPodNamespaceField),
+		filters.NewNotNullFilter(DockerPodNameField))
+	m := graph.NewGraphElementFilter(filter)
+
+	return graph.NewMetadataIndexer(g, m, DockerPodNamespaceField, DockerPodNameField)
+}
+
+func newNetworkAttachmentIndexer(g *graph.Graph) *graph.MetadataIndexer {
+	filter := filters.NewAndFilter(
+		filters.NewTermStringFilter("Manager", managerValue),
+		filters.NewTermStringFilter("Type", networkAttachmentType),
+		filters.NewNotNullFilter("Namespace"),
+		filters.NewNotNullFilter("Name"))
+	m := graph.NewGraphElementFilter(filter)
+
+	return graph.NewMetadataIndexer(g, m, "Namespace", "Name")
+}
+
+func podKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func podNetworkStatuses(pod *api.Pod) (statuses []networkStatus) {
+	annotation, found := pod.GetAnnotations()[networkStatusAnnotation]
+	if !found {
+		if annotation, found = pod.GetAnnotations()[oldNetworkStatusAnnotation]; !found {
+			return nil
+		}
+	}
+
+	if err := json.Unmarshal([]byte(annotation), &statuses); err != nil {
+		logging.GetLogger().Warningf("Invalid network status of %s: %s", dumpPod(pod), err.Error())
+		return nil
+	}
+
+	for i, status := range statuses {
+		if status.Interface == "" && status.Default {
+			statuses[i].Interface = defaultPodInterface
+		}
+	}
+
+	return statuses
+}
+
+func networkMetadata(status *networkStatus) map[string]interface{} {
+	ips := make([]interface{}, len(status.IPs))
+	for i, ip := range status.IPs {
+		ips[i] = ip
+	}
+
+	m := map[string]interface{}{
+		"Name":      status.Name,
+		"Interface": status.Interface,
+		"IPs":       ips,
+		"MAC":       status.MAC,
+		"Default":   status.Default,
+	}
+	if info := status.DeviceInfo; info != nil {
+		m["DeviceType"] = info.Type
+		if info.PCI != nil {
+			m["PCIAddress"] = info.PCI.PCIAddress
+		}
+	}
+	return m
+}
+
+// podInterfaces returns the interfaces of the network namespaces of the
+// containers of a pod, by name
+func (p *podNetworkProbe) podInterfaces(namespace, name string) map[string]*graph.Node {
+	intfs := make(map[string]*graph.Node)
+
+	containers, _ := p.dockerIndexer.Get(namespace, name)
+	for _, container := range containers {
+		for _, netns := range p.graph.LookupParents(container, graph.Metadata{"Type": "netns"}, ownershipMetadata) {
+			for _, intf := range p.graph.LookupChildren(netns, nil, ownershipMetadata) {
+				if intfName, _ := intf.GetFieldString("Name"); intfName != "" {
+					intfs[intfName] = intf
+				}
+			}
+		}
+	}
+
+	return intfs
+}
+
+func (p *podNetworkProbe) linkAttachment(podNamespace string, status *networkStatus, intf *graph.Node) {
+	namespace, name := podNamespace, status.Name
+	if s := strings.SplitN(status.Name, "/", 2); len(s) == 2 {
+		namespace, name = s[0], s[1]
+	}
+
+	if nads, _ := p.attachmentIndex.Get(namespace, name); len(nads) > 0 {
+		addLink(p.graph, nads[0], intf)
+	}
+}
+
+func (p *podNetworkProbe) syncPod(key string) {
+	statuses := p.statuses[key]
+	if len(statuses) == 0 {
+		return
+	}
+
+	s := strings.SplitN(key, "/", 2)
+	pods, _ := p.podIndexer.Get(s[0], s[1])
+	if len(pods) == 0 {
+		return
+	}
+	podNode := pods[0]
+
+	intfs := p.podInterfaces(s[0], s[1])
+	pending := make(map[string]bool)
+
+	for i := range statuses {
+		status := &statuses[i]
+
+		intf, found := intfs[status.Interface]
+		if !found {
+			pending[status.Interface] = true
+			continue
+		}
+
+		m := networkMetadata(status)
+		filter := graph.Metadata{"Manager": managerValue, "RelationType": networkRelationType}
+		if e := p.graph.GetFirstLink(podNode, intf, filter); e != nil {
+			if current, _ := e.GetField("Network"); !reflect.DeepEqual(current, m) {
+				p.graph.AddMetadata(e, "Network", m)
+			}
+		} else {
+			em := filter.Clone()
+			em["Network"] = m
+			p.graph.Link(podNode, intf, em, hostID)
+			logging.GetLogger().Debugf("Linked %s to interface %s of network %s", dumpGraphNode(podNode), status.Interface, status.Name)
+		}
+
+		p.linkAttachment(s[0], status, intf)
+	}
+
+	if len(pending) > 0 {
+		p.pendingIntfNames[key] = pending
+	} else {
+		delete(p.pendingIntfNames, key)
+	}
+}
+
+func (p *podNetworkProbe) onPod(pod *api.Pod) {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	key := podKey(pod.GetNamespace(), pod.GetName())
+	if statuses := podNetworkStatuses(pod); len(statuses) > 0 {
+		p.statuses[key] = statuses
+		p.syncPod(key)
+	} else {
+		delete(p.statuses, key)
+		delete(p.pendingIntfNames, key)
+	}
+}
+
+func (p *podNetworkProbe) OnAdd(obj interface{}) {
+	if pod, ok := obj.(*api.Pod); ok {
+		p.onPod(pod)
+	}
+}
+
+func (p *podNetworkProbe) OnUpdate(oldObj, newObj interface{}) {
+	if pod, ok := newObj.(*api.Pod); ok {
+		p.onPod(pod)
+	}
+}
+
+func (p *podNetworkProbe) OnDelete(obj interface{}) {
+	if pod, ok := obj.(*api.Pod); ok {
+		p.graph.Lock()
+		defer p.graph.Unlock()
+
+		key := podKey(pod.GetNamespace(), pod.GetName())
+		delete(p.statuses, key)
+		delete(p.pendingIntfNames, key)
+	}
+}
+
+// OnEdgeAdded resyncs the pods waiting for an interface or a container
+// attached to their network namespace
+func (p *podNetworkProbe) OnEdgeAdded(e *graph.Edge) {
+	if rt, _ := e.GetFieldString("RelationType"); rt != topology.OwnershipLink || len(p.pendingIntfNames) == 0 {
+		return
+	}
+
+	child := p.graph.GetNode(e.GetChild())
+	if child == nil {
+		return
+	}
+
+	if namespace, _ := child.GetFieldString(DockerPodNamespaceField); namespace != "" {
+		name, _ := child.GetFieldString(DockerPodNameField)
+		p.syncPod(podKey(namespace, name))
+		return
+	}
+
+	name, _ := child.GetFieldString("Name")
+	for key, pending := range p.pendingIntfNames {
+		if pending[name] {
+			p.syncPod(key)
+		}
+	}
+}
+
+// OnNodeAdded links the pod nodes added after their pod event and the
+// network attachment definitions added later
+func (p *podNetworkProbe) OnNodeAdded(n *graph.Node) {
+	switch ty, _ := n.GetFieldString("Type"); ty {
+	case "pod":
+		namespace, _ := n.GetFieldString("Namespace")
+		name, _ := n.GetFieldString("Name")
+		p.syncPod(podKey(namespace, name))
+	case networkAttachmentType:
+		for key := range p.statuses {
+			p.syncPod(key)
+		}
+	}
+}
+
+func (p *podNetworkProbe) Start() {
+	p.podIndexer.Start()
+	p.dockerIndexer.Start()
+	p.attachmentIndex.Start()
+	p.graph.AddEventListener(p)
+	p.kubeCache.Start()
+}
+
+func (p *podNetworkProbe) Stop() {
+	p.kubeCache.Stop()
+	p.graph.RemoveEventListener(p)
+	p.podIndexer.Stop()
+	p.dockerIndexer.Stop()
+	p.attachmentIndex.Stop()
+}
+
+func newPodNetworkProbe(g *graph.Graph) probe.Probe {
+	p := &podNetworkProbe{
+		graph:            g,
+		podIndexer:       newPodIndexerByName(g),
+		dockerIndexer:    newDockerPodIndexer(g),
+		attachmentIndex:  newNetworkAttachmentIndexer(g),
+		statuses:         make(map[string][]networkStatus),
+		pendingIntfNames: make(map[string]map[string]bool),
+	}
+	p.kubeCache = newPodKubeCache(p)
+	return p
+}
+
+func newNetworkAttachmentDefinitionProbe(g *graph.Graph) probe.Probe {
+	p, err := newCustomResourceProbe(g, networkAttachmentDefinition)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to create network attachment definition probe: %s", err.Error())
+		return nil
+	}
+	return p
+}
@@ -31,27 +31,31 @@ import (
 
 // Probe for tracking k8s events
 type Probe struct {
-	bundle *probe.ProbeBundle
+	bundle      *probe.ProbeBundle
+	degradation *probe.GraphDegradationHandler
 }
 
 func makeProbeBundle(g *graph.Graph) *probe.ProbeBundle {
 	name2ctor := map[string](func(*graph.Graph) probe.Probe){
-		"cluster":               newClusterProbe,
-		"container":             newContainerProbe,
-		"daemonset":             newDaemonSetProbe,
-		"deployment":            newDeploymentProbe,
-		"ingress":               newIngressProbe,
-		"job":                   newJobProbe,
-		"namespace":             newNamespaceProbe,
-		"networkpolicy":         newNetworkPolicyProbe,
-		"node":                  newNodeProbe,
-		"persistentvolume":      newPersistentVolumeProbe,
-		"persistentvolumeclaim": newPersistentVolumeClaimProbe,
-		"pod":                   newPodProbe,
-		"replicaset":            newReplicaSetProbe,
-		"replicationcontroller": newReplicationControllerProbe,
-		"service":               newServiceProbe,
-		"statefulset":           newStatefulSetProbe,
+		"cluster":                     newClusterProbe,
+		"cni":                         newCNIProbe,
+		"container":                   newContainerProbe,
+		"daemonset":                   newDaemonSetProbe,
+		"deployment":                  newDeploymentProbe,
+		"ingress":                     newIngressProbe,
+		"job":                         newJobProbe,
+		"namespace":                   newNamespaceProbe,
+		"networkattachmentdefinition": newNetworkAttachmentDefinitionProbe,
+		"networkpolicy":               newNetworkPolicyProbe,
+		"node":                        newNodeProbe,
+		"persistentvolume":            newPersistentVolumeProbe,
+		"persistentvolumeclaim":       newPersistentVolumeClaimProbe,
+		"pod":                         newPodProbe,
+		"podnetwork":                  newPodNetworkProbe,
+		"replicaset":                  newReplicaSetProbe,
+		"replicationcontroller":       newReplicationControllerProbe,
+		"service":                     newServiceProbe,
+		"statefulset":                 newStatefulSetProbe,
 	}
 
 	configProbes := config.GetStringSlice("k8s.probes")
@@ -65,21 +69,36 @@ func makeProbeBundle(g *graph.Graph) *probe.ProbeBundle {
 	probes := make(map[string]probe.Probe)
 	for _, name := range configProbes {
 		if ctor, ok := name2ctor[name]; ok {
-			probes[name] = ctor(g)
+			if p := ctor(g); p != nil {
+				probes[name] = p
+			}
 		} else {
 			logging.GetLogger().Errorf("skipping unsupported K8s probe %v", name)
 		}
 	}
+
+	for _, cr := range customResources {
+		p, err := newCustomResourceProbe(g, cr)
+		if err != nil {
+			logging.GetLogger().Errorf("skipping K8s custom resource %s: %s", cr.Resource, err.Error())
+			continue
+		}
+		probes["custom."+cr.Type] = p
+		logging.GetLogger().Infof("K8s custom resource %s: %s/%s %s", cr.Type, cr.Group, cr.Version, cr.Resource)
+	}
+
 	return probe.NewProbeBundle(probes)
 }
 
 // Start k8s probe
 func (p *Probe) Start() {
 	p.bundle.Start()
+	probe.AddDegradationHandler(p.degradation)
 }
 
 // Stop k8s probe
 func (p *Probe) Stop() {
+	probe.RemoveDegradationHandler(p.degradation)
 	p.bundle.Stop()
 }
 
@@ -90,7 +109,17 @@ func NewProbe(g *graph.Graph) (*Probe, error) {
 		return nil, err
 	}
 
+	if err = initCustomResources(); err != nil {
+		return nil, err
+	}
+
+	// degradations of the k8s probes are reported on the cluster node
+	lookup := func() *graph.Node {
+		return g.LookupFirstNode(graph.Metadata{"Manager": managerValue, "Type": "cluster"})
+	}
+
 	return &Probe{
-		bundle: makeProbeBundle(g),
+		bundle:      makeProbeBundle(g),
+		degradation: probe.NewGraphDegradationHandler(g, lookup, managerValue+"."),
 	}, nil
 }
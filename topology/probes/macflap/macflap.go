@@ -0,0 +1,501 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package macflap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	managerValue   = "macflap"
+	annotationType = "annotation"
+)
+
+// entryKey identifies a MAC address learned by a bridge
+type entryKey struct {
+	bridge graph.Identifier
+	vlan   int64
+	mac    string
+}
+
+// entry tracks the port a MAC address was last learned on and the times
+// it moved between the ports of its bridge
+type entry struct {
+	port  graph.Identifier
+	moves []time.Time
+}
+
+// FlapProbe tracks the MAC addresses learned by the bridges, reported in the
+// FDB metadata of their ports, and raises annotations when a MAC address
+// flaps between the ports of a bridge or is learned by several bridges of
+// the same host not connected by layer2 edges, the usual symptoms of loops
+// and misconfigured bonds. The state is only accessed with the graph lock
+// held.
+type FlapProbe struct {
+	graph.DefaultGraphListener
+	graph       *graph.Graph
+	moves       int
+	window      time.Duration
+	maxHops     int
+	entries     map[entryKey]*entry
+	ports       map[graph.Identifier]map[entryKey]bool
+	annotations map[graph.Identifier]*graph.Node
+	quit        chan bool
+	wg          sync.WaitGroup
+}
+
+func isPermanent(states interface{}) bool {
+	switch v := states.(type) {
+	case []string:
+		for _, state := range v {
+			if state == "NUD_PERMANENT" {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, state := range v {
+			if state == "NUD_PERMANENT" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fdbEntries returns the MAC addresses learned on a port, ignoring the
+// permanent entries, the addresses of the port itself, and the multicast
+// ones
+func fdbEntries(n *graph.Node) map[string]int64 {
+	value, err := n.GetField("FDB")
+	if err != nil {
+		return nil
+	}
+
+	var fdb []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		fdb = v
+	case []map[string]interface{}:
+		for _, e := range v {
+			fdb = append(fdb, e)
+		}
+	}
+
+	entries := make(map[string]int64)
+	for _, e := range fdb {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if isPermanent(m["State"]) {
+			continue
+		}
+
+		s, _ := m["MAC"].(string)
+		mac, err := net.ParseMAC(s)
+		if err != nil || mac[0]&0x01 != 0 {
+			continue
+		}
+
+		vlan, _ := common.ToInt64(m["Vlan"])
+		entries[mac.String()] = vlan
+	}
+	return entries
+}
+
+// bridgeOf returns the bridge of a port, either a Linux bridge or the Open
+// vSwitch bridge of the ovsport of the interface
+func (p *FlapProbe) bridgeOf(n *graph.Node) *graph.Node {
+	for _, peer := range p.layer2Peers(n) {
+		switch t, _ := peer.GetFieldString("Type"); t {
+		case "bridge", "ovsbridge":
+			return peer
+		case "ovsport":
+			for _, bridge := range p.layer2Peers(peer) {
+				if t, _ := bridge.GetFieldString("Type"); t == "ovsbridge" {
+					return bridge
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *FlapProbe) layer2Peers(n *graph.Node) (peers []*graph.Node) {
+	for _, e := range p.graph.GetNodeEdges(n, topology.Layer2Metadata) {
+		peer := e.GetChild()
+		if peer == n.ID {
+			peer = e.GetParent()
+		}
+		if node := p.graph.GetNode(peer); node != nil {
+			peers = append(peers, node)
+		}
+	}
+	return
+}
+
+// recentMoves drops the moves older than the window and returns the
+// remaining ones
+func (p *FlapProbe) recentMoves(e *entry, now time.Time) int {
+	i := 0
+	for i < len(e.moves) && now.Sub(e.moves[i]) > p.window {
+		i++
+	}
+	e.moves = e.moves[i:]
+	return len(e.moves)
+}
+
+func (p *FlapProbe) learn(n *graph.Node, now time.Time) {
+	previous := p.ports[n.ID]
+
+	fdb := fdbEntries(n)
+	if len(fdb) == 0 {
+		delete(p.ports, n.ID)
+		return
+	}
+
+	bridge := p.bridgeOf(n)
+	if bridge == nil {
+		return
+	}
+
+	current := make(map[entryKey]bool)
+	for mac, vlan := range fdb {
+		key := entryKey{bridge: bridge.ID, vlan: vlan, mac: mac}
+		current[key] = true
+
+		// only the addresses newly learned on the port are moves, the
+		// previous port may not have aged its entry out yet
+		if previous[key] {
+			continue
+		}
+
+		e, found := p.entries[key]
+		if !found {
+			p.entries[key] = &entry{port: n.ID}
+			continue
+		}
+
+		if e.port != n.ID {
+			e.moves = append(e.moves, now)
+			e.port = n.ID
+		}
+	}
+	p.ports[n.ID] = current
+}
+
+func (p *FlapProbe) onNodeEvent(n *graph.Node) {
+	if manager, _ := n.GetFieldString("Manager"); manager == managerValue {
+		return
+	}
+
+	_, known := p.ports[n.ID]
+	if _, err := n.GetField("FDB"); known || err == nil {
+		p.learn(n, time.Now())
+	}
+}
+
+// OnNodeAdded event
+func (p *FlapProbe) OnNodeAdded(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeUpdated event
+func (p *FlapProbe) OnNodeUpdated(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeDeleted event
+func (p *FlapProbe) OnNodeDeleted(n *graph.Node) {
+	delete(p.ports, n.ID)
+}
+
+// annotate creates, if needed, the annotation of the given identifier
+// linked to the given nodes
+func (p *FlapProbe) annotate(id graph.Identifier, kind, name, description string, m graph.Metadata, nodes []*graph.Node) {
+	if _, found := p.annotations[id]; found {
+		return
+	}
+
+	logging.GetLogger().Warning(description)
+
+	metadata := graph.Metadata{
+		"Type":    annotationType,
+		"Manager": managerValue,
+		"Name":    name,
+		"Annotation": map[string]interface{}{
+			"Source":      managerValue,
+			"Kind":        kind,
+			"Timestamp":   common.UnixMillis(time.Now().UTC()),
+			"Description": description,
+		},
+	}
+	for k, v := range m {
+		metadata[k] = v
+	}
+
+	n := p.graph.NewNode(id, metadata)
+	for _, node := range nodes {
+		p.graph.Link(n, node, graph.Metadata{"RelationType": annotationType})
+	}
+	p.annotations[id] = n
+}
+
+// flaps annotates the MAC addresses moving too often between the ports of
+// their bridge
+func (p *FlapProbe) flaps(now time.Time, seen map[graph.Identifier]bool) {
+	for key, e := range p.entries {
+		bridge := p.graph.GetNode(key.bridge)
+		if bridge == nil {
+			delete(p.entries, key)
+			continue
+		}
+
+		moves := p.recentMoves(e, now)
+		if moves < p.moves {
+			if moves == 0 {
+				if _, found := p.ports[e.port]; !found {
+					delete(p.entries, key)
+				}
+			}
+			continue
+		}
+
+		id := graph.GenIDNameBased(managerValue, fmt.Sprintf("flap/%s/%d/%s", key.bridge, key.vlan, key.mac))
+		seen[id] = true
+
+		// the ports the address has been learned on
+		nodes := []*graph.Node{bridge}
+		for port, keys := range p.ports {
+			if keys[key] {
+				if node := p.graph.GetNode(port); node != nil {
+					nodes = append(nodes, node)
+				}
+			}
+		}
+
+		bridgeName, _ := bridge.GetFieldString("Name")
+		p.annotate(id, "mac-flap", "MAC flap "+key.mac,
+			fmt.Sprintf("MAC address %s moved %d times between the ports of the bridge %s in %s", key.mac, moves, bridgeName, p.window),
+			graph.Metadata{"MACFlap": map[string]interface{}{
+				"MAC":    key.mac,
+				"Vlan":   key.vlan,
+				"Bridge": bridgeName,
+				"Moves":  int64(moves),
+			}}, nodes)
+	}
+}
+
+// duplicates annotates the MAC addresses learned by bridges of the same
+// host that are not connected by layer2 edges
+func (p *FlapProbe) duplicates(seen map[graph.Identifier]bool) {
+	type learner struct {
+		bridge *graph.Node
+		ports  []*graph.Node
+	}
+
+	learners := make(map[string]map[graph.Identifier]*learner)
+	for port, keys := range p.ports {
+		node := p.graph.GetNode(port)
+		if node == nil {
+			continue
+		}
+
+		for key := range keys {
+			bridge := p.graph.GetNode(key.bridge)
+			if bridge == nil {
+				continue
+			}
+
+			if _, found := learners[key.mac]; !found {
+				learners[key.mac] = make(map[graph.Identifier]*learner)
+			}
+			l, found := learners[key.mac][key.bridge]
+			if !found {
+				l = &learner{bridge: bridge}
+				learners[key.mac][key.bridge] = l
+			}
+			l.ports = append(l.ports, node)
+		}
+	}
+
+	for mac, bridges := range learners {
+		if len(bridges) < 2 {
+			continue
+		}
+
+		var ids []string
+		for id := range bridges {
+			ids = append(ids, string(id))
+		}
+		sort.Strings(ids)
+
+		// the bridges of the same host that are not connected
+		duplicated := make(map[string]*learner)
+		for i, from := range ids {
+			for _, to := range ids[i+1:] {
+				a, b := bridges[graph.Identifier(from)], bridges[graph.Identifier(to)]
+				if a.bridge.Host() != b.bridge.Host() {
+					continue
+				}
+
+				if len(p.graph.LookupEdgePath(a.bridge, b.bridge, topology.Layer2Metadata, p.maxHops, nil)) == 0 {
+					duplicated[from], duplicated[to] = a, b
+				}
+			}
+		}
+
+		if len(duplicated) == 0 {
+			continue
+		}
+
+		var keys, names []string
+		var nodes []*graph.Node
+		for id, l := range duplicated {
+			keys = append(keys, id)
+			name, _ := l.bridge.GetFieldString("Name")
+			names = append(names, name)
+			nodes = append(nodes, l.bridge)
+			nodes = append(nodes, l.ports...)
+		}
+		sort.Strings(keys)
+		sort.Strings(names)
+
+		bridgeNames := make([]interface{}, len(names))
+		for i, name := range names {
+			bridgeNames[i] = name
+		}
+
+		id := graph.GenIDNameBased(managerValue, "duplicate/"+mac+"/"+strings.Join(keys, ","))
+		seen[id] = true
+
+		p.annotate(id, "mac-duplicate", "Duplicate MAC "+mac,
+			fmt.Sprintf("MAC address %s learned by the unconnected bridges %s", mac, strings.Join(names, ", ")),
+			graph.Metadata{"MACDuplicate": map[string]interface{}{
+				"MAC":     mac,
+				"Bridges": bridgeNames,
+			}}, nodes)
+	}
+}
+
+// update creates the annotations of the new flaps and duplicates and
+// removes the annotations of the ones that stopped
+func (p *FlapProbe) update(now time.Time) {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	seen := make(map[graph.Identifier]bool)
+	p.flaps(now, seen)
+	p.duplicates(seen)
+
+	for id, n := range p.annotations {
+		if !seen[id] {
+			logging.GetLogger().Infof("%s resolved", n.Metadata()["Name"])
+			if p.graph.GetNode(id) != nil {
+				p.graph.DelNode(n)
+			}
+			delete(p.annotations, id)
+		}
+	}
+}
+
+func (p *FlapProbe) run() {
+	defer p.wg.Done()
+
+	// check several times per window so that the annotations are removed
+	// shortly after the flaps stopped
+	interval := p.window / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case t := <-ticker.C:
+			p.update(t)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Start the probe
+func (p *FlapProbe) Start() {
+	p.graph.AddEventListener(p)
+
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop the probe
+func (p *FlapProbe) Stop() {
+	p.graph.RemoveEventListener(p)
+
+	p.quit <- true
+	p.wg.Wait()
+}
+
+// NewFlapProbe creates a new MAC flap probe, raising an annotation when a
+// MAC address moves the given number of times within the window
+func NewFlapProbe(g *graph.Graph, moves int, window time.Duration, maxHops int) *FlapProbe {
+	return &FlapProbe{
+		graph:       g,
+		moves:       moves,
+		window:      window,
+		maxHops:     maxHops,
+		entries:     make(map[entryKey]*entry),
+		ports:       make(map[graph.Identifier]map[entryKey]bool),
+		annotations: make(map[graph.Identifier]*graph.Node),
+		quit:        make(chan bool),
+	}
+}
+
+// NewFlapProbeFromConfig creates a new MAC flap probe based on configuration
+func NewFlapProbeFromConfig(g *graph.Graph) (*FlapProbe, error) {
+	moves := config.GetInt("analyzer.topology.macflap.moves")
+	if moves <= 0 {
+		return nil, errors.New("analyzer.topology.macflap.moves must be a positive number")
+	}
+
+	window := time.Duration(config.GetInt("analyzer.topology.macflap.window")) * time.Second
+	if window <= 0 {
+		return nil, errors.New("analyzer.topology.macflap.window must be a positive number of seconds")
+	}
+
+	return NewFlapProbe(g, moves, window, config.GetInt("analyzer.topology.macflap.max_hops")), nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package macflap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func fdb(macs ...string) []interface{} {
+	var entries []interface{}
+	for _, mac := range macs {
+		entries = append(entries, map[string]interface{}{"MAC": mac, "State": []interface{}{"NUD_REACHABLE"}})
+	}
+	return entries
+}
+
+func annotations(p *FlapProbe, kind string) (nodes []*graph.Node) {
+	for _, n := range p.annotations {
+		if k, _ := n.GetFieldString("Annotation.Kind"); k == kind {
+			nodes = append(nodes, n)
+		}
+	}
+	return
+}
+
+func TestFlap(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+	p := NewFlapProbe(g, 3, time.Minute, 10)
+	g.AddEventListener(p)
+
+	bridge := g.NewNode(graph.GenID(), graph.Metadata{"Type": "bridge", "Name": "br0"})
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0"})
+	eth1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth1"})
+	topology.AddLayer2Link(g, bridge, eth0, nil)
+	topology.AddLayer2Link(g, bridge, eth1, nil)
+
+	permanent := map[string]interface{}{"MAC": "00:00:00:00:00:02", "State": []interface{}{"NUD_PERMANENT"}}
+	g.AddMetadata(eth0, "FDB", append(fdb("00:00:00:00:00:01", "01:00:5e:00:00:01"), permanent))
+
+	for i := 0; i < 2; i++ {
+		g.AddMetadata(eth0, "FDB", fdb())
+		g.AddMetadata(eth1, "FDB", fdb("00:00:00:00:00:01"))
+		g.AddMetadata(eth1, "FDB", fdb())
+		g.AddMetadata(eth0, "FDB", fdb("00:00:00:00:00:01"))
+	}
+
+	p.update(time.Now())
+
+	flaps := annotations(p, "mac-flap")
+	if len(flaps) != 1 {
+		t.Fatalf("Expected one flap, got %v", p.annotations)
+	}
+	if mac, _ := flaps[0].GetFieldString("MACFlap.MAC"); mac != "00:00:00:00:00:01" {
+		t.Errorf("Wrong flapping address: %v", flaps[0].Metadata())
+	}
+	if moves, _ := flaps[0].GetFieldInt64("MACFlap.Moves"); moves != 4 {
+		t.Errorf("Expected 4 moves, got %v", flaps[0].Metadata())
+	}
+
+	// the flap is resolved once the moves are out of the window
+	p.update(time.Now().Add(2 * time.Minute))
+
+	if len(p.annotations) != 0 {
+		t.Errorf("Expected no annotation, got %v", p.annotations)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+	p := NewFlapProbe(g, 3, time.Minute, 10)
+	g.AddEventListener(p)
+
+	root := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host1"})
+	br0 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "bridge", "Name": "br0"})
+	br1 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsbridge", "Name": "br1"})
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0"})
+	port := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsport", "Name": "eth1"})
+	eth1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth1"})
+	topology.AddOwnershipLink(g, root, br0, nil)
+	topology.AddOwnershipLink(g, root, br1, nil)
+	topology.AddLayer2Link(g, br0, eth0, nil)
+	topology.AddLayer2Link(g, br1, port, nil)
+	topology.AddLayer2Link(g, port, eth1, nil)
+
+	g.AddMetadata(eth0, "FDB", fdb("00:00:00:00:00:01"))
+	g.AddMetadata(eth1, "FDB", fdb("00:00:00:00:00:01"))
+
+	p.update(time.Now())
+
+	duplicates := annotations(p, "mac-duplicate")
+	if len(duplicates) != 1 {
+		t.Fatalf("Expected one duplicate, got %v", p.annotations)
+	}
+	if edges := g.GetNodeEdges(duplicates[0], nil); len(edges) != 4 {
+		t.Errorf("Expected the annotation linked to the bridges and the ports, got %v", edges)
+	}
+
+	// bridges connected by a layer2 path learn the same addresses
+	topology.AddLayer2Link(g, br0, br1, nil)
+	p.update(time.Now())
+
+	if len(p.annotations) != 0 {
+		t.Errorf("Expected no annotation, got %v", p.annotations)
+	}
+}
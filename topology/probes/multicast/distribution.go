@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package multicast
+
+import (
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const managerValue = "multicast"
+
+var edgeMetadata = graph.Metadata{"RelationType": "multicast", "Manager": managerValue}
+
+// DistributionProbe models the multicast distribution by linking a node
+// per multicast group to the interfaces that joined it, based on the
+// Multicast metadata reported by the agents
+type DistributionProbe struct {
+	graph.DefaultGraphListener
+	graph   *graph.Graph
+	members map[graph.Identifier]map[string]bool
+	groups  map[string]*graph.Node
+	counts  map[string]int
+}
+
+func joinedGroups(n *graph.Node) map[string]bool {
+	list, err := n.GetFieldStringList("Multicast.Groups")
+	if err != nil {
+		return nil
+	}
+
+	joined := make(map[string]bool)
+	for _, group := range list {
+		joined[group] = true
+	}
+	return joined
+}
+
+func (p *DistributionProbe) join(group string, n *graph.Node) {
+	node, found := p.groups[group]
+	if !found {
+		node = p.graph.NewNode(graph.GenIDNameBased(managerValue, group), graph.Metadata{
+			"Manager": managerValue,
+			"Type":    "multicastgroup",
+			"Name":    group,
+		})
+		p.groups[group] = node
+	}
+	p.counts[group]++
+
+	if !p.graph.AreLinked(node, n, edgeMetadata) {
+		p.graph.Link(node, n, edgeMetadata.Clone())
+	}
+}
+
+func (p *DistributionProbe) leave(group string, n *graph.Node) {
+	node, found := p.groups[group]
+	if !found {
+		return
+	}
+
+	if n != nil {
+		for _, e := range p.graph.GetNodeEdges(node, edgeMetadata) {
+			if e.GetChild() == n.ID {
+				p.graph.DelEdge(e)
+			}
+		}
+	}
+
+	if p.counts[group]--; p.counts[group] <= 0 {
+		p.graph.DelNode(node)
+		delete(p.groups, group)
+		delete(p.counts, group)
+	}
+}
+
+func (p *DistributionProbe) onNodeEvent(n *graph.Node) {
+	if manager, _ := n.GetFieldString("Manager"); manager == managerValue {
+		return
+	}
+
+	joined, previous := joinedGroups(n), p.members[n.ID]
+	for group := range joined {
+		if !previous[group] {
+			p.join(group, n)
+		}
+	}
+	for group := range previous {
+		if !joined[group] {
+			p.leave(group, n)
+		}
+	}
+
+	if len(joined) > 0 {
+		p.members[n.ID] = joined
+	} else {
+		delete(p.members, n.ID)
+	}
+}
+
+// OnNodeAdded event
+func (p *DistributionProbe) OnNodeAdded(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeUpdated event
+func (p *DistributionProbe) OnNodeUpdated(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+// OnNodeDeleted event
+func (p *DistributionProbe) OnNodeDeleted(n *graph.Node) {
+	// the edges are removed along with the node
+	for group := range p.members[n.ID] {
+		p.leave(group, nil)
+	}
+	delete(p.members, n.ID)
+}
+
+// Start the probe
+func (p *DistributionProbe) Start() {
+}
+
+// Stop the probe
+func (p *DistributionProbe) Stop() {
+	p.graph.RemoveEventListener(p)
+}
+
+// NewDistributionProbe creates a new probe modeling the multicast distribution
+func NewDistributionProbe(g *graph.Graph) *DistributionProbe {
+	probe := &DistributionProbe{
+		graph:   g,
+		members: make(map[graph.Identifier]map[string]bool),
+		groups:  make(map[string]*graph.Node),
+		counts:  make(map[string]int),
+	}
+	g.AddEventListener(probe)
+
+	return probe
+}
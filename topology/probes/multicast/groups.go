@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package multicast
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// groups holds the multicast groups joined by each interface
+type groups map[string][]string
+
+func (g groups) add(device string, ip net.IP) {
+	// groups joined by every interface, like all-hosts, are of no interest
+	if ip == nil || !ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return
+	}
+
+	group := ip.String()
+	for _, joined := range g[device] {
+		if joined == group {
+			return
+		}
+	}
+	g[device] = append(g[device], group)
+	sort.Strings(g[device])
+}
+
+// parseIGMP parses the IPv4 memberships of /proc/net/igmp, where a line
+// per device is followed by a line per group address printed in host
+// byte order:
+//
+//	Idx Device    : Count Querier   Group    Users Timer    Reporter
+//	2   eth0      :     2      V3
+//	                        010000E0     1 0:00000000       0
+func parseIGMP(r io.Reader, g groups) error {
+	var device string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] == "Idx" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "\t") {
+			device = strings.TrimSuffix(fields[1], ":")
+			continue
+		}
+
+		b, err := hex.DecodeString(fields[0])
+		if err != nil || len(b) != net.IPv4len || device == "" {
+			continue
+		}
+
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(b))
+		g.add(device, ip)
+	}
+
+	return scanner.Err()
+}
+
+// parseIGMP6 parses the IPv6 memberships of /proc/net/igmp6, a line per
+// device and group address:
+//
+//	2    eth0            ff0200000000000000000001ff123456     1 00000004 0
+func parseIGMP6(r io.Reader, g groups) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != net.IPv6len {
+			continue
+		}
+
+		g.add(fields[1], net.IP(b))
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,193 @@
+// +build linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package multicast
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// MembershipProbe describes a probe reading the IGMP and MLD memberships
+// of the network namespaces and reporting the multicast groups joined by
+// the interfaces in their Multicast metadata
+type MembershipProbe struct {
+	graph    *graph.Graph
+	host     *graph.Node
+	interval time.Duration
+	quit     chan bool
+	wg       sync.WaitGroup
+}
+
+func namespaceInode(path string) (uint64, error) {
+	var stats syscall.Stat_t
+	if err := syscall.Stat(path, &stats); err != nil {
+		return 0, err
+	}
+	return stats.Ino, nil
+}
+
+func parseFile(path string, parse func(r io.Reader, g groups) error, g groups) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if err := parse(f, g); err != nil {
+		logging.GetLogger().Debugf("Failed to parse %s: %s", path, err)
+	}
+}
+
+// readMemberships returns the groups of every network namespace, indexed by
+// namespace inode, through the proc entries of a process of each namespace
+func readMemberships() map[uint64]groups {
+	memberships := make(map[uint64]groups)
+
+	pids, _ := filepath.Glob("/proc/[0-9]*")
+	for _, pid := range pids {
+		inode, err := namespaceInode(pid + "/ns/net")
+		if err != nil {
+			continue
+		}
+		if _, found := memberships[inode]; found {
+			continue
+		}
+
+		g := make(groups)
+		parseFile(pid+"/net/igmp", parseIGMP, g)
+		parseFile(pid+"/net/igmp6", parseIGMP6, g)
+		memberships[inode] = g
+	}
+
+	return memberships
+}
+
+func (p *MembershipProbe) updateInterfaces(owner *graph.Node, g groups) {
+	for _, intf := range p.graph.LookupChildren(owner, nil, topology.OwnershipMetadata) {
+		name, _ := intf.GetFieldString("Name")
+		current, err := intf.GetField("Multicast")
+
+		joined := g[name]
+		if len(joined) == 0 {
+			if err == nil {
+				p.graph.DelMetadata(intf, "Multicast")
+			}
+			continue
+		}
+
+		addresses := make([]interface{}, len(joined))
+		for i, group := range joined {
+			addresses[i] = group
+		}
+
+		m := map[string]interface{}{"Groups": addresses}
+		if !reflect.DeepEqual(current, m) {
+			p.graph.AddMetadata(intf, "Multicast", m)
+		}
+	}
+}
+
+func (p *MembershipProbe) update() {
+	hostInode, err := namespaceInode("/proc/self/ns/net")
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to get the host network namespace: %s", err)
+		return
+	}
+
+	memberships := readMemberships()
+
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	for inode, g := range memberships {
+		owner := p.host
+		if inode != hostInode {
+			if owner = p.graph.LookupFirstNode(graph.Metadata{"Type": "netns", "Inode": int64(inode)}); owner == nil {
+				continue
+			}
+		}
+		p.updateInterfaces(owner, g)
+	}
+}
+
+func (p *MembershipProbe) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.update()
+
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Start the probe
+func (p *MembershipProbe) Start() {
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop the probe
+func (p *MembershipProbe) Stop() {
+	p.quit <- true
+	p.wg.Wait()
+}
+
+// NewMembershipProbe creates a new probe tracking the multicast groups
+// joined by the interfaces of the host
+func NewMembershipProbe(g *graph.Graph, host *graph.Node, interval time.Duration) *MembershipProbe {
+	return &MembershipProbe{
+		graph:    g,
+		host:     host,
+		interval: interval,
+		quit:     make(chan bool),
+	}
+}
+
+// NewMembershipProbeFromConfig creates a new multicast membership probe based on configuration
+func NewMembershipProbeFromConfig(g *graph.Graph, host *graph.Node) (*MembershipProbe, error) {
+	interval := time.Duration(config.GetInt("agent.topology.multicast.interval")) * time.Second
+	if interval <= 0 {
+		return nil, errors.New("agent.topology.multicast.interval must be a positive number of seconds")
+	}
+	return NewMembershipProbe(g, host, interval), nil
+}
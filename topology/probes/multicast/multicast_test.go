@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package multicast
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const procIGMP = `Idx	Device    : Count Querier	Group    Users Timer	Reporter
+1	lo        :     1      V3
+				010000E0     1 0:00000000		0
+2	eth0      :     3      V3
+				FAFFFFEF     1 0:00000000		0
+				010101EF     2 0:00000000		0
+				010000E0     1 0:00000000		0
+3	eth1      :     1      V2
+				010101EF     1 0:00000000		0
+`
+
+const procIGMP6 = `1    lo              ff020000000000000000000000000001     1 0000000C 0
+2    eth0            ff020000000000000000000000000001     1 0000000C 0
+2    eth0            ff0e0000000000000000000000000101     1 00000004 0
+2    eth0            ff05000000000000000000000000abcd     2 00000004 0
+3    eth1            zz05000000000000000000000000abcd     1 00000004 0
+`
+
+func TestParseMemberships(t *testing.T) {
+	g := make(groups)
+	if err := parseIGMP(strings.NewReader(procIGMP), g); err != nil {
+		t.Fatal(err)
+	}
+	if err := parseIGMP6(strings.NewReader(procIGMP6), g); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := groups{
+		"eth0": {"239.1.1.1", "239.255.255.250", "ff05::abcd", "ff0e::101"},
+		"eth1": {"239.1.1.1"},
+	}
+	if !reflect.DeepEqual(g, expected) {
+		t.Errorf("Expected %v, got %v", expected, g)
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+
+	probe := NewDistributionProbe(g)
+	defer probe.Stop()
+
+	g.Lock()
+	defer g.Unlock()
+
+	groupNode := func(group string) *graph.Node {
+		return g.GetNode(graph.GenIDNameBased(managerValue, group))
+	}
+
+	multicast := func(groups ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"Groups": groups}
+	}
+
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "Multicast": multicast("239.1.1.1", "ff0e::101")})
+	eth1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth1", "Multicast": multicast("239.1.1.1")})
+
+	group := groupNode("239.1.1.1")
+	if group == nil {
+		t.Fatal("Group node 239.1.1.1 not created")
+	}
+	if typ, _ := group.GetFieldString("Type"); typ != "multicastgroup" {
+		t.Errorf("Wrong group node type: %s", typ)
+	}
+	if !g.AreLinked(group, eth0, edgeMetadata) || !g.AreLinked(group, eth1, edgeMetadata) {
+		t.Error("Members not linked to their group")
+	}
+	if groupNode("ff0e::101") == nil {
+		t.Error("Group node ff0e::101 not created")
+	}
+
+	g.AddMetadata(eth0, "Multicast", multicast("239.1.1.1"))
+	if groupNode("ff0e::101") != nil {
+		t.Error("Group node ff0e::101 should be removed once left")
+	}
+
+	g.DelMetadata(eth1, "Multicast")
+	if g.AreLinked(group, eth1, edgeMetadata) {
+		t.Error("eth1 should not be linked anymore to its group")
+	}
+
+	g.DelNode(eth0)
+	if groupNode("239.1.1.1") != nil {
+		t.Error("Group node 239.1.1.1 should be removed without members")
+	}
+}
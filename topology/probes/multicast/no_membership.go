@@ -0,0 +1,47 @@
+// +build !linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package multicast
+
+import (
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// MembershipProbe describes a probe reading the IGMP and MLD memberships
+type MembershipProbe struct {
+}
+
+// Start the probe
+func (p *MembershipProbe) Start() {
+}
+
+// Stop the probe
+func (p *MembershipProbe) Stop() {
+}
+
+// NewMembershipProbeFromConfig creates a new multicast membership probe based on configuration
+func NewMembershipProbeFromConfig(g *graph.Graph, host *graph.Node) (*MembershipProbe, error) {
+	return nil, common.ErrNotImplemented
+}
@@ -29,6 +29,7 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -41,6 +42,7 @@ import (
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe/replay"
 	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/topology/graph"
 )
@@ -64,6 +66,27 @@ type neighbor struct {
 	IfIndex int64
 }
 
+// netlinkHandle is the part of the netlink handle used by the probe, the
+// replay implements it from the replayed messages
+type netlinkHandle interface {
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+	LinkByIndex(index int) (netlink.Link, error)
+	LinkByName(name string) (netlink.Link, error)
+	LinkList() ([]netlink.Link, error)
+	NeighList(linkIndex, family int) ([]netlink.Neigh, error)
+	RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error)
+	Delete()
+}
+
+// ethtoolHandle is the part of ethtool used by the probe
+type ethtoolHandle interface {
+	DriverName(intf string) (string, error)
+	CmdGet(ecmd *ethtool.EthtoolCmd, intf string) (uint32, error)
+	Stats(intf string) (map[string]uint64, error)
+	Features(intf string) (map[string]bool, error)
+	Close()
+}
+
 // NetNsNetLinkProbe describes a topology probe based on netlink in a network namespace
 type NetNsNetLinkProbe struct {
 	common.RWMutex
@@ -71,8 +94,8 @@ type NetNsNetLinkProbe struct {
 	Root                 *graph.Node
 	NsPath               string
 	epollFd              int
-	ethtool              *ethtool.Ethtool
-	handle               *netlink.Handle
+	ethtool              ethtoolHandle
+	handle               netlinkHandle
 	socket               *nl.NetlinkSocket
 	indexToChildrenQueue map[int64][]pendingLink
 	links                map[string]*graph.Node
@@ -865,6 +888,32 @@ func (u *NetNsNetLinkProbe) updateIntfFeatures() {
 	}
 }
 
+func (u *NetNsNetLinkProbe) updateIntfFDB() {
+	for _, node := range u.cloneLinkNodes() {
+		u.Graph.RLock()
+		index, err := node.GetFieldInt64("IfIndex")
+		u.Graph.RUnlock()
+
+		if err != nil {
+			continue
+		}
+
+		neighbors := u.getNeighbors(int(index), syscall.AF_BRIDGE)
+
+		u.Graph.Lock()
+		if fdb, err := node.GetField("FDB"); err == nil {
+			if len(neighbors) == 0 {
+				u.Graph.DelMetadata(node, "FDB")
+			} else if !reflect.DeepEqual(fdb, neighbors) {
+				u.Graph.AddMetadata(node, "FDB", neighbors)
+			}
+		} else if len(neighbors) > 0 {
+			u.Graph.AddMetadata(node, "FDB", neighbors)
+		}
+		u.Graph.Unlock()
+	}
+}
+
 func (u *NetNsNetLinkProbe) start(nlProbe *NetLinkProbe) {
 	u.wg.Add(1)
 	defer u.wg.Done()
@@ -901,11 +950,21 @@ Ready:
 	featureTicker := time.NewTicker(5 * time.Second)
 	defer featureTicker.Stop()
 
+	// the FDB entries are not notified when learned, refresh them periodically
+	var fdbUpdate <-chan time.Time
+	if seconds := config.GetInt("agent.topology.netlink.fdb_update"); seconds > 0 {
+		fdbTicker := time.NewTicker(time.Duration(seconds) * time.Second)
+		defer fdbTicker.Stop()
+		fdbUpdate = fdbTicker.C
+	}
+
 	last := time.Now().UTC()
 	for {
 		select {
 		case <-featureTicker.C:
 			u.updateIntfFeatures()
+		case <-fdbUpdate:
+			u.updateIntfFDB()
 		case t := <-metricTicker.C:
 			now := t.UTC()
 			u.updateIntfMetric(now, last)
@@ -925,6 +984,12 @@ func (u *NetNsNetLinkProbe) onMessageAvailable() {
 		return
 	}
 
+	replay.Record(ReplaySource, u.NsPath, msgs)
+
+	u.handleMessages(msgs)
+}
+
+func (u *NetNsNetLinkProbe) handleMessages(msgs []syscall.NetlinkMessage) {
 	for _, msg := range msgs {
 		switch msg.Header.Type {
 		case syscall.RTM_NEWLINK:
@@ -1020,17 +1085,27 @@ func newNetNsNetLinkProbe(g *graph.Graph, root *graph.Node, nsPath string) (*Net
 	}
 
 	// Both NewHandle and Subscribe need to done in the network namespace.
-	if probe.handle, err = netlink.NewHandle(syscall.NETLINK_ROUTE); err != nil {
+	handle, err := netlink.NewHandle(syscall.NETLINK_ROUTE)
+	if err != nil {
 		return errFnc(fmt.Errorf("Failed to create netlink handle: %s", err))
 	}
+	probe.handle = handle
 
 	if probe.socket, err = nl.Subscribe(syscall.NETLINK_ROUTE, syscall.RTNLGRP_LINK, syscall.RTNLGRP_IPV4_IFADDR, syscall.RTNLGRP_IPV6_IFADDR, syscall.RTNLGRP_IPV4_MROUTE, syscall.RTNLGRP_IPV4_ROUTE, syscall.RTNLGRP_IPV6_MROUTE, syscall.RTNLGRP_IPV6_ROUTE); err != nil {
 		return errFnc(fmt.Errorf("Failed to subscribe to netlink messages: %s", err))
 	}
 
-	if probe.ethtool, err = ethtool.NewEthtool(); err != nil {
+	et, err := ethtool.NewEthtool()
+	if err != nil {
 		return errFnc(fmt.Errorf("Failed to create ethtool object: %s", err))
 	}
+	probe.ethtool = et
+
+	// the trace starts with the current state so that it can be replayed
+	// without the live namespace
+	if replay.Recording() {
+		recordInitialState(nsPath)
+	}
 
 	if probe.epollFd, err = syscall.EpollCreate1(0); err != nil {
 		return errFnc(fmt.Errorf("Failed to create epoll: %s", err))
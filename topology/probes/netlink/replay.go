@@ -0,0 +1,242 @@
+// +build linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netlink
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"syscall"
+
+	"github.com/safchain/ethtool"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe/replay"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// ReplaySource is the source of the netlink events in the replay traces
+const ReplaySource = "netlink"
+
+var errReplayNotFound = errors.New("Not found in the replayed messages")
+
+// replayHandle answers the queries of the probe from the links and the
+// addresses of the replayed messages, neighbors and routes are not replayed
+type replayHandle struct {
+	links map[int]netlink.Link
+	addrs map[int][]netlink.Addr
+}
+
+func (h *replayHandle) AddrList(link netlink.Link, family int) (addrs []netlink.Addr, err error) {
+	for _, addr := range h.addrs[link.Attrs().Index] {
+		isV4 := addr.IP.To4() != nil
+		if family == netlink.FAMILY_ALL || (family == netlink.FAMILY_V4) == isV4 {
+			addrs = append(addrs, addr)
+		}
+	}
+	return
+}
+
+func (h *replayHandle) LinkByIndex(index int) (netlink.Link, error) {
+	if link, ok := h.links[index]; ok {
+		return link, nil
+	}
+	return nil, errReplayNotFound
+}
+
+func (h *replayHandle) LinkByName(name string) (netlink.Link, error) {
+	for _, link := range h.links {
+		if link.Attrs().Name == name {
+			return link, nil
+		}
+	}
+	return nil, errReplayNotFound
+}
+
+func (h *replayHandle) LinkList() ([]netlink.Link, error) {
+	var indexes []int
+	for index := range h.links {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	var links []netlink.Link
+	for _, index := range indexes {
+		links = append(links, h.links[index])
+	}
+	return links, nil
+}
+
+func (h *replayHandle) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	return nil, nil
+}
+
+func (h *replayHandle) RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error) {
+	return nil, nil
+}
+
+func (h *replayHandle) Delete() {
+}
+
+func (h *replayHandle) delAddr(index int, addr netlink.Addr) {
+	addrs := h.addrs[index][:0]
+	for _, a := range h.addrs[index] {
+		if a.IPNet.String() != addr.IPNet.String() {
+			addrs = append(addrs, a)
+		}
+	}
+	h.addrs[index] = addrs
+}
+
+// apply updates the state with a message before the probe handles it
+func (h *replayHandle) apply(msg syscall.NetlinkMessage) {
+	switch msg.Header.Type {
+	case syscall.RTM_NEWLINK:
+		if link, err := netlink.LinkDeserialize(nil, msg.Data); err == nil {
+			h.links[link.Attrs().Index] = link
+		}
+	case syscall.RTM_DELLINK:
+		// the bridge family notifies the removal from a bridge, the
+		// link is still there
+		if nl.DeserializeIfInfomsg(msg.Data).Family == syscall.AF_BRIDGE {
+			return
+		}
+		if link, err := netlink.LinkDeserialize(nil, msg.Data); err == nil {
+			delete(h.links, link.Attrs().Index)
+			delete(h.addrs, link.Attrs().Index)
+		}
+	case syscall.RTM_NEWADDR:
+		if addr, _, index, err := parseAddr(msg.Data); err == nil && addr.IPNet != nil {
+			h.delAddr(index, addr)
+			h.addrs[index] = append(h.addrs[index], addr)
+		}
+	case syscall.RTM_DELADDR:
+		if addr, _, index, err := parseAddr(msg.Data); err == nil && addr.IPNet != nil {
+			h.delAddr(index, addr)
+		}
+	}
+}
+
+// replayEthtool reports the interfaces as unknown to ethtool
+type replayEthtool struct {
+}
+
+func (e replayEthtool) DriverName(intf string) (string, error) {
+	return "", syscall.ENODEV
+}
+
+func (e replayEthtool) CmdGet(ecmd *ethtool.EthtoolCmd, intf string) (uint32, error) {
+	return 0, syscall.ENODEV
+}
+
+func (e replayEthtool) Stats(intf string) (map[string]uint64, error) {
+	return nil, syscall.ENODEV
+}
+
+func (e replayEthtool) Features(intf string) (map[string]bool, error) {
+	return nil, syscall.ENODEV
+}
+
+func (e replayEthtool) Close() {
+}
+
+// recordInitialState records the links and the addresses of the current
+// namespace as the messages announcing them
+func recordInitialState(nsPath string) {
+	var msgs []syscall.NetlinkMessage
+	for _, dump := range []struct{ req, res uint16 }{
+		{syscall.RTM_GETLINK, syscall.RTM_NEWLINK},
+		{syscall.RTM_GETADDR, syscall.RTM_NEWADDR},
+	} {
+		req := nl.NewNetlinkRequest(int(dump.req), syscall.NLM_F_DUMP)
+		req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+
+		data, err := req.Execute(syscall.NETLINK_ROUTE, dump.res)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to record the netlink state of %s: %s", nsPath, err)
+			return
+		}
+
+		for _, d := range data {
+			msgs = append(msgs, syscall.NetlinkMessage{
+				Header: syscall.NlMsghdr{Len: uint32(syscall.NLMSG_HDRLEN + len(d)), Type: dump.res},
+				Data:   d,
+			})
+		}
+	}
+
+	replay.Record(ReplaySource, nsPath, msgs)
+}
+
+// NewReplayNetNsNetLinkProbe creates a probe for the namespace nsPath only
+// fed by replayed messages, the links and the addresses are taken from the
+// messages while the neighbors, the routes and the ethtool informations are
+// left empty. It is not meant to be started nor stopped.
+func NewReplayNetNsNetLinkProbe(g *graph.Graph, root *graph.Node, nsPath string) *NetNsNetLinkProbe {
+	return &NetNsNetLinkProbe{
+		Graph:                g,
+		Root:                 root,
+		NsPath:               nsPath,
+		ethtool:              replayEthtool{},
+		handle:               &replayHandle{links: make(map[int]netlink.Link), addrs: make(map[int][]netlink.Addr)},
+		indexToChildrenQueue: make(map[int64][]pendingLink),
+		links:                make(map[string]*graph.Node),
+		state:                common.RunningState,
+		quit:                 make(chan bool),
+	}
+}
+
+// Replay handles the messages one at a time in order, as if they were
+// received from the kernel
+func (u *NetNsNetLinkProbe) Replay(msgs []syscall.NetlinkMessage) {
+	h, isReplay := u.handle.(*replayHandle)
+	for _, msg := range msgs {
+		if isReplay {
+			h.apply(msg)
+		}
+		u.handleMessages([]syscall.NetlinkMessage{msg})
+	}
+}
+
+// ReplayTrace replays the netlink events of a trace recorded from the
+// namespace of the probe
+func (u *NetNsNetLinkProbe) ReplayTrace(events []replay.Event) error {
+	for _, event := range events {
+		if event.Source != ReplaySource || event.Key != u.NsPath {
+			continue
+		}
+
+		var msgs []syscall.NetlinkMessage
+		if err := json.Unmarshal(event.Data, &msgs); err != nil {
+			return err
+		}
+		u.Replay(msgs)
+	}
+
+	return nil
+}
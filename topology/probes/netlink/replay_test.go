@@ -0,0 +1,99 @@
+// +build linux
+
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netlink
+
+import (
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func newLinkMessage(msgType uint16, index int, name string) syscall.NetlinkMessage {
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	msg.Flags = syscall.IFF_UP
+
+	data := msg.Serialize()
+	data = append(data, nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(name)).Serialize()...)
+
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: msgType}, Data: data}
+}
+
+func newAddrMessage(msgType uint16, index int, ip net.IP, prefixLen int) syscall.NetlinkMessage {
+	msg := nl.NewIfAddrmsg(syscall.AF_INET)
+	msg.Index = uint32(index)
+	msg.Prefixlen = uint8(prefixLen)
+
+	data := msg.Serialize()
+	data = append(data, nl.NewRtAttr(syscall.IFA_LOCAL, ip.To4()).Serialize()...)
+
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: msgType}, Data: data}
+}
+
+func TestReplay(t *testing.T) {
+	backend, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("test", backend)
+
+	g.Lock()
+	root := g.NewNode(graph.GenID(), graph.Metadata{"Name": "host", "Type": "host"})
+	g.Unlock()
+
+	probe := NewReplayNetNsNetLinkProbe(g, root, "")
+	probe.Replay([]syscall.NetlinkMessage{
+		newLinkMessage(syscall.RTM_NEWLINK, 2, "eth0"),
+		newAddrMessage(syscall.RTM_NEWADDR, 2, net.IP{192, 0, 2, 1}, 24),
+	})
+
+	g.RLock()
+	intf := g.LookupFirstChild(root, graph.Metadata{"Name": "eth0"})
+	if intf == nil {
+		t.Fatal("Interface not replayed")
+	}
+
+	if state, _ := intf.GetFieldString("State"); state != "UP" {
+		t.Errorf("Expected eth0 UP, got %s", state)
+	}
+
+	if ips, _ := intf.GetField("IPV4"); !reflect.DeepEqual(ips, []string{"192.0.2.1/24"}) {
+		t.Errorf("Wrong addresses replayed: %v", ips)
+	}
+	g.RUnlock()
+
+	probe.Replay([]syscall.NetlinkMessage{newLinkMessage(syscall.RTM_DELLINK, 2, "eth0")})
+
+	g.RLock()
+	if g.LookupFirstChild(root, graph.Metadata{"Name": "eth0"}) != nil {
+		t.Error("Interface deletion not replayed")
+	}
+	g.RUnlock()
+}
@@ -68,6 +68,19 @@ type attributes struct {
 	IPV4        []string
 	IPV6        []string
 	VNI         string
+	DNSName     string
+	DNSFQDN     []string
+}
+
+// portWithDNS is a port with the attributes of the DNS integration extension
+type portWithDNS struct {
+	ports.Port
+	DNSName       string `json:"dns_name"`
+	DNSAssignment []struct {
+		Hostname  string `json:"hostname"`
+		IPAddress string `json:"ip_address"`
+		FQDN      string `json:"fqdn"`
+	} `json:"dns_assignment"`
 }
 
 // portMetadata neutron metadata
@@ -102,7 +115,7 @@ func retrieveportMetadata(node *graph.Node) portMetadata {
 	return md
 }
 
-func (mapper *NeutronProbe) retrievePort(portMd portMetadata) (port ports.Port, err error) {
+func (mapper *NeutronProbe) retrievePort(portMd portMetadata) (port portWithDNS, err error) {
 	var opts ports.ListOpts
 
 	logging.GetLogger().Debugf("Retrieving attributes from Neutron for MAC: %s", portMd.mac)
@@ -118,10 +131,13 @@ func (mapper *NeutronProbe) retrievePort(portMd portMetadata) (port ports.Port,
 	pager := ports.List(mapper.client, opts)
 
 	err = pager.EachPage(func(page pagination.Page) (bool, error) {
-		portList, err := ports.ExtractPorts(page)
-		if err != nil {
+		var result struct {
+			Ports []portWithDNS `json:"ports"`
+		}
+		if err := page.(ports.PortPage).ExtractInto(&result); err != nil {
 			return false, err
 		}
+		portList := result.Ports
 
 		for _, p := range portList {
 			if p.MACAddress == portMd.mac {
@@ -185,6 +201,13 @@ func (mapper *NeutronProbe) retrieveAttributes(portMd portMetadata) (*attributes
 		IPV4:        IPV4,
 		IPV6:        IPV6,
 		VNI:         network.SegmentationID,
+		DNSName:     port.DNSName,
+	}
+
+	for _, assignment := range port.DNSAssignment {
+		if assignment.FQDN != "" {
+			a.DNSFQDN = append(a.DNSFQDN, assignment.FQDN)
+		}
 	}
 
 	return a, nil
@@ -254,6 +277,14 @@ func (mapper *NeutronProbe) updateNode(node *graph.Node, attrs *attributes) {
 		metadata["Neutron.IPV6"] = attrs.IPV6
 	}
 
+	if attrs.DNSName != "" {
+		metadata["Neutron.DNSName"] = attrs.DNSName
+	}
+
+	if len(attrs.DNSFQDN) != 0 {
+		metadata["Neutron.DNSFQDN"] = attrs.DNSFQDN
+	}
+
 	if segID, err := strconv.Atoi(attrs.VNI); err != nil && segID > 0 {
 		metadata["Neutron.VNI"] = int64(segID)
 	}
@@ -0,0 +1,533 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package nova
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	managerValue       = "nova"
+	ironicManagerValue = "ironic"
+	// ironicAPIVersion is the minimal version of the Ironic API reporting
+	// the names of the nodes
+	ironicAPIVersion = "1.5"
+)
+
+var (
+	hypervisorEdgeMetadata = graph.Metadata{"RelationType": "association", "Manager": managerValue, "Type": "hypervisor"}
+	portEdgeMetadata       = graph.Metadata{"RelationType": "association", "Manager": managerValue, "Type": "port"}
+	instanceEdgeMetadata   = graph.Metadata{"RelationType": "association", "Manager": ironicManagerValue, "Type": "instance"}
+)
+
+// NovaProbe describes a probe importing the Nova servers, and optionally the
+// Ironic bare-metal nodes, in the graph. The servers are linked to the host
+// of their hypervisor and to the interfaces of their ports, the bare-metal
+// nodes to the server they are provisioned for.
+type NovaProbe struct {
+	graph        *graph.Graph
+	opts         gophercloud.AuthOptions
+	regionName   string
+	availability gophercloud.Availability
+	ironic       bool
+	interval     time.Duration
+	compute      *gophercloud.ServiceClient
+	baremetal    *gophercloud.ServiceClient
+	servers      map[string]*graph.Node
+	nodes        map[string]*graph.Node
+	quit         chan bool
+	wg           sync.WaitGroup
+}
+
+type link struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+type flavor struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	VCPUs int    `json:"vcpus"`
+	RAM   int    `json:"ram"`
+	Disk  int    `json:"disk"`
+}
+
+type server struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	TenantID string `json:"tenant_id"`
+	Flavor   struct {
+		ID string `json:"id"`
+	} `json:"flavor"`
+	AvailabilityZone string `json:"OS-EXT-AZ:availability_zone"`
+	Host             string `json:"OS-EXT-SRV-ATTR:host"`
+	Hypervisor       string `json:"OS-EXT-SRV-ATTR:hypervisor_hostname"`
+	InstanceName     string `json:"OS-EXT-SRV-ATTR:instance_name"`
+	Addresses        map[string][]struct {
+		Addr string `json:"addr"`
+		MAC  string `json:"OS-EXT-IPS-MAC:mac_addr"`
+	} `json:"addresses"`
+}
+
+type baremetalNode struct {
+	UUID           string                 `json:"uuid"`
+	Name           string                 `json:"name"`
+	InstanceUUID   string                 `json:"instance_uuid"`
+	PowerState     string                 `json:"power_state"`
+	ProvisionState string                 `json:"provision_state"`
+	Maintenance    bool                   `json:"maintenance"`
+	Driver         string                 `json:"driver"`
+	Properties     map[string]interface{} `json:"properties"`
+}
+
+func nextLink(links []link) string {
+	for _, l := range links {
+		if l.Rel == "next" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+func (p *NovaProbe) connect() error {
+	if p.compute != nil {
+		return nil
+	}
+
+	provider, err := openstack.AuthenticatedClient(p.opts)
+	if err != nil {
+		return fmt.Errorf("keystone authentication error: %s", err)
+	}
+
+	eo := gophercloud.EndpointOpts{
+		Region:       p.regionName,
+		Availability: p.availability,
+	}
+
+	compute, err := openstack.NewComputeV2(provider, eo)
+	if err != nil {
+		return fmt.Errorf("Unable to find the Nova endpoint: %s", err)
+	}
+
+	if p.ironic {
+		eo.ApplyDefaults("baremetal")
+		url, err := provider.EndpointLocator(eo)
+		if err != nil {
+			return fmt.Errorf("Unable to find the Ironic endpoint: %s", err)
+		}
+		p.baremetal = &gophercloud.ServiceClient{ProviderClient: provider, Endpoint: url}
+	}
+	p.compute = compute
+
+	return nil
+}
+
+func (p *NovaProbe) flavors() (map[string]flavor, error) {
+	var result struct {
+		Flavors []flavor `json:"flavors"`
+	}
+	if _, err := p.compute.Get(p.compute.ServiceURL("flavors", "detail")+"?is_public=None", &result, nil); err != nil {
+		return nil, err
+	}
+
+	flavors := make(map[string]flavor)
+	for _, f := range result.Flavors {
+		flavors[f.ID] = f
+	}
+	return flavors, nil
+}
+
+func (p *NovaProbe) listServers() (servers []server, err error) {
+	url := p.compute.ServiceURL("servers", "detail") + "?all_tenants=True"
+	for url != "" {
+		var page struct {
+			Servers []server `json:"servers"`
+			Links   []link   `json:"servers_links"`
+		}
+		if _, err := p.compute.Get(url, &page, nil); err != nil {
+			return nil, err
+		}
+		servers = append(servers, page.Servers...)
+		url = nextLink(page.Links)
+	}
+	return
+}
+
+func (p *NovaProbe) listBaremetalNodes() (nodes []baremetalNode, err error) {
+	opts := &gophercloud.RequestOpts{
+		MoreHeaders: map[string]string{"X-OpenStack-Ironic-API-Version": ironicAPIVersion},
+	}
+
+	url := p.baremetal.ServiceURL("v1", "nodes", "detail")
+	for url != "" {
+		var page struct {
+			Nodes []baremetalNode `json:"nodes"`
+			Next  string          `json:"next"`
+		}
+		if _, err := p.baremetal.Get(url, &page, opts); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, page.Nodes...)
+		url = page.Next
+	}
+	return
+}
+
+// serverMetadata returns the Nova metadata of a server, with its flavor
+func serverMetadata(s *server, flavors map[string]flavor) map[string]interface{} {
+	m := map[string]interface{}{
+		"ID":     s.ID,
+		"Status": s.Status,
+	}
+
+	for key, value := range map[string]string{
+		"TenantID":         s.TenantID,
+		"AvailabilityZone": s.AvailabilityZone,
+		"Host":             s.Host,
+		"Hypervisor":       s.Hypervisor,
+		"InstanceName":     s.InstanceName,
+	} {
+		if value != "" {
+			m[key] = value
+		}
+	}
+
+	if s.Flavor.ID != "" {
+		fm := map[string]interface{}{"ID": s.Flavor.ID}
+		if f, found := flavors[s.Flavor.ID]; found {
+			fm["Name"] = f.Name
+			fm["VCPUs"] = int64(f.VCPUs)
+			fm["RAM"] = int64(f.RAM)
+			fm["Disk"] = int64(f.Disk)
+		}
+		m["Flavor"] = fm
+	}
+
+	var ips, macs []interface{}
+	seenMACs := make(map[string]bool)
+	for _, addresses := range s.Addresses {
+		for _, address := range addresses {
+			ips = append(ips, address.Addr)
+			if address.MAC != "" && !seenMACs[address.MAC] {
+				seenMACs[address.MAC] = true
+				macs = append(macs, address.MAC)
+			}
+		}
+	}
+	if len(ips) > 0 {
+		m["IPs"] = ips
+	}
+	if len(macs) > 0 {
+		m["MACs"] = macs
+	}
+
+	return m
+}
+
+// baremetalMetadata returns the Ironic metadata of a bare-metal node
+func baremetalMetadata(n *baremetalNode) map[string]interface{} {
+	m := map[string]interface{}{
+		"UUID":        n.UUID,
+		"Maintenance": n.Maintenance,
+	}
+
+	for key, value := range map[string]string{
+		"InstanceUUID":   n.InstanceUUID,
+		"PowerState":     n.PowerState,
+		"ProvisionState": n.ProvisionState,
+		"Driver":         n.Driver,
+	} {
+		if value != "" {
+			m[key] = value
+		}
+	}
+
+	if len(n.Properties) > 0 {
+		m["Properties"] = n.Properties
+	}
+
+	return m
+}
+
+// hypervisorHost returns the host node of the hypervisor of a server, known
+// by its short or fully qualified name
+func (p *NovaProbe) hypervisorHost(s *server) *graph.Node {
+	for _, name := range []string{s.Hypervisor, s.Host} {
+		if name == "" {
+			continue
+		}
+
+		candidates := []string{name}
+		if i := strings.Index(name, "."); i != -1 {
+			candidates = append(candidates, name[:i])
+		}
+
+		for _, candidate := range candidates {
+			if host := p.graph.LookupFirstNode(graph.Metadata{"Type": "host", "Name": candidate}); host != nil {
+				return host
+			}
+		}
+	}
+	return nil
+}
+
+// serverInterfaces returns the interfaces attached to the servers, by
+// server, the ones reported by the agents with the UUID of their server
+func (p *NovaProbe) serverInterfaces() map[string][]*graph.Node {
+	interfaces := make(map[string][]*graph.Node)
+
+	filter := graph.NewGraphElementFilter(filters.NewNotNullFilter("ExtID.vm-uuid"))
+	for _, n := range p.graph.GetNodes(filter) {
+		if uuid, _ := n.GetFieldString("ExtID.vm-uuid"); uuid != "" {
+			interfaces[uuid] = append(interfaces[uuid], n)
+		}
+	}
+	return interfaces
+}
+
+// syncEdges links the node to the targets with the edge metadata, removing
+// the edges to the previous targets
+func (p *NovaProbe) syncEdges(node *graph.Node, targets []*graph.Node, metadata graph.Metadata) {
+	children := make(map[graph.Identifier]*graph.Node)
+	for _, target := range targets {
+		children[target.ID] = target
+	}
+
+	for _, e := range p.graph.GetNodeEdges(node, metadata) {
+		if e.GetParent() != node.ID {
+			continue
+		}
+		if _, found := children[e.GetChild()]; found {
+			delete(children, e.GetChild())
+		} else {
+			p.graph.DelEdge(e)
+		}
+	}
+
+	for _, child := range children {
+		p.graph.Link(node, child, metadata.Clone(), "")
+	}
+}
+
+// syncNode creates or updates the node of an OpenStack resource
+func (p *NovaProbe) syncNode(nodes map[string]*graph.Node, manager, id, name, nodeType, key string, m map[string]interface{}) *graph.Node {
+	node, found := nodes[id]
+	if !found {
+		node = p.graph.NewNode(graph.GenIDNameBased(manager, id), graph.Metadata{
+			"Manager": manager,
+			"Type":    nodeType,
+			"Name":    name,
+			key:       m,
+		}, "")
+		nodes[id] = node
+		return node
+	}
+
+	if current, _ := node.GetFieldString("Name"); current != name {
+		p.graph.AddMetadata(node, "Name", name)
+	}
+	if current, _ := node.GetField(key); !reflect.DeepEqual(current, m) {
+		p.graph.AddMetadata(node, key, m)
+	}
+	return node
+}
+
+func deleteUnseen(g *graph.Graph, nodes map[string]*graph.Node, seen map[string]bool) {
+	for id, node := range nodes {
+		if !seen[id] {
+			g.DelNode(node)
+			delete(nodes, id)
+		}
+	}
+}
+
+func (p *NovaProbe) apply(servers []server, flavors map[string]flavor, baremetalNodes []baremetalNode) {
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	interfaces := p.serverInterfaces()
+
+	seen := make(map[string]bool)
+	for i := range servers {
+		s := &servers[i]
+		seen[s.ID] = true
+
+		node := p.syncNode(p.servers, managerValue, s.ID, s.Name, "instance", "Nova", serverMetadata(s, flavors))
+
+		var hosts []*graph.Node
+		if host := p.hypervisorHost(s); host != nil {
+			hosts = append(hosts, host)
+		}
+		p.syncEdges(node, hosts, hypervisorEdgeMetadata)
+		p.syncEdges(node, interfaces[s.ID], portEdgeMetadata)
+	}
+	deleteUnseen(p.graph, p.servers, seen)
+
+	seen = make(map[string]bool)
+	for i := range baremetalNodes {
+		n := &baremetalNodes[i]
+		seen[n.UUID] = true
+
+		name := n.Name
+		if name == "" {
+			name = n.UUID
+		}
+
+		node := p.syncNode(p.nodes, ironicManagerValue, n.UUID, name, "baremetal", "Ironic", baremetalMetadata(n))
+
+		var instances []*graph.Node
+		if instance, found := p.servers[n.InstanceUUID]; found {
+			instances = append(instances, instance)
+		}
+		p.syncEdges(node, instances, instanceEdgeMetadata)
+	}
+	deleteUnseen(p.graph, p.nodes, seen)
+}
+
+func (p *NovaProbe) poll() error {
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	flavors, err := p.flavors()
+	if err != nil {
+		return fmt.Errorf("Unable to list the Nova flavors: %s", err)
+	}
+
+	servers, err := p.listServers()
+	if err != nil {
+		return fmt.Errorf("Unable to list the Nova servers: %s", err)
+	}
+
+	var baremetalNodes []baremetalNode
+	if p.baremetal != nil {
+		if baremetalNodes, err = p.listBaremetalNodes(); err != nil {
+			return fmt.Errorf("Unable to list the Ironic nodes: %s", err)
+		}
+	}
+
+	p.apply(servers, flavors, baremetalNodes)
+
+	return nil
+}
+
+func (p *NovaProbe) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.poll(); err != nil {
+			logging.GetLogger().Errorf("Failed to import the Nova inventory: %s", err)
+			probe.ReportError(managerValue, err)
+		} else {
+			probe.ReportRecovery(managerValue)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Start the probe
+func (p *NovaProbe) Start() {
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop the probe
+func (p *NovaProbe) Stop() {
+	p.quit <- true
+	p.wg.Wait()
+}
+
+// NewNovaProbe creates a new Nova probe, importing the Ironic nodes too when
+// ironic is true
+func NewNovaProbe(g *graph.Graph, authURL, username, password, tenantName, regionName, domainName string, availability gophercloud.Availability, ironic bool, interval time.Duration) *NovaProbe {
+	return &NovaProbe{
+		graph: g,
+		opts: gophercloud.AuthOptions{
+			IdentityEndpoint: authURL,
+			Username:         username,
+			Password:         password,
+			TenantName:       tenantName,
+			DomainName:       domainName,
+			AllowReauth:      true,
+		},
+		regionName:   regionName,
+		availability: availability,
+		ironic:       ironic,
+		interval:     interval,
+		servers:      make(map[string]*graph.Node),
+		nodes:        make(map[string]*graph.Node),
+		quit:         make(chan bool),
+	}
+}
+
+// NewNovaProbeFromConfig creates a new Nova probe based on configuration
+func NewNovaProbeFromConfig(g *graph.Graph) (*NovaProbe, error) {
+	authURL := config.GetString("analyzer.topology.nova.auth_url")
+	domainName := config.GetString("analyzer.topology.nova.domain_name")
+	endpointType := config.GetString("analyzer.topology.nova.endpoint_type")
+	password := config.GetString("analyzer.topology.nova.password")
+	regionName := config.GetString("analyzer.topology.nova.region_name")
+	tenantName := config.GetString("analyzer.topology.nova.tenant_name")
+	username := config.GetString("analyzer.topology.nova.username")
+	ironic := config.GetBool("analyzer.topology.nova.ironic")
+
+	interval := time.Duration(config.GetInt("analyzer.topology.nova.interval")) * time.Second
+	if interval <= 0 {
+		return nil, errors.New("analyzer.topology.nova.interval must be a positive number of seconds")
+	}
+
+	endpointTypes := map[string]gophercloud.Availability{
+		"public":   gophercloud.AvailabilityPublic,
+		"admin":    gophercloud.AvailabilityAdmin,
+		"internal": gophercloud.AvailabilityInternal,
+	}
+
+	availability, ok := endpointTypes[endpointType]
+	if !ok {
+		return nil, fmt.Errorf("Endpoint type '%s' is not valid (must be 'public', 'admin' or 'internal')", endpointType)
+	}
+
+	return NewNovaProbe(g, authURL, username, password, tenantName, regionName, domainName, availability, ironic, interval), nil
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package nova
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func newTestProbe(t *testing.T) *NovaProbe {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+
+	return NewNovaProbe(g, "", "", "", "", "", "", "", true, 0)
+}
+
+func TestServerMetadata(t *testing.T) {
+	s := &server{ID: "vm1", Status: "ACTIVE", AvailabilityZone: "nova"}
+	s.Flavor.ID = "1"
+
+	m := serverMetadata(s, map[string]flavor{"1": {ID: "1", Name: "m1.tiny", VCPUs: 1, RAM: 512, Disk: 1}})
+	if m["AvailabilityZone"] != "nova" {
+		t.Errorf("Availability zone not reported: %v", m)
+	}
+
+	f, ok := m["Flavor"].(map[string]interface{})
+	if !ok || f["Name"] != "m1.tiny" || f["RAM"] != int64(512) {
+		t.Errorf("Flavor not reported: %v", m)
+	}
+
+	if _, found := m["Host"]; found {
+		t.Errorf("Empty fields should not be reported: %v", m)
+	}
+}
+
+func TestApply(t *testing.T) {
+	p := newTestProbe(t)
+	g := p.graph
+
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "compute1"})
+	tap := g.NewNode(graph.GenID(), graph.Metadata{"Type": "tun", "Name": "tap1", "ExtID": map[string]interface{}{"vm-uuid": "vm1"}})
+
+	s := server{ID: "vm1", Name: "instance1", Hypervisor: "compute1.example.com"}
+	n := baremetalNode{UUID: "bm1", InstanceUUID: "vm1"}
+
+	p.apply([]server{s}, nil, []baremetalNode{n})
+
+	instance := p.servers["vm1"]
+	if instance == nil {
+		t.Fatal("Server not imported")
+	}
+
+	if len(g.GetNodeEdges(instance, hypervisorEdgeMetadata)) != 1 || len(g.GetNodeEdges(host, hypervisorEdgeMetadata)) != 1 {
+		t.Error("Server not linked to its hypervisor")
+	}
+
+	if len(g.GetNodeEdges(tap, portEdgeMetadata)) != 1 {
+		t.Error("Server not linked to its interface")
+	}
+
+	baremetal := p.nodes["bm1"]
+	if baremetal == nil || len(g.GetNodeEdges(baremetal, instanceEdgeMetadata)) != 1 {
+		t.Error("Bare-metal node not linked to its instance")
+	}
+
+	p.apply(nil, nil, nil)
+
+	if g.GetNode(instance.ID) != nil || g.GetNode(baremetal.ID) != nil {
+		t.Error("Removed resources should be deleted")
+	}
+
+	if len(g.GetNodeEdges(tap, portEdgeMetadata)) != 0 {
+		t.Error("Edges of removed servers should be deleted")
+	}
+}
@@ -26,7 +26,6 @@ package opencontrail
 
 import (
 	"errors"
-	"fmt"
 	"strings"
 	"time"
 
@@ -105,7 +104,7 @@ func (mapper *OpenContrailProbe) retrieveMetadata(metadata graph.Metadata, itf c
 // contrail introspect with a delay between each attempt.
 func getInterfaceFromIntrospect(host string, port int, name string) (col collection.Collection, elem collection.Element, err error) {
 	getFromIntrospect := func() (err error) {
-		col, err = collection.LoadCollection(descriptions.Interface(), []string{fmt.Sprintf("%s:%d", host, port)})
+		col, err = collection.LoadCollection(descriptions.Interface(), []string{common.JoinHostPort(host, port)})
 		if err != nil {
 			return
 		}
@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package ovsdb
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// OvsFDBProbe periodically reports the MAC addresses learned by the Open
+// vSwitch bridges in the FDB metadata of the interfaces they have been
+// learned on, as the netlink probe does for the Linux bridges
+type OvsFDBProbe struct {
+	sync.Mutex
+	Graph    *graph.Graph
+	interval time.Duration
+	bridges  map[string]context.CancelFunc
+}
+
+// parseFDB parses the output of ovs-appctl fdb/show, returning the learned
+// entries by OpenFlow port, the entries of the local port being ignored
+func parseFDB(output string) map[int64][]interface{} {
+	entries := make(map[int64][]interface{})
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		port, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			// header or LOCAL port
+			continue
+		}
+
+		vlan, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		mac, err := net.ParseMAC(fields[2])
+		if err != nil {
+			continue
+		}
+
+		entry := map[string]interface{}{"MAC": mac.String()}
+		if vlan != 0 {
+			entry["Vlan"] = vlan
+		}
+		entries[port] = append(entries[port], entry)
+	}
+	return entries
+}
+
+// bridgeInterfaces returns the interfaces of a bridge by OpenFlow port
+func (o *OvsFDBProbe) bridgeInterfaces(bridge *graph.Node) map[int64]*graph.Node {
+	interfaces := make(map[int64]*graph.Node)
+	for _, port := range o.Graph.LookupChildren(bridge, graph.Metadata{"Type": "ovsport"}, topology.OwnershipMetadata) {
+		for _, intf := range o.Graph.LookupChildren(port, nil, topology.OwnershipMetadata) {
+			if ofport, err := intf.GetFieldInt64("OfPort"); err == nil {
+				interfaces[ofport] = intf
+			}
+		}
+	}
+	return interfaces
+}
+
+func (o *OvsFDBProbe) update(bridge *graph.Node, name string) {
+	output, err := launchOnSwitch([]string{"ovs-appctl", "fdb/show", name})
+	if err != nil {
+		logging.GetLogger().Debugf("Cannot launch ovs-appctl fdb/show on %s: %s", name, err)
+		return
+	}
+	entries := parseFDB(output)
+
+	o.Graph.Lock()
+	defer o.Graph.Unlock()
+
+	if o.Graph.GetNode(bridge.ID) == nil {
+		return
+	}
+
+	for ofport, intf := range o.bridgeInterfaces(bridge) {
+		current, err := intf.GetField("FDB")
+		if fdb, found := entries[ofport]; found {
+			if !reflect.DeepEqual(current, fdb) {
+				o.Graph.AddMetadata(intf, "FDB", fdb)
+			}
+		} else if err == nil {
+			o.Graph.DelMetadata(intf, "FDB")
+		}
+	}
+}
+
+func (o *OvsFDBProbe) run(ctx context.Context, bridge *graph.Node, name string) {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			o.update(bridge, name)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// OnOvsBridgeAdd is called when a bridge is added
+func (o *OvsFDBProbe) OnOvsBridgeAdd(bridgeNode *graph.Node) {
+	o.Lock()
+	defer o.Unlock()
+
+	name, _ := bridgeNode.GetFieldString("Name")
+	uuid, _ := bridgeNode.GetFieldString("UUID")
+	if _, ok := o.bridges[uuid]; ok || name == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o.bridges[uuid] = cancel
+
+	go o.run(ctx, bridgeNode, name)
+}
+
+// OnOvsBridgeDel is called when a bridge is deleted
+func (o *OvsFDBProbe) OnOvsBridgeDel(uuid string) {
+	o.Lock()
+	defer o.Unlock()
+
+	if cancel, ok := o.bridges[uuid]; ok {
+		cancel()
+		delete(o.bridges, uuid)
+	}
+}
+
+// NewOvsFDBProbe creates a new FDB probe, nil if disabled
+func NewOvsFDBProbe(g *graph.Graph) *OvsFDBProbe {
+	if !config.GetBool("ovs.fdb.enable") {
+		return nil
+	}
+
+	return &OvsFDBProbe{
+		Graph:    g,
+		interval: time.Duration(config.GetInt("ovs.fdb.interval")) * time.Second,
+		bridges:  make(map[string]context.CancelFunc),
+	}
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package ovsdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFDB(t *testing.T) {
+	output := ` port  VLAN  MAC                Age
+    1     0  52:54:00:12:34:56    3
+    2    10  52:54:00:ab:cd:ef   12
+    2    10  52:54:00:AB:CD:00    1
+LOCAL     0  9a:0f:30:41:5c:4b    0
+`
+	expected := map[int64][]interface{}{
+		1: {
+			map[string]interface{}{"MAC": "52:54:00:12:34:56"},
+		},
+		2: {
+			map[string]interface{}{"MAC": "52:54:00:ab:cd:ef", "Vlan": int64(10)},
+			map[string]interface{}{"MAC": "52:54:00:ab:cd:00", "Vlan": int64(10)},
+		},
+	}
+
+	if entries := parseFDB(output); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected FDB entries %+v, got %+v", expected, entries)
+	}
+}
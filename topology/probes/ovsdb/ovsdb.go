@@ -34,6 +34,7 @@ import (
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/ovs"
+	"github.com/skydive-project/skydive/probe"
 	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/topology/graph"
 )
@@ -49,6 +50,7 @@ type OvsdbProbe struct {
 	Root         *graph.Node
 	OvsMon       *ovsdb.OvsMonitor
 	OvsOfProbe   *OvsOfProbe
+	OvsFDBProbe  *OvsFDBProbe
 	uuidToIntf   map[string]*graph.Node
 	uuidToPort   map[string]*graph.Node
 	intfToPort   map[string]*graph.Node
@@ -129,6 +131,9 @@ func (o *OvsdbProbe) OnOvsBridgeAdd(monitor *ovsdb.OvsMonitor, uuid string, row
 	if o.OvsOfProbe != nil {
 		o.OvsOfProbe.OnOvsBridgeAdd(bridge)
 	}
+	if o.OvsFDBProbe != nil {
+		o.OvsFDBProbe.OnOvsBridgeAdd(bridge)
+	}
 }
 
 // OnOvsBridgeDel event
@@ -140,6 +145,9 @@ func (o *OvsdbProbe) OnOvsBridgeDel(monitor *ovsdb.OvsMonitor, uuid string, row
 	if o.OvsOfProbe != nil {
 		o.OvsOfProbe.OnOvsBridgeDel(uuid, bridge)
 	}
+	if o.OvsFDBProbe != nil {
+		o.OvsFDBProbe.OnOvsBridgeDel(uuid)
+	}
 	if bridge != nil {
 		o.Graph.DelNode(bridge)
 	}
@@ -513,6 +521,16 @@ func (o *OvsdbProbe) OnOvsPortDel(monitor *ovsdb.OvsMonitor, uuid string, row *l
 	delete(o.portToIntf, uuid)
 }
 
+// OnOvsConnected event
+func (o *OvsdbProbe) OnOvsConnected(monitor *ovsdb.OvsMonitor) {
+	probe.ReportRecovery("ovsdb")
+}
+
+// OnOvsDisconnected event
+func (o *OvsdbProbe) OnOvsDisconnected(monitor *ovsdb.OvsMonitor, err error) {
+	probe.ReportError("ovsdb", fmt.Errorf("Unable to reach OVSDB at %s: %s", monitor.Target, err.Error()))
+}
+
 // Start the probe
 func (o *OvsdbProbe) Start() {
 	o.OvsMon.StartMonitoring()
@@ -539,8 +557,10 @@ func NewOvsdbProbe(g *graph.Graph, n *graph.Node, p string, t string) *OvsdbProb
 		portToBridge: make(map[string]*graph.Node),
 		OvsMon:       mon,
 		OvsOfProbe:   NewOvsOfProbe(g, n, mon.Target),
+		OvsFDBProbe:  NewOvsFDBProbe(g),
 	}
 	o.OvsMon.AddMonitorHandler(o)
+	o.OvsMon.AddConnectionHandler(o)
 
 	return o
 }
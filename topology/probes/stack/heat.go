@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package stack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+)
+
+// heatNestedDepth is the depth of the nested stacks whose resources are
+// attributed to their top level stack
+const heatNestedDepth = 5
+
+// heatClient lists the resources of the Heat stacks of all the tenants
+type heatClient struct {
+	opts         gophercloud.AuthOptions
+	regionName   string
+	availability gophercloud.Availability
+	client       *gophercloud.ServiceClient
+}
+
+func (h *heatClient) connect() error {
+	if h.client != nil {
+		return nil
+	}
+
+	provider, err := openstack.AuthenticatedClient(h.opts)
+	if err != nil {
+		return fmt.Errorf("keystone authentication error: %s", err)
+	}
+
+	client, err := openstack.NewOrchestrationV1(provider, gophercloud.EndpointOpts{
+		Region:       h.regionName,
+		Availability: h.availability,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to find the Heat endpoint: %s", err)
+	}
+	h.client = client
+
+	return nil
+}
+
+func (h *heatClient) resources() ([]*resource, error) {
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+
+	var stacks struct {
+		Stacks []struct {
+			ID   string `json:"id"`
+			Name string `json:"stack_name"`
+		} `json:"stacks"`
+	}
+	if _, err := h.client.Get(h.client.ServiceURL("stacks")+"?global_tenant=True", &stacks, nil); err != nil {
+		return nil, fmt.Errorf("Unable to list the Heat stacks: %s", err)
+	}
+
+	var resources []*resource
+	for _, stack := range stacks.Stacks {
+		var result struct {
+			Resources []struct {
+				PhysicalID string `json:"physical_resource_id"`
+				Name       string `json:"resource_name"`
+				Type       string `json:"resource_type"`
+			} `json:"resources"`
+		}
+
+		url := h.client.ServiceURL("stacks", stack.Name, stack.ID, "resources") + fmt.Sprintf("?nested_depth=%d", heatNestedDepth)
+		if _, err := h.client.Get(url, &result, nil); err != nil {
+			return nil, fmt.Errorf("Unable to list the resources of the Heat stack %s: %s", stack.Name, err)
+		}
+
+		for _, r := range result.Resources {
+			if r.PhysicalID == "" {
+				continue
+			}
+
+			resources = append(resources, &resource{
+				ID:      r.PhysicalID,
+				Stack:   stack.Name,
+				StackID: stack.ID,
+				Source:  "heat",
+				Name:    r.Name,
+				Type:    r.Type,
+			})
+		}
+	}
+
+	return resources, nil
+}
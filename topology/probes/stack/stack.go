@@ -0,0 +1,294 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	managerValue = "stack"
+	// MetadataKey is the metadata holding the stack of a node
+	MetadataKey = "Stack"
+)
+
+// DefaultIDFields are the metadata holding the IDs of the resources
+// represented by the nodes, the most specific first
+var DefaultIDFields = []string{
+	"Neutron.PortID",
+	"ExtID.iface-id",
+	"Nova.ID",
+	"Ironic.UUID",
+	"ExtID.vm-uuid",
+	"Neutron.NetworkID",
+}
+
+// resource describes a resource of an infrastructure-as-code stack
+type resource struct {
+	ID      string
+	Stack   string
+	StackID string
+	Source  string
+	Name    string
+	Type    string
+}
+
+// StackProbe maps the resources of the Heat stacks and of the Terraform
+// states onto the nodes holding their IDs, with the Stack metadata, so
+// that the elements of a stack can be selected with a Has('Stack.Name', ...)
+// step
+type StackProbe struct {
+	common.RWMutex
+	graph.DefaultGraphListener
+	graph     *graph.Graph
+	heat      *heatClient
+	states    map[string]string
+	fields    []string
+	interval  time.Duration
+	resources map[string]*resource
+	quit      chan bool
+	wg        sync.WaitGroup
+}
+
+func resourceMetadata(r *resource) map[string]interface{} {
+	m := map[string]interface{}{
+		"Name":     r.Stack,
+		"Source":   r.Source,
+		"Resource": r.Name,
+	}
+	if r.StackID != "" {
+		m["ID"] = r.StackID
+	}
+	if r.Type != "" {
+		m["ResourceType"] = r.Type
+	}
+	return m
+}
+
+// lookup returns the resource of a node, from the first of its ID fields
+// known by a stack
+func (p *StackProbe) lookup(n *graph.Node) *resource {
+	p.RLock()
+	defer p.RUnlock()
+
+	for _, field := range p.fields {
+		if id, _ := n.GetFieldString(field); id != "" {
+			if r, found := p.resources[id]; found {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// enrich sets or removes the stack metadata of a node
+func (p *StackProbe) enrich(n *graph.Node) {
+	current, err := n.GetField(MetadataKey)
+
+	r := p.lookup(n)
+	if r == nil {
+		if err == nil {
+			p.graph.DelMetadata(n, MetadataKey)
+		}
+		return
+	}
+
+	if m := resourceMetadata(r); !reflect.DeepEqual(current, m) {
+		p.graph.AddMetadata(n, MetadataKey, m)
+	}
+}
+
+// OnNodeAdded event
+func (p *StackProbe) OnNodeAdded(n *graph.Node) {
+	p.enrich(n)
+}
+
+// OnNodeUpdated event
+func (p *StackProbe) OnNodeUpdated(n *graph.Node) {
+	p.enrich(n)
+}
+
+func (p *StackProbe) load() (map[string]*resource, error) {
+	var all []*resource
+
+	if p.heat != nil {
+		r, err := p.heat.resources()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, r...)
+	}
+
+	for stack, path := range p.states {
+		r, err := readTerraformState(stack, path)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, r...)
+	}
+
+	resources := make(map[string]*resource, len(all))
+	for _, r := range all {
+		resources[r.ID] = r
+	}
+	return resources, nil
+}
+
+// apply replaces the known resources and updates the stack metadata of the
+// nodes accordingly
+func (p *StackProbe) apply(resources map[string]*resource) {
+	p.Lock()
+	p.resources = resources
+	p.Unlock()
+
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	for _, n := range p.graph.GetNodes(nil) {
+		p.enrich(n)
+	}
+}
+
+func (p *StackProbe) poll() error {
+	resources, err := p.load()
+	if err != nil {
+		return err
+	}
+
+	p.apply(resources)
+	return nil
+}
+
+func (p *StackProbe) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.poll(); err != nil {
+			logging.GetLogger().Errorf("Failed to load the stacks: %s", err)
+			probe.ReportError(managerValue, err)
+		} else {
+			probe.ReportRecovery(managerValue)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Start the probe
+func (p *StackProbe) Start() {
+	p.graph.AddEventListener(p)
+
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop the probe
+func (p *StackProbe) Stop() {
+	p.graph.RemoveEventListener(p)
+
+	p.quit <- true
+	p.wg.Wait()
+}
+
+// NewStackProbe creates a new stack probe mapping the resources of the
+// Terraform states, given by stack name, onto the nodes having one of the
+// ID fields
+func NewStackProbe(g *graph.Graph, states map[string]string, fields []string, interval time.Duration) *StackProbe {
+	return &StackProbe{
+		graph:     g,
+		states:    states,
+		fields:    fields,
+		interval:  interval,
+		resources: make(map[string]*resource),
+		quit:      make(chan bool),
+	}
+}
+
+// NewStackProbeFromConfig creates a new stack probe based on configuration
+func NewStackProbeFromConfig(g *graph.Graph) (*StackProbe, error) {
+	interval := time.Duration(config.GetInt("analyzer.topology.stack.interval")) * time.Second
+	if interval <= 0 {
+		return nil, errors.New("analyzer.topology.stack.interval must be a positive number of seconds")
+	}
+
+	fields := config.GetStringSlice("analyzer.topology.stack.id_fields")
+	if len(fields) == 0 {
+		fields = DefaultIDFields
+	}
+
+	var heat *heatClient
+	if config.GetBool("analyzer.topology.stack.heat.enabled") {
+		endpointType := config.GetString("analyzer.topology.stack.heat.endpoint_type")
+		endpointTypes := map[string]gophercloud.Availability{
+			"public":   gophercloud.AvailabilityPublic,
+			"admin":    gophercloud.AvailabilityAdmin,
+			"internal": gophercloud.AvailabilityInternal,
+		}
+
+		availability, ok := endpointTypes[endpointType]
+		if !ok {
+			return nil, fmt.Errorf("Endpoint type '%s' is not valid (must be 'public', 'admin' or 'internal')", endpointType)
+		}
+
+		heat = &heatClient{
+			opts: gophercloud.AuthOptions{
+				IdentityEndpoint: config.GetString("analyzer.topology.stack.heat.auth_url"),
+				Username:         config.GetString("analyzer.topology.stack.heat.username"),
+				Password:         config.GetString("analyzer.topology.stack.heat.password"),
+				TenantName:       config.GetString("analyzer.topology.stack.heat.tenant_name"),
+				DomainName:       config.GetString("analyzer.topology.stack.heat.domain_name"),
+				AllowReauth:      true,
+			},
+			regionName:   config.GetString("analyzer.topology.stack.heat.region_name"),
+			availability: availability,
+		}
+	}
+
+	states := config.GetStringMapString("analyzer.topology.stack.terraform.states")
+	if heat == nil && len(states) == 0 {
+		return nil, errors.New("Neither Heat nor Terraform states are configured for the stack probe")
+	}
+
+	p := NewStackProbe(g, states, fields, interval)
+	p.heat = heat
+
+	return p, nil
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package stack
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestParseTerraformState(t *testing.T) {
+	v4 := `{
+		"version": 4,
+		"resources": [
+			{"mode": "managed", "type": "openstack_compute_instance_v2", "name": "web", "instances": [
+				{"index_key": 0, "attributes": {"id": "vm1"}},
+				{"index_key": 1, "attributes": {"id": "vm2"}}
+			]},
+			{"mode": "managed", "module": "module.net", "type": "openstack_networking_port_v2", "name": "port", "instances": [
+				{"attributes": {"id": "port1"}}
+			]},
+			{"mode": "data", "type": "openstack_images_image_v2", "name": "image", "instances": [
+				{"attributes": {"id": "image1"}}
+			]}
+		]
+	}`
+
+	resources, err := parseTerraformState("app", []byte(v4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]string)
+	for _, r := range resources {
+		names[r.ID] = r.Name
+	}
+
+	expected := map[string]string{
+		"vm1":   "openstack_compute_instance_v2.web[0]",
+		"vm2":   "openstack_compute_instance_v2.web[1]",
+		"port1": "module.net.openstack_networking_port_v2.port",
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for id, name := range expected {
+		if names[id] != name {
+			t.Errorf("Expected resource %s for %s, got %v", name, id, names)
+		}
+	}
+
+	v3 := `{
+		"version": 3,
+		"modules": [
+			{"path": ["root", "net"], "resources": {
+				"openstack_networking_network_v2.net": {"type": "openstack_networking_network_v2", "primary": {"id": "net1"}},
+				"data.openstack_images_image_v2.image": {"type": "openstack_images_image_v2", "primary": {"id": "image1"}}
+			}}
+		]
+	}`
+
+	if resources, err = parseTerraformState("app", []byte(v3)); err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 || resources[0].ID != "net1" || resources[0].Name != "module.net.openstack_networking_network_v2.net" {
+		t.Errorf("Wrong resources for a version 3 state: %+v", resources)
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b)
+
+	p := NewStackProbe(g, nil, DefaultIDFields, 0)
+	g.AddEventListener(p)
+
+	tap := g.NewNode(graph.GenID(), graph.Metadata{
+		"Neutron": map[string]interface{}{"PortID": "port1", "NetworkID": "net1"},
+	})
+
+	p.apply(map[string]*resource{
+		"port1": {ID: "port1", Stack: "app", Source: "terraform", Name: "port"},
+		"net1":  {ID: "net1", Stack: "infra", Source: "terraform", Name: "net"},
+	})
+
+	if name, _ := tap.GetFieldString("Stack.Name"); name != "app" {
+		t.Errorf("The most specific resource should be used, got %v", tap.Metadata())
+	}
+
+	net := g.NewNode(graph.GenID(), graph.Metadata{
+		"Neutron": map[string]interface{}{"NetworkID": "net1"},
+	})
+	if name, _ := net.GetFieldString("Stack.Name"); name != "infra" {
+		t.Errorf("New nodes should be enriched, got %v", net.Metadata())
+	}
+
+	p.apply(map[string]*resource{})
+
+	if _, err := tap.GetField("Stack"); err == nil {
+		t.Errorf("Stack metadata should be removed, got %v", tap.Metadata())
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// terraformState holds the fields of the Terraform states needed to map the
+// resources, the version 3 states listing the resources by module, the
+// version 4 ones listing the instances of each resource
+type terraformState struct {
+	Version int `json:"version"`
+	Modules []struct {
+		Path      []string `json:"path"`
+		Resources map[string]struct {
+			Type    string `json:"type"`
+			Primary struct {
+				ID string `json:"id"`
+			} `json:"primary"`
+		} `json:"resources"`
+	} `json:"modules"`
+	Resources []struct {
+		Mode      string `json:"mode"`
+		Module    string `json:"module"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey   interface{} `json:"index_key"`
+			Attributes struct {
+				ID string `json:"id"`
+			} `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// parseTerraformState returns the resources of the stack described by a
+// Terraform state
+func parseTerraformState(stack string, data []byte) ([]*resource, error) {
+	var state terraformState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	var resources []*resource
+	switch {
+	case state.Version >= 4:
+		for _, r := range state.Resources {
+			if r.Mode == "data" {
+				continue
+			}
+
+			name := r.Type + "." + r.Name
+			if r.Module != "" {
+				name = r.Module + "." + name
+			}
+
+			for _, instance := range r.Instances {
+				if instance.Attributes.ID == "" {
+					continue
+				}
+
+				instanceName := name
+				switch key := instance.IndexKey.(type) {
+				case string:
+					instanceName = fmt.Sprintf("%s[%q]", name, key)
+				case float64:
+					instanceName = fmt.Sprintf("%s[%d]", name, int64(key))
+				}
+
+				resources = append(resources, &resource{
+					ID:     instance.Attributes.ID,
+					Stack:  stack,
+					Source: "terraform",
+					Name:   instanceName,
+					Type:   r.Type,
+				})
+			}
+		}
+	case state.Version == 3:
+		for _, module := range state.Modules {
+			var prefix string
+			for _, p := range module.Path {
+				if p != "root" {
+					prefix += "module." + p + "."
+				}
+			}
+
+			for name, r := range module.Resources {
+				if r.Primary.ID == "" || strings.HasPrefix(name, "data.") {
+					continue
+				}
+
+				resources = append(resources, &resource{
+					ID:     r.Primary.ID,
+					Stack:  stack,
+					Source: "terraform",
+					Name:   prefix + name,
+					Type:   r.Type,
+				})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported Terraform state version %d", state.Version)
+	}
+
+	return resources, nil
+}
+
+func readTerraformState(stack, path string) ([]*resource, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := parseTerraformState(stack, data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse Terraform state %s: %s", path, err)
+	}
+	return resources, nil
+}
@@ -69,6 +69,15 @@ var (
 	LayerKeyModeNotValid = func() error {
 		return valid.TextErr{Err: errors.New("Not a valid layer key mode")}
 	}
+
+	//FlowKeyNotValid validator
+	FlowKeyNotValid = func() error {
+		return valid.TextErr{Err: errors.New("Not a valid flow key, either 5-tuple, 3-tuple, ip-pair, l2-pair or a list of Link, Network, Protocol, Ports and Application")}
+	}
+	//FlowDirectionNotValid validator
+	FlowDirectionNotValid = func() error {
+		return valid.TextErr{Err: errors.New("Not a valid flow direction mode, either first-packet or auto")}
+	}
 )
 
 func isIP(v interface{}, param string) error {
@@ -93,6 +102,9 @@ func isGremlinExpr(v interface{}, param string) error {
 	tr.AddTraversalExtension(ge.NewMetricsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewFlowTraversalExtension(nil, nil))
 	tr.AddTraversalExtension(ge.NewSocketsTraversalExtension())
+	tr.AddTraversalExtension(ge.NewSummarizeTraversalExtension())
+	tr.AddTraversalExtension(ge.NewUtilizationTraversalExtension())
+	tr.AddTraversalExtension(ge.NewTopNTraversalExtension())
 
 	if _, err := tr.Parse(strings.NewReader(query)); err != nil {
 		return GremlinNotValid(err)
@@ -152,6 +164,34 @@ func isValidLayerKeyMode(v interface{}, param string) error {
 	return nil
 }
 
+func isValidFlowKey(v interface{}, param string) error {
+	name, ok := v.(string)
+	if !ok {
+		return FlowKeyNotValid()
+	}
+
+	if _, err := flow.KeyFieldsByName(name); err != nil {
+		return FlowKeyNotValid()
+	}
+	return nil
+}
+
+func isValidFlowDirection(v interface{}, param string) error {
+	name, ok := v.(string)
+	if !ok {
+		return FlowDirectionNotValid()
+	}
+
+	if len(name) == 0 {
+		return nil
+	}
+
+	if _, err := flow.DirectionModeByName(name); err != nil {
+		return FlowDirectionNotValid()
+	}
+	return nil
+}
+
 // Validate an object based on previously (at init) registered function
 func Validate(value interface{}) error {
 	if err := skydiveValidator.Validate(value); err != nil {
@@ -165,6 +205,12 @@ func Validate(value interface{}) error {
 	return nil
 }
 
+// ValidateGremlinQuery verifies a Gremlin query, the analyzer extensions
+// being allowed
+func ValidateGremlinQuery(query string) error {
+	return isGremlinExpr(query, "")
+}
+
 func init() {
 	skydiveValidator.SetValidationFunc("isIP", isIP)
 	skydiveValidator.SetValidationFunc("isGremlinExpr", isGremlinExpr)
@@ -172,5 +218,7 @@ func init() {
 	skydiveValidator.SetValidationFunc("isValidCaptureHeaderSize", isValidCaptureHeaderSize)
 	skydiveValidator.SetValidationFunc("isValidRawPacketLimit", isValidRawPacketLimit)
 	skydiveValidator.SetValidationFunc("isValidLayerKeyMode", isValidLayerKeyMode)
+	skydiveValidator.SetValidationFunc("isValidFlowKey", isValidFlowKey)
+	skydiveValidator.SetValidationFunc("isValidFlowDirection", isValidFlowDirection)
 	skydiveValidator.SetTag("valid")
 }